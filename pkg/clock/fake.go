@@ -0,0 +1,152 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when it is advanced. It is safe for
+// concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// NewFake returns a new fake clock set to the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// fakeWaiter is a ticker or timer waiting on a fake clock.
+type fakeWaiter struct {
+	clock  *Fake
+	at     time.Time
+	period time.Duration
+	fn     func()
+	c      chan time.Time
+}
+
+// Now returns the current time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the time elapsed since t according to the clock.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTicker returns a Ticker that ticks each time the clock is advanced past
+// a multiple of d. Like a real ticker, ticks are dropped when the receiver
+// falls behind.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{clock: f, at: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return fakeTicker{w}
+}
+
+// AfterFunc calls f once the clock has been advanced by d. Unlike a real
+// timer, f is called synchronously by Advance.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{clock: f, at: f.now.Add(d), fn: fn}
+	f.waiters = append(f.waiters, w)
+	return fakeTimer{w}
+}
+
+// Set moves the clock to the given time, firing any tickers and timers that
+// become due along the way. Setting a time in the past only moves the clock.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	for {
+		var next *fakeWaiter
+		for _, w := range f.waiters {
+			if !w.at.After(t) && (next == nil || w.at.Before(next.at)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.at.After(f.now) {
+			f.now = next.at
+		}
+		if next.period > 0 {
+			select {
+			case next.c <- f.now:
+			default:
+			}
+			next.at = next.at.Add(next.period)
+			continue
+		}
+		f.remove(next)
+		f.mu.Unlock()
+		next.fn()
+		f.mu.Lock()
+	}
+	f.now = t
+	f.mu.Unlock()
+}
+
+// Advance moves the clock forward by d, firing any tickers and timers that
+// become due along the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// remove removes the given waiter and reports whether it was pending. The
+// caller must hold f.mu.
+func (f *Fake) remove(w *fakeWaiter) bool {
+	for i, waiter := range f.waiters {
+		if waiter == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fakeWaiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t fakeTicker) Stop() { t.stop() }
+
+type fakeTimer struct {
+	*fakeWaiter
+}
+
+func (t fakeTimer) Stop() bool { return t.stop() }
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Now", func(t *testing.T) {
+		t.Parallel()
+		clk := NewFake(start)
+		clk.Advance(time.Minute)
+		if got := clk.Now(); !got.Equal(start.Add(time.Minute)) {
+			t.Errorf("expected %s, got %s", start.Add(time.Minute), got)
+		}
+		if got := clk.Since(start); got != time.Minute {
+			t.Errorf("expected a minute since start, got %s", got)
+		}
+	})
+
+	t.Run("AfterFunc", func(t *testing.T) {
+		t.Parallel()
+		clk := NewFake(start)
+		var fired []time.Time
+		clk.AfterFunc(time.Second, func() { fired = append(fired, clk.Now()) })
+		stopped := clk.AfterFunc(time.Second, func() { t.Error("stopped timer fired") })
+		if !stopped.Stop() {
+			t.Error("expected pending timer to be stopped")
+		}
+		clk.Advance(500 * time.Millisecond)
+		if len(fired) != 0 {
+			t.Fatalf("expected timer not to fire early, fired at %v", fired)
+		}
+		clk.Advance(time.Minute)
+		if len(fired) != 1 || !fired[0].Equal(start.Add(time.Second)) {
+			t.Fatalf("expected timer to fire once at its deadline, fired at %v", fired)
+		}
+		if stopped.Stop() {
+			t.Error("expected stopping a stopped timer to return false")
+		}
+	})
+
+	t.Run("Ticker", func(t *testing.T) {
+		t.Parallel()
+		clk := NewFake(start)
+		ticker := clk.NewTicker(time.Second)
+		select {
+		case <-ticker.C():
+			t.Fatal("unexpected tick before advancing")
+		default:
+		}
+		clk.Advance(time.Second)
+		select {
+		case tick := <-ticker.C():
+			if !tick.Equal(start.Add(time.Second)) {
+				t.Errorf("expected tick at %s, got %s", start.Add(time.Second), tick)
+			}
+		default:
+			t.Fatal("expected a tick")
+		}
+		// Missed ticks are dropped.
+		clk.Advance(5 * time.Second)
+		<-ticker.C()
+		select {
+		case tick := <-ticker.C():
+			t.Fatalf("unexpected extra tick at %s", tick)
+		default:
+		}
+		ticker.Stop()
+		clk.Advance(time.Minute)
+		select {
+		case tick := <-ticker.C():
+			t.Fatalf("unexpected tick after stop at %s", tick)
+		default:
+		}
+	})
+}
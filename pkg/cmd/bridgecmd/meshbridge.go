@@ -37,6 +37,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/dns"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/services"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
@@ -77,10 +78,21 @@ func RunBridgeConnection(ctx context.Context, conf config.BridgeOptions) error {
 	}
 
 	// Start all the mesh connections.
+	muxes := make(map[string]*tcp.Mux)
 	for meshID, meshConn := range meshes {
 		// Create a new raft node and build connection options
 		meshConfig := conf.Meshes[meshID]
-		storageProvider, err := meshConfig.NewStorageProvider(ctx, meshConn, meshConfig.Bootstrap.Force)
+		mux, err := meshConfig.NewMux()
+		if err != nil {
+			return handleErr(fmt.Errorf("failed to create connection muxer: %w", err))
+		}
+		if mux != nil {
+			muxes[meshID] = mux
+			cleanFuncs = append(cleanFuncs, func() {
+				_ = mux.Close()
+			})
+		}
+		storageProvider, err := meshConfig.NewStorageProvider(ctx, meshConn, mux, meshConfig.Bootstrap.Force)
 		if err != nil {
 			return handleErr(fmt.Errorf("failed to create storage provider: %w", err))
 		}
@@ -118,7 +130,7 @@ func RunBridgeConnection(ctx context.Context, conf config.BridgeOptions) error {
 	for meshID, meshConn := range meshes {
 		id := meshID
 		meshConfig := conf.Meshes[id]
-		srvOpts, err := meshConfig.Services.NewServiceOptions(ctx, meshConn)
+		srvOpts, err := meshConfig.Services.NewServiceOptions(ctx, meshConn, muxes[id])
 		if err != nil {
 			return handleErr(fmt.Errorf("failed to create service options: %w", err))
 		}
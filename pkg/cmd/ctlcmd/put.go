@@ -17,10 +17,14 @@ limitations under the License.
 package ctlcmd
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
 
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/services/admin"
 )
 
 var (
@@ -47,6 +51,7 @@ var (
 	putRouteNode    string
 	putRouteCIDRs   []string
 	putRouteNextHop string
+	putRouteSig     string
 
 	putEdgeFrom   string
 	putEdgeTo     string
@@ -96,6 +101,7 @@ func init() {
 	putRouteFlags.StringVar(&putRouteNode, "node", "", "node to add the route to")
 	putRouteFlags.StringArrayVar(&putRouteCIDRs, "cidr", nil, "CIDRs to add to the route")
 	putRouteFlags.StringVar(&putRouteNextHop, "next-hop", "", "next hop to add to the route")
+	putRouteFlags.StringVar(&putRouteSig, "signature", "", "base64 encoded signature of the route by its node, as produced by types.Route.Sign")
 	cobra.CheckErr(putRouteCmd.MarkFlagRequired("node"))
 	cobra.CheckErr(putRouteCmd.MarkFlagRequired("cidr"))
 	cobra.CheckErr(putRouteCmd.RegisterFlagCompletionFunc("node", completeNodes(1)))
@@ -370,7 +376,15 @@ var putRouteCmd = &cobra.Command{
 			return err
 		}
 		defer closer.Close()
-		_, err = client.PutRoute(cmd.Context(), route)
+		ctx := cmd.Context()
+		if putRouteSig != "" {
+			sig, err := base64.StdEncoding.DecodeString(putRouteSig)
+			if err != nil {
+				return fmt.Errorf("decode signature: %w", err)
+			}
+			ctx = admin.AppendRouteSignatureToOutgoingContext(ctx, sig)
+		}
+		_, err = client.PutRoute(ctx, route)
 		if err != nil {
 			return err
 		}
@@ -65,10 +65,12 @@ func Run(ctx context.Context, conf Config) error {
 	defer srv.Close()
 	unarymiddlewares := []grpc.UnaryServerInterceptor{
 		context.LogInjectUnaryServerInterceptor(log.With("appdaemon", "grpc")),
+		context.RequestIDUnaryServerInterceptor(),
 		logging.ContextUnaryServerInterceptor(),
 	}
 	streammiddlewares := []grpc.StreamServerInterceptor{
 		context.LogInjectStreamServerInterceptor(log.With("appdaemon", "grpc")),
+		context.RequestIDStreamServerInterceptor(),
 		logging.ContextStreamServerInterceptor(),
 	}
 	grpcServer := grpc.NewServer(
@@ -48,8 +48,14 @@ type BootstrapOptions struct {
 	Transport BootstrapTransportOptions `koanf:"transport,omitempty"`
 	// IPv4Network is the IPv4 network of the mesh to write to the database when bootstraping a new cluster.
 	IPv4Network string `koanf:"ipv4-network,omitempty"`
+	// IPv4NodePrefixLength is the prefix length of the IPv4 subnet allocated to each node. Defaults to
+	// a /32 per node.
+	IPv4NodePrefixLength int `koanf:"ipv4-node-prefix-length,omitempty"`
+	// ExpectedNodes is the number of nodes the IPv4 network must have room for when bootstrapping a new
+	// cluster. Defaults to the number of bootstrap servers.
+	ExpectedNodes int `koanf:"expected-nodes,omitempty"`
 	// IPv6Network is the IPv6 network of the mesh to write to the database when bootstraping a new cluster.
-	// If left unset, one will be generated. This must be a /32 prefix.
+	// If left unset, one will be generated. This must be a /48 unique local address prefix within fd00::/8.
 	IPv6Network string `koanf:"ipv6-network,omitempty"`
 	// MeshDomain is the domain of the mesh to write to the database when bootstraping a new cluster.
 	MeshDomain string `koanf:"mesh-domain,omitempty"`
@@ -94,6 +100,8 @@ func NewBootstrapOptions() BootstrapOptions {
 		ElectionTimeout:      time.Second * 3,
 		Transport:            NewBootstrapTransportOptions(),
 		IPv4Network:          storage.DefaultIPv4Network,
+		IPv4NodePrefixLength: storage.DefaultIPv4NodePrefixLength,
+		ExpectedNodes:        0,
 		IPv6Network:          "",
 		MeshDomain:           storage.DefaultMeshDomain,
 		Admin:                storage.DefaultMeshAdmin,
@@ -121,7 +129,9 @@ func (o *BootstrapOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.Enabled, prefix+"enabled", o.Enabled, "Attempt to bootstrap a new cluster")
 	fs.DurationVar(&o.ElectionTimeout, prefix+"election-timeout", o.ElectionTimeout, "Election timeout to use when bootstrapping a new cluster")
 	fs.StringVar(&o.IPv4Network, prefix+"ipv4-network", o.IPv4Network, "IPv4 network of the mesh to write to the database when bootstraping a new cluster")
-	fs.StringVar(&o.IPv6Network, prefix+"ipv6-network", o.IPv6Network, "IPv6 network of the mesh to write to the database when bootstraping a new cluster, if left unset one will be generated")
+	fs.IntVar(&o.IPv4NodePrefixLength, prefix+"ipv4-node-prefix-length", o.IPv4NodePrefixLength, "Prefix length of the IPv4 subnet allocated to each node")
+	fs.IntVar(&o.ExpectedNodes, prefix+"expected-nodes", o.ExpectedNodes, "Number of nodes the IPv4 network must have room for, defaults to the number of bootstrap servers")
+	fs.StringVar(&o.IPv6Network, prefix+"ipv6-network", o.IPv6Network, "IPv6 network of the mesh to write to the database when bootstraping a new cluster, must be a /48 within fd00::/8, if left unset one will be generated")
 	fs.StringVar(&o.MeshDomain, prefix+"mesh-domain", o.MeshDomain, "Domain of the mesh to write to the database when bootstraping a new cluster")
 	fs.StringVar(&o.Admin, prefix+"admin", o.Admin, "User and/or node name to assign administrator privileges to when bootstraping a new cluster")
 	fs.StringSliceVar(&o.Voters, prefix+"voters", o.Voters, "Comma separated list of node IDs to assign voting privileges to when bootstraping a new cluster")
@@ -154,13 +164,31 @@ func (o *BootstrapOptions) Validate() error {
 	} else if ip.To4() == nil {
 		return fmt.Errorf("ipv4 network must be a valid IPv4 CIDR")
 	}
+	if o.ExpectedNodes < 0 {
+		return fmt.Errorf("expected nodes must not be negative")
+	}
+	nodePrefixLength := o.IPv4NodePrefixLength
+	if nodePrefixLength == 0 {
+		nodePrefixLength = storage.DefaultIPv4NodePrefixLength
+	}
+	expectedNodes := o.ExpectedNodes
+	if expectedNodes == 0 {
+		expectedNodes = len(o.Transport.TCPServers)
+	}
+	network, err := netip.ParsePrefix(o.IPv4Network)
+	if err != nil {
+		return fmt.Errorf("ipv4 network must be a valid CIDR")
+	}
+	if err := storage.ValidateIPv4Network(network, nodePrefixLength, expectedNodes); err != nil {
+		return fmt.Errorf("ipv4 network is not valid for the node prefix length: %w", err)
+	}
 	if o.IPv6Network != "" {
 		prefix, err := netip.ParsePrefix(o.IPv6Network)
 		if err != nil {
 			return fmt.Errorf("ipv6 network must be a valid CIDR")
 		}
-		if prefix.Bits() != netutil.DefaultULABits {
-			return fmt.Errorf("ipv6 network must be a /%d prefix", netutil.DefaultULABits)
+		if err := netutil.ValidateULA(prefix); err != nil {
+			return fmt.Errorf("ipv6 network must be a unique local address prefix: %w", err)
 		}
 	}
 	if o.MeshDomain == "" {
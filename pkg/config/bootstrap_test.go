@@ -95,6 +95,60 @@ func TestValidateBootstrapOptions(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "ValidULANetwork",
+			opts: &BootstrapOptions{
+				Enabled:              true,
+				IPv4Network:          "172.16.0.0/12",
+				IPv6Network:          "fdaa:bbcc:ddee::/48",
+				MeshDomain:           "cluster.local",
+				Admin:                "admin",
+				DefaultNetworkPolicy: string(firewall.PolicyAccept),
+				Transport:            NewBootstrapTransportOptions(),
+			},
+			wantErr: false,
+		},
+		{
+			name: "NonULANetwork",
+			opts: &BootstrapOptions{
+				Enabled:              true,
+				IPv4Network:          "172.16.0.0/12",
+				IPv6Network:          "2001:db8::/48",
+				MeshDomain:           "cluster.local",
+				Admin:                "admin",
+				DefaultNetworkPolicy: string(firewall.PolicyAccept),
+				Transport:            NewBootstrapTransportOptions(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "CustomIPv4NodePrefixLength",
+			opts: &BootstrapOptions{
+				Enabled:              true,
+				IPv4Network:          "10.10.0.0/24",
+				IPv4NodePrefixLength: 30,
+				ExpectedNodes:        64,
+				MeshDomain:           "cluster.local",
+				Admin:                "admin",
+				DefaultNetworkPolicy: string(firewall.PolicyAccept),
+				Transport:            NewBootstrapTransportOptions(),
+			},
+			wantErr: false,
+		},
+		{
+			name: "IPv4NetworkTooSmall",
+			opts: &BootstrapOptions{
+				Enabled:              true,
+				IPv4Network:          "10.10.0.0/24",
+				IPv4NodePrefixLength: 30,
+				ExpectedNodes:        65,
+				MeshDomain:           "cluster.local",
+				Admin:                "admin",
+				DefaultNetworkPolicy: string(firewall.PolicyAccept),
+				Transport:            NewBootstrapTransportOptions(),
+			},
+			wantErr: true,
+		},
 		{
 			name: "NoMeshDomain",
 			opts: &BootstrapOptions{
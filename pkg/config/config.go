@@ -179,6 +179,9 @@ func (o *Config) Validate() error {
 	if err != nil {
 		return fmt.Errorf("invalid mesh options: %w", err)
 	}
+	if o.Mesh.Observer && o.Bootstrap.Enabled {
+		return fmt.Errorf("invalid mesh options: read-only observers cannot bootstrap the mesh")
+	}
 	err = o.Storage.Validate(o.IsStorageMember())
 	if err != nil {
 		return fmt.Errorf("invalid raft options: %w", err)
@@ -187,10 +190,23 @@ func (o *Config) Validate() error {
 	if err != nil {
 		return fmt.Errorf("invalid service options: %w", err)
 	}
+	if o.Storage.Raft.Multiplex && o.IsStorageMember() {
+		if o.Services.API.Disabled {
+			return fmt.Errorf("invalid raft options: multiplexing requires the gRPC API to be enabled")
+		}
+		if o.Storage.Raft.ListenAddress != o.Services.API.ListenAddress {
+			return fmt.Errorf("invalid raft options: listen address %q must match the gRPC listen address %q when multiplexing",
+				o.Storage.Raft.ListenAddress, o.Services.API.ListenAddress)
+		}
+	}
 	err = o.WireGuard.Validate()
 	if err != nil {
 		return fmt.Errorf("invalid wireguard options: %w", err)
 	}
+	err = o.Plugins.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid plugin options: %w", err)
+	}
 	err = o.Discovery.Validate()
 	if err != nil {
 		return fmt.Errorf("invalid discovery options: %w", err)
@@ -18,6 +18,7 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"time"
 
 	p2pcore "github.com/libp2p/go-libp2p"
@@ -42,8 +43,16 @@ type DiscoveryOptions struct {
 	// LocalAddrs is a list of local addresses to announce to the discovery service.
 	// If empty, the default local addresses will be used.
 	LocalAddrs []string `koanf:"local-addrs,omitempty"`
+	// ListenFilters is a list of CIDRs to restrict the addresses the discovery host listens on.
+	// Wildcard listen addresses are expanded to the matching interface addresses.
+	ListenFilters []string `koanf:"listen-filters,omitempty"`
+	// AnnounceFilters is a list of CIDRs to restrict the addresses advertised to the DHT and peers.
+	AnnounceFilters []string `koanf:"announce-filters,omitempty"`
 	// ConnectTimeout is the timeout for connecting to a peer.
 	ConnectTimeout time.Duration `koanf:"connect-timeout,omitempty"`
+	// FlowControlWindow is the receive window in bytes for credit-based flow control
+	// on RPC streams. Set to 0 to disable flow control on outbound streams.
+	FlowControlWindow int `koanf:"flow-control-window,omitempty"`
 }
 
 // NewDiscoveryOptions returns a new DiscoveryOptions for the given PSK.
@@ -62,16 +71,22 @@ func (o *DiscoveryOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.Discover, prefix+"discover", o.Discover, "use the libp2p kademlia DHT for discovery")
 	fs.StringSliceVar(&o.BootstrapServers, prefix+"bootstrap-servers", o.BootstrapServers, "list of bootstrap servers to use for the DHT")
 	fs.StringSliceVar(&o.LocalAddrs, prefix+"local-addrs", o.LocalAddrs, "list of local addresses to announce to the discovery service")
+	fs.StringSliceVar(&o.ListenFilters, prefix+"listen-filters", o.ListenFilters, "list of CIDRs to restrict the addresses the discovery host listens on")
+	fs.StringSliceVar(&o.AnnounceFilters, prefix+"announce-filters", o.AnnounceFilters, "list of CIDRs to restrict the addresses advertised to the DHT and peers")
 	fs.DurationVar(&o.ConnectTimeout, prefix+"connect-timeout", o.ConnectTimeout, "timeout for connecting to a peer")
+	fs.IntVar(&o.FlowControlWindow, prefix+"flow-control-window", o.FlowControlWindow, "receive window in bytes for flow control on RPC streams, 0 to disable")
 }
 
 // NewHostConfig returns a new HostOptions for the discovery config.
 func (o *DiscoveryOptions) HostOptions(ctx context.Context, key crypto.PrivateKey) libp2p.HostOptions {
 	return libp2p.HostOptions{
-		Options:        []config.Option{p2pcore.Identity(key.AsIdentity())},
-		BootstrapPeers: libp2p.ToMultiaddrs(o.BootstrapServers),
-		LocalAddrs:     libp2p.ToMultiaddrs(o.LocalAddrs),
-		ConnectTimeout: o.ConnectTimeout,
+		Options:           []config.Option{p2pcore.Identity(key.AsIdentity())},
+		BootstrapPeers:    libp2p.ToMultiaddrs(o.BootstrapServers),
+		LocalAddrs:        libp2p.ToMultiaddrs(o.LocalAddrs),
+		ListenFilters:     toPrefixes(o.ListenFilters),
+		AnnounceFilters:   toPrefixes(o.AnnounceFilters),
+		ConnectTimeout:    o.ConnectTimeout,
+		FlowControlWindow: o.FlowControlWindow,
 	}
 }
 
@@ -86,6 +101,9 @@ func (o *DiscoveryOptions) Validate() error {
 	if o.Rendezvous == "" {
 		return fmt.Errorf("rendezvous must be set when using the kademlia DHT")
 	}
+	if o.FlowControlWindow < 0 {
+		return fmt.Errorf("flow control window must be greater than or equal to zero")
+	}
 	if o.ConnectTimeout <= 0 {
 		return fmt.Errorf("connect timeout must be greater than zero")
 	}
@@ -106,5 +124,28 @@ func (o *DiscoveryOptions) Validate() error {
 			}
 		}
 	}
+	for _, cidr := range o.ListenFilters {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid listen filter: %w", err)
+		}
+	}
+	for _, cidr := range o.AnnounceFilters {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid announce filter: %w", err)
+		}
+	}
 	return nil
 }
+
+// toPrefixes parses the given CIDRs, skipping any that are invalid.
+func toPrefixes(cidrs []string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		out = append(out, prefix)
+	}
+	return out
+}
@@ -165,6 +165,10 @@ func (global *GlobalOptions) ApplyGlobals(ctx context.Context, o *Config) (*Conf
 	// Protocol preferences
 	o.Mesh.DisableIPv4 = global.DisableIPv4
 	o.Mesh.DisableIPv6 = global.DisableIPv6
+	// Select a wireguard port now so it can be used in advertised endpoints.
+	if err := o.WireGuard.ResolveListenPort(ctx); err != nil {
+		return nil, err
+	}
 	// Gather possible endpoints
 	var primaryEndpoint netip.Addr
 	var detectedEndpoints endpoints.PrefixList
@@ -38,9 +38,13 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/basicauth"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/idauth"
@@ -58,8 +62,11 @@ type MeshOptions struct {
 	// PrimaryEndpoint is the primary endpoint to advertise when joining.
 	// This can be empty to signal the node is not publicly reachable.
 	PrimaryEndpoint string `koanf:"primary-endpoint,omitempty"`
-	// ZoneAwarenessID is the zone awareness ID.
+	// ZoneAwarenessID is the zone awareness ID. Nodes sharing a zone are connected
+	// directly and prefer each other's LAN endpoints over their primary endpoints.
 	ZoneAwarenessID string `koanf:"zone-awareness-id,omitempty"`
+	// Labels are arbitrary key/value labels to attach to the node.
+	Labels map[string]string `koanf:"labels,omitempty"`
 	// JoinAddresses are addresses of nodes to attempt to join.
 	JoinAddresses []string `koanf:"join-addresses,omitempty"`
 	// JoinMultiaddrs are multiaddresses to attempt to join over libp2p.
@@ -67,9 +74,26 @@ type MeshOptions struct {
 	JoinMultiaddrs []string `koanf:"join-multiaddrs,omitempty"`
 	// MaxJoinRetries is the maximum number of join retries.
 	MaxJoinRetries int `koanf:"max-join-retries,omitempty"`
+	// JoinToken is a join token issued by an admin to present when joining.
+	JoinToken string `koanf:"join-token,omitempty"`
 	// Routes are additional routes to advertise to the mesh. These routes are advertised to all peers.
 	// If the node is not allowed to put routes in the mesh, the node will be unable to join.
 	Routes []string `koanf:"routes,omitempty"`
+	// AdvertiseHostRoutes advertises routes from the host routing table to the
+	// mesh along with Routes and keeps them in sync as the host routes change.
+	AdvertiseHostRoutes bool `koanf:"advertise-host-routes,omitempty"`
+	// HostRouteInterfaces limits advertised host routes to the given interfaces.
+	// Shell patterns are supported.
+	HostRouteInterfaces []string `koanf:"host-route-interfaces,omitempty"`
+	// HostRouteCIDRs limits advertised host routes to those within the given CIDRs.
+	HostRouteCIDRs []string `koanf:"host-route-cidrs,omitempty"`
+	// HostRouteSyncInterval is how often to re-read the host routing table.
+	HostRouteSyncInterval time.Duration `koanf:"host-route-sync-interval,omitempty"`
+	// ExitNode advertises this node as an exit node. Other nodes only route default
+	// traffic through it when they explicitly select it with UseExitNode.
+	ExitNode bool `koanf:"exit-node,omitempty"`
+	// UseExitNode is the ID of an exit node to route default traffic through.
+	UseExitNode string `koanf:"use-exit-node,omitempty"`
 	// ICEPeers are peers to request direct edges to over ICE. If the node is not allowed to create edges
 	// and data channels, the node will be unable to join.
 	ICEPeers []string `koanf:"ice-peers,omitempty"`
@@ -86,6 +110,10 @@ type MeshOptions struct {
 	RequestVote bool `koanf:"request-vote,omitempty"`
 	// RequestObserver is true if the node should be a storage observer.
 	RequestObserver bool `koanf:"request-observer,omitempty"`
+	// Observer is true if the node should connect as a read-only observer. Observers
+	// read mesh state from the join addresses without joining the mesh, voting in
+	// storage, or configuring WireGuard.
+	Observer bool `koanf:"observer,omitempty"`
 	// StoragePreferIPv6 is the prefer IPv6 flag for storage provider connections.
 	StoragePreferIPv6 bool `koanf:"prefer-ipv6,omitempty"`
 	// DisableIPv4 disables IPv4 usage.
@@ -98,6 +126,18 @@ type MeshOptions struct {
 	DisableDefaultIPAM bool `koanf:"disable-default-ipam,omitempty"`
 	// DefaultIPAMStaticIPv4 are static IPv4 assignments to use for the default IPAM.
 	DefaultIPAMStaticIPv4 map[string]string `koanf:"default-ipam-static-ipv4,omitempty"`
+	// HeartbeatInterval is the interval at which to record liveness in storage.
+	// Heartbeats are disabled when zero.
+	HeartbeatInterval time.Duration `koanf:"heartbeat-interval,omitempty"`
+	// PruneStaleNodesAfter is how long a node may go without a heartbeat before the
+	// leader removes it from the mesh. Pruning is disabled when zero.
+	PruneStaleNodesAfter time.Duration `koanf:"prune-stale-nodes-after,omitempty"`
+	// PruneGracePeriod is how long a node must stay down after it is first observed
+	// down before it is pruned, so that brief outages do not cause churn.
+	PruneGracePeriod time.Duration `koanf:"prune-grace-period,omitempty"`
+	// DryRunMigrations logs the changes pending storage migrations would make
+	// instead of applying them.
+	DryRunMigrations bool `koanf:"dry-run-migrations,omitempty"`
 }
 
 // NewMeshOptions returns a new MeshOptions with the default values. If node id
@@ -110,6 +150,12 @@ func NewMeshOptions(nodeID string) MeshOptions {
 		JoinAddresses:               nil,
 		MaxJoinRetries:              15,
 		Routes:                      nil,
+		AdvertiseHostRoutes:         false,
+		HostRouteInterfaces:         nil,
+		HostRouteCIDRs:              nil,
+		HostRouteSyncInterval:       meshnode.DefaultHostRouteSyncInterval,
+		ExitNode:                    false,
+		UseExitNode:                 "",
 		ICEPeers:                    []string{},
 		LibP2PPeers:                 []string{},
 		GRPCAdvertisePort:           services.DefaultGRPCPort,
@@ -117,24 +163,54 @@ func NewMeshOptions(nodeID string) MeshOptions {
 		UseMeshDNS:                  false,
 		RequestVote:                 false,
 		RequestObserver:             false,
+		Observer:                    false,
 		StoragePreferIPv6:           false,
 		DisableIPv4:                 false,
 		DisableIPv6:                 false,
 		DisableFeatureAdvertisement: false,
 		DisableDefaultIPAM:          false,
 		DefaultIPAMStaticIPv4:       map[string]string{},
+		HeartbeatInterval:           time.Minute,
+		PruneStaleNodesAfter:        0,
+		PruneGracePeriod:            0,
+		DryRunMigrations:            false,
 	}
 }
 
+// EncodedZoneAwarenessID returns the zone awareness ID with the configured
+// labels appended to it. The zone awareness ID is returned unchanged when
+// there are no labels.
+func (o *MeshOptions) EncodedZoneAwarenessID() (string, error) {
+	if len(o.Labels) == 0 {
+		return o.ZoneAwarenessID, nil
+	}
+	labels := types.Labels(o.Labels)
+	if err := types.ValidateLabels(labels); err != nil {
+		return "", err
+	}
+	if o.ZoneAwarenessID == "" {
+		return labels.String(), nil
+	}
+	return o.ZoneAwarenessID + types.ZoneSeparator + labels.String(), nil
+}
+
 // BindFlags binds the flags to the options.
 func (o *MeshOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringVar(&o.NodeID, prefix+"node-id", o.NodeID, "Node ID. One will be chosen automatically if left unset.")
 	fs.StringVar(&o.PrimaryEndpoint, prefix+"primary-endpoint", o.PrimaryEndpoint, "Primary endpoint to advertise when joining.")
 	fs.StringVar(&o.ZoneAwarenessID, prefix+"zone-awareness-id", o.ZoneAwarenessID, "Zone awareness ID.")
+	fs.StringToStringVar(&o.Labels, prefix+"labels", o.Labels, "Key/value labels to attach to the node.")
 	fs.StringSliceVar(&o.JoinAddresses, prefix+"join-addresses", o.JoinAddresses, "Addresses of nodes to join.")
 	fs.StringSliceVar(&o.JoinMultiaddrs, prefix+"join-multiaddrs", o.JoinMultiaddrs, "Multiaddresses of nodes to join.")
 	fs.IntVar(&o.MaxJoinRetries, prefix+"max-join-retries", o.MaxJoinRetries, "Maximum number of join retries.")
+	fs.StringVar(&o.JoinToken, prefix+"join-token", o.JoinToken, "Join token to present when joining.")
 	fs.StringSliceVar(&o.Routes, prefix+"routes", o.Routes, "Additional routes to advertise to the mesh.")
+	fs.BoolVar(&o.AdvertiseHostRoutes, prefix+"advertise-host-routes", o.AdvertiseHostRoutes, "Advertise routes from the host routing table to the mesh.")
+	fs.StringSliceVar(&o.HostRouteInterfaces, prefix+"host-route-interfaces", o.HostRouteInterfaces, "Only advertise host routes on these interfaces. Shell patterns are supported.")
+	fs.StringSliceVar(&o.HostRouteCIDRs, prefix+"host-route-cidrs", o.HostRouteCIDRs, "Only advertise host routes within these CIDRs.")
+	fs.DurationVar(&o.HostRouteSyncInterval, prefix+"host-route-sync-interval", o.HostRouteSyncInterval, "Interval at which to re-read the host routing table.")
+	fs.BoolVar(&o.ExitNode, prefix+"exit-node", o.ExitNode, "Advertise this node as an exit node for peers that select it.")
+	fs.StringVar(&o.UseExitNode, prefix+"use-exit-node", o.UseExitNode, "ID of an exit node to route default traffic through.")
 	fs.StringSliceVar(&o.ICEPeers, prefix+"ice-peers", o.ICEPeers, "Peers to request direct edges to over ICE.")
 	fs.StringSliceVar(&o.LibP2PPeers, prefix+"libp2p-peers", o.LibP2PPeers, "Map of peer IDs to rendezvous strings for edges over libp2p.")
 	fs.IntVar(&o.GRPCAdvertisePort, prefix+"grpc-advertise-port", o.GRPCAdvertisePort, "Port to advertise for gRPC.")
@@ -142,12 +218,17 @@ func (o *MeshOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.UseMeshDNS, prefix+"use-meshdns", o.UseMeshDNS, "Set mesh DNS servers to the system configuration.")
 	fs.BoolVar(&o.RequestVote, prefix+"request-vote", o.RequestVote, "Request a vote in elections for the storage backend.")
 	fs.BoolVar(&o.RequestObserver, prefix+"request-observer", o.RequestObserver, "Request to be an observer in the storage backend.")
+	fs.BoolVar(&o.Observer, prefix+"observer", o.Observer, "Connect as a read-only observer without joining the mesh or configuring WireGuard.")
 	fs.BoolVar(&o.StoragePreferIPv6, prefix+"storage-prefer-ipv6", o.StoragePreferIPv6, "Prefer IPv6 connections for the storage backend transport.")
 	fs.BoolVar(&o.DisableIPv4, prefix+"disable-ipv4", o.DisableIPv4, "Disable IPv4 usage.")
 	fs.BoolVar(&o.DisableIPv6, prefix+"disable-ipv6", o.DisableIPv6, "Disable IPv6 usage.")
 	fs.BoolVar(&o.DisableFeatureAdvertisement, prefix+"disable-feature-advertisement", o.DisableFeatureAdvertisement, "Disable feature advertisement.")
 	fs.BoolVar(&o.DisableDefaultIPAM, prefix+"disable-default-ipam", o.DisableDefaultIPAM, "Disable the default IPAM.")
 	fs.StringToStringVar(&o.DefaultIPAMStaticIPv4, prefix+"default-ipam-static-ipv4", o.DefaultIPAMStaticIPv4, "Static IPv4 assignments to use for the default IPAM.")
+	fs.DurationVar(&o.HeartbeatInterval, prefix+"heartbeat-interval", o.HeartbeatInterval, "Interval at which to record liveness in storage. Set to 0 to disable.")
+	fs.DurationVar(&o.PruneStaleNodesAfter, prefix+"prune-stale-nodes-after", o.PruneStaleNodesAfter, "Remove nodes that have not sent a heartbeat for this long. Set to 0 to disable.")
+	fs.DurationVar(&o.PruneGracePeriod, prefix+"prune-grace-period", o.PruneGracePeriod, "How long a node must stay down after it is first observed down before it is pruned.")
+	fs.BoolVar(&o.DryRunMigrations, prefix+"dry-run-migrations", o.DryRunMigrations, "Log the changes pending storage migrations would make instead of applying them.")
 }
 
 // Validate validates the options.
@@ -163,6 +244,9 @@ func (o *MeshOptions) Validate() error {
 	if o.DisableIPv4 && o.DisableIPv6 {
 		return fmt.Errorf("cannot disable both IPv4 and IPv6")
 	}
+	if _, err := o.EncodedZoneAwarenessID(); err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
 	if (len(o.JoinAddresses) > 0 || len(o.JoinMultiaddrs) > 0) && o.MaxJoinRetries <= 0 {
 		return fmt.Errorf("max join retries must be >= 0")
 	}
@@ -180,6 +264,33 @@ func (o *MeshOptions) Validate() error {
 	if o.RequestVote && o.RequestObserver {
 		return fmt.Errorf("cannot request vote and observer")
 	}
+	if o.Observer {
+		if o.RequestVote || o.RequestObserver {
+			return fmt.Errorf("read-only observers cannot request storage membership")
+		}
+		if len(o.JoinAddresses) == 0 {
+			return fmt.Errorf("read-only observers require join addresses to read mesh state from")
+		}
+	}
+	if o.HostRouteSyncInterval < 0 {
+		return fmt.Errorf("host route sync interval must not be negative")
+	}
+	for _, cidr := range o.HostRouteCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid host route cidr %q: %w", cidr, err)
+		}
+	}
+	if o.HeartbeatInterval < 0 || o.PruneStaleNodesAfter < 0 || o.PruneGracePeriod < 0 {
+		return fmt.Errorf("heartbeat interval, stale node pruning, and prune grace period must not be negative")
+	}
+	if o.PruneStaleNodesAfter > 0 {
+		if o.HeartbeatInterval == 0 {
+			return fmt.Errorf("pruning stale nodes requires heartbeats to be enabled")
+		}
+		if o.PruneStaleNodesAfter <= o.HeartbeatInterval {
+			return fmt.Errorf("prune-stale-nodes-after must be greater than the heartbeat interval")
+		}
+	}
 	if o.DisableIPv6 && o.StoragePreferIPv6 {
 		return fmt.Errorf("cannot prefer IPv6 for storage when IPv6 is disabled")
 	}
@@ -202,6 +313,14 @@ func (o *MeshOptions) Validate() error {
 			return fmt.Errorf("invalid primary endpoint: %w", err)
 		}
 	}
+	if o.UseExitNode != "" {
+		if !types.IsValidNodeID(o.UseExitNode) {
+			return fmt.Errorf("invalid exit node ID %s", o.UseExitNode)
+		}
+		if o.ExitNode {
+			return fmt.Errorf("exit nodes cannot use another exit node")
+		}
+	}
 	for _, peer := range o.ICEPeers {
 		if !types.IsValidNodeID(peer) {
 			return fmt.Errorf("invalid ICE peer ID %s", peer)
@@ -249,10 +368,18 @@ func (o *Config) NewMeshConfig(ctx context.Context, key crypto.PrivateKey) (conf
 			return
 		}
 	}
+	zoneID, err := o.Mesh.EncodedZoneAwarenessID()
+	if err != nil {
+		return
+	}
 	conf = meshnode.Config{
 		Key:                     key,
 		HeartbeatPurgeThreshold: o.Storage.Raft.HeartbeatPurgeThreshold,
-		ZoneAwarenessID:         o.Mesh.ZoneAwarenessID,
+		HeartbeatInterval:       o.Mesh.HeartbeatInterval,
+		PruneStaleNodesAfter:    o.Mesh.PruneStaleNodesAfter,
+		PruneGracePeriod:        o.Mesh.PruneGracePeriod,
+		DryRunMigrations:        o.Mesh.DryRunMigrations,
+		ZoneAwarenessID:         zoneID,
 		UseMeshDNS:              o.Mesh.UseMeshDNS,
 		DisableIPv4:             o.Mesh.DisableIPv4,
 		DisableIPv6:             o.Mesh.DisableIPv6,
@@ -389,6 +516,14 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 	if err != nil {
 		return
 	}
+	zoneID, err := o.Mesh.EncodedZoneAwarenessID()
+	if err != nil {
+		return
+	}
+	err = o.WireGuard.ResolveListenPort(ctx)
+	if err != nil {
+		return
+	}
 	// Parse all endpoints and routes
 	var primaryEndpoint netip.Addr
 	if o.Mesh.PrimaryEndpoint != "" {
@@ -427,11 +562,39 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 				return
 			}
 		}
+		// Advertise the smallest set of prefixes covering the configured routes.
+		routes = netutil.Summarize(routes)
 	}
-	// Create the join transport
-	joinRT, err := o.NewJoinTransport(ctx, nodeid, conn, host)
+	roaming, err := o.WireGuard.NewRoamingOptions()
 	if err != nil {
-		return
+		return opts, err
+	}
+	var hostRoutes *meshnode.HostRouteOptions
+	if o.Mesh.AdvertiseHostRoutes {
+		hostRoutes = &meshnode.HostRouteOptions{
+			Interfaces:   o.Mesh.HostRouteInterfaces,
+			SyncInterval: o.Mesh.HostRouteSyncInterval,
+		}
+		for _, cidr := range o.Mesh.HostRouteCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return opts, fmt.Errorf("parse host route cidr: %w", err)
+			}
+			hostRoutes.CIDRs = append(hostRoutes.CIDRs, prefix)
+		}
+	}
+	// Create the join transport. Observers never join.
+	var joinRT transport.JoinRoundTripper
+	var leaveRT transport.LeaveRoundTripper
+	var observer *meshnode.ObserverOptions
+	if o.Mesh.Observer {
+		observer = &meshnode.ObserverOptions{Addrs: o.Mesh.JoinAddresses}
+	} else {
+		joinRT, err = o.NewJoinTransport(ctx, nodeid, conn, host)
+		if err != nil {
+			return
+		}
+		leaveRT = o.NewLeaveTransport(ctx, conn)
 	}
 	// Configure any bootstrap options
 	var bootstrap *meshnode.BootstrapOptions
@@ -455,6 +618,8 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 		bootstrap = &meshnode.BootstrapOptions{
 			Transport:            rt,
 			IPv4Network:          o.Bootstrap.IPv4Network,
+			IPv4NodePrefixLength: o.Bootstrap.IPv4NodePrefixLength,
+			ExpectedNodes:        o.Bootstrap.ExpectedNodes,
 			IPv6Network:          o.Bootstrap.IPv6Network,
 			MeshDomain:           o.Bootstrap.MeshDomain,
 			Admin:                o.Bootstrap.Admin,
@@ -483,10 +648,12 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 	opts = meshnode.ConnectOptions{
 		StorageProvider:      provider,
 		JoinRoundTripper:     joinRT,
-		LeaveRoundTripper:    o.NewLeaveTransport(ctx, conn),
+		LeaveRoundTripper:    leaveRT,
 		Features:             o.Services.NewFeatureSet(provider, o.Services.API.ListenPort()),
 		Bootstrap:            bootstrap,
+		Observer:             observer,
 		MaxJoinRetries:       o.Mesh.MaxJoinRetries,
+		JoinToken:            o.Mesh.JoinToken,
 		GRPCAdvertisePort:    o.Mesh.GRPCAdvertisePort,
 		MeshDNSAdvertisePort: o.Mesh.MeshDNSAdvertisePort,
 		PrimaryEndpoint:      primaryEndpoint,
@@ -494,6 +661,9 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 		RequestVote:          o.Mesh.RequestVote,
 		RequestObserver:      o.Mesh.RequestObserver,
 		Routes:               routes,
+		HostRoutes:           hostRoutes,
+		ExitNode:             o.Mesh.ExitNode,
+		RequireSignedRoutes:  o.Services.API.RequireSignedRoutes,
 		DirectPeers: func() map[types.NodeID]v1.ConnectProtocol {
 			peers := make(map[types.NodeID]v1.ConnectProtocol)
 			for _, peer := range o.Mesh.ICEPeers {
@@ -506,26 +676,46 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 			}
 			return peers
 		}(),
-		PreferIPv6: o.Mesh.StoragePreferIPv6,
-		Plugins:    plugins,
+		PreferIPv6:         o.Mesh.StoragePreferIPv6,
+		Plugins:            plugins,
+		PluginEvents:       o.Plugins.NewEventDispatchOptions(),
+		PluginHealth:       o.Plugins.NewHealthCheckOptions(),
+		PluginDrainTimeout: o.Plugins.DrainTimeout,
 		NetworkOptions: meshnet.Options{
-			Modprobe:              o.WireGuard.Modprobe,
-			InterfaceName:         o.WireGuard.InterfaceName,
-			ForceReplace:          o.WireGuard.ForceInterfaceName,
-			ListenPort:            o.WireGuard.ListenPort,
-			PersistentKeepAlive:   o.WireGuard.PersistentKeepAlive,
-			ForceTUN:              o.WireGuard.ForceTUN,
-			MTU:                   o.WireGuard.MTU,
-			RecordMetrics:         o.WireGuard.RecordMetrics,
-			RecordMetricsInterval: o.WireGuard.RecordMetricsInterval,
-			StoragePort:           o.Storage.ListenPort(),
-			GRPCPort:              o.Mesh.GRPCAdvertisePort,
-			ZoneAwarenessID:       o.Mesh.ZoneAwarenessID,
-			Credentials:           conn.Credentials(),
-			LocalDNSAddr:          localDNSAddr,
-			DisableIPv4:           o.Mesh.DisableIPv4,
-			DisableIPv6:           o.Mesh.DisableIPv6,
-			DisableFullTunnel:     o.WireGuard.DisableFullTunnel,
+			Modprobe:               o.WireGuard.Modprobe,
+			InterfaceName:          o.WireGuard.InterfaceName,
+			ForceReplace:           o.WireGuard.ForceInterfaceName,
+			ListenPort:             o.WireGuard.ListenPort,
+			PersistentKeepAlive:    o.WireGuard.PersistentKeepAlive,
+			ForceTUN:               o.WireGuard.ForceTUN,
+			Implementation:         wireguard.Implementation(o.WireGuard.Implementation),
+			MTU:                    o.WireGuard.MTU,
+			RecordMetrics:          o.WireGuard.RecordMetrics,
+			RecordMetricsInterval:  o.WireGuard.RecordMetricsInterval,
+			StoragePort:            o.Storage.ListenPort(),
+			GRPCPort:               o.Mesh.GRPCAdvertisePort,
+			ZoneAwarenessID:        zoneID,
+			Credentials:            conn.Credentials(),
+			LocalDNSAddr:           localDNSAddr,
+			DisableIPv4:            o.Mesh.DisableIPv4,
+			DisableIPv6:            o.Mesh.DisableIPv6,
+			DisableFullTunnel:      o.WireGuard.DisableFullTunnel,
+			FirewallBackend:        firewall.Backend(o.WireGuard.FirewallBackend),
+			RestrictControlPlane:   o.WireGuard.RestrictControlPlane,
+			FwMark:                 o.WireGuard.FwMark,
+			RoutingTable:           o.WireGuard.RoutingTable,
+			EqualCostMultipath:     o.WireGuard.EqualCostMultipath,
+			MaxAllowedIPs:          o.WireGuard.MaxAllowedIPs,
+			SummarizeAllowedIPs:    o.WireGuard.SummarizeAllowedIPs,
+			RequireSignedRoutes:    o.Services.API.RequireSignedRoutes,
+			InterfaceWatchMode:     meshnet.InterfaceWatchMode(o.WireGuard.InterfaceWatchMode),
+			InterfaceWatchInterval: o.WireGuard.InterfaceWatchInterval,
+			HostOverlapCheck:       endpoints.OverlapCheckMode(o.WireGuard.HostOverlapCheck),
+			Preflight:              o.WireGuard.Preflight,
+			EnableIPForwarding:     o.WireGuard.EnableIPForwarding,
+			RestoreIPForwarding:    o.WireGuard.RestoreIPForwarding,
+			Roaming:                roaming,
+			ExitNode:               types.NodeID(o.Mesh.UseExitNode),
 			Relays: meshnet.RelayOptions{
 				Host: o.Discovery.HostOptions(ctx, conn.Key()),
 			},
@@ -122,6 +122,41 @@ func TestMeshConfigValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "ValidObserver",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				JoinAddresses:        []string{"localhost:8443"},
+				MaxJoinRetries:       15,
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				Observer:             true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "ObserverWithoutJoinAddresses",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				Observer:             true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "ObserverRequestingVote",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				JoinAddresses:        []string{"localhost:8443"},
+				MaxJoinRetries:       15,
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				RequestVote:          true,
+				Observer:             true,
+			},
+			wantErr: true,
+		},
 		{
 			name: "InvalidPrimaryEndpoint",
 			cfg: &MeshOptions{
@@ -22,6 +22,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 
@@ -35,11 +36,66 @@ import (
 type PluginOptions struct {
 	// Configs is a map of plugin names to plugin configurations.
 	Configs map[string]PluginConfig `koanf:"configs"`
+	// EventWorkers is the number of workers delivering events to each watch plugin.
+	EventWorkers int `koanf:"event-workers,omitempty"`
+	// EventQueueSize is the number of events buffered for each watch plugin.
+	EventQueueSize int `koanf:"event-queue-size,omitempty"`
+	// EventQueuePolicy is the policy when a watch plugin's event queue is full.
+	// It can be "block" to wait for room in the queue or "drop" to discard the event.
+	EventQueuePolicy string `koanf:"event-queue-policy,omitempty"`
+	// DisableHealthChecks disables periodic plugin health checks and restarts.
+	DisableHealthChecks bool `koanf:"disable-health-checks,omitempty"`
+	// HealthCheckInterval is the interval between plugin health checks.
+	HealthCheckInterval time.Duration `koanf:"health-check-interval,omitempty"`
+	// MaxRestarts is the number of consecutive restarts without a passing health
+	// check before a plugin is marked as failed.
+	MaxRestarts int `koanf:"max-restarts,omitempty"`
+	// DrainTimeout is how long to wait for plugins to flush in-flight work on shutdown.
+	DrainTimeout time.Duration `koanf:"drain-timeout,omitempty"`
 }
 
 // NewPluginOptions returns a new empty PluginOptions.
 func NewPluginOptions() PluginOptions {
-	return PluginOptions{}
+	return PluginOptions{
+		EventWorkers:        plugins.DefaultEventWorkers,
+		EventQueueSize:      plugins.DefaultEventQueueSize,
+		EventQueuePolicy:    string(plugins.DefaultEventQueuePolicy),
+		HealthCheckInterval: plugins.DefaultHealthCheckInterval,
+		MaxRestarts:         plugins.DefaultMaxRestarts,
+		DrainTimeout:        plugins.DefaultDrainTimeout,
+	}
+}
+
+// Validate validates the plugin options.
+func (o *PluginOptions) Validate() error {
+	if o.HealthCheckInterval < 0 {
+		return fmt.Errorf("health check interval must be non-negative")
+	}
+	if o.MaxRestarts < 0 {
+		return fmt.Errorf("max restarts must be non-negative")
+	}
+	if o.DrainTimeout < 0 {
+		return fmt.Errorf("drain timeout must be non-negative")
+	}
+	return o.NewEventDispatchOptions().Validate()
+}
+
+// NewHealthCheckOptions returns the options for checking the health of plugins.
+func (o *PluginOptions) NewHealthCheckOptions() plugins.HealthCheckOptions {
+	return plugins.HealthCheckOptions{
+		Disabled:    o.DisableHealthChecks,
+		Interval:    o.HealthCheckInterval,
+		MaxRestarts: o.MaxRestarts,
+	}
+}
+
+// NewEventDispatchOptions returns the options for dispatching events to watch plugins.
+func (o *PluginOptions) NewEventDispatchOptions() plugins.EventDispatchOptions {
+	return plugins.EventDispatchOptions{
+		Workers:   o.EventWorkers,
+		QueueSize: o.EventQueueSize,
+		Policy:    plugins.EventQueuePolicy(o.EventQueuePolicy),
+	}
 }
 
 // MTLSEnabled reports whether the mtls plugin is configured.
@@ -53,6 +109,13 @@ func (o *PluginOptions) MTLSEnabled() bool {
 
 // BindFlags binds the flags for the plugin options.
 func (o *PluginOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.IntVar(&o.EventWorkers, prefix+"event-workers", o.EventWorkers, "Number of workers delivering events to each watch plugin.")
+	fs.IntVar(&o.EventQueueSize, prefix+"event-queue-size", o.EventQueueSize, "Number of events buffered for each watch plugin.")
+	fs.StringVar(&o.EventQueuePolicy, prefix+"event-queue-policy", o.EventQueuePolicy, "Policy when a watch plugin's event queue is full (block or drop).")
+	fs.BoolVar(&o.DisableHealthChecks, prefix+"disable-health-checks", o.DisableHealthChecks, "Disable periodic plugin health checks and restarts.")
+	fs.DurationVar(&o.HealthCheckInterval, prefix+"health-check-interval", o.HealthCheckInterval, "Interval between plugin health checks.")
+	fs.IntVar(&o.MaxRestarts, prefix+"max-restarts", o.MaxRestarts, "Consecutive plugin restarts before a plugin is marked as failed.")
+	fs.DurationVar(&o.DrainTimeout, prefix+"drain-timeout", o.DrainTimeout, "Time to wait for plugins to flush in-flight work on shutdown.")
 	seen := map[string]struct{}{}
 	if len(os.Args[1:]) > 0 {
 		for _, arg := range os.Args[1:] {
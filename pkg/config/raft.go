@@ -17,9 +17,12 @@ limitations under the License.
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net"
 	"net/netip"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -28,12 +31,16 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
 )
 
 // RaftOptions are options for the raft backend.
 type RaftOptions struct {
 	// ListenAddress is the address to listen on.
 	ListenAddress string `koanf:"listen-address,omitempty"`
+	// Multiplex serves raft and the gRPC API on the same port through a connection
+	// muxer. The listen address must match the gRPC listen address.
+	Multiplex bool `koanf:"multiplex,omitempty"`
 	// ConnectionPoolCount is the number of connections to pool. If 0, no connection pooling is used.
 	ConnectionPoolCount int `koanf:"connection-pool-count,omitempty"`
 	// ConnectionTimeout is the timeout for connections.
@@ -60,6 +67,23 @@ type RaftOptions struct {
 	ObserverChanBuffer int `koanf:"observer-chan-buffer,omitempty"`
 	// HeartbeatPurgeThreshold is the threshold of failed heartbeats before purging a peer.
 	HeartbeatPurgeThreshold int `koanf:"heartbeat-purge-threshold,omitempty"`
+	// MinFreeDiskSpace is the minimum free space in bytes required on the filesystem containing
+	// the data directory. Writes are paused while free space is below this threshold. Set to 0
+	// to disable the check.
+	MinFreeDiskSpace uint64 `koanf:"min-free-disk-space,omitempty"`
+	// DiskSpaceCheckInterval is the interval for checking free disk space.
+	DiskSpaceCheckInterval time.Duration `koanf:"disk-space-check-interval,omitempty"`
+	// RecordMetrics enables recording of raft and storage metrics. These are only exposed if the
+	// metrics server is enabled.
+	RecordMetrics bool `koanf:"record-metrics,omitempty"`
+	// SnapshotEncryptionKeyFile is a file containing a base64-encoded 32-byte key used
+	// to encrypt the values of sensitive keys in snapshots.
+	SnapshotEncryptionKeyFile string `koanf:"snapshot-encryption-key-file,omitempty"`
+	// SnapshotSensitivePrefixes are the key prefixes whose values are encrypted in snapshots.
+	SnapshotSensitivePrefixes []string `koanf:"snapshot-sensitive-prefixes,omitempty"`
+	// AllowSnapshotRestore allows restoring the cluster from an exported snapshot. This replaces
+	// all storage state and should only be enabled for disaster recovery.
+	AllowSnapshotRestore bool `koanf:"allow-snapshot-restore,omitempty"`
 }
 
 // NewRaftOptions returns a new RaftOptions with the default values.
@@ -79,12 +103,16 @@ func NewRaftOptions() RaftOptions {
 		SnapshotRetention:       2,
 		ObserverChanBuffer:      100,
 		HeartbeatPurgeThreshold: 25,
+		MinFreeDiskSpace:        raftstorage.DefaultMinFreeDiskSpace,
+		DiskSpaceCheckInterval:  raftstorage.DefaultDiskSpaceCheckInterval,
+		RecordMetrics:           false,
 	}
 }
 
 // BindFlags binds the flags.
 func (o *RaftOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringVar(&o.ListenAddress, prefix+"listen-address", o.ListenAddress, "Raft listen address.")
+	fs.BoolVar(&o.Multiplex, prefix+"multiplex", o.Multiplex, "Serve raft and the gRPC API on the same port. The raft listen address must match the gRPC listen address.")
 	fs.IntVar(&o.ConnectionPoolCount, prefix+"connection-pool-count", o.ConnectionPoolCount, "Raft connection pool count.")
 	fs.DurationVar(&o.ConnectionTimeout, prefix+"connection-timeout", o.ConnectionTimeout, "Raft connection timeout.")
 	fs.DurationVar(&o.HeartbeatTimeout, prefix+"heartbeat-timeout", o.HeartbeatTimeout, "Raft heartbeat timeout.")
@@ -98,6 +126,12 @@ func (o *RaftOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.Uint64Var(&o.SnapshotRetention, prefix+"snapshot-retention", o.SnapshotRetention, "Raft snapshot retention.")
 	fs.IntVar(&o.ObserverChanBuffer, prefix+"observer-chan-buffer", o.ObserverChanBuffer, "Raft observer channel buffer.")
 	fs.IntVar(&o.HeartbeatPurgeThreshold, prefix+"heartbeat-purge-threshold", o.HeartbeatPurgeThreshold, "Raft heartbeat purge threshold.")
+	fs.Uint64Var(&o.MinFreeDiskSpace, prefix+"min-free-disk-space", o.MinFreeDiskSpace, "Minimum free disk space in bytes before pausing writes. Set to 0 to disable.")
+	fs.DurationVar(&o.DiskSpaceCheckInterval, prefix+"disk-space-check-interval", o.DiskSpaceCheckInterval, "Interval for checking free disk space.")
+	fs.BoolVar(&o.RecordMetrics, prefix+"record-metrics", o.RecordMetrics, "Record raft and storage metrics. These are only exposed if the metrics server is enabled.")
+	fs.StringVar(&o.SnapshotEncryptionKeyFile, prefix+"snapshot-encryption-key-file", o.SnapshotEncryptionKeyFile, "File containing a base64-encoded 32-byte key for encrypting sensitive values in snapshots.")
+	fs.StringSliceVar(&o.SnapshotSensitivePrefixes, prefix+"snapshot-sensitive-prefixes", o.SnapshotSensitivePrefixes, "Key prefixes whose values are encrypted in snapshots.")
+	fs.BoolVar(&o.AllowSnapshotRestore, prefix+"allow-snapshot-restore", o.AllowSnapshotRestore, "Allow restoring the cluster from an exported snapshot. This replaces all storage state.")
 }
 
 // Validate validates the options.
@@ -112,16 +146,47 @@ func (o RaftOptions) Validate(dataDir string, inMemory bool) error {
 	if !inMemory && dataDir == "" {
 		return fmt.Errorf("storage.data-dir is required when not running in-memory")
 	}
+	if o.SnapshotEncryptionKeyFile != "" && len(o.SnapshotSensitivePrefixes) == 0 {
+		return fmt.Errorf("raft.snapshot-sensitive-prefixes must be set when raft.snapshot-encryption-key-file is set")
+	}
+	if len(o.SnapshotSensitivePrefixes) > 0 && o.SnapshotEncryptionKeyFile == "" {
+		return fmt.Errorf("raft.snapshot-encryption-key-file must be set when raft.snapshot-sensitive-prefixes is set")
+	}
 	return nil
 }
 
+// LoadSnapshotEncryptionKey loads the snapshot encryption key. It returns
+// nil if no key file is configured.
+func (o RaftOptions) LoadSnapshotEncryptionKey() ([]byte, error) {
+	if o.SnapshotEncryptionKeyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(o.SnapshotEncryptionKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot encryption key file: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot encryption key: %w", err)
+	}
+	if len(key) != snapshots.EncryptionKeySize {
+		return nil, fmt.Errorf("snapshot encryption key must be %d bytes, got %d", snapshots.EncryptionKeySize, len(key))
+	}
+	return key, nil
+}
+
 // NewTransport creates a new raft transport for the current configuration.
-func (o RaftOptions) NewTransport(conn meshnode.Node) (transport.RaftTransport, error) {
-	return tcp.NewRaftTransport(conn, tcp.RaftTransportOptions{
+// The mux is optional and is only used when multiplexing is enabled.
+func (o RaftOptions) NewTransport(conn meshnode.Node, mux *tcp.Mux) (transport.RaftTransport, error) {
+	opts := tcp.RaftTransportOptions{
 		Addr:    o.ListenAddress,
 		MaxPool: o.ConnectionPoolCount,
 		Timeout: o.ConnectionTimeout,
-	})
+	}
+	if o.Multiplex {
+		opts.Mux = mux
+	}
+	return tcp.NewRaftTransport(conn, opts)
 }
 
 // ListenPort returns the listen port.
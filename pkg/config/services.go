@@ -21,6 +21,7 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"maps"
 	"net"
 	"net/netip"
 	"os"
@@ -42,7 +43,9 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/datachannels"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/idauth"
 	"github.com/webmeshproj/webmesh/pkg/services"
@@ -59,6 +62,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/services/turn"
 	"github.com/webmeshproj/webmesh/pkg/services/webrtc"
 	meshstorage "github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 	"github.com/webmeshproj/webmesh/pkg/version"
 )
 
@@ -139,6 +143,17 @@ func (s *ServiceOptions) Validate() error {
 	if err != nil {
 		return err
 	}
+	if s.Metrics.Enabled && !s.API.Disabled {
+		if listenAddressesCollide(s.API.ListenAddress, s.Metrics.ListenAddress) {
+			return fmt.Errorf("services.metrics.listen-address must not use the same port as services.api.listen-address")
+		}
+		if listenAddressesCollide(s.API.AdminListenAddress, s.Metrics.ListenAddress) {
+			return fmt.Errorf("services.metrics.listen-address must not use the same port as services.api.admin-listen-address")
+		}
+		if listenAddressesCollide(s.API.ReadOnlyStorageListenAddress, s.Metrics.ListenAddress) {
+			return fmt.Errorf("services.metrics.listen-address must not use the same port as services.api.read-only-storage-listen-address")
+		}
+	}
 	err = s.WebRTC.Validate()
 	if err != nil {
 		return err
@@ -156,6 +171,10 @@ type APIOptions struct {
 	LibP2P LibP2PAPIOptions `koanf:"libp2p,omitempty"`
 	// ListenAddress is the gRPC address to listen on.
 	ListenAddress string `koanf:"listen-address,omitempty"`
+	// BindMeshAddress moves the gRPC listener to the node's mesh addresses once
+	// the WireGuard interface is up, so the API is no longer served on public
+	// interfaces. The port from ListenAddress is kept.
+	BindMeshAddress bool `koanf:"bind-mesh-address,omitempty"`
 	// WebEnabled enables serving gRPC over HTTP/1.1.
 	WebEnabled bool `koanf:"web-enabled,omitempty"`
 	// CORSEnabled enables CORS for the gRPC web server.
@@ -183,6 +202,57 @@ type APIOptions struct {
 	MeshEnabled bool `koanf:"mesh-enabled,omitempty"`
 	// AdminEnabled is true if the admin API should be registered.
 	AdminEnabled bool `koanf:"admin-enabled,omitempty"`
+	// AdminListenAddress is an optional separate address to serve the admin API on,
+	// such as a localhost address. When empty the admin API is served on the main
+	// listen address.
+	AdminListenAddress string `koanf:"admin-listen-address,omitempty"`
+	// ReadOnlyStorageListenAddress is an optional separate address to serve
+	// read-only storage queries from the local applied state on. This lets
+	// followers offload heavy read workloads from the leader.
+	ReadOnlyStorageListenAddress string `koanf:"read-only-storage-listen-address,omitempty"`
+	// PruneRoutesOnLeave is true if routes left without a node should be
+	// removed when a node leaves the mesh.
+	PruneRoutesOnLeave bool `koanf:"prune-routes-on-leave,omitempty"`
+	// SupportedJoinFeatures are the features joining nodes may advertise.
+	// When empty, every known feature is supported.
+	SupportedJoinFeatures []string `koanf:"supported-join-features,omitempty"`
+	// RequiredJoinFeatures are features every joining node must advertise.
+	RequiredJoinFeatures []string `koanf:"required-join-features,omitempty"`
+	// StrictJoinFeatures rejects joining nodes that advertise unsupported
+	// features instead of dropping them.
+	StrictJoinFeatures bool `koanf:"strict-join-features,omitempty"`
+	// PresharedKeys is true if a WireGuard preshared key should be negotiated
+	// for every edge created for a joining node.
+	PresharedKeys bool `koanf:"preshared-keys,omitempty"`
+	// DuplicateNodeWindow is how long after a node joins that a join for the
+	// same ID with a different public key from a different source is rejected.
+	// Zero disables the check.
+	DuplicateNodeWindow time.Duration `koanf:"duplicate-node-window,omitempty"`
+	// RequireKeyBoundIDs is true if the ID of every joining node must be the
+	// ID derived from its public key.
+	RequireKeyBoundIDs bool `koanf:"require-key-bound-ids,omitempty"`
+	// MinVoters is the minimum number of storage voters to maintain by
+	// promoting observers. Zero disables promotion.
+	MinVoters int `koanf:"min-voters,omitempty"`
+	// MaxVoters is the maximum number of storage voters. Nodes joining as
+	// voters past it remain observers. Zero means no limit.
+	MaxVoters int `koanf:"max-voters,omitempty"`
+	// MaxRoutesPerNode is the maximum number of destination CIDRs a single
+	// node may advertise across all of its routes. Zero means no limit.
+	MaxRoutesPerNode int `koanf:"max-routes-per-node,omitempty"`
+	// RequireSignedRoutes rejects routes that are not signed by the identity
+	// key of the node advertising them. Unsigned routes are rejected by the
+	// admin and storage APIs, skipped when sent by joining nodes and ignored
+	// when computing peers. Nodes sign the routes they advertise themselves.
+	// It should be set on every node in the mesh.
+	RequireSignedRoutes bool `koanf:"require-signed-routes,omitempty"`
+	// RBACAllowWildcards is true if resource names in RBAC rules ending in "*"
+	// should match by prefix, and a bare "*" should grant access to every
+	// resource name. Wildcard resource names match nothing otherwise.
+	RBACAllowWildcards bool `koanf:"rbac-allow-wildcards,omitempty"`
+	// DrainTimeout is the maximum time to wait for in-flight RPCs to finish
+	// on shutdown before the server is stopped forcefully.
+	DrainTimeout time.Duration `koanf:"drain-timeout,omitempty"`
 }
 
 // LibP2PAPIOptions are options for serving the API over libp2p.
@@ -206,9 +276,11 @@ type LibP2PAPIOptions struct {
 // NewAPIOptions returns a new APIOptions with the default values.
 func NewAPIOptions(disabled bool) APIOptions {
 	return APIOptions{
-		Disabled:       disabled,
-		ListenAddress:  services.DefaultGRPCListenAddress,
-		AllowedOrigins: []string{"*"},
+		Disabled:            disabled,
+		ListenAddress:       services.DefaultGRPCListenAddress,
+		AllowedOrigins:      []string{"*"},
+		DrainTimeout:        services.DefaultDrainTimeout,
+		DuplicateNodeWindow: membership.DefaultDuplicateNodeWindow,
 	}
 }
 
@@ -216,9 +288,11 @@ func NewAPIOptions(disabled bool) APIOptions {
 // and insecure set to true.
 func NewInsecureAPIOptions(disabled bool) APIOptions {
 	return APIOptions{
-		Disabled:      disabled,
-		ListenAddress: services.DefaultGRPCListenAddress,
-		Insecure:      true,
+		Disabled:            disabled,
+		ListenAddress:       services.DefaultGRPCListenAddress,
+		Insecure:            true,
+		DrainTimeout:        services.DefaultDrainTimeout,
+		DuplicateNodeWindow: membership.DefaultDuplicateNodeWindow,
 	}
 }
 
@@ -226,6 +300,7 @@ func NewInsecureAPIOptions(disabled bool) APIOptions {
 func (a *APIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&a.Disabled, prefix+"disabled", a.Disabled, "Disable the API. This is ignored when joining as a Raft member.")
 	fl.StringVar(&a.ListenAddress, prefix+"listen-address", a.ListenAddress, "gRPC listen address.")
+	fl.BoolVar(&a.BindMeshAddress, prefix+"bind-mesh-address", a.BindMeshAddress, "Only serve the gRPC API on the node's mesh addresses once the WireGuard interface is up.")
 	fl.BoolVar(&a.WebEnabled, prefix+"web-enabled", a.WebEnabled, "Enable gRPC over HTTP/1.1.")
 	fl.BoolVar(&a.CORSEnabled, prefix+"cors-enabled", a.CORSEnabled, "Enable CORS for the gRPC web server.")
 	fl.StringSliceVar(&a.AllowedOrigins, prefix+"allowed-origins", a.AllowedOrigins, "Allowed origins for CORS.")
@@ -239,6 +314,21 @@ func (a *APIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&a.Insecure, prefix+"insecure", a.Insecure, "Disable TLS.")
 	fl.BoolVar(&a.MeshEnabled, prefix+"mesh-enabled", a.MeshEnabled, "Enable and register the MeshAPI.")
 	fl.BoolVar(&a.AdminEnabled, prefix+"admin-enabled", a.AdminEnabled, "Enable and register the AdminAPI.")
+	fl.StringVar(&a.AdminListenAddress, prefix+"admin-listen-address", a.AdminListenAddress, "Separate gRPC listen address for the AdminAPI. Defaults to the main listen address.")
+	fl.StringVar(&a.ReadOnlyStorageListenAddress, prefix+"read-only-storage-listen-address", a.ReadOnlyStorageListenAddress, "Separate gRPC listen address for read-only storage queries served from local state.")
+	fl.BoolVar(&a.PruneRoutesOnLeave, prefix+"prune-routes-on-leave", a.PruneRoutesOnLeave, "Remove routes left without a node when a node leaves the mesh.")
+	fl.StringSliceVar(&a.SupportedJoinFeatures, prefix+"supported-join-features", a.SupportedJoinFeatures, "Features joining nodes may advertise. Defaults to all known features.")
+	fl.StringSliceVar(&a.RequiredJoinFeatures, prefix+"required-join-features", a.RequiredJoinFeatures, "Features every joining node must advertise.")
+	fl.BoolVar(&a.StrictJoinFeatures, prefix+"strict-join-features", a.StrictJoinFeatures, "Reject joining nodes that advertise unsupported features instead of dropping them.")
+	fl.BoolVar(&a.PresharedKeys, prefix+"preshared-keys", a.PresharedKeys, "Negotiate WireGuard preshared keys with joining nodes. Every node must support preshared keys.")
+	fl.DurationVar(&a.DuplicateNodeWindow, prefix+"duplicate-node-window", a.DuplicateNodeWindow, "Reject joins for a recently joined node ID with a different public key from a different source within this window. Zero disables the check.")
+	fl.BoolVar(&a.RequireKeyBoundIDs, prefix+"require-key-bound-ids", a.RequireKeyBoundIDs, "Require the ID of every joining node to be the ID derived from its public key.")
+	fl.IntVar(&a.MinVoters, prefix+"min-voters", a.MinVoters, "Minimum number of storage voters to maintain by promoting observers. Zero disables promotion.")
+	fl.IntVar(&a.MaxVoters, prefix+"max-voters", a.MaxVoters, "Maximum number of storage voters. Nodes joining as voters past it remain observers. Zero means no limit.")
+	fl.IntVar(&a.MaxRoutesPerNode, prefix+"max-routes-per-node", a.MaxRoutesPerNode, "Maximum number of destination CIDRs a single node may advertise across all of its routes. Zero means no limit.")
+	fl.BoolVar(&a.RequireSignedRoutes, prefix+"require-signed-routes", a.RequireSignedRoutes, "Require routes to be signed by the node advertising them. Should be set on every node.")
+	fl.BoolVar(&a.RBACAllowWildcards, prefix+"rbac-allow-wildcards", a.RBACAllowWildcards, "Allow resource names in RBAC rules ending in \"*\" to match by prefix, and a bare \"*\" to match every resource name.")
+	fl.DurationVar(&a.DrainTimeout, prefix+"drain-timeout", a.DrainTimeout, "Maximum time to wait for in-flight RPCs to finish on shutdown.")
 	a.LibP2P.BindFlags(prefix+"libp2p.", fl)
 }
 
@@ -250,12 +340,57 @@ func (a APIOptions) Validate() error {
 	if a.ListenAddress == "" && !a.LibP2P.Enabled {
 		return fmt.Errorf("services.api.listen-address or services.api.libp2p.enabled must be be set")
 	}
+	if a.DrainTimeout < 0 {
+		return fmt.Errorf("services.api.drain-timeout must not be negative")
+	}
+	if a.DuplicateNodeWindow < 0 {
+		return fmt.Errorf("services.api.duplicate-node-window must not be negative")
+	}
+	if a.MinVoters < 0 || a.MaxVoters < 0 {
+		return fmt.Errorf("services.api.min-voters and services.api.max-voters must not be negative")
+	}
+	if a.MaxVoters > 0 && a.MinVoters > a.MaxVoters {
+		return fmt.Errorf("services.api.min-voters must not be greater than services.api.max-voters")
+	}
+	if a.MaxRoutesPerNode < 0 {
+		return fmt.Errorf("services.api.max-routes-per-node must not be negative")
+	}
+	if _, err := types.ParseFeatures(a.SupportedJoinFeatures); err != nil {
+		return fmt.Errorf("services.api.supported-join-features is invalid: %w", err)
+	}
+	if _, err := types.ParseFeatures(a.RequiredJoinFeatures); err != nil {
+		return fmt.Errorf("services.api.required-join-features is invalid: %w", err)
+	}
+	if a.BindMeshAddress && a.ListenAddress == "" {
+		return fmt.Errorf("services.api.listen-address must be set to use services.api.bind-mesh-address")
+	}
 	if a.ListenAddress != "" {
 		_, err := netip.ParseAddrPort(a.ListenAddress)
 		if err != nil {
 			return fmt.Errorf("listen-address is invalid: %w", err)
 		}
 	}
+	if a.AdminListenAddress != "" {
+		_, err := netip.ParseAddrPort(a.AdminListenAddress)
+		if err != nil {
+			return fmt.Errorf("services.api.admin-listen-address is invalid: %w", err)
+		}
+		if listenAddressesCollide(a.ListenAddress, a.AdminListenAddress) {
+			return fmt.Errorf("services.api.admin-listen-address must not use the same port as services.api.listen-address")
+		}
+	}
+	if a.ReadOnlyStorageListenAddress != "" {
+		_, err := netip.ParseAddrPort(a.ReadOnlyStorageListenAddress)
+		if err != nil {
+			return fmt.Errorf("services.api.read-only-storage-listen-address is invalid: %w", err)
+		}
+		if listenAddressesCollide(a.ListenAddress, a.ReadOnlyStorageListenAddress) {
+			return fmt.Errorf("services.api.read-only-storage-listen-address must not use the same port as services.api.listen-address")
+		}
+		if listenAddressesCollide(a.AdminListenAddress, a.ReadOnlyStorageListenAddress) {
+			return fmt.Errorf("services.api.read-only-storage-listen-address must not use the same port as services.api.admin-listen-address")
+		}
+	}
 	if !a.Insecure {
 		// If key file is supplied, make sure we have a cert-file with it.
 		if a.TLSKeyFile != "" && a.TLSCertFile == "" {
@@ -289,6 +424,51 @@ func (a APIOptions) ListenPort() int {
 	return out
 }
 
+// AdminListenPort returns the port the admin API is served on.
+func (a APIOptions) AdminListenPort() int {
+	if a.AdminListenAddress == "" {
+		return a.ListenPort()
+	}
+	_, port, err := net.SplitHostPort(a.AdminListenAddress)
+	if err != nil {
+		return 0
+	}
+	out, err := strconv.Atoi(port)
+	if err != nil {
+		return 0
+	}
+	return out
+}
+
+// listenAddressesCollide reports whether two listen addresses would conflict.
+// They conflict when they use the same port and either one listens on all
+// interfaces or both listen on the same address.
+func listenAddressesCollide(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	hostA, portA, err := net.SplitHostPort(a)
+	if err != nil {
+		return false
+	}
+	hostB, portB, err := net.SplitHostPort(b)
+	if err != nil {
+		return false
+	}
+	if portA != portB || portA == "0" {
+		return false
+	}
+	addrA, errA := netip.ParseAddr(hostA)
+	addrB, errB := netip.ParseAddr(hostB)
+	if hostA == "" || hostB == "" || (errA == nil && addrA.IsUnspecified()) || (errB == nil && addrB.IsUnspecified()) {
+		return true
+	}
+	if errA == nil && errB == nil {
+		return addrA.Unmap() == addrB.Unmap()
+	}
+	return hostA == hostB
+}
+
 // BindFlags binds the flags.
 func (l *LibP2PAPIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&l.Enabled, prefix+"enabled", l.Enabled, "Enable the libp2p API.")
@@ -352,13 +532,17 @@ type WebRTCOptions struct {
 	Enabled bool `koanf:"enabled,omitempty"`
 	// STUNServers is a list of STUN servers to use for the WebRTC API.
 	STUNServers []string `koanf:"stun-servers,omitempty"`
+	// ICEGatheringTimeout is the maximum time to wait for ICE candidate gathering
+	// before proceeding with the candidates gathered so far.
+	ICEGatheringTimeout time.Duration `koanf:"ice-gathering-timeout,omitempty"`
 }
 
 // NewWebRTCOptions returns a new WebRTCOptions with the default values.
 func NewWebRTCOptions() WebRTCOptions {
 	return WebRTCOptions{
-		Enabled:     false,
-		STUNServers: webrtc.DefaultSTUNServers,
+		Enabled:             false,
+		STUNServers:         webrtc.DefaultSTUNServers,
+		ICEGatheringTimeout: datachannels.DefaultICEGatheringTimeout,
 	}
 }
 
@@ -366,6 +550,7 @@ func NewWebRTCOptions() WebRTCOptions {
 func (w *WebRTCOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&w.Enabled, prefix+"enabled", w.Enabled, "Enable and register the WebRTC API.")
 	fl.StringSliceVar(&w.STUNServers, prefix+"stun-servers", w.STUNServers, "TURN/STUN servers to use for the WebRTC API.")
+	fl.DurationVar(&w.ICEGatheringTimeout, prefix+"ice-gathering-timeout", w.ICEGatheringTimeout, "Maximum time to wait for ICE candidate gathering before proceeding with the candidates gathered so far.")
 }
 
 // Validate validates the options.
@@ -381,6 +566,9 @@ func (w WebRTCOptions) Validate() error {
 			return fmt.Errorf("services.webrtc.stun-servers is invalid: %w", err)
 		}
 	}
+	if w.ICEGatheringTimeout < 0 {
+		return fmt.Errorf("services.webrtc.ice-gathering-timeout must not be negative")
+	}
 	return nil
 }
 
@@ -635,11 +823,20 @@ func (m MetricsOptions) Validate() error {
 	return nil
 }
 
-// NewServiceOptions returns new options for the webmesh services.
-func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.Node) (conf services.Options, err error) {
+// NewServiceOptions returns new options for the webmesh services. The mux is
+// optional and, when set, the gRPC API is served on its gRPC side.
+func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.Node, mux *tcp.Mux) (conf services.Options, err error) {
 	conf.DisableGRPC = o.API.Disabled
 	if !conf.DisableGRPC {
 		conf.ListenAddress = o.API.ListenAddress
+		if mux != nil {
+			conf.Listener = mux.GRPC()
+		}
+		conf.DrainTimeout = o.API.DrainTimeout
+		if o.API.AdminEnabled {
+			conf.InternalListenAddress = o.API.AdminListenAddress
+		}
+		conf.ReadOnlyListenAddress = o.API.ReadOnlyStorageListenAddress
 		// Build out the server options
 		srvopts, err := o.NewServerOptions(ctx)
 		if err != nil {
@@ -660,10 +857,12 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 		// Always append logging middlewares to the server options
 		unarymiddlewares := []grpc.UnaryServerInterceptor{
 			context.LogInjectUnaryServerInterceptor(context.LoggerFrom(ctx)),
+			context.RequestIDUnaryServerInterceptor(),
 			logging.ContextUnaryServerInterceptor(),
 		}
 		streammiddlewares := []grpc.StreamServerInterceptor{
 			context.LogInjectStreamServerInterceptor(context.LoggerFrom(ctx)),
+			context.RequestIDStreamServerInterceptor(),
 			logging.ContextStreamServerInterceptor(),
 		}
 		// If metrics are enabled, register the metrics interceptor
@@ -678,8 +877,15 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 			unarymiddlewares = append(unarymiddlewares, conn.Plugins().AuthUnaryInterceptor())
 			streammiddlewares = append(streammiddlewares, conn.Plugins().AuthStreamInterceptor())
 		}
+		// Resolve the roles of each caller once per unary request. Streams
+		// resolve them on every check so that role changes apply mid-stream.
+		unarymiddlewares = append(unarymiddlewares, rbac.RoleCacheUnaryServerInterceptor())
 		if !o.API.DisableLeaderProxy {
-			leaderProxy := leaderproxy.New(conn.ID(), conn.Storage().Consensus(), conn, conn.Network())
+			leaderProxy := leaderproxy.NewWithOptions(conn.ID(), conn.Storage().Consensus(), conn, conn.Network(), leaderproxy.Options{
+				Breaker:     leaderproxy.DefaultBreakerOptions(),
+				Methods:     leaderProxyMethods(),
+				ForwardMeta: admin.ForwardedMeta(),
+			})
 			unarymiddlewares = append(unarymiddlewares, leaderProxy.UnaryInterceptor())
 			streammiddlewares = append(streammiddlewares, leaderProxy.StreamInterceptor())
 		}
@@ -731,6 +937,14 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 	return
 }
 
+// leaderProxyMethods returns the routing of the extension services the leader
+// proxy does not know about.
+func leaderProxyMethods() map[string]leaderproxy.Method {
+	methods := admin.ExtensionMethods()
+	maps.Copy(methods, membership.ExtensionMethods())
+	return methods
+}
+
 // NewServerOptions returns new options for the gRPC server.
 func (o *ServiceOptions) NewServerOptions(ctx context.Context) (grpc.ServerOption, error) {
 	if o.API.Insecure {
@@ -808,6 +1022,11 @@ type APIRegistrationOptions struct {
 // RegisterAPIs registers the configured APIs to the given server.
 func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationOptions) error {
 	log := context.LoggerFrom(ctx)
+	if err := o.CheckFeatureDependencies(opts.Features, opts.Node.Storage().Consensus().IsMember()); err != nil {
+		return err
+	}
+	// Only the services of enabled features are registered.
+	gate := services.NewFeatureGate(ctx, opts.Server, opts.Features)
 	var rbacEnabled bool
 	var err error
 	maxTries := 5
@@ -832,44 +1051,80 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 		}
 		rbacEvaluator = rbac.NewNoopEvaluator()
 	} else {
-		rbacEvaluator = rbac.NewStoreEvaluator(opts.Node.Storage().MeshDB())
+		rbacEvaluator = rbac.NewStoreEvaluator(opts.Node.Storage().MeshDB(), rbac.StoreEvaluatorOptions{
+			AllowWildcards: o.API.RBACAllowWildcards,
+		})
 	}
-	// Always register the node API
 	log.Debug("Registering node service")
-	v1.RegisterNodeServer(opts.Server, node.NewServer(ctx, node.Options{
-		NodeID:      opts.Node.ID(),
-		Description: opts.Description,
-		Version:     opts.BuildInfo,
-		NodeDialer:  opts.Node,
-		Storage:     opts.Node.Storage(),
-		Meshnet:     opts.Node.Network(),
-		Plugins:     opts.Node.Plugins(),
-		Features:    opts.Features,
+	v1.RegisterNodeServer(gate, node.NewServer(ctx, node.Options{
+		NodeID:       opts.Node.ID(),
+		Description:  opts.Description,
+		Version:      opts.BuildInfo,
+		NodeDialer:   opts.Node,
+		LeaderDialer: opts.Node,
+		Storage:      opts.Node.Storage(),
+		Meshnet:      opts.Node.Network(),
+		Plugins:      opts.Node.Plugins(),
+		Features:     opts.Features,
 	}))
-	// Register membership and storage if we are a storage provider
-	if opts.Node.Storage().Consensus().IsMember() {
+	if gate.Enabled(v1.Feature_MEMBERSHIP) {
 		log.Debug("Registering membership service")
-		v1.RegisterMembershipServer(opts.Server, membership.NewServer(ctx, membership.Options{
-			NodeID:  opts.Node.ID(),
-			Storage: opts.Node.Storage(),
-			Plugins: opts.Node.Plugins(),
-			RBAC:    rbacEvaluator,
-			Meshnet: opts.Node.Network(),
-		}))
+		supported, err := types.ParseFeatures(o.API.SupportedJoinFeatures)
+		if err != nil {
+			return fmt.Errorf("parse supported join features: %w", err)
+		}
+		required, err := types.ParseFeatures(o.API.RequiredJoinFeatures)
+		if err != nil {
+			return fmt.Errorf("parse required join features: %w", err)
+		}
+		membershipServer := membership.NewServer(ctx, membership.Options{
+			NodeID:              opts.Node.ID(),
+			Storage:             opts.Node.Storage(),
+			Plugins:             opts.Node.Plugins(),
+			RBAC:                rbacEvaluator,
+			Meshnet:             opts.Node.Network(),
+			PruneRoutesOnLeave:  o.API.PruneRoutesOnLeave,
+			SupportedFeatures:   supported,
+			RequiredFeatures:    required,
+			StrictFeatures:      o.API.StrictJoinFeatures,
+			PresharedKeys:       o.API.PresharedKeys,
+			DuplicateNodeWindow: o.API.DuplicateNodeWindow,
+			RequireKeyBoundIDs:  o.API.RequireKeyBoundIDs,
+			MinVoters:           o.API.MinVoters,
+			MaxVoters:           o.API.MaxVoters,
+			MaxRoutesPerNode:    o.API.MaxRoutesPerNode,
+			RequireSignedRoutes: o.API.RequireSignedRoutes,
+		})
+		v1.RegisterMembershipServer(gate, membershipServer)
+		membership.RegisterExtensionsServer(gate, membershipServer)
+	}
+	if gate.Enabled(v1.Feature_STORAGE_QUERIER) {
 		log.Debug("Registering storage service")
-		storageSrv := storage.NewServer(ctx, opts.Node.Storage(), rbacEvaluator, opts.Node.Network())
-		v1.RegisterStorageQueryServiceServer(opts.Server, storageSrv)
+		storageSrv := storage.NewServerWithOptions(ctx, opts.Node.Storage(), rbacEvaluator, opts.Node.Network(), storage.Options{
+			RequireSignedRoutes: o.API.RequireSignedRoutes,
+		})
+		v1.RegisterStorageQueryServiceServer(gate, storageSrv)
+		if readOnly := opts.Server.ReadOnly(); readOnly != nil {
+			log.Debug("Registering read-only storage service")
+			v1.RegisterStorageQueryServiceServer(gate.For(readOnly), storage.NewReadOnlyServer(ctx, opts.Node.Storage(), rbacEvaluator, opts.Node.Network()))
+		}
 	}
-	// Register any other enabled APIs
-	if o.API.MeshEnabled {
+	if gate.Enabled(v1.Feature_MESH_API) {
 		log.Debug("Registering mesh api")
-		v1.RegisterMeshServer(opts.Server, meshapi.NewServer(opts.Node.Storage().MeshDB()))
+		v1.RegisterMeshServer(gate, meshapi.NewServer(opts.Node.Storage().MeshDB()))
 	}
-	if o.API.AdminEnabled {
+	if gate.Enabled(v1.Feature_ADMIN_API) {
 		log.Debug("Registering admin api")
-		v1.RegisterAdminServer(opts.Server, admin.NewServer(opts.Node.Storage(), rbacEvaluator))
+		adminServer := admin.NewServerWithOptions(opts.Node.Storage(), rbacEvaluator, opts.Node.Network(), admin.Options{
+			MaxRoutesPerNode:    o.API.MaxRoutesPerNode,
+			RequireSignedRoutes: o.API.RequireSignedRoutes,
+			JoinTokenKey:        opts.Node.Key(),
+			Plugins:             opts.Node.Plugins(),
+		})
+		v1.RegisterAdminServer(gate.For(opts.Server.Internal()), adminServer)
+		admin.RegisterExtensionsServer(gate.For(opts.Server.Internal()), adminServer)
 	}
-	if o.WebRTC.Enabled {
+	if gate.Enabled(v1.Feature_ICE_NEGOTIATION) {
 		log.Debug("Registering WebRTC api")
 		// Check if we are a TURN server, and if so - register the TURN server
 		if o.TURN.Enabled {
@@ -878,15 +1133,20 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 			turnAddr = fmt.Sprintf("turn:%s", turnAddr)
 			o.WebRTC.STUNServers = append([]string{turnAddr}, o.WebRTC.STUNServers...)
 		}
-		v1.RegisterWebRTCServer(opts.Server, webrtc.NewServer(webrtc.Options{
-			ID:          opts.Node.ID(),
-			Wireguard:   opts.Node.Network().WireGuard(),
-			NodeDialer:  opts.Node,
-			RBAC:        rbacEvaluator,
-			STUNServers: o.WebRTC.STUNServers,
+		// Serve the mesh APIs to peers that tunnel gRPC over data channels.
+		grpcLis := datachannels.NewConnListener(nil)
+		opts.Server.AddListener(grpcLis)
+		v1.RegisterWebRTCServer(gate, webrtc.NewServer(webrtc.Options{
+			ID:                  opts.Node.ID(),
+			Wireguard:           opts.Node.Network().WireGuard(),
+			NodeDialer:          opts.Node,
+			RBAC:                rbacEvaluator,
+			STUNServers:         o.WebRTC.STUNServers,
+			ICEGatheringTimeout: o.WebRTC.ICEGatheringTimeout,
+			GRPCListener:        grpcLis,
 		}))
 	}
-	if o.Registrar.Enabled {
+	if gate.Enabled(v1.Feature_REGISTRAR) {
 		log.Debug("Registering registrar api")
 		var authcfg *idauth.Config
 		if _, ok := opts.Node.Plugins().Get("id-auth"); !ok {
@@ -900,7 +1160,29 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 		if err != nil {
 			return err
 		}
-		v1.RegisterRegistrarServer(opts.Server, rs)
+		v1.RegisterRegistrarServer(gate, rs)
+	}
+	return nil
+}
+
+// CheckFeatureDependencies returns an error if any of the given features
+// cannot be served with the current options.
+func (o *ServiceOptions) CheckFeatureDependencies(features []*v1.FeaturePort, storageMember bool) error {
+	for _, feat := range features {
+		switch feat.GetFeature() {
+		case v1.Feature_MEMBERSHIP, v1.Feature_STORAGE_QUERIER, v1.Feature_STORAGE_PROVIDER, v1.Feature_REGISTRAR:
+			if !storageMember {
+				return fmt.Errorf("feature %s requires the node to be a storage member", feat.GetFeature())
+			}
+		case v1.Feature_ICE_NEGOTIATION:
+			if len(o.WebRTC.STUNServers) == 0 && !o.TURN.Enabled {
+				return fmt.Errorf("feature %s requires services.webrtc.stun-servers or services.turn.enabled", feat.GetFeature())
+			}
+		case v1.Feature_TURN_SERVER:
+			if o.TURN.PublicIP == "" && o.TURN.Endpoint == "" {
+				return fmt.Errorf("feature %s requires services.turn.public-ip or services.turn.endpoint", feat.GetFeature())
+			}
+		}
 	}
 	return nil
 }
@@ -951,9 +1233,13 @@ func (o *ServiceOptions) NewFeatureSet(storage meshstorage.Provider, grpcPort in
 			})
 		}
 		if o.API.AdminEnabled {
+			adminPort := grpcPort
+			if o.API.AdminListenAddress != "" {
+				adminPort = o.API.AdminListenPort()
+			}
 			features = append(features, &v1.FeaturePort{
 				Feature: v1.Feature_ADMIN_API,
-				Port:    int32(grpcPort),
+				Port:    int32(adminPort),
 			})
 		}
 		if o.WebRTC.Enabled {
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	"github.com/spf13/pflag"
+	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/services"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
@@ -517,6 +518,109 @@ func TestServiceOptionsValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "MetricsCollidesWithAPI",
+			opts: &ServiceOptions{
+				API:     NewInsecureAPIOptions(false),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+				Metrics: MetricsOptions{
+					Enabled:       true,
+					ListenAddress: "127.0.0.1:8443",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ValidAdminAddress",
+			opts: &ServiceOptions{
+				API: func() APIOptions {
+					o := NewInsecureAPIOptions(false)
+					o.AdminEnabled = true
+					o.AdminListenAddress = "127.0.0.1:8444"
+					return o
+				}(),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidAdminAddress",
+			opts: &ServiceOptions{
+				API: func() APIOptions {
+					o := NewInsecureAPIOptions(false)
+					o.AdminListenAddress = "localhost"
+					return o
+				}(),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "AdminCollidesWithAPI",
+			opts: &ServiceOptions{
+				API: func() APIOptions {
+					o := NewInsecureAPIOptions(false)
+					o.AdminListenAddress = "127.0.0.1:8443"
+					return o
+				}(),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "ValidReadOnlyStorageAddress",
+			opts: &ServiceOptions{
+				API: func() APIOptions {
+					o := NewInsecureAPIOptions(false)
+					o.ReadOnlyStorageListenAddress = "127.0.0.1:8445"
+					return o
+				}(),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+			},
+			wantErr: false,
+		},
+		{
+			name: "ReadOnlyStorageCollidesWithAPI",
+			opts: &ServiceOptions{
+				API: func() APIOptions {
+					o := NewInsecureAPIOptions(false)
+					o.ReadOnlyStorageListenAddress = "[::]:8443"
+					return o
+				}(),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "MetricsCollidesWithAdmin",
+			opts: &ServiceOptions{
+				API: func() APIOptions {
+					o := NewInsecureAPIOptions(false)
+					o.AdminListenAddress = "127.0.0.1:8444"
+					return o
+				}(),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+				Metrics: MetricsOptions{
+					Enabled:       true,
+					ListenAddress: "[::]:8444",
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tc {
@@ -531,3 +635,68 @@ func TestServiceOptionsValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckFeatureDependencies(t *testing.T) {
+	t.Parallel()
+	features := func(fs ...v1.Feature) []*v1.FeaturePort {
+		out := make([]*v1.FeaturePort, len(fs))
+		for i, f := range fs {
+			out[i] = &v1.FeaturePort{Feature: f, Port: 8443}
+		}
+		return out
+	}
+	noSTUN := NewServiceOptions(false)
+	noSTUN.WebRTC.STUNServers = nil
+	tc := []struct {
+		name     string
+		opts     ServiceOptions
+		features []*v1.FeaturePort
+		member   bool
+		wantErr  bool
+	}{
+		{
+			name:     "NodeOnly",
+			opts:     NewServiceOptions(false),
+			features: features(v1.Feature_NODES, v1.Feature_MESH_API),
+		},
+		{
+			name:     "MembershipAsMember",
+			opts:     NewServiceOptions(false),
+			features: features(v1.Feature_MEMBERSHIP, v1.Feature_STORAGE_QUERIER),
+			member:   true,
+		},
+		{
+			name:     "MembershipWithoutStorage",
+			opts:     NewServiceOptions(false),
+			features: features(v1.Feature_MEMBERSHIP),
+			wantErr:  true,
+		},
+		{
+			name:     "ICEWithDefaultSTUN",
+			opts:     NewServiceOptions(false),
+			features: features(v1.Feature_ICE_NEGOTIATION),
+		},
+		{
+			name:     "ICEWithoutSTUN",
+			opts:     noSTUN,
+			features: features(v1.Feature_ICE_NEGOTIATION),
+			wantErr:  true,
+		},
+		{
+			name:     "TURNWithoutEndpoint",
+			opts:     NewServiceOptions(false),
+			features: features(v1.Feature_TURN_SERVER),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.opts.CheckFeatureDependencies(tt.features, tt.member)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckFeatureDependencies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	extstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/external"
@@ -75,16 +76,22 @@ type StorageOptions struct {
 	LogLevel string `koanf:"log-level,omitempty"`
 	// LogFormat is the log format for the storage provider.
 	LogFormat string `koanf:"log-format,omitempty"`
+	// ValueCodec is the codec used for encoding values written to storage.
+	// Nodes read values written with either codec, but nodes older than the
+	// binary codec can only read json. Every node in the mesh must be
+	// upgraded before binary is enabled on any of them.
+	ValueCodec string `koanf:"value-codec,omitempty"`
 }
 
 // NewStorageOptions creates a new storage options.
 func NewStorageOptions() StorageOptions {
 	return StorageOptions{
-		Path:     raftstorage.DefaultDataDir,
-		Provider: string(StorageProviderRaft),
-		Raft:     NewRaftOptions(),
-		External: NewExternalStorageOptions(),
-		LogLevel: "info",
+		Path:       raftstorage.DefaultDataDir,
+		Provider:   string(StorageProviderRaft),
+		Raft:       NewRaftOptions(),
+		External:   NewExternalStorageOptions(),
+		LogLevel:   "info",
+		ValueCodec: storage.ProtoJSONCodec.Name(),
 	}
 }
 
@@ -95,6 +102,7 @@ func (o *StorageOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringVar(&o.Provider, prefix+"provider", o.Provider, "Storage provider (defaults to raftstorage or passthrough depending on other options)")
 	fs.StringVar(&o.LogLevel, prefix+"log-level", o.LogLevel, "Log level for the storage provider")
 	fs.StringVar(&o.LogFormat, prefix+"log-format", o.LogFormat, "Log format for the storage provider")
+	fs.StringVar(&o.ValueCodec, prefix+"value-codec", o.ValueCodec, "Codec for values written to storage (json or binary). Upgrade every node before enabling binary.")
 	o.Raft.BindFlags(prefix+"raft.", fs)
 	o.External.BindFlags(prefix+"external.", fs)
 }
@@ -105,6 +113,9 @@ func (o StorageOptions) Validate(isMember bool) error {
 	if !provider.IsValid() {
 		return fmt.Errorf("invalid storage provider: %s", o.Provider)
 	}
+	if _, err := storage.CodecByName(o.ValueCodec); err != nil {
+		return err
+	}
 	if provider == StorageProviderRaft {
 		if isMember {
 			if err := o.Raft.Validate(o.Path, o.InMemory); err != nil {
@@ -120,6 +131,16 @@ func (o StorageOptions) Validate(isMember bool) error {
 	return nil
 }
 
+// Codec returns the codec for values written to storage. Unknown codec
+// names are rejected by Validate and fall back to protojson.
+func (o StorageOptions) Codec() storage.Codec {
+	codec, err := storage.CodecByName(o.ValueCodec)
+	if err != nil {
+		return storage.ProtoJSONCodec
+	}
+	return codec
+}
+
 // ListenPort returns the port to listen on for the storage provider.
 func (o StorageOptions) ListenPort() int {
 	if o.Provider == string(StorageProviderRaft) || o.Provider == "" {
@@ -129,15 +150,28 @@ func (o StorageOptions) ListenPort() int {
 	return 0
 }
 
+// NewMux returns a connection muxer for serving raft and the gRPC API on the
+// same port, or nil if multiplexing is not enabled. The muxer should be passed
+// to both NewStorageProvider and NewServiceOptions.
+func (o *Config) NewMux() (*tcp.Mux, error) {
+	if !o.Storage.Raft.Multiplex || !o.IsStorageMember() {
+		return nil, nil
+	}
+	return tcp.ListenMux(o.Storage.Raft.ListenAddress)
+}
+
 // NewStorageProvider creates a new storage provider from the given options. If not a storage providing member, a node dialer
-// is required for the passthrough storage provider.
-func (o *Config) NewStorageProvider(ctx context.Context, node meshnode.Node, force bool) (storage.Provider, error) {
+// is required for the passthrough storage provider. The mux is optional and is used by the raft transport when multiplexing.
+func (o *Config) NewStorageProvider(ctx context.Context, node meshnode.Node, mux *tcp.Mux, force bool) (storage.Provider, error) {
+	if _, err := storage.CodecByName(o.Storage.ValueCodec); err != nil {
+		return nil, err
+	}
 	if !o.IsStorageMember() {
 		return passthroughstorage.NewProvider(o.Storage.NewPassthroughOptions(ctx, node)), nil
 	}
 	switch StorageProvider(o.Storage.Provider) {
 	case StorageProviderRaft, "":
-		return o.Storage.NewRaftStorageProvider(ctx, node, force)
+		return o.Storage.NewRaftStorageProvider(ctx, node, mux, force)
 	case StorageProviderExternal:
 		return o.Storage.NewExternalStorageProvider(ctx, node.ID())
 	case StorageProviderPassThrough:
@@ -148,8 +182,8 @@ func (o *Config) NewStorageProvider(ctx context.Context, node meshnode.Node, for
 }
 
 // NewRaftStorageProvider returns a new raftstorage provider for the current configuration.
-func (o StorageOptions) NewRaftStorageProvider(ctx context.Context, node meshnode.Node, force bool) (storage.Provider, error) {
-	opts, err := o.NewRaftOptions(ctx, node, force)
+func (o StorageOptions) NewRaftStorageProvider(ctx context.Context, node meshnode.Node, mux *tcp.Mux, force bool) (storage.Provider, error) {
+	opts, err := o.NewRaftOptions(ctx, node, mux, force)
 	if err != nil {
 		return nil, err
 	}
@@ -166,8 +200,8 @@ func (o StorageOptions) NewExternalStorageProvider(ctx context.Context, nodeID t
 }
 
 // NewRaftOptions returns a new raft options for the current configuration.
-func (o StorageOptions) NewRaftOptions(ctx context.Context, node meshnode.Node, force bool) (raftstorage.Options, error) {
-	raftTransport, err := o.Raft.NewTransport(node)
+func (o StorageOptions) NewRaftOptions(ctx context.Context, node meshnode.Node, mux *tcp.Mux, force bool) (raftstorage.Options, error) {
+	raftTransport, err := o.Raft.NewTransport(node, mux)
 	if err != nil {
 		return raftstorage.Options{}, fmt.Errorf("create raft transport: %w", err)
 	}
@@ -187,6 +221,16 @@ func (o StorageOptions) NewRaftOptions(ctx context.Context, node meshnode.Node,
 	opts.SnapshotThreshold = o.Raft.SnapshotThreshold
 	opts.SnapshotRetention = o.Raft.SnapshotRetention
 	opts.ObserverChanBuffer = o.Raft.ObserverChanBuffer
+	opts.MinFreeDiskSpace = o.Raft.MinFreeDiskSpace
+	opts.DiskSpaceCheckInterval = o.Raft.DiskSpaceCheckInterval
+	opts.RecordMetrics = o.Raft.RecordMetrics
+	opts.SnapshotEncryptionKey, err = o.Raft.LoadSnapshotEncryptionKey()
+	if err != nil {
+		return raftstorage.Options{}, err
+	}
+	opts.SnapshotSensitivePrefixes = o.Raft.SnapshotSensitivePrefixes
+	opts.AllowSnapshotRestore = o.Raft.AllowSnapshotRestore
+	opts.Codec = o.Codec()
 	opts.LogLevel = o.LogLevel
 	opts.LogFormat = o.LogFormat
 	return opts, nil
@@ -196,6 +240,7 @@ func (o StorageOptions) NewRaftOptions(ctx context.Context, node meshnode.Node,
 func (o StorageOptions) NewPassthroughOptions(ctx context.Context, node meshnode.Node) passthroughstorage.Options {
 	return passthroughstorage.Options{
 		Dialer:    node,
+		Codec:     o.Codec(),
 		LogLevel:  o.LogLevel,
 		LogFormat: o.LogFormat,
 	}
@@ -206,6 +251,7 @@ func (o StorageOptions) NewExternalStorageOptions(ctx context.Context, nodeID ty
 	opts := extstorage.Options{
 		NodeID:    nodeID,
 		Server:    o.External.Server,
+		Codec:     o.Codec(),
 		LogLevel:  o.LogLevel,
 		LogFormat: o.LogFormat,
 	}
@@ -19,21 +19,27 @@ package config
 import (
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
 
+	"github.com/webmeshproj/webmesh/pkg/clock"
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 )
 
 // WireGuardOptions are options for configuring the WireGuard interface.
 type WireGuardOptions struct {
-	// ListenPort is the port to listen on.
+	// ListenPort is the port to listen on. Set this to 0 to select an
+	// available port automatically.
 	ListenPort int `koanf:"listen-port,omitempty"`
 	// Modprobe attempts to load the wireguard kernel module on linux systems.
 	Modprobe bool `koanf:"modprobe,omitempty"`
@@ -44,6 +50,19 @@ type WireGuardOptions struct {
 	ForceInterfaceName bool `koanf:"force-interface-name,omitempty"`
 	// ForceTUN forces the use of a TUN interface.
 	ForceTUN bool `koanf:"force-tun,omitempty"`
+	// Preflight checks that the host has the capabilities needed to create
+	// the interface before starting, and fails startup if it does not.
+	Preflight bool `koanf:"preflight,omitempty"`
+	// EnableIPForwarding enables the IP forwarding sysctls on startup and
+	// fails if they cannot be set.
+	EnableIPForwarding bool `koanf:"enable-ip-forwarding,omitempty"`
+	// RestoreIPForwarding restores the IP forwarding sysctls to their
+	// previous values on shutdown.
+	RestoreIPForwarding bool `koanf:"restore-ip-forwarding,omitempty"`
+	// Implementation is the WireGuard implementation to use. One of "kernel", "userspace",
+	// or "auto". Auto uses the kernel module and falls back to userspace wireguard-go when
+	// the module is unavailable.
+	Implementation string `koanf:"implementation,omitempty"`
 	// Masquerade enables masquerading of traffic from the wireguard interface.
 	Masquerade bool `koanf:"masquerade,omitempty"`
 	// PersistentKeepAlive is the interval at which to send keepalive packets
@@ -67,44 +86,124 @@ type WireGuardOptions struct {
 	RecordMetricsInterval time.Duration `koanf:"record-metrics-interval,omitempty"`
 	// DisableFullTunnel will ignore routes for a default gateway.
 	DisableFullTunnel bool `koanf:"disable-full-tunnel,omitempty"`
+	// FirewallBackend is the firewall backend to use. One of "nftables" or "iptables".
+	// If unset, nftables is preferred when available.
+	FirewallBackend string `koanf:"firewall-backend,omitempty"`
+	// RestrictControlPlane restricts the storage and gRPC ports to traffic arriving
+	// over the WireGuard interface. Nodes will not be able to join through this node
+	// over a public address when enabled.
+	RestrictControlPlane bool `koanf:"restrict-control-plane,omitempty"`
+	// FwMark is the firewall mark to set on packets sent by WireGuard.
+	FwMark int `koanf:"fwmark,omitempty"`
+	// RoutingTable is the routing table to install mesh routes into. Policy
+	// rules are added to send traffic through the table, excluding packets
+	// carrying FwMark. If unset, routes are added to the main table.
+	RoutingTable int `koanf:"routing-table,omitempty"`
+	// EqualCostMultipath will route traffic for a destination through every peer
+	// advertising it with the lowest metric instead of a single preferred peer.
+	EqualCostMultipath bool `koanf:"equal-cost-multipath,omitempty"`
+	// MaxAllowedIPs is the maximum number of allowed IPs to configure for a single peer.
+	// Peers are not updated when it would be exceeded. Set to 0 for no limit.
+	MaxAllowedIPs int `koanf:"max-allowed-ips,omitempty"`
+	// SummarizeAllowedIPs aggregates adjacent and overlapping prefixes in each peer's
+	// allowed IPs. This keeps peers below MaxAllowedIPs on large meshes.
+	SummarizeAllowedIPs bool `koanf:"summarize-allowed-ips,omitempty"`
+	// InterfaceWatchMode is how to watch for the interface being deleted by something
+	// outside of webmesh. One of "poll", "netlink", or "disabled". The interface is
+	// recreated and reconfigured when it disappears.
+	InterfaceWatchMode string `koanf:"interface-watch-mode,omitempty"`
+	// InterfaceWatchInterval is the interval at which to poll for the interface.
+	InterfaceWatchInterval time.Duration `koanf:"interface-watch-interval,omitempty"`
+	// HostOverlapCheck is what to do when the mesh networks overlap addresses or routes already
+	// configured on the host. One of "warn", "error", or "disabled".
+	HostOverlapCheck string `koanf:"host-overlap-check,omitempty"`
+	// AcceptRoamedEndpoints accepts endpoints learned by WireGuard when a peer roams.
+	// Accepted endpoints are stored by the leader so that other nodes use them as well.
+	AcceptRoamedEndpoints bool `koanf:"accept-roamed-endpoints,omitempty"`
+	// RoamingAllowedPrefixes restricts accepted roamed endpoints to these prefixes.
+	// Any unicast address outside of the mesh networks is accepted when empty.
+	RoamingAllowedPrefixes []string `koanf:"roaming-allowed-prefixes,omitempty"`
+	// RoamingInterval is the interval at which to check peers for roamed endpoints.
+	RoamingInterval time.Duration `koanf:"roaming-interval,omitempty"`
 
 	// loaded is an already loaded key from the configuration.
 	loaded crypto.PrivateKey `koanf:"-"`
+	// clock is the clock used to check key expiry.
+	clock clock.Clock `koanf:"-"`
 }
 
 // NewWireGuardOptions returns a new WireGuardOptions with sensible defaults.
 func NewWireGuardOptions() WireGuardOptions {
 	return WireGuardOptions{
-		ListenPort:            wireguard.DefaultListenPort,
-		Modprobe:              false,
-		InterfaceName:         wireguard.DefaultInterfaceName,
-		ForceInterfaceName:    false,
-		ForceTUN:              false,
-		Masquerade:            false,
-		PersistentKeepAlive:   0,
-		MTU:                   system.DefaultMTU,
-		Endpoints:             nil,
-		KeyFile:               "",
-		KeyRotationInterval:   time.Hour * 24 * 7,
-		RecordMetrics:         false,
-		RecordMetricsInterval: time.Second * 10,
-		DisableFullTunnel:     false,
+		ListenPort:             wireguard.DefaultListenPort,
+		Modprobe:               false,
+		InterfaceName:          wireguard.DefaultInterfaceName,
+		ForceInterfaceName:     false,
+		ForceTUN:               false,
+		Preflight:              false,
+		EnableIPForwarding:     false,
+		RestoreIPForwarding:    false,
+		Implementation:         string(wireguard.ImplementationAuto),
+		Masquerade:             false,
+		PersistentKeepAlive:    0,
+		MTU:                    system.DefaultMTU,
+		Endpoints:              nil,
+		KeyFile:                "",
+		KeyRotationInterval:    time.Hour * 24 * 7,
+		RecordMetrics:          false,
+		RecordMetricsInterval:  time.Second * 10,
+		DisableFullTunnel:      false,
+		EqualCostMultipath:     false,
+		MaxAllowedIPs:          0,
+		SummarizeAllowedIPs:    false,
+		InterfaceWatchMode:     string(meshnet.InterfaceWatchPoll),
+		InterfaceWatchInterval: meshnet.DefaultInterfaceWatchInterval,
+		HostOverlapCheck:       string(endpoints.OverlapCheckWarn),
+		AcceptRoamedEndpoints:  false,
+		RoamingAllowedPrefixes: nil,
+		RoamingInterval:        meshnet.DefaultRoamingInterval,
 	}
 }
 
+// NewRoamingOptions returns the options for accepting roamed peer endpoints.
+func (o *WireGuardOptions) NewRoamingOptions() (meshnet.RoamingOptions, error) {
+	opts := meshnet.RoamingOptions{
+		Enabled:  o.AcceptRoamedEndpoints,
+		Interval: o.RoamingInterval,
+	}
+	for _, prefix := range o.RoamingAllowedPrefixes {
+		p, err := netip.ParsePrefix(prefix)
+		if err != nil {
+			return opts, fmt.Errorf("wireguard.roaming-allowed-prefixes: invalid prefix %q: %w", prefix, err)
+		}
+		opts.AllowedPrefixes = append(opts.AllowedPrefixes, p)
+	}
+	return opts, nil
+}
+
 // SetKey is a convenience method for setting a preloaded key to these wireguard options
 // so that calls to LoadKey will return the preloaded key.
 func (o *WireGuardOptions) SetKey(key crypto.PrivateKey) {
 	o.loaded = key
 }
 
+// SetClock sets the clock used by LoadKey to check if the key file has
+// expired. The real clock is used by default.
+func (o *WireGuardOptions) SetClock(clk clock.Clock) {
+	o.clock = clk
+}
+
 // BindFlags binds the flags.
 func (o *WireGuardOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
-	fs.IntVar(&o.ListenPort, prefix+"listen-port", o.ListenPort, "The port to listen on.")
+	fs.IntVar(&o.ListenPort, prefix+"listen-port", o.ListenPort, "The port to listen on. Set to 0 to select an available port.")
 	fs.BoolVar(&o.Modprobe, prefix+"modprobe", o.Modprobe, "Attempt to load the wireguard kernel module on linux systems.")
 	fs.StringVar(&o.InterfaceName, prefix+"interface-name", o.InterfaceName, "The name of the interface.")
 	fs.BoolVar(&o.ForceInterfaceName, prefix+"force-interface-name", o.ForceInterfaceName, "Force the use of the given name by deleting any pre-existing interface with the same name.")
 	fs.BoolVar(&o.ForceTUN, prefix+"force-tun", o.ForceTUN, "Force the use of a TUN interface.")
+	fs.BoolVar(&o.Preflight, prefix+"preflight", o.Preflight, "Check host capabilities before creating the interface and fail startup if critical checks fail.")
+	fs.BoolVar(&o.EnableIPForwarding, prefix+"enable-ip-forwarding", o.EnableIPForwarding, "Enable the IP forwarding sysctls on startup and fail if they cannot be set.")
+	fs.BoolVar(&o.RestoreIPForwarding, prefix+"restore-ip-forwarding", o.RestoreIPForwarding, "Restore the IP forwarding sysctls to their previous values on shutdown.")
+	fs.StringVar(&o.Implementation, prefix+"implementation", o.Implementation, "The WireGuard implementation to use (kernel, userspace, or auto).")
 	fs.BoolVar(&o.Masquerade, prefix+"masquerade", o.Masquerade, "Enable masquerading of traffic from the wireguard interface.")
 	fs.DurationVar(&o.PersistentKeepAlive, prefix+"persistent-keepalive", o.PersistentKeepAlive, "The interval at which to send keepalive packets to peers.")
 	fs.IntVar(&o.MTU, prefix+"mtu", o.MTU, "The MTU to use for the interface.")
@@ -114,12 +213,25 @@ func (o *WireGuardOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.RecordMetrics, prefix+"record-metrics", o.RecordMetrics, "Record WireGuard metrics. These are only exposed if the metrics server is enabled.")
 	fs.DurationVar(&o.RecordMetricsInterval, prefix+"record-metrics-interval", o.RecordMetricsInterval, "The interval at which to update WireGuard metrics.")
 	fs.BoolVar(&o.DisableFullTunnel, prefix+"disable-full-tunnel", o.DisableFullTunnel, "Ignore routes for a default gateway.")
+	fs.StringVar(&o.FirewallBackend, prefix+"firewall-backend", o.FirewallBackend, "The firewall backend to use (nftables or iptables). Auto-detected if unset.")
+	fs.BoolVar(&o.RestrictControlPlane, prefix+"restrict-control-plane", o.RestrictControlPlane, "Only allow storage and gRPC traffic arriving over the WireGuard interface.")
+	fs.IntVar(&o.FwMark, prefix+"fwmark", o.FwMark, "The firewall mark to set on packets sent by WireGuard.")
+	fs.IntVar(&o.RoutingTable, prefix+"routing-table", o.RoutingTable, "The routing table to install mesh routes into. Uses the main table if unset.")
+	fs.BoolVar(&o.EqualCostMultipath, prefix+"equal-cost-multipath", o.EqualCostMultipath, "Use every peer tied for the lowest route metric instead of a single preferred peer.")
+	fs.IntVar(&o.MaxAllowedIPs, prefix+"max-allowed-ips", o.MaxAllowedIPs, "The maximum number of allowed IPs to configure for a single peer. Set to 0 for no limit.")
+	fs.BoolVar(&o.SummarizeAllowedIPs, prefix+"summarize-allowed-ips", o.SummarizeAllowedIPs, "Aggregate adjacent and overlapping prefixes in each peer's allowed IPs.")
+	fs.StringVar(&o.InterfaceWatchMode, prefix+"interface-watch-mode", o.InterfaceWatchMode, "How to watch for the interface being deleted (poll, netlink, or disabled).")
+	fs.DurationVar(&o.InterfaceWatchInterval, prefix+"interface-watch-interval", o.InterfaceWatchInterval, "The interval at which to poll for the interface.")
+	fs.StringVar(&o.HostOverlapCheck, prefix+"host-overlap-check", o.HostOverlapCheck, "What to do when the mesh networks overlap addresses or routes on the host (warn, error, or disabled).")
+	fs.BoolVar(&o.AcceptRoamedEndpoints, prefix+"accept-roamed-endpoints", o.AcceptRoamedEndpoints, "Accept endpoints learned by WireGuard when a peer roams and store them for other nodes.")
+	fs.StringSliceVar(&o.RoamingAllowedPrefixes, prefix+"roaming-allowed-prefixes", o.RoamingAllowedPrefixes, "Only accept roamed endpoints within these prefixes.")
+	fs.DurationVar(&o.RoamingInterval, prefix+"roaming-interval", o.RoamingInterval, "The interval at which to check peers for roamed endpoints.")
 }
 
 // Validate validates the options.
 func (o *WireGuardOptions) Validate() error {
-	if o.ListenPort <= 1024 {
-		return fmt.Errorf("wireguard.listen-port must be greater than 1024")
+	if o.ListenPort != 0 && (o.ListenPort <= 1024 || o.ListenPort > 65535) {
+		return fmt.Errorf("wireguard.listen-port must be 0 or between 1025 and 65535")
 	}
 	if o.InterfaceName == "" {
 		return fmt.Errorf("wireguard.interface-name must be set")
@@ -130,6 +242,42 @@ func (o *WireGuardOptions) Validate() error {
 	if o.KeyRotationInterval < 0 {
 		return fmt.Errorf("wireguard.key-rotation-interval must be greater than or equal to 0")
 	}
+	if !wireguard.Implementation(o.Implementation).IsValid() {
+		return fmt.Errorf("wireguard.implementation must be one of kernel, userspace, or auto")
+	}
+	if o.RestoreIPForwarding && !o.EnableIPForwarding {
+		return fmt.Errorf("wireguard.restore-ip-forwarding requires wireguard.enable-ip-forwarding")
+	}
+	if o.ForceTUN && o.Implementation == string(wireguard.ImplementationKernel) {
+		return fmt.Errorf("wireguard.force-tun cannot be used with the kernel implementation")
+	}
+	if !firewall.Backend(o.FirewallBackend).IsValid() {
+		return fmt.Errorf("wireguard.firewall-backend must be one of nftables or iptables")
+	}
+	if o.FwMark < 0 {
+		return fmt.Errorf("wireguard.fwmark must be greater than or equal to 0")
+	}
+	if o.RoutingTable < 0 {
+		return fmt.Errorf("wireguard.routing-table must be greater than or equal to 0")
+	}
+	if o.MaxAllowedIPs < 0 {
+		return fmt.Errorf("wireguard.max-allowed-ips must be greater than or equal to 0")
+	}
+	if !meshnet.InterfaceWatchMode(o.InterfaceWatchMode).IsValid() {
+		return fmt.Errorf("wireguard.interface-watch-mode must be one of poll, netlink, or disabled")
+	}
+	if meshnet.InterfaceWatchMode(o.InterfaceWatchMode).Enabled() && o.InterfaceWatchInterval <= 0 {
+		return fmt.Errorf("wireguard.interface-watch-interval must be greater than 0")
+	}
+	if o.AcceptRoamedEndpoints && o.RoamingInterval <= 0 {
+		return fmt.Errorf("wireguard.roaming-interval must be greater than 0")
+	}
+	if _, err := o.NewRoamingOptions(); err != nil {
+		return err
+	}
+	if !endpoints.OverlapCheckMode(o.HostOverlapCheck).IsValid() {
+		return fmt.Errorf("wireguard.host-overlap-check must be one of warn, error, or disabled")
+	}
 	if o.RecordMetrics {
 		if o.RecordMetricsInterval < 0 {
 			return fmt.Errorf("wireguard.record-metrics-interval must be greater than 0")
@@ -138,6 +286,22 @@ func (o *WireGuardOptions) Validate() error {
 	return nil
 }
 
+// ResolveListenPort selects an available listen port if one was not configured.
+// The selected port is stored in the options so that it is advertised to peers.
+// It is a no-op when a port is already set.
+func (o *WireGuardOptions) ResolveListenPort(ctx context.Context) error {
+	if o.ListenPort != 0 {
+		return nil
+	}
+	port, err := wireguard.SelectListenPort(0)
+	if err != nil {
+		return fmt.Errorf("select wireguard listen port: %w", err)
+	}
+	context.LoggerFrom(ctx).Info("Selected WireGuard listen port", slog.Int("port", port))
+	o.ListenPort = port
+	return nil
+}
+
 // LoadKey loads the key from the given configuration.
 func (o *WireGuardOptions) LoadKey(ctx context.Context) (crypto.PrivateKey, error) {
 	log := context.LoggerFrom(ctx)
@@ -177,7 +341,7 @@ func (o *WireGuardOptions) LoadKey(ctx context.Context) (crypto.PrivateKey, erro
 	}
 	// Check if the key is expired
 	if o.KeyRotationInterval > 0 {
-		if stat.ModTime().Add(o.KeyRotationInterval).Before(time.Now()) {
+		if stat.ModTime().Add(o.KeyRotationInterval).Before(clock.OrReal(o.clock).Now()) {
 			// Delete the key file if it's older than the key rotation interval.
 			log.Debug("Removing expired WireGuard key file", slog.String("file", o.KeyFile))
 			if err := os.Remove(o.KeyFile); err != nil {
@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/clock"
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestWireGuardKeyExpiry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	clk := clock.NewFake(time.Now())
+
+	loadKey := func() string {
+		t.Helper()
+		opts := NewWireGuardOptions()
+		opts.KeyFile = keyFile
+		opts.KeyRotationInterval = time.Hour
+		opts.SetClock(clk)
+		key, err := opts.LoadKey(ctx)
+		if err != nil {
+			t.Fatalf("load key: %v", err)
+		}
+		return key.ID()
+	}
+
+	first := loadKey()
+	clk.Advance(59 * time.Minute)
+	if got := loadKey(); got != first {
+		t.Fatalf("expected key to be reused before it expires, got %s", got)
+	}
+	clk.Advance(2 * time.Minute)
+	rotated := loadKey()
+	if rotated == first {
+		t.Fatal("expected expired key to be rotated")
+	}
+	// The rotated key is written with the real modification time, so it is
+	// only considered fresh once the clock catches up with the file.
+	clk.Set(time.Now())
+	if got := loadKey(); got != rotated {
+		t.Errorf("expected rotated key to be reused, got %s", got)
+	}
+}
@@ -72,11 +72,15 @@ func WithLogger(ctx Context, logger Logger) Context {
 }
 
 // LoggerFrom returns the logger from the context. If no logger is set, the
-// default logger is returned.
+// default logger is returned. If the context carries a request ID, it is
+// included in the returned logger.
 func LoggerFrom(ctx Context) Logger {
 	logger, ok := ctx.Value(logContextKey{}).(*slog.Logger)
 	if !ok {
-		return slog.Default()
+		logger = slog.Default()
+	}
+	if id, ok := RequestIDFrom(ctx); ok {
+		return withRequestIDAttr(logger, id)
 	}
 	return logger
 }
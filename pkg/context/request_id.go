@@ -0,0 +1,142 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RequestIDMeta is the metadata key used to propagate request IDs
+	// between nodes and plugins.
+	RequestIDMeta = "x-webmesh-request-id"
+	// RequestIDLogKey is the log attribute request IDs are recorded under.
+	RequestIDLogKey = "request-id"
+	// maxRequestIDLength is the longest request ID accepted from a caller.
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context with the given request ID set. Loggers
+// returned by LoggerFrom for the context and any context derived from it
+// will include the request ID.
+func WithRequestID(ctx Context, id string) Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID from the context.
+func RequestIDFrom(ctx Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// NewRequestID generates a new random request ID.
+func NewRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// RequestIDUnaryServerInterceptor returns a unary server interceptor that
+// attaches a request ID to the context. The ID is taken from the incoming
+// metadata when present, so requests proxied between nodes keep their ID,
+// otherwise a new one is generated.
+func RequestIDUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		return handler(withIncomingRequestID(ctx), req)
+	}
+}
+
+// RequestIDStreamServerInterceptor returns a stream server interceptor that
+// attaches a request ID to the context.
+func RequestIDStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &requestIDServerStream{ss, withIncomingRequestID(ss.Context())})
+	}
+}
+
+// RequestIDUnaryClientInterceptor returns a unary client interceptor that
+// propagates the request ID in the context to the server.
+func RequestIDUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(AppendRequestIDToOutgoingContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// AppendRequestIDToOutgoingContext returns a context with the request ID,
+// if any, added to the outgoing gRPC metadata.
+func AppendRequestIDToOutgoingContext(ctx Context) Context {
+	id, ok := RequestIDFrom(ctx)
+	if !ok {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIDMeta)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDMeta, id)
+}
+
+func withIncomingRequestID(ctx Context) Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ids := md.Get(RequestIDMeta)
+		if len(ids) > 0 && ids[0] != "" && len(ids[0]) <= maxRequestIDLength {
+			return WithRequestID(ctx, ids[0])
+		}
+	}
+	return WithRequestID(ctx, NewRequestID())
+}
+
+type requestIDServerStream struct {
+	grpc.ServerStream
+	ctx Context
+}
+
+func (ss *requestIDServerStream) Context() Context {
+	return ss.ctx
+}
+
+// requestIDHandler marks a handler as already carrying a request ID so
+// it is only added once, no matter how the logger is derived.
+type requestIDHandler struct {
+	slog.Handler
+	id string
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs), h.id}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name), h.id}
+}
+
+func withRequestIDAttr(logger Logger, id string) Logger {
+	if h, ok := logger.Handler().(requestIDHandler); ok && h.id == id {
+		return logger
+	}
+	return slog.New(requestIDHandler{
+		Handler: logger.Handler().WithAttrs([]slog.Attr{slog.String(RequestIDLogKey, id)}),
+		id:      id,
+	})
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDLogging(t *testing.T) {
+	t.Parallel()
+
+	// deepCall mimics a service replacing the context logger with its own
+	// component logger before calling further down the stack.
+	deepCall := func(ctx Context, component Logger) {
+		ctx = WithLogger(ctx, component.With("op", "join"))
+		func(ctx Context) {
+			LoggerFrom(ctx).With("storage", "put").Info("deep log")
+		}(ctx)
+	}
+
+	tc := []struct {
+		name     string
+		incoming string
+	}{
+		{name: "Generated"},
+		{name: "FromMetadata", incoming: "proxied-request-id"},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			base := slog.New(slog.NewJSONHandler(&buf, nil))
+			ctx := context.Background()
+			if tt.incoming != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIDMeta, tt.incoming))
+			}
+			var requestID string
+			handler := func(ctx context.Context, req any) (any, error) {
+				id, ok := RequestIDFrom(ctx)
+				if !ok {
+					t.Fatal("expected request id in context")
+				}
+				requestID = id
+				deepCall(ctx, base.With("component", "membership-server"))
+				return nil, nil
+			}
+			chain := []grpc.UnaryServerInterceptor{
+				LogInjectUnaryServerInterceptor(base),
+				RequestIDUnaryServerInterceptor(),
+			}
+			_, err := chain[0](ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+				return chain[1](ctx, req, &grpc.UnaryServerInfo{}, handler)
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.incoming != "" && requestID != tt.incoming {
+				t.Errorf("expected request id %q, got %q", tt.incoming, requestID)
+			}
+			line := strings.TrimSpace(buf.String())
+			if strings.Count(line, `"`+RequestIDLogKey+`"`) != 1 {
+				t.Fatalf("expected request id exactly once in log, got %s", line)
+			}
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("unmarshal log entry: %v", err)
+			}
+			if entry[RequestIDLogKey] != requestID {
+				t.Errorf("expected request id %q in log, got %v", requestID, entry[RequestIDLogKey])
+			}
+			if entry["component"] != "membership-server" || entry["storage"] != "put" {
+				t.Errorf("expected logger attributes to be preserved, got %v", entry)
+			}
+		})
+	}
+
+	t.Run("OutgoingMetadata", func(t *testing.T) {
+		t.Parallel()
+		ctx := AppendRequestIDToOutgoingContext(WithRequestID(context.Background(), "abc"))
+		// Appending twice should not duplicate the ID.
+		ctx = AppendRequestIDToOutgoingContext(ctx)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if ids := md.Get(RequestIDMeta); len(ids) != 1 || ids[0] != "abc" {
+			t.Errorf("expected outgoing request id abc, got %v", ids)
+		}
+	})
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/services"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
@@ -98,9 +99,17 @@ func NewNode(ctx context.Context, opts Options) (Node, error) {
 		return nil, fmt.Errorf("failed to create mesh config: %w", err)
 	}
 	meshConn := meshnode.NewWithLogger(log, meshConfig)
+	// Create the connection muxer if raft and gRPC share a port
+	mux, err := config.NewMux()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection muxer: %w", err)
+	}
 	// Create a storage provider
-	storageProvider, err := config.NewStorageProvider(ctx, meshConn, config.Bootstrap.Force)
+	storageProvider, err := config.NewStorageProvider(ctx, meshConn, mux, config.Bootstrap.Force)
 	if err != nil {
+		if mux != nil {
+			_ = mux.Close()
+		}
 		return nil, fmt.Errorf("failed to create storage provider: %w", err)
 	}
 	return &node{
@@ -108,6 +117,7 @@ func NewNode(ctx context.Context, opts Options) (Node, error) {
 		conf:    config,
 		log:     log,
 		mesh:    meshConn,
+		mux:     mux,
 		storage: storageProvider,
 		errs:    make(chan error, 1),
 	}, nil
@@ -118,6 +128,7 @@ type node struct {
 	conf     *config.Config
 	log      *slog.Logger
 	mesh     meshnode.Node
+	mux      *tcp.Mux
 	storage  storage.Provider
 	services *services.Server
 	meshdns  *meshdns.Server
@@ -193,7 +204,7 @@ func (n *node) Start(ctx context.Context) error {
 	}
 	log.Info("Webmesh connection is ready")
 	// Start the mesh services
-	srvOpts, err := n.conf.Services.NewServiceOptions(ctx, n.MeshNode())
+	srvOpts, err := n.conf.Services.NewServiceOptions(ctx, n.MeshNode(), n.mux)
 	if err != nil {
 		return handleErr(fmt.Errorf("failed to create service options: %w", err))
 	}
@@ -218,6 +229,18 @@ func (n *node) Start(ctx context.Context) error {
 		if err != nil {
 			return handleErr(fmt.Errorf("failed to register APIs: %w", err))
 		}
+		if n.conf.Services.API.BindMeshAddress {
+			var addrs []netip.Addr
+			for _, addr := range []netip.Prefix{n.AddressV4(), n.AddressV6()} {
+				if addr.IsValid() {
+					addrs = append(addrs, addr.Addr())
+				}
+			}
+			err = n.services.BindMeshAddresses(addrs...)
+			if err != nil {
+				return handleErr(fmt.Errorf("failed to bind API to mesh addresses: %w", err))
+			}
+		}
 	}
 	go func() {
 		if err := n.services.ListenAndServe(); err != nil {
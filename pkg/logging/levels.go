@@ -0,0 +1,165 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ComponentKey is the attribute key loggers use to name their subsystem.
+const ComponentKey = "component"
+
+// Levels is the registry of per-component log levels consulted by loggers
+// created with NewLogger.
+var Levels = NewLevelRegistry()
+
+// LevelRegistry holds log level overrides for individual components. An
+// override for a component also applies to any component it is a prefix of,
+// so "raft" matches "raftstorage". The longest matching override wins.
+type LevelRegistry struct {
+	levels map[string]slog.Level
+	mu     sync.RWMutex
+}
+
+// NewLevelRegistry returns a new empty LevelRegistry.
+func NewLevelRegistry() *LevelRegistry {
+	return &LevelRegistry{levels: make(map[string]slog.Level)}
+}
+
+// SetLevel overrides the log level for the given component.
+func (r *LevelRegistry) SetLevel(component string, level slog.Level) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.levels[component] = level
+}
+
+// ResetLevel removes any override for the given component.
+func (r *LevelRegistry) ResetLevel(component string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.levels, component)
+}
+
+// Level returns the overridden level for the given component, if any.
+func (r *LevelRegistry) Level(component string) (slog.Level, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var match string
+	var level slog.Level
+	var found bool
+	for prefix, lvl := range r.levels {
+		if strings.HasPrefix(component, prefix) && (!found || len(prefix) > len(match)) {
+			match, level, found = prefix, lvl, true
+		}
+	}
+	return level, found
+}
+
+// Overrides returns a copy of the current overrides.
+func (r *LevelRegistry) Overrides() map[string]slog.Level {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]slog.Level, len(r.levels))
+	for component, level := range r.levels {
+		out[component] = level
+	}
+	return out
+}
+
+// Components returns the components with overrides in sorted order.
+func (r *LevelRegistry) Components() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.levels))
+	for component := range r.levels {
+		out = append(out, component)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseLevel parses a log level name.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q", level)
+	}
+}
+
+// NewComponentHandler returns a handler that filters records by the level
+// registered for the logger's component, falling back to the given level.
+// The wrapped handler should accept every level.
+func NewComponentHandler(h slog.Handler, level slog.Leveler, registry *LevelRegistry) slog.Handler {
+	return &componentHandler{handler: h, level: level, registry: registry}
+}
+
+type componentHandler struct {
+	handler   slog.Handler
+	level     slog.Leveler
+	registry  *LevelRegistry
+	component string
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if h.component != "" {
+		if override, ok := h.registry.Level(h.component); ok {
+			return level >= override
+		}
+	}
+	return level >= h.level.Level()
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	component := h.component
+	for _, attr := range attrs {
+		if attr.Key == ComponentKey {
+			component = attr.Value.String()
+		}
+	}
+	return &componentHandler{
+		handler:   h.handler.WithAttrs(attrs),
+		level:     h.level,
+		registry:  h.registry,
+		component: component,
+	}
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{
+		handler:   h.handler.WithGroup(name),
+		level:     h.level,
+		registry:  h.registry,
+		component: h.component,
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestComponentLevels(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	registry := NewLevelRegistry()
+	handler := NewComponentHandler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), slog.LevelInfo, registry)
+	root := slog.New(handler)
+	raft := root.With(ComponentKey, "raftstorage")
+	meshnet := root.With(ComponentKey, "meshnet")
+	mesh := root.With(ComponentKey, "mesh-server")
+
+	logAll := func() string {
+		buf.Reset()
+		for _, log := range []*slog.Logger{raft, meshnet, mesh} {
+			log.Debug("debug message")
+			log.Info("info message")
+		}
+		return buf.String()
+	}
+	countFor := func(out, component, msg string) int {
+		var n int
+		for _, line := range strings.Split(out, "\n") {
+			if strings.Contains(line, "component="+component) && strings.Contains(line, msg) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Nothing logs at debug by default.
+	out := logAll()
+	if strings.Contains(out, "debug message") {
+		t.Errorf("expected no debug logs by default, got:\n%s", out)
+	}
+
+	// Turning on debug for one component leaves the others quiet.
+	registry.SetLevel("meshnet", slog.LevelDebug)
+	registry.SetLevel("raft", slog.LevelWarn)
+	out = logAll()
+	if countFor(out, "meshnet", "debug message") != 1 {
+		t.Errorf("expected meshnet debug logs, got:\n%s", out)
+	}
+	if countFor(out, "mesh-server", "debug message") != 0 {
+		t.Errorf("expected mesh-server to stay quiet, got:\n%s", out)
+	}
+	if countFor(out, "mesh-server", "info message") != 1 {
+		t.Errorf("expected mesh-server info logs, got:\n%s", out)
+	}
+	// Prefixes match longer component names.
+	if countFor(out, "raftstorage", "info message") != 0 {
+		t.Errorf("expected raftstorage to only log warnings, got:\n%s", out)
+	}
+
+	// Child loggers and groups keep the component.
+	buf.Reset()
+	meshnet.WithGroup("peers").With("peer", "node-a").Debug("child debug message")
+	if countFor(buf.String(), "meshnet", "child debug message") != 1 {
+		t.Errorf("expected child logger to use the component level, got:\n%s", buf.String())
+	}
+
+	// Resetting restores the default level.
+	registry.ResetLevel("meshnet")
+	if out := logAll(); strings.Contains(out, "debug message") {
+		t.Errorf("expected no debug logs after reset, got:\n%s", out)
+	}
+	if got := registry.Components(); len(got) != 1 || got[0] != "raft" {
+		t.Errorf("expected only the raft override to remain, got %v", got)
+	}
+}
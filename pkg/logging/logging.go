@@ -33,39 +33,33 @@ func SetupLogging(logLevel string, format string) *slog.Logger {
 }
 
 // NewLogger returns a new logger with the given log level. Format can be one of "text" or "json".
-// If log level is empty or "silent" then the logger will be silent.
+// If log level is empty or "silent" then the logger will be silent. Otherwise levels
+// set for a component in Levels take precedence over the given level.
 func NewLogger(logLevel string, format string) *slog.Logger {
+	return newLogger(os.Stderr, logLevel, format)
+}
+
+func newLogger(w io.Writer, logLevel string, format string) *slog.Logger {
 	if logLevel == "" || strings.ToLower(logLevel) == "silent" {
 		return slog.New(slog.NewTextHandler(io.Discard, nil))
 	}
-	level := func() slog.Level {
-		switch strings.ToLower(logLevel) {
-		case "debug":
-			return slog.LevelDebug
-		case "info":
-			return slog.LevelInfo
-		case "warn":
-			return slog.LevelWarn
-		case "error":
-			return slog.LevelError
-		default:
-			slog.Default().Warn("Invalid log level specified, defaulting to info", "log-level", logLevel)
-		}
-		return slog.LevelInfo
-	}()
+	level, err := ParseLevel(logLevel)
+	if err != nil {
+		slog.Default().Warn("Invalid log level specified, defaulting to info", "log-level", logLevel)
+	}
+	// The component handler does the filtering so that per-component
+	// levels can be lower than the logger's own level.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
 	var handler slog.Handler
 	switch format {
 	case "text":
-		handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-			Level: level,
-		})
+		handler = slog.NewTextHandler(w, opts)
 	case "json":
 		fallthrough
 	default:
-		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
-			Level: level,
-		})
+		handler = slog.NewJSONHandler(w, opts)
 	}
+	handler = NewComponentHandler(handler, level, Levels)
 	log := slog.New(handler)
 	return log
 }
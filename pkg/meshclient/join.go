@@ -0,0 +1,340 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package meshclient contains helpers for clients interacting with a webmesh
+// cluster without running a full mesh node.
+package meshclient
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultRetryInterval is the default interval between join attempts.
+const DefaultRetryInterval = time.Second
+
+// JoinParams are the parameters for building and submitting a join request.
+type JoinParams struct {
+	// NodeID is the ID of the node joining the cluster.
+	NodeID types.NodeID
+	// Key is the private key of the node. If not set, the key is loaded
+	// from KeyFile or generated.
+	Key crypto.PrivateKey
+	// KeyFile is a path to load the private key from. If the file does
+	// not exist, a new key is generated and written to it.
+	KeyFile string
+	// Credentials are the gRPC dial options to use when joining. If
+	// empty, insecure credentials are used.
+	Credentials []grpc.DialOption
+	// AddressTimeout is the timeout for dialing the join address.
+	AddressTimeout time.Duration
+	// JoinToken is an optional token to attach to the request.
+	JoinToken string
+	// PrimaryEndpoint is the primary endpoint to advertise when joining.
+	PrimaryEndpoint netip.Addr
+	// WireGuardEndpoints are the WireGuard endpoints to advertise.
+	WireGuardEndpoints []netip.AddrPort
+	// ZoneAwarenessID is the zone awareness ID of the node.
+	ZoneAwarenessID string
+	// Labels are key/value labels to attach to the node. They are sent
+	// appended to the zone awareness ID.
+	Labels types.Labels
+	// DisableIPv4 disables requesting an IPv4 address.
+	DisableIPv4 bool
+	// DisableIPv6 disables IPv6 on the node.
+	DisableIPv6 bool
+	// PreferIPv6 requests IPv6 for storage traffic.
+	PreferIPv6 bool
+	// AsVoter requests to join as a voter.
+	AsVoter bool
+	// AsObserver requests to join as an observer.
+	AsObserver bool
+	// Routes are additional routes to advertise.
+	Routes []netip.Prefix
+	// ExitNode advertises the node as an exit node for the mesh.
+	ExitNode bool
+	// DirectPeers are peers to request direct edges to.
+	DirectPeers map[types.NodeID]v1.ConnectProtocol
+	// Features are the features to advertise.
+	Features []*v1.FeaturePort
+	// Multiaddrs are the multiaddrs to advertise.
+	Multiaddrs []multiaddr.Multiaddr
+	// MaxRetries is the maximum number of times to retry a failed join.
+	MaxRetries int
+	// RetryInterval is the interval between retries. Defaults to
+	// DefaultRetryInterval.
+	RetryInterval time.Duration
+}
+
+// LoadKey returns the configured key, loading or generating it as needed.
+func (p *JoinParams) LoadKey() (crypto.PrivateKey, error) {
+	if p.Key != nil {
+		return p.Key, nil
+	}
+	if p.KeyFile == "" {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, fmt.Errorf("generate key: %w", err)
+		}
+		p.Key = key
+		return key, nil
+	}
+	key, err := crypto.DecodePrivateKeyFromFile(p.KeyFile)
+	if err == nil {
+		p.Key = key
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load key file: %w", err)
+	}
+	key, err = crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	if err := crypto.EncodeKeyToFile(key, p.KeyFile); err != nil {
+		return nil, fmt.Errorf("save key: %w", err)
+	}
+	p.Key = key
+	return key, nil
+}
+
+// JoinResult is the parsed result of a successful join.
+type JoinResult struct {
+	// Key is the private key used for the join.
+	Key crypto.PrivateKey
+	// NodeID is the ID the node joined with.
+	NodeID types.NodeID
+	// MeshDomain is the domain of the mesh.
+	MeshDomain string
+	// AddressV4 is the IPv4 address assigned to the node.
+	AddressV4 netip.Prefix
+	// AddressV6 is the IPv6 address assigned to the node.
+	AddressV6 netip.Prefix
+	// NetworkV4 is the IPv4 network of the mesh.
+	NetworkV4 netip.Prefix
+	// NetworkV6 is the IPv6 network of the mesh.
+	NetworkV6 netip.Prefix
+	// Peers are the peers the node should connect to.
+	Peers []*v1.WireGuardPeer
+	// ICEServers are ICE servers that can be used for peers requiring them.
+	ICEServers []string
+	// DNSServers are the MeshDNS servers advertised in the response.
+	DNSServers []netip.AddrPort
+	// Features are the advertised features accepted by the cluster.
+	Features []v1.Feature
+	// UnsupportedFeatures are advertised features the cluster does not
+	// support. They were dropped from the node's registration.
+	UnsupportedFeatures []v1.Feature
+	// PresharedKeys are the WireGuard preshared keys negotiated with the
+	// node's peers, keyed by peer ID. It is empty when the cluster does not
+	// use preshared keys.
+	PresharedKeys map[types.NodeID]wgtypes.Key
+	// Response is the raw join response.
+	Response *v1.JoinResponse
+}
+
+// Join builds a join request from the given parameters and submits it to the
+// node at addr, retrying on failure.
+func Join(ctx context.Context, addr string, params JoinParams) (*JoinResult, error) {
+	creds := params.Credentials
+	if len(creds) == 0 {
+		creds = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	rt := tcp.NewJoinRoundTripper(tcp.RoundTripOptions{
+		Addrs:          []string{addr},
+		Credentials:    creds,
+		AddressTimeout: params.AddressTimeout,
+	})
+	defer rt.Close()
+	return JoinWithRoundTripper(ctx, rt, params)
+}
+
+// JoinWithRoundTripper is like Join but submits the request with the given
+// round tripper.
+func JoinWithRoundTripper(ctx context.Context, rt transport.JoinRoundTripper, params JoinParams) (*JoinResult, error) {
+	log := context.LoggerFrom(ctx)
+	key, err := params.LoadKey()
+	if err != nil {
+		return nil, err
+	}
+	if params.NodeID == "" {
+		params.NodeID = types.NodeID(key.ID())
+	}
+	if params.JoinToken != "" {
+		ctx = jointokens.AppendToOutgoingContext(ctx, params.JoinToken)
+	}
+	interval := params.RetryInterval
+	if interval <= 0 {
+		interval = DefaultRetryInterval
+	}
+	req, err := NewJoinRequest(params, key)
+	if err != nil {
+		return nil, err
+	}
+	var tries int
+	for {
+		if tries > 0 {
+			log.Info("Retrying join request", slog.Int("tries", tries))
+		}
+		log.Debug("Sending join request to node", slog.Any("req", req))
+		var header metadata.MD
+		resp, err := rt.RoundTrip(transport.WithResponseHeader(ctx, &header), req)
+		if err == nil {
+			log.Debug("Received join response", slog.Any("resp", resp))
+			res, err := ParseJoinResponse(resp)
+			if err != nil {
+				return nil, err
+			}
+			res.Key = key
+			res.NodeID = params.NodeID
+			res.Features, res.UnsupportedFeatures = types.FeaturesFromHeader(header)
+			if len(res.UnsupportedFeatures) > 0 {
+				log.Warn("Cluster does not support some advertised features", slog.Any("features", res.UnsupportedFeatures))
+			}
+			res.PresharedKeys = types.PresharedKeysFromHeader(header)
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		err = fmt.Errorf("join: %w", err)
+		log.Error("Join request failed", slog.String("error", err.Error()))
+		if tries >= params.MaxRetries {
+			return nil, err
+		}
+		tries++
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// NewJoinRequest builds a join request from the given parameters and key.
+func NewJoinRequest(params JoinParams, key crypto.PrivateKey) (*v1.JoinRequest, error) {
+	encoded, err := key.PublicKey().Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encode public key: %w", err)
+	}
+	nodeID := params.NodeID
+	if nodeID == "" {
+		nodeID = types.NodeID(key.ID())
+	}
+	zoneID := params.ZoneAwarenessID
+	if len(params.Labels) > 0 {
+		if err := types.ValidateLabels(params.Labels); err != nil {
+			return nil, err
+		}
+		if zoneID != "" {
+			zoneID += types.ZoneSeparator
+		}
+		zoneID += params.Labels.String()
+	}
+	req := &v1.JoinRequest{
+		Id:                nodeID.String(),
+		PublicKey:         encoded,
+		ZoneAwarenessID:   zoneID,
+		AssignIPv4:        !params.DisableIPv4,
+		PreferStorageIPv6: !params.DisableIPv6 && params.PreferIPv6,
+		AsVoter:           params.AsVoter,
+		AsObserver:        params.AsObserver,
+		Features:          params.Features,
+	}
+	if params.PrimaryEndpoint.IsValid() {
+		req.PrimaryEndpoint = params.PrimaryEndpoint.String()
+	}
+	for _, ep := range params.WireGuardEndpoints {
+		req.WireguardEndpoints = append(req.WireguardEndpoints, ep.String())
+	}
+	for _, route := range params.Routes {
+		req.Routes = append(req.Routes, route.String())
+	}
+	if params.ExitNode {
+		req.Routes = append(req.Routes, types.NewExitRoute(nodeID, !params.DisableIPv4, !params.DisableIPv6).GetDestinationCIDRs()...)
+	}
+	if len(params.DirectPeers) > 0 {
+		req.DirectPeers = make(map[string]v1.ConnectProtocol, len(params.DirectPeers))
+		for id, proto := range params.DirectPeers {
+			req.DirectPeers[id.String()] = proto
+		}
+	}
+	for _, addr := range params.Multiaddrs {
+		req.Multiaddrs = append(req.Multiaddrs, addr.String())
+	}
+	return req, nil
+}
+
+// ParseJoinResponse parses the addresses, networks, and DNS servers in a
+// join response.
+func ParseJoinResponse(resp *v1.JoinResponse) (*JoinResult, error) {
+	res := &JoinResult{
+		MeshDomain: resp.GetMeshDomain(),
+		Peers:      resp.GetPeers(),
+		ICEServers: resp.GetIceServers(),
+		Response:   resp,
+	}
+	var err error
+	if resp.GetAddressIPv4() != "" {
+		res.AddressV4, err = netip.ParsePrefix(resp.GetAddressIPv4())
+		if err != nil {
+			return nil, fmt.Errorf("parse ipv4 address: %w", err)
+		}
+	}
+	if resp.GetNetworkIPv4() != "" {
+		res.NetworkV4, err = netip.ParsePrefix(resp.GetNetworkIPv4())
+		if err != nil {
+			return nil, fmt.Errorf("parse ipv4 network: %w", err)
+		}
+	}
+	if resp.GetAddressIPv6() != "" {
+		res.AddressV6, err = netip.ParsePrefix(resp.GetAddressIPv6())
+		if err != nil {
+			return nil, fmt.Errorf("parse ipv6 address: %w", err)
+		}
+	}
+	if resp.GetNetworkIPv6() != "" {
+		res.NetworkV6, err = netip.ParsePrefix(resp.GetNetworkIPv6())
+		if err != nil {
+			return nil, fmt.Errorf("parse ipv6 network: %w", err)
+		}
+	}
+	for _, server := range resp.GetDnsServers() {
+		addr, err := netip.ParseAddrPort(server)
+		if err != nil {
+			return nil, fmt.Errorf("parse dns server: %w", err)
+		}
+		res.DNSServers = append(res.DNSServers, addr)
+	}
+	return res, nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshclient_test
+
+import (
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshclient"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/services/membership"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+func TestJoin(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	v1.RegisterMembershipServer(srv, membership.NewServer(ctx, membership.Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: node.Plugins(),
+		RBAC:    rbac.NewNoopEvaluator(),
+		Meshnet: node.Network(),
+	}))
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	state, err := node.Storage().MeshDB().MeshState().GetMeshState(ctx)
+	if err != nil {
+		t.Fatalf("get mesh state: %v", err)
+	}
+
+	t.Run("GeneratedKey", func(t *testing.T) {
+		res, err := meshclient.Join(ctx, lis.Addr().String(), meshclient.JoinParams{
+			NodeID: "client-a",
+			Routes: []netip.Prefix{netip.MustParsePrefix("192.168.100.0/24")},
+		})
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		if res.Key == nil {
+			t.Fatal("expected a generated key")
+		}
+		if res.NodeID != "client-a" {
+			t.Errorf("expected node ID client-a, got %s", res.NodeID)
+		}
+		if res.MeshDomain != state.Domain() {
+			t.Errorf("expected mesh domain %q, got %q", state.Domain(), res.MeshDomain)
+		}
+		if res.NetworkV4 != state.NetworkV4() {
+			t.Errorf("expected ipv4 network %s, got %s", state.NetworkV4(), res.NetworkV4)
+		}
+		if res.NetworkV6 != state.NetworkV6() {
+			t.Errorf("expected ipv6 network %s, got %s", state.NetworkV6(), res.NetworkV6)
+		}
+		if !res.AddressV4.IsValid() || !res.NetworkV4.Contains(res.AddressV4.Addr()) {
+			t.Errorf("expected ipv4 address in %s, got %s", res.NetworkV4, res.AddressV4)
+		}
+		if !res.AddressV6.IsValid() || !res.NetworkV6.Contains(res.AddressV6.Addr()) {
+			t.Errorf("expected ipv6 address in %s, got %s", res.NetworkV6, res.AddressV6)
+		}
+		peer, err := node.Storage().MeshDB().Peers().Get(ctx, "client-a")
+		if err != nil {
+			t.Fatalf("get joined peer: %v", err)
+		}
+		pubkey, err := peer.DecodePublicKey()
+		if err != nil {
+			t.Fatalf("decode peer public key: %v", err)
+		}
+		if !pubkey.Equals(res.Key.PublicKey()) {
+			t.Error("expected the stored public key to match the client key")
+		}
+	})
+
+	t.Run("KeyFile", func(t *testing.T) {
+		keyFile := filepath.Join(t.TempDir(), "key")
+		params := meshclient.JoinParams{NodeID: "client-b", KeyFile: keyFile}
+		res, err := meshclient.Join(ctx, lis.Addr().String(), params)
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		saved, err := crypto.DecodePrivateKeyFromFile(keyFile)
+		if err != nil {
+			t.Fatalf("decode saved key: %v", err)
+		}
+		if !saved.Equals(res.Key) {
+			t.Error("expected the generated key to be written to the key file")
+		}
+		// Joining again should reuse the key on disk.
+		res, err = meshclient.Join(ctx, lis.Addr().String(), params)
+		if err != nil {
+			t.Fatalf("rejoin: %v", err)
+		}
+		if !saved.Equals(res.Key) {
+			t.Error("expected the key file to be reused")
+		}
+	})
+
+	t.Run("Features", func(t *testing.T) {
+		res, err := meshclient.Join(ctx, lis.Addr().String(), meshclient.JoinParams{
+			NodeID: "client-d",
+			Features: []*v1.FeaturePort{
+				{Feature: v1.Feature_NODES, Port: 8443},
+				{Feature: v1.Feature_MESH_DNS, Port: 53},
+			},
+		})
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		if len(res.Features) != 2 || res.Features[0] != v1.Feature_NODES || res.Features[1] != v1.Feature_MESH_DNS {
+			t.Errorf("expected the advertised features to be accepted, got %v", res.Features)
+		}
+		if len(res.UnsupportedFeatures) != 0 {
+			t.Errorf("expected no unsupported features, got %v", res.UnsupportedFeatures)
+		}
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_, err := meshclient.Join(ctx, "127.0.0.1:1", meshclient.JoinParams{
+			NodeID:        "client-c",
+			MaxRetries:    1,
+			RetryInterval: 10 * time.Millisecond,
+		})
+		if err == nil {
+			t.Fatal("expected join to an unreachable address to fail")
+		}
+	})
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// EffectiveRoute is a destination routed over the mesh to a peer.
+type EffectiveRoute struct {
+	// Destination is the destination prefix.
+	Destination netip.Prefix `json:"destination"`
+	// Peer is the ID of the direct peer traffic to the destination is
+	// sent to.
+	Peer string `json:"peer"`
+	// Route is the name of the mesh route the destination was advertised
+	// by. It is empty for mesh addresses of nodes reached through the peer.
+	Route string `json:"route,omitempty"`
+}
+
+// EffectiveRoutes returns the routes the given node installs for its peers
+// as a result of mesh routes and allowed IPs. Routes are sorted by
+// destination and then peer.
+func EffectiveRoutes(ctx context.Context, st storage.MeshDB, nodeID types.NodeID, opts PeerMapOptions) ([]EffectiveRoute, error) {
+	peers, err := WireGuardPeersWithOptions(ctx, st, nodeID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("get wireguard peers: %w", err)
+	}
+	return effectiveRoutes(ctx, st, peers)
+}
+
+// effectiveRoutes returns the routes installed for the given peers.
+func effectiveRoutes(ctx context.Context, st storage.MeshDB, peers []*v1.WireGuardPeer) ([]EffectiveRoute, error) {
+	routes, err := st.Networking().ListRoutes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	out := make([]EffectiveRoute, 0)
+	for _, peer := range peers {
+		for _, allowedIP := range peer.GetAllowedIPs() {
+			// The address was validated when it was added to the allowed IPs.
+			dest := netip.MustParsePrefix(allowedIP)
+			rt := EffectiveRoute{Destination: dest, Peer: peer.GetNode().GetId()}
+			if slices.Contains(peer.GetAllowedRoutes(), allowedIP) {
+				rt.Route = sourceRoute(routes, dest)
+			}
+			out = append(out, rt)
+		}
+	}
+	slices.SortFunc(out, func(a, b EffectiveRoute) int {
+		if c := a.Destination.Addr().Compare(b.Destination.Addr()); c != 0 {
+			return c
+		}
+		if a.Destination.Bits() != b.Destination.Bits() {
+			return a.Destination.Bits() - b.Destination.Bits()
+		}
+		switch {
+		case a.Peer < b.Peer:
+			return -1
+		case a.Peer > b.Peer:
+			return 1
+		}
+		return 0
+	})
+	return out, nil
+}
+
+// sourceRoute returns the name of the route advertising the given prefix.
+// When several do, the one with the lowest metric and then name is used,
+// matching how the route was selected.
+func sourceRoute(routes types.Routes, prefix netip.Prefix) string {
+	var name string
+	var metric uint32
+	for _, route := range routes {
+		if !slices.Contains(advertisedPrefixes(route), prefix) {
+			continue
+		}
+		if name == "" || route.Metric < metric || (route.Metric == metric && route.GetName() < name) {
+			name, metric = route.GetName(), route.Metric
+		}
+	}
+	return name
+}
+
+func (m *manager) EffectiveRoutes(ctx context.Context) ([]EffectiveRoute, error) {
+	peers, err := m.graph.WireGuardPeers(ctx, m.nodeID, m.peerMapOptions())
+	if err != nil {
+		return nil, fmt.Errorf("get wireguard peers: %w", err)
+	}
+	routes, err := effectiveRoutes(ctx, m.storage, peers)
+	if err != nil {
+		return nil, err
+	}
+	// Drop destinations for disabled address families, the same as when
+	// the peers are configured.
+	return slices.DeleteFunc(routes, func(rt EffectiveRoute) bool {
+		return (m.opts.DisableIPv4 && rt.Destination.Addr().Is4()) || (m.opts.DisableIPv6 && rt.Destination.Addr().Is6())
+	}), nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestEffectiveRoutes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	// node-a is directly connected to the gateway and node-b. node-c
+	// sits behind the gateway, which advertises the office subnet.
+	nodes := map[string]string{
+		"node-a":  "172.16.0.1/32",
+		"gateway": "172.16.0.2/32",
+		"node-b":  "172.16.0.3/32",
+		"node-c":  "172.16.0.4/32",
+	}
+	for id, addr := range nodes {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: addr,
+		}})
+		if err != nil {
+			t.Fatalf("put node %q: %v", id, err)
+		}
+	}
+	for _, edge := range [][2]string{{"node-a", "gateway"}, {"node-a", "node-b"}, {"gateway", "node-c"}} {
+		for _, e := range [][2]string{edge, {edge[1], edge[0]}} {
+			err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: e[0], Target: e[1]}})
+			if err != nil {
+				t.Fatalf("put edge from %q to %q: %v", e[0], e[1], err)
+			}
+		}
+	}
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "office",
+		Node:             "gateway",
+		DestinationCIDRs: []string{"10.10.0.0/24"},
+	}})
+	if err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+
+	got, err := EffectiveRoutes(ctx, db, "node-a", PeerMapOptions{})
+	if err != nil {
+		t.Fatalf("effective routes: %v", err)
+	}
+	want := []EffectiveRoute{
+		{Destination: netip.MustParsePrefix("10.10.0.0/24"), Peer: "gateway", Route: "office"},
+		{Destination: netip.MustParsePrefix("172.16.0.2/32"), Peer: "gateway"},
+		{Destination: netip.MustParsePrefix("172.16.0.3/32"), Peer: "node-b"},
+		{Destination: netip.MustParsePrefix("172.16.0.4/32"), Peer: "gateway"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected routes %+v, got %+v", want, got)
+	}
+
+	// The gateway reaches everything directly and installs no mesh routes
+	// for its own subnet.
+	got, err = EffectiveRoutes(ctx, db, "gateway", PeerMapOptions{})
+	if err != nil {
+		t.Fatalf("effective routes: %v", err)
+	}
+	for _, rt := range got {
+		if rt.Route != "" {
+			t.Errorf("expected no advertised routes on the gateway, got %+v", rt)
+		}
+	}
+}
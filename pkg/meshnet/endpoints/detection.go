@@ -20,6 +20,7 @@ package endpoints
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -28,6 +29,7 @@ import (
 	"time"
 
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
 )
 
 // Detect detects endpoints for this machine.
@@ -110,6 +112,56 @@ func DetectPublicAddresses(ctx context.Context) ([]netip.Addr, error) {
 	return out, nil
 }
 
+// DetectHostNetworks returns the addresses of every interface that is up
+// along with the routes in the main routing table. Loopback and link-local
+// networks are skipped, as are any networks on the given interfaces. Routes
+// are only included on platforms that support listing them.
+func DetectHostNetworks(ctx context.Context, skipInterfaces []string) ([]HostNetwork, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %w", err)
+	}
+	var out []HostNetwork
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if slices.Contains(skipInterfaces, iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses for interface %s: %w", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			prefix, err := netip.ParsePrefix(addr.String())
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse address %s: %w", addr.String(), err)
+			}
+			if !isHostNetwork(prefix) {
+				continue
+			}
+			out = append(out, HostNetwork{Interface: iface.Name, Prefix: prefix})
+		}
+	}
+	rts, err := routes.List(ctx)
+	if err != nil && !errors.Is(err, routes.ErrRoutingTablesNotSupported) {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	for _, rt := range rts {
+		if slices.Contains(skipInterfaces, rt.Interface) || !isHostNetwork(rt.Destination) {
+			continue
+		}
+		out = append(out, HostNetwork{Interface: rt.Interface, Prefix: rt.Destination, Route: true})
+	}
+	return out, nil
+}
+
+func isHostNetwork(prefix netip.Prefix) bool {
+	addr := prefix.Addr()
+	return !addr.IsLoopback() && !addr.IsLinkLocalUnicast() && !addr.IsMulticast()
+}
+
 func detectFromInterfaces(opts *DetectOpts) (PrefixList, error) {
 	interfaces, err := net.Interfaces()
 	if err != nil {
@@ -53,6 +53,11 @@ func Detect(ctx context.Context, opts DetectOpts) (PrefixList, error) {
 	return addrs, nil
 }
 
+// DetectHostNetworks is not supported on wasm.
+func DetectHostNetworks(ctx context.Context, skipInterfaces []string) ([]HostNetwork, error) {
+	return nil, errors.New("listing host networks not supported on wasm")
+}
+
 // DetectPublicAddresses detects the public addresses of the machine
 // using the opendns resolver service.
 func DetectPublicAddresses(ctx context.Context) ([]netip.Addr, error) {
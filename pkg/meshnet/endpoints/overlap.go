@@ -0,0 +1,166 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// OverlapCheckMode is what to do when a mesh network overlaps a network
+// already configured on the host.
+type OverlapCheckMode string
+
+const (
+	// OverlapCheckDisabled skips checking for overlapping networks.
+	OverlapCheckDisabled OverlapCheckMode = "disabled"
+	// OverlapCheckWarn logs a warning for each overlapping network.
+	OverlapCheckWarn OverlapCheckMode = "warn"
+	// OverlapCheckError refuses to continue when networks overlap.
+	OverlapCheckError OverlapCheckMode = "error"
+)
+
+// IsValid returns true if the mode is a known check mode. An empty mode
+// is treated as disabled.
+func (m OverlapCheckMode) IsValid() bool {
+	switch m {
+	case "", OverlapCheckDisabled, OverlapCheckWarn, OverlapCheckError:
+		return true
+	default:
+		return false
+	}
+}
+
+// HostNetwork is a network configured on the host, either as an address
+// on an interface or as a route.
+type HostNetwork struct {
+	// Interface is the name of the interface the network is on.
+	Interface string
+	// Prefix is the address or route destination.
+	Prefix netip.Prefix
+	// Route is true if the network came from the routing table.
+	Route bool
+}
+
+// String returns a human readable description of the network.
+func (h HostNetwork) String() string {
+	kind := "address"
+	if h.Route {
+		kind = "route"
+	}
+	if h.Interface == "" {
+		return fmt.Sprintf("%s %s", kind, h.Prefix)
+	}
+	return fmt.Sprintf("%s %s on %s", kind, h.Prefix, h.Interface)
+}
+
+// Overlap is a mesh network that overlaps a host network.
+type Overlap struct {
+	// Mesh is the mesh network.
+	Mesh netip.Prefix
+	// Host is the host network it overlaps.
+	Host HostNetwork
+}
+
+// String returns a human readable description of the overlap.
+func (o Overlap) String() string {
+	return fmt.Sprintf("mesh network %s overlaps %s", o.Mesh, o.Host)
+}
+
+// OverlapError is returned when mesh networks overlap host networks.
+type OverlapError struct {
+	Overlaps []Overlap
+}
+
+// Error implements error.
+func (e *OverlapError) Error() string {
+	msgs := make([]string, len(e.Overlaps))
+	for i, o := range e.Overlaps {
+		msgs[i] = o.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FindOverlaps returns every pair of mesh and host networks that overlap.
+// Default routes are ignored since they overlap everything.
+func FindOverlaps(mesh []netip.Prefix, host []HostNetwork) []Overlap {
+	var out []Overlap
+	for _, m := range mesh {
+		if !m.IsValid() {
+			continue
+		}
+		for _, h := range host {
+			if !h.Prefix.IsValid() || h.Prefix.Bits() == 0 {
+				continue
+			}
+			if m.Overlaps(h.Prefix) {
+				out = append(out, Overlap{Mesh: m, Host: h})
+			}
+		}
+	}
+	return out
+}
+
+// OverlapCheck compares mesh networks against the networks already
+// configured on the host.
+type OverlapCheck struct {
+	// Mode is what to do when networks overlap.
+	Mode OverlapCheckMode
+	// SkipInterfaces are interfaces whose networks are ignored, such as
+	// the mesh interface itself.
+	SkipInterfaces []string
+	// HostNetworks lists the networks configured on the host. It defaults
+	// to DetectHostNetworks.
+	HostNetworks func(ctx context.Context, skipInterfaces []string) ([]HostNetwork, error)
+}
+
+// Run checks the given mesh networks for overlaps. Overlaps are always
+// logged, and an OverlapError is returned when the mode is OverlapCheckError.
+// Failing to list the host networks is logged and otherwise ignored.
+func (c OverlapCheck) Run(ctx context.Context, mesh ...netip.Prefix) error {
+	if c.Mode == "" || c.Mode == OverlapCheckDisabled {
+		return nil
+	}
+	log := context.LoggerFrom(ctx)
+	detect := c.HostNetworks
+	if detect == nil {
+		detect = DetectHostNetworks
+	}
+	host, err := detect(ctx, c.SkipInterfaces)
+	if err != nil {
+		log.Warn("Failed to list host networks, skipping overlap check", slog.String("error", err.Error()))
+		return nil
+	}
+	overlaps := FindOverlaps(mesh, host)
+	if len(overlaps) == 0 {
+		return nil
+	}
+	for _, o := range overlaps {
+		log.Warn("Mesh network overlaps a network configured on the host, routing to it may break",
+			slog.String("mesh-network", o.Mesh.String()),
+			slog.String("host-network", o.Host.String()),
+		)
+	}
+	if c.Mode == OverlapCheckError {
+		return &OverlapError{Overlaps: overlaps}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestOverlapCheck(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	host := []HostNetwork{
+		{Interface: "eth0", Prefix: netip.MustParsePrefix("192.168.1.10/24")},
+		{Interface: "eth0", Prefix: netip.MustParsePrefix("0.0.0.0/0"), Route: true},
+		{Interface: "tun0", Prefix: netip.MustParsePrefix("172.20.0.0/16"), Route: true},
+		{Interface: "webmesh0", Prefix: netip.MustParsePrefix("172.16.0.1/32")},
+		{Interface: "eth1", Prefix: netip.MustParsePrefix("fdaa:bbcc:ddee:1::/64"), Route: true},
+	}
+	// fakeHost mimics DetectHostNetworks against a fixed host config.
+	fakeHost := func(_ context.Context, skip []string) ([]HostNetwork, error) {
+		var out []HostNetwork
+		for _, h := range host {
+			if !slices.Contains(skip, h.Interface) {
+				out = append(out, h)
+			}
+		}
+		return out, nil
+	}
+	mesh := []netip.Prefix{
+		netip.MustParsePrefix("172.16.0.0/12"),
+		netip.MustParsePrefix("fdaa:bbcc:ddee::/48"),
+	}
+
+	t.Run("FindOverlaps", func(t *testing.T) {
+		t.Parallel()
+		overlaps := FindOverlaps(mesh, host)
+		var got []string
+		for _, o := range overlaps {
+			got = append(got, o.String())
+		}
+		want := []string{
+			"mesh network 172.16.0.0/12 overlaps route 172.20.0.0/16 on tun0",
+			"mesh network 172.16.0.0/12 overlaps address 172.16.0.1/32 on webmesh0",
+			"mesh network fdaa:bbcc:ddee::/48 overlaps route fdaa:bbcc:ddee:1::/64 on eth1",
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("expected overlaps %v, got %v", want, got)
+		}
+	})
+
+	t.Run("NoOverlap", func(t *testing.T) {
+		t.Parallel()
+		overlaps := FindOverlaps([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, host)
+		if len(overlaps) != 0 {
+			t.Errorf("expected no overlaps, got %v", overlaps)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+		check := OverlapCheck{
+			Mode:           OverlapCheckError,
+			SkipInterfaces: []string{"webmesh0"},
+			HostNetworks:   fakeHost,
+		}
+		err := check.Run(ctx, mesh...)
+		var overlapErr *OverlapError
+		if !errors.As(err, &overlapErr) {
+			t.Fatalf("expected an overlap error, got %v", err)
+		}
+		// The mesh interface itself should be ignored.
+		if len(overlapErr.Overlaps) != 2 {
+			t.Errorf("expected 2 overlaps, got %v", overlapErr.Overlaps)
+		}
+	})
+
+	t.Run("Warn", func(t *testing.T) {
+		t.Parallel()
+		check := OverlapCheck{Mode: OverlapCheckWarn, HostNetworks: fakeHost}
+		if err := check.Run(ctx, mesh...); err != nil {
+			t.Errorf("expected overlaps to only be logged, got %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		check := OverlapCheck{
+			Mode: OverlapCheckDisabled,
+			HostNetworks: func(context.Context, []string) ([]HostNetwork, error) {
+				t.Error("host networks should not be listed when the check is disabled")
+				return nil, nil
+			},
+		}
+		if err := check.Run(ctx, mesh...); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+// mapSysctl is an in-memory sysctl.
+type mapSysctl struct {
+	values   map[string]string
+	readOnly bool
+}
+
+func (m *mapSysctl) Get(name string) (string, error) { return m.values[name], nil }
+
+func (m *mapSysctl) Set(name, value string) error {
+	if m.readOnly {
+		return fs.ErrPermission
+	}
+	m.values[name] = value
+	return nil
+}
+
+func TestIPForwarding(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("StartFailsWithoutPermission", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		m := New(db, Options{EnableIPForwarding: true}, "node-a").(*manager)
+		m.fwd = routes.NewForwarding(&mapSysctl{readOnly: true, values: map[string]string{}})
+		if err := m.Start(ctx, StartOptions{}); err == nil {
+			t.Fatal("expected start to fail when forwarding cannot be enabled")
+		}
+		if m.WireGuard() != nil {
+			t.Error("expected no interface to be created")
+		}
+	})
+
+	t.Run("RestoredOnClose", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		m := New(db, Options{EnableIPForwarding: true, RestoreIPForwarding: true}, "node-a").(*manager)
+		sysctl := &mapSysctl{values: map[string]string{}}
+		m.fwd = routes.NewForwarding(sysctl)
+		if err := m.fwd.Enable(true, true); err != nil {
+			t.Fatalf("enable forwarding: %v", err)
+		}
+		for name, val := range sysctl.values {
+			if val != "1" {
+				t.Fatalf("expected %s to be enabled, got %q", name, val)
+			}
+		}
+		if err := m.Close(ctx); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		for name, val := range sysctl.values {
+			if val != "" {
+				t.Errorf("expected %s to be restored, got %q", name, val)
+			}
+		}
+	})
+}
@@ -33,7 +33,28 @@ import (
 // allowed. Currently if a single route provided by a destination node is not allowed, the entire node
 // is filtered out.
 func FilterGraph(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID) (types.AdjacencyMap, error) {
+	return filterGraph(ctx, db, thisNodeID, func(acls types.NetworkACLs) aclEvaluator { return acls })
+}
+
+// aclEvaluator evaluates actions against a list of network ACLs.
+type aclEvaluator interface {
+	// AllowNodesToCommunicate checks if the given nodes are allowed to communicate.
+	AllowNodesToCommunicate(ctx context.Context, nodeA, nodeB types.MeshNode) bool
+	// Accept evaluates an action against the ACLs.
+	Accept(ctx context.Context, action types.NetworkAction) bool
+}
+
+func filterGraph(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID, newEvaluator func(types.NetworkACLs) aclEvaluator) (types.AdjacencyMap, error) {
 	log := context.LoggerFrom(ctx)
+	// Read the graph and the ACLs at a single revision when the storage
+	// supports it, so concurrent writes can't produce an inconsistent map.
+	if rdb, ok := db.(storage.RevisionMeshDB); ok {
+		var err error
+		db, err = rdb.AtCurrentRevision(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read current revision: %w", err)
+		}
+	}
 	graph := db.Peers().Graph()
 
 	// Resolve the current node ID
@@ -50,7 +71,7 @@ func FilterGraph(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID
 	if len(acls) == 0 {
 		return nil, nil
 	}
-	err = storage.ExpandACLs(ctx, db.RBAC(), acls)
+	err = storage.ExpandACLs(ctx, db.RBAC(), db.Peers(), acls)
 	if err != nil {
 		return nil, fmt.Errorf("expand network acls: %w", err)
 	}
@@ -61,12 +82,16 @@ func FilterGraph(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID
 	}
 	log.Debug("Full adjacency map", "from", thisNode.Id, "map", fullMap)
 
+	// Decisions are memoized for the duration of this call only, so that the
+	// same pair is not evaluated again in the second phase without risking
+	// stale decisions across calls.
+	decisions := newPairDecisions(db, newEvaluator(acls))
+
 	// Start with a copy of the full map and filter out nodes that are not allowed to communicate
 	// with the current node.
 	filtered := make(types.AdjacencyMap)
 	filtered[thisNode.NodeID()] = fullMap[thisNode.NodeID()]
 
-Nodes:
 	for nodeID := range fullMap {
 		if nodeID.String() == thisNode.GetId() {
 			continue
@@ -75,102 +100,135 @@ Nodes:
 		if err != nil {
 			return nil, fmt.Errorf("get node: %w", err)
 		}
-		if !acls.AllowNodesToCommunicate(ctx, thisNode, node) {
-			log.Debug("Nodes not allowed to communicate", "nodeA", thisNode, "nodeB", node)
-			delete(filtered[thisNode.NodeID()], node.NodeID())
-			continue Nodes
-		}
-		// If the destination node exposes additional routes, check if the nodes can communicate
-		// via any of those routes.
-		routes, err := db.Networking().GetRoutesByNode(ctx, node.NodeID())
+		allowed, err := decisions.allow(ctx, thisNode, node)
 		if err != nil {
-			return nil, fmt.Errorf("get routes by node: %w", err)
+			return nil, err
 		}
-		for _, route := range routes {
-			for _, cidr := range route.DestinationPrefixes() {
-				var action types.NetworkAction
-				if cidr.Addr().Is4() {
-					action = types.NetworkAction{
-						NetworkAction: &v1.NetworkAction{
-							SrcNode: thisNode.GetId(),
-							SrcCIDR: thisNode.GetPrivateIPv4(),
-							DstNode: node.GetId(),
-							DstCIDR: cidr.String(),
-						},
-					}
-				} else {
-					action = types.NetworkAction{
-						NetworkAction: &v1.NetworkAction{
-							SrcNode: thisNode.GetId(),
-							SrcCIDR: thisNode.GetPrivateIPv6(),
-							DstNode: node.GetId(),
-							DstCIDR: cidr.String(),
-						},
-					}
-				}
-				if !acls.Accept(ctx, action) {
-					log.Debug("filtering node", "node", node, "reason", "route not allowed", "action", action)
-					delete(filtered[thisNode.NodeID()], node.NodeID())
-					continue Nodes
-				}
-			}
+		if !allowed {
+			log.Debug("filtering node", "node", node)
+			delete(filtered[thisNode.NodeID()], node.NodeID())
+			continue
 		}
 		filtered[node.NodeID()] = make(types.EdgeMap)
 	}
-	for node := range filtered {
-		edges, ok := fullMap[node]
+	// ACLs are directional, so each edge is only kept in the direction it is
+	// allowed and is marked one-way when the reverse direction is denied.
+	for nodeID := range filtered {
+		edges, ok := fullMap[nodeID]
 		if !ok {
 			continue
 		}
-	Peers:
+		node, err := graph.Vertex(nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("get node: %w", err)
+		}
 		for peerID, edge := range edges {
 			e := edge
-			if peerID.String() == thisNode.GetId() {
-				filtered[node][peerID] = e
-				continue
-			}
 			peer, err := graph.Vertex(peerID)
 			if err != nil {
 				return nil, fmt.Errorf("get peer: %w", err)
 			}
-			if !acls.AllowNodesToCommunicate(ctx, thisNode, peer) {
-				log.Debug("Nodes not allowed to communicate", "nodeA", thisNode, "nodeB", peer)
-				continue Peers
+			if peerID.String() != thisNode.GetId() {
+				// The peer must be reachable from this node.
+				allowed, err := decisions.allow(ctx, thisNode, peer)
+				if err != nil {
+					return nil, err
+				}
+				if !allowed {
+					log.Debug("filtering peer", "peer", peer)
+					continue
+				}
 			}
-			// If the peer exposes additional routes, check if the nodes can communicate
-			// via any of those routes.
-			routes, err := db.Networking().GetRoutesByNode(ctx, peerID)
+			allowed, err := decisions.allow(ctx, node, peer)
 			if err != nil {
-				return nil, fmt.Errorf("get routes by node: %w", err)
+				return nil, err
 			}
-			for _, route := range routes {
-				for _, cidr := range route.DestinationPrefixes() {
-					var action v1.NetworkAction
-					if cidr.Addr().Is4() {
-						action = v1.NetworkAction{
-							SrcNode: thisNode.GetId(),
-							SrcCIDR: thisNode.GetPrivateIPv4(),
-							DstNode: peerID.String(),
-							DstCIDR: cidr.String(),
-						}
-					} else {
-						action = v1.NetworkAction{
-							SrcNode: thisNode.GetId(),
-							SrcCIDR: thisNode.GetPrivateIPv6(),
-							DstNode: peerID.String(),
-							DstCIDR: cidr.String(),
-						}
-					}
-					if !acls.Accept(ctx, types.NetworkAction{NetworkAction: &action}) {
-						log.Debug("filtering peer", "peer", peer, "reason", "route not allowed", "action", &action)
-						continue Peers
-					}
-				}
+			if !allowed {
+				log.Debug("filtering edge", "source", nodeID, "target", peerID)
+				continue
 			}
-			filtered[node][peerID] = e
+			reverse, err := decisions.allow(ctx, peer, node)
+			if err != nil {
+				return nil, err
+			}
+			if !reverse {
+				e = e.OneWay()
+			}
+			filtered[nodeID][peerID] = e
 		}
 	}
 
 	log.Debug("Filtered adjacency map", "from", thisNode.Id, "map", filtered)
 	return filtered, nil
 }
+
+// nodePair is an ordered pair of node IDs.
+type nodePair struct {
+	src, dst types.NodeID
+}
+
+// pairDecisions memoizes whether a source node may communicate with a destination
+// node and every route it exposes. It must not be shared across calls to FilterGraph.
+type pairDecisions struct {
+	db    storage.MeshDB
+	acls  aclEvaluator
+	cache map[nodePair]bool
+}
+
+func newPairDecisions(db storage.MeshDB, acls aclEvaluator) *pairDecisions {
+	return &pairDecisions{
+		db:    db,
+		acls:  acls,
+		cache: make(map[nodePair]bool),
+	}
+}
+
+// allow returns whether src is allowed to communicate with dst, computing the
+// decision only the first time the pair is seen.
+func (p *pairDecisions) allow(ctx context.Context, src, dst types.MeshNode) (bool, error) {
+	key := nodePair{src: src.NodeID(), dst: dst.NodeID()}
+	if allowed, ok := p.cache[key]; ok {
+		return allowed, nil
+	}
+	allowed, err := p.evaluate(ctx, src, dst)
+	if err != nil {
+		return false, err
+	}
+	p.cache[key] = allowed
+	return allowed, nil
+}
+
+func (p *pairDecisions) evaluate(ctx context.Context, src, dst types.MeshNode) (bool, error) {
+	log := context.LoggerFrom(ctx)
+	if !p.acls.AllowNodesToCommunicate(ctx, src, dst) {
+		log.Debug("Nodes not allowed to communicate", "nodeA", src, "nodeB", dst)
+		return false, nil
+	}
+	// If the destination node exposes additional routes, check if the nodes can communicate
+	// via any of those routes.
+	routes, err := p.db.Networking().GetRoutesByNode(ctx, dst.NodeID())
+	if err != nil {
+		return false, fmt.Errorf("get routes by node: %w", err)
+	}
+	for _, route := range routes {
+		for _, cidr := range route.DestinationPrefixes() {
+			srcCIDR := src.GetPrivateIPv4()
+			if !cidr.Addr().Is4() {
+				srcCIDR = src.GetPrivateIPv6()
+			}
+			action := types.NetworkAction{
+				NetworkAction: &v1.NetworkAction{
+					SrcNode: src.GetId(),
+					SrcCIDR: srcCIDR,
+					DstNode: dst.GetId(),
+					DstCIDR: cidr.String(),
+				},
+			}
+			if !p.acls.Accept(ctx, action) {
+				log.Debug("Route not allowed", "node", dst, "action", action)
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
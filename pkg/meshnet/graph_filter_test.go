@@ -19,6 +19,7 @@ package meshnet
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/dominikbraun/graph"
@@ -239,6 +240,70 @@ func TestFilteredGraph(t *testing.T) {
 	})
 }
 
+func TestFilterGraphAsymmetricACLs(t *testing.T) {
+	t.Parallel()
+	db := setupGraphTest(t, graphSetup{
+		nodes: []types.MeshNode{
+			{
+				MeshNode: &v1.MeshNode{
+					Id:          "node-a",
+					PublicKey:   generateEncodedKey(t),
+					PrivateIPv4: "172.16.0.1/32",
+					PrivateIPv6: "fe80::1/128",
+				},
+			},
+			{
+				MeshNode: &v1.MeshNode{
+					Id:          "node-b",
+					PublicKey:   generateEncodedKey(t),
+					PrivateIPv4: "172.16.0.2/32",
+					PrivateIPv6: "fe80::2/128",
+				},
+			},
+		},
+		edges: []types.MeshEdge{
+			{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b"}},
+			{MeshEdge: &v1.MeshEdge{Source: "node-b", Target: "node-a"}},
+		},
+		acls: []*v1.NetworkACL{
+			{
+				Name:             "allow-a-to-b",
+				Action:           v1.ACLAction_ACTION_ACCEPT,
+				SourceNodes:      []string{"node-a"},
+				DestinationNodes: []string{"node-b"},
+				SourceCIDRs:      []string{"*"},
+				DestinationCIDRs: []string{"*"},
+			},
+		},
+	})
+
+	filteredA, err := FilterGraph(context.Background(), db, "node-a")
+	if err != nil {
+		t.Fatalf("filter graph: %v", err)
+	}
+	edge, ok := filteredA["node-a"]["node-b"]
+	if !ok {
+		t.Fatalf("expected edge node-a -> node-b, got %v", filteredA)
+	}
+	if !edge.IsOneWay() {
+		t.Errorf("expected edge node-a -> node-b to be one-way")
+	}
+	if _, ok := filteredA["node-b"]["node-a"]; ok {
+		t.Errorf("expected reverse edge node-b -> node-a to be filtered, got %v", filteredA)
+	}
+
+	filteredB, err := FilterGraph(context.Background(), db, "node-b")
+	if err != nil {
+		t.Fatalf("filter graph: %v", err)
+	}
+	if _, ok := filteredB["node-b"]["node-a"]; ok {
+		t.Errorf("expected edge node-b -> node-a to be filtered, got %v", filteredB)
+	}
+	if _, ok := filteredB["node-a"]; ok {
+		t.Errorf("expected node-a to be filtered from node-b's view, got %v", filteredB)
+	}
+}
+
 type graphSetup struct {
 	acls   []*v1.NetworkACL
 	nodes  []types.MeshNode
@@ -246,7 +311,7 @@ type graphSetup struct {
 	routes []*v1.Route
 }
 
-func setupGraphTest(t *testing.T, opts graphSetup) storage.MeshDB {
+func setupGraphTest(t testing.TB, opts graphSetup) storage.MeshDB {
 	t.Helper()
 	db := meshdb.NewTestDB()
 	nw := db.Networking()
@@ -278,7 +343,7 @@ func setupGraphTest(t *testing.T, opts graphSetup) storage.MeshDB {
 	return db
 }
 
-func generateEncodedKey(t *testing.T) string {
+func generateEncodedKey(t testing.TB) string {
 	t.Helper()
 	key := crypto.MustGenerateKey()
 	encoded, err := key.PublicKey().Encode()
@@ -287,3 +352,197 @@ func generateEncodedKey(t *testing.T) string {
 	}
 	return encoded
 }
+
+// countingEvaluator counts ACL evaluations and the node pairs they were made for.
+type countingEvaluator struct {
+	aclEvaluator
+	evaluations int
+	pairs       map[nodePair]int
+}
+
+func (c *countingEvaluator) AllowNodesToCommunicate(ctx context.Context, nodeA, nodeB types.MeshNode) bool {
+	c.evaluations++
+	c.pairs[nodePair{src: nodeA.NodeID(), dst: nodeB.NodeID()}]++
+	return c.aclEvaluator.AllowNodesToCommunicate(ctx, nodeA, nodeB)
+}
+
+func (c *countingEvaluator) Accept(ctx context.Context, action types.NetworkAction) bool {
+	c.evaluations++
+	return c.aclEvaluator.Accept(ctx, action)
+}
+
+func setupDenseGraph(t testing.TB, size int) storage.MeshDB {
+	t.Helper()
+	var opts graphSetup
+	for i := 0; i < size; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		opts.nodes = append(opts.nodes, types.MeshNode{
+			MeshNode: &v1.MeshNode{
+				Id:          id,
+				PublicKey:   generateEncodedKey(t),
+				PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+				PrivateIPv6: fmt.Sprintf("fe80::%x/128", i+1),
+			},
+		})
+		opts.routes = append(opts.routes, &v1.Route{
+			Name:             id + "-route",
+			Node:             id,
+			DestinationCIDRs: []string{fmt.Sprintf("10.%d.0.0/16", i)},
+		})
+		for j := 0; j < size; j++ {
+			if i == j {
+				continue
+			}
+			opts.edges = append(opts.edges, types.MeshEdge{
+				MeshEdge: &v1.MeshEdge{
+					Source: id,
+					Target: fmt.Sprintf("node-%d", j),
+				},
+			})
+		}
+	}
+	opts.acls = []*v1.NetworkACL{
+		{
+			Name:             "allow-all",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+			SourceCIDRs:      []string{"*"},
+			DestinationCIDRs: []string{"*"},
+		},
+	}
+	return setupGraphTest(t, opts)
+}
+
+func TestFilterGraphEvaluatesPairsOnce(t *testing.T) {
+	t.Parallel()
+	const size = 8
+	db := setupDenseGraph(t, size)
+	var counter *countingEvaluator
+	filtered, err := filterGraph(context.Background(), db, "node-0", func(acls types.NetworkACLs) aclEvaluator {
+		counter = &countingEvaluator{aclEvaluator: acls, pairs: make(map[nodePair]int)}
+		return counter
+	})
+	if err != nil {
+		t.Fatalf("filter graph: %v", err)
+	}
+	if len(filtered) != size {
+		t.Fatalf("expected %d nodes in the filtered graph, got %d", size, len(filtered))
+	}
+	// Every ordered pair is evaluated since edges are checked in both directions.
+	if len(counter.pairs) != size*(size-1) {
+		t.Errorf("expected %d evaluated pairs, got %d", size*(size-1), len(counter.pairs))
+	}
+	for pair, count := range counter.pairs {
+		if count != 1 {
+			t.Errorf("expected pair %v to be evaluated once, got %d", pair, count)
+		}
+	}
+	// Each decision should not leak into the next call.
+	var next *countingEvaluator
+	_, err = filterGraph(context.Background(), db, "node-0", func(acls types.NetworkACLs) aclEvaluator {
+		next = &countingEvaluator{aclEvaluator: acls, pairs: make(map[nodePair]int)}
+		return next
+	})
+	if err != nil {
+		t.Fatalf("filter graph: %v", err)
+	}
+	if next.evaluations != counter.evaluations {
+		t.Errorf("expected %d evaluations on the next call, got %d", counter.evaluations, next.evaluations)
+	}
+}
+
+// BenchmarkFilterGraph reports the number of ACL evaluations per call on a fully
+// connected graph. With per-call memoization this grows with the number of
+// nodes rather than the number of edges.
+func BenchmarkFilterGraph(b *testing.B) {
+	for _, size := range []int{8, 32} {
+		b.Run(fmt.Sprintf("nodes=%d", size), func(b *testing.B) {
+			db := setupDenseGraph(b, size)
+			var evaluations int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var counter *countingEvaluator
+				_, err := filterGraph(context.Background(), db, "node-0", func(acls types.NetworkACLs) aclEvaluator {
+					counter = &countingEvaluator{aclEvaluator: acls, pairs: make(map[nodePair]int)}
+					return counter
+				})
+				if err != nil {
+					b.Fatalf("filter graph: %v", err)
+				}
+				evaluations += counter.evaluations
+			}
+			b.ReportMetric(float64(evaluations)/float64(b.N), "acl-evals/op")
+		})
+	}
+}
+
+func TestFilterGraphQuarantine(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	const size = 4
+	db := setupDenseGraph(t, size)
+	// Quarantine must win over accept ACLs of the same priority.
+	err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all-max-priority",
+		Priority:         storage.QuarantinePriority,
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network ACL: %v", err)
+	}
+	const quarantined = types.NodeID("node-0")
+
+	assertPeers := func(t *testing.T, wantQuarantined bool) {
+		t.Helper()
+		for i := 0; i < size; i++ {
+			id := types.NodeID(fmt.Sprintf("node-%d", i))
+			filtered, err := FilterGraph(ctx, db, id)
+			if err != nil {
+				t.Fatalf("filter graph: %v", err)
+			}
+			if id == quarantined {
+				if wantQuarantined && len(filtered[id]) != 0 {
+					t.Errorf("expected quarantined node to have no peers, got %v", filtered[id])
+				}
+				if !wantQuarantined && len(filtered[id]) != size-1 {
+					t.Errorf("expected node %s to have %d peers, got %v", id, size-1, filtered[id])
+				}
+				continue
+			}
+			_, hasEdge := filtered[id][quarantined]
+			_, hasNode := filtered[quarantined]
+			if wantQuarantined && (hasEdge || hasNode) {
+				t.Errorf("expected %s to be dropped from the graph of %s, got %v", quarantined, id, filtered)
+			}
+			if !wantQuarantined && !hasEdge {
+				t.Errorf("expected %s to be in the graph of %s, got %v", quarantined, id, filtered)
+			}
+			if len(filtered[id]) < size-2 {
+				t.Errorf("expected node %s to keep its other peers, got %v", id, filtered[id])
+			}
+		}
+	}
+
+	if err := storage.QuarantineNode(ctx, db.Networking(), quarantined); err != nil {
+		t.Fatalf("quarantine node: %v", err)
+	}
+	ok, err := storage.IsQuarantined(ctx, db.Networking(), quarantined)
+	if err != nil || !ok {
+		t.Fatalf("expected node to be quarantined, got %v, %v", ok, err)
+	}
+	assertPeers(t, true)
+
+	if err := storage.UnquarantineNode(ctx, db.Networking(), quarantined); err != nil {
+		t.Fatalf("unquarantine node: %v", err)
+	}
+	ok, err = storage.IsQuarantined(ctx, db.Networking(), quarantined)
+	if err != nil || ok {
+		t.Fatalf("expected node to be released, got %v, %v", ok, err)
+	}
+	assertPeers(t, false)
+}
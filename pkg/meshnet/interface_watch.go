@@ -0,0 +1,236 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+)
+
+// InterfaceWatchMode is how the wireguard interface is watched for being
+// removed from the system.
+type InterfaceWatchMode string
+
+const (
+	// InterfaceWatchDisabled disables watching the interface.
+	InterfaceWatchDisabled InterfaceWatchMode = "disabled"
+	// InterfaceWatchPoll periodically checks that the interface exists.
+	InterfaceWatchPoll InterfaceWatchMode = "poll"
+	// InterfaceWatchNetlink subscribes to link removals over netlink. It
+	// falls back to polling where netlink is not available.
+	InterfaceWatchNetlink InterfaceWatchMode = "netlink"
+)
+
+// DefaultInterfaceWatchInterval is the default interval for polling the
+// wireguard interface.
+const DefaultInterfaceWatchInterval = 10 * time.Second
+
+// IsValid returns true if the mode is a known watch mode. An empty mode
+// is treated as disabled.
+func (m InterfaceWatchMode) IsValid() bool {
+	switch m {
+	case "", InterfaceWatchDisabled, InterfaceWatchPoll, InterfaceWatchNetlink:
+		return true
+	default:
+		return false
+	}
+}
+
+// Enabled returns true if the mode watches the interface.
+func (m InterfaceWatchMode) Enabled() bool {
+	return m == InterfaceWatchPoll || m == InterfaceWatchNetlink
+}
+
+// InterfaceWatcher watches for the wireguard interface disappearing and
+// recovers it when it does.
+type InterfaceWatcher struct {
+	// Mode is the watch mode.
+	Mode InterfaceWatchMode
+	// Interval is the interval for polling the interface. In netlink mode
+	// it is how often to retry a failed recovery.
+	Interval time.Duration
+	// Name is the name of the interface being watched.
+	Name string
+	// Exists reports whether the interface still exists.
+	Exists func() (bool, error)
+	// Recover recreates and reconfigures the interface.
+	Recover func(ctx context.Context) error
+}
+
+// InterfaceExists reports whether the given wireguard interface still exists
+// on the system.
+func InterfaceExists(wg wireguard.Interface) (bool, error) {
+	_, err := wg.Link()
+	if err == nil {
+		return true, nil
+	}
+	if link.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Run watches the interface until the context is canceled.
+func (w *InterfaceWatcher) Run(ctx context.Context) {
+	if !w.Mode.Enabled() {
+		return
+	}
+	log := context.LoggerFrom(ctx).With("component", "interface-watcher", "interface", w.Name)
+	ctx = context.WithLogger(ctx, log)
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterfaceWatchInterval
+	}
+	var removed <-chan struct{}
+	if w.Mode == InterfaceWatchNetlink {
+		var err error
+		removed, err = link.SubscribeRemoved(ctx, w.Name)
+		if err != nil {
+			log.Warn("Cannot watch interface over netlink, falling back to polling", slog.String("error", err.Error()))
+			removed = nil
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// Recovery failures are retried on the next tick regardless of mode.
+	var pending bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-removed:
+			if !ok {
+				log.Warn("Netlink subscription closed, falling back to polling")
+				removed = nil
+				continue
+			}
+		case <-ticker.C:
+			if removed != nil && !pending {
+				continue
+			}
+		}
+		err := w.check(ctx)
+		pending = err != nil
+		if err != nil {
+			log.Error("Failed to recover wireguard interface", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (w *InterfaceWatcher) check(ctx context.Context) error {
+	exists, err := w.Exists()
+	if err != nil {
+		return fmt.Errorf("check interface: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	log := context.LoggerFrom(ctx)
+	log.Warn("WireGuard interface has disappeared, recreating it")
+	err = w.Recover(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info("Recovered wireguard interface")
+	return nil
+}
+
+func (m *manager) startInterfaceWatch(ctx context.Context) {
+	if !m.opts.InterfaceWatchMode.Enabled() {
+		return
+	}
+	mode := m.opts.InterfaceWatchMode
+	if mode == InterfaceWatchNetlink && (runtime.GOOS != "linux" || m.opts.NetNs != "") {
+		// Subscriptions are made in the current namespace only.
+		mode = InterfaceWatchPoll
+	}
+	watcher := &InterfaceWatcher{
+		Mode:     mode,
+		Interval: m.opts.InterfaceWatchInterval,
+		Name:     m.wg.Name(),
+		Exists: func() (bool, error) {
+			m.mu.Lock()
+			wg := m.wg
+			m.mu.Unlock()
+			return InterfaceExists(wg)
+		},
+		Recover: m.recoverInterface,
+	}
+	ctx, cancel := context.WithCancel(context.WithLogger(context.Background(), context.LoggerFrom(ctx)))
+	done := make(chan struct{})
+	m.stopWatch = func() {
+		cancel()
+		<-done
+	}
+	go func() {
+		defer close(done)
+		watcher.Run(ctx)
+	}()
+}
+
+// recoverInterface recreates the wireguard interface from the options it was
+// started with and reconfigures peers, routes, and firewall rules.
+func (m *manager) recoverInterface(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return errors.New("network manager is closed")
+	}
+	log := context.LoggerFrom(ctx)
+	if err := m.wg.Close(ctx); err != nil {
+		log.Debug("Error closing removed wireguard interface", slog.String("error", err.Error()))
+	}
+	if m.fw != nil {
+		if err := m.fw.Close(ctx); err != nil {
+			log.Debug("Error clearing firewall rules", slog.String("error", err.Error()))
+		}
+	}
+	err := m.startInterface(ctx, m.startOpts)
+	if err == nil && m.masquerading {
+		err = m.fw.AddMasquerade(ctx, m.wg.Name())
+		if err != nil {
+			err = fmt.Errorf("add masquerade rule: %w", err)
+		}
+	}
+	if err != nil {
+		// Remove whatever was created so the next check retries.
+		if closeErr := m.wg.Close(ctx); closeErr != nil {
+			log.Debug("Error closing wireguard interface", slog.String("error", closeErr.Error()))
+		}
+	}
+	if m.dns != nil {
+		m.dns.mu.Lock()
+		m.dns.wg = m.wg
+		m.dns.mu.Unlock()
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = m.peers.Sync(ctx)
+	if err != nil {
+		return fmt.Errorf("sync peers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet_test
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestInterfaceWatcherRecoversInterface(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	for _, id := range []string{"node-a", "node-b"} {
+		key, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PublicKey: key}})
+		if err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b"}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{
+		NetworkACL: &v1.NetworkACL{
+			Name:             "allow-all",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+			SourceCIDRs:      []string{"*"},
+			DestinationCIDRs: []string{"*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+
+	mgr := testutil.NewManagerWithDB(db, meshnet.Options{
+		InterfaceName:          "webmesh0",
+		InterfaceWatchMode:     meshnet.InterfaceWatchPoll,
+		InterfaceWatchInterval: 10 * time.Millisecond,
+	}, "node-a")
+	err = mgr.Start(ctx, meshnet.StartOptions{Key: crypto.MustGenerateKey()})
+	if err != nil {
+		t.Fatalf("start network manager: %v", err)
+	}
+	t.Cleanup(func() { _ = mgr.Close(ctx) })
+	peers, err := meshnet.WireGuardPeersFor(ctx, db, "node-a")
+	if err != nil {
+		t.Fatalf("compute peers: %v", err)
+	}
+	if err := mgr.Peers().Refresh(ctx, peers); err != nil {
+		t.Fatalf("refresh peers: %v", err)
+	}
+
+	if _, ok := mgr.WireGuard().Peers()["node-b"]; !ok {
+		t.Fatal("expected node-b to be a peer before the interface is removed")
+	}
+
+	// Simulate the interface being deleted out from under us.
+	removed := mgr.WireGuard()
+	if err := removed.Destroy(ctx); err != nil {
+		t.Fatalf("destroy interface: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		wg := mgr.WireGuard()
+		if wg != removed {
+			if exists, err := meshnet.InterfaceExists(wg); err != nil || !exists {
+				t.Fatalf("expected recreated interface to exist, got %v: %v", exists, err)
+			}
+			if _, ok := wg.Peers()["node-b"]; ok {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the interface to be recovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInterfaceWatchMode(t *testing.T) {
+	t.Parallel()
+	for mode, want := range map[meshnet.InterfaceWatchMode]bool{
+		"":                             false,
+		meshnet.InterfaceWatchDisabled: false,
+		meshnet.InterfaceWatchPoll:     true,
+		meshnet.InterfaceWatchNetlink:  true,
+	} {
+		if !mode.IsValid() {
+			t.Errorf("expected %q to be valid", mode)
+		}
+		if mode.Enabled() != want {
+			t.Errorf("expected %q enabled to be %v", mode, want)
+		}
+	}
+	if meshnet.InterfaceWatchMode("inotify").IsValid() {
+		t.Error("expected unknown mode to be invalid")
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+// fakeWireGuard records peers in memory. Only the methods used by the peer
+// manager are implemented.
+type fakeWireGuard struct {
+	wireguard.Interface
+	peers map[string]wireguard.Peer
+	mu    sync.Mutex
+}
+
+func (f *fakeWireGuard) PutPeer(_ context.Context, peer *wireguard.Peer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.peers[peer.ID] = *peer
+	return nil
+}
+
+func (f *fakeWireGuard) DeletePeer(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.peers, id)
+	return nil
+}
+
+func (f *fakeWireGuard) Peers() map[string]wireguard.Peer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]wireguard.Peer, len(f.peers))
+	for id, peer := range f.peers {
+		out[id] = peer
+	}
+	return out
+}
+
+func TestSetPeerKeepalive(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	m := New(db, Options{PersistentKeepAlive: 25 * time.Second}, "node-a").(*manager)
+	wg := &fakeWireGuard{peers: make(map[string]wireguard.Peer)}
+	m.wg = wg
+
+	newPeer := func(id string) *v1.WireGuardPeer {
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		return &v1.WireGuardPeer{
+			Node: &v1.MeshNode{
+				Id:              id,
+				PublicKey:       encoded,
+				PrimaryEndpoint: "127.0.0.1:51820",
+			},
+			Proto: v1.ConnectProtocol_CONNECT_NATIVE,
+		}
+	}
+	peers := []*v1.WireGuardPeer{newPeer("flaky"), newPeer("stable")}
+	if err := m.Peers().Refresh(ctx, peers); err != nil {
+		t.Fatalf("refresh peers: %v", err)
+	}
+	keepalive := func(id string) time.Duration {
+		t.Helper()
+		peer, ok := wg.Peers()[id]
+		if !ok {
+			t.Fatalf("peer %s not configured", id)
+		}
+		return peer.PersistentKeepAlive
+	}
+	if got := keepalive("flaky"); got != 0 {
+		t.Fatalf("expected no override before it is set, got %s", got)
+	}
+
+	// The override should be applied to the live peer.
+	if err := m.SetPeerKeepalive(ctx, "flaky", 5*time.Second); err != nil {
+		t.Fatalf("set peer keepalive: %v", err)
+	}
+	if got := keepalive("flaky"); got != 5*time.Second {
+		t.Errorf("expected keepalive of 5s, got %s", got)
+	}
+	if got := keepalive("stable"); got != 0 {
+		t.Errorf("expected other peers to keep the default, got %s", got)
+	}
+
+	// The override should survive a refresh of the peer.
+	if err := m.Peers().Refresh(ctx, peers); err != nil {
+		t.Fatalf("refresh peers: %v", err)
+	}
+	if got := keepalive("flaky"); got != 5*time.Second {
+		t.Errorf("expected keepalive of 5s after refresh, got %s", got)
+	}
+
+	// Clearing the override should fall back to the default.
+	if err := m.SetPeerKeepalive(ctx, "flaky", 0); err != nil {
+		t.Fatalf("clear peer keepalive: %v", err)
+	}
+	if err := m.Peers().Refresh(ctx, peers); err != nil {
+		t.Fatalf("refresh peers: %v", err)
+	}
+	if got := keepalive("flaky"); got != 0 {
+		t.Errorf("expected the override to be removed, got %s", got)
+	}
+
+	if err := m.SetPeerKeepalive(ctx, "flaky", -time.Second); err == nil {
+		t.Error("expected negative keepalive to be rejected")
+	}
+}
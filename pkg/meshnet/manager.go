@@ -23,18 +23,22 @@ import (
 	"net"
 	"net/netip"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc"
 
 	"github.com/webmeshproj/webmesh/pkg/common"
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/dns"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
@@ -59,6 +63,9 @@ type Options struct {
 	PersistentKeepAlive time.Duration
 	// ForceTUN is whether to force the use of TUN.
 	ForceTUN bool
+	// Implementation is the wireguard implementation to use. Auto prefers
+	// the kernel module and falls back to userspace when it is unavailable.
+	Implementation wireguard.Implementation
 	// MTU is the MTU to use for the wireguard interface.
 	MTU int
 	// RecordMetrics is whether to enable metrics recording.
@@ -69,7 +76,9 @@ type Options struct {
 	StoragePort int
 	// GRPCPort is the port being used for gRPC.
 	GRPCPort int
-	// ZoneAwarenessID is the zone awareness ID.
+	// ZoneAwarenessID is the zone awareness ID. Peers sharing a zone are
+	// reached on their LAN endpoints when one is inside a local CIDR. When
+	// several are, one is chosen by rendezvous hashing on our node ID.
 	ZoneAwarenessID string
 	// Credentials are the dial options to use when calling peer nodes.
 	Credentials []grpc.DialOption
@@ -81,8 +90,58 @@ type Options struct {
 	DisableIPv6 bool
 	// DisableFullTunnel will ignore routes for a default gateway.
 	DisableFullTunnel bool
+	// FirewallBackend is the firewall backend to use.
+	FirewallBackend firewall.Backend
+	// RestrictControlPlane restricts the storage and gRPC ports to
+	// traffic arriving over the wireguard interface.
+	RestrictControlPlane bool
+	// FwMark is the firewall mark to set on WireGuard packets.
+	FwMark int
+	// RoutingTable is the routing table to install mesh routes into.
+	// If unset, routes are added to the main table.
+	RoutingTable int
 	// IgnoreRoutes are additional routes to ignore.
 	IgnoreRoutes []netip.Prefix
+	// EqualCostMultipath will use every peer tied for the lowest metric
+	// for a route instead of picking one deterministically.
+	EqualCostMultipath bool
+	// ExitNode is the exit node to route default traffic through. Exit
+	// routes advertised by other nodes are never used.
+	ExitNode types.NodeID
+	// MaxAllowedIPs is the maximum number of allowed IPs to configure
+	// for a single peer. Zero means no limit.
+	MaxAllowedIPs int
+	// SummarizeAllowedIPs aggregates each peer's allowed IPs into the
+	// smallest covering set of prefixes.
+	SummarizeAllowedIPs bool
+	// RequireSignedRoutes ignores routes that are not signed by the node
+	// advertising them.
+	RequireSignedRoutes bool
+	// InterfaceWatchMode is how to watch for the wireguard interface being
+	// removed from the system. When it is, the interface is recreated and
+	// reconfigured. An empty value disables the watcher.
+	InterfaceWatchMode InterfaceWatchMode
+	// InterfaceWatchInterval is the interval for polling the interface.
+	InterfaceWatchInterval time.Duration
+	// HostOverlapCheck is what to do when the mesh networks overlap
+	// addresses or routes already configured on the host. An empty value
+	// disables the check.
+	HostOverlapCheck endpoints.OverlapCheckMode
+	// Preflight runs the host preflight checks on Start and fails it
+	// when a critical check does not pass.
+	Preflight bool
+	// EnableIPForwarding enables the IP forwarding sysctls for the enabled
+	// address families on Start. Start fails if they cannot be set.
+	EnableIPForwarding bool
+	// RestoreIPForwarding restores the IP forwarding sysctls changed by
+	// EnableIPForwarding when the manager is closed.
+	RestoreIPForwarding bool
+	// PeerGraphSource supplies the WireGuard peers of this node when they
+	// are synced. Defaults to walking the peer graph in the mesh database.
+	PeerGraphSource PeerGraphSource
+	// Roaming are options for accepting endpoints learned by WireGuard
+	// when a peer roams.
+	Roaming RoamingOptions
 	// Relays are options for when presented with the need to negotiate
 	// p2p data channels.
 	Relays RelayOptions
@@ -90,25 +149,40 @@ type Options struct {
 
 func (o *Options) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]any{
-		"netNs":                 o.NetNs,
-		"interfaceName":         o.InterfaceName,
-		"forceReplace":          o.ForceReplace,
-		"listenPort":            o.ListenPort,
-		"modprobe":              o.Modprobe,
-		"persistentKeepAlive":   o.PersistentKeepAlive,
-		"forceTUN":              o.ForceTUN,
-		"mtu":                   o.MTU,
-		"recordMetrics":         o.RecordMetrics,
-		"recordMetricsInterval": o.RecordMetricsInterval,
-		"storagePort":           o.StoragePort,
-		"grpcPort":              o.GRPCPort,
-		"zoneAwarenessID":       o.ZoneAwarenessID,
-		"localDNSAddr":          o.LocalDNSAddr,
-		"disableIPv4":           o.DisableIPv4,
-		"disableIPv6":           o.DisableIPv6,
-		"disableFullTunnel":     o.DisableFullTunnel,
-		"ignoreRoutes":          o.IgnoreRoutes,
-		"relays":                o.Relays,
+		"netNs":                  o.NetNs,
+		"interfaceName":          o.InterfaceName,
+		"forceReplace":           o.ForceReplace,
+		"listenPort":             o.ListenPort,
+		"modprobe":               o.Modprobe,
+		"persistentKeepAlive":    o.PersistentKeepAlive,
+		"forceTUN":               o.ForceTUN,
+		"implementation":         o.Implementation,
+		"mtu":                    o.MTU,
+		"recordMetrics":          o.RecordMetrics,
+		"recordMetricsInterval":  o.RecordMetricsInterval,
+		"storagePort":            o.StoragePort,
+		"grpcPort":               o.GRPCPort,
+		"zoneAwarenessID":        o.ZoneAwarenessID,
+		"localDNSAddr":           o.LocalDNSAddr,
+		"disableIPv4":            o.DisableIPv4,
+		"disableIPv6":            o.DisableIPv6,
+		"disableFullTunnel":      o.DisableFullTunnel,
+		"firewallBackend":        o.FirewallBackend,
+		"restrictControlPlane":   o.RestrictControlPlane,
+		"fwMark":                 o.FwMark,
+		"routingTable":           o.RoutingTable,
+		"ignoreRoutes":           o.IgnoreRoutes,
+		"equalCostMultipath":     o.EqualCostMultipath,
+		"exitNode":               o.ExitNode,
+		"maxAllowedIPs":          o.MaxAllowedIPs,
+		"summarizeAllowedIPs":    o.SummarizeAllowedIPs,
+		"interfaceWatchMode":     o.InterfaceWatchMode,
+		"interfaceWatchInterval": o.InterfaceWatchInterval,
+		"hostOverlapCheck":       o.HostOverlapCheck,
+		"preflight":              o.Preflight,
+		"enableIPForwarding":     o.EnableIPForwarding,
+		"restoreIPForwarding":    o.RestoreIPForwarding,
+		"relays":                 o.Relays,
 	})
 }
 
@@ -153,12 +227,58 @@ type Manager interface {
 	DNS() DNSManager
 	// Peers return the peer manager.
 	Peers() PeerManager
+	// Zones returns the zones this node is currently available in.
+	Zones() []string
+	// SetZones updates the zones this node is available in and re-evaluates
+	// the endpoints used for all peers.
+	SetZones(ctx context.Context, zones []string) error
 	// Firewall returns the firewall.
 	// The firewall is only available after Start has been called.
 	Firewall() firewall.Firewall
 	// WireGuard returns the wireguard interface.
 	// The wireguard interface is only available after Start has been called.
 	WireGuard() wireguard.Interface
+	// DumpConfig returns the effective configuration of the wireguard interface
+	// for diagnostics. It returns an error if Start has not been called.
+	DumpConfig(ctx context.Context) (*wireguard.DeviceConfig, error)
+	// Reconfigure forces a full rebuild of the local network. Peers are
+	// recomputed from storage and re-applied, peers unknown to the mesh are
+	// removed, and mesh routes and firewall rules are reconciled. It returns
+	// a summary of the peers that changed.
+	Reconfigure(ctx context.Context) (*ReconfigureResult, error)
+	// VerifyPeers checks connectivity to all current peers. Peers are
+	// reported unhealthy when their last handshake is stale, or when
+	// pinging is enabled and their mesh address does not reply.
+	VerifyPeers(ctx context.Context, opts VerifyPeersOptions) ([]PeerStatus, error)
+	// CheckHostOverlap compares the given networks against the addresses
+	// and routes already configured on the host, ignoring the mesh
+	// interface itself. Overlaps are logged, and an endpoints.OverlapError
+	// is returned when the configured check mode is error.
+	CheckHostOverlap(ctx context.Context, networks ...netip.Prefix) error
+	// EffectiveRoutes returns the routes installed for peers as a result of
+	// mesh routes and allowed IPs, computed from the current peer and route
+	// state in storage.
+	EffectiveRoutes(ctx context.Context) ([]EffectiveRoute, error)
+	// SetPeerKeepalive sets the persistent keepalive interval for the given
+	// peer, overriding the PersistentKeepAlive option. The override is
+	// applied to the live peer if it is configured, and kept for every
+	// later refresh of the peer. A zero interval removes the override.
+	SetPeerKeepalive(ctx context.Context, peerID string, interval time.Duration) error
+	// SetPeerPresharedKey sets the WireGuard preshared key for the given
+	// peer. It is used for keys negotiated during a join, before the edges
+	// holding them can be read from storage. The key is applied to the live
+	// peer if it is configured. A zero key removes it.
+	SetPeerPresharedKey(ctx context.Context, peerID string, key wgtypes.Key) error
+	// Preflight validates that the host can run the mesh network with the
+	// current options. It checks for the required capabilities, IP
+	// forwarding, and the kernel module or TUN support needed by the
+	// WireGuard implementation. It can be called before Start.
+	Preflight(ctx context.Context) []CheckResult
+	// RoamedEndpoints returns the peers whose live WireGuard endpoint is
+	// not one of the endpoints stored for them. Each is validated against
+	// the roaming options, and only accepted endpoints should be adopted
+	// with AdoptRoamedEndpoint.
+	RoamedEndpoints(ctx context.Context) ([]RoamedEndpoint, error)
 	// Close closes the network manager and cleans up any resources.
 	Close(ctx context.Context) error
 }
@@ -169,6 +289,13 @@ func New(store storage.MeshDB, opts Options, nodeID types.NodeID) Manager {
 		nodeID:  nodeID,
 		storage: store,
 		opts:    opts,
+		zones:   types.ParseZones(opts.ZoneAwarenessID),
+		probes:  DefaultPreflightProbes(),
+		fwd:     routes.NewForwarding(routes.SystemSysctl()),
+		graph:   opts.PeerGraphSource,
+	}
+	if m.graph == nil {
+		m.graph = NewStoragePeerGraphSource(store)
 	}
 	m.peers = newPeerManager(m)
 	return m
@@ -185,7 +312,31 @@ type manager struct {
 	wg                   wireguard.Interface
 	networkv4, networkv6 netip.Prefix
 	masquerading         bool
+	impl                 wireguard.Implementation
+	startOpts            StartOptions
+	stopWatch            func()
+	closed               bool
+	zones                []string
+	probes               PreflightProbes
+	fwd                  *routes.Forwarding
+	graph                PeerGraphSource
 	mu                   sync.Mutex
+	zonemu               sync.RWMutex
+}
+
+// peerMapOptions returns the options for computing the peers of this node.
+func (m *manager) peerMapOptions() PeerMapOptions {
+	return PeerMapOptions{
+		EqualCostMultipath:  m.opts.EqualCostMultipath,
+		ExitNode:            m.opts.ExitNode,
+		MaxAllowedIPs:       m.opts.MaxAllowedIPs,
+		SummarizeAllowedIPs: m.opts.SummarizeAllowedIPs,
+		RequireSignedRoutes: m.opts.RequireSignedRoutes,
+	}
+}
+
+func (m *manager) Preflight(ctx context.Context) []CheckResult {
+	return RunPreflight(ctx, m.opts, m.probes)
 }
 
 func (m *manager) DNS() DNSManager {
@@ -196,6 +347,27 @@ func (m *manager) Peers() PeerManager {
 	return m.peers
 }
 
+func (m *manager) Zones() []string {
+	m.zonemu.RLock()
+	defer m.zonemu.RUnlock()
+	return slices.Clone(m.zones)
+}
+
+func (m *manager) SetZones(ctx context.Context, zones []string) error {
+	zoneID, err := types.JoinZones(zones)
+	if err != nil {
+		return err
+	}
+	m.zonemu.Lock()
+	m.zones = types.ParseZones(zoneID)
+	m.zonemu.Unlock()
+	if m.WireGuard() == nil {
+		// Peers will be evaluated with the new zones once we start.
+		return nil
+	}
+	return m.peers.Sync(ctx)
+}
+
 func (m *manager) NetworkV4() netip.Prefix {
 	return m.networkv4
 }
@@ -217,19 +389,60 @@ func (m *manager) WireGuard() wireguard.Interface {
 	return m.wg
 }
 
+func (m *manager) DumpConfig(ctx context.Context) (*wireguard.DeviceConfig, error) {
+	m.mu.Lock()
+	wg := m.wg
+	m.mu.Unlock()
+	if wg == nil {
+		return nil, fmt.Errorf("wireguard interface is not available")
+	}
+	return wg.DumpConfig(ctx)
+}
+
 func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.key = opts.Key
 	log := context.LoggerFrom(ctx).With("component", "net-manager")
 	log.Info("Starting mesh network manager")
+	if m.opts.Preflight {
+		results := RunPreflight(ctx, m.opts, m.probes)
+		for _, res := range results {
+			if !res.Passed {
+				log.Warn("Preflight check failed",
+					slog.String("check", res.Name),
+					slog.Bool("critical", res.Critical),
+					slog.String("message", res.Message),
+					slog.String("remedy", res.Remedy),
+				)
+			}
+		}
+		if err := CheckPreflight(results); err != nil {
+			return err
+		}
+	}
+	var loadModule func(context.Context) error
 	if m.opts.Modprobe && runtime.GOOS == "linux" {
-		log.Debug("Attempting to load wireguard kernel module")
-		err := common.Exec(ctx, "modprobe", "wireguard")
-		if err != nil {
-			log.Warn("Failed to load wireguard kernel module", slog.String("error", err.Error()))
+		loadModule = func(ctx context.Context) error {
+			return common.Exec(ctx, "modprobe", "wireguard")
 		}
 	}
+	if m.opts.EnableIPForwarding {
+		log.Debug("Enabling IP forwarding sysctls")
+		if err := m.fwd.Enable(!m.opts.DisableIPv4, !m.opts.DisableIPv6); err != nil {
+			return fmt.Errorf("enable ip forwarding: %w", err)
+		}
+	}
+	impl := m.opts.Implementation
+	if m.opts.ForceTUN {
+		impl = wireguard.ImplementationUserspace
+	}
+	impl, err := wireguard.SelectImplementation(context.WithLogger(ctx, log), impl, loadModule)
+	if err != nil {
+		return err
+	}
+	log.Debug("Selected wireguard implementation", slog.String("implementation", string(impl)))
+	m.impl = impl
 	log.Debug("Network manager start options", slog.Any("start-opts", opts))
 	handleErr := func(err error) error {
 		if m.wg != nil {
@@ -244,7 +457,37 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 		}
 		return err
 	}
-	var err error
+	err = m.startInterface(ctx, opts)
+	if err != nil {
+		return handleErr(err)
+	}
+	m.dns = &dnsManager{
+		wg:           m.wg,
+		storage:      m.storage,
+		localdnsaddr: m.opts.LocalDNSAddr,
+		dnsservers:   []netip.AddrPort{},
+		noIPv4:       m.opts.DisableIPv4,
+		noIPv6:       m.opts.DisableIPv6,
+	}
+	m.startOpts = opts
+	m.startInterfaceWatch(ctx)
+	return nil
+}
+
+// startInterface creates and configures the wireguard interface, mesh routes,
+// and firewall from the given options.
+func (m *manager) startInterface(ctx context.Context, opts StartOptions) error {
+	log := context.LoggerFrom(ctx).With("component", "net-manager")
+	var networks []netip.Prefix
+	if !m.opts.DisableIPv4 {
+		networks = append(networks, opts.NetworkV4)
+	}
+	if !m.opts.DisableIPv6 {
+		networks = append(networks, opts.NetworkV6)
+	}
+	if err := m.checkHostOverlap(context.WithLogger(ctx, log), networks...); err != nil {
+		return fmt.Errorf("check host networks: %w", err)
+	}
 	// TODO: Getting close (if not already there) to just needing to embed
 	// the wireguard options in the manager options.
 	wgopts := &wireguard.Options{
@@ -254,6 +497,7 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 		Name:                m.opts.InterfaceName,
 		ForceName:           m.opts.ForceReplace,
 		ForceTUN:            m.opts.ForceTUN,
+		Implementation:      m.impl,
 		PersistentKeepAlive: m.opts.PersistentKeepAlive,
 		MTU:                 m.opts.MTU,
 		Metrics:             m.opts.RecordMetrics,
@@ -266,37 +510,32 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 		DisableIPv4:         m.opts.DisableIPv4,
 		DisableIPv6:         m.opts.DisableIPv6,
 		DisableFullTunnel:   m.opts.DisableFullTunnel,
+		FwMark:              m.opts.FwMark,
+		RoutingTable:        m.opts.RoutingTable,
 	}
 	log.Debug("Configuring wireguard", slog.Any("opts", wgopts))
-	m.wg, err = wireguard.New(ctx, wgopts)
+	wg, err := wireguard.New(ctx, wgopts)
 	if err != nil {
-		return handleErr(fmt.Errorf("new wireguard interface: %w", err))
-	}
-	m.dns = &dnsManager{
-		wg:           m.wg,
-		storage:      m.storage,
-		localdnsaddr: m.opts.LocalDNSAddr,
-		dnsservers:   []netip.AddrPort{},
-		noIPv4:       m.opts.DisableIPv4,
-		noIPv6:       m.opts.DisableIPv6,
+		return fmt.Errorf("new wireguard interface: %w", err)
 	}
+	m.wg = wg
 	err = m.wg.Configure(ctx, opts.Key)
 	if err != nil {
-		return handleErr(fmt.Errorf("configure wireguard: %w", err))
+		return fmt.Errorf("configure wireguard: %w", err)
 	}
 	if opts.NetworkV6.IsValid() && !m.opts.DisableIPv6 {
 		m.networkv6 = opts.NetworkV6
 		log.Debug("Adding IPv6 network route", slog.String("network", opts.NetworkV6.String()))
 		err = m.wg.AddRoute(ctx, opts.NetworkV6)
 		if err != nil && !system.IsRouteExists(err) {
-			return handleErr(fmt.Errorf("wireguard add mesh network route: %w", err))
+			return fmt.Errorf("wireguard add mesh network route: %w", err)
 		}
 	}
 	if opts.AddressV6.IsValid() && !m.opts.DisableIPv6 {
 		log.Debug("Adding IPv6 address route", slog.String("address", opts.AddressV6.String()))
 		err = m.wg.AddRoute(ctx, opts.AddressV6)
 		if err != nil && !system.IsRouteExists(err) {
-			return handleErr(fmt.Errorf("wireguard add ipv6 route: %w", err))
+			return fmt.Errorf("wireguard add ipv6 route: %w", err)
 		}
 	}
 	if opts.NetworkV4.IsValid() && !m.opts.DisableIPv4 {
@@ -304,30 +543,47 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 		log.Debug("Adding IPv4 network route", slog.String("network", opts.NetworkV4.String()))
 		err = m.wg.AddRoute(ctx, opts.NetworkV4)
 		if err != nil && !system.IsRouteExists(err) {
-			return handleErr(fmt.Errorf("wireguard add mesh network route: %w", err))
+			return fmt.Errorf("wireguard add mesh network route: %w", err)
 		}
 	}
+	return m.configureFirewall(ctx)
+}
+
+// configureFirewall creates the firewall for the wireguard interface and
+// allows forwarding traffic over it.
+func (m *manager) configureFirewall(ctx context.Context) error {
+	log := context.LoggerFrom(ctx).With("component", "net-manager")
 	realPort, err := m.wg.ListenPort()
 	if err != nil {
-		return handleErr(fmt.Errorf("lookup wireguard listen port: %w", err))
+		return fmt.Errorf("lookup wireguard listen port: %w", err)
 	}
 	fwopts := &firewall.Options{
-		ID:            m.nodeID.String(),
-		NetNs:         m.opts.NetNs,
-		DefaultPolicy: firewall.PolicyAccept, // TODO: Make this configurable
-		WireguardPort: uint16(realPort),
-		StoragePort:   uint16(m.opts.StoragePort),
-		GRPCPort:      uint16(m.opts.GRPCPort),
+		ID:                   m.nodeID.String(),
+		NetNs:                m.opts.NetNs,
+		Backend:              m.opts.FirewallBackend,
+		DefaultPolicy:        firewall.PolicyAccept, // TODO: Make this configurable
+		WireguardPort:        uint16(realPort),
+		StoragePort:          uint16(m.opts.StoragePort),
+		GRPCPort:             uint16(m.opts.GRPCPort),
+		InterfaceName:        m.wg.Name(),
+		RestrictControlPlane: m.opts.RestrictControlPlane,
 	}
 	log.Debug("Configuring firewall", slog.Any("opts", fwopts))
 	m.fw, err = firewall.New(ctx, fwopts)
 	if err != nil {
-		return handleErr(fmt.Errorf("new firewall manager: %w", err))
+		return fmt.Errorf("new firewall manager: %w", err)
+	}
+	var family firewall.Family
+	if !m.opts.DisableIPv4 {
+		family |= firewall.FamilyIPv4
 	}
-	log.Debug("Configuring forwarding on wireguard interface", slog.String("interface", m.wg.Name()))
-	err = m.fw.AddWireguardForwarding(ctx, m.wg.Name())
+	if !m.opts.DisableIPv6 {
+		family |= firewall.FamilyIPv6
+	}
+	log.Debug("Configuring forwarding on wireguard interface", slog.String("interface", m.wg.Name()), slog.String("family", family.String()))
+	err = m.fw.AddWireguardForwarding(ctx, m.wg.Name(), family)
 	if err != nil {
-		return handleErr(fmt.Errorf("add wireguard forwarding rule: %w", err))
+		return fmt.Errorf("add wireguard forwarding rule: %w", err)
 	}
 	return nil
 }
@@ -384,6 +640,35 @@ func (m *manager) Dial(ctx context.Context, network, address string) (net.Conn,
 	return dialer.DialContext(ctx, network, address)
 }
 
+func (m *manager) SetPeerKeepalive(ctx context.Context, peerID string, interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("keepalive interval must not be negative")
+	}
+	return m.peers.setKeepalive(ctx, peerID, interval)
+}
+
+func (m *manager) SetPeerPresharedKey(ctx context.Context, peerID string, key wgtypes.Key) error {
+	return m.peers.setPresharedKey(ctx, peerID, key)
+}
+
+func (m *manager) CheckHostOverlap(ctx context.Context, networks ...netip.Prefix) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkHostOverlap(ctx, networks...)
+}
+
+func (m *manager) checkHostOverlap(ctx context.Context, networks ...netip.Prefix) error {
+	skip := []string{m.opts.InterfaceName}
+	if m.wg != nil {
+		skip = append(skip, m.wg.Name())
+	}
+	check := endpoints.OverlapCheck{
+		Mode:           m.opts.HostOverlapCheck,
+		SkipInterfaces: skip,
+	}
+	return check.Run(ctx, networks...)
+}
+
 func (m *manager) StartMasquerade(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -399,10 +684,23 @@ func (m *manager) StartMasquerade(ctx context.Context) error {
 }
 
 func (m *manager) Close(ctx context.Context) error {
+	if m.stopWatch != nil {
+		m.stopWatch()
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.closed = true
 	log := context.LoggerFrom(ctx).With("component", "net-manager")
 	defer m.peers.Close(context.WithLogger(ctx, log))
+	if m.opts.RestoreIPForwarding {
+		// Restore forwarding last, once nothing is routed anymore.
+		defer func() {
+			log.Debug("Restoring IP forwarding sysctls")
+			if err := m.fwd.Restore(); err != nil {
+				log.Error("error restoring ip forwarding", slog.String("error", err.Error()))
+			}
+		}()
+	}
 	if m.fw != nil {
 		// Clear the firewall rules after wireguard is shutdown
 		defer func() {
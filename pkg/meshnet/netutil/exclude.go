@@ -0,0 +1,67 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import "net/netip"
+
+// Exclude returns the prefixes covering every address in prefixes that is not
+// in any of the excluded prefixes. Prefixes partially covered by an exclusion
+// are split into the largest prefixes around it, e.g. excluding 10.5.0.0/16 from
+// 10.0.0.0/8 leaves 10.0.0.0/14, 10.4.0.0/16, 10.6.0.0/15 and so on. The result
+// is summarized and the input is not modified.
+func Exclude(prefixes, excluded []netip.Prefix) []netip.Prefix {
+	work := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix.IsValid() {
+			work = append(work, prefix.Masked())
+		}
+	}
+	out := make([]netip.Prefix, 0, len(work))
+	for len(work) > 0 {
+		prefix := work[len(work)-1]
+		work = work[:len(work)-1]
+		keep := true
+		for _, exclusion := range excluded {
+			if !exclusion.IsValid() || !prefix.Overlaps(exclusion) {
+				continue
+			}
+			keep = false
+			if exclusion.Bits() > prefix.Bits() {
+				// Only part of the prefix is excluded, split it in half
+				// and check each half again.
+				work = append(work, splitPrefix(prefix)...)
+			}
+			break
+		}
+		if keep {
+			out = append(out, prefix)
+		}
+	}
+	return Summarize(out)
+}
+
+// splitPrefix returns the two halves of the given masked prefix.
+func splitPrefix(prefix netip.Prefix) []netip.Prefix {
+	bits := prefix.Bits() + 1
+	addr := prefix.Addr().AsSlice()
+	addr[prefix.Bits()/8] |= 0x80 >> (prefix.Bits() % 8)
+	upper, _ := netip.AddrFromSlice(addr)
+	return []netip.Prefix{
+		netip.PrefixFrom(prefix.Addr(), bits),
+		netip.PrefixFrom(upper, bits),
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestExclude(t *testing.T) {
+	t.Parallel()
+
+	tc := []struct {
+		name     string
+		prefixes []string
+		excluded []string
+		want     []string
+	}{
+		{
+			name:     "NoExclusions",
+			prefixes: []string{"10.0.0.0/8"},
+			want:     []string{"10.0.0.0/8"},
+		},
+		{
+			name:     "Disjoint",
+			prefixes: []string{"10.0.0.0/8"},
+			excluded: []string{"192.168.0.0/16", "fd00::/8"},
+			want:     []string{"10.0.0.0/8"},
+		},
+		{
+			name:     "FullyExcluded",
+			prefixes: []string{"10.5.0.0/16"},
+			excluded: []string{"10.0.0.0/8"},
+			want:     []string{},
+		},
+		{
+			name:     "HalfExcluded",
+			prefixes: []string{"10.0.0.0/23"},
+			excluded: []string{"10.0.1.0/24"},
+			want:     []string{"10.0.0.0/24"},
+		},
+		{
+			name:     "SplitAroundExclusion",
+			prefixes: []string{"10.0.0.0/8"},
+			excluded: []string{"10.5.0.0/16"},
+			want: []string{
+				"10.0.0.0/14", "10.4.0.0/16", "10.6.0.0/15", "10.8.0.0/13",
+				"10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/9",
+			},
+		},
+		{
+			name:     "MultipleExclusions",
+			prefixes: []string{"10.0.0.0/22"},
+			excluded: []string{"10.0.1.0/24", "10.0.3.0/24"},
+			want:     []string{"10.0.0.0/24", "10.0.2.0/24"},
+		},
+		{
+			name:     "IPv6",
+			prefixes: []string{"fd00::/62"},
+			excluded: []string{"fd00:0:0:1::/64"},
+			want:     []string{"fd00::/64", "fd00:0:0:2::/63"},
+		},
+		{
+			name:     "MixedFamilies",
+			prefixes: []string{"0.0.0.0/0", "::/0"},
+			excluded: []string{"128.0.0.0/1", "8000::/1"},
+			want:     []string{"0.0.0.0/1", "::/1"},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			parse := func(ss []string) []netip.Prefix {
+				out := make([]netip.Prefix, len(ss))
+				for i, s := range ss {
+					out[i] = netip.MustParsePrefix(s)
+				}
+				return out
+			}
+			got := Exclude(parse(tt.prefixes), parse(tt.excluded))
+			gotStrs := make([]string, len(got))
+			for i, p := range got {
+				gotStrs[i] = p.String()
+			}
+			if !slices.Equal(gotStrs, tt.want) {
+				t.Errorf("Exclude(%v, %v) = %v, want %v", tt.prefixes, tt.excluded, gotStrs, tt.want)
+			}
+		})
+	}
+}
@@ -39,6 +39,31 @@ const (
 	DefaultNodeBits = 112
 )
 
+// ULARange is the locally assigned half of the unique local address space
+// defined in RFC 4193.
+var ULARange = netip.MustParsePrefix("fd00::/8")
+
+// ValidateULA checks that the given prefix is a locally assigned unique
+// local address prefix suitable for a mesh network.
+func ValidateULA(prefix netip.Prefix) error {
+	if !prefix.IsValid() {
+		return fmt.Errorf("invalid prefix")
+	}
+	if !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return fmt.Errorf("%s is not an IPv6 prefix", prefix)
+	}
+	if !ULARange.Contains(prefix.Addr()) {
+		return fmt.Errorf("%s is not within the unique local address range %s", prefix, ULARange)
+	}
+	if prefix.Bits() != DefaultULABits {
+		return fmt.Errorf("%s must be a /%d prefix", prefix, DefaultULABits)
+	}
+	if prefix.Masked() != prefix {
+		return fmt.Errorf("%s has host bits set, expected %s", prefix, prefix.Masked())
+	}
+	return nil
+}
+
 // GenerateULA generates a unique local address with a /48 prefix
 // according to RFC 4193. The network is returned as a netip.Prefix.
 func GenerateULA() (netip.Prefix, error) {
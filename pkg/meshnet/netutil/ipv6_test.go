@@ -63,6 +63,34 @@ func FuzzGenerateULA(t *testing.F) {
 
 // FuzzGenerateULAWithSeed is for checking that given a seed we consistently generate
 // the same /32 ULA.
+func TestValidateULA(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		prefix  netip.Prefix
+		wantErr bool
+	}{
+		{"Generated", GenerateULAWithSeed([]byte("seed")), false},
+		{"Supplied", netip.MustParsePrefix("fdaa:bbcc:ddee::/48"), false},
+		{"Invalid", netip.Prefix{}, true},
+		{"IPv4", netip.MustParsePrefix("172.16.0.0/12"), true},
+		{"GlobalUnicast", netip.MustParsePrefix("2001:db8::/48"), true},
+		{"CentrallyAssigned", netip.MustParsePrefix("fc00:1234:5678::/48"), true},
+		{"WrongLength", netip.MustParsePrefix("fdaa:bbcc::/32"), true},
+		{"HostBitsSet", netip.MustParsePrefix("fdaa:bbcc:ddee::1/48"), true},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateULA(tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateULA(%s) error = %v, wantErr %v", tt.prefix, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func FuzzGenerateULAWithSeed(t *testing.F) {
 	tc := defaultTestCount(t)
 	for i := 0; i <= tc; i++ {
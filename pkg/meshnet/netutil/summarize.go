@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// Summarize returns the smallest set of prefixes covering exactly the same
+// addresses as the given prefixes. Prefixes contained in others are dropped and
+// aligned sibling prefixes are merged into their parent, e.g. 10.0.0.0/24 and
+// 10.0.1.0/24 become 10.0.0.0/23 while 10.0.1.0/24 and 10.0.2.0/24 are kept.
+// IPv4 and IPv6 prefixes are summarized separately and IPv4 prefixes are sorted
+// first in the result. Invalid prefixes are ignored and the input is not modified.
+func Summarize(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if !prefix.IsValid() {
+			continue
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		sorted = append(sorted, prefix.Masked())
+	}
+	// Sorting by address and then by length places every prefix directly
+	// after the prefix that contains it, if any.
+	slices.SortFunc(sorted, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	out := make([]netip.Prefix, 0, len(sorted))
+	for _, prefix := range sorted {
+		if len(out) > 0 && containsPrefix(out[len(out)-1], prefix) {
+			continue
+		}
+		out = append(out, prefix)
+		// Merge siblings for as long as the tail can be aggregated.
+		for len(out) >= 2 {
+			parent, ok := mergeSiblings(out[len(out)-2], out[len(out)-1])
+			if !ok {
+				break
+			}
+			out = append(out[:len(out)-2], parent)
+		}
+	}
+	return out
+}
+
+// containsPrefix reports if a contains all addresses in b.
+func containsPrefix(a, b netip.Prefix) bool {
+	return a.Addr().BitLen() == b.Addr().BitLen() && a.Bits() <= b.Bits() && a.Contains(b.Addr())
+}
+
+// mergeSiblings returns the parent of a and b if they are the two halves of it.
+func mergeSiblings(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Addr().BitLen() != b.Addr().BitLen() || a.Bits() != b.Bits() || a.Bits() == 0 || a == b {
+		return netip.Prefix{}, false
+	}
+	parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+	if parent != netip.PrefixFrom(b.Addr(), b.Bits()-1).Masked() {
+		return netip.Prefix{}, false
+	}
+	return parent, true
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+
+	tc := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{
+			name: "Empty",
+			in:   nil,
+			want: []string{},
+		},
+		{
+			name: "Single",
+			in:   []string{"10.0.0.0/24"},
+			want: []string{"10.0.0.0/24"},
+		},
+		{
+			name: "Duplicates",
+			in:   []string{"10.0.0.0/24", "10.0.0.0/24"},
+			want: []string{"10.0.0.0/24"},
+		},
+		{
+			name: "UnmaskedInput",
+			in:   []string{"10.0.0.1/24", "10.0.1.1/24"},
+			want: []string{"10.0.0.0/23"},
+		},
+		{
+			name: "AlignedIPv4Siblings",
+			in:   []string{"10.0.1.0/24", "10.0.0.0/24"},
+			want: []string{"10.0.0.0/23"},
+		},
+		{
+			name: "UnalignedIPv4Neighbors",
+			in:   []string{"10.0.1.0/24", "10.0.2.0/24"},
+			want: []string{"10.0.1.0/24", "10.0.2.0/24"},
+		},
+		{
+			name: "DifferentLengths",
+			in:   []string{"10.0.0.0/24", "10.0.1.0/25"},
+			want: []string{"10.0.0.0/24", "10.0.1.0/25"},
+		},
+		{
+			name: "NonAdjacent",
+			in:   []string{"10.0.0.0/24", "192.168.0.0/24"},
+			want: []string{"10.0.0.0/24", "192.168.0.0/24"},
+		},
+		{
+			name: "Contained",
+			in:   []string{"10.1.2.3/32", "10.1.2.0/24", "10.0.0.0/8"},
+			want: []string{"10.0.0.0/8"},
+		},
+		{
+			name: "Overlapping",
+			in:   []string{"10.0.0.0/23", "10.0.1.0/24", "10.0.2.0/23"},
+			want: []string{"10.0.0.0/22"},
+		},
+		{
+			name: "Cascading",
+			in:   []string{"10.0.0.0/25", "10.0.0.128/25", "10.0.1.0/24", "10.0.2.0/23"},
+			want: []string{"10.0.0.0/22"},
+		},
+		{
+			name: "CascadingAfterGap",
+			in:   []string{"10.0.0.0/24", "10.0.2.0/24", "10.0.3.0/24", "10.0.1.0/24", "10.0.5.0/24"},
+			want: []string{"10.0.0.0/22", "10.0.5.0/24"},
+		},
+		{
+			name: "IPv4HalvesOfTheInternet",
+			in:   []string{"0.0.0.0/1", "128.0.0.0/1"},
+			want: []string{"0.0.0.0/0"},
+		},
+		{
+			name: "IPv4Hosts",
+			in:   []string{"10.0.0.1/32", "10.0.0.0/32", "10.0.0.2/32", "10.0.0.3/32"},
+			want: []string{"10.0.0.0/30"},
+		},
+		{
+			name: "AlignedIPv6Siblings",
+			in:   []string{"2001:db8:0:1::/64", "2001:db8::/64"},
+			want: []string{"2001:db8::/63"},
+		},
+		{
+			name: "UnalignedIPv6Neighbors",
+			in:   []string{"2001:db8:0:1::/64", "2001:db8:0:2::/64"},
+			want: []string{"2001:db8:0:1::/64", "2001:db8:0:2::/64"},
+		},
+		{
+			name: "IPv6Contained",
+			in:   []string{"fd00::/8", "fd00:dead:beef::/48"},
+			want: []string{"fd00::/8"},
+		},
+		{
+			name: "IPv6HalvesOfTheInternet",
+			in:   []string{"::/1", "8000::/1"},
+			want: []string{"::/0"},
+		},
+		{
+			name: "MixedFamilies",
+			in:   []string{"2001:db8::/65", "10.0.0.0/24", "2001:db8:0:0:8000::/65", "10.0.1.0/24"},
+			want: []string{"10.0.0.0/23", "2001:db8::/64"},
+		},
+		{
+			name: "FamiliesNeverMerge",
+			in:   []string{"::/0", "0.0.0.0/0"},
+			want: []string{"0.0.0.0/0", "::/0"},
+		},
+		{
+			name: "MappedIPv4",
+			in:   []string{"::ffff:10.0.0.0/120", "10.0.1.0/24"},
+			want: []string{"10.0.0.0/23"},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			in := make([]netip.Prefix, len(tt.in))
+			for i, p := range tt.in {
+				in[i] = netip.MustParsePrefix(p)
+			}
+			orig := slices.Clone(in)
+			got := Summarize(in)
+			gotStrs := make([]string, len(got))
+			for i, p := range got {
+				gotStrs[i] = p.String()
+			}
+			if !slices.Equal(gotStrs, tt.want) {
+				t.Errorf("Summarize(%v) = %v, want %v", tt.in, gotStrs, tt.want)
+			}
+			if !slices.Equal(in, orig) {
+				t.Errorf("Summarize modified its input: %v", in)
+			}
+		})
+	}
+
+	t.Run("InvalidPrefixesIgnored", func(t *testing.T) {
+		t.Parallel()
+		got := Summarize([]netip.Prefix{{}, netip.MustParsePrefix("10.0.0.0/8")})
+		if len(got) != 1 || got[0] != netip.MustParsePrefix("10.0.0.0/8") {
+			t.Errorf("expected only the valid prefix, got %v", got)
+		}
+	})
+}
+
+func TestSummarizeExhaustive(t *testing.T) {
+	t.Parallel()
+	// Summarize random sets of prefixes within a /24 and compare the covered
+	// addresses against the input.
+	base := netip.MustParseAddr("10.0.0.0")
+	covered := func(prefixes []netip.Prefix) [256]bool {
+		var out [256]bool
+		addr := base
+		for i := 0; i < 256; i++ {
+			for _, p := range prefixes {
+				if p.Contains(addr) {
+					out[i] = true
+					break
+				}
+			}
+			addr = addr.Next()
+		}
+		return out
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		in := make([]netip.Prefix, rng.Intn(16))
+		for j := range in {
+			bits := 24 + rng.Intn(9)
+			addr := base.As4()
+			addr[3] = byte(rng.Intn(256))
+			in[j] = netip.PrefixFrom(netip.AddrFrom4(addr), bits).Masked()
+		}
+		got := Summarize(in)
+		if covered(in) != covered(got) {
+			t.Fatalf("Summarize(%v) = %v covers different addresses", in, got)
+		}
+		for j := range got {
+			for k := range got {
+				if j == k {
+					continue
+				}
+				if got[j].Overlaps(got[k]) {
+					t.Fatalf("Summarize(%v) = %v contains overlapping prefixes", in, got)
+				}
+				if _, ok := mergeSiblings(got[j], got[k]); ok {
+					t.Fatalf("Summarize(%v) = %v contains mergeable prefixes", in, got)
+				}
+			}
+		}
+	}
+}
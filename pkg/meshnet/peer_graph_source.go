@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"sync"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// PeerGraphSource supplies the WireGuard peers of a node. It is consulted
+// every time the peers are synced. By default peers are computed from the
+// mesh database, but the topology can be supplied by an external controller
+// instead.
+type PeerGraphSource interface {
+	// WireGuardPeers returns the WireGuard peers for the given node.
+	WireGuardPeers(ctx context.Context, nodeID types.NodeID, opts PeerMapOptions) ([]*v1.WireGuardPeer, error)
+}
+
+// PeerGraphSourceFunc is a function that implements PeerGraphSource.
+type PeerGraphSourceFunc func(ctx context.Context, nodeID types.NodeID, opts PeerMapOptions) ([]*v1.WireGuardPeer, error)
+
+// WireGuardPeers implements PeerGraphSource.
+func (f PeerGraphSourceFunc) WireGuardPeers(ctx context.Context, nodeID types.NodeID, opts PeerMapOptions) ([]*v1.WireGuardPeer, error) {
+	return f(ctx, nodeID, opts)
+}
+
+// NewStoragePeerGraphSource returns a PeerGraphSource that walks the peer
+// graph in the given mesh database. This is the default source.
+func NewStoragePeerGraphSource(st storage.MeshDB) PeerGraphSource {
+	return PeerGraphSourceFunc(func(ctx context.Context, nodeID types.NodeID, opts PeerMapOptions) ([]*v1.WireGuardPeer, error) {
+		return WireGuardPeersWithOptions(ctx, st, nodeID, opts)
+	})
+}
+
+// ExternalPeerGraph is a PeerGraphSource for topologies computed by an
+// external controller. The controller is responsible for applying any
+// peer map options, the peers set for a node are returned as is.
+type ExternalPeerGraph struct {
+	peers map[types.NodeID][]*v1.WireGuardPeer
+	mu    sync.RWMutex
+}
+
+// NewExternalPeerGraph returns a new external peer graph with the given
+// peers for each node.
+func NewExternalPeerGraph(peers map[types.NodeID][]*v1.WireGuardPeer) *ExternalPeerGraph {
+	g := &ExternalPeerGraph{peers: make(map[types.NodeID][]*v1.WireGuardPeer, len(peers))}
+	for nodeID, nodePeers := range peers {
+		g.Set(nodeID, nodePeers)
+	}
+	return g
+}
+
+// Set replaces the peers of the given node. The change takes effect the
+// next time the peers are synced.
+func (g *ExternalPeerGraph) Set(nodeID types.NodeID, peers []*v1.WireGuardPeer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.peers == nil {
+		g.peers = make(map[types.NodeID][]*v1.WireGuardPeer)
+	}
+	g.peers[nodeID] = clonePeers(peers)
+}
+
+// WireGuardPeers implements PeerGraphSource.
+func (g *ExternalPeerGraph) WireGuardPeers(_ context.Context, nodeID types.NodeID, _ PeerMapOptions) ([]*v1.WireGuardPeer, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return clonePeers(g.peers[nodeID]), nil
+}
+
+func clonePeers(peers []*v1.WireGuardPeer) []*v1.WireGuardPeer {
+	out := make([]*v1.WireGuardPeer, len(peers))
+	for i, peer := range peers {
+		out[i] = peer.DeepCopy()
+	}
+	return out
+}
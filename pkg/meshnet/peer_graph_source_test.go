@@ -0,0 +1,111 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestExternalPeerGraphSource(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	// The database is left empty, peers can only come from the graph.
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	newPeer := func(id string, allowedIPs ...string) *v1.WireGuardPeer {
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		return &v1.WireGuardPeer{
+			Node: &v1.MeshNode{
+				Id:              id,
+				PublicKey:       encoded,
+				PrimaryEndpoint: "127.0.0.1:51820",
+			},
+			Proto:      v1.ConnectProtocol_CONNECT_NATIVE,
+			AllowedIPs: allowedIPs,
+		}
+	}
+	graph := NewExternalPeerGraph(map[types.NodeID][]*v1.WireGuardPeer{
+		"node-a": {
+			newPeer("node-b", "172.16.0.2/32"),
+			newPeer("node-c", "172.16.0.3/32", "10.10.0.0/16"),
+		},
+		"node-b": {newPeer("node-a", "172.16.0.1/32")},
+	})
+	m := New(db, Options{PeerGraphSource: graph}, "node-a").(*manager)
+	wg := &fakeWireGuard{peers: make(map[string]wireguard.Peer)}
+	m.wg = wg
+
+	if err := m.Peers().Sync(ctx); err != nil {
+		t.Fatalf("sync peers: %v", err)
+	}
+	assertPeers := func(want map[string][]string) {
+		t.Helper()
+		got := wg.Peers()
+		if len(got) != len(want) {
+			t.Fatalf("expected %d peers, got %d: %v", len(want), len(got), got)
+		}
+		for id, allowedIPs := range want {
+			peer, ok := got[id]
+			if !ok {
+				t.Fatalf("expected peer %s to be configured", id)
+			}
+			var gotIPs []string
+			for _, prefix := range peer.AllowedIPs {
+				gotIPs = append(gotIPs, prefix.String())
+			}
+			if !slices.Equal(gotIPs, allowedIPs) {
+				t.Errorf("expected peer %s to have allowed IPs %v, got %v", id, allowedIPs, gotIPs)
+			}
+		}
+	}
+	assertPeers(map[string][]string{
+		"node-b": {"172.16.0.2/32"},
+		"node-c": {"172.16.0.3/32", "10.10.0.0/16"},
+	})
+
+	// Updates from the controller are applied on the next sync.
+	graph.Set("node-a", []*v1.WireGuardPeer{newPeer("node-d", "172.16.0.4/32")})
+	if err := m.Peers().Sync(ctx); err != nil {
+		t.Fatalf("sync peers: %v", err)
+	}
+	assertPeers(map[string][]string{
+		"node-d": {"172.16.0.4/32"},
+	})
+
+	routes, err := m.EffectiveRoutes(ctx)
+	if err != nil {
+		t.Fatalf("effective routes: %v", err)
+	}
+	want := []EffectiveRoute{{Destination: netip.MustParsePrefix("172.16.0.4/32"), Peer: "node-d"}}
+	if !slices.Equal(routes, want) {
+		t.Errorf("expected effective routes %v, got %v", want, routes)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package meshnet
 
 import (
+	"errors"
 	"fmt"
 	"net/netip"
 	"slices"
@@ -26,11 +27,16 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/networking"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
+// ErrTooManyAllowedIPs is returned when a peer would be configured with more
+// allowed IPs than the configured maximum.
+var ErrTooManyAllowedIPs = errors.New("too many allowed IPs for peer")
+
 // GraphWalk is the structure used to recursively walk the graph
 // and build the adjacency map.
 type GraphWalk struct {
@@ -44,6 +50,11 @@ type GraphWalk struct {
 	Routes       []Route
 	Visited      map[types.NodeID]struct{}
 	Depth        int
+	ExitNode     types.NodeID
+	// Cordoned is true when the walk passes through a cordoned node.
+	Cordoned bool
+	// RequireSignedRoutes skips routes that are not signed.
+	RequireSignedRoutes bool
 }
 
 // SkipNode reports if the given node ID should be skipped.
@@ -64,24 +75,95 @@ func (g *GraphWalk) SkipNode(id types.NodeID) bool {
 	return false
 }
 
+// SkipRoute reports if the given route advertised by node should be skipped.
+// Exit routes are skipped unless they were advertised by the selected exit node.
+// Signed routes are skipped unless the signature was made by the node's key,
+// and unsigned routes are skipped when signed routes are required.
+func (g *GraphWalk) SkipRoute(node types.MeshNode, route types.Route) bool {
+	if route.IsExitRoute() && types.NodeID(route.GetNode()) != g.ExitNode {
+		return true
+	}
+	if !route.IsSigned() {
+		return g.RequireSignedRoutes
+	}
+	return storage.VerifyRouteSignature(route, node) != nil
+}
+
+// AddRoute adds a route to the walk. If the CIDR is already reachable
+// through the walk, the preferred of the two routes is kept.
+func (g *GraphWalk) AddRoute(rt Route) {
+	for i, route := range g.Routes {
+		if route.CIDR == rt.CIDR {
+			if rt.PreferredOver(route) {
+				g.Routes[i] = rt
+			}
+			return
+		}
+	}
+	g.Routes = append(g.Routes, rt)
+}
+
 // WalkedPeer is a peer that has been walked. We track routes
 // separately so we can do a final iteration to determine the
-// smallest depth for each route.
+// preferred peer for each route.
 type WalkedPeer struct {
 	*v1.WireGuardPeer
 	Routes []Route
 }
 
-// Route tracks a route and the depth into the graph of the route.
-// Smallest depth wins in the end.
+// Route tracks a route, its metric, and the depth into the graph of the route.
+// Routes through cordoned nodes always lose to routes that avoid them. After
+// that the lowest metric wins, with the smallest depth breaking ties.
 type Route struct {
-	CIDR  netip.Prefix
-	Depth int
+	CIDR     netip.Prefix
+	Depth    int
+	Metric   uint32
+	Cordoned bool
+}
+
+// PreferredOver reports if the route is preferred over the other route
+// for the same CIDR.
+func (r Route) PreferredOver(other Route) bool {
+	if r.Cordoned != other.Cordoned {
+		return !r.Cordoned
+	}
+	if r.Metric != other.Metric {
+		return r.Metric < other.Metric
+	}
+	return r.Depth < other.Depth
+}
+
+// PeerMapOptions are options for computing the WireGuard peers of a node.
+type PeerMapOptions struct {
+	// EqualCostMultipath assigns a route to every peer tied for the lowest
+	// metric and depth. By default only the tied peer with the lowest node
+	// ID is used so that route selection is deterministic.
+	EqualCostMultipath bool
+	// ExitNode is the exit node the peer has opted in to. Exit routes
+	// advertised by any other node are never used.
+	ExitNode types.NodeID
+	// MaxAllowedIPs is the maximum number of allowed IPs a single peer may
+	// be configured with. ErrTooManyAllowedIPs is returned when a peer
+	// exceeds it. Zero means no limit.
+	MaxAllowedIPs int
+	// SummarizeAllowedIPs aggregates adjacent and overlapping prefixes in
+	// each peer's allowed IPs into the smallest covering set before the
+	// limit is checked.
+	SummarizeAllowedIPs bool
+	// RequireSignedRoutes ignores routes that are not signed by the node
+	// advertising them.
+	RequireSignedRoutes bool
 }
 
 // WireGuardPeersFor returns the WireGuard peers for the given peer ID.
 // Peers are filtered by network ACLs.
 func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.NodeID) ([]*v1.WireGuardPeer, error) {
+	return WireGuardPeersWithOptions(ctx, st, peerID, PeerMapOptions{})
+}
+
+// WireGuardPeersWithOptions returns the WireGuard peers for the given peer ID
+// using the given options. Peers are filtered by network ACLs.
+func WireGuardPeersWithOptions(ctx context.Context, st storage.MeshDB, peerID types.NodeID, opts PeerMapOptions) ([]*v1.WireGuardPeer, error) {
 	log := context.LoggerFrom(ctx).With("source-peer", peerID)
 	graph := st.Peers().Graph()
 	nw := st.Networking()
@@ -143,16 +225,19 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 		var target types.MeshNode
 		directPeer.DeepCopyInto(&target)
 		walk := GraphWalk{
-			Graph:        graph,
-			Networking:   nw,
-			AdjacencyMap: adjacencyMap,
-			SourceNode:   peerID,
-			TargetNode:   &target,
-			LocalRoutes:  ourRoutes,
-			AllowedIPs:   []string{},
-			Routes:       []Route{},
-			Visited:      map[types.NodeID]struct{}{},
-			Depth:        0,
+			Graph:               graph,
+			Networking:          nw,
+			AdjacencyMap:        adjacencyMap,
+			SourceNode:          peerID,
+			TargetNode:          &target,
+			LocalRoutes:         ourRoutes,
+			AllowedIPs:          []string{},
+			Routes:              []Route{},
+			Visited:             map[types.NodeID]struct{}{},
+			Depth:               0,
+			ExitNode:            opts.ExitNode,
+			Cordoned:            directPeer.Cordoned(),
+			RequireSignedRoutes: opts.RequireSignedRoutes,
 		}
 		err = recursePeers(ctx, &walk)
 		if err != nil {
@@ -163,13 +248,13 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 		peer.AllowedIPs = append(peer.AllowedIPs, walk.AllowedIPs...)
 		peers = append(peers, peer)
 	}
-	// Walk our results and assign routes based on shortest path.
+	// Walk our results and assign routes based on metric and shortest path.
 	out := make([]*v1.WireGuardPeer, 0, len(peers))
 	for _, peer := range peers {
-		// For each route, check if its the shortest depth for that prefix.
+		// For each route, check if this peer is preferred for that prefix.
 		for _, route := range peer.Routes {
-			if isSmallestDepth(peers, route) {
-				// This is the shortest depth for this route.
+			if isPreferredRoute(peers, peer.GetNode().GetId(), route, opts.EqualCostMultipath) {
+				// This is the preferred peer for this route.
 				peer.AllowedRoutes = append(peer.AllowedRoutes, route.CIDR.String())
 				peer.AllowedIPs = append(peer.AllowedIPs, route.CIDR.String())
 			}
@@ -194,9 +279,42 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 		}
 		peer.AllowedIPs = newAllowedIPs
 	}
+	for _, peer := range out {
+		if opts.SummarizeAllowedIPs {
+			peer.AllowedIPs = summarizeAllowedIPs(peer.AllowedIPs)
+		}
+		if opts.MaxAllowedIPs > 0 && len(peer.AllowedIPs) > opts.MaxAllowedIPs {
+			return nil, fmt.Errorf("%w: %s has %d, maximum is %d", ErrTooManyAllowedIPs, peer.GetNode().GetId(), len(peer.AllowedIPs), opts.MaxAllowedIPs)
+		}
+	}
 	return out, nil
 }
 
+// advertisedPrefixes returns the destination prefixes of the route with
+// its excluded prefixes removed.
+func advertisedPrefixes(route types.Route) []netip.Prefix {
+	if len(route.ExcludedCIDRs) == 0 {
+		return route.DestinationPrefixes()
+	}
+	return netutil.Exclude(route.DestinationPrefixes(), route.ExcludedPrefixes())
+}
+
+// summarizeAllowedIPs returns the smallest set of prefixes covering the given
+// allowed IPs.
+func summarizeAllowedIPs(allowedIPs []string) []string {
+	prefixes := make([]netip.Prefix, 0, len(allowedIPs))
+	for _, allowedIP := range allowedIPs {
+		// The address was validated when it was added to the allowed IPs.
+		prefixes = append(prefixes, netip.MustParsePrefix(allowedIP))
+	}
+	summarized := netutil.Summarize(prefixes)
+	out := make([]string, len(summarized))
+	for i, prefix := range summarized {
+		out[i] = prefix.String()
+	}
+	return out
+}
+
 func recursePeers(ctx context.Context, walk *GraphWalk) error {
 	if walk.TargetNode.PrivateAddrV4().IsValid() {
 		walk.AllowedIPs = append(walk.AllowedIPs, walk.TargetNode.PrivateAddrV4().String())
@@ -210,14 +328,17 @@ func recursePeers(ctx context.Context, walk *GraphWalk) error {
 		return fmt.Errorf("get routes by node: %w", err)
 	}
 	for _, route := range routes {
-		for _, cidr := range route.DestinationPrefixes() {
+		if walk.SkipRoute(*walk.TargetNode, route) {
+			continue
+		}
+		for _, cidr := range advertisedPrefixes(route) {
 			if !slices.Contains(walk.AllowedIPs, cidr.String()) && !slices.Contains(walk.LocalRoutes, cidr) {
-				if !routeExists(walk.Routes, cidr) {
-					walk.Routes = append(walk.Routes, Route{
-						CIDR:  cidr,
-						Depth: walk.Depth,
-					})
-				}
+				walk.AddRoute(Route{
+					CIDR:     cidr,
+					Depth:    walk.Depth,
+					Metric:   route.Metric,
+					Cordoned: walk.Cordoned,
+				})
 			}
 		}
 	}
@@ -253,19 +374,25 @@ func recursePeerEdges(ctx context.Context, walk *GraphWalk) error {
 		if targetNode.PrivateAddrV6().IsValid() {
 			walk.AllowedIPs = append(walk.AllowedIPs, targetNode.PrivateAddrV6().String())
 		}
+		// Everything reached through a cordoned node is cordoned as well.
+		parentCordoned := walk.Cordoned
+		walk.Cordoned = parentCordoned || targetNode.Cordoned()
 		routes, err := walk.Networking.GetRoutesByNode(ctx, targetNode.NodeID())
 		if err != nil {
 			return fmt.Errorf("get routes by node: %w", err)
 		}
 		for _, route := range routes {
-			for _, cidr := range route.DestinationPrefixes() {
+			if walk.SkipRoute(targetNode, route) {
+				continue
+			}
+			for _, cidr := range advertisedPrefixes(route) {
 				if !slices.Contains(walk.AllowedIPs, cidr.String()) && !slices.Contains(walk.LocalRoutes, cidr) {
-					if !routeExists(walk.Routes, cidr) {
-						walk.Routes = append(walk.Routes, Route{
-							CIDR:  cidr,
-							Depth: walk.Depth,
-						})
-					}
+					walk.AddRoute(Route{
+						CIDR:     cidr,
+						Depth:    walk.Depth,
+						Metric:   route.Metric,
+						Cordoned: walk.Cordoned,
+					})
 				}
 			}
 		}
@@ -275,27 +402,30 @@ func recursePeerEdges(ctx context.Context, walk *GraphWalk) error {
 		if err != nil {
 			return fmt.Errorf("recurse vertex edges: %w", err)
 		}
+		walk.Cordoned = parentCordoned
 	}
 	return nil
 }
 
-func isSmallestDepth(peers []WalkedPeer, rt Route) bool {
-	depth := rt.Depth
+// isPreferredRoute reports if the route through the given peer is the
+// preferred route for its CIDR across all peers. Ties are broken by the
+// lowest peer ID unless ecmp is true.
+func isPreferredRoute(peers []WalkedPeer, peerID string, rt Route, ecmp bool) bool {
 	for _, peer := range peers {
+		if peer.GetNode().GetId() == peerID {
+			continue
+		}
 		for _, route := range peer.Routes {
-			if route.CIDR == rt.CIDR && route.Depth < depth {
+			if route.CIDR != rt.CIDR {
+				continue
+			}
+			if route.PreferredOver(rt) {
+				return false
+			}
+			if !ecmp && !rt.PreferredOver(route) && peer.GetNode().GetId() < peerID {
 				return false
 			}
 		}
 	}
 	return true
 }
-
-func routeExists(routes []Route, rt netip.Prefix) bool {
-	for _, route := range routes {
-		if route.CIDR == rt {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,199 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestWireGuardPeersMaxAllowedIPs(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		opts          PeerMapOptions
+		wantErr       bool
+		wantAllowedIP []string
+	}{
+		{
+			name: "NoLimit",
+			opts: PeerMapOptions{},
+			wantAllowedIP: []string{
+				"172.16.0.2/32", "2001:db8::2/128",
+				"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24", "10.0.5.0/24",
+			},
+		},
+		{
+			name:    "ExceedsLimit",
+			opts:    PeerMapOptions{MaxAllowedIPs: 4},
+			wantErr: true,
+		},
+		{
+			name: "SummarizedBelowLimit",
+			opts: PeerMapOptions{MaxAllowedIPs: 4, SummarizeAllowedIPs: true},
+			wantAllowedIP: []string{
+				"10.0.0.0/22", "10.0.5.0/24", "172.16.0.2/32", "2001:db8::2/128",
+			},
+		},
+		{
+			name:    "SummarizedAboveLimit",
+			opts:    PeerMapOptions{MaxAllowedIPs: 3, SummarizeAllowedIPs: true},
+			wantErr: true,
+		},
+	}
+
+	for _, testcase := range tt {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := meshdb.NewTestDB()
+			defer db.Close()
+			err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+				NetworkState: &v1.NetworkState{
+					NetworkV4: "172.16.0.0/12",
+					NetworkV6: "2001:db8::/64",
+					Domain:    "example.com",
+				},
+			})
+			if err != nil {
+				t.Fatalf("set network state: %v", err)
+			}
+			for i, id := range []string{"client", "hub", "other"} {
+				err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+					Id:          id,
+					PublicKey:   mustGeneratePublicKey(t),
+					PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+					PrivateIPv6: fmt.Sprintf("2001:db8::%d/128", i+1),
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Connect to two peers so allowed IPs are not flattened to the network.
+			for _, peer := range []string{"hub", "other"} {
+				err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+					Source: "client",
+					Target: peer,
+				}})
+				if err != nil {
+					t.Fatalf("put edge to %q: %v", peer, err)
+				}
+			}
+			err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+				Name:             "hub-spokes",
+				Node:             "hub",
+				DestinationCIDRs: []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24", "10.0.5.0/24"},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{
+				NetworkACL: &v1.NetworkACL{
+					Name:             "allow",
+					Action:           v1.ACLAction_ACTION_ACCEPT,
+					SourceNodes:      []string{"*"},
+					DestinationNodes: []string{"*"},
+					SourceCIDRs:      []string{"*"},
+					DestinationCIDRs: []string{"*"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			peers, err := WireGuardPeersWithOptions(ctx, db, "client", tc.opts)
+			if tc.wantErr {
+				if !errors.Is(err, ErrTooManyAllowedIPs) {
+					t.Fatalf("expected ErrTooManyAllowedIPs, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("get peers for client: %v", err)
+			}
+			for _, p := range peers {
+				if p.Node.GetId() != "hub" {
+					continue
+				}
+				if !reflect.DeepEqual(p.AllowedIPs, tc.wantAllowedIP) {
+					t.Errorf("got allowed IPs %v, wanted %v", p.AllowedIPs, tc.wantAllowedIP)
+				}
+				return
+			}
+			t.Fatal("hub was not returned as a peer")
+		})
+	}
+}
+
+func TestSummarizeAllowedIPs(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{
+			name: "Empty",
+			in:   []string{},
+			want: []string{},
+		},
+		{
+			name: "AlignedSiblings",
+			in:   []string{"10.0.1.0/24", "10.0.0.0/24"},
+			want: []string{"10.0.0.0/23"},
+		},
+		{
+			name: "UnalignedNeighbors",
+			in:   []string{"10.0.1.0/24", "10.0.2.0/24"},
+			want: []string{"10.0.1.0/24", "10.0.2.0/24"},
+		},
+		{
+			name: "Contained",
+			in:   []string{"10.0.0.0/8", "10.1.2.0/24", "10.1.2.3/32"},
+			want: []string{"10.0.0.0/8"},
+		},
+		{
+			name: "Cascading",
+			in:   []string{"10.0.0.0/25", "10.0.0.128/25", "10.0.1.0/24", "10.0.2.0/23"},
+			want: []string{"10.0.0.0/22"},
+		},
+		{
+			name: "MixedFamilies",
+			in:   []string{"2001:db8::/65", "10.0.0.0/24", "2001:db8:0:0:8000::/65", "10.0.1.0/24"},
+			want: []string{"10.0.0.0/23", "2001:db8::/64"},
+		},
+	}
+	for _, testcase := range tt {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got := summarizeAllowedIPs(tc.in)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, wanted %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -17,13 +17,16 @@ limitations under the License.
 package meshnet
 
 import (
+	"fmt"
 	"reflect"
+	"slices"
 	"sort"
 	"testing"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -155,6 +158,50 @@ func TestWireGuardPeersWithRoutes(t *testing.T) {
 				},
 			},
 		},
+		{
+			// A cordoned peer loses routes another peer can serve, but keeps
+			// the ones only it provides until it leaves.
+			name: "CordonedPeer",
+			peers: []types.MeshNode{
+				{MeshNode: &v1.MeshNode{
+					Id:          "node-a",
+					PrivateIPv4: "172.16.0.1/32",
+					PrivateIPv6: "2001:db8::1/128",
+				}},
+				{MeshNode: &v1.MeshNode{
+					Id:              "node-b",
+					PrivateIPv4:     "172.16.0.2/32",
+					PrivateIPv6:     "2001:db8::2/128",
+					ZoneAwarenessID: types.CordonLabel + "=" + string(types.CordonStateCordoned),
+				}},
+				{MeshNode: &v1.MeshNode{
+					Id:          "node-c",
+					PrivateIPv4: "172.16.0.3/32",
+					PrivateIPv6: "2001:db8::3/128",
+				}},
+			},
+			routes: []types.Route{
+				{Route: &v1.Route{
+					Name:             "node-b-routes",
+					Node:             "node-b",
+					DestinationCIDRs: []string{"10.0.0.0/24", "10.1.0.0/24"},
+				}},
+				{Route: &v1.Route{
+					Name:             "node-c-routes",
+					Node:             "node-c",
+					DestinationCIDRs: []string{"10.0.0.0/24"},
+				}},
+			},
+			edges: map[string][]string{
+				"node-a": {"node-b", "node-c"},
+			},
+			wantRoutes: map[string]map[string][]string{
+				"node-a": {
+					"node-b": {"10.1.0.0/24"},
+					"node-c": {"10.0.0.0/24"},
+				},
+			},
+		},
 	}
 
 	for _, testcase := range tt {
@@ -232,3 +279,459 @@ func TestWireGuardPeersWithRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestWireGuardPeersWithRouteMetrics(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name       string
+		metrics    map[string]uint32 // peerID -> route metric
+		ecmp       bool
+		wantRoutes map[string][]string // peerID -> []routes
+	}{
+		{
+			name:    "LowestMetricWins",
+			metrics: map[string]uint32{"gateway-a": 200, "gateway-b": 100},
+			wantRoutes: map[string][]string{
+				"gateway-a": {},
+				"gateway-b": {"10.0.0.0/8"},
+			},
+		},
+		{
+			name:    "EqualMetricsAreDeterministic",
+			metrics: map[string]uint32{"gateway-a": 100, "gateway-b": 100},
+			wantRoutes: map[string][]string{
+				"gateway-a": {"10.0.0.0/8"},
+				"gateway-b": {},
+			},
+		},
+		{
+			name:    "EqualCostMultipath",
+			metrics: map[string]uint32{"gateway-a": 100, "gateway-b": 100},
+			ecmp:    true,
+			wantRoutes: map[string][]string{
+				"gateway-a": {"10.0.0.0/8"},
+				"gateway-b": {"10.0.0.0/8"},
+			},
+		},
+		{
+			name:    "EqualCostMultipathLowestMetricWins",
+			metrics: map[string]uint32{"gateway-a": 200, "gateway-b": 100},
+			ecmp:    true,
+			wantRoutes: map[string][]string{
+				"gateway-a": {},
+				"gateway-b": {"10.0.0.0/8"},
+			},
+		},
+	}
+
+	for _, testcase := range tt {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := meshdb.NewTestDB()
+			defer db.Close()
+			err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+				NetworkState: &v1.NetworkState{
+					NetworkV4: "172.16.0.0/12",
+					NetworkV6: "2001:db8::/64",
+					Domain:    "example.com",
+				},
+			})
+			if err != nil {
+				t.Fatalf("set network state: %v", err)
+			}
+			for i, id := range []string{"client", "gateway-a", "gateway-b"} {
+				err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+					Id:          id,
+					PublicKey:   mustGeneratePublicKey(t),
+					PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+					PrivateIPv6: fmt.Sprintf("2001:db8::%d/128", i+1),
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, gateway := range []string{"gateway-a", "gateway-b"} {
+				err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+					Source: "client",
+					Target: gateway,
+				}})
+				if err != nil {
+					t.Fatalf("put edge to %q: %v", gateway, err)
+				}
+				err = db.Networking().PutRoute(ctx, types.Route{
+					Route: &v1.Route{
+						Name:             gateway + "-route",
+						Node:             gateway,
+						DestinationCIDRs: []string{"10.0.0.0/8"},
+					},
+					Metric: tc.metrics[gateway],
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{
+				NetworkACL: &v1.NetworkACL{
+					Name:             "allow-all",
+					Action:           v1.ACLAction_ACTION_ACCEPT,
+					SourceNodes:      []string{"*"},
+					DestinationNodes: []string{"*"},
+					SourceCIDRs:      []string{"*"},
+					DestinationCIDRs: []string{"*"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			peers, err := WireGuardPeersWithOptions(ctx, db, "client", PeerMapOptions{
+				EqualCostMultipath: tc.ecmp,
+			})
+			if err != nil {
+				t.Fatalf("get peers for client: %v", err)
+			}
+			got := make(map[string][]string)
+			for _, p := range peers {
+				got[p.Node.GetId()] = p.AllowedRoutes
+			}
+			if !reflect.DeepEqual(got, tc.wantRoutes) {
+				t.Errorf("got routes %v, wanted routes %v", got, tc.wantRoutes)
+			}
+		})
+	}
+}
+
+func TestWireGuardPeersWithExitNodes(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name       string
+		exitNode   types.NodeID
+		denyExitB  bool
+		wantRoutes map[string][]string // peerID -> []routes
+	}{
+		{
+			name: "NoExitNodeSelected",
+			wantRoutes: map[string][]string{
+				"exit-a": {"10.0.0.0/8"},
+				"exit-b": {},
+			},
+		},
+		{
+			name:     "SelectedExitNode",
+			exitNode: "exit-a",
+			wantRoutes: map[string][]string{
+				"exit-a": {"0.0.0.0/0", "::/0", "10.0.0.0/8"},
+				"exit-b": {},
+			},
+		},
+		{
+			name:     "OtherExitNodeSelected",
+			exitNode: "exit-b",
+			wantRoutes: map[string][]string{
+				"exit-a": {"10.0.0.0/8"},
+				"exit-b": {"0.0.0.0/0", "::/0"},
+			},
+		},
+		{
+			name:      "SelectedExitNodeDeniedByACLs",
+			exitNode:  "exit-b",
+			denyExitB: true,
+			wantRoutes: map[string][]string{
+				"exit-a": {"10.0.0.0/8"},
+			},
+		},
+	}
+
+	for _, testcase := range tt {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := meshdb.NewTestDB()
+			defer db.Close()
+			err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+				NetworkState: &v1.NetworkState{
+					NetworkV4: "172.16.0.0/12",
+					NetworkV6: "2001:db8::/64",
+					Domain:    "example.com",
+				},
+			})
+			if err != nil {
+				t.Fatalf("set network state: %v", err)
+			}
+			for i, id := range []string{"client", "exit-a", "exit-b"} {
+				err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+					Id:          id,
+					PublicKey:   mustGeneratePublicKey(t),
+					PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+					PrivateIPv6: fmt.Sprintf("2001:db8::%d/128", i+1),
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, exit := range []types.NodeID{"exit-a", "exit-b"} {
+				err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+					Source: "client",
+					Target: exit.String(),
+				}})
+				if err != nil {
+					t.Fatalf("put edge to %q: %v", exit, err)
+				}
+				err = db.Networking().PutRoute(ctx, types.NewExitRoute(exit, true, true))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A regular route on exit-a should be used regardless of the selection.
+			err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+				Name:             "exit-a-site",
+				Node:             "exit-a",
+				DestinationCIDRs: []string{"10.0.0.0/8"},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			destinations := []string{"*"}
+			if tc.denyExitB {
+				destinations = []string{"client", "exit-a"}
+			}
+			err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{
+				NetworkACL: &v1.NetworkACL{
+					Name:             "allow",
+					Action:           v1.ACLAction_ACTION_ACCEPT,
+					SourceNodes:      []string{"*"},
+					DestinationNodes: destinations,
+					SourceCIDRs:      []string{"*"},
+					DestinationCIDRs: []string{"*"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			peers, err := WireGuardPeersWithOptions(ctx, db, "client", PeerMapOptions{
+				ExitNode: tc.exitNode,
+			})
+			if err != nil {
+				t.Fatalf("get peers for client: %v", err)
+			}
+			got := make(map[string][]string)
+			for _, p := range peers {
+				got[p.Node.GetId()] = p.AllowedRoutes
+			}
+			if !reflect.DeepEqual(got, tc.wantRoutes) {
+				t.Errorf("got routes %v, wanted routes %v", got, tc.wantRoutes)
+			}
+		})
+	}
+}
+
+func TestWireGuardPeersWithExcludedRoutes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	for i, id := range []string{"client", "gateway", "site"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+			PrivateIPv6: fmt.Sprintf("2001:db8::%d/128", i+1),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == "client" {
+			continue
+		}
+		err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+			Source: "client",
+			Target: id,
+		}})
+		if err != nil {
+			t.Fatalf("put edge to %q: %v", id, err)
+		}
+	}
+	// The gateway serves 10.0.0.0/8 except for the site's range.
+	err = db.Networking().PutRoute(ctx, types.Route{
+		Route: &v1.Route{
+			Name:             "gateway",
+			Node:             "gateway",
+			DestinationCIDRs: []string{"10.0.0.0/8"},
+		},
+		ExcludedCIDRs: []string{"10.5.0.0/16"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "site",
+		Node:             "site",
+		DestinationCIDRs: []string{"10.5.0.0/16"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{
+		NetworkACL: &v1.NetworkACL{
+			Name:             "allow",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+			SourceCIDRs:      []string{"*"},
+			DestinationCIDRs: []string{"*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers, err := WireGuardPeersFor(ctx, db, "client")
+	if err != nil {
+		t.Fatalf("get peers for client: %v", err)
+	}
+	got := make(map[string][]string)
+	for _, p := range peers {
+		got[p.Node.GetId()] = p.AllowedIPs
+	}
+	want := map[string][]string{
+		"gateway": {
+			"172.16.0.2/32", "2001:db8::2/128",
+			"10.0.0.0/14", "10.4.0.0/16", "10.6.0.0/15", "10.8.0.0/13",
+			"10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/9",
+		},
+		"site": {"172.16.0.3/32", "2001:db8::3/128", "10.5.0.0/16"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got allowed IPs %v, wanted %v", got, want)
+	}
+}
+
+func TestWireGuardPeersWithSignedRoutes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	key := crypto.MustGenerateKey()
+	gatewayKey, err := key.PublicKey().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	putGateway := func(publicKey string) {
+		t.Helper()
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          "gateway",
+			PublicKey:   publicKey,
+			PrivateIPv4: "172.16.0.2/32",
+			PrivateIPv6: "2001:db8::2/128",
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          "client",
+		PublicKey:   mustGeneratePublicKey(t),
+		PrivateIPv4: "172.16.0.1/32",
+		PrivateIPv6: "2001:db8::1/128",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	putGateway(gatewayKey)
+	err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source: "client",
+		Target: "gateway",
+	}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	route := types.Route{Route: &v1.Route{
+		Name:             "gateway",
+		Node:             "gateway",
+		DestinationCIDRs: []string{"10.0.0.0/8"},
+	}}
+	if err := route.Sign(key); err != nil {
+		t.Fatalf("sign route: %v", err)
+	}
+	if err := db.Networking().PutRoute(ctx, route); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{
+		NetworkACL: &v1.NetworkACL{
+			Name:             "allow",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+			SourceCIDRs:      []string{"*"},
+			DestinationCIDRs: []string{"*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedIPs := func(opts PeerMapOptions) []string {
+		t.Helper()
+		peers, err := WireGuardPeersWithOptions(ctx, db, "client", opts)
+		if err != nil {
+			t.Fatalf("get peers for client: %v", err)
+		}
+		if len(peers) != 1 {
+			t.Fatalf("expected one peer, got %d", len(peers))
+		}
+		return peers[0].AllowedIPs
+	}
+
+	// With a single peer the internal addresses are flattened to the network.
+	want := []string{"172.16.0.0/12", "2001:db8::/64", "10.0.0.0/8"}
+	if got := allowedIPs(PeerMapOptions{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got allowed IPs %v, wanted %v", got, want)
+	}
+
+	// Unsigned routes are ignored when signed routes are required.
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "gateway-unsigned",
+		Node:             "gateway",
+		DestinationCIDRs: []string{"192.168.0.0/16"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := allowedIPs(PeerMapOptions{}); !slices.Contains(got, "192.168.0.0/16") {
+		t.Errorf("expected the unsigned route to be allowed, got %v", got)
+	}
+	if got := allowedIPs(PeerMapOptions{RequireSignedRoutes: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got allowed IPs %v, wanted %v", got, want)
+	}
+	if err := db.Networking().DeleteRoute(ctx, "gateway-unsigned"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the gateway has a different key the signature no longer matches
+	// and the route is not installed.
+	putGateway(mustGeneratePublicKey(t))
+	want = []string{"172.16.0.0/12", "2001:db8::/64"}
+	if got := allowedIPs(PeerMapOptions{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got allowed IPs %v, wanted %v", got, want)
+	}
+}
@@ -18,6 +18,8 @@ limitations under the License.
 package meshnet
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -29,6 +31,8 @@ import (
 
 	"github.com/multiformats/go-multiaddr"
 	v1 "github.com/webmeshproj/api/go/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
@@ -42,7 +46,9 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/webrtc"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	storerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
 )
 
 // PeerManager is the interface for tracking and managing WireGuard peers.
@@ -73,19 +79,206 @@ type PeerResolver interface {
 }
 
 type peerManager struct {
-	net      *manager
-	storage  storage.MeshDB
-	p2pConns map[string]clientPeerConn
-	peermu   sync.Mutex
-	p2pmu    sync.Mutex
+	net        *manager
+	storage    storage.MeshDB
+	p2pConns   map[string]clientPeerConn
+	keepalives map[string]time.Duration
+	psks       map[string]wgtypes.Key
+	rotations  map[string]pendingRotation
+	// peerLocks serialize updates to individual peers. Bulk refreshes only
+	// hold the lock of the peer they are currently updating, so single-peer
+	// updates are never queued behind a whole refresh.
+	peerLocks map[string]*peerLock
+	// refreshmu serializes bulk refreshes with each other.
+	refreshmu sync.Mutex
+	// statemu protects the maps above. It is never held across calls
+	// to the wireguard interface.
+	statemu sync.Mutex
+	p2pmu   sync.Mutex
+}
+
+// peerLock is a reference counted lock for a single peer.
+type peerLock struct {
+	mu   sync.Mutex
+	refs int
 }
 
 func newPeerManager(m *manager) *peerManager {
 	return &peerManager{
-		net:      m,
-		storage:  m.storage,
-		p2pConns: make(map[string]clientPeerConn),
+		net:        m,
+		storage:    m.storage,
+		p2pConns:   make(map[string]clientPeerConn),
+		keepalives: make(map[string]time.Duration),
+		psks:       make(map[string]wgtypes.Key),
+		rotations:  make(map[string]pendingRotation),
+		peerLocks:  make(map[string]*peerLock),
+	}
+}
+
+// lockPeer acquires the lock for the given peer and returns a function that
+// releases it. Locks are dropped once no caller holds or waits on them.
+func (m *peerManager) lockPeer(peerID string) (unlock func()) {
+	m.statemu.Lock()
+	l, ok := m.peerLocks[peerID]
+	if !ok {
+		l = &peerLock{}
+		m.peerLocks[peerID] = l
+	}
+	l.refs++
+	m.statemu.Unlock()
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		m.statemu.Lock()
+		defer m.statemu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.peerLocks, peerID)
+		}
+	}
+}
+
+// pendingRotation is a scheduled switch to a rotated preshared key.
+type pendingRotation struct {
+	key   wgtypes.Key
+	timer *time.Timer
+}
+
+// setPresharedKey records the preshared key for the given peer and applies
+// it to the peer if it is currently configured. A zero key removes it.
+func (m *peerManager) setPresharedKey(ctx context.Context, peerID string, key wgtypes.Key) error {
+	defer m.lockPeer(peerID)()
+	m.statemu.Lock()
+	if key == (wgtypes.Key{}) {
+		delete(m.psks, peerID)
+	} else {
+		m.psks[peerID] = key
+	}
+	m.statemu.Unlock()
+	wg := m.net.WireGuard()
+	if wg == nil {
+		return nil
+	}
+	peer, ok := wg.Peers()[peerID]
+	if !ok {
+		return nil
+	}
+	peer.PresharedKey = key
+	if err := wg.PutPeer(ctx, &peer); err != nil {
+		return fmt.Errorf("put wireguard peer: %w", err)
+	}
+	return nil
+}
+
+// presharedKey returns the preshared key to use with the given peer. Keys
+// negotiated on the edge to the peer take precedence over keys set with
+// setPresharedKey, so that nodes pick up keys for peers that joined after
+// them. A pending rotation on the edge is scheduled to be applied at its
+// activation time. It must be called with the peer lock held.
+func (m *peerManager) presharedKey(ctx context.Context, peerID string) wgtypes.Key {
+	edge, err := m.storage.Peers().GetEdge(ctx, m.net.nodeID, types.NodeID(peerID))
+	m.statemu.Lock()
+	defer m.statemu.Unlock()
+	if err != nil {
+		if !storerrors.IsEdgeNotFound(err) {
+			context.LoggerFrom(ctx).Debug("Could not lookup edge to peer for preshared key", slog.String("peer", peerID), slog.String("error", err.Error()))
+		}
+		return m.psks[peerID]
+	}
+	// The activation time comes from the clock of the node that started the
+	// rotation. See storage.RotatePresharedKey for the clock skew tolerated.
+	now := time.Now()
+	if next, activateAt, ok := types.PendingPresharedKeyFromEdgeAttrs(edge.GetAttributes(), m.net.nodeID, m.net.key); ok && now.Before(activateAt) {
+		m.scheduleRotation(ctx, peerID, next, activateAt)
+	}
+	if key, ok := types.ActivePresharedKeyFromEdgeAttrs(edge.GetAttributes(), now, m.net.nodeID, m.net.key); ok {
+		m.psks[peerID] = key
+		return key
+	}
+	return m.psks[peerID]
+}
+
+// scheduleRotation switches the given peer to the rotated preshared key at
+// activateAt. The switch happens locally so that both ends of the edge
+// change keys at the same time, whether or not storage is reachable then.
+// It must be called with the peer lock and statemu held.
+func (m *peerManager) scheduleRotation(ctx context.Context, peerID string, key wgtypes.Key, activateAt time.Time) {
+	if pending, ok := m.rotations[peerID]; ok {
+		if pending.key == key {
+			return
+		}
+		pending.timer.Stop()
+	}
+	log := context.LoggerFrom(ctx).With(slog.String("peer", peerID))
+	log.Debug("Scheduling preshared key rotation", slog.Time("activate-at", activateAt))
+	m.rotations[peerID] = pendingRotation{
+		key: key,
+		timer: time.AfterFunc(time.Until(activateAt), func() {
+			m.activateRotation(context.WithLogger(context.Background(), log), peerID, key)
+		}),
+	}
+}
+
+// activateRotation applies a rotated preshared key scheduled with
+// scheduleRotation, unless it was superseded in the meantime.
+func (m *peerManager) activateRotation(ctx context.Context, peerID string, key wgtypes.Key) {
+	defer m.lockPeer(peerID)()
+	m.statemu.Lock()
+	if pending, ok := m.rotations[peerID]; !ok || pending.key != key {
+		m.statemu.Unlock()
+		return
+	}
+	delete(m.rotations, peerID)
+	m.psks[peerID] = key
+	m.statemu.Unlock()
+	wg := m.net.WireGuard()
+	if wg == nil {
+		return
+	}
+	peer, ok := wg.Peers()[peerID]
+	if !ok {
+		return
+	}
+	peer.PresharedKey = key
+	if err := wg.PutPeer(ctx, &peer); err != nil {
+		context.LoggerFrom(ctx).Error("Failed to apply rotated preshared key", slog.String("error", err.Error()))
+		return
+	}
+	context.LoggerFrom(ctx).Info("Switched to rotated preshared key")
+}
+
+// setKeepalive records a keepalive override for the given peer and applies
+// it to the peer if it is currently configured. A zero interval removes
+// the override.
+func (m *peerManager) setKeepalive(ctx context.Context, peerID string, interval time.Duration) error {
+	defer m.lockPeer(peerID)()
+	m.statemu.Lock()
+	if interval == 0 {
+		delete(m.keepalives, peerID)
+	} else {
+		m.keepalives[peerID] = interval
+	}
+	m.statemu.Unlock()
+	wg := m.net.WireGuard()
+	if wg == nil {
+		return nil
+	}
+	peer, ok := wg.Peers()[peerID]
+	if !ok {
+		return nil
 	}
+	peer.PersistentKeepAlive = interval
+	if err := wg.PutPeer(ctx, &peer); err != nil {
+		return fmt.Errorf("put wireguard peer: %w", err)
+	}
+	return nil
+}
+
+// keepalive returns the keepalive override for the given peer, if any.
+func (m *peerManager) keepalive(peerID string) time.Duration {
+	m.statemu.Lock()
+	defer m.statemu.Unlock()
+	return m.keepalives[peerID]
 }
 
 type clientPeerConn struct {
@@ -94,8 +287,7 @@ type clientPeerConn struct {
 }
 
 func (m *peerManager) Close(ctx context.Context) {
-	m.peermu.Lock()
-	defer m.peermu.Unlock()
+	m.p2pmu.Lock()
 	for _, conn := range m.p2pConns {
 		err := conn.peerConn.Close()
 		if err != nil {
@@ -103,6 +295,13 @@ func (m *peerManager) Close(ctx context.Context) {
 		}
 	}
 	m.p2pConns = make(map[string]clientPeerConn)
+	m.p2pmu.Unlock()
+	m.statemu.Lock()
+	defer m.statemu.Unlock()
+	for _, pending := range m.rotations {
+		pending.timer.Stop()
+	}
+	m.rotations = make(map[string]pendingRotation)
 }
 
 func (m *peerManager) Resolver() PeerResolver {
@@ -110,8 +309,7 @@ func (m *peerManager) Resolver() PeerResolver {
 }
 
 func (m *peerManager) Add(ctx context.Context, peer *v1.WireGuardPeer, iceServers []string) error {
-	m.peermu.Lock()
-	defer m.peermu.Unlock()
+	defer m.lockPeer(peer.GetNode().GetId())()
 	if m.net.WireGuard() == nil {
 		return errors.New("add peer called before wireguard interface is ready")
 	}
@@ -121,16 +319,22 @@ func (m *peerManager) Add(ctx context.Context, peer *v1.WireGuardPeer, iceServer
 }
 
 func (m *peerManager) Sync(ctx context.Context) error {
-	peers, err := WireGuardPeersFor(ctx, m.net.storage, m.net.nodeID)
+	peers, err := m.wireGuardPeers(ctx)
 	if err != nil {
 		return fmt.Errorf("get wireguard peers: %w", err)
 	}
 	return m.Refresh(ctx, peers)
 }
 
-func (m *peerManager) Refresh(ctx context.Context, wgpeers []*v1.WireGuardPeer) error {
-	m.peermu.Lock()
-	defer m.peermu.Unlock()
+func (m *peerManager) wireGuardPeers(ctx context.Context) ([]*v1.WireGuardPeer, error) {
+	return m.net.graph.WireGuardPeers(ctx, m.net.nodeID, m.net.peerMapOptions())
+}
+
+func (m *peerManager) Refresh(ctx context.Context, wgpeers []*v1.WireGuardPeer) (err error) {
+	ctx, span := tracing.Start(ctx, "meshnet.RefreshWireguardPeers", attribute.Int("peers", len(wgpeers)))
+	defer tracing.End(span, &err)
+	m.refreshmu.Lock()
+	defer m.refreshmu.Unlock()
 	if m.net.WireGuard() == nil {
 		return errors.New("refresh peers called before wireguard interface is ready")
 	}
@@ -142,8 +346,11 @@ func (m *peerManager) Refresh(ctx context.Context, wgpeers []*v1.WireGuardPeer)
 	errs := make([]error, 0)
 	for _, peer := range wgpeers {
 		seenPeers[peer.GetNode().GetId()] = struct{}{}
-		// Ensure the peer is configured
+		// Ensure the peer is configured. Only this peer is locked while we
+		// do so, leaving updates to any other peer free to proceed.
+		unlock := m.lockPeer(peer.GetNode().GetId())
 		err := m.addPeer(ctx, peer, nil)
+		unlock()
 		if err != nil {
 			log.Error("Error adding peer", slog.String("error", err.Error()))
 			errs = append(errs, fmt.Errorf("add peer: %w", err))
@@ -153,6 +360,7 @@ func (m *peerManager) Refresh(ctx context.Context, wgpeers []*v1.WireGuardPeer)
 	for peer := range currentPeers {
 		if _, ok := seenPeers[peer]; !ok {
 			log.Debug("Removing peer", slog.String("peer_id", peer))
+			unlock := m.lockPeer(peer)
 			m.p2pmu.Lock()
 			if conn, ok := m.p2pConns[peer]; ok {
 				conn.peerConn.Close()
@@ -162,6 +370,7 @@ func (m *peerManager) Refresh(ctx context.Context, wgpeers []*v1.WireGuardPeer)
 			if err := m.net.WireGuard().DeletePeer(ctx, peer); err != nil {
 				errs = append(errs, fmt.Errorf("delete peer: %w", err))
 			}
+			unlock()
 		}
 	}
 	if len(errs) > 0 {
@@ -248,6 +457,9 @@ func (m *peerManager) addPeer(ctx context.Context, peer *v1.WireGuardPeer, iceSe
 		PrivateIPv6:     priv6,
 		AllowedIPs:      allowedIPs,
 		AllowedRoutes:   allowedRoutes,
+		// Overrides set through SetPeerKeepalive outlive refreshes.
+		PersistentKeepAlive: m.keepalive(peer.GetNode().GetId()),
+		PresharedKey:        m.presharedKey(ctx, peer.GetNode().GetId()),
 	}
 	for _, addr := range peer.GetNode().GetMultiaddrs() {
 		ma, err := multiaddr.NewMultiaddr(addr)
@@ -312,8 +524,9 @@ func (m *peerManager) determinePeerEndpoint(ctx context.Context, peer *v1.WireGu
 		}
 		endpoint = addr.AddrPort()
 	}
-	// Check if we are using zone awareness and the peer is in the same zone
-	if m.net.opts.ZoneAwarenessID != "" && peer.GetNode().GetZoneAwarenessID() == m.net.opts.ZoneAwarenessID {
+	// Check if we are using zone awareness and the peer shares a zone with us
+	zones := m.net.Zones()
+	if types.ZonesOverlap(zones, types.ParseZones(peer.GetNode().GetZoneAwarenessID())) {
 		log.Debug("Using zone awareness, collecting local CIDRs")
 		localCIDRs, err := endpoints.Detect(ctx, endpoints.DetectOpts{
 			DetectPrivate:  true,
@@ -324,35 +537,7 @@ func (m *peerManager) determinePeerEndpoint(ctx context.Context, peer *v1.WireGu
 			return endpoint, fmt.Errorf("detect local cidrs: %w", err)
 		}
 		log.Debug("Detected local CIDRs", slog.Any("cidrs", localCIDRs.Strings()))
-		// If the primary endpoint is not in our zone and additional endpoints are available,
-		// check if any of the additional endpoints are in our zone
-		if !localCIDRs.Contains(endpoint.Addr()) && len(peer.GetNode().GetWireguardEndpoints()) > 0 {
-			for _, additionalEndpoint := range peer.GetNode().GetWireguardEndpoints() {
-				addr, err := net.ResolveUDPAddr("udp", additionalEndpoint)
-				if err != nil {
-					log.Error("could not resolve peer primary endpoint", slog.String("error", err.Error()))
-					continue
-				}
-				if addr.AddrPort().Addr().Is4In6() {
-					// Same as above, this is an IPv4 address masquerading as an IPv6 address.
-					addr = &net.UDPAddr{
-						IP:   addr.IP.To4(),
-						Port: addr.Port,
-					}
-				}
-				log.Debug("Evalauting zone awareness endpoint",
-					slog.String("endpoint", addr.String()),
-					slog.String("zone", peer.GetNode().GetZoneAwarenessID()))
-				ep := addr.AddrPort()
-				if localCIDRs.Contains(ep.Addr()) {
-					// We found an additional endpoint that is in one of our local
-					// CIDRs. We'll use this one instead.
-					log.Debug("Zone awareness shared with peer, using LAN endpoint", slog.String("endpoint", ep.String()))
-					endpoint = ep
-					break
-				}
-			}
-		}
+		endpoint = selectZoneEndpoint(ctx, m.net.nodeID, zones, peer, endpoint, localCIDRs)
 	}
 	return endpoint, nil
 }
@@ -396,7 +581,7 @@ func (m *peerManager) negotiateP2PRelay(ctx context.Context, peer *v1.WireGuardP
 		defer func() {
 			// This is a hacky way to attempt to reconnect to the peer if
 			// the ICE connection is closed and they are still in the store.
-			wgpeers, err := WireGuardPeersFor(ctx, m.net.storage, m.net.nodeID)
+			wgpeers, err := m.wireGuardPeers(ctx)
 			if err != nil {
 				log.Error("Error getting wireguard peers after p2p connection closed", slog.String("error", err.Error()))
 				return
@@ -461,7 +646,7 @@ func (m *peerManager) negotiateICEConn(ctx context.Context, peer *v1.WireGuardPe
 		defer func() {
 			// This is a hacky way to attempt to reconnect to the peer if
 			// the ICE connection is closed and they are still in the store.
-			wgpeers, err := WireGuardPeersFor(ctx, m.net.storage, m.net.nodeID)
+			wgpeers, err := m.wireGuardPeers(ctx)
 			if err != nil {
 				log.Error("Error getting wireguard peers after ICE connection closed", slog.String("error", err.Error()))
 				return
@@ -519,3 +704,73 @@ func (m *peerManager) getSignalingTransport(ctx context.Context, peer *v1.WireGu
 		TargetAddr:  netip.AddrPortFrom(netip.IPv4Unspecified(), 0),
 	}), nil
 }
+
+// selectZoneEndpoint returns the endpoint to use for a peer given the zones
+// this node is available in and the CIDRs local to it. If the peer does not
+// share a zone with us, or its primary endpoint is already local, the given
+// endpoint is returned unchanged. Otherwise the peer's additional endpoints
+// inside one of our local CIDRs are candidates and one is chosen by rendezvous
+// hashing on our node ID and the endpoint. This makes the choice deterministic
+// regardless of the order endpoints are advertised in, while different nodes
+// in the zone spread their connections across the peer's LAN endpoints. Ties
+// in the hash are broken by the lowest endpoint.
+func selectZoneEndpoint(ctx context.Context, nodeID types.NodeID, zones []string, peer *v1.WireGuardPeer, endpoint netip.AddrPort, localCIDRs endpoints.PrefixList) netip.AddrPort {
+	log := context.LoggerFrom(ctx)
+	if !types.ZonesOverlap(zones, types.ParseZones(peer.GetNode().GetZoneAwarenessID())) {
+		return endpoint
+	}
+	// If the primary endpoint is not in our zone and additional endpoints are available,
+	// check if any of the additional endpoints are in our zone
+	if localCIDRs.Contains(endpoint.Addr()) || len(peer.GetNode().GetWireguardEndpoints()) == 0 {
+		return endpoint
+	}
+	var selected netip.AddrPort
+	var selectedScore uint64
+	for _, additionalEndpoint := range peer.GetNode().GetWireguardEndpoints() {
+		addr, err := net.ResolveUDPAddr("udp", additionalEndpoint)
+		if err != nil {
+			log.Error("could not resolve peer primary endpoint", slog.String("error", err.Error()))
+			continue
+		}
+		if addr.AddrPort().Addr().Is4In6() {
+			// Same as above, this is an IPv4 address masquerading as an IPv6 address.
+			addr = &net.UDPAddr{
+				IP:   addr.IP.To4(),
+				Port: addr.Port,
+			}
+		}
+		log.Debug("Evalauting zone awareness endpoint",
+			slog.String("endpoint", addr.String()),
+			slog.String("zone", peer.GetNode().GetZoneAwarenessID()))
+		ep := addr.AddrPort()
+		if !localCIDRs.Contains(ep.Addr()) {
+			continue
+		}
+		score := zoneEndpointScore(nodeID, ep)
+		if !selected.IsValid() || score > selectedScore || (score == selectedScore && compareAddrPorts(ep, selected) < 0) {
+			selected, selectedScore = ep, score
+		}
+	}
+	if !selected.IsValid() {
+		return endpoint
+	}
+	// We found an additional endpoint that is in one of our local
+	// CIDRs. We'll use this one instead.
+	log.Debug("Zone awareness shared with peer, using LAN endpoint", slog.String("endpoint", selected.String()))
+	return selected
+}
+
+// zoneEndpointScore returns the rendezvous hashing weight of the endpoint
+// for the given node.
+func zoneEndpointScore(nodeID types.NodeID, endpoint netip.AddrPort) uint64 {
+	sum := sha256.Sum256([]byte(nodeID.String() + "/" + endpoint.String()))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// compareAddrPorts orders endpoints by address and then by port.
+func compareAddrPorts(a, b netip.AddrPort) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return int(a.Port()) - int(b.Port())
+}
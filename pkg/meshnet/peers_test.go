@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+// slowWireGuard blocks puts of bulk peers until released.
+type slowWireGuard struct {
+	*fakeWireGuard
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (s *slowWireGuard) PutPeer(ctx context.Context, peer *wireguard.Peer) error {
+	if strings.HasPrefix(peer.ID, "bulk-") {
+		select {
+		case s.blocked <- struct{}{}:
+		default:
+		}
+		<-s.release
+	}
+	return s.fakeWireGuard.PutPeer(ctx, peer)
+}
+
+func TestRefreshDoesNotStarvePeerUpdates(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	m := New(db, Options{}, "node-a").(*manager)
+	wg := &slowWireGuard{
+		fakeWireGuard: &fakeWireGuard{peers: make(map[string]wireguard.Peer)},
+		blocked:       make(chan struct{}, 1),
+		release:       make(chan struct{}),
+	}
+	m.wg = wg
+
+	newPeer := func(id string) *v1.WireGuardPeer {
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		return &v1.WireGuardPeer{
+			Node: &v1.MeshNode{
+				Id:              id,
+				PublicKey:       encoded,
+				PrimaryEndpoint: "127.0.0.1:51820",
+			},
+			Proto: v1.ConnectProtocol_CONNECT_NATIVE,
+		}
+	}
+	bulk := []*v1.WireGuardPeer{newPeer("bulk-a"), newPeer("bulk-b"), newPeer("bulk-c")}
+	refreshed := make(chan error, 1)
+	go func() {
+		refreshed <- m.Peers().Refresh(ctx, bulk)
+	}()
+	select {
+	case <-wg.blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh never reached the wireguard interface")
+	}
+
+	// The refresh is now stuck on a bulk peer. Single-peer updates to
+	// other peers should go through regardless.
+	updated := make(chan error, 1)
+	go func() {
+		if err := m.Peers().Add(ctx, newPeer("urgent"), nil); err != nil {
+			updated <- err
+			return
+		}
+		updated <- m.SetPeerKeepalive(ctx, "urgent", 5*time.Second)
+	}()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Fatalf("update peer during refresh: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("single-peer update was blocked by the refresh")
+	}
+	if got := wg.Peers()["urgent"].PersistentKeepAlive; got != 5*time.Second {
+		t.Errorf("expected keepalive of 5s, got %s", got)
+	}
+
+	close(wg.release)
+	select {
+	case err := <-refreshed:
+		if err != nil {
+			t.Fatalf("refresh peers: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh did not complete")
+	}
+	peers := wg.Peers()
+	for _, id := range []string{"bulk-a", "bulk-b", "bulk-c", "urgent"} {
+		if _, ok := peers[id]; !ok {
+			t.Errorf("expected peer %s to be configured", id)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestZoneUpdateChangesEndpoint(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	m := New(db, Options{ZoneAwarenessID: "zone-a"}, "node-a")
+	peer := &v1.WireGuardPeer{
+		Node: &v1.MeshNode{
+			Id:                 "node-b",
+			ZoneAwarenessID:    "zone-b",
+			PrimaryEndpoint:    "203.0.113.10:51820",
+			WireguardEndpoints: []string{"192.168.1.10:51820"},
+		},
+	}
+	primary := netip.MustParseAddrPort("203.0.113.10:51820")
+	lan := netip.MustParseAddrPort("192.168.1.10:51820")
+	localCIDRs := endpoints.PrefixList{netip.MustParsePrefix("192.168.1.0/24")}
+
+	// We don't share a zone with the peer so the primary endpoint is used.
+	got := selectZoneEndpoint(ctx, "node-a", m.Zones(), peer, primary, localCIDRs)
+	if got != primary {
+		t.Fatalf("expected primary endpoint %s, got %s", primary, got)
+	}
+
+	// Once we are available in the peer's zone the LAN endpoint is preferred.
+	if err := m.SetZones(ctx, []string{"zone-a", "zone-b"}); err != nil {
+		t.Fatalf("set zones: %v", err)
+	}
+	got = selectZoneEndpoint(ctx, "node-a", m.Zones(), peer, primary, localCIDRs)
+	if got != lan {
+		t.Fatalf("expected LAN endpoint %s, got %s", lan, got)
+	}
+
+	// Peers available in several zones match on any of them.
+	if err := m.SetZones(ctx, []string{"zone-c"}); err != nil {
+		t.Fatalf("set zones: %v", err)
+	}
+	got = selectZoneEndpoint(ctx, "node-a", m.Zones(), peer, primary, localCIDRs)
+	if got != primary {
+		t.Fatalf("expected primary endpoint %s after leaving zone, got %s", primary, got)
+	}
+	peer.Node.ZoneAwarenessID = "zone-b,zone-c"
+	got = selectZoneEndpoint(ctx, "node-a", m.Zones(), peer, primary, localCIDRs)
+	if got != lan {
+		t.Fatalf("expected LAN endpoint %s for multi-zone peer, got %s", lan, got)
+	}
+
+	if err := m.SetZones(ctx, []string{"bad,zone"}); err == nil {
+		t.Fatal("expected error for invalid zone")
+	}
+}
+
+func TestZonePeersPreferPrivateEndpoints(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	localCIDRs := endpoints.PrefixList{netip.MustParsePrefix("192.168.1.0/24")}
+	newPeer := func(id string, lanEndpoints ...string) *v1.WireGuardPeer {
+		return &v1.WireGuardPeer{
+			Node: &v1.MeshNode{
+				Id:                 id,
+				ZoneAwarenessID:    "zone-a",
+				PrimaryEndpoint:    "203.0.113.10:51820",
+				WireguardEndpoints: append([]string{"203.0.113.10:51820"}, lanEndpoints...),
+			},
+		}
+	}
+	primary := netip.MustParseAddrPort("203.0.113.10:51820")
+
+	// Two nodes in the same zone prefer each other's LAN endpoints.
+	a := newPeer("node-a", "192.168.1.10:51820")
+	b := newPeer("node-b", "192.168.1.20:51820")
+	zones := []string{"zone-a"}
+	if got := selectZoneEndpoint(ctx, "node-a", zones, b, primary, localCIDRs); got != netip.MustParseAddrPort("192.168.1.20:51820") {
+		t.Errorf("expected node-a to use node-b's LAN endpoint, got %s", got)
+	}
+	if got := selectZoneEndpoint(ctx, "node-b", zones, a, primary, localCIDRs); got != netip.MustParseAddrPort("192.168.1.10:51820") {
+		t.Errorf("expected node-b to use node-a's LAN endpoint, got %s", got)
+	}
+
+	// Nodes in different zones use the primary endpoint.
+	if got := selectZoneEndpoint(ctx, "node-c", []string{"zone-b"}, a, primary, localCIDRs); got != primary {
+		t.Errorf("expected node in another zone to use the primary endpoint, got %s", got)
+	}
+
+	// With several LAN endpoints the selection is deterministic regardless of
+	// advertisement order and balanced across the nodes selecting them.
+	lan := []string{"192.168.1.10:51820", "192.168.1.11:51820", "192.168.1.12:51820"}
+	reversed := []string{lan[2], lan[1], lan[0]}
+	counts := make(map[netip.AddrPort]int)
+	const nodes = 300
+	for i := 0; i < nodes; i++ {
+		id := types.NodeID(fmt.Sprintf("node-%d", i))
+		got := selectZoneEndpoint(ctx, id, zones, newPeer("multi", lan...), primary, localCIDRs)
+		if again := selectZoneEndpoint(ctx, id, zones, newPeer("multi", reversed...), primary, localCIDRs); again != got {
+			t.Fatalf("expected %s to select the same endpoint regardless of order, got %s and %s", id, got, again)
+		}
+		counts[got]++
+	}
+	for _, ep := range lan {
+		n := counts[netip.MustParseAddrPort(ep)]
+		if n < nodes/len(lan)/2 {
+			t.Errorf("expected endpoint %s to be selected by a fair share of nodes, got %d of %d", ep, n, nodes)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+)
+
+// ErrPreflightFailed is returned when a critical preflight check fails.
+var ErrPreflightFailed = errors.New("preflight checks failed")
+
+// ErrPreflightNotSupported is returned by probes that cannot run on the
+// current platform. Checks using them are skipped.
+var ErrPreflightNotSupported = errors.New("preflight check not supported on this platform")
+
+// Names of the preflight checks.
+const (
+	PreflightNetAdmin        = "net-admin"
+	PreflightIPForwarding    = "ip-forwarding"
+	PreflightWireGuardModule = "wireguard-module"
+	PreflightTUNDevice       = "tun-device"
+)
+
+// CheckResult is the result of a single preflight check.
+type CheckResult struct {
+	// Name is the name of the check.
+	Name string `json:"name"`
+	// Passed is true if the check passed or was skipped.
+	Passed bool `json:"passed"`
+	// Skipped is true if the check does not apply to this host or
+	// configuration.
+	Skipped bool `json:"skipped,omitempty"`
+	// Critical is true if starting the network will fail when the check
+	// does not pass.
+	Critical bool `json:"critical"`
+	// Message describes the outcome of the check.
+	Message string `json:"message,omitempty"`
+	// Remedy describes how to fix a failed check.
+	Remedy string `json:"remedy,omitempty"`
+}
+
+// PreflightProbes are the host probes used by the preflight checks.
+// Probes return ErrPreflightNotSupported when they cannot run on the
+// current platform.
+type PreflightProbes struct {
+	// HasNetAdmin reports if the process can configure network interfaces.
+	HasNetAdmin func() (bool, error)
+	// ReadSysctl returns the value of the sysctl with the given
+	// slash-separated name.
+	ReadSysctl func(name string) (string, error)
+	// WireGuardModule returns an error if the wireguard kernel module is
+	// neither loaded nor available to load.
+	WireGuardModule func(ctx context.Context) error
+	// CreateTUN creates and removes a TUN device.
+	CreateTUN func(ctx context.Context) error
+}
+
+// RunPreflight validates the host against the given options with the given
+// probes. Checks that do not apply to the configured implementation are
+// skipped.
+func RunPreflight(ctx context.Context, opts Options, probes PreflightProbes) []CheckResult {
+	impl := opts.Implementation
+	if impl == "" {
+		impl = wireguard.ImplementationAuto
+	}
+	if opts.ForceTUN {
+		impl = wireguard.ImplementationUserspace
+	}
+	results := make([]CheckResult, 0, 4)
+
+	netAdmin := CheckResult{Name: PreflightNetAdmin, Critical: true}
+	ok, err := probes.HasNetAdmin()
+	switch {
+	case errors.Is(err, ErrPreflightNotSupported):
+		netAdmin.skip(err)
+	case err != nil:
+		netAdmin.Message = fmt.Sprintf("could not determine capabilities: %v", err)
+		netAdmin.Remedy = "Run as root or grant the process the CAP_NET_ADMIN capability."
+	case !ok:
+		netAdmin.Message = "the process lacks the CAP_NET_ADMIN capability"
+		netAdmin.Remedy = "Run as root or grant the process the CAP_NET_ADMIN capability."
+	default:
+		netAdmin.Passed = true
+	}
+	results = append(results, netAdmin)
+
+	forwarding := CheckResult{Name: PreflightIPForwarding}
+	var sysctls []string
+	if !opts.DisableIPv4 {
+		sysctls = append(sysctls, "net/ipv4/ip_forward")
+	}
+	if !opts.DisableIPv6 {
+		sysctls = append(sysctls, "net/ipv6/conf/all/forwarding")
+	}
+	var disabled []string
+	for _, name := range sysctls {
+		val, err := probes.ReadSysctl(name)
+		if errors.Is(err, ErrPreflightNotSupported) {
+			forwarding.skip(err)
+			break
+		}
+		if err != nil || strings.TrimSpace(val) != "1" {
+			disabled = append(disabled, strings.ReplaceAll(name, "/", "."))
+		}
+	}
+	if !forwarding.Skipped {
+		if len(disabled) > 0 {
+			forwarding.Message = fmt.Sprintf("ip forwarding is disabled (%s), traffic cannot be routed through this node", strings.Join(disabled, ", "))
+			forwarding.Remedy = fmt.Sprintf("Enable forwarding with: sysctl -w %s=1", strings.Join(disabled, "=1 "))
+		} else {
+			forwarding.Passed = true
+		}
+	}
+	results = append(results, forwarding)
+
+	module := CheckResult{Name: PreflightWireGuardModule, Critical: impl == wireguard.ImplementationKernel}
+	var moduleErr error
+	if impl == wireguard.ImplementationUserspace {
+		module.skip(errors.New("the userspace implementation is in use"))
+	} else {
+		moduleErr = probes.WireGuardModule(ctx)
+		switch {
+		case errors.Is(moduleErr, ErrPreflightNotSupported):
+			module.skip(moduleErr)
+		case moduleErr != nil:
+			module.Message = fmt.Sprintf("the wireguard kernel module is not available: %v", moduleErr)
+			module.Remedy = "Install the wireguard kernel module, or use the userspace implementation."
+		default:
+			module.Passed = true
+		}
+	}
+	results = append(results, module)
+
+	// A TUN device is only needed when the kernel module is not used.
+	tun := CheckResult{Name: PreflightTUNDevice, Critical: true}
+	if impl == wireguard.ImplementationKernel || (impl == wireguard.ImplementationAuto && module.Passed && !module.Skipped) {
+		tun.skip(errors.New("the kernel implementation is in use"))
+	} else {
+		err := probes.CreateTUN(ctx)
+		switch {
+		case errors.Is(err, ErrPreflightNotSupported):
+			tun.skip(err)
+		case err != nil:
+			tun.Message = fmt.Sprintf("could not create a TUN device: %v", err)
+			tun.Remedy = "Make sure /dev/net/tun exists and is accessible, e.g. by passing it through to the container."
+		default:
+			tun.Passed = true
+		}
+	}
+	results = append(results, tun)
+	return results
+}
+
+func (c *CheckResult) skip(reason error) {
+	c.Passed = true
+	c.Skipped = true
+	c.Message = reason.Error()
+}
+
+// CheckPreflight returns an error wrapping ErrPreflightFailed if any
+// critical check in the given results failed.
+func CheckPreflight(results []CheckResult) error {
+	var failed []string
+	for _, res := range results {
+		if res.Critical && !res.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", res.Name, res.Message))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrPreflightFailed, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in capability sets.
+const capNetAdmin = 12
+
+// hasCapability reports if the effective capability set in the given
+// contents of /proc/<pid>/status contains the given capability.
+func hasCapability(status []byte, capability uint) (bool, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		val, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(val), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("parse effective capabilities: %w", err)
+		}
+		return caps&(1<<capability) != 0, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("no effective capabilities in process status")
+}
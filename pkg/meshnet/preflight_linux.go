@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+)
+
+// preflightTUNName is the name of the TUN device created to test TUN support.
+const preflightTUNName = "webmesh-pf0"
+
+// DefaultPreflightProbes returns the probes used to check the current host.
+func DefaultPreflightProbes() PreflightProbes {
+	return PreflightProbes{
+		HasNetAdmin: func() (bool, error) {
+			status, err := os.ReadFile("/proc/self/status")
+			if err != nil {
+				return false, fmt.Errorf("read process status: %w", err)
+			}
+			return hasCapability(status, capNetAdmin)
+		},
+		ReadSysctl: func(name string) (string, error) {
+			return sysctl.Sysctl(name)
+		},
+		WireGuardModule: func(ctx context.Context) error {
+			if _, err := os.Stat("/sys/module/wireguard"); err == nil {
+				return nil
+			}
+			// Check that the module can be loaded without loading it.
+			if err := common.Exec(ctx, "modprobe", "--dry-run", "wireguard"); err != nil {
+				return errors.New("module is not loaded and cannot be found by modprobe")
+			}
+			return nil
+		},
+		CreateTUN: func(ctx context.Context) error {
+			_, closer, err := link.NewTUN(ctx, preflightTUNName, 1280)
+			if err != nil {
+				return err
+			}
+			closer()
+			return nil
+		},
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !linux
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// DefaultPreflightProbes returns the probes used to check the current host.
+// Host checks are only implemented on Linux.
+func DefaultPreflightProbes() PreflightProbes {
+	return PreflightProbes{
+		HasNetAdmin:     func() (bool, error) { return false, ErrPreflightNotSupported },
+		ReadSysctl:      func(string) (string, error) { return "", ErrPreflightNotSupported },
+		WireGuardModule: func(context.Context) error { return ErrPreflightNotSupported },
+		CreateTUN:       func(context.Context) error { return ErrPreflightNotSupported },
+	}
+}
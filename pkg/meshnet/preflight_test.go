@@ -0,0 +1,184 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+// mockProbes returns probes for a fully capable host with the given
+// overrides applied.
+func mockProbes(override func(*PreflightProbes)) PreflightProbes {
+	probes := PreflightProbes{
+		HasNetAdmin:     func() (bool, error) { return true, nil },
+		ReadSysctl:      func(string) (string, error) { return "1\n", nil },
+		WireGuardModule: func(context.Context) error { return nil },
+		CreateTUN:       func(context.Context) error { return nil },
+	}
+	if override != nil {
+		override(&probes)
+	}
+	return probes
+}
+
+func TestRunPreflight(t *testing.T) {
+	t.Parallel()
+	noModule := func(p *PreflightProbes) {
+		p.WireGuardModule = func(context.Context) error { return errors.New("not found") }
+	}
+	noTUN := func(p *PreflightProbes) {
+		p.CreateTUN = func(context.Context) error { return errors.New("no such device") }
+	}
+	tc := []struct {
+		name     string
+		opts     Options
+		probes   PreflightProbes
+		failed   []string
+		skipped  []string
+		critical bool
+	}{
+		{
+			name:    "CapableHost",
+			probes:  mockProbes(nil),
+			skipped: []string{PreflightTUNDevice},
+		},
+		{
+			name:     "NoNetAdmin",
+			probes:   mockProbes(func(p *PreflightProbes) { p.HasNetAdmin = func() (bool, error) { return false, nil } }),
+			failed:   []string{PreflightNetAdmin},
+			skipped:  []string{PreflightTUNDevice},
+			critical: true,
+		},
+		{
+			name: "ForwardingDisabled",
+			probes: mockProbes(func(p *PreflightProbes) {
+				p.ReadSysctl = func(name string) (string, error) {
+					if name == "net/ipv6/conf/all/forwarding" {
+						return "0", nil
+					}
+					return "1", nil
+				}
+			}),
+			failed:  []string{PreflightIPForwarding},
+			skipped: []string{PreflightTUNDevice},
+		},
+		{
+			name:   "AutoFallsBackToTUN",
+			probes: mockProbes(noModule),
+			failed: []string{PreflightWireGuardModule},
+		},
+		{
+			name:     "AutoWithoutModuleOrTUN",
+			probes:   mockProbes(func(p *PreflightProbes) { noModule(p); noTUN(p) }),
+			failed:   []string{PreflightWireGuardModule, PreflightTUNDevice},
+			critical: true,
+		},
+		{
+			name:     "KernelRequiresModule",
+			opts:     Options{Implementation: wireguard.ImplementationKernel},
+			probes:   mockProbes(noModule),
+			failed:   []string{PreflightWireGuardModule},
+			skipped:  []string{PreflightTUNDevice},
+			critical: true,
+		},
+		{
+			name:     "UserspaceRequiresTUN",
+			opts:     Options{ForceTUN: true},
+			probes:   mockProbes(noTUN),
+			failed:   []string{PreflightTUNDevice},
+			skipped:  []string{PreflightWireGuardModule},
+			critical: true,
+		},
+		{
+			name: "UnsupportedPlatform",
+			probes: PreflightProbes{
+				HasNetAdmin:     func() (bool, error) { return false, ErrPreflightNotSupported },
+				ReadSysctl:      func(string) (string, error) { return "", ErrPreflightNotSupported },
+				WireGuardModule: func(context.Context) error { return ErrPreflightNotSupported },
+				CreateTUN:       func(context.Context) error { return ErrPreflightNotSupported },
+			},
+			skipped: []string{PreflightNetAdmin, PreflightIPForwarding, PreflightWireGuardModule, PreflightTUNDevice},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			results := RunPreflight(context.Background(), tt.opts, tt.probes)
+			var failed, skipped []string
+			for _, res := range results {
+				if !res.Passed {
+					failed = append(failed, res.Name)
+					if res.Remedy == "" {
+						t.Errorf("expected a remedy for failed check %s", res.Name)
+					}
+				}
+				if res.Skipped {
+					skipped = append(skipped, res.Name)
+				}
+			}
+			if !slices.Equal(failed, tt.failed) {
+				t.Errorf("expected failed checks %v, got %v", tt.failed, failed)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("expected skipped checks %v, got %v", tt.skipped, skipped)
+			}
+			err := CheckPreflight(results)
+			if tt.critical != errors.Is(err, ErrPreflightFailed) {
+				t.Errorf("expected critical failure %v, got %v", tt.critical, err)
+			}
+		})
+	}
+}
+
+func TestStartFailsPreflight(t *testing.T) {
+	t.Parallel()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	m := New(db, Options{Preflight: true, Implementation: wireguard.ImplementationKernel}, "node-a").(*manager)
+	m.probes = mockProbes(func(p *PreflightProbes) { p.HasNetAdmin = func() (bool, error) { return false, nil } })
+	err := m.Start(context.Background(), StartOptions{})
+	if !errors.Is(err, ErrPreflightFailed) {
+		t.Fatalf("expected start to fail preflight, got %v", err)
+	}
+	if m.WireGuard() != nil {
+		t.Error("expected no interface to be created")
+	}
+}
+
+func TestHasCapability(t *testing.T) {
+	t.Parallel()
+	status := []byte("Name:\twebmesh\nCapInh:\t0000000000000000\nCapEff:\t0000000000001000\n")
+	ok, err := hasCapability(status, capNetAdmin)
+	if err != nil || !ok {
+		t.Errorf("expected CAP_NET_ADMIN, got %v: %v", ok, err)
+	}
+	ok, err = hasCapability([]byte("CapEff:\t0000000000000400\n"), capNetAdmin)
+	if err != nil || ok {
+		t.Errorf("expected no CAP_NET_ADMIN, got %v: %v", ok, err)
+	}
+	if _, err := hasCapability([]byte("Name:\twebmesh\n"), capNetAdmin); err == nil {
+		t.Error("expected error without effective capabilities")
+	}
+}
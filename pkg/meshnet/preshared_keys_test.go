@@ -0,0 +1,318 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestPeerPresharedKeys(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	m := New(db, Options{}, "node-a").(*manager)
+	wg := &fakeWireGuard{peers: make(map[string]wireguard.Peer)}
+	m.wg = wg
+
+	newKey := func() wgtypes.Key {
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			t.Fatalf("generate preshared key: %v", err)
+		}
+		return key
+	}
+	newPeer := func(id string) *v1.WireGuardPeer {
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		return &v1.WireGuardPeer{
+			Node: &v1.MeshNode{
+				Id:              id,
+				PublicKey:       encoded,
+				PrimaryEndpoint: "127.0.0.1:51820",
+			},
+			Proto: v1.ConnectProtocol_CONNECT_NATIVE,
+		}
+	}
+	presharedKey := func(id string) wgtypes.Key {
+		t.Helper()
+		peer, ok := wg.Peers()[id]
+		if !ok {
+			t.Fatalf("peer %s not configured", id)
+		}
+		return peer.PresharedKey
+	}
+
+	// Keys negotiated on edges should be read from storage.
+	nodeKeys := make(map[types.NodeID]crypto.PublicKey)
+	for _, id := range []string{"node-a", "node-b", "node-c"} {
+		key := crypto.MustGenerateKey()
+		if id == "node-a" {
+			m.key = key
+		}
+		encoded, err := key.PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		nodeKeys[types.NodeID(id)] = key.PublicKey()
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PublicKey: encoded}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	edgeKey := newKey()
+	attrs, err := types.EdgeAttrsWithPresharedKey(nil, edgeKey, map[types.NodeID]crypto.PublicKey{
+		"node-a": nodeKeys["node-a"],
+		"node-b": nodeKeys["node-b"],
+	})
+	if err != nil {
+		t.Fatalf("seal preshared key: %v", err)
+	}
+	err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source:     "node-b",
+		Target:     "node-a",
+		Weight:     1,
+		Attributes: attrs,
+	}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	peers := []*v1.WireGuardPeer{newPeer("node-b"), newPeer("node-c")}
+	if err := m.Peers().Refresh(ctx, peers); err != nil {
+		t.Fatalf("refresh peers: %v", err)
+	}
+	if got := presharedKey("node-b"); got != edgeKey {
+		t.Errorf("expected preshared key from the edge to be configured, got %s", got)
+	}
+	if got := presharedKey("node-c"); got != (wgtypes.Key{}) {
+		t.Errorf("expected no preshared key for node-c, got %s", got)
+	}
+
+	// Keys set directly should be applied to the live peer and kept
+	// across refreshes.
+	joinKey := newKey()
+	if err := m.SetPeerPresharedKey(ctx, "node-c", joinKey); err != nil {
+		t.Fatalf("set peer preshared key: %v", err)
+	}
+	if got := presharedKey("node-c"); got != joinKey {
+		t.Errorf("expected preshared key to be applied, got %s", got)
+	}
+	if err := m.Peers().Refresh(ctx, peers); err != nil {
+		t.Fatalf("refresh peers: %v", err)
+	}
+	if got := presharedKey("node-c"); got != joinKey {
+		t.Errorf("expected preshared key to survive a refresh, got %s", got)
+	}
+
+	// Clearing the key should remove it from the peer.
+	if err := m.SetPeerPresharedKey(ctx, "node-c", wgtypes.Key{}); err != nil {
+		t.Fatalf("clear peer preshared key: %v", err)
+	}
+	if got := presharedKey("node-c"); got != (wgtypes.Key{}) {
+		t.Errorf("expected preshared key to be removed, got %s", got)
+	}
+}
+
+func TestPresharedKeyRotation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	// Two endpoints sharing the same control plane.
+	type endpoint struct {
+		id  string
+		m   *manager
+		wg  *fakeWireGuard
+		key crypto.PrivateKey
+		pub string
+	}
+	var endpoints []*endpoint
+	for _, id := range []string{"node-a", "node-b"} {
+		key := crypto.MustGenerateKey()
+		encoded, err := key.PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PublicKey: encoded}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+		ep := &endpoint{
+			id:  id,
+			m:   New(db, Options{}, types.NodeID(id)).(*manager),
+			wg:  &fakeWireGuard{peers: make(map[string]wireguard.Peer)},
+			key: key,
+			pub: encoded,
+		}
+		ep.m.wg = ep.wg
+		ep.m.key = key
+		t.Cleanup(func() { ep.m.peers.Close(ctx) })
+		endpoints = append(endpoints, ep)
+	}
+	a, b := endpoints[0], endpoints[1]
+	initial, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate preshared key: %v", err)
+	}
+	attrs, err := types.EdgeAttrsWithPresharedKey(nil, initial, map[types.NodeID]crypto.PublicKey{
+		types.NodeID(a.id): a.key.PublicKey(),
+		types.NodeID(b.id): b.key.PublicKey(),
+	})
+	if err != nil {
+		t.Fatalf("seal preshared key: %v", err)
+	}
+	err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source:     a.id,
+		Target:     b.id,
+		Weight:     1,
+		Attributes: attrs,
+	}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	// syncPeers simulates both endpoints receiving a peer update from storage.
+	syncPeers := func() {
+		t.Helper()
+		for _, ep := range endpoints {
+			peer := a
+			if ep == a {
+				peer = b
+			}
+			err := ep.m.Peers().Refresh(ctx, []*v1.WireGuardPeer{{
+				Node: &v1.MeshNode{
+					Id:              peer.id,
+					PublicKey:       peer.pub,
+					PrimaryEndpoint: "127.0.0.1:51820",
+				},
+				Proto: v1.ConnectProtocol_CONNECT_NATIVE,
+			}})
+			if err != nil {
+				t.Fatalf("refresh peers on %s: %v", ep.id, err)
+			}
+		}
+	}
+	keys := func() (wgtypes.Key, wgtypes.Key) {
+		return a.wg.Peers()[b.id].PresharedKey, b.wg.Peers()[a.id].PresharedKey
+	}
+	syncPeers()
+	if ka, kb := keys(); ka != initial || kb != initial {
+		t.Fatal("expected both endpoints to use the initial preshared key")
+	}
+
+	// Simulate the tunnel between the endpoints. Sessions are renegotiated
+	// every rekeyAfter, which only succeeds when both ends agree on the
+	// preshared key, and are dropped once they are older than rejectAfter.
+	const (
+		rekeyAfter  = 20 * time.Millisecond
+		rejectAfter = 150 * time.Millisecond
+	)
+	stop := make(chan struct{})
+	outages := make(chan time.Duration, 1)
+	go func() {
+		lastHandshake := time.Now()
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				close(outages)
+				return
+			case now := <-ticker.C:
+				if now.Sub(lastHandshake) < rekeyAfter {
+					continue
+				}
+				if ka, kb := keys(); ka == kb {
+					lastHandshake = now
+					continue
+				}
+				if age := now.Sub(lastHandshake); age > rejectAfter {
+					select {
+					case outages <- age:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	next, activateAt, err := storage.RotatePresharedKey(ctx, db.Peers(), types.NodeID(a.id), types.NodeID(b.id), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("rotate preshared key: %v", err)
+	}
+	syncPeers()
+	if ka, kb := keys(); ka != initial || kb != initial {
+		t.Error("expected the initial key to stay in use until the rotation activates")
+	}
+	// Both ends should switch on their own at the activation time.
+	deadline := time.After(time.Until(activateAt) + 2*time.Second)
+	for {
+		if ka, kb := keys(); ka == next && kb == next {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for both endpoints to switch preshared keys")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if time.Now().Before(activateAt) {
+		t.Error("expected endpoints to switch keys no earlier than the activation time")
+	}
+	// Let the tunnel run through a few more rekeys on the new key.
+	time.Sleep(5 * rekeyAfter)
+	close(stop)
+	for age := range outages {
+		t.Errorf("tunnel dropped during rotation, last handshake %s ago", age)
+	}
+
+	// Completing the rotation retires the old key without changing the
+	// key in use.
+	completed, err := storage.CompletePresharedKeyRotations(ctx, db.Peers(), time.Now().Add(storage.PresharedKeyRotationGrace))
+	if err != nil {
+		t.Fatalf("complete rotations: %v", err)
+	}
+	if completed != 1 {
+		t.Errorf("expected 1 rotation to be completed, got %d", completed)
+	}
+	edge, err := db.Peers().GetEdge(ctx, types.NodeID(a.id), types.NodeID(b.id))
+	if err != nil {
+		t.Fatalf("get edge: %v", err)
+	}
+	if _, ok := types.PresharedKeyRotationFromEdgeAttrs(edge.GetAttributes()); ok {
+		t.Error("expected the pending key to be removed")
+	}
+	if key, ok := types.PresharedKeyFromEdgeAttrs(edge.GetAttributes(), types.NodeID(a.id), a.key); !ok || key != next {
+		t.Error("expected the rotated key to become the current key")
+	}
+	syncPeers()
+	if ka, kb := keys(); ka != next || kb != next {
+		t.Error("expected both endpoints to keep the rotated key")
+	}
+}
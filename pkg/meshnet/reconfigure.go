@@ -0,0 +1,161 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+)
+
+// ReconfigureResult summarizes the changes made by a full reconfiguration
+// of the local network. Peers are identified by node ID, or by public key
+// when the peer was not known to the mesh.
+type ReconfigureResult struct {
+	// PeersAdded are peers that were missing from the interface.
+	PeersAdded []string `json:"peersAdded,omitempty"`
+	// PeersUpdated are peers whose endpoint or allowed IPs had drifted.
+	PeersUpdated []string `json:"peersUpdated,omitempty"`
+	// PeersRemoved are peers that were removed from the interface.
+	PeersRemoved []string `json:"peersRemoved,omitempty"`
+}
+
+// Changed reports if the reconfiguration changed any peers.
+func (r *ReconfigureResult) Changed() bool {
+	return len(r.PeersAdded) > 0 || len(r.PeersUpdated) > 0 || len(r.PeersRemoved) > 0
+}
+
+// DiffDeviceConfigs returns the peer changes between two dumps of a device
+// configuration.
+func DiffDeviceConfigs(before, after *wireguard.DeviceConfig) *ReconfigureResult {
+	index := func(cfg *wireguard.DeviceConfig) map[string]wireguard.PeerConfig {
+		out := make(map[string]wireguard.PeerConfig)
+		if cfg == nil {
+			return out
+		}
+		for _, peer := range cfg.Peers {
+			out[peer.PublicKey] = peer
+		}
+		return out
+	}
+	name := func(peer wireguard.PeerConfig) string {
+		if peer.ID != "" {
+			return peer.ID
+		}
+		return peer.PublicKey
+	}
+	prev, next := index(before), index(after)
+	res := &ReconfigureResult{}
+	for key, peer := range next {
+		old, ok := prev[key]
+		if !ok {
+			res.PeersAdded = append(res.PeersAdded, name(peer))
+			continue
+		}
+		oldIPs, newIPs := slices.Clone(old.AllowedIPs), slices.Clone(peer.AllowedIPs)
+		slices.Sort(oldIPs)
+		slices.Sort(newIPs)
+		if old.Endpoint != peer.Endpoint || !slices.Equal(oldIPs, newIPs) {
+			res.PeersUpdated = append(res.PeersUpdated, name(peer))
+		}
+	}
+	for key, peer := range prev {
+		if _, ok := next[key]; !ok {
+			res.PeersRemoved = append(res.PeersRemoved, name(peer))
+		}
+	}
+	slices.Sort(res.PeersAdded)
+	slices.Sort(res.PeersUpdated)
+	slices.Sort(res.PeersRemoved)
+	return res
+}
+
+func (m *manager) Reconfigure(ctx context.Context) (*ReconfigureResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wg == nil {
+		return nil, errors.New("reconfigure called before wireguard interface is ready")
+	}
+	log := context.LoggerFrom(ctx).With("component", "net-manager")
+	ctx = context.WithLogger(ctx, log)
+	log.Info("Forcing full reconfiguration of the mesh network")
+	before, err := m.wg.DumpConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dump wireguard config: %w", err)
+	}
+	unknown, err := m.wg.RemoveUnknownPeers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("remove unknown peers: %w", err)
+	}
+	if len(unknown) > 0 {
+		log.Info("Removed peers unknown to the mesh", slog.Any("peers", unknown))
+	}
+	err = m.peers.Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("sync peers: %w", err)
+	}
+	for _, route := range []struct {
+		prefix  netip.Prefix
+		enabled bool
+	}{
+		{m.networkv4, !m.opts.DisableIPv4},
+		{m.networkv6, !m.opts.DisableIPv6},
+		{m.wg.AddressV6(), !m.opts.DisableIPv6},
+	} {
+		if !route.prefix.IsValid() || !route.enabled {
+			continue
+		}
+		err = m.wg.AddRoute(ctx, route.prefix)
+		if err != nil && !system.IsRouteExists(err) {
+			return nil, fmt.Errorf("add mesh network route %s: %w", route.prefix, err)
+		}
+	}
+	// Rebuild the firewall from scratch so rules changed outside of
+	// webmesh are restored.
+	if m.fw != nil {
+		if err := m.fw.Close(ctx); err != nil {
+			log.Warn("Error clearing firewall rules", slog.String("error", err.Error()))
+		}
+	}
+	err = m.configureFirewall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.masquerading {
+		err = m.fw.AddMasquerade(ctx, m.wg.Name())
+		if err != nil {
+			return nil, fmt.Errorf("add masquerade rule: %w", err)
+		}
+	}
+	after, err := m.wg.DumpConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dump wireguard config: %w", err)
+	}
+	res := DiffDeviceConfigs(before, after)
+	log.Info("Finished reconfiguring the mesh network",
+		slog.Any("added", res.PeersAdded),
+		slog.Any("updated", res.PeersUpdated),
+		slog.Any("removed", res.PeersRemoved),
+	)
+	return res, nil
+}
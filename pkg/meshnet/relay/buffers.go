@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package relay
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultStreamBuffer is the buffer size used when copying between streams.
+// It matches the buffer size used by io.Copy.
+const DefaultStreamBuffer = 32 * 1024
+
+// pools holds a *sync.Pool of buffers for each requested size.
+var pools sync.Map
+
+func poolFor(size int) *sync.Pool {
+	if pool, ok := pools.Load(size); ok {
+		return pool.(*sync.Pool)
+	}
+	pool, _ := pools.LoadOrStore(size, &sync.Pool{
+		New: func() any {
+			buf := make([]byte, size)
+			return &buf
+		},
+	})
+	return pool.(*sync.Pool)
+}
+
+// GetBuffer returns a buffer of the given size from a shared pool. It should
+// be returned with PutBuffer when no longer in use.
+func GetBuffer(size int) *[]byte {
+	return poolFor(size).Get().(*[]byte)
+}
+
+// PutBuffer returns a buffer retrieved with GetBuffer to its pool.
+func PutBuffer(buf *[]byte) {
+	if buf == nil || len(*buf) == 0 {
+		return
+	}
+	poolFor(len(*buf)).Put(buf)
+}
+
+// CopyBuffer is like io.CopyBuffer but uses a pooled buffer of the given size.
+func CopyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		size = DefaultStreamBuffer
+	}
+	buf := GetBuffer(size)
+	defer PutBuffer(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
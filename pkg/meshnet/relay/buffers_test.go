@@ -0,0 +1,76 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// onlyReader and onlyWriter hide any ReaderFrom/WriterTo implementations so
+// that the copy buffer is always used.
+type onlyReader struct{ io.Reader }
+
+type onlyWriter struct{ io.Writer }
+
+func TestCopyBuffer(t *testing.T) {
+	t.Parallel()
+	payload := bytes.Repeat([]byte("webmesh"), 10000)
+	for _, size := range []int{0, 1, 512, DefaultStreamBuffer} {
+		size := size
+		t.Run(fmt.Sprintf("Size%d", size), func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			n, err := CopyBuffer(onlyWriter{&out}, onlyReader{bytes.NewReader(payload)}, size)
+			if err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+			if n != int64(len(payload)) || !bytes.Equal(out.Bytes(), payload) {
+				t.Fatalf("expected %d bytes to be copied intact, got %d", len(payload), n)
+			}
+		})
+	}
+}
+
+func BenchmarkCopyBuffer(b *testing.B) {
+	for _, payloadSize := range []int{1024, 64 * 1024, 1024 * 1024} {
+		payload := make([]byte, payloadSize)
+		reader := bytes.NewReader(payload)
+		b.Run(fmt.Sprintf("Alloc/%d", payloadSize), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(payloadSize))
+			for i := 0; i < b.N; i++ {
+				reader.Reset(payload)
+				if _, err := io.CopyBuffer(onlyWriter{io.Discard}, onlyReader{reader}, make([]byte, DefaultStreamBuffer)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("Pooled/%d", payloadSize), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(payloadSize))
+			for i := 0; i < b.N; i++ {
+				reader.Reset(payload)
+				if _, err := CopyBuffer(onlyWriter{io.Discard}, onlyReader{reader}, DefaultStreamBuffer); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -102,7 +102,7 @@ func (r *LocalUDP) Relay(ctx context.Context, from io.ReadWriteCloser) error {
 		defer r.Conn.Close()
 		defer log.Debug("Relay from local interface to stream stopped")
 		log.Debug("Relay from local interface to stream started")
-		_, err := io.CopyBuffer(from, r.Conn, make([]byte, r.bufSize))
+		_, err := CopyBuffer(from, r.Conn, r.bufSize)
 		if err != nil {
 			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 				return nil
@@ -116,7 +116,7 @@ func (r *LocalUDP) Relay(ctx context.Context, from io.ReadWriteCloser) error {
 		defer from.Close()
 		defer log.Debug("Relay from stream to local interface stopped")
 		log.Debug("Relay from stream to local interface started")
-		_, err := io.CopyBuffer(r.Conn, from, make([]byte, r.bufSize))
+		_, err := CopyBuffer(r.Conn, from, r.bufSize)
 		if err != nil {
 			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 				return nil
@@ -0,0 +1,183 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	storerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultRoamingInterval is the default interval for checking peers for
+// roamed endpoints.
+const DefaultRoamingInterval = 30 * time.Second
+
+// ErrRoamedEndpointRejected is returned when a roamed endpoint does not
+// pass validation.
+var ErrRoamedEndpointRejected = errors.New("roamed endpoint rejected")
+
+// RoamingOptions are options for accepting endpoints learned by WireGuard
+// when a peer roams.
+type RoamingOptions struct {
+	// Enabled accepts roamed endpoints that pass validation.
+	Enabled bool
+	// AllowedPrefixes restricts roamed endpoints to addresses within one
+	// of the given prefixes. Empty allows any unicast address outside of
+	// the mesh networks.
+	AllowedPrefixes []netip.Prefix
+	// Interval is how often peers are checked for roamed endpoints.
+	// Defaults to DefaultRoamingInterval.
+	Interval time.Duration
+}
+
+// RoamedEndpoint is a live endpoint of a peer that is not one of the
+// endpoints stored for it.
+type RoamedEndpoint struct {
+	// NodeID is the ID of the peer.
+	NodeID types.NodeID `json:"nodeID"`
+	// Endpoint is the live endpoint of the peer.
+	Endpoint netip.AddrPort `json:"endpoint"`
+	// Accepted is true if the endpoint passed validation.
+	Accepted bool `json:"accepted"`
+	// Reason is why the endpoint was rejected.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate checks that the given roamed endpoint may be adopted. It must be
+// a unicast address outside of the given mesh networks and within one of
+// the allowed prefixes if any are configured.
+func (o RoamingOptions) Validate(endpoint netip.AddrPort, meshNetworks ...netip.Prefix) error {
+	addr := endpoint.Addr().Unmap()
+	switch {
+	case !endpoint.IsValid() || endpoint.Port() == 0:
+		return fmt.Errorf("%w: %s is not a valid endpoint", ErrRoamedEndpointRejected, endpoint)
+	case addr.IsUnspecified() || addr.IsLoopback() || addr.IsMulticast() || addr.IsLinkLocalMulticast():
+		return fmt.Errorf("%w: %s is not a unicast address", ErrRoamedEndpointRejected, addr)
+	}
+	for _, network := range meshNetworks {
+		if network.IsValid() && network.Contains(addr) {
+			return fmt.Errorf("%w: %s is inside the mesh network %s", ErrRoamedEndpointRejected, addr, network)
+		}
+	}
+	if len(o.AllowedPrefixes) > 0 && !slices.ContainsFunc(o.AllowedPrefixes, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+		return fmt.Errorf("%w: %s is not within an allowed prefix", ErrRoamedEndpointRejected, addr)
+	}
+	return nil
+}
+
+// DetectRoamedEndpoints returns the peers on the device whose live endpoint
+// is not one of the WireGuard endpoints stored for the peer. Peers unknown
+// to the interface or without a live endpoint are skipped. The returned
+// endpoints are not validated.
+func DetectRoamedEndpoints(device *wireguard.DeviceConfig, nodes map[types.NodeID]types.MeshNode) []RoamedEndpoint {
+	out := make([]RoamedEndpoint, 0)
+	for _, peer := range device.Peers {
+		if peer.ID == "" || peer.Endpoint == "" {
+			continue
+		}
+		node, ok := nodes[types.NodeID(peer.ID)]
+		if !ok {
+			continue
+		}
+		live, err := netip.ParseAddrPort(peer.Endpoint)
+		if err != nil {
+			continue
+		}
+		live = netip.AddrPortFrom(live.Addr().Unmap(), live.Port())
+		if slices.ContainsFunc(node.GetWireguardEndpoints(), func(ep string) bool {
+			stored, err := netip.ParseAddrPort(ep)
+			return err == nil && netip.AddrPortFrom(stored.Addr().Unmap(), stored.Port()) == live
+		}) {
+			continue
+		}
+		out = append(out, RoamedEndpoint{NodeID: node.NodeID(), Endpoint: live})
+	}
+	return out
+}
+
+// AdoptRoamedEndpoint stores the given roamed endpoint as the preferred
+// WireGuard endpoint of the peer, so that other nodes use it as well.
+// The endpoint is validated again with the given options before it is
+// stored.
+func AdoptRoamedEndpoint(ctx context.Context, st storage.MeshDB, opts RoamingOptions, roamed RoamedEndpoint) error {
+	state, err := st.MeshState().GetMeshState(ctx)
+	if err != nil {
+		return fmt.Errorf("get mesh state: %w", err)
+	}
+	if err := opts.Validate(roamed.Endpoint, state.NetworkV4(), state.NetworkV6()); err != nil {
+		return err
+	}
+	node, err := st.Peers().Get(ctx, roamed.NodeID)
+	if err != nil {
+		return fmt.Errorf("get peer %s: %w", roamed.NodeID, err)
+	}
+	endpoint := roamed.Endpoint.String()
+	node.PrimaryEndpoint = roamed.Endpoint.Addr().String()
+	node.WireguardEndpoints = append([]string{endpoint}, slices.DeleteFunc(node.WireguardEndpoints, func(ep string) bool {
+		return ep == endpoint
+	})...)
+	if err := st.Peers().Put(ctx, node); err != nil {
+		return fmt.Errorf("put peer %s: %w", roamed.NodeID, err)
+	}
+	return nil
+}
+
+func (m *manager) RoamedEndpoints(ctx context.Context) ([]RoamedEndpoint, error) {
+	wg := m.WireGuard()
+	if wg == nil {
+		return nil, errors.New("roamed endpoints requested before wireguard interface is ready")
+	}
+	device, err := wg.DumpConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dump wireguard config: %w", err)
+	}
+	nodes := make(map[types.NodeID]types.MeshNode, len(device.Peers))
+	for _, peer := range device.Peers {
+		if peer.ID == "" {
+			continue
+		}
+		node, err := m.storage.Peers().Get(ctx, types.NodeID(peer.ID))
+		if err != nil {
+			if storerrors.IsNodeNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get peer %s: %w", peer.ID, err)
+		}
+		nodes[node.NodeID()] = node
+	}
+	roamed := DetectRoamedEndpoints(device, nodes)
+	for i, rt := range roamed {
+		if !m.opts.Roaming.Enabled {
+			roamed[i].Reason = "roaming is disabled"
+			continue
+		}
+		if err := m.opts.Roaming.Validate(rt.Endpoint, m.NetworkV4(), m.NetworkV6()); err != nil {
+			roamed[i].Reason = err.Error()
+			continue
+		}
+		roamed[i].Accepted = true
+	}
+	return roamed, nil
+}
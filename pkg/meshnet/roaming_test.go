@@ -0,0 +1,164 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// roamingWireGuard reports the given live endpoints for its peers.
+type roamingWireGuard struct {
+	*fakeWireGuard
+	live map[string]string
+}
+
+func (r *roamingWireGuard) DumpConfig(context.Context) (*wireguard.DeviceConfig, error) {
+	cfg := &wireguard.DeviceConfig{Name: "webmesh0"}
+	for id, endpoint := range r.live {
+		cfg.Peers = append(cfg.Peers, wireguard.PeerConfig{ID: id, PublicKey: id, Endpoint: endpoint})
+	}
+	return cfg, nil
+}
+
+func TestRoamedEndpoints(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	for _, id := range []string{"roamed", "outside", "steady"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:                 id,
+			PublicKey:          mustGeneratePublicKey(t),
+			PrimaryEndpoint:    "198.51.100.1",
+			WireguardEndpoints: []string{"198.51.100.1:51820"},
+		}})
+		if err != nil {
+			t.Fatalf("put node %q: %v", id, err)
+		}
+	}
+	roaming := RoamingOptions{
+		Enabled:         true,
+		AllowedPrefixes: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+	}
+	m := New(db, Options{Roaming: roaming}, "node-a").(*manager)
+	m.networkv4 = netip.MustParsePrefix("172.16.0.0/12")
+	m.networkv6 = netip.MustParsePrefix("2001:db8::/64")
+	m.wg = &roamingWireGuard{
+		fakeWireGuard: &fakeWireGuard{peers: make(map[string]wireguard.Peer)},
+		live: map[string]string{
+			"roamed":  "203.0.113.7:40000",
+			"outside": "192.0.2.9:51820",
+			"steady":  "198.51.100.1:51820",
+			"unknown": "203.0.113.8:51820",
+		},
+	}
+
+	roamed, err := m.RoamedEndpoints(ctx)
+	if err != nil {
+		t.Fatalf("roamed endpoints: %v", err)
+	}
+	slices.SortFunc(roamed, func(a, b RoamedEndpoint) int {
+		return strings.Compare(a.NodeID.String(), b.NodeID.String())
+	})
+	if len(roamed) != 2 {
+		t.Fatalf("expected 2 roamed endpoints, got %v", roamed)
+	}
+	outside, accepted := roamed[0], roamed[1]
+	if accepted.NodeID != "roamed" || !accepted.Accepted || accepted.Endpoint != netip.MustParseAddrPort("203.0.113.7:40000") {
+		t.Errorf("expected roamed endpoint of roamed to be accepted, got %+v", accepted)
+	}
+	if outside.NodeID != "outside" || outside.Accepted || outside.Reason == "" {
+		t.Errorf("expected roamed endpoint outside the allowed prefixes to be rejected, got %+v", outside)
+	}
+
+	// Only the accepted endpoint is stored.
+	if err := AdoptRoamedEndpoint(ctx, db, roaming, accepted); err != nil {
+		t.Fatalf("adopt roamed endpoint: %v", err)
+	}
+	if err := AdoptRoamedEndpoint(ctx, db, roaming, outside); !errors.Is(err, ErrRoamedEndpointRejected) {
+		t.Errorf("expected rejected endpoint to not be adopted, got %v", err)
+	}
+	node, err := db.Peers().Get(ctx, "roamed")
+	if err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if node.PrimaryEndpoint != "203.0.113.7" || !slices.Equal(node.WireguardEndpoints, []string{"203.0.113.7:40000", "198.51.100.1:51820"}) {
+		t.Errorf("expected roamed endpoint to be preferred, got %q and %v", node.PrimaryEndpoint, node.WireguardEndpoints)
+	}
+	node, err = db.Peers().Get(ctx, "outside")
+	if err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if !slices.Equal(node.WireguardEndpoints, []string{"198.51.100.1:51820"}) {
+		t.Errorf("expected rejected endpoint to not be stored, got %v", node.WireguardEndpoints)
+	}
+
+	// The stored endpoint now matches the live one.
+	roamed, err = m.RoamedEndpoints(ctx)
+	if err != nil {
+		t.Fatalf("roamed endpoints: %v", err)
+	}
+	if slices.ContainsFunc(roamed, func(rt RoamedEndpoint) bool { return rt.NodeID == "roamed" }) {
+		t.Errorf("expected adopted endpoint to no longer be reported as roamed, got %v", roamed)
+	}
+}
+
+func TestRoamingOptionsValidate(t *testing.T) {
+	t.Parallel()
+	mesh := netip.MustParsePrefix("172.16.0.0/12")
+	tc := []struct {
+		endpoint string
+		opts     RoamingOptions
+		ok       bool
+	}{
+		{"203.0.113.7:51820", RoamingOptions{}, true},
+		{"[2001:db8:1::1]:51820", RoamingOptions{}, true},
+		{"172.16.0.5:51820", RoamingOptions{}, false},
+		{"127.0.0.1:51820", RoamingOptions{}, false},
+		{"0.0.0.0:51820", RoamingOptions{}, false},
+		{"203.0.113.7:0", RoamingOptions{}, false},
+		{"203.0.113.7:51820", RoamingOptions{AllowedPrefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}, false},
+		{"[::ffff:192.0.2.4]:51820", RoamingOptions{AllowedPrefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}, true},
+	}
+	for _, tt := range tc {
+		err := tt.opts.Validate(netip.MustParseAddrPort(tt.endpoint), mesh)
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(%s) with %+v: expected ok=%v, got %v", tt.endpoint, tt.opts, tt.ok, err)
+		}
+	}
+}
@@ -19,13 +19,19 @@ package firewall
 
 import (
 	"context"
+	"errors"
 	"net/netip"
 )
 
+// ErrBackendNotSupported is returned when the requested firewall backend
+// is not supported on the current system.
+var ErrBackendNotSupported = errors.New("firewall backend not supported")
+
 // Firewall is an interface for interacting with the necessary system firewall rules on a router.
 type Firewall interface {
-	// AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
-	AddWireguardForwarding(ctx context.Context, ifaceName string) error
+	// AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface
+	// for the given address families.
+	AddWireguardForwarding(ctx context.Context, ifaceName string, family Family) error
 	// AddMasquerade should configure the firewall to masquerade outbound traffic on the wireguard interface.
 	AddMasquerade(ctx context.Context, ifaceName string) error
 	// Clear should clear any changes made to the firewall.
@@ -44,6 +50,60 @@ const (
 	PolicyDrop Policy = "drop"
 )
 
+// Backend is a firewall backend implementation.
+type Backend string
+
+const (
+	// BackendAuto selects the best available backend for the system.
+	// On Linux this prefers nftables and falls back to iptables.
+	BackendAuto Backend = ""
+	// BackendNFTables uses nftables. This is only supported on Linux.
+	BackendNFTables Backend = "nftables"
+	// BackendIPTables uses iptables. This is only supported on Linux.
+	BackendIPTables Backend = "iptables"
+)
+
+// IsValid returns true if the backend is a known backend.
+func (b Backend) IsValid() bool {
+	switch b {
+	case BackendAuto, BackendNFTables, BackendIPTables:
+		return true
+	default:
+		return false
+	}
+}
+
+// Family is a set of IP address families that a firewall rule applies to.
+type Family uint8
+
+const (
+	// FamilyIPv4 is the IPv4 address family.
+	FamilyIPv4 Family = 1 << iota
+	// FamilyIPv6 is the IPv6 address family.
+	FamilyIPv6
+	// FamilyAll is both the IPv4 and IPv6 address families.
+	FamilyAll = FamilyIPv4 | FamilyIPv6
+)
+
+// Has returns true if the family includes the given family.
+func (f Family) Has(other Family) bool {
+	return f&other == other
+}
+
+// String returns a string representation of the family.
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	case FamilyAll:
+		return "all"
+	default:
+		return "none"
+	}
+}
+
 // Options are options for configuring a firewall.
 type Options struct {
 	// ID is used to uniquely identify the firewall. It can be empty,
@@ -53,6 +113,8 @@ type Options struct {
 	// NetNs is the network namespace to use for the firewall.
 	// This is only applicable on Linux.
 	NetNs string
+	// Backend is the firewall backend to use. Defaults to BackendAuto.
+	Backend Backend
 	// DefaultPolicy is the default policy for the firewall.
 	DefaultPolicy Policy
 	// WireguardPort is the port to allow for wireguard traffic.
@@ -61,10 +123,33 @@ type Options struct {
 	StoragePort uint16
 	// GRPCPort is the port to allow for grpc traffic.
 	GRPCPort uint16
+	// InterfaceName is the name of the wireguard interface. It is required
+	// when RestrictControlPlane is set.
+	InterfaceName string
+	// RestrictControlPlane restricts the storage and grpc ports to traffic
+	// arriving over the wireguard interface. Traffic to these ports from
+	// any other interface is dropped. Note that this prevents nodes from
+	// joining through this node over a public address. This is currently
+	// only supported on Linux.
+	RestrictControlPlane bool
+}
+
+// ControlPlanePorts returns the non-zero control plane ports.
+func (o *Options) ControlPlanePorts() []uint16 {
+	var ports []uint16
+	for _, port := range []uint16{o.GRPCPort, o.StoragePort} {
+		if port > 0 {
+			ports = append(ports, port)
+		}
+	}
+	return ports
 }
 
 // New returns a new firewall manager for the given options.
 func New(ctx context.Context, opts *Options) (Firewall, error) {
+	if opts.RestrictControlPlane && opts.InterfaceName == "" {
+		return nil, errors.New("interface name is required to restrict the control plane")
+	}
 	return newFirewall(ctx, opts)
 }
 
@@ -28,6 +28,12 @@ import (
 const anchorFile = "/etc/pf.anchors/com.webmesh"
 
 func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
+	if opts.Backend != BackendAuto {
+		return nil, fmt.Errorf("%w: %s", ErrBackendNotSupported, opts.Backend)
+	}
+	if opts.RestrictControlPlane {
+		return nil, fmt.Errorf("restricting the control plane is not supported on this platform")
+	}
 	// Make sure we can touch the anchor file
 	afile := anchorFile
 	if opts.ID != "" {
@@ -60,15 +66,23 @@ type pfctlFirewall struct {
 }
 
 // AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
-func (pf *pfctlFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string) error {
+func (pf *pfctlFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string, family Family) error {
 	f, err := os.OpenFile(pf.anchorFile, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("open anchor file: %w", err)
 	}
 	defer f.Close()
-	_, err = f.WriteString(fmt.Sprintf("pass in on %s\n", ifaceName))
-	if err != nil {
-		return fmt.Errorf("write anchor file: %w", err)
+	for _, af := range []struct {
+		family Family
+		name   string
+	}{{FamilyIPv4, "inet"}, {FamilyIPv6, "inet6"}} {
+		if !family.Has(af.family) {
+			continue
+		}
+		_, err = f.WriteString(fmt.Sprintf("pass in %s on %s\n", af.name, ifaceName))
+		if err != nil {
+			return fmt.Errorf("write anchor file: %w", err)
+		}
 	}
 	// Reload pfctl
 	err = common.Exec(ctx, "pfctl", "-f", anchorFile)
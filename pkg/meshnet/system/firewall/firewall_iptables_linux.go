@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
@@ -29,51 +30,146 @@ import (
 // is technically not safe for use with multiple interfaces. The Close method may restore
 // rules from another interface. But documentation should push people to use nftables instead.
 // This is just a fallback.
-func newIPTablesFirewall(ctx context.Context, _ *Options) (Firewall, error) {
+func newIPTablesFirewall(ctx context.Context, opts *Options) (Firewall, error) {
 	fw := &iptablesFirewall{
-		log: context.LoggerFrom(ctx).With(slog.String("component", "iptables-firewall")),
+		log:          context.LoggerFrom(ctx).With(slog.String("component", "iptables-firewall")),
+		comment:      iptablesComment(opts.ID),
+		initialRules: make(map[string][]string),
 	}
-	var initialRules []string
-	rules, err := fw.execOutput(context.Background(), "-S")
-	if err != nil {
-		return nil, fmt.Errorf("iptables -S: %v", err)
+	for _, cmd := range []string{iptablesCmd, ip6tablesCmd} {
+		rules, err := fw.execOutput(context.Background(), cmd, "-S")
+		if err != nil {
+			if cmd == ip6tablesCmd {
+				// IPv6 is optional, we'll warn if forwarding is requested for it.
+				fw.log.Debug("ip6tables is not available", slog.String("error", err.Error()))
+				continue
+			}
+			return nil, fmt.Errorf("%s -S: %v", cmd, err)
+		}
+		fw.initialRules[cmd] = strings.Split(string(rules), "\n")
+	}
+	for _, rule := range fw.controlPlaneRules(opts) {
+		if _, ok := fw.initialRules[rule.cmd]; !ok {
+			continue
+		}
+		if err := fw.exec(ctx, rule.cmd, rule.args...); err != nil {
+			return nil, fmt.Errorf("restrict control plane: %w", err)
+		}
 	}
-	initialRules = append(initialRules, strings.Split(string(rules), "\n")...)
-	fw.initialRules = initialRules
 	return fw, nil
 }
 
+const (
+	iptablesCmd  = "iptables"
+	ip6tablesCmd = "ip6tables"
+)
+
+// iptablesComment returns the comment used to tag rules created for the
+// firewall with the given ID.
+func iptablesComment(id string) string {
+	if id == "" {
+		return "webmesh"
+	}
+	return fmt.Sprintf("webmesh_%s", id)
+}
+
 type iptablesFirewall struct {
-	log          *slog.Logger
-	initialRules []string
+	log *slog.Logger
+	// comment is used to tag the rules we create.
+	comment string
+	// initialRules are the rules present at startup keyed by the
+	// command that manages them.
+	initialRules map[string][]string
+}
+
+// iptablesRule is a rule to be applied with the given command.
+type iptablesRule struct {
+	cmd  string
+	args []string
+}
+
+// forwardingRules returns the rules for allowing forwarding traffic on the
+// given interface for the given address families.
+func (fw *iptablesFirewall) forwardingRules(ifaceName string, family Family) []iptablesRule {
+	var rules []iptablesRule
+	for _, af := range []struct {
+		family Family
+		cmd    string
+	}{{FamilyIPv4, iptablesCmd}, {FamilyIPv6, ip6tablesCmd}} {
+		if !family.Has(af.family) {
+			continue
+		}
+		rules = append(rules, iptablesRule{
+			cmd:  af.cmd,
+			args: fw.withComment("-A", "FORWARD", "-i", ifaceName, "-j", "ACCEPT"),
+		})
+	}
+	return rules
+}
+
+// controlPlaneRules returns the rules for dropping traffic to the control plane
+// ports that does not arrive over the wireguard interface.
+func (fw *iptablesFirewall) controlPlaneRules(opts *Options) []iptablesRule {
+	if !opts.RestrictControlPlane {
+		return nil
+	}
+	var rules []iptablesRule
+	for _, cmd := range []string{iptablesCmd, ip6tablesCmd} {
+		for _, port := range opts.ControlPlanePorts() {
+			rules = append(rules, iptablesRule{
+				cmd: cmd,
+				args: fw.withComment(
+					"-A", "INPUT", "-p", "tcp", "--dport", strconv.Itoa(int(port)),
+					"!", "-i", opts.InterfaceName, "-j", "DROP",
+				),
+			})
+		}
+	}
+	return rules
 }
 
 // AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
-func (fw *iptablesFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string) error {
-	return fw.exec(ctx, "-A", "FORWARD", "-i", ifaceName, "-j", "ACCEPT")
+func (fw *iptablesFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string, family Family) error {
+	for _, rule := range fw.forwardingRules(ifaceName, family) {
+		if _, ok := fw.initialRules[rule.cmd]; !ok {
+			fw.log.Warn("Cannot add forwarding rule, traffic may be dropped", slog.String("command", rule.cmd))
+			continue
+		}
+		if err := fw.exec(ctx, rule.cmd, rule.args...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // AddMasquerade should configure the firewall to masquerade outbound traffic on the wireguard interface.
 func (fw *iptablesFirewall) AddMasquerade(ctx context.Context, ifaceName string) error {
-	return fw.exec(ctx, "-t", "nat", "-A", "POSTROUTING", "-o", ifaceName, "-j", "MASQUERADE")
+	return fw.exec(ctx, iptablesCmd, fw.withComment("-t", "nat", "-A", "POSTROUTING", "-o", ifaceName, "-j", "MASQUERADE")...)
+}
+
+// withComment appends the comment match tagging the rule as ours.
+func (fw *iptablesFirewall) withComment(args ...string) []string {
+	return append(args, "-m", "comment", "--comment", fw.comment)
 }
 
 // Clear should clear any changes made to the firewall.
 func (fw *iptablesFirewall) Clear(ctx context.Context) error {
-	err := fw.exec(ctx, "-F")
-	if err != nil {
-		return err
-	}
-	// Restore initial rules
-	for _, rule := range fw.initialRules {
-		if strings.HasPrefix(rule, "#") {
-			// Comment, skip
-			continue
-		}
-		err = fw.exec(ctx, strings.Fields(rule)...)
+	for cmd, initialRules := range fw.initialRules {
+		err := fw.exec(ctx, cmd, "-F")
 		if err != nil {
 			return err
 		}
+		// Restore initial rules
+		for _, rule := range initialRules {
+			if strings.HasPrefix(rule, "#") {
+				// Comment, skip
+				continue
+			}
+			err = fw.exec(ctx, cmd, strings.Fields(rule)...)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -83,17 +179,17 @@ func (fw *iptablesFirewall) Close(ctx context.Context) error {
 	return fw.Clear(ctx)
 }
 
-func (fw *iptablesFirewall) exec(ctx context.Context, args ...string) error {
-	cmd := exec.CommandContext(ctx, "iptables", args...)
-	fw.log.Debug("iptables", slog.String("args", strings.Join(args, " ")))
+func (fw *iptablesFirewall) exec(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	fw.log.Debug(name, slog.String("args", strings.Join(args, " ")))
 	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("iptables %v: %v: %s", args, err, out)
+		return fmt.Errorf("%s %v: %v: %s", name, args, err, out)
 	}
 	return nil
 }
 
-func (rw *iptablesFirewall) execOutput(ctx context.Context, args ...string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, "iptables", args...)
-	rw.log.Debug("iptables", slog.String("args", strings.Join(args, " ")))
+func (rw *iptablesFirewall) execOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	rw.log.Debug(name, slog.String("args", strings.Join(args, " ")))
 	return cmd.CombinedOutput()
 }
@@ -0,0 +1,54 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// Backend constructors, overridden in tests.
+var (
+	newNFTablesBackend = newNFTablesFirewall
+	newIPTablesBackend = newIPTablesFirewall
+)
+
+func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
+	log := context.LoggerFrom(ctx)
+	switch opts.Backend {
+	case BackendNFTables:
+		return newNFTablesBackend(ctx, opts)
+	case BackendIPTables:
+		return newIPTablesBackend(ctx, opts)
+	case BackendAuto:
+		fw, err := newNFTablesBackend(ctx, opts)
+		if err == nil {
+			log.Debug("Using nftables firewall backend")
+			return fw, nil
+		}
+		if !errors.Is(err, ErrBackendNotSupported) {
+			return nil, err
+		}
+		log.Info("nftables is not supported, falling back to iptables", slog.String("error", err.Error()))
+		return newIPTablesBackend(ctx, opts)
+	default:
+		return nil, fmt.Errorf("unknown firewall backend: %q", opts.Backend)
+	}
+}
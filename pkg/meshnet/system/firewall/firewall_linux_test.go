@@ -0,0 +1,257 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables/expr"
+	"github.com/sbezverk/nftableslib"
+	"golang.org/x/sys/unix"
+)
+
+func TestForwardingRules(t *testing.T) {
+	t.Parallel()
+	const ifaceName = "webmesh0"
+
+	tc := []struct {
+		name     string
+		family   Family
+		wantCmds []string
+		wantNFs  []byte
+	}{
+		{
+			name:     "DualStack",
+			family:   FamilyAll,
+			wantCmds: []string{iptablesCmd, ip6tablesCmd},
+			wantNFs:  []byte{unix.NFPROTO_IPV4, unix.NFPROTO_IPV6},
+		},
+		{
+			name:     "IPv4Only",
+			family:   FamilyIPv4,
+			wantCmds: []string{iptablesCmd},
+			wantNFs:  []byte{unix.NFPROTO_IPV4},
+		},
+		{
+			name:     "IPv6Only",
+			family:   FamilyIPv6,
+			wantCmds: []string{ip6tablesCmd},
+			wantNFs:  []byte{unix.NFPROTO_IPV6},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("IPTables", func(t *testing.T) {
+				fw := &iptablesFirewall{comment: iptablesComment("test")}
+				rules := fw.forwardingRules(ifaceName, tt.family)
+				var cmds []string
+				for _, rule := range rules {
+					cmds = append(cmds, rule.cmd)
+					if !slices.Contains(rule.args, ifaceName) {
+						t.Errorf("expected rule %v to match interface %q", rule.args, ifaceName)
+					}
+					if !slices.Contains(rule.args, "webmesh_test") {
+						t.Errorf("expected rule %v to be tagged", rule.args)
+					}
+				}
+				if !slices.Equal(cmds, tt.wantCmds) {
+					t.Errorf("expected commands %v, got %v", tt.wantCmds, cmds)
+				}
+			})
+
+			t.Run("NFTables", func(t *testing.T) {
+				rules, err := nftForwardingRules(ifaceName, tt.family)
+				if err != nil {
+					t.Fatalf("nftables forwarding rules: %v", err)
+				}
+				var nfprotos []byte
+				for _, rule := range rules {
+					var matchesIface bool
+					for _, meta := range rule.Meta.Expr {
+						switch expr.MetaKey(meta.Key) {
+						case expr.MetaKeyNFPROTO:
+							nfprotos = append(nfprotos, meta.Value...)
+						case expr.MetaKeyOIFNAME:
+							matchesIface = bytes.Equal(meta.Value, []byte(ifaceName))
+						}
+					}
+					if !matchesIface {
+						t.Errorf("expected rule to match interface %q", ifaceName)
+					}
+				}
+				if !bytes.Equal(nfprotos, tt.wantNFs) {
+					t.Errorf("expected protocols %v, got %v", tt.wantNFs, nfprotos)
+				}
+			})
+		})
+	}
+}
+
+// fakeFirewall is a firewall returned by mocked backends.
+type fakeFirewall struct {
+	Firewall
+	backend Backend
+}
+
+func TestBackendSelection(t *testing.T) {
+	// Not parallel, the backend constructors are package globals.
+	origNFTables, origIPTables := newNFTablesBackend, newIPTablesBackend
+	t.Cleanup(func() {
+		newNFTablesBackend, newIPTablesBackend = origNFTables, origIPTables
+	})
+	mockBackends := func(nftablesErr error) {
+		newNFTablesBackend = func(context.Context, *Options) (Firewall, error) {
+			if nftablesErr != nil {
+				return nil, nftablesErr
+			}
+			return &fakeFirewall{backend: BackendNFTables}, nil
+		}
+		newIPTablesBackend = func(context.Context, *Options) (Firewall, error) {
+			return &fakeFirewall{backend: BackendIPTables}, nil
+		}
+	}
+	unsupported := fmt.Errorf("%w: nftables: not supported", ErrBackendNotSupported)
+
+	tc := []struct {
+		name        string
+		backend     Backend
+		nftablesErr error
+		want        Backend
+		wantErr     bool
+	}{
+		{name: "AutoPrefersNFTables", backend: BackendAuto, want: BackendNFTables},
+		{name: "AutoFallsBackToIPTables", backend: BackendAuto, nftablesErr: unsupported, want: BackendIPTables},
+		{name: "AutoReturnsOtherErrors", backend: BackendAuto, nftablesErr: errors.New("permission denied"), wantErr: true},
+		{name: "ExplicitNFTables", backend: BackendNFTables, want: BackendNFTables},
+		{name: "ExplicitNFTablesUnsupported", backend: BackendNFTables, nftablesErr: unsupported, wantErr: true},
+		{name: "ExplicitIPTables", backend: BackendIPTables, want: BackendIPTables},
+		{name: "UnknownBackend", backend: "pf", wantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBackends(tt.nftablesErr)
+			fw, err := New(context.Background(), &Options{Backend: tt.backend})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("new firewall: %v", err)
+			}
+			fake, ok := fw.(*fakeFirewall)
+			if !ok {
+				t.Fatalf("expected mocked backend, got %T", fw)
+			}
+			if fake.backend != tt.want {
+				t.Errorf("expected backend %q, got %q", tt.want, fake.backend)
+			}
+		})
+	}
+}
+
+func TestRestrictControlPlane(t *testing.T) {
+	t.Parallel()
+	opts := &Options{
+		WireguardPort:        51820,
+		GRPCPort:             8443,
+		StoragePort:          9000,
+		InterfaceName:        "webmesh0",
+		RestrictControlPlane: true,
+	}
+
+	t.Run("NFTables", func(t *testing.T) {
+		t.Parallel()
+		drop, err := nftableslib.SetVerdict(nftableslib.NFT_DROP)
+		if err != nil {
+			t.Fatalf("drop verdict: %v", err)
+		}
+		rules, err := nftInputRules(opts)
+		if err != nil {
+			t.Fatalf("nftables input rules: %v", err)
+		}
+		var dropped []int
+		for _, rule := range rules {
+			if rule.rule.L4 == nil || rule.rule.L4.L4Proto != unix.IPPROTO_TCP {
+				continue
+			}
+			if rule.rule.Meta == nil || len(rule.rule.Meta.Expr) != 1 {
+				t.Fatalf("expected %q to match the interface", rule.comment)
+			}
+			meta := rule.rule.Meta.Expr[0]
+			if expr.MetaKey(meta.Key) != expr.MetaKeyIIFNAME || !bytes.Equal(meta.Value, []byte(opts.InterfaceName)) {
+				t.Errorf("expected %q to match interface %q", rule.comment, opts.InterfaceName)
+			}
+			if meta.RelOp == nftableslib.NEQ {
+				// Traffic from any other interface must be dropped.
+				if !reflect.DeepEqual(rule.rule.Action, drop) {
+					t.Errorf("expected %q to drop traffic", rule.comment)
+				}
+				for _, port := range rule.rule.L4.Dst.List {
+					dropped = append(dropped, int(*port))
+				}
+			}
+		}
+		slices.Sort(dropped)
+		if !slices.Equal(dropped, []int{8443, 9000}) {
+			t.Errorf("expected control plane ports to be dropped from outside the mesh, got %v", dropped)
+		}
+
+		// Without the restriction, nothing should be dropped by port.
+		unrestricted := *opts
+		unrestricted.RestrictControlPlane = false
+		rules, err = nftInputRules(&unrestricted)
+		if err != nil {
+			t.Fatalf("nftables input rules: %v", err)
+		}
+		for _, rule := range rules {
+			if rule.rule.L4 != nil && rule.rule.Meta != nil {
+				t.Errorf("expected %q to not be restricted", rule.comment)
+			}
+		}
+	})
+
+	t.Run("IPTables", func(t *testing.T) {
+		t.Parallel()
+		fw := &iptablesFirewall{comment: iptablesComment("")}
+		rules := fw.controlPlaneRules(opts)
+		// One rule per port per address family.
+		if len(rules) != 4 {
+			t.Fatalf("expected 4 rules, got %d", len(rules))
+		}
+		for _, rule := range rules {
+			args := strings.Join(rule.args, " ")
+			if !strings.Contains(args, "! -i webmesh0 -j DROP") {
+				t.Errorf("expected rule to drop traffic from outside the mesh, got %q", args)
+			}
+		}
+		if rules := fw.controlPlaneRules(&Options{GRPCPort: 8443}); len(rules) != 0 {
+			t.Errorf("expected no rules when unrestricted, got %v", rules)
+		}
+	})
+}
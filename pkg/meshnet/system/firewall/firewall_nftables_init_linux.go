@@ -194,23 +194,41 @@ func (fw *firewall) initChains() error {
 }
 
 func (fw *firewall) initInputChain() error {
+	rules, err := nftInputRules(fw.opts)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		rule.rule.UserData = nftableslib.MakeRuleComment(rule.comment)
+		_, err = fw.input.Rules().InsertImm(rule.rule)
+		if err != nil {
+			return fmt.Errorf("failed to add %s rule to input chain: %w", rule.comment, err)
+		}
+	}
+	return fw.conn.Flush()
+}
+
+// nftRule is a rule with a comment describing it.
+type nftRule struct {
+	comment string
+	rule    *nftableslib.Rule
+}
+
+// nftInputRules returns the rules for the input chain.
+func nftInputRules(opts *Options) ([]nftRule, error) {
 	accept, err := nftableslib.SetVerdict(nftableslib.NFT_ACCEPT)
 	if err != nil {
-		return fmt.Errorf("failed to create accept verdict: %w", err)
+		return nil, fmt.Errorf("failed to create accept verdict: %w", err)
 	}
 	drop, err := nftableslib.SetVerdict(nftableslib.NFT_DROP)
 	if err != nil {
-		return fmt.Errorf("failed to create drop verdict: %w", err)
+		return nil, fmt.Errorf("failed to create drop verdict: %w", err)
 	}
 	var ctInvalid [4]byte
 	binary.BigEndian.PutUint32(ctInvalid[:], uint32(nftableslib.CTStateInvalid))
 	var ctEstablishedRelated [4]byte
 	binary.BigEndian.PutUint32(ctEstablishedRelated[:], uint32(nftableslib.CTStateEstablished|nftableslib.CTStateRelated))
-	rules := []struct {
-		comment string
-		cmd     string
-		rule    *nftableslib.Rule
-	}{
+	rules := []nftRule{
 		{
 			comment: "early drop of invalid connections",
 			rule: &nftableslib.Rule{
@@ -283,55 +301,77 @@ func (fw *firewall) initInputChain() error {
 				L4: &nftableslib.L4Rule{
 					L4Proto: unix.IPPROTO_UDP,
 					Dst: &nftableslib.Port{
-						List: nftableslib.SetPortList([]int{int(fw.opts.WireguardPort)}),
+						List: nftableslib.SetPortList([]int{int(opts.WireguardPort)}),
 					},
 				},
 				Action: accept,
 			},
 		},
 	}
-	if fw.opts.GRPCPort > 0 {
-		rules = append(rules, struct {
-			comment string
-			cmd     string
-			rule    *nftableslib.Rule
-		}{
-			comment: "allow webmesh rpc",
+	// When restricting the control plane, only accept traffic to the control
+	// plane ports that arrives over the wireguard interface.
+	var meshOnly *nftableslib.Meta
+	ifaceName := opts.InterfaceName
+	if len(ifaceName) > 15 {
+		ifaceName = ifaceName[:15]
+	}
+	if opts.RestrictControlPlane {
+		meshOnly = &nftableslib.Meta{
+			Expr: []nftableslib.MetaExpr{
+				{
+					Key:   uint32(expr.MetaKeyIIFNAME),
+					Value: []byte(ifaceName),
+				},
+			},
+		}
+	}
+	for _, port := range []struct {
+		comment string
+		port    uint16
+	}{{"allow webmesh rpc", opts.GRPCPort}, {"allow storage", opts.StoragePort}} {
+		if port.port == 0 {
+			continue
+		}
+		rules = append(rules, nftRule{
+			comment: port.comment,
 			rule: &nftableslib.Rule{
 				L4: &nftableslib.L4Rule{
 					L4Proto: unix.IPPROTO_TCP,
 					Dst: &nftableslib.Port{
-						List: nftableslib.SetPortList([]int{int(fw.opts.GRPCPort)}),
+						List: nftableslib.SetPortList([]int{int(port.port)}),
 					},
 				},
+				Meta:   meshOnly,
 				Action: accept,
 			},
 		})
 	}
-	if fw.opts.StoragePort > 0 {
-		rules = append(rules, struct {
-			comment string
-			cmd     string
-			rule    *nftableslib.Rule
-		}{
-			comment: "allow storage",
+	if ports := opts.ControlPlanePorts(); opts.RestrictControlPlane && len(ports) > 0 {
+		portList := make([]int, len(ports))
+		for i, port := range ports {
+			portList[i] = int(port)
+		}
+		rules = append(rules, nftRule{
+			comment: "drop control plane traffic from outside the mesh",
 			rule: &nftableslib.Rule{
 				L4: &nftableslib.L4Rule{
 					L4Proto: unix.IPPROTO_TCP,
 					Dst: &nftableslib.Port{
-						List: nftableslib.SetPortList([]int{int(fw.opts.StoragePort)}),
+						List: nftableslib.SetPortList(portList),
 					},
 				},
-				Action: accept,
+				Meta: &nftableslib.Meta{
+					Expr: []nftableslib.MetaExpr{
+						{
+							Key:   uint32(expr.MetaKeyIIFNAME),
+							Value: []byte(ifaceName),
+							RelOp: nftableslib.NEQ,
+						},
+					},
+				},
+				Action: drop,
 			},
 		})
 	}
-	for _, rule := range rules {
-		rule.rule.UserData = nftableslib.MakeRuleComment(rule.comment)
-		_, err = fw.input.Rules().InsertImm(rule.rule)
-		if err != nil {
-			return fmt.Errorf("failed to add %s rule to input chain: %w", rule.comment, err)
-		}
-	}
-	return fw.conn.Flush()
+	return rules, nil
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
 	"github.com/sbezverk/nftableslib"
+	"golang.org/x/sys/unix"
 )
 
 // firewall is a firewall manager that uses nftables.
@@ -48,8 +49,9 @@ type firewall struct {
 	rawprerouting nftableslib.RulesInterface
 }
 
-// newFirewall returns a new nftables firewall manager.
-func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
+// newNFTablesFirewall returns a new nftables firewall manager. If nftables is not
+// supported by the system, an error wrapping ErrBackendNotSupported is returned.
+func newNFTablesFirewall(ctx context.Context, opts *Options) (Firewall, error) {
 	fw := &firewall{opts: opts}
 	// Initialize a long lasting connection to the nftables library
 	var netns []int
@@ -66,8 +68,7 @@ func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
 	err := fw.initialize(opts)
 	if err != nil {
 		if strings.Contains(err.Error(), "not supported") || strings.Contains(err.Error(), "no such file") {
-			// Try to fallback to iptables
-			return newIPTablesFirewall(ctx, opts)
+			return nil, fmt.Errorf("%w: nftables: %v", ErrBackendNotSupported, err)
 		}
 		return nil, err
 	}
@@ -75,30 +76,57 @@ func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
 }
 
 // AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
-func (fw *firewall) AddWireguardForwarding(ctx context.Context, ifaceName string) error {
+func (fw *firewall) AddWireguardForwarding(ctx context.Context, ifaceName string, family Family) error {
+	rules, err := nftForwardingRules(ifaceName, family)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		_, err = fw.forward.Rules().InsertImm(rule)
+		if err != nil {
+			return fmt.Errorf("failed to create wireguard forwarding rule: %w", err)
+		}
+	}
+	return fw.conn.Flush()
+}
+
+// nftForwardingRules returns the rules for allowing forwarding traffic on the given
+// interface. The tables are in the inet family, so an explicit rule matching the
+// protocol is created for each requested address family.
+func nftForwardingRules(ifaceName string, family Family) ([]*nftableslib.Rule, error) {
 	if len(ifaceName) > 15 {
 		ifaceName = ifaceName[:15]
 	}
 	accept, err := nftableslib.SetVerdict(nftableslib.NFT_ACCEPT)
 	if err != nil {
-		return fmt.Errorf("failed to create accept verdict: %w", err)
+		return nil, fmt.Errorf("failed to create accept verdict: %w", err)
 	}
-	_, err = fw.forward.Rules().InsertImm(&nftableslib.Rule{
-		Meta: &nftableslib.Meta{
-			Expr: []nftableslib.MetaExpr{
-				{
-					Key:   uint32(expr.MetaKeyOIFNAME),
-					Value: []byte(ifaceName),
+	var rules []*nftableslib.Rule
+	for _, af := range []struct {
+		family  Family
+		nfproto byte
+	}{{FamilyIPv4, unix.NFPROTO_IPV4}, {FamilyIPv6, unix.NFPROTO_IPV6}} {
+		if !family.Has(af.family) {
+			continue
+		}
+		rules = append(rules, &nftableslib.Rule{
+			Meta: &nftableslib.Meta{
+				Expr: []nftableslib.MetaExpr{
+					{
+						Key:   uint32(expr.MetaKeyNFPROTO),
+						Value: []byte{af.nfproto},
+					},
+					{
+						Key:   uint32(expr.MetaKeyOIFNAME),
+						Value: []byte(ifaceName),
+					},
 				},
 			},
-		},
-		Action:   accept,
-		UserData: nftableslib.MakeRuleComment("Allow forwarding traffic on the wireguard interface"),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create wireguard forwarding rule: %w", err)
+			Action:   accept,
+			UserData: nftableslib.MakeRuleComment(fmt.Sprintf("Allow forwarding %s traffic on the wireguard interface", af.family)),
+		})
 	}
-	return fw.conn.Flush()
+	return rules, nil
 }
 
 // AddMasquerade should configure the firewall to masquerade outbound traffic on the wireguard interface.
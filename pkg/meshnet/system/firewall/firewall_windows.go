@@ -25,6 +25,12 @@ import (
 )
 
 func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
+	if opts.Backend != BackendAuto {
+		return nil, fmt.Errorf("%w: %s", ErrBackendNotSupported, opts.Backend)
+	}
+	if opts.RestrictControlPlane {
+		return nil, fmt.Errorf("restricting the control plane is not supported on this platform")
+	}
 	return &winFirewall{}, nil
 }
 
@@ -32,7 +38,7 @@ type winFirewall struct {
 }
 
 // AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
-func (wf *winFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string) error {
+func (wf *winFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string, family Family) error {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		return err
@@ -46,6 +52,13 @@ func (wf *winFirewall) AddWireguardForwarding(ctx context.Context, ifaceName str
 		if !ok {
 			continue
 		}
+		addrFamily := FamilyIPv6
+		if addr.IP.To4() != nil {
+			addrFamily = FamilyIPv4
+		}
+		if !family.Has(addrFamily) {
+			continue
+		}
 		err = common.Exec(ctx, "netsh", "advfirewall", "firewall", "add", "rule",
 			`name="webmesh-forward-inbound"`, "dir=in", "action=allow",
 			fmt.Sprintf("localip=%s", addr.IP.String()),
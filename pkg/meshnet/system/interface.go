@@ -77,6 +77,9 @@ type Options struct {
 	AddressV6 netip.Prefix
 	// ForceTUN forces the use of a TUN interface.
 	ForceTUN bool
+	// NoFallback disables falling back to a TUN interface when the
+	// kernel interface cannot be created.
+	NoFallback bool
 	// MTU is the MTU of the interface. If unset, it will be automatically
 	// detected from the host.
 	MTU uint32
@@ -84,6 +87,13 @@ type Options struct {
 	DisableIPv4 bool
 	// DisableIPv6 disables IPv6 on the interface.
 	DisableIPv6 bool
+	// RoutingTable is the routing table to install routes into. When set,
+	// policy rules are added to direct traffic through the table. If unset,
+	// routes are added to the main table. This is only supported on Linux.
+	RoutingTable int
+	// FwMark is the firewall mark set on packets sent by the interface.
+	// Marked packets are excluded from the policy rules for RoutingTable.
+	FwMark uint32
 }
 
 // IsRouteExists returns true if the given error is a route exists error.
@@ -109,6 +119,8 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 		addrv4: opts.AddressV4,
 		addrv6: opts.AddressV6,
 		netns:  opts.NetNs,
+		table:  opts.RoutingTable,
+		fwmark: opts.FwMark,
 	}
 	forceTUN := opts.ForceTUN || (runtime.GOOS != "linux" && runtime.GOOS != "freebsd")
 	mtu := opts.MTU
@@ -126,7 +138,9 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 	} else {
 		log.Debug("Creating wireguard kernel interface")
 		err := link.NewKernel(ctx, iface.ifname, mtu)
-		if err != nil {
+		if err != nil && opts.NoFallback {
+			return nil, fmt.Errorf("new kernel interface: %w", err)
+		} else if err != nil {
 			log.Error("Failed to create kernel interface failed, falling back to TUN driver", "error", err)
 			// Try the TUN device as a fallback
 			name, closer, err := link.NewTUN(ctx, iface.ifname, mtu)
@@ -171,6 +185,19 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 			return nil, fmt.Errorf("set IPv6 address: %w", err)
 		}
 	}
+	if opts.RoutingTable != 0 {
+		log.Debug("Adding policy rules for routing table", "table", opts.RoutingTable, "fwmark", opts.FwMark)
+		err := iface.doInNetNS(func() error {
+			return routes.AddPolicyRule(ctx, opts.FwMark, opts.RoutingTable)
+		})
+		if err != nil {
+			derr := iface.close(ctx)
+			if derr != nil {
+				return nil, fmt.Errorf("%w, destroy interface: %v", err, derr)
+			}
+			return nil, fmt.Errorf("add policy rule: %w", err)
+		}
+	}
 	return iface, nil
 }
 
@@ -179,9 +206,18 @@ type sysInterface struct {
 	addrv4 netip.Prefix
 	addrv6 netip.Prefix
 	netns  string
+	table  int
+	fwmark uint32
 	close  func(context.Context) error
 }
 
+func (l *sysInterface) doInNetNS(fn func() error) error {
+	if runtime.GOOS == "linux" && l.netns != "" {
+		return DoInNetNS(l.netns, fn)
+	}
+	return fn()
+}
+
 func (l *sysInterface) setInterfaceAddress(ctx context.Context, addr netip.Prefix) error {
 	context.LoggerFrom(ctx).Debug("Setting interface address", "address", addr.String())
 	if runtime.GOOS == "linux" && l.netns != "" {
@@ -233,6 +269,14 @@ func (l *sysInterface) Down(ctx context.Context) error {
 
 // Destroy destroys the interface
 func (l *sysInterface) Destroy(ctx context.Context) error {
+	if l.table != 0 {
+		err := l.doInNetNS(func() error {
+			return routes.RemovePolicyRule(ctx, l.fwmark, l.table)
+		})
+		if err != nil {
+			context.LoggerFrom(ctx).Error("Failed to remove policy rules", "error", err.Error())
+		}
+	}
 	if runtime.GOOS == "linux" && l.netns != "" {
 		if err := moveLinkOut(l.netns, l.Name()); err != nil {
 			context.LoggerFrom(ctx).Error("Failed to move link out of network namespace", "error", err.Error())
@@ -265,20 +309,20 @@ func (l *sysInterface) RemoveAddress(ctx context.Context, addr netip.Prefix) err
 func (l *sysInterface) AddRoute(ctx context.Context, network netip.Prefix) error {
 	if runtime.GOOS == "linux" && l.netns != "" {
 		return DoInNetNS(l.netns, func() error {
-			return routes.Add(ctx, l.Name(), network)
+			return routes.AddToTable(ctx, l.Name(), network, l.table)
 		})
 	}
-	return routes.Add(ctx, l.Name(), network)
+	return routes.AddToTable(ctx, l.Name(), network, l.table)
 }
 
 // RemoveRoute removes the route for the given network.
 func (l *sysInterface) RemoveRoute(ctx context.Context, network netip.Prefix) error {
 	if runtime.GOOS == "linux" && l.netns != "" {
 		return DoInNetNS(l.netns, func() error {
-			return routes.Remove(ctx, l.Name(), network)
+			return routes.RemoveFromTable(ctx, l.Name(), network, l.table)
 		})
 	}
-	return routes.Remove(ctx, l.Name(), network)
+	return routes.RemoveFromTable(ctx, l.Name(), network, l.table)
 }
 
 // Link attempts to return the underling net.Interface.
@@ -16,9 +16,23 @@ limitations under the License.
 
 package link
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	// ErrLinkNotExists is returned when a link does not exist.
 	ErrLinkNotExists = errors.New("link does not exist")
+	// ErrWatchNotSupported is returned when watching links is not supported
+	// on the current platform.
+	ErrWatchNotSupported = errors.New("watching links is not supported on this platform")
 )
+
+// IsNotExist returns true if the error indicates that a link does not exist.
+func IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrLinkNotExists) || strings.Contains(err.Error(), "no such network interface")
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// SubscribeRemoved returns a channel that receives a value whenever the
+// link with the given name is removed. The channel is closed when the
+// context is canceled or the subscription fails.
+func SubscribeRemoved(ctx context.Context, name string) (<-chan struct{}, error) {
+	updates := make(chan netlink.LinkUpdate)
+	err := netlink.LinkSubscribe(updates, ctx.Done())
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to link updates: %w", err)
+	}
+	removed := make(chan struct{}, 1)
+	go func() {
+		defer close(removed)
+		for update := range updates {
+			if update.Header.Type != unix.RTM_DELLINK || update.Link == nil || update.Link.Attrs().Name != name {
+				continue
+			}
+			select {
+			case removed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return removed, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// ErrForwardingNotSupported is returned when IP forwarding cannot be managed
+// on the current platform.
+var ErrForwardingNotSupported = errors.New("managing ip forwarding is not supported on this platform")
+
+// Sysctl reads and writes kernel parameters.
+type Sysctl interface {
+	// Get returns the value of the given parameter.
+	Get(name string) (string, error)
+	// Set sets the value of the given parameter.
+	Set(name, value string) error
+}
+
+// Forwarding enables the IP forwarding sysctls and remembers their previous
+// values so they can be restored.
+type Forwarding struct {
+	sysctl Sysctl
+	saved  map[string]string
+	order  []string
+	mu     sync.Mutex
+}
+
+// NewForwarding returns a new Forwarding using the given sysctl. Use
+// SystemSysctl for the sysctls of the current host.
+func NewForwarding(sysctl Sysctl) *Forwarding {
+	return &Forwarding{sysctl: sysctl, saved: make(map[string]string)}
+}
+
+// Enable enables forwarding for the given address families. Values that
+// were changed are restored by Restore.
+func (f *Forwarding) Enable(ipv4, ipv6 bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	if ipv4 {
+		names = append(names, ipv4ForwardingSysctl)
+	}
+	if ipv6 {
+		names = append(names, ipv6ForwardingSysctl)
+	}
+	for _, name := range names {
+		if name == "" {
+			return ErrForwardingNotSupported
+		}
+		current, err := f.sysctl.Get(name)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+		current = strings.TrimSpace(current)
+		if current == "1" {
+			continue
+		}
+		if err := f.sysctl.Set(name, "1"); err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				return fmt.Errorf("set %s: %w (enabling ip forwarding requires root or CAP_NET_ADMIN)", name, err)
+			}
+			return fmt.Errorf("set %s: %w", name, err)
+		}
+		if _, ok := f.saved[name]; !ok {
+			f.saved[name] = current
+			f.order = append(f.order, name)
+		}
+	}
+	return nil
+}
+
+// Restore sets every sysctl changed by Enable back to its previous value.
+func (f *Forwarding) Restore() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	var failed []string
+	for _, name := range f.order {
+		if err := f.sysctl.Set(name, f.saved[name]); err != nil {
+			// Keep failed restores so they can be retried.
+			errs = append(errs, fmt.Errorf("restore %s: %w", name, err))
+			failed = append(failed, name)
+			continue
+		}
+		delete(f.saved, name)
+	}
+	f.order = failed
+	return errors.Join(errs...)
+}
+
+// unsupportedSysctl is used on platforms without sysctls.
+type unsupportedSysctl struct{}
+
+func (unsupportedSysctl) Get(string) (string, error) { return "", ErrForwardingNotSupported }
+
+func (unsupportedSysctl) Set(string, string) error { return ErrForwardingNotSupported }
@@ -18,10 +18,36 @@ package routes
 
 import (
 	"context"
+	"strings"
 
 	"github.com/webmeshproj/webmesh/pkg/common"
 )
 
+const (
+	ipv4ForwardingSysctl = "net.inet.ip.forwarding"
+	ipv6ForwardingSysctl = "net.inet6.ip6.forwarding"
+)
+
+// SystemSysctl returns a Sysctl for the current host backed by the
+// sysctl command.
+func SystemSysctl() Sysctl {
+	return execSysctl{}
+}
+
+type execSysctl struct{}
+
+func (execSysctl) Get(name string) (string, error) {
+	out, err := common.ExecOutput(context.Background(), "sysctl", "-n", name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (execSysctl) Set(name, value string) error {
+	return common.Exec(context.Background(), "sysctl", "-w", name+"="+value)
+}
+
 // EnableIPForwarding enables IP forwarding.
 func EnableIPForwarding() error {
 	return common.Exec(context.Background(), "sysctl", "-w", "net.inet.ip.forwarding=1")
@@ -23,6 +23,28 @@ import (
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 )
 
+const (
+	ipv4ForwardingSysctl = "net/ipv4/ip_forward"
+	ipv6ForwardingSysctl = "net/ipv6/conf/all/forwarding"
+)
+
+// SystemSysctl returns a Sysctl for the current host. Names are
+// slash-separated paths under /proc/sys.
+func SystemSysctl() Sysctl {
+	return procSysctl{}
+}
+
+type procSysctl struct{}
+
+func (procSysctl) Get(name string) (string, error) {
+	return sysctl.Sysctl(name)
+}
+
+func (procSysctl) Set(name, value string) error {
+	_, err := sysctl.Sysctl(name, value)
+	return err
+}
+
 // EnableIPForwarding enables IP forwarding.
 func EnableIPForwarding() error {
 	errs := make([]error, 0, 3)
@@ -0,0 +1,53 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSystemForwarding(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("forwarding tests require root")
+	}
+	sysctl := SystemSysctl()
+	original, err := sysctl.Get(ipv4ForwardingSysctl)
+	if err != nil {
+		t.Fatalf("read ipv4 forwarding: %v", err)
+	}
+	// Start with forwarding disabled so there is something to restore.
+	if err := sysctl.Set(ipv4ForwardingSysctl, "0"); err != nil {
+		// Sandboxed environments may mount /proc/sys read-only.
+		t.Skip("cannot write sysctls:", err)
+	}
+	t.Cleanup(func() { _ = sysctl.Set(ipv4ForwardingSysctl, original) })
+
+	fwd := NewForwarding(sysctl)
+	if err := fwd.Enable(true, false); err != nil {
+		t.Fatalf("enable forwarding: %v", err)
+	}
+	if got, _ := sysctl.Get(ipv4ForwardingSysctl); got != "1" {
+		t.Errorf("expected ipv4 forwarding to be enabled, got %q", got)
+	}
+	if err := fwd.Restore(); err != nil {
+		t.Fatalf("restore forwarding: %v", err)
+	}
+	if got, _ := sysctl.Get(ipv4ForwardingSysctl); got != "0" {
+		t.Errorf("expected ipv4 forwarding to be restored, got %q", got)
+	}
+}
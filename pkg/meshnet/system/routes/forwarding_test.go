@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+type fakeSysctl struct {
+	values   map[string]string
+	readOnly bool
+}
+
+func (f *fakeSysctl) Get(name string) (string, error) {
+	val, ok := f.values[name]
+	if !ok {
+		return "", fs.ErrNotExist
+	}
+	return val + "\n", nil
+}
+
+func (f *fakeSysctl) Set(name, value string) error {
+	if f.readOnly {
+		return fs.ErrPermission
+	}
+	f.values[name] = value
+	return nil
+}
+
+func TestForwarding(t *testing.T) {
+	t.Parallel()
+	if ipv4ForwardingSysctl == "" {
+		t.Skip("forwarding sysctls are not supported on this platform")
+	}
+
+	t.Run("EnableAndRestore", func(t *testing.T) {
+		t.Parallel()
+		sysctl := &fakeSysctl{values: map[string]string{
+			ipv4ForwardingSysctl: "0",
+			ipv6ForwardingSysctl: "1",
+		}}
+		fwd := NewForwarding(sysctl)
+		if err := fwd.Enable(true, true); err != nil {
+			t.Fatalf("enable forwarding: %v", err)
+		}
+		for name, val := range sysctl.values {
+			if val != "1" {
+				t.Errorf("expected %s to be enabled, got %q", name, val)
+			}
+		}
+		// Enabling again must not lose the original values.
+		if err := fwd.Enable(true, true); err != nil {
+			t.Fatalf("enable forwarding: %v", err)
+		}
+		if err := fwd.Restore(); err != nil {
+			t.Fatalf("restore forwarding: %v", err)
+		}
+		if got := sysctl.values[ipv4ForwardingSysctl]; got != "0" {
+			t.Errorf("expected ipv4 forwarding to be restored to 0, got %q", got)
+		}
+		if got := sysctl.values[ipv6ForwardingSysctl]; got != "1" {
+			t.Errorf("expected ipv6 forwarding to stay 1, got %q", got)
+		}
+	})
+
+	t.Run("OnlyRequestedFamilies", func(t *testing.T) {
+		t.Parallel()
+		sysctl := &fakeSysctl{values: map[string]string{
+			ipv4ForwardingSysctl: "0",
+			ipv6ForwardingSysctl: "0",
+		}}
+		if err := NewForwarding(sysctl).Enable(true, false); err != nil {
+			t.Fatalf("enable forwarding: %v", err)
+		}
+		if got := sysctl.values[ipv6ForwardingSysctl]; got != "0" {
+			t.Errorf("expected ipv6 forwarding to be untouched, got %q", got)
+		}
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		sysctl := &fakeSysctl{readOnly: true, values: map[string]string{
+			ipv4ForwardingSysctl: "0",
+		}}
+		err := NewForwarding(sysctl).Enable(true, false)
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("expected a permission error, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "CAP_NET_ADMIN") {
+			t.Errorf("expected the error to explain the required permissions, got %v", err)
+		}
+	})
+}
@@ -18,6 +18,18 @@ package routes
 
 import "log/slog"
 
+// Forwarding sysctls do not exist on this platform.
+const (
+	ipv4ForwardingSysctl = ""
+	ipv6ForwardingSysctl = ""
+)
+
+// SystemSysctl returns a Sysctl for the current host. Sysctls are not
+// supported on this platform.
+func SystemSysctl() Sysctl {
+	return unsupportedSysctl{}
+}
+
 // EnableIPForwarding enables IP forwarding.
 func EnableIPForwarding() error {
 	slog.Default().Debug("not enabling IP forwarding on wasm")
@@ -24,6 +24,18 @@ import (
 // ErrRouteExists is returned when a route already exists.
 var ErrRouteExists = errors.New("route already exists")
 
+// ErrRoutingTablesNotSupported is returned when a custom routing table or
+// policy rule is requested on a platform that does not support them.
+var ErrRoutingTablesNotSupported = errors.New("routing tables are not supported on this platform")
+
+// HostRoute is a route configured on the system.
+type HostRoute struct {
+	// Interface is the name of the interface the route is on.
+	Interface string
+	// Destination is the destination network of the route.
+	Destination netip.Prefix
+}
+
 // Gateway represents a gateway route. It contains the name and IP address
 // of a gateway interface.
 type Gateway struct {
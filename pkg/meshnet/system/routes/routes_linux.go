@@ -89,6 +89,53 @@ func SetDefaultIPv4Gateway(ctx context.Context, gateway Gateway) error {
 
 // Add adds a route to the interface with the given name.
 func Add(ctx context.Context, ifaceName string, addr netip.Prefix) error {
+	return AddToTable(ctx, ifaceName, addr, 0)
+}
+
+// Remove removes a route from the interface with the given name.
+func Remove(ctx context.Context, ifaceName string, addr netip.Prefix) error {
+	return RemoveFromTable(ctx, ifaceName, addr, 0)
+}
+
+// List returns the routes in the main routing table. Default routes are
+// not included.
+func List(_ context.Context) ([]HostRoute, error) {
+	rts, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	names := make(map[int]string)
+	var out []HostRoute
+	for _, rt := range rts {
+		if rt.Dst == nil {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(rt.Dst.IP)
+		if !ok {
+			continue
+		}
+		ones, _ := rt.Dst.Mask.Size()
+		if ones == 0 {
+			continue
+		}
+		name, ok := names[rt.LinkIndex]
+		if !ok {
+			if link, err := netlink.LinkByIndex(rt.LinkIndex); err == nil {
+				name = link.Attrs().Name
+			}
+			names[rt.LinkIndex] = name
+		}
+		out = append(out, HostRoute{
+			Interface:   name,
+			Destination: netip.PrefixFrom(addr.Unmap(), ones).Masked(),
+		})
+	}
+	return out, nil
+}
+
+// AddToTable adds a route to the interface with the given name in the given
+// routing table. A table of zero uses the main table.
+func AddToTable(ctx context.Context, ifaceName string, addr netip.Prefix, table int) error {
 	link, err := netlink.LinkByName(ifaceName)
 	if err != nil {
 		return fmt.Errorf("get link by name: %w", err)
@@ -100,8 +147,9 @@ func Add(ctx context.Context, ifaceName string, addr netip.Prefix) error {
 			IP:   addr.Masked().Addr().AsSlice(),
 			Mask: net.CIDRMask(ones, 8*len(addr.Addr().AsSlice())),
 		},
+		Table: table,
 	}
-	context.LoggerFrom(ctx).Debug("Adding route to interface", slog.Any("route", rt.Dst))
+	context.LoggerFrom(ctx).Debug("Adding route to interface", slog.Any("route", rt.Dst), slog.Int("table", table))
 	err = netlink.RouteAdd(rt)
 	if err != nil {
 		if strings.Contains(err.Error(), "file exists") || errors.Is(err, os.ErrExist) {
@@ -112,8 +160,9 @@ func Add(ctx context.Context, ifaceName string, addr netip.Prefix) error {
 	return nil
 }
 
-// Remove removes a route from the interface with the given name.
-func Remove(ctx context.Context, ifaceName string, addr netip.Prefix) error {
+// RemoveFromTable removes a route from the interface with the given name in
+// the given routing table. A table of zero uses the main table.
+func RemoveFromTable(ctx context.Context, ifaceName string, addr netip.Prefix, table int) error {
 	link, err := netlink.LinkByName(ifaceName)
 	if err != nil {
 		return fmt.Errorf("get link by name: %w", err)
@@ -125,8 +174,9 @@ func Remove(ctx context.Context, ifaceName string, addr netip.Prefix) error {
 			IP:   addr.Masked().Addr().AsSlice(),
 			Mask: net.CIDRMask(ones, 8*len(addr.Addr().AsSlice())),
 		},
+		Table: table,
 	}
-	context.LoggerFrom(ctx).Debug("Removing route from interface", slog.Any("route", rt.Dst))
+	context.LoggerFrom(ctx).Debug("Removing route from interface", slog.Any("route", rt.Dst), slog.Int("table", table))
 	err = netlink.RouteDel(rt)
 	if err != nil {
 		if strings.Contains(err.Error(), "no such process") || errors.Is(err, os.ErrNotExist) {
@@ -137,6 +187,47 @@ func Remove(ctx context.Context, ifaceName string, addr netip.Prefix) error {
 	return nil
 }
 
+// AddPolicyRule adds policy rules that send traffic through the given routing
+// table. When fwmark is set, packets carrying the mark are excluded so that
+// traffic sent by WireGuard itself never loops back into the mesh table.
+func AddPolicyRule(ctx context.Context, fwmark uint32, table int) error {
+	for _, rule := range policyRules(fwmark, table) {
+		context.LoggerFrom(ctx).Debug("Adding policy rule", slog.String("rule", rule.String()))
+		err := netlink.RuleAdd(rule)
+		if err != nil && !errors.Is(err, os.ErrExist) && !strings.Contains(err.Error(), "file exists") {
+			return fmt.Errorf("add policy rule: %w", err)
+		}
+	}
+	return nil
+}
+
+// RemovePolicyRule removes the policy rules added by AddPolicyRule.
+func RemovePolicyRule(ctx context.Context, fwmark uint32, table int) error {
+	for _, rule := range policyRules(fwmark, table) {
+		context.LoggerFrom(ctx).Debug("Removing policy rule", slog.String("rule", rule.String()))
+		err := netlink.RuleDel(rule)
+		if err != nil && !errors.Is(err, os.ErrNotExist) && !strings.Contains(err.Error(), "no such file") {
+			return fmt.Errorf("remove policy rule: %w", err)
+		}
+	}
+	return nil
+}
+
+func policyRules(fwmark uint32, table int) []*netlink.Rule {
+	var rules []*netlink.Rule
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rule := netlink.NewRule()
+		rule.Family = family
+		rule.Table = table
+		if fwmark != 0 {
+			rule.Mark = int(fwmark)
+			rule.Invert = true
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
 func decodeKernelHexIP(hexIP string) (netip.Addr, error) {
 	ip, err := hex.DecodeString(hexIP)
 	if err != nil {
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestRoutingTables(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("routing table tests require root")
+	}
+	ctx := context.Background()
+	const table = 4242
+	const fwmark = 0x4242
+
+	name := "wmtest0"
+	dummy := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	if err := netlink.LinkAdd(dummy); err != nil {
+		// Sandboxed environments may not permit creating links.
+		t.Skip("cannot create dummy links:", err)
+	}
+	t.Cleanup(func() { _ = netlink.LinkDel(dummy) })
+	if err := netlink.LinkSetUp(dummy); err != nil {
+		t.Fatalf("set dummy link up: %v", err)
+	}
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatalf("get dummy link: %v", err)
+	}
+
+	listRoutes := func(t *testing.T, table int) []netlink.Route {
+		t.Helper()
+		routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Table:     table,
+		}, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+		if err != nil {
+			t.Fatalf("list routes: %v", err)
+		}
+		return routes
+	}
+	hasRoute := func(routes []netlink.Route, dst netip.Prefix) bool {
+		for _, rt := range routes {
+			if rt.Dst != nil && rt.Dst.String() == dst.String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("Routes", func(t *testing.T) {
+		dst := netip.MustParsePrefix("172.31.42.0/24")
+		if err := AddToTable(ctx, name, dst, table); err != nil {
+			t.Fatalf("add route to table: %v", err)
+		}
+		if err := AddToTable(ctx, name, dst, table); !errors.Is(err, ErrRouteExists) {
+			t.Errorf("expected ErrRouteExists, got %v", err)
+		}
+		if !hasRoute(listRoutes(t, table), dst) {
+			t.Errorf("expected route %s in table %d", dst, table)
+		}
+		if hasRoute(listRoutes(t, 0), dst) {
+			t.Errorf("expected route %s to not be in the main table", dst)
+		}
+		if err := RemoveFromTable(ctx, name, dst, table); err != nil {
+			t.Fatalf("remove route from table: %v", err)
+		}
+		if hasRoute(listRoutes(t, table), dst) {
+			t.Errorf("expected route %s to be removed from table %d", dst, table)
+		}
+		// Removing a missing route is not an error.
+		if err := RemoveFromTable(ctx, name, dst, table); err != nil {
+			t.Errorf("remove missing route: %v", err)
+		}
+	})
+
+	t.Run("PolicyRules", func(t *testing.T) {
+		findRule := func(t *testing.T) bool {
+			t.Helper()
+			rules, err := netlink.RuleList(netlink.FAMILY_V4)
+			if err != nil {
+				t.Fatalf("list rules: %v", err)
+			}
+			for _, rule := range rules {
+				if rule.Table == table && rule.Mark == fwmark && rule.Invert {
+					return true
+				}
+			}
+			return false
+		}
+		if err := AddPolicyRule(ctx, fwmark, table); err != nil {
+			t.Fatalf("add policy rule: %v", err)
+		}
+		if !findRule(t) {
+			t.Error("expected policy rule to be installed")
+		}
+		if err := RemovePolicyRule(ctx, fwmark, table); err != nil {
+			t.Fatalf("remove policy rule: %v", err)
+		}
+		if findRule(t) {
+			t.Error("expected policy rule to be removed")
+		}
+	})
+}
@@ -0,0 +1,58 @@
+//go:build !linux
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"context"
+	"net/netip"
+)
+
+// List is not supported on this platform.
+func List(ctx context.Context) ([]HostRoute, error) {
+	return nil, ErrRoutingTablesNotSupported
+}
+
+// AddToTable adds a route to the interface with the given name in the given
+// routing table. Only the main table (zero) is supported on this platform.
+func AddToTable(ctx context.Context, ifaceName string, addr netip.Prefix, table int) error {
+	if table != 0 {
+		return ErrRoutingTablesNotSupported
+	}
+	return Add(ctx, ifaceName, addr)
+}
+
+// RemoveFromTable removes a route from the interface with the given name in
+// the given routing table. Only the main table (zero) is supported on this
+// platform.
+func RemoveFromTable(ctx context.Context, ifaceName string, addr netip.Prefix, table int) error {
+	if table != 0 {
+		return ErrRoutingTablesNotSupported
+	}
+	return Remove(ctx, ifaceName, addr)
+}
+
+// AddPolicyRule is not supported on this platform.
+func AddPolicyRule(ctx context.Context, fwmark uint32, table int) error {
+	return ErrRoutingTablesNotSupported
+}
+
+// RemovePolicyRule is not supported on this platform.
+func RemovePolicyRule(ctx context.Context, fwmark uint32, table int) error {
+	return ErrRoutingTablesNotSupported
+}
@@ -16,13 +16,17 @@ limitations under the License.
 
 package testutil
 
-import "context"
+import (
+	"context"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+)
 
 // Firewall is a mock firewall.
 type Firewall struct{}
 
 // AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
-func (fw *Firewall) AddWireguardForwarding(ctx context.Context, ifaceName string) error {
+func (fw *Firewall) AddWireguardForwarding(ctx context.Context, ifaceName string, family firewall.Family) error {
 	return nil
 }
 
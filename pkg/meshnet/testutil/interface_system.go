@@ -26,6 +26,7 @@ import (
 	"sync"
 
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
 )
 
 // SystemInterface is a test interface for use with testing.
@@ -176,6 +177,11 @@ func (t *SystemInterface) RemoveRoute(_ context.Context, route netip.Prefix) err
 
 // Link returns the underlying net.Interface.
 func (t *SystemInterface) Link() (*net.Interface, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, link.ErrLinkNotExists
+	}
 	return &net.Interface{
 		Index:        1,
 		MTU:          system.DefaultMTU,
@@ -18,9 +18,13 @@ package testutil
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"sync"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
@@ -35,7 +39,9 @@ type WireGuardInterface struct {
 	opts  *wireguard.Options
 	key   crypto.PrivateKey
 	peers map[string]wireguard.Peer
-	mu    sync.Mutex
+	// handshakes are the last handshake times reported for each peer.
+	handshakes map[string]time.Time
+	mu         sync.Mutex
 }
 
 // NewWireGuardInterface creates a new test wireguard interface.
@@ -53,9 +59,10 @@ func NewWireGuardInterface(ctx context.Context, opts *wireguard.Options) (wiregu
 		return nil, err
 	}
 	return &WireGuardInterface{
-		Interface: systemInterface,
-		peers:     make(map[string]wireguard.Peer),
-		opts:      opts,
+		Interface:  systemInterface,
+		peers:      make(map[string]wireguard.Peer),
+		handshakes: make(map[string]time.Time),
+		opts:       opts,
 	}, nil
 }
 
@@ -76,6 +83,9 @@ func (wg *WireGuardInterface) ListenPort() (int, error) {
 func (wg *WireGuardInterface) PutPeer(ctx context.Context, peer *wireguard.Peer) error {
 	wg.mu.Lock()
 	defer wg.mu.Unlock()
+	if _, ok := wg.peers[peer.ID]; !ok {
+		wg.handshakes[peer.ID] = time.Now()
+	}
 	wg.peers[peer.ID] = *peer
 	return nil
 }
@@ -85,9 +95,15 @@ func (wg *WireGuardInterface) DeletePeer(ctx context.Context, id string) error {
 	wg.mu.Lock()
 	defer wg.mu.Unlock()
 	delete(wg.peers, id)
+	delete(wg.handshakes, id)
 	return nil
 }
 
+// RemoveUnknownPeers is a no-op since all peers are added through PutPeer.
+func (wg *WireGuardInterface) RemoveUnknownPeers(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
 // Peers returns the list of peers in the wireguard configuration.
 func (wg *WireGuardInterface) Peers() map[string]wireguard.Peer {
 	wg.mu.Lock()
@@ -121,6 +137,69 @@ func (wg *WireGuardInterface) Metrics() (*v1.InterfaceMetrics, error) {
 	}, nil
 }
 
+// DumpConfig returns the in-memory configuration of the wireguard interface.
+// It is read through the fake device returned by Device, the same way the
+// configuration of a real interface is read through wgctrl.
+func (wg *WireGuardInterface) DumpConfig(ctx context.Context) (*wireguard.DeviceConfig, error) {
+	config, err := wireguard.ReadDeviceConfig(wg, wg.opts.Name, wg.Peers())
+	if err != nil {
+		return nil, err
+	}
+	config.Type = "test-wireguard"
+	return config, nil
+}
+
+// Device implements wireguard.DeviceReader with a wgctrl device built from
+// the in-memory configuration.
+func (wg *WireGuardInterface) Device(name string) (*wgtypes.Device, error) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	if name != wg.opts.Name {
+		return nil, fmt.Errorf("device %q not found", name)
+	}
+	device := &wgtypes.Device{
+		Name:       wg.opts.Name,
+		ListenPort: wg.opts.ListenPort,
+		Peers:      make([]wgtypes.Peer, 0, len(wg.peers)),
+	}
+	if wg.key != nil {
+		device.PublicKey = wg.key.PublicKey().WireGuardKey()
+	}
+	for id, peer := range wg.peers {
+		p := wgtypes.Peer{
+			PresharedKey:                peer.PresharedKey,
+			LastHandshakeTime:           wg.handshakes[id],
+			PersistentKeepaliveInterval: wg.opts.PersistentKeepAlive,
+			AllowedIPs:                  make([]net.IPNet, 0, len(peer.AllowedIPs)),
+		}
+		if peer.PersistentKeepAlive != 0 {
+			p.PersistentKeepaliveInterval = peer.PersistentKeepAlive
+		}
+		if peer.PublicKey != nil {
+			p.PublicKey = peer.PublicKey.WireGuardKey()
+		}
+		if peer.Endpoint.IsValid() {
+			p.Endpoint = net.UDPAddrFromAddrPort(peer.Endpoint)
+		}
+		for _, ip := range peer.AllowedIPs {
+			p.AllowedIPs = append(p.AllowedIPs, net.IPNet{
+				IP:   ip.Addr().AsSlice(),
+				Mask: net.CIDRMask(ip.Bits(), ip.Addr().BitLen()),
+			})
+		}
+		device.Peers = append(device.Peers, p)
+	}
+	return device, nil
+}
+
+// SetLastHandshake sets the time of the last handshake reported for the
+// given peer. Peers are reported as having handshaked when they were added.
+func (wg *WireGuardInterface) SetLastHandshake(id string, t time.Time) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	wg.handshakes[id] = t
+}
+
 // Close closes the wireguard interface and all client connections.
 func (wg *WireGuardInterface) Close(ctx context.Context) error {
 	return nil
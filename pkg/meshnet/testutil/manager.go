@@ -18,9 +18,14 @@ package testutil
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/netip"
+	"slices"
 	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
@@ -43,7 +48,12 @@ type Manager struct {
 	netv4  netip.Prefix
 	netv6  netip.Prefix
 	masq   bool
+	zones  []string
+	start  meshnet.StartOptions
+	stop   func()
 	mu     sync.Mutex
+
+	keepalives *keepaliveOverrides
 }
 
 // NewManager creates a new test network manager with a new in-memory database.
@@ -57,8 +67,11 @@ func NewManagerWithDB(db storage.MeshDB, opts meshnet.Options, nodeID types.Node
 		nodeID: nodeID,
 		db:     db,
 		opts:   opts,
+		zones:  types.ParseZones(opts.ZoneAwarenessID),
 		dns:    &DNSManager{},
 		fw:     &Firewall{},
+
+		keepalives: &keepaliveOverrides{intervals: make(map[string]time.Duration)},
 	}
 }
 
@@ -66,10 +79,41 @@ func NewManagerWithDB(db storage.MeshDB, opts meshnet.Options, nodeID types.Node
 func (c *Manager) Start(ctx context.Context, opts meshnet.StartOptions) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	var err error
 	c.netv4 = opts.NetworkV4
 	c.netv6 = opts.NetworkV6
-	c.wg, err = NewWireGuardInterface(ctx, &wireguard.Options{
+	c.start = opts
+	err := c.startInterface(ctx)
+	if err != nil {
+		return err
+	}
+	c.peers = &PeerManager{wg: c.wg, keepalives: c.keepalives}
+	if c.opts.InterfaceWatchMode.Enabled() {
+		watcher := &meshnet.InterfaceWatcher{
+			// Netlink events are never sent for the in-memory interface.
+			Mode:     meshnet.InterfaceWatchPoll,
+			Interval: c.opts.InterfaceWatchInterval,
+			Name:     c.wg.Name(),
+			Exists: func() (bool, error) {
+				return meshnet.InterfaceExists(c.WireGuard())
+			},
+			Recover: c.recoverInterface,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		c.stop = func() {
+			cancel()
+			<-done
+		}
+		go func() {
+			defer close(done)
+			watcher.Run(ctx)
+		}()
+	}
+	return nil
+}
+
+func (c *Manager) startInterface(ctx context.Context) error {
+	wg, err := NewWireGuardInterface(ctx, &wireguard.Options{
 		NodeID:              c.nodeID,
 		ListenPort:          c.opts.ListenPort,
 		Name:                c.opts.InterfaceName,
@@ -77,24 +121,41 @@ func (c *Manager) Start(ctx context.Context, opts meshnet.StartOptions) error {
 		ForceTUN:            c.opts.ForceTUN,
 		PersistentKeepAlive: c.opts.PersistentKeepAlive,
 		MTU:                 c.opts.MTU,
-		AddressV4:           opts.AddressV4,
-		AddressV6:           opts.AddressV6,
-		NetworkV4:           opts.NetworkV4,
-		NetworkV6:           opts.NetworkV6,
+		AddressV4:           c.start.AddressV4,
+		AddressV6:           c.start.AddressV6,
+		NetworkV4:           c.start.NetworkV4,
+		NetworkV6:           c.start.NetworkV6,
 		DisableIPv4:         c.opts.DisableIPv4,
 		DisableIPv6:         c.opts.DisableIPv6,
 	})
 	if err != nil {
 		return err
 	}
-	err = c.wg.Configure(ctx, opts.Key)
+	err = wg.Configure(ctx, c.start.Key)
 	if err != nil {
 		return err
 	}
-	c.peers = &PeerManager{wg: c.wg}
+	c.wg = wg
 	return nil
 }
 
+// recoverInterface recreates the test wireguard interface and refreshes
+// its peers from storage.
+func (c *Manager) recoverInterface(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.startInterface(ctx)
+	if err != nil {
+		return err
+	}
+	c.peers = &PeerManager{wg: c.wg, keepalives: c.keepalives}
+	peers, err := meshnet.WireGuardPeersFor(ctx, c.db, c.nodeID)
+	if err != nil {
+		return err
+	}
+	return c.peers.Refresh(ctx, peers)
+}
+
 // NetworkV4 returns the current IPv4 network. The returned value may be invalid.
 func (c *Manager) NetworkV4() netip.Prefix {
 	return c.netv4
@@ -132,9 +193,30 @@ func (c *Manager) DNS() meshnet.DNSManager {
 
 // Peers return the peer manager.
 func (c *Manager) Peers() meshnet.PeerManager {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.peers
 }
 
+// Zones returns the zones this node is currently available in.
+func (c *Manager) Zones() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.zones)
+}
+
+// SetZones updates the zones this node is available in.
+func (c *Manager) SetZones(ctx context.Context, zones []string) error {
+	zoneID, err := types.JoinZones(zones)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.zones = types.ParseZones(zoneID)
+	return nil
+}
+
 // Firewall returns the firewall.
 // The firewall is only available after Start has been called.
 func (c *Manager) Firewall() firewall.Firewall {
@@ -144,15 +226,138 @@ func (c *Manager) Firewall() firewall.Firewall {
 // WireGuard returns the wireguard interface.
 // The wireguard interface is only available after Start has been called.
 func (c *Manager) WireGuard() wireguard.Interface {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.wg
 }
 
+// DumpConfig returns the configuration of the test wireguard interface.
+func (c *Manager) DumpConfig(ctx context.Context) (*wireguard.DeviceConfig, error) {
+	wg := c.WireGuard()
+	if wg == nil {
+		return nil, fmt.Errorf("wireguard interface is not available")
+	}
+	return wg.DumpConfig(ctx)
+}
+
+// Reconfigure recomputes peers from storage and re-applies them to the
+// test wireguard interface.
+func (c *Manager) Reconfigure(ctx context.Context) (*meshnet.ReconfigureResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.wg == nil {
+		return nil, fmt.Errorf("wireguard interface is not available")
+	}
+	before, err := c.wg.DumpConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := meshnet.WireGuardPeersFor(ctx, c.db, c.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	err = c.peers.Refresh(ctx, peers)
+	if err != nil {
+		return nil, err
+	}
+	after, err := c.wg.DumpConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return meshnet.DiffDeviceConfigs(before, after), nil
+}
+
+// VerifyPeers reports the status of the peers on the test wireguard
+// interface. Handshake times are those reported by the interface and
+// pings always succeed.
+func (c *Manager) VerifyPeers(ctx context.Context, opts meshnet.VerifyPeersOptions) ([]meshnet.PeerStatus, error) {
+	wg := c.WireGuard()
+	if wg == nil {
+		return nil, fmt.Errorf("wireguard interface is not available")
+	}
+	cfg, err := wg.DumpConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ping := func(context.Context, netip.Addr) error { return nil }
+	return meshnet.CheckPeers(ctx, cfg, wg.Peers(), time.Now(), opts, ping), nil
+}
+
+// Preflight reports every check as skipped since the test manager never
+// touches the host.
+func (c *Manager) Preflight(ctx context.Context) []meshnet.CheckResult {
+	names := []string{meshnet.PreflightNetAdmin, meshnet.PreflightIPForwarding, meshnet.PreflightWireGuardModule, meshnet.PreflightTUNDevice}
+	results := make([]meshnet.CheckResult, len(names))
+	for i, name := range names {
+		results[i] = meshnet.CheckResult{Name: name, Passed: true, Skipped: true, Message: "test network manager"}
+	}
+	return results
+}
+
+// RoamedEndpoints returns no roamed endpoints, peers never roam on the
+// test network.
+func (c *Manager) RoamedEndpoints(ctx context.Context) ([]meshnet.RoamedEndpoint, error) {
+	return []meshnet.RoamedEndpoint{}, nil
+}
+
+// EffectiveRoutes returns the routes for this node's peers computed from
+// the test database.
+func (c *Manager) EffectiveRoutes(ctx context.Context) ([]meshnet.EffectiveRoute, error) {
+	return meshnet.EffectiveRoutes(ctx, c.db, c.nodeID, meshnet.PeerMapOptions{
+		EqualCostMultipath: c.opts.EqualCostMultipath,
+		ExitNode:           c.opts.ExitNode,
+	})
+}
+
+// SetPeerKeepalive sets the keepalive override for the given peer and
+// applies it to the test wireguard interface.
+func (c *Manager) SetPeerKeepalive(ctx context.Context, peerID string, interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("keepalive interval must not be negative")
+	}
+	c.keepalives.set(peerID, interval)
+	wg := c.WireGuard()
+	if wg == nil {
+		return nil
+	}
+	peer, ok := wg.Peers()[peerID]
+	if !ok {
+		return nil
+	}
+	peer.PersistentKeepAlive = interval
+	return wg.PutPeer(ctx, &peer)
+}
+
+// SetPeerPresharedKey applies the preshared key to the given peer if it is
+// configured on the test wireguard interface.
+func (c *Manager) SetPeerPresharedKey(ctx context.Context, peerID string, key wgtypes.Key) error {
+	wg := c.WireGuard()
+	if wg == nil {
+		return nil
+	}
+	peer, ok := wg.Peers()[peerID]
+	if !ok {
+		return nil
+	}
+	peer.PresharedKey = key
+	return wg.PutPeer(ctx, &peer)
+}
+
+// CheckHostOverlap is a no-op on the test manager since it never
+// configures the host.
+func (c *Manager) CheckHostOverlap(ctx context.Context, networks ...netip.Prefix) error {
+	return nil
+}
+
 func (c *Manager) Dial(ctx context.Context, network, address string) (net.Conn, error) {
 	return (&net.Dialer{}).DialContext(ctx, network, address)
 }
 
 // Close closes the network manager and cleans up any resources.
 func (c *Manager) Close(ctx context.Context) error {
+	if c.stop != nil {
+		c.stop()
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return nil
@@ -18,6 +18,8 @@ package testutil
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 
@@ -30,7 +32,34 @@ import (
 
 // PeerManager is a mock peer manager for wireguard.
 type PeerManager struct {
-	wg wireguard.Interface
+	wg         wireguard.Interface
+	keepalives *keepaliveOverrides
+}
+
+// keepaliveOverrides are per-peer keepalive intervals that outlive
+// the peer manager.
+type keepaliveOverrides struct {
+	intervals map[string]time.Duration
+	mu        sync.Mutex
+}
+
+func (k *keepaliveOverrides) get(id string) time.Duration {
+	if k == nil {
+		return 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.intervals[id]
+}
+
+func (k *keepaliveOverrides) set(id string, interval time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if interval == 0 {
+		delete(k.intervals, id)
+		return
+	}
+	k.intervals[id] = interval
 }
 
 // AddPeer adds a peer to the wireguard interface. IceServers is optional
@@ -42,8 +71,9 @@ func (p *PeerManager) Add(ctx context.Context, peer *v1.WireGuardPeer, iceServer
 		return err
 	}
 	return p.wg.PutPeer(ctx, &wireguard.Peer{
-		ID:        peer.GetNode().GetId(),
-		PublicKey: key,
+		ID:                  peer.GetNode().GetId(),
+		PublicKey:           key,
+		PersistentKeepAlive: p.keepalives.get(peer.GetNode().GetId()),
 	})
 }
 
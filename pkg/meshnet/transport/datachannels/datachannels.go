@@ -26,6 +26,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pion/datachannel"
 	"github.com/pion/webrtc/v3"
@@ -72,6 +73,10 @@ type ServerChannel interface {
 // TODO: Make this configurable.
 const DefaultWireGuardProxyBuffer = 1024 * 1024
 
+// DefaultICEGatheringTimeout is the default time to wait for ICE candidate
+// gathering before proceeding with the candidates gathered so far.
+const DefaultICEGatheringTimeout = 5 * time.Second
+
 // NewServerChannel creates a new server-side data channel.
 func NewServerChannel(ctx context.Context, rt transport.WebRTCSignalTransport) (ServerChannel, error) {
 	log := context.LoggerFrom(ctx)
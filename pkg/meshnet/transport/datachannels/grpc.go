@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// ProtocolGRPC is the data channel protocol used to carry gRPC connections
+// to the mesh services of the remote node.
+const ProtocolGRPC = "grpc"
+
+// StreamAddr is the net.Addr of a connection carried over a data channel.
+type StreamAddr string
+
+// Network implements net.Addr.
+func (a StreamAddr) Network() string { return "webrtc" }
+
+// String implements net.Addr.
+func (a StreamAddr) String() string { return string(a) }
+
+// StreamConn is a net.Conn shim over a detached data channel. Data channels
+// have no notion of deadlines, so the deadline methods are no-ops. This is
+// sufficient for gRPC which manages its own timeouts with keepalives.
+type StreamConn struct {
+	io.ReadWriteCloser
+	local, remote net.Addr
+}
+
+// NewStreamConn wraps the given data channel as a net.Conn.
+func NewStreamConn(rw io.ReadWriteCloser, local, remote net.Addr) *StreamConn {
+	return &StreamConn{ReadWriteCloser: rw, local: local, remote: remote}
+}
+
+// LocalAddr implements net.Conn.
+func (c *StreamConn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr implements net.Conn.
+func (c *StreamConn) RemoteAddr() net.Addr { return c.remote }
+
+// SetDeadline implements net.Conn.
+func (c *StreamConn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline implements net.Conn.
+func (c *StreamConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline implements net.Conn.
+func (c *StreamConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// ConnListener is a net.Listener for connections arriving over data channels.
+// Connections are handed to the listener with Handle and returned from Accept,
+// which allows a gRPC server to serve connections from WebRTC peers.
+type ConnListener struct {
+	addr      net.Addr
+	connc     chan net.Conn
+	closec    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConnListener returns a new ConnListener with the given address.
+func NewConnListener(addr net.Addr) *ConnListener {
+	if addr == nil {
+		addr = StreamAddr(ProtocolGRPC)
+	}
+	return &ConnListener{
+		addr:   addr,
+		connc:  make(chan net.Conn),
+		closec: make(chan struct{}),
+	}
+}
+
+// Handle queues the connection to be returned from Accept. It blocks until
+// the connection is accepted or the listener is closed, in which case the
+// connection is closed.
+func (l *ConnListener) Handle(conn net.Conn) {
+	select {
+	case l.connc <- conn:
+	case <-l.closec:
+		_ = conn.Close()
+	}
+}
+
+// Accept implements net.Listener.
+func (l *ConnListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connc:
+		return conn, nil
+	case <-l.closec:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (l *ConnListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closec) })
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *ConnListener) Addr() net.Addr { return l.addr }
+
+// DialGRPC returns a gRPC client connection that carries its traffic over
+// data channels on the peer connection. The peer connection should have been
+// negotiated with ProtocolGRPC so the remote side serves its mesh services on
+// incoming channels.
+func (pc *PeerConnectionClient) DialGRPC(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, "passthrough:///"+ProtocolGRPC, append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-pc.closed:
+			return nil, net.ErrClosed
+		case <-pc.ready:
+		}
+		local, remote := net.Pipe()
+		go pc.Handle(remote)
+		return local, nil
+	}))...)
+}
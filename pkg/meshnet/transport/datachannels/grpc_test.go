@@ -0,0 +1,139 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestGRPCOverDataChannels(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	lis := NewConnListener(nil)
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	server, err := NewPeerConnectionServer(ctx, &OfferOptions{
+		Proto:      ProtocolGRPC,
+		SrcAddress: "client",
+		DstAddress: "server",
+		Handler:    lis.Handle,
+	})
+	if err != nil {
+		t.Fatalf("new peer connection server: %v", err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	client, err := NewPeerConnectionClient(ctx, ProtocolGRPC, newTestSignalTransport(t, server))
+	if err != nil {
+		t.Fatalf("new peer connection client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	select {
+	case <-client.Ready():
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for peer connection")
+	}
+	conn, err := client.DialGRPC(ctx, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial grpc: %v", err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected status %s, got %s", healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	}
+}
+
+// testSignalTransport signals directly with an in-process PeerConnectionServer.
+type testSignalTransport struct {
+	t          *testing.T
+	server     *PeerConnectionServer
+	candidates chan webrtc.ICECandidateInit
+	errc       chan error
+}
+
+func newTestSignalTransport(t *testing.T, server *PeerConnectionServer) *testSignalTransport {
+	return &testSignalTransport{
+		t:          t,
+		server:     server,
+		candidates: make(chan webrtc.ICECandidateInit, 16),
+		errc:       make(chan error, 1),
+	}
+}
+
+func (s *testSignalTransport) Start(ctx context.Context) error {
+	go func() {
+		defer close(s.candidates)
+		// The server sends the bare candidate string, as it is carried
+		// in a DataChannelOffer.
+		for cand := range s.server.Candidates() {
+			s.candidates <- webrtc.ICECandidateInit{Candidate: cand}
+		}
+	}()
+	return nil
+}
+
+func (s *testSignalTransport) TURNServers() []webrtc.ICEServer { return nil }
+
+func (s *testSignalTransport) SendDescription(ctx context.Context, desc webrtc.SessionDescription) error {
+	data, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	return s.server.AnswerOffer(string(data))
+}
+
+func (s *testSignalTransport) SendCandidate(ctx context.Context, candidate webrtc.ICECandidateInit) error {
+	data, err := json.Marshal(candidate)
+	if err != nil {
+		return err
+	}
+	return s.server.AddCandidate(string(data))
+}
+
+func (s *testSignalTransport) Candidates() <-chan webrtc.ICECandidateInit { return s.candidates }
+
+func (s *testSignalTransport) RemoteDescription() webrtc.SessionDescription {
+	var offer webrtc.SessionDescription
+	if err := json.Unmarshal([]byte(s.server.Offer()), &offer); err != nil {
+		s.t.Errorf("unmarshal offer: %v", err)
+	}
+	return offer
+}
+
+func (s *testSignalTransport) Error() <-chan error { return s.errc }
+
+func (s *testSignalTransport) Close() error { return nil }
@@ -0,0 +1,246 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// DefaultMaxICERestarts is the default number of ICE restarts attempted
+// before a failed connection is closed.
+const DefaultMaxICERestarts = 3
+
+// DefaultICERestartTimeout is the default time allowed for a single ICE
+// restart to gather candidates and renegotiate.
+const DefaultICERestartTimeout = 15 * time.Second
+
+// ConnectionState is the state of a peer connection as seen by the
+// application.
+type ConnectionState string
+
+const (
+	// StateConnecting is the state before the connection is first established.
+	StateConnecting ConnectionState = "connecting"
+	// StateConnected is the state while the connection is established.
+	StateConnected ConnectionState = "connected"
+	// StateReconnecting is the state while an ICE restart is in progress.
+	StateReconnecting ConnectionState = "reconnecting"
+	// StateClosed is the state once the connection has failed or been closed.
+	StateClosed ConnectionState = "closed"
+)
+
+// RestartSignaler exchanges session descriptions with the remote peer
+// during an ICE restart.
+type RestartSignaler interface {
+	// Renegotiate sends the given offer to the remote peer and returns
+	// its answer.
+	Renegotiate(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error)
+}
+
+// RestartSignalerFunc is a function that implements RestartSignaler.
+type RestartSignalerFunc func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error)
+
+// Renegotiate implements RestartSignaler.
+func (f RestartSignalerFunc) Renegotiate(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	return f(ctx, offer)
+}
+
+// ICERestartOptions are options for an ICERestarter.
+type ICERestartOptions struct {
+	// MaxRestarts is the number of restarts attempted over the lifetime of
+	// the connection. Defaults to DefaultMaxICERestarts.
+	MaxRestarts int
+	// Timeout is the time allowed for a single restart. Defaults to
+	// DefaultICERestartTimeout.
+	Timeout time.Duration
+}
+
+// ICERestarter performs ICE restarts on the offering side of a peer connection
+// when it fails, re-gathering candidates and renegotiating over a signaler
+// without tearing down the data channels on the connection.
+type ICERestarter struct {
+	pc       *webrtc.PeerConnection
+	signaler RestartSignaler
+	opts     ICERestartOptions
+	log      *slog.Logger
+	state    ConnectionState
+	restarts int
+	running  bool
+	mu       sync.Mutex
+}
+
+// NewICERestarter returns a new ICERestarter for the given peer connection.
+// Owners of the connection should call OnConnected and OnFailed from their
+// state change handlers.
+func NewICERestarter(ctx context.Context, pc *webrtc.PeerConnection, signaler RestartSignaler, opts ICERestartOptions) *ICERestarter {
+	if opts.MaxRestarts <= 0 {
+		opts.MaxRestarts = DefaultMaxICERestarts
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultICERestartTimeout
+	}
+	return &ICERestarter{
+		pc:       pc,
+		signaler: signaler,
+		opts:     opts,
+		log:      context.LoggerFrom(ctx).With("component", "ice-restarter"),
+		state:    StateConnecting,
+	}
+}
+
+// State returns the current connection state.
+func (r *ICERestarter) State() ConnectionState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// Restarts returns the number of ICE restarts attempted so far.
+func (r *ICERestarter) Restarts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.restarts
+}
+
+// OnConnected marks the connection as established.
+func (r *ICERestarter) OnConnected() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != StateClosed {
+		r.state = StateConnected
+	}
+}
+
+// OnClosed marks the connection as closed. No further restarts are attempted.
+func (r *ICERestarter) OnClosed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = StateClosed
+}
+
+// OnFailed starts an ICE restart in the background if the restart budget
+// allows it. It returns false if the caller should close the connection.
+func (r *ICERestarter) OnFailed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == StateClosed {
+		return false
+	}
+	if r.running {
+		// A restart is already in progress and will retry as needed.
+		return true
+	}
+	if r.restarts >= r.opts.MaxRestarts {
+		r.log.Warn("ICE restart budget exhausted", slog.Int("restarts", r.restarts))
+		r.state = StateClosed
+		return false
+	}
+	r.state = StateReconnecting
+	r.running = true
+	go r.run()
+	return true
+}
+
+func (r *ICERestarter) run() {
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+	for {
+		r.mu.Lock()
+		if r.state != StateReconnecting {
+			r.mu.Unlock()
+			return
+		}
+		if r.restarts >= r.opts.MaxRestarts {
+			r.state = StateClosed
+			r.mu.Unlock()
+			r.log.Warn("ICE restart budget exhausted, closing connection")
+			if err := r.pc.Close(); err != nil {
+				r.log.Error("Failed to close peer connection", slog.String("error", err.Error()))
+			}
+			return
+		}
+		r.restarts++
+		attempt := r.restarts
+		r.mu.Unlock()
+		r.log.Info("Attempting ICE restart", slog.Int("attempt", attempt))
+		err := r.restart()
+		if err == nil {
+			return
+		}
+		r.log.Error("ICE restart failed", slog.Int("attempt", attempt), slog.String("error", err.Error()))
+	}
+}
+
+func (r *ICERestarter) restart() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.Timeout)
+	defer cancel()
+	offer, err := r.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return fmt.Errorf("create restart offer: %w", err)
+	}
+	// Candidates are sent with the offer instead of trickled since the
+	// signaling channel is only used for the description exchange.
+	gathered := webrtc.GatheringCompletePromise(r.pc)
+	if err := r.pc.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("set local description: %w", err)
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		r.log.Warn("ICE gathering timed out during restart, proceeding with partial candidates")
+	}
+	answer, err := r.signaler.Renegotiate(ctx, *r.pc.LocalDescription())
+	if err != nil {
+		return fmt.Errorf("renegotiate: %w", err)
+	}
+	if err := r.pc.SetRemoteDescription(answer); err != nil {
+		return fmt.Errorf("set remote description: %w", err)
+	}
+	return nil
+}
+
+// AnswerRestart applies an ICE restart offer from the remote peer to the
+// answering side of a connection and returns the answer to send back.
+func AnswerRestart(ctx context.Context, pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("set remote description: %w", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("create answer: %w", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("set local description: %w", err)
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return webrtc.SessionDescription{}, ctx.Err()
+	}
+	return *pc.LocalDescription(), nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestICERestart(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	offerer, answerer := newTestPeerConnections(t)
+
+	restarter := NewICERestarter(ctx, offerer, RestartSignalerFunc(func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		return AnswerRestart(ctx, answerer, offer)
+	}), ICERestartOptions{Timeout: 10 * time.Second})
+
+	connected := make(chan struct{}, 2)
+	offerer.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		if s == webrtc.PeerConnectionStateConnected {
+			restarter.OnConnected()
+			connected <- struct{}{}
+		}
+	})
+	received := make(chan string, 2)
+	answerer.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnOpen(func() {
+			raw, err := dc.Detach()
+			if err != nil {
+				t.Errorf("detach data channel: %v", err)
+				return
+			}
+			go func() {
+				buf := make([]byte, 64)
+				for {
+					n, err := raw.Read(buf)
+					if err != nil {
+						return
+					}
+					received <- string(buf[:n])
+				}
+			}()
+		})
+	})
+
+	// The connection may have been established before the handler was set.
+	if offerer.ConnectionState() == webrtc.PeerConnectionStateConnected {
+		restarter.OnConnected()
+	} else {
+		select {
+		case <-connected:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for initial connection")
+		}
+	}
+	if restarter.State() != StateConnected {
+		t.Fatalf("expected state %q, got %q", StateConnected, restarter.State())
+	}
+
+	// Simulate a connection failure and wait for the restart to complete.
+	if !restarter.OnFailed() {
+		t.Fatal("expected restart to be attempted")
+	}
+	if state := restarter.State(); state != StateReconnecting && state != StateConnected {
+		t.Fatalf("expected state %q, got %q", StateReconnecting, state)
+	}
+	deadline := time.After(10 * time.Second)
+	for restarter.State() != StateConnected {
+		select {
+		case <-connected:
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("timed out waiting for reconnection, state %q", restarter.State())
+		}
+	}
+	if restarter.Restarts() != 1 {
+		t.Errorf("expected 1 restart, got %d", restarter.Restarts())
+	}
+
+	// Data channels must survive the restart.
+	dc, err := offerer.CreateDataChannel("after-restart", &webrtc.DataChannelInit{
+		Ordered: common.Pointer(true),
+	})
+	if err != nil {
+		t.Fatalf("create data channel: %v", err)
+	}
+	opened := make(chan struct{})
+	dc.OnOpen(func() { close(opened) })
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for data channel to open")
+	}
+	raw, err := dc.Detach()
+	if err != nil {
+		t.Fatalf("detach data channel: %v", err)
+	}
+	if _, err := raw.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "hello" {
+			t.Errorf("expected %q, got %q", "hello", msg)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// DefaultMuxMaxBufferedAmount is the default number of bytes that may be
+// queued on a multiplexed stream before writes block.
+const DefaultMuxMaxBufferedAmount = 1024 * 1024
+
+// DefaultMuxAcceptBacklog is the default number of incoming streams that
+// may be queued before they are accepted.
+const DefaultMuxAcceptBacklog = 16
+
+// muxMaxMessageSize is the largest message written to a data channel at once.
+// Larger writes are split to stay under the SCTP message size limits of
+// all peers.
+const muxMaxMessageSize = 16 * 1024
+
+// muxReadBufferSize is the size of the buffer messages are read into. It
+// matches the largest SCTP message pion will deliver.
+const muxReadBufferSize = 64 * 1024
+
+// MuxOptions are options for a Mux.
+type MuxOptions struct {
+	// MaxBufferedAmount is the number of bytes that may be queued on a stream
+	// before writes block. Defaults to DefaultMuxMaxBufferedAmount.
+	MaxBufferedAmount uint64
+	// AcceptBacklog is the number of incoming streams that may be queued
+	// before they are accepted. Defaults to DefaultMuxAcceptBacklog.
+	AcceptBacklog int
+}
+
+// Mux multiplexes named streams over data channels on a single peer
+// connection. Each stream is carried by its own data channel so that
+// streams are isolated from each other. The peer connection must be
+// created with detached data channels enabled.
+type Mux struct {
+	pc        *webrtc.PeerConnection
+	opts      MuxOptions
+	acceptc   chan *MuxStream
+	closec    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMux creates a new Mux over the given peer connection. It replaces any
+// existing OnDataChannel handler on the connection.
+func NewMux(pc *webrtc.PeerConnection, opts MuxOptions) *Mux {
+	if opts.MaxBufferedAmount == 0 {
+		opts.MaxBufferedAmount = DefaultMuxMaxBufferedAmount
+	}
+	if opts.AcceptBacklog <= 0 {
+		opts.AcceptBacklog = DefaultMuxAcceptBacklog
+	}
+	m := &Mux{
+		pc:      pc,
+		opts:    opts,
+		acceptc: make(chan *MuxStream, opts.AcceptBacklog),
+		closec:  make(chan struct{}),
+	}
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnOpen(func() {
+			stream, err := m.newStream(dc)
+			if err != nil {
+				_ = dc.Close()
+				return
+			}
+			select {
+			case m.acceptc <- stream:
+			case <-m.closec:
+				_ = stream.Close()
+			}
+		})
+	})
+	return m
+}
+
+// Dial opens a new stream with the given name. The name is delivered to
+// the remote side with the stream.
+func (m *Mux) Dial(ctx context.Context, name string) (*MuxStream, error) {
+	select {
+	case <-m.closec:
+		return nil, net.ErrClosed
+	default:
+	}
+	dc, err := m.pc.CreateDataChannel(name, &webrtc.DataChannelInit{
+		Ordered: common.Pointer(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create data channel: %w", err)
+	}
+	type result struct {
+		stream *MuxStream
+		err    error
+	}
+	resc := make(chan result, 1)
+	dc.OnOpen(func() {
+		stream, err := m.newStream(dc)
+		resc <- result{stream, err}
+	})
+	select {
+	case <-ctx.Done():
+		_ = dc.Close()
+		return nil, ctx.Err()
+	case <-m.closec:
+		_ = dc.Close()
+		return nil, net.ErrClosed
+	case res := <-resc:
+		if res.err != nil {
+			_ = dc.Close()
+			return nil, res.err
+		}
+		return res.stream, nil
+	}
+}
+
+// Accept waits for and returns the next stream opened by the remote side.
+func (m *Mux) Accept(ctx context.Context) (*MuxStream, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-m.closec:
+		return nil, net.ErrClosed
+	case stream := <-m.acceptc:
+		return stream, nil
+	}
+}
+
+// Close stops accepting new streams. Existing streams and the underlying
+// peer connection are left open.
+func (m *Mux) Close() error {
+	m.closeOnce.Do(func() { close(m.closec) })
+	return nil
+}
+
+func (m *Mux) newStream(dc *webrtc.DataChannel) (*MuxStream, error) {
+	rw, err := dc.Detach()
+	if err != nil {
+		return nil, fmt.Errorf("detach data channel: %w", err)
+	}
+	s := &MuxStream{
+		dc:     dc,
+		rw:     rw,
+		max:    m.opts.MaxBufferedAmount,
+		lowc:   make(chan struct{}, 1),
+		closec: make(chan struct{}),
+	}
+	dc.SetBufferedAmountLowThreshold(m.opts.MaxBufferedAmount / 2)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case s.lowc <- struct{}{}:
+		default:
+		}
+	})
+	dc.OnClose(s.markClosed)
+	return s, nil
+}
+
+// MuxStream is a single named stream on a Mux.
+type MuxStream struct {
+	dc        *webrtc.DataChannel
+	rw        io.ReadWriteCloser
+	max       uint64
+	lowc      chan struct{}
+	closec    chan struct{}
+	closeOnce sync.Once
+	writemu   sync.Mutex
+	readmu    sync.Mutex
+	readbuf   []byte
+	pending   []byte
+}
+
+// Name returns the name the stream was opened with.
+func (s *MuxStream) Name() string {
+	return s.dc.Label()
+}
+
+// Read reads from the stream. It returns io.EOF once the remote side has
+// closed the stream. Messages larger than p are buffered and returned
+// over subsequent reads.
+func (s *MuxStream) Read(p []byte) (int, error) {
+	s.readmu.Lock()
+	defer s.readmu.Unlock()
+	if len(s.pending) == 0 {
+		if s.readbuf == nil {
+			s.readbuf = make([]byte, muxReadBufferSize)
+		}
+		n, err := s.rw.Read(s.readbuf)
+		if err != nil {
+			return 0, err
+		}
+		s.pending = s.readbuf[:n]
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Write writes to the stream. It blocks while more than the maximum
+// buffered amount is queued for sending.
+func (s *MuxStream) Write(p []byte) (int, error) {
+	s.writemu.Lock()
+	defer s.writemu.Unlock()
+	var written int
+	for len(p) > 0 {
+		for s.dc.BufferedAmount() > s.max {
+			select {
+			case <-s.lowc:
+			case <-s.closec:
+				return written, net.ErrClosed
+			}
+		}
+		chunk := p
+		if len(chunk) > muxMaxMessageSize {
+			chunk = chunk[:muxMaxMessageSize]
+		}
+		n, err := s.rw.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Closed returns a channel that is closed when the stream is closed.
+func (s *MuxStream) Closed() <-chan struct{} {
+	return s.closec
+}
+
+// Close closes the stream and its underlying data channel.
+func (s *MuxStream) Close() error {
+	s.markClosed()
+	return s.rw.Close()
+}
+
+func (s *MuxStream) markClosed() {
+	s.closeOnce.Do(func() { close(s.closec) })
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/webmeshproj/webmesh/pkg/common"
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestMux(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	offerer, answerer := newTestPeerConnections(t)
+	// A small buffer exercises backpressure on the larger writes below.
+	client := NewMux(offerer, MuxOptions{MaxBufferedAmount: 64 * 1024})
+	server := NewMux(answerer, MuxOptions{MaxBufferedAmount: 64 * 1024})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	const numStreams = 4
+	payloads := make(map[string][]byte, numStreams)
+	clientStreams := make(map[string]*MuxStream, numStreams)
+	for i := 0; i < numStreams; i++ {
+		name := fmt.Sprintf("stream-%d", i)
+		payloads[name] = bytes.Repeat([]byte{byte('a' + i)}, 256*1024)
+		stream, err := client.Dial(ctx, name)
+		if err != nil {
+			t.Fatalf("dial %s: %v", name, err)
+		}
+		clientStreams[name] = stream
+	}
+	serverStreams := make(map[string]*MuxStream, numStreams)
+	for i := 0; i < numStreams; i++ {
+		stream, err := server.Accept(ctx)
+		if err != nil {
+			t.Fatalf("accept: %v", err)
+		}
+		serverStreams[stream.Name()] = stream
+	}
+	if len(serverStreams) != numStreams {
+		t.Fatalf("expected %d distinct streams, got %d", numStreams, len(serverStreams))
+	}
+
+	// Write to every stream concurrently and make sure each side only
+	// sees the data written to its own stream.
+	errs := make(chan error, numStreams)
+	for name, stream := range clientStreams {
+		go func(name string, stream *MuxStream) {
+			_, err := stream.Write(payloads[name])
+			if err == nil {
+				err = stream.Close()
+			}
+			errs <- err
+		}(name, stream)
+	}
+	for name, stream := range serverStreams {
+		got, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if !bytes.Equal(got, payloads[name]) {
+			t.Errorf("stream %s received %d bytes not matching its payload", name, len(got))
+		}
+	}
+	for i := 0; i < numStreams; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	// Closing the mux stops accepting new streams.
+	if err := server.Close(); err != nil {
+		t.Fatalf("close mux: %v", err)
+	}
+	if _, err := server.Accept(ctx); err == nil {
+		t.Fatal("expected accept on a closed mux to fail")
+	}
+}
+
+func newTestPeerConnections(t *testing.T) (offerer, answerer *webrtc.PeerConnection) {
+	t.Helper()
+	s := webrtc.SettingEngine{}
+	s.DetachDataChannels()
+	s.SetIncludeLoopbackCandidate(true)
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(s))
+	var err error
+	offerer, err = api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("new peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = offerer.Close() })
+	answerer, err = api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("new peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = answerer.Close() })
+	// A data channel must be part of the initial offer for SCTP to be negotiated.
+	// It is pre-negotiated so it is not seen by the answering mux.
+	if _, err := offerer.CreateDataChannel("init", &webrtc.DataChannelInit{
+		Negotiated: common.Pointer(true),
+		ID:         common.Pointer(uint16(0)),
+	}); err != nil {
+		t.Fatalf("create data channel: %v", err)
+	}
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(offerer)
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		t.Fatalf("set local description: %v", err)
+	}
+	<-gathered
+	if err := answerer.SetRemoteDescription(*offerer.LocalDescription()); err != nil {
+		t.Fatalf("set remote description: %v", err)
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("create answer: %v", err)
+	}
+	gathered = webrtc.GatheringCompletePromise(answerer)
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		t.Fatalf("set local description: %v", err)
+	}
+	<-gathered
+	if err := offerer.SetRemoteDescription(*answerer.LocalDescription()); err != nil {
+		t.Fatalf("set remote description: %v", err)
+	}
+	return offerer, answerer
+}
@@ -24,6 +24,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pion/datachannel"
 	"github.com/pion/webrtc/v3"
@@ -31,6 +32,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/common"
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/relay"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 )
 
@@ -70,6 +72,15 @@ type PeerConnectionClient struct {
 	closed chan struct{}
 	// logger is the logger to use for the connection.
 	logger *slog.Logger
+	// state is the current state of the connection.
+	state ConnectionState
+	// statemu protects state.
+	statemu sync.Mutex
+	// acks are channels waiting for the remote side to acknowledge a
+	// connection channel. They are only used with ProtocolGRPC.
+	acks map[uint32]chan struct{}
+	// acksmu protects acks.
+	acksmu sync.Mutex
 }
 
 // NewPeerConnectionClient creates a new peer connection client.
@@ -95,10 +106,14 @@ func NewPeerConnectionClient(ctx context.Context, protocol string, rt transport.
 	// Build the peer connection
 	pc := &PeerConnectionClient{
 		PeerConnection: p,
+		protocol:       protocol,
+		rt:             rt,
+		state:          StateConnecting,
 		errors:         make(chan error, 5),
 		ready:          make(chan struct{}),
 		closed:         make(chan struct{}),
 		logger:         log,
+		acks:           make(map[uint32]chan struct{}),
 	}
 	// Create the negotiation data channel
 	d, err := pc.CreateDataChannel(
@@ -124,15 +139,44 @@ func NewPeerConnectionClient(ctx context.Context, protocol string, rt transport.
 			pc.errors <- fmt.Errorf("failed to send ICE candidate: %w", err)
 		}
 	})
-	var mu sync.Mutex
+	// If the signaling transport can deliver ICE restart offers we keep it
+	// open for the life of the connection and wait for the remote side to
+	// restart ICE when the connection fails.
+	restartrt, canRestart := rt.(transport.WebRTCRestartSignalTransport)
+	if canRestart {
+		go pc.handleRestartOffers(restartrt)
+	}
+	var restartTimer *time.Timer
 	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		mu.Lock()
-		defer mu.Unlock()
+		pc.statemu.Lock()
+		defer pc.statemu.Unlock()
 		pc.logger.Debug("Peer connection state has changed", "state", s.String())
 		if s == webrtc.PeerConnectionStateConnected {
-			defer rt.Close()
+			pc.state = StateConnected
+			if restartTimer != nil {
+				restartTimer.Stop()
+				restartTimer = nil
+			}
+			if !canRestart {
+				defer rt.Close()
+			}
+		}
+		if s == webrtc.PeerConnectionStateFailed && canRestart && pc.state != StateClosed {
+			if pc.state != StateReconnecting {
+				pc.logger.Info("Peer connection failed, waiting for ICE restart")
+				pc.state = StateReconnecting
+				restartTimer = time.AfterFunc(DefaultICERestartTimeout*DefaultMaxICERestarts, func() {
+					pc.logger.Warn("Timed out waiting for ICE restart, closing connection")
+					pc.Close()
+				})
+			}
+			return
 		}
 		if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateClosed {
+			pc.state = StateClosed
+			if canRestart {
+				defer rt.Close()
+			}
 			defer pc.Close()
 			select {
 			case <-pc.closed:
@@ -150,11 +194,21 @@ func NewPeerConnectionClient(ctx context.Context, protocol string, rt transport.
 			return
 		}
 		pc.channels = detached.(*datachannel.DataChannel)
+		if pc.protocol == ProtocolGRPC {
+			go pc.readAcks()
+		}
 	})
 	go pc.negotiate(rt.RemoteDescription())
 	return pc, nil
 }
 
+// State returns the current state of the connection.
+func (pc *PeerConnectionClient) State() ConnectionState {
+	pc.statemu.Lock()
+	defer pc.statemu.Unlock()
+	return pc.state
+}
+
 // Errors returns a channel for receiving errors from the peer connection.
 func (pc *PeerConnectionClient) Errors() <-chan error { return pc.errors }
 
@@ -203,6 +257,15 @@ func (pc *PeerConnectionClient) Serve(ctx context.Context, l net.Listener) error
 // Handle handles the given connection.
 func (pc *PeerConnectionClient) Handle(conn net.Conn) {
 	connNumber := pc.count.Add(1)
+	var ack chan struct{}
+	if pc.protocol == ProtocolGRPC {
+		// The remote side must create its end of the channel before we
+		// send on it, otherwise the first messages are dropped.
+		ack = make(chan struct{})
+		pc.acksmu.Lock()
+		pc.acks[connNumber] = ack
+		pc.acksmu.Unlock()
+	}
 	if err := binary.Write(pc.channels, binary.BigEndian, connNumber); err != nil {
 		pc.errors <- fmt.Errorf("failed to write to negotiation data channel: %w", err)
 		return
@@ -222,27 +285,52 @@ func (pc *PeerConnectionClient) Handle(conn net.Conn) {
 		pc.logger.Debug("Connection data channel closed:", "conn", connNumber)
 	})
 	d.OnOpen(func() {
-		pc.logger.Debug("Connection data channel opened, proxying:", "conn", connNumber)
 		defer conn.Close()
 		defer d.Close()
+		if ack != nil {
+			select {
+			case <-ack:
+			case <-pc.closed:
+				return
+			}
+		}
+		pc.logger.Debug("Connection data channel opened, proxying:", "conn", connNumber)
 		rw, err := d.Detach()
 		if err != nil {
 			pc.errors <- fmt.Errorf("failed to detach connection data channel: %w", err)
 			return
 		}
 		go func() {
-			_, err := io.Copy(rw, conn)
+			_, err := relay.CopyBuffer(rw, conn, relay.DefaultStreamBuffer)
 			if err != nil {
 				pc.errors <- fmt.Errorf("failed to proxy data to data channel: %w", err)
 			}
 		}()
-		_, err = io.Copy(conn, rw)
+		_, err = relay.CopyBuffer(conn, rw, relay.DefaultStreamBuffer)
 		if err != nil {
 			pc.errors <- fmt.Errorf("failed to proxy data from data channel: %w", err)
 		}
 	})
 }
 
+// readAcks reads connection channel acknowledgements from the negotiation
+// channel and releases the connections waiting on them.
+func (pc *PeerConnectionClient) readAcks() {
+	for {
+		var channelID uint32
+		if err := binary.Read(pc.channels, binary.BigEndian, &channelID); err != nil {
+			pc.logger.Debug("Stopped reading channel acknowledgements", "error", err.Error())
+			return
+		}
+		pc.acksmu.Lock()
+		if ack, ok := pc.acks[channelID]; ok {
+			close(ack)
+			delete(pc.acks, channelID)
+		}
+		pc.acksmu.Unlock()
+	}
+}
+
 func (pc *PeerConnectionClient) negotiate(offer webrtc.SessionDescription) {
 	defer pc.rt.Close()
 	// Set the remote SessionDescription
@@ -272,3 +360,27 @@ func (pc *PeerConnectionClient) negotiate(offer webrtc.SessionDescription) {
 		}
 	}
 }
+
+func (pc *PeerConnectionClient) handleRestartOffers(rt transport.WebRTCRestartSignalTransport) {
+	for {
+		select {
+		case <-pc.closed:
+			return
+		case offer := <-rt.RestartOffers():
+			pc.logger.Info("Received ICE restart offer, renegotiating")
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultICERestartTimeout)
+			answer, err := AnswerRestart(ctx, pc.PeerConnection, offer)
+			if err == nil {
+				err = rt.SendDescription(ctx, answer)
+			}
+			cancel()
+			if err != nil {
+				select {
+				case pc.errors <- fmt.Errorf("failed to answer ICE restart: %w", err):
+				default:
+					pc.logger.Error("Failed to answer ICE restart", "error", err.Error())
+				}
+			}
+		}
+	}
+}
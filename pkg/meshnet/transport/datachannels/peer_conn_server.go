@@ -20,16 +20,17 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v3"
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/common"
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/relay"
 )
 
 // PeerConnectionServer represents a connection to a peer where we
@@ -48,16 +49,29 @@ type PeerConnectionServer struct {
 	srcAddress string
 	// address is the destination address of the connection.
 	dstAddress string
+	// handler handles incoming connections instead of dialing dstAddress.
+	handler func(net.Conn)
 	// logger is the logger to use for the connection.
 	logger *slog.Logger
-	// candidatec is a channel that receives ICE candidates.
+	// candidatec is a channel that receives ICE candidates. It is closed
+	// when gathering completes or times out.
 	candidatec chan string
+	// gatherc is a channel that is closed when gathering completes or times out.
+	gatherc chan struct{}
+	// gathermu protects candidatec and gatherc.
+	gathermu sync.Mutex
 	// closec is a channel that is closed when the connection is closed.
 	closec chan struct{}
 	// readyc is a channel that is closed when the connection is ready.
 	readyc chan struct{}
 	// dataChannel is the data channel used for the connection.
 	channels *webrtc.DataChannel
+	// restarter performs ICE restarts when enabled.
+	restarter *ICERestarter
+	// statemu protects readyc and restarter.
+	statemu sync.Mutex
+	// ackmu serializes channel acknowledgements on the negotiation channel.
+	ackmu sync.Mutex
 }
 
 // Offer represents an offer to be sent to a peer.
@@ -72,6 +86,13 @@ type OfferOptions struct {
 	DstAddress string
 	// STUNServers is a list of STUN servers to use for the connection.
 	STUNServers []string
+	// ICEGatheringTimeout is the maximum time to wait for ICE candidate
+	// gathering to complete. Once it expires, setup proceeds with the
+	// candidates gathered so far. Zero waits for gathering to complete.
+	ICEGatheringTimeout time.Duration
+	// Handler, when set, is passed incoming connections instead of
+	// dialing DstAddress. The handler takes ownership of the connection.
+	Handler func(net.Conn)
 }
 
 // NewPeerConnectionServer creates a new peer connection server with the given options.
@@ -102,29 +123,44 @@ func NewPeerConnectionServer(ctx context.Context, opts *OfferOptions) (*PeerConn
 		proto:          opts.Proto,
 		srcAddress:     opts.SrcAddress,
 		dstAddress:     opts.DstAddress,
+		handler:        opts.Handler,
 		logger: context.LoggerFrom(ctx).With(
 			slog.String("proto", opts.Proto),
 			slog.String("src", opts.SrcAddress),
 			slog.String("dst", opts.DstAddress),
 		),
 		candidatec: make(chan string, 16),
+		gatherc:    make(chan struct{}),
 		readyc:     make(chan struct{}),
 		closec:     make(chan struct{}),
 	}
-	var mu sync.Mutex
 	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
-		mu.Lock()
-		defer mu.Unlock()
+		pc.statemu.Lock()
+		defer pc.statemu.Unlock()
 		pc.logger.Debug("ICE connection state changed", slog.String("state", state.String()))
 		if state == webrtc.ICEConnectionStateConnected {
+			if pc.restarter != nil {
+				pc.restarter.OnConnected()
+			}
 			select {
 			case <-pc.closec:
 				return
+			case <-pc.readyc:
+				// We are reconnected after an ICE restart.
+				pc.logger.Info("ICE connection re-established")
+				return
 			default:
 				close(pc.readyc)
 			}
 		}
+		if state == webrtc.ICEConnectionStateFailed && pc.restarter != nil && pc.restarter.OnFailed() {
+			pc.logger.Info("ICE connection failed, attempting ICE restart")
+			return
+		}
 		if state == webrtc.ICEConnectionStateFailed || state == webrtc.ICEConnectionStateCompleted {
+			if pc.restarter != nil {
+				pc.restarter.OnClosed()
+			}
 			select {
 			case <-pc.closec:
 				return
@@ -139,6 +175,21 @@ func NewPeerConnectionServer(ctx context.Context, opts *OfferOptions) (*PeerConn
 		}
 	})
 	pc.OnICECandidate(pc.onICECandidate)
+	if opts.ICEGatheringTimeout > 0 {
+		timer := time.AfterFunc(opts.ICEGatheringTimeout, func() {
+			if pc.finishGathering() {
+				pc.logger.Warn("ICE gathering timed out, proceeding with partial candidates",
+					slog.Duration("timeout", opts.ICEGatheringTimeout))
+			}
+		})
+		go func() {
+			select {
+			case <-pc.gatherc:
+			case <-pc.closec:
+			}
+			timer.Stop()
+		}()
+	}
 	dc, err := pc.CreateDataChannel(
 		v1.DataChannel_CHANNELS.String(), &webrtc.DataChannelInit{
 			Protocol:   common.Pointer("tcp"),
@@ -181,11 +232,19 @@ func (pc *PeerConnectionServer) AnswerOffer(answer string) error {
 }
 
 // Candidates returns a channel that will receive potential
-// ICE candidates for the peer.
+// ICE candidates for the peer. It is closed once gathering
+// completes or times out.
 func (pc *PeerConnectionServer) Candidates() <-chan string {
 	return pc.candidatec
 }
 
+// GatheringDone returns a channel that will be closed when ICE candidate
+// gathering has completed or timed out. No more candidates will be sent
+// on Candidates after this.
+func (pc *PeerConnectionServer) GatheringDone() <-chan struct{} {
+	return pc.gatherc
+}
+
 // AddCandidate adds an ICE candidate to the peer connection.
 func (pc *PeerConnectionServer) AddCandidate(cand string) error {
 	var candidate webrtc.ICECandidateInit
@@ -208,6 +267,33 @@ func (pc *PeerConnectionServer) Ready() <-chan struct{} {
 	return pc.readyc
 }
 
+// EnableICERestart enables ICE restarts when the connection fails. Restart
+// offers are exchanged with the remote peer over the given signaler.
+func (pc *PeerConnectionServer) EnableICERestart(ctx context.Context, signaler RestartSignaler, opts ICERestartOptions) {
+	pc.statemu.Lock()
+	defer pc.statemu.Unlock()
+	pc.restarter = NewICERestarter(ctx, pc.PeerConnection, signaler, opts)
+}
+
+// State returns the current state of the connection.
+func (pc *PeerConnectionServer) State() ConnectionState {
+	pc.statemu.Lock()
+	restarter := pc.restarter
+	pc.statemu.Unlock()
+	if restarter != nil {
+		return restarter.State()
+	}
+	if pc.IsClosed() {
+		return StateClosed
+	}
+	select {
+	case <-pc.readyc:
+		return StateConnected
+	default:
+		return StateConnecting
+	}
+}
+
 // IsClosed returns true if the peer connection is closed.
 func (pc *PeerConnectionServer) IsClosed() bool {
 	select {
@@ -220,10 +306,38 @@ func (pc *PeerConnectionServer) IsClosed() bool {
 
 func (pc *PeerConnectionServer) onICECandidate(c *webrtc.ICECandidate) {
 	if c == nil {
+		// Gathering is complete.
+		pc.finishGathering()
 		return
 	}
+	pc.gathermu.Lock()
+	defer pc.gathermu.Unlock()
+	select {
+	case <-pc.gatherc:
+		pc.logger.Debug("Dropping ICE candidate gathered after timeout", slog.Any("candidate", c))
+		return
+	default:
+	}
 	pc.logger.Debug("Received ICE candidate", slog.Any("candidate", c))
-	pc.candidatec <- c.ToJSON().Candidate
+	select {
+	case pc.candidatec <- c.ToJSON().Candidate:
+	case <-pc.closec:
+	}
+}
+
+// finishGathering stops forwarding candidates. It returns false if
+// gathering had already finished.
+func (pc *PeerConnectionServer) finishGathering() bool {
+	pc.gathermu.Lock()
+	defer pc.gathermu.Unlock()
+	select {
+	case <-pc.gatherc:
+		return false
+	default:
+	}
+	close(pc.gatherc)
+	close(pc.candidatec)
+	return true
 }
 
 func (pc *PeerConnectionServer) onDataChannelClose() {
@@ -280,14 +394,31 @@ func (pc *PeerConnectionServer) onDataChannelOpen() {
 			log.Debug("data channel has closed")
 		})
 		d.OnOpen(func() {
-			log.Info("data channel has opened, dialing remote")
-			defer d.Close()
 			dconn, err := d.Detach()
 			if err != nil {
 				log.Error("failed to detach data channel",
 					slog.String("error", err.Error()))
+				_ = d.Close()
 				return
 			}
+			if pc.proto == ProtocolGRPC {
+				// Let the client know it can start sending on the channel.
+				pc.ackmu.Lock()
+				err := binary.Write(rw, binary.BigEndian, channelID)
+				pc.ackmu.Unlock()
+				if err != nil {
+					log.Error("failed to acknowledge data channel", slog.String("error", err.Error()))
+					_ = d.Close()
+					return
+				}
+			}
+			if pc.handler != nil {
+				log.Info("data channel has opened, passing to handler")
+				pc.handler(NewStreamConn(dconn, StreamAddr(pc.dstAddress), StreamAddr(pc.srcAddress)))
+				return
+			}
+			log.Info("data channel has opened, dialing remote")
+			defer d.Close()
 			conn, err := net.Dial(pc.proto, pc.dstAddress)
 			if err != nil {
 				log.Error("failed to dial remote", slog.String("error", err.Error()))
@@ -296,13 +427,13 @@ func (pc *PeerConnectionServer) onDataChannelOpen() {
 			defer conn.Close()
 			log.Info("connected to remote")
 			go func() {
-				_, err := io.Copy(conn, dconn)
+				_, err := relay.CopyBuffer(conn, dconn, relay.DefaultStreamBuffer)
 				if err != nil {
 					log.Error("failed to copy from data channel to remote",
 						slog.String("error", err.Error()))
 				}
 			}()
-			_, err = io.Copy(dconn, conn)
+			_, err = relay.CopyBuffer(dconn, conn, relay.DefaultStreamBuffer)
 			if err != nil {
 				log.Error("failed to copy from remote to data channel",
 					slog.String("error", err.Error()))
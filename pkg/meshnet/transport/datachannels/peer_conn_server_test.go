@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestICEGatheringTimeout(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// A STUN server that never responds stalls server reflexive gathering.
+	stun, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = stun.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := stun.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	timeout := 500 * time.Millisecond
+	start := time.Now()
+	pc, err := NewPeerConnectionServer(ctx, &OfferOptions{
+		DstAddress:          "127.0.0.1:8080",
+		STUNServers:         []string{"stun:" + stun.LocalAddr().String()},
+		ICEGatheringTimeout: timeout,
+	})
+	if err != nil {
+		t.Fatalf("new peer connection server: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	var candidates []string
+	deadline := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case cand, ok := <-pc.Candidates():
+			if !ok {
+				done = true
+				continue
+			}
+			candidates = append(candidates, cand)
+		case <-deadline:
+			t.Fatal("timed out waiting for ICE gathering to finish")
+		}
+	}
+	elapsed := time.Since(start)
+	select {
+	case <-pc.GatheringDone():
+	default:
+		t.Fatal("expected gathering to be marked done")
+	}
+	if elapsed < timeout {
+		t.Errorf("expected gathering to finish at the timeout, finished after %s", elapsed)
+	}
+	var host bool
+	for _, cand := range candidates {
+		if strings.Contains(cand, "typ host") {
+			host = true
+		}
+		if strings.Contains(cand, "typ srflx") {
+			t.Errorf("did not expect a server reflexive candidate, got %q", cand)
+		}
+	}
+	if !host {
+		t.Errorf("expected at least one host candidate, got %v", candidates)
+	}
+}
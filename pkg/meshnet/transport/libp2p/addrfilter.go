@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/multiformats/go-multiaddr"
+	mnet "github.com/multiformats/go-multiaddr/net"
+)
+
+// defaultListenAddrs are the listen addresses used by libp2p when none are
+// configured. They are expanded against the local interfaces when listen
+// filters are set.
+var defaultListenAddrs = []string{
+	"/ip4/0.0.0.0/tcp/0",
+	"/ip4/0.0.0.0/udp/0/quic-v1",
+	"/ip4/0.0.0.0/udp/0/quic-v1/webtransport",
+	"/ip6/::/tcp/0",
+	"/ip6/::/udp/0/quic-v1",
+	"/ip6/::/udp/0/quic-v1/webtransport",
+}
+
+// FilterAddrs returns the addresses whose leading IP component falls within
+// one of the given prefixes. Addresses that do not start with an IP, such as
+// DNS addresses, are passed through unchanged. If prefixes is empty, addrs is
+// returned as is.
+func FilterAddrs(addrs []multiaddr.Multiaddr, prefixes []netip.Prefix) []multiaddr.Multiaddr {
+	if len(prefixes) == 0 {
+		return addrs
+	}
+	out := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		ip, err := mnet.ToIP(addr)
+		if err != nil {
+			out = append(out, addr)
+			continue
+		}
+		if containsAddr(prefixes, ip) {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+// listenAddrsFor returns the addresses to listen on given the configured
+// listen addresses and filters. Unspecified addresses are replaced with each
+// matching local interface address of the same family.
+func listenAddrsFor(addrs []multiaddr.Multiaddr, prefixes []netip.Prefix) ([]multiaddr.Multiaddr, error) {
+	if len(addrs) == 0 {
+		addrs = ToMultiaddrs(defaultListenAddrs)
+	}
+	ifaddrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("list interface addresses: %w", err)
+	}
+	out := expandListenAddrs(addrs, ifaddrs, prefixes)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no listen addresses match the configured filters")
+	}
+	return out, nil
+}
+
+// expandListenAddrs replaces unspecified IP addresses in addrs with each of the
+// given interface addresses of the same family, and then drops any address
+// outside of the prefixes.
+func expandListenAddrs(addrs []multiaddr.Multiaddr, ifaddrs []netip.Addr, prefixes []netip.Prefix) []multiaddr.Multiaddr {
+	var out []multiaddr.Multiaddr
+	for _, addr := range addrs {
+		first, rest := multiaddr.SplitFirst(addr)
+		if first == nil {
+			continue
+		}
+		code := first.Protocol().Code
+		if code != multiaddr.P_IP4 && code != multiaddr.P_IP6 {
+			out = append(out, addr)
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(first.RawValue())
+		if !ok || !ip.Unmap().IsUnspecified() {
+			out = append(out, addr)
+			continue
+		}
+		for _, ifaddr := range ifaddrs {
+			if ifaddr.Is4() != (code == multiaddr.P_IP4) {
+				continue
+			}
+			if len(prefixes) > 0 && !containsAddr(prefixes, ifaddr.AsSlice()) {
+				continue
+			}
+			comp, err := mnet.FromIP(ifaddr.AsSlice())
+			if err != nil {
+				continue
+			}
+			if rest != nil {
+				comp = comp.Encapsulate(rest)
+			}
+			out = append(out, comp)
+		}
+	}
+	return FilterAddrs(out, prefixes)
+}
+
+// interfaceAddrs returns the unicast addresses assigned to the local interfaces.
+// IPv6 link-local addresses are skipped since they cannot be listened on without
+// a zone.
+func interfaceAddrs() ([]netip.Addr, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]netip.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipnet.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.Is6() && ip.IsLinkLocalUnicast() {
+			continue
+		}
+		out = append(out, ip)
+	}
+	return out, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	mnet "github.com/multiformats/go-multiaddr/net"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestAddrFilters(t *testing.T) {
+	t.Parallel()
+
+	t.Run("FilterAddrs", func(t *testing.T) {
+		addrs := ToMultiaddrs([]string{
+			"/ip4/10.0.0.1/tcp/4001",
+			"/ip4/192.168.1.10/udp/4001/quic-v1",
+			"/ip6/fd00::1/tcp/4001",
+			"/ip6/2001:db8::1/tcp/4001",
+			"/dns4/example.com/tcp/4001",
+		})
+		prefixes := []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("fd00::/8"),
+		}
+		got := FilterAddrs(addrs, prefixes)
+		want := []string{
+			"/ip4/10.0.0.1/tcp/4001",
+			"/ip6/fd00::1/tcp/4001",
+			"/dns4/example.com/tcp/4001",
+		}
+		assertAddrs(t, got, want)
+		// No prefixes should leave the addresses untouched.
+		if got := FilterAddrs(addrs, nil); len(got) != len(addrs) {
+			t.Fatalf("expected %d addresses, got %d", len(addrs), len(got))
+		}
+	})
+
+	t.Run("ExpandListenAddrs", func(t *testing.T) {
+		addrs := ToMultiaddrs([]string{
+			"/ip4/0.0.0.0/tcp/0",
+			"/ip6/::/udp/0/quic-v1",
+			"/ip4/192.168.1.10/tcp/4001",
+		})
+		ifaddrs := []netip.Addr{
+			netip.MustParseAddr("10.0.0.1"),
+			netip.MustParseAddr("192.168.1.10"),
+			netip.MustParseAddr("fd00::1"),
+			netip.MustParseAddr("2001:db8::1"),
+		}
+		prefixes := []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("fd00::/8"),
+		}
+		got := expandListenAddrs(addrs, ifaddrs, prefixes)
+		want := []string{
+			"/ip4/10.0.0.1/tcp/0",
+			"/ip6/fd00::1/udp/0/quic-v1",
+		}
+		assertAddrs(t, got, want)
+	})
+
+	t.Run("AdvertisedAddrs", func(t *testing.T) {
+		ctx := context.Background()
+		loopback := netip.MustParsePrefix("127.0.0.0/8")
+		announce := netip.MustParsePrefix("127.0.0.1/32")
+		host, err := NewHost(ctx, HostOptions{
+			LocalAddrs:      ToMultiaddrs([]string{"/ip4/0.0.0.0/tcp/0", "/ip4/0.0.0.0/udp/0/quic-v1"}),
+			ListenFilters:   []netip.Prefix{loopback},
+			AnnounceFilters: []netip.Prefix{announce},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer host.Close()
+		listening, err := host.Host().Network().InterfaceListenAddresses()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, addr := range listening {
+			ip, err := mnet.ToIP(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !containsAddr([]netip.Prefix{loopback}, ip) {
+				t.Errorf("host is listening on non-permitted address %s", addr)
+			}
+		}
+		advertised := host.Host().Addrs()
+		if len(advertised) == 0 {
+			t.Fatal("expected host to advertise at least one address")
+		}
+		for _, addr := range advertised {
+			ip, err := mnet.ToIP(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !containsAddr([]netip.Prefix{announce}, ip) {
+				t.Errorf("host is advertising non-permitted address %s", addr)
+			}
+		}
+	})
+}
+
+func assertAddrs(t *testing.T, got []multiaddr.Multiaddr, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected addresses %v, got %v", want, got)
+	}
+	for i, addr := range got {
+		if addr.String() != want[i] {
+			t.Errorf("expected address %s at index %d, got %s", want[i], i, addr)
+		}
+	}
+}
@@ -500,8 +500,15 @@ func (t *WebmeshTransport) startNode(ctx context.Context, laddr ma.Multiaddr) (m
 	}
 	meshConfig.Key = t.key
 	node := meshnode.NewWithLogger(log, meshConfig)
-	storageProvider, err := conf.NewStorageProvider(ctx, node, conf.Bootstrap.Force)
+	mux, err := conf.NewMux()
 	if err != nil {
+		return nil, fmt.Errorf("failed to create connection muxer: %w", err)
+	}
+	storageProvider, err := conf.NewStorageProvider(ctx, node, mux, conf.Bootstrap.Force)
+	if err != nil {
+		if mux != nil {
+			_ = mux.Close()
+		}
 		return nil, fmt.Errorf("failed to create storage provider: %w", err)
 	}
 	connectOpts, err := conf.NewConnectOptions(ctx, node, storageProvider, nil)
@@ -537,7 +544,7 @@ func (t *WebmeshTransport) startNode(ctx context.Context, laddr ma.Multiaddr) (m
 	})
 
 	// Start any mesh services
-	srvOpts, err := conf.Services.NewServiceOptions(ctx, node)
+	srvOpts, err := conf.Services.NewServiceOptions(ctx, node, mux)
 	if err != nil {
 		return nil, handleErr(fmt.Errorf("failed to create service options: %w", err))
 	}
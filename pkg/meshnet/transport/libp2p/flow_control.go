@@ -0,0 +1,309 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFlowControlWindow is the default receive window for flow controlled
+// connections.
+const DefaultFlowControlWindow = 256 * 1024
+
+// ErrFlowControlViolation is returned when a peer sends more data than it was
+// granted credit for.
+var ErrFlowControlViolation = errors.New("peer exceeded flow control window")
+
+const (
+	frameData byte = iota
+	frameCredit
+	frameWindow
+)
+
+const (
+	frameHeaderSize = 5
+	maxFrameSize    = 16 * 1024
+)
+
+// NewFlowControlledConn wraps a connection with credit-based flow control.
+// The remote end of the connection must also be wrapped. Each side advertises
+// a receive window and the writer may only send as many bytes as the reader
+// has granted. Reads return credit to the writer. Writes block when the window
+// is exhausted until credit is returned or the write deadline passes, so a
+// slow reader never causes more than window bytes to be buffered.
+func NewFlowControlledConn(conn net.Conn, window int) net.Conn {
+	if window <= 0 {
+		window = DefaultFlowControlWindow
+	}
+	fc := &flowConn{
+		Conn:   conn,
+		window: window,
+		signal: make(chan struct{}),
+	}
+	go fc.readFrames()
+	// The window is sent asynchronously so that construction never blocks
+	// on an unbuffered transport.
+	go func() {
+		if err := fc.writeFrame(frameWindow, uint32(window), nil); err != nil {
+			fc.fail(err)
+		}
+	}()
+	return fc
+}
+
+type flowConn struct {
+	net.Conn
+	window int
+
+	// wmu serializes frames written to the underlying connection.
+	wmu sync.Mutex
+
+	mu            sync.Mutex
+	signal        chan struct{}
+	credits       int
+	buf           bytes.Buffer
+	pendingCredit int
+	err           error
+	closed        bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// scratch is only used by readFrames.
+	scratch [maxFrameSize]byte
+}
+
+// buffered returns the number of received bytes not yet read.
+func (c *flowConn) buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Len()
+}
+
+// Read reads buffered data from the connection and returns credit to the
+// remote writer.
+func (c *flowConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.mu.Lock()
+	for c.buf.Len() == 0 {
+		if c.closed {
+			c.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		if err := c.wait(c.readDeadline); err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+	}
+	n, _ := c.buf.Read(p)
+	c.pendingCredit += n
+	// Batch credit updates unless the buffer has been drained, in which
+	// case the writer may be waiting on all of its credit.
+	var credit int
+	if c.buf.Len() == 0 || c.pendingCredit >= c.window/4 {
+		credit = c.pendingCredit
+		c.pendingCredit = 0
+	}
+	c.mu.Unlock()
+	if credit > 0 {
+		if err := c.writeFrame(frameCredit, uint32(credit), nil); err != nil {
+			c.fail(err)
+		}
+	}
+	return n, nil
+}
+
+// Write writes data to the connection, blocking while the remote window is
+// exhausted.
+func (c *flowConn) Write(p []byte) (int, error) {
+	var written int
+	for written < len(p) {
+		c.mu.Lock()
+		for c.credits == 0 {
+			if c.closed {
+				c.mu.Unlock()
+				return written, net.ErrClosed
+			}
+			if c.err != nil {
+				err := c.err
+				c.mu.Unlock()
+				return written, err
+			}
+			if err := c.wait(c.writeDeadline); err != nil {
+				c.mu.Unlock()
+				return written, err
+			}
+		}
+		size := min(len(p)-written, c.credits, maxFrameSize)
+		c.credits -= size
+		c.mu.Unlock()
+		err := c.writeFrame(frameData, uint32(size), p[written:written+size])
+		if err != nil {
+			c.fail(err)
+			return written, err
+		}
+		written += size
+	}
+	return written, nil
+}
+
+// Close closes the connection and wakes any blocked readers and writers.
+func (c *flowConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.broadcast()
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *flowConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future and pending reads. The
+// underlying connection keeps reading so that credit updates are processed.
+func (c *flowConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future and pending writes, including
+// writes waiting on the flow control window.
+func (c *flowConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.broadcast()
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// wait releases the lock until the state changes or the deadline passes.
+// It must be called with the lock held.
+func (c *flowConn) wait(deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	signal := c.signal
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	select {
+	case <-signal:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// broadcast wakes all waiters. It must be called with the lock held.
+func (c *flowConn) broadcast() {
+	close(c.signal)
+	c.signal = make(chan struct{})
+}
+
+func (c *flowConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.broadcast()
+}
+
+func (c *flowConn) writeFrame(typ byte, value uint32, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var header [frameHeaderSize]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], value)
+	if _, err := c.Conn.Write(header[:]); err != nil {
+		return err
+	}
+	if len(payload) > 0 {
+		if _, err := c.Conn.Write(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *flowConn) readFrames() {
+	var header [frameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			c.fail(err)
+			return
+		}
+		value := int(binary.BigEndian.Uint32(header[1:]))
+		switch header[0] {
+		case frameWindow, frameCredit:
+			c.mu.Lock()
+			c.credits += value
+			c.broadcast()
+			c.mu.Unlock()
+		case frameData:
+			c.mu.Lock()
+			if value > maxFrameSize || c.buf.Len()+value > c.window {
+				c.mu.Unlock()
+				c.fail(ErrFlowControlViolation)
+				return
+			}
+			c.mu.Unlock()
+			// Reads from the underlying connection happen without the lock so
+			// that readers and writers are never blocked on the network.
+			payload := c.scratch[:value]
+			if _, err := io.ReadFull(c.Conn, payload); err != nil {
+				c.fail(err)
+				return
+			}
+			c.mu.Lock()
+			c.buf.Write(payload)
+			c.broadcast()
+			c.mu.Unlock()
+		default:
+			c.fail(fmt.Errorf("unknown flow control frame type %d", header[0]))
+			return
+		}
+	}
+}
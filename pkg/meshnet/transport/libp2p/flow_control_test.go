@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlowControlledConn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		t.Parallel()
+		a, b := net.Pipe()
+		client := NewFlowControlledConn(a, 1024)
+		server := NewFlowControlledConn(b, 1024)
+		defer client.Close()
+		defer server.Close()
+		payload := make([]byte, 64*1024)
+		if _, err := rand.Read(payload); err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error, 1)
+		go func() {
+			_, err := client.Write(payload)
+			errs <- err
+		}()
+		got := make([]byte, len(payload))
+		if _, err := io.ReadFull(server, got); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatal("payload was corrupted")
+		}
+	})
+
+	t.Run("SlowReaderBoundsBuffering", func(t *testing.T) {
+		t.Parallel()
+		const window = 32 * 1024
+		a, b := net.Pipe()
+		writer := NewFlowControlledConn(a, window)
+		reader := NewFlowControlledConn(b, window).(*flowConn)
+		defer writer.Close()
+		defer reader.Close()
+
+		var written atomic.Int64
+		done := make(chan error, 1)
+		go func() {
+			chunk := make([]byte, 4*1024)
+			for i := 0; i < 256; i++ {
+				n, err := writer.Write(chunk)
+				written.Add(int64(n))
+				if err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+
+		// Read slowly and make sure the unread data never exceeds the window.
+		buf := make([]byte, 1024)
+		var read int64
+		var maxBuffered int
+		for read < 256*4*1024 {
+			time.Sleep(100 * time.Microsecond)
+			if n := reader.buffered(); n > maxBuffered {
+				maxBuffered = n
+			}
+			if inflight := written.Load() - read; inflight > window {
+				t.Fatalf("writer has %d bytes in flight, window is %d", inflight, window)
+			}
+			n, err := reader.Read(buf)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			read += int64(n)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if maxBuffered > window {
+			t.Errorf("reader buffered %d bytes, window is %d", maxBuffered, window)
+		}
+	})
+
+	t.Run("WriteBlocksWhenWindowExhausted", func(t *testing.T) {
+		t.Parallel()
+		const window = 8 * 1024
+		a, b := net.Pipe()
+		writer := NewFlowControlledConn(a, window)
+		reader := NewFlowControlledConn(b, window).(*flowConn)
+		defer writer.Close()
+		defer reader.Close()
+
+		// Nobody reads, so the write should stall once the window is used.
+		if err := writer.SetWriteDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := writer.Write(make([]byte, 4*window))
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+		if n != window {
+			t.Errorf("expected exactly the window (%d bytes) to be written, got %d", window, n)
+		}
+		if got := reader.buffered(); got != window {
+			t.Errorf("expected %d bytes buffered by the reader, got %d", window, got)
+		}
+	})
+}
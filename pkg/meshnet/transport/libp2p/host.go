@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
@@ -30,6 +31,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/record"
 	"github.com/multiformats/go-multiaddr"
 	mnet "github.com/multiformats/go-multiaddr/net"
@@ -72,6 +74,13 @@ type HostOptions struct {
 	// LocalAddrs is a list of local addresses to announce the host with.
 	// If empty or nil, the default local addresses will be used.
 	LocalAddrs []multiaddr.Multiaddr
+	// ListenFilters restricts the addresses the host listens on to those
+	// within the given prefixes. Unspecified listen addresses are expanded
+	// to the matching local interface addresses.
+	ListenFilters []netip.Prefix
+	// AnnounceFilters restricts the addresses the host advertises to peers
+	// and the DHT to those within the given prefixes.
+	AnnounceFilters []netip.Prefix
 	// ConnectTimeout is the timeout for connecting to peers when bootstrapping.
 	ConnectTimeout time.Duration
 	// UncertifiedPeerstore uses an uncertified peerstore for the host.
@@ -81,15 +90,23 @@ type HostOptions struct {
 	// NoFallbackDefaults disables the use of fallback defaults when creating
 	// the host. This is useful for testing.
 	NoFallbackDefaults bool
+	// FlowControlWindow enables credit-based flow control on outbound RPC
+	// streams with the given receive window in bytes. Peers that do not
+	// support flow control are dialed without it. Inbound flow controlled
+	// streams are always accepted.
+	FlowControlWindow int
 }
 
 // MarshalJSON implements json.Marshaler.
 func (o HostOptions) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]any{
-		"key":            "redacted",
-		"bootstrapPeers": o.BootstrapPeers,
-		"localAddrs":     o.LocalAddrs,
-		"connectTimeout": o.ConnectTimeout,
+		"key":               "redacted",
+		"bootstrapPeers":    o.BootstrapPeers,
+		"localAddrs":        o.LocalAddrs,
+		"listenFilters":     o.ListenFilters,
+		"announceFilters":   o.AnnounceFilters,
+		"connectTimeout":    o.ConnectTimeout,
+		"flowControlWindow": o.FlowControlWindow,
 	})
 }
 
@@ -98,9 +115,22 @@ func NewHost(ctx context.Context, opts HostOptions) (Host, error) {
 	if opts.Key != nil {
 		opts.Options = append(opts.Options, libp2p.Identity(opts.Key.AsIdentity()))
 	}
+	if len(opts.ListenFilters) > 0 {
+		addrs, err := listenAddrsFor(opts.LocalAddrs, opts.ListenFilters)
+		if err != nil {
+			return nil, fmt.Errorf("filter listen addresses: %w", err)
+		}
+		opts.LocalAddrs = addrs
+	}
 	if len(opts.LocalAddrs) > 0 {
 		opts.Options = append(opts.Options, libp2p.ListenAddrs(opts.LocalAddrs...))
 	}
+	if len(opts.AnnounceFilters) > 0 {
+		filters := opts.AnnounceFilters
+		opts.Options = append(opts.Options, libp2p.AddrsFactory(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+			return FilterAddrs(addrs, filters)
+		}))
+	}
 	if opts.ConnectTimeout > 0 {
 		opts.Options = append(opts.Options, libp2p.WithDialTimeout(opts.ConnectTimeout))
 	}
@@ -118,11 +148,12 @@ func NewHost(ctx context.Context, opts HostOptions) (Host, error) {
 	if err != nil {
 		return nil, fmt.Errorf("new libp2p host: %w", err)
 	}
-	return wrapHost(host), nil
+	return &libp2pHost{host: host, fcWindow: opts.FlowControlWindow}, nil
 }
 
 type libp2pHost struct {
 	host      host.Host
+	fcWindow  int
 	liscancel func()
 }
 
@@ -197,6 +228,9 @@ func (h *libp2pHost) RPCListener() net.Listener {
 	h.host.SetStreamHandler(RPCProtocol, func(stream network.Stream) {
 		ch <- NewConnFromStream(stream)
 	})
+	h.host.SetStreamHandler(RPCFlowControlProtocol, func(stream network.Stream) {
+		ch <- NewFlowControlledConn(NewConnFromStream(stream), h.fcWindow)
+	})
 	h.liscancel = cancel
 	return &hostRPCListener{
 		h:       h,
@@ -248,3 +282,24 @@ func (h *hostRPCListener) Addr() net.Addr {
 	addr, _ := mnet.ToNetAddr(addrs[0])
 	return addr
 }
+
+// newRPCConn opens an RPC stream to the given peer. Flow control is negotiated
+// when it is enabled on the host.
+func newRPCConn(ctx context.Context, h Host, pid peer.ID) (net.Conn, error) {
+	var window int
+	if lh, ok := h.(*libp2pHost); ok {
+		window = lh.fcWindow
+	}
+	protocols := []protocol.ID{RPCProtocol}
+	if window > 0 {
+		protocols = []protocol.ID{RPCFlowControlProtocol, RPCProtocol}
+	}
+	stream, err := h.Host().NewStream(ctx, pid, protocols...)
+	if err != nil {
+		return nil, fmt.Errorf("new stream: %w", err)
+	}
+	if stream.Protocol() == RPCFlowControlProtocol {
+		return NewFlowControlledConn(NewConnFromStream(stream), window), nil
+	}
+	return NewConnFromStream(stream), nil
+}
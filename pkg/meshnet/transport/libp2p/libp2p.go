@@ -38,6 +38,9 @@ const (
 	// RPCProtocol is the protocol used for executing RPCs against a mesh.
 	// The method should be appended to the end of the protocol.
 	RPCProtocol = protocol.ID("/webmesh/rpc/0.0.1")
+	// RPCFlowControlProtocol is the RPC protocol with credit-based flow
+	// control applied to the stream.
+	RPCFlowControlProtocol = protocol.ID("/webmesh/rpc-fc/0.0.1")
 	// RaftProtocol is the protocol used for webmesh raft.
 	// This is not used yet.
 	RaftProtocol = protocol.ID("/webmesh/raft/0.0.1")
@@ -137,7 +137,7 @@ func (rt *roundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ) (*RE
 		defer conn.Close()
 		log.Debug("Dial successful, invoking request")
 		var resp RESP
-		var callOpts []grpc.CallOption
+		callOpts := transport.ResponseHeaderCallOptions(ctx)
 		for _, cred := range rt.Credentials {
 			if callCred, ok := cred.(grpc.CallOption); ok {
 				log.Debug("Adding call option", "option", callCred)
@@ -181,7 +181,7 @@ func (rt *discoveryRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *
 	defer conn.Close()
 	log.Debug("Dial successful, invoking request")
 	var resp RESP
-	var callOpts []grpc.CallOption
+	callOpts := transport.ResponseHeaderCallOptions(ctx)
 	for _, cred := range rt.Credentials {
 		if callCred, ok := cred.(grpc.CallOption); ok {
 			log.Debug("Adding call option", "option", callCred)
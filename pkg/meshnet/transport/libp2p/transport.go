@@ -187,12 +187,12 @@ func (r *rpcTransport) Dial(ctx context.Context, id, address string) (transport.
 				return nil, fmt.Errorf("parse multiaddr: %w", err)
 			}
 			r.h.Host().Peerstore().AddAddr(pid, ma, peerstore.PermanentAddrTTL)
-			stream, err := r.h.Host().NewStream(ctx, pid, RPCProtocol)
+			conn, err := newRPCConn(ctx, r.h, pid)
 			if err != nil {
-				return nil, fmt.Errorf("new stream: %w", err)
+				return nil, err
 			}
 			return grpc.DialContext(ctx, "", append(r.creds, grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
-				return NewConnFromStream(stream), nil
+				return conn, nil
 			}))...)
 		} else {
 			// Generate a temporary peer ID and dial the address.
@@ -207,24 +207,24 @@ func (r *rpcTransport) Dial(ctx context.Context, id, address string) (transport.
 			}
 			r.h.Host().Peerstore().AddAddr(pid, ma, peerstore.PermanentAddrTTL)
 			defer r.h.Host().Peerstore().ClearAddrs(pid)
-			stream, err := r.h.Host().NewStream(ctx, pid, RPCProtocol)
+			conn, err := newRPCConn(ctx, r.h, pid)
 			if err != nil {
-				return nil, fmt.Errorf("new stream: %w", err)
+				return nil, err
 			}
 			return grpc.DialContext(ctx, "", append(r.creds, grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
-				return NewConnFromStream(stream), nil
+				return conn, nil
 			}))...)
 		}
 	}
 	// Next if we don't have an address but have an id, or have both, just dial the peer and
 	// let the peerstore handle the rest.
 	if (address == "" && id != "") || (address != "" && id != "") {
-		stream, err := r.h.Host().NewStream(ctx, pid, RPCProtocol)
+		conn, err := newRPCConn(ctx, r.h, pid)
 		if err != nil {
-			return nil, fmt.Errorf("new stream: %w", err)
+			return nil, err
 		}
 		return grpc.DialContext(ctx, "", append(r.creds, grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
-			return NewConnFromStream(stream), nil
+			return conn, nil
 		}))...)
 	}
 	// Try to find the peer with the given address.
@@ -239,12 +239,12 @@ func (r *rpcTransport) Dial(ctx context.Context, id, address string) (transport.
 			if !addr.Equal(ma) {
 				continue
 			}
-			stream, err := r.h.Host().NewStream(ctx, pid, RPCProtocol)
+			conn, err := newRPCConn(ctx, r.h, pid)
 			if err != nil {
-				return nil, fmt.Errorf("new stream: %w", err)
+				return nil, err
 			}
 			return grpc.DialContext(ctx, "", append(r.creds, grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
-				return NewConnFromStream(stream), nil
+				return conn, nil
 			}))...)
 		}
 	}
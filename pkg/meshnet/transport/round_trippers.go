@@ -21,6 +21,8 @@ import (
 	"io"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 // RoundTripper is a generic interface for executing a request and returning
@@ -44,6 +46,24 @@ func (f RoundTripperFunc[REQ, RESP]) Close() error {
 	return nil
 }
 
+type responseHeaderKey struct{}
+
+// WithResponseHeader returns a context that asks gRPC round trippers to store
+// the header of the response in md.
+func WithResponseHeader(ctx context.Context, md *metadata.MD) context.Context {
+	return context.WithValue(ctx, responseHeaderKey{}, md)
+}
+
+// ResponseHeaderCallOptions returns the gRPC call options needed to honor
+// a response header requested with WithResponseHeader.
+func ResponseHeaderCallOptions(ctx context.Context) []grpc.CallOption {
+	md, ok := ctx.Value(responseHeaderKey{}).(*metadata.MD)
+	if !ok || md == nil {
+		return nil
+	}
+	return []grpc.CallOption{grpc.Header(md)}
+}
+
 // JoinRoundTripper is the interface for joining a cluster.
 type JoinRoundTripper = RoundTripper[v1.JoinRequest, v1.JoinResponse]
 
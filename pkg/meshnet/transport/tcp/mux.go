@@ -0,0 +1,212 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tcp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// muxSniffTimeout is how long the connection muxer waits for the first byte
+// of a new connection before dropping it.
+const muxSniffTimeout = 10 * time.Second
+
+// muxAcceptTimeout is how long a routed connection waits to be accepted
+// before it is dropped.
+const muxAcceptTimeout = 10 * time.Second
+
+// maxRaftRPCType is the largest first byte routed to the raft transport.
+// Every connection from the raft network transport begins with a single byte
+// identifying the RPC type. gRPC connections begin with either the HTTP/2
+// client preface ("PRI") or a TLS handshake record (0x16), both well above it.
+const maxRaftRPCType = 0x0f
+
+// Mux splits the connections accepted on a single TCP listener between the
+// raft transport and a gRPC server, so that both can be served on one port.
+type Mux struct {
+	lis       net.Listener
+	raft      *muxListener
+	grpc      *muxListener
+	closec    chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenMux listens on the given address and starts routing the accepted
+// connections. The muxer stops listening once both of its listeners are
+// closed.
+func ListenMux(addr string) (*Mux, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", addr, err)
+	}
+	return NewMux(lis), nil
+}
+
+// NewMux starts routing the connections accepted on the given listener.
+func NewMux(lis net.Listener) *Mux {
+	m := &Mux{
+		lis:    lis,
+		closec: make(chan struct{}),
+	}
+	m.raft = newMuxListener(m)
+	m.grpc = newMuxListener(m)
+	go m.serve()
+	return m
+}
+
+// Raft returns the listener for raft connections.
+func (m *Mux) Raft() net.Listener {
+	return m.raft
+}
+
+// GRPC returns the listener for gRPC connections.
+func (m *Mux) GRPC() net.Listener {
+	return m.grpc
+}
+
+// Addr returns the address of the underlying listener.
+func (m *Mux) Addr() net.Addr {
+	return m.lis.Addr()
+}
+
+// Close stops the muxer and closes the underlying listener.
+func (m *Mux) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closec)
+		err = m.lis.Close()
+	})
+	return err
+}
+
+func (m *Mux) serve() {
+	for {
+		conn, err := m.lis.Accept()
+		if err != nil {
+			_ = m.Close()
+			return
+		}
+		go m.route(conn)
+	}
+}
+
+// route peeks at the first byte of the connection and hands it to the
+// matching listener.
+func (m *Mux) route(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(muxSniffTimeout))
+	first, err := r.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	target := m.grpc
+	if first[0] <= maxRaftRPCType {
+		target = m.raft
+	}
+	target.deliver(&sniffedConn{Conn: conn, r: r})
+}
+
+// listenerClosed closes the muxer once both of its listeners are closed.
+func (m *Mux) listenerClosed() {
+	if m.raft.isClosed() && m.grpc.isClosed() {
+		_ = m.Close()
+	}
+}
+
+// muxListener is one side of a Mux.
+type muxListener struct {
+	mux       *Mux
+	conns     chan net.Conn
+	closec    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMuxListener(m *Mux) *muxListener {
+	return &muxListener{
+		mux:    m,
+		conns:  make(chan net.Conn),
+		closec: make(chan struct{}),
+	}
+}
+
+// Accept waits for and returns the next connection routed to the listener.
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closec:
+		return nil, net.ErrClosed
+	case <-l.mux.closec:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener. The muxer keeps running until both of its
+// listeners are closed.
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closec)
+		l.mux.listenerClosed()
+	})
+	return nil
+}
+
+// Addr returns the address of the muxer.
+func (l *muxListener) Addr() net.Addr {
+	return l.mux.Addr()
+}
+
+// deliver hands the connection to the next call to Accept. The connection is
+// dropped if it is not accepted in time, so a side that is never served does
+// not pile up connections.
+func (l *muxListener) deliver(conn net.Conn) {
+	timer := time.NewTimer(muxAcceptTimeout)
+	defer timer.Stop()
+	select {
+	case l.conns <- conn:
+	case <-timer.C:
+		_ = conn.Close()
+	case <-l.closec:
+		_ = conn.Close()
+	case <-l.mux.closec:
+		_ = conn.Close()
+	}
+}
+
+func (l *muxListener) isClosed() bool {
+	select {
+	case <-l.closec:
+		return true
+	default:
+		return false
+	}
+}
+
+// sniffedConn is a connection whose first bytes were read ahead by the muxer.
+type sniffedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestMuxRaftAndGRPC(t *testing.T) {
+	ctx := context.Background()
+	const addr = "127.0.0.1:0"
+	mux, err := ListenMux(addr)
+	if err != nil {
+		t.Fatalf("listen mux: %v", err)
+	}
+	target := mux.Addr().String()
+
+	// The raft transport accepts its connections from the muxer.
+	server, err := NewRaftTransport(nil, RaftTransportOptions{
+		Addr:    addr,
+		Timeout: time.Second,
+		Mux:     mux,
+	})
+	if err != nil {
+		t.Fatalf("create raft transport: %v", err)
+	}
+	if server.AddrPort().String() != target {
+		t.Fatalf("expected raft transport on %s, got %s", target, server.AddrPort())
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(mux.GRPC()) }()
+
+	// Answer a vote request sent over the shared port.
+	go func() {
+		for rpc := range server.Consumer() {
+			if _, ok := rpc.Command.(*raft.RequestVoteRequest); !ok {
+				rpc.Respond(nil, nil)
+				continue
+			}
+			rpc.Respond(&raft.RequestVoteResponse{Term: 7, Granted: true}, nil)
+		}
+	}()
+	client, err := NewRaftTransport(nil, RaftTransportOptions{
+		Addr:    "127.0.0.1:0",
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("create raft client transport: %v", err)
+	}
+	defer client.Close()
+	var voteResp raft.RequestVoteResponse
+	err = client.RequestVote("server", raft.ServerAddress(target), &raft.RequestVoteRequest{
+		RPCHeader: raft.RPCHeader{ID: []byte("client"), Addr: []byte(client.LocalAddr())},
+		Term:      7,
+	}, &voteResp)
+	if err != nil {
+		t.Fatalf("request vote: %v", err)
+	}
+	if !voteResp.Granted || voteResp.Term != 7 {
+		t.Fatalf("unexpected vote response: %+v", voteResp)
+	}
+
+	// A gRPC call on the same port reaches the gRPC server.
+	conn, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial grpc: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	healthResp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	if healthResp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected health status: %v", healthResp.GetStatus())
+	}
+
+	// The port is released once both sides are closed.
+	srv.Stop()
+	select {
+	case <-mux.closec:
+		t.Fatal("expected muxer to keep running while raft is open")
+	default:
+	}
+	if err := server.Close(); err != nil {
+		t.Fatalf("close raft transport: %v", err)
+	}
+	select {
+	case <-mux.closec:
+	default:
+		t.Fatal("expected muxer to stop once both listeners are closed")
+	}
+}
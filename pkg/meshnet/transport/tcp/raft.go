@@ -37,11 +37,15 @@ type RaftTransportOptions struct {
 	MaxPool int
 	// Timeout is the timeout for dialing a connection.
 	Timeout time.Duration
+	// Mux is an optional connection muxer to accept raft connections from,
+	// so that the same port can also serve gRPC. When set, Addr is not
+	// listened on.
+	Mux *Mux
 }
 
 // NewRaftTransport creates a new TCP transport listening on the given address.
 func NewRaftTransport(leaderDialer transport.LeaderDialer, opts RaftTransportOptions) (transport.RaftTransport, error) {
-	sl, err := newTCPStreamLayer(opts.Addr)
+	sl, err := newTCPStreamLayer(opts.Addr, opts.Mux)
 	if err != nil {
 		return nil, fmt.Errorf("create TCP stream layer: %w", err)
 	}
@@ -74,10 +78,16 @@ type tcpStreamLayer struct {
 	*net.Dialer
 }
 
-func newTCPStreamLayer(addr string) (*tcpStreamLayer, error) {
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return nil, fmt.Errorf("listen %s: %w", addr, err)
+func newTCPStreamLayer(addr string, mux *Mux) (*tcpStreamLayer, error) {
+	var ln net.Listener
+	if mux != nil {
+		ln = mux.Raft()
+	} else {
+		var err error
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen %s: %w", addr, err)
+		}
 	}
 	return &tcpStreamLayer{
 		Listener: ln,
@@ -37,6 +37,8 @@ type RoundTripOptions struct {
 	// AddressTimeout is the timeout for dialing each address. If not set
 	// any timeout on the context will be used.
 	AddressTimeout time.Duration
+	// CallOptions are additional gRPC call options to use for the request.
+	CallOptions []grpc.CallOption
 }
 
 // NewJoinRoundTripper creates a new gRPC round tripper for issuing a Join Request.
@@ -60,8 +62,6 @@ type grpcRoundTripper[REQ, RESP any] struct {
 func (rt *grpcRoundTripper[REQ, RESP]) Close() error { return nil }
 
 func (rt *grpcRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ) (*RESP, error) {
-	var dialCtx context.Context
-	var cancel context.CancelFunc
 	var err error
 	t := NewGRPCTransport(TransportOptions{Credentials: rt.Credentials})
 	for _, addr := range rt.Addrs {
@@ -70,10 +70,9 @@ func (rt *grpcRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ)
 		}
 		log := context.LoggerFrom(ctx).With("join-addr", addr, "method", rt.method)
 		log.Debug("Attempting to dial node")
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
 		if rt.AddressTimeout > 0 {
 			dialCtx, cancel = context.WithTimeout(ctx, rt.AddressTimeout)
-		} else {
-			dialCtx = ctx
 		}
 		var conn transport.RPCClientConn
 		conn, err = t.Dial(dialCtx, "", addr)
@@ -85,7 +84,8 @@ func (rt *grpcRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ)
 		defer conn.Close()
 		log.Debug("Dial successful, invoking request")
 		var resp RESP
-		var callOpts []grpc.CallOption
+		callOpts := append([]grpc.CallOption{}, rt.CallOptions...)
+		callOpts = append(callOpts, transport.ResponseHeaderCallOptions(ctx)...)
 		for _, cred := range rt.Credentials {
 			if callCred, ok := cred.(grpc.CallOption); ok {
 				log.Debug("Adding call option", "option", callCred)
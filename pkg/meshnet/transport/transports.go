@@ -117,3 +117,13 @@ type WebRTCSignalTransport interface {
 	// Close closes the transport.
 	Close() error
 }
+
+// WebRTCRestartSignalTransport is implemented by signal transports that stay
+// open after the initial negotiation to deliver ICE restart offers from the
+// remote peer.
+type WebRTCRestartSignalTransport interface {
+	WebRTCSignalTransport
+	// RestartOffers returns a channel of ICE restart offers received from
+	// the remote peer. Answers are sent with SendDescription.
+	RestartOffers() <-chan webrtc.SessionDescription
+}
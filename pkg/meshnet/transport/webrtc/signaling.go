@@ -52,10 +52,11 @@ type SignalOptions struct {
 // NewSignalTransport returns a new WebRTC signaling transport that attempts
 // to negotiate a WebRTC connection using the Webmesh WebRTC signaling server.
 // This is typically used by clients trying to create a proxy connection to a server.
-func NewSignalTransport(opts SignalOptions) transport.WebRTCSignalTransport {
+func NewSignalTransport(opts SignalOptions) transport.WebRTCRestartSignalTransport {
 	return &webrtcSignalTransport{
 		SignalOptions: opts,
 		candidatec:    make(chan webrtc.ICECandidateInit, 16),
+		offerc:        make(chan webrtc.SessionDescription, 1),
 		errc:          make(chan error, 1),
 		cancel:        func() {},
 		closec:        make(chan struct{}),
@@ -69,6 +70,7 @@ type webrtcSignalTransport struct {
 	turnServers       []webrtc.ICEServer
 	remoteDescription webrtc.SessionDescription
 	candidatec        chan webrtc.ICECandidateInit
+	offerc            chan webrtc.SessionDescription
 	errc              chan error
 	cancel            context.CancelFunc
 	closec            chan struct{}
@@ -159,6 +161,11 @@ func (rt *webrtcSignalTransport) Candidates() <-chan webrtc.ICECandidateInit {
 	return rt.candidatec
 }
 
+// RestartOffers returns a channel of ICE restart offers received from the remote peer.
+func (rt *webrtcSignalTransport) RestartOffers() <-chan webrtc.SessionDescription {
+	return rt.offerc
+}
+
 // RemoteDescription returns the SDP description received from the remote peer.
 func (rt *webrtcSignalTransport) RemoteDescription() webrtc.SessionDescription {
 	return rt.remoteDescription
@@ -264,5 +271,21 @@ func (rt *webrtcSignalTransport) handleNegotiateStream(ctx context.Context, conn
 			}
 			rt.candidatec <- candidate
 		}
+		if msg.GetOffer() != "" {
+			// The remote peer is restarting ICE.
+			log.Debug("Received ICE restart offer from peer", "offer", msg.GetOffer())
+			var offer webrtc.SessionDescription
+			err := json.Unmarshal([]byte(msg.GetOffer()), &offer)
+			if err != nil {
+				log.Error("Failed to unmarshal ICE restart offer", "error", err.Error())
+				rt.errc <- fmt.Errorf("unmarshal ICE restart offer: %w", err)
+				return
+			}
+			select {
+			case rt.offerc <- offer:
+			case <-rt.closec:
+				return
+			}
+		}
 	}
 }
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+)
+
+const (
+	// DefaultMaxHandshakeAge is the default age after which a peer's last
+	// handshake is considered stale. WireGuard re-handshakes every two minutes
+	// on an active session, so anything older indicates a broken tunnel.
+	DefaultMaxHandshakeAge = 3 * time.Minute
+	// DefaultPeerPingTimeout is the default timeout for pinging a peer.
+	DefaultPeerPingTimeout = 3 * time.Second
+)
+
+// VerifyPeersOptions are options for verifying connectivity to peers.
+type VerifyPeersOptions struct {
+	// MaxHandshakeAge is the age after which a handshake is considered stale.
+	// Defaults to DefaultMaxHandshakeAge.
+	MaxHandshakeAge time.Duration
+	// Ping enables pinging the mesh address of each peer.
+	Ping bool
+	// PingTimeout is the timeout for each ping. Defaults to DefaultPeerPingTimeout.
+	PingTimeout time.Duration
+}
+
+// PingFunc sends ICMP echo requests to the given address. It returns an
+// error if the address could not be reached before the context expires.
+type PingFunc func(ctx context.Context, addr netip.Addr) error
+
+// PeerStatus is the connectivity status of a single peer.
+type PeerStatus struct {
+	// ID is the node ID of the peer if it is known to the interface.
+	ID string `json:"id,omitempty"`
+	// PublicKey is the public key of the peer.
+	PublicKey string `json:"publicKey"`
+	// Endpoint is the current endpoint of the peer, if any.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Address is the mesh address of the peer used for pinging, if any.
+	Address string `json:"address,omitempty"`
+	// LastHandshake is the time of the last handshake with the peer.
+	LastHandshake time.Time `json:"lastHandshake,omitempty"`
+	// HandshakeAge is the time since the last handshake. It is zero if
+	// no handshake has occurred.
+	HandshakeAge time.Duration `json:"handshakeAge,omitempty"`
+	// HandshakeStale is true if no handshake occurred within the maximum age.
+	HandshakeStale bool `json:"handshakeStale"`
+	// Pinged is true if the peer's mesh address was pinged.
+	Pinged bool `json:"pinged"`
+	// PingError is the error returned while pinging the peer, if any.
+	PingError string `json:"pingError,omitempty"`
+	// Healthy is true if the handshake is fresh and, when pinged, the
+	// peer replied.
+	Healthy bool `json:"healthy"`
+}
+
+// CheckPeers evaluates the status of the peers in the given device
+// configuration at the given time. Known peers are used to resolve mesh
+// addresses when pinging is enabled. Pings are sent concurrently with the
+// given function.
+func CheckPeers(ctx context.Context, cfg *wireguard.DeviceConfig, known map[string]wireguard.Peer, now time.Time, opts VerifyPeersOptions, ping PingFunc) []PeerStatus {
+	if cfg == nil {
+		return nil
+	}
+	maxAge := opts.MaxHandshakeAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxHandshakeAge
+	}
+	timeout := opts.PingTimeout
+	if timeout <= 0 {
+		timeout = DefaultPeerPingTimeout
+	}
+	statuses := make([]PeerStatus, len(cfg.Peers))
+	var wg sync.WaitGroup
+	for i, peer := range cfg.Peers {
+		status := PeerStatus{
+			ID:             peer.ID,
+			PublicKey:      peer.PublicKey,
+			Endpoint:       peer.Endpoint,
+			LastHandshake:  peer.LastHandshake,
+			HandshakeStale: true,
+		}
+		if !peer.LastHandshake.IsZero() {
+			status.HandshakeAge = now.Sub(peer.LastHandshake)
+			status.HandshakeStale = status.HandshakeAge > maxAge
+		}
+		status.Healthy = !status.HandshakeStale
+		statuses[i] = status
+		if !opts.Ping || ping == nil {
+			continue
+		}
+		addr := meshAddress(known[peer.ID])
+		if !addr.IsValid() {
+			continue
+		}
+		statuses[i].Address = addr.String()
+		statuses[i].Pinged = true
+		wg.Add(1)
+		go func(status *PeerStatus, addr netip.Addr) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := ping(ctx, addr); err != nil {
+				status.PingError = err.Error()
+				status.Healthy = false
+			}
+		}(&statuses[i], addr)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// meshAddress returns the private address of the peer, preferring IPv4.
+func meshAddress(peer wireguard.Peer) netip.Addr {
+	if peer.PrivateIPv4.IsValid() {
+		return peer.PrivateIPv4.Addr()
+	}
+	if peer.PrivateIPv6.IsValid() {
+		return peer.PrivateIPv6.Addr()
+	}
+	return netip.Addr{}
+}
+
+func (m *manager) VerifyPeers(ctx context.Context, opts VerifyPeersOptions) ([]PeerStatus, error) {
+	m.mu.Lock()
+	wg := m.wg
+	m.mu.Unlock()
+	if wg == nil {
+		return nil, fmt.Errorf("wireguard interface is not available")
+	}
+	cfg, err := wg.DumpConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dump wireguard config: %w", err)
+	}
+	statuses := CheckPeers(ctx, cfg, wg.Peers(), time.Now(), opts, netutil.Ping)
+	log := context.LoggerFrom(ctx).With("component", "net-manager")
+	for _, status := range statuses {
+		if !status.Healthy {
+			log.Debug("Peer failed connectivity check",
+				slog.String("peer", status.ID),
+				slog.Duration("handshake-age", status.HandshakeAge),
+				slog.String("ping-error", status.PingError),
+			)
+		}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+)
+
+type fakeDevice struct {
+	device *wgtypes.Device
+}
+
+func (f *fakeDevice) Device(name string) (*wgtypes.Device, error) {
+	if f.device == nil || f.device.Name != name {
+		return nil, errors.New("device not found")
+	}
+	return f.device, nil
+}
+
+func TestCheckPeers(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	fresh := crypto.MustGenerateKey().PublicKey()
+	stale := crypto.MustGenerateKey().PublicKey()
+	never := crypto.MustGenerateKey().PublicKey()
+	unreachable := crypto.MustGenerateKey().PublicKey()
+	device := &fakeDevice{device: &wgtypes.Device{
+		Name: "webmesh0",
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:         fresh.WireGuardKey(),
+				Endpoint:          &net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 51820},
+				LastHandshakeTime: now.Add(-30 * time.Second),
+			},
+			{
+				PublicKey:         stale.WireGuardKey(),
+				LastHandshakeTime: now.Add(-10 * time.Minute),
+			},
+			{
+				PublicKey: never.WireGuardKey(),
+			},
+			{
+				PublicKey:         unreachable.WireGuardKey(),
+				LastHandshakeTime: now.Add(-time.Minute),
+			},
+		},
+	}}
+	known := map[string]wireguard.Peer{
+		"fresh":       {ID: "fresh", PublicKey: fresh, PrivateIPv4: netip.MustParsePrefix("172.16.0.2/32")},
+		"stale":       {ID: "stale", PublicKey: stale, PrivateIPv6: netip.MustParsePrefix("fd00::3/128")},
+		"never":       {ID: "never", PublicKey: never},
+		"unreachable": {ID: "unreachable", PublicKey: unreachable, PrivateIPv4: netip.MustParsePrefix("172.16.0.5/32")},
+	}
+	cfg, err := wireguard.ReadDeviceConfig(device, "webmesh0", known)
+	if err != nil {
+		t.Fatalf("read device config: %v", err)
+	}
+	ping := func(ctx context.Context, addr netip.Addr) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected ping context to have a deadline")
+		}
+		if addr == netip.MustParseAddr("172.16.0.5") {
+			return errors.New("no replies received")
+		}
+		return nil
+	}
+
+	t.Run("HandshakesOnly", func(t *testing.T) {
+		t.Parallel()
+		statuses := CheckPeers(context.Background(), cfg, known, now, VerifyPeersOptions{}, ping)
+		got := indexStatuses(t, statuses)
+		for id, wantHealthy := range map[string]bool{
+			"fresh":       true,
+			"stale":       false,
+			"never":       false,
+			"unreachable": true,
+		} {
+			if got[id].Healthy != wantHealthy {
+				t.Errorf("expected %s healthy=%v, got %+v", id, wantHealthy, got[id])
+			}
+			if got[id].Pinged {
+				t.Errorf("expected %s not to be pinged", id)
+			}
+		}
+		if got["fresh"].HandshakeAge != 30*time.Second {
+			t.Errorf("expected handshake age of 30s, got %v", got["fresh"].HandshakeAge)
+		}
+		if got["fresh"].Endpoint != "10.1.1.1:51820" {
+			t.Errorf("expected endpoint to be reported, got %q", got["fresh"].Endpoint)
+		}
+		if got["never"].HandshakeAge != 0 || !got["never"].HandshakeStale {
+			t.Errorf("expected peer without handshake to be stale, got %+v", got["never"])
+		}
+	})
+
+	t.Run("MaxHandshakeAge", func(t *testing.T) {
+		t.Parallel()
+		statuses := CheckPeers(context.Background(), cfg, known, now, VerifyPeersOptions{MaxHandshakeAge: 15 * time.Minute}, ping)
+		got := indexStatuses(t, statuses)
+		if !got["stale"].Healthy {
+			t.Errorf("expected stale peer to be healthy with a larger max age, got %+v", got["stale"])
+		}
+	})
+
+	t.Run("WithPing", func(t *testing.T) {
+		t.Parallel()
+		statuses := CheckPeers(context.Background(), cfg, known, now, VerifyPeersOptions{Ping: true}, ping)
+		got := indexStatuses(t, statuses)
+		if !got["fresh"].Pinged || !got["fresh"].Healthy || got["fresh"].Address != "172.16.0.2" {
+			t.Errorf("expected fresh peer to be pinged over IPv4, got %+v", got["fresh"])
+		}
+		if !got["stale"].Pinged || got["stale"].Address != "fd00::3" {
+			t.Errorf("expected stale peer to be pinged over IPv6, got %+v", got["stale"])
+		}
+		if got["never"].Pinged {
+			t.Errorf("expected peer without a mesh address not to be pinged, got %+v", got["never"])
+		}
+		if got["unreachable"].Healthy || got["unreachable"].PingError == "" {
+			t.Errorf("expected unreachable peer to fail its ping, got %+v", got["unreachable"])
+		}
+	})
+}
+
+func indexStatuses(t *testing.T, statuses []PeerStatus) map[string]PeerStatus {
+	t.Helper()
+	out := make(map[string]PeerStatus, len(statuses))
+	for _, status := range statuses {
+		out[status.ID] = status
+	}
+	if len(out) != 4 {
+		t.Fatalf("expected 4 peer statuses, got %d", len(out))
+	}
+	return out
+}
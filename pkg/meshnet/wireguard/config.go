@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DeviceReader reads the live configuration of a WireGuard device.
+// It is implemented by *wgctrl.Client.
+type DeviceReader interface {
+	// Device returns the device with the given name.
+	Device(name string) (*wgtypes.Device, error)
+}
+
+// DeviceConfig is the effective configuration of a WireGuard interface
+// as reported by the device.
+type DeviceConfig struct {
+	// Name is the name of the interface.
+	Name string `json:"name"`
+	// Type is the type of the device.
+	Type string `json:"type"`
+	// ListenPort is the port the device is listening on.
+	ListenPort int `json:"listenPort"`
+	// PublicKey is the public key of the device.
+	PublicKey string `json:"publicKey"`
+	// FirewallMark is the firewall mark applied to outgoing packets.
+	FirewallMark int `json:"firewallMark,omitempty"`
+	// Peers are the peers configured on the device sorted by public key.
+	Peers []PeerConfig `json:"peers,omitempty"`
+}
+
+// PeerConfig is the effective configuration of a peer on a WireGuard interface.
+type PeerConfig struct {
+	// ID is the node ID of the peer if it is known to the interface.
+	ID string `json:"id,omitempty"`
+	// PublicKey is the public key of the peer.
+	PublicKey string `json:"publicKey"`
+	// Endpoint is the current endpoint of the peer, if any.
+	Endpoint string `json:"endpoint,omitempty"`
+	// AllowedIPs are the allowed IPs of the peer.
+	AllowedIPs []string `json:"allowedIPs,omitempty"`
+	// LastHandshake is the time of the last handshake with the peer. It is
+	// zero if no handshake has occurred.
+	LastHandshake time.Time `json:"lastHandshake,omitempty"`
+	// PersistentKeepAlive is the keepalive interval of the peer.
+	PersistentKeepAlive time.Duration `json:"persistentKeepAlive,omitempty"`
+	// HasPresharedKey is true if a preshared key is configured for the peer.
+	// The key itself is never reported.
+	HasPresharedKey bool `json:"hasPresharedKey,omitempty"`
+	// ReceiveBytes is the number of bytes received from the peer.
+	ReceiveBytes int64 `json:"receiveBytes"`
+	// TransmitBytes is the number of bytes sent to the peer.
+	TransmitBytes int64 `json:"transmitBytes"`
+}
+
+// ReadDeviceConfig reads the effective configuration of the named device. The
+// given peers are used to resolve the node IDs of the peers on the device.
+func ReadDeviceConfig(r DeviceReader, name string, peers map[string]Peer) (*DeviceConfig, error) {
+	device, err := r.Device(name)
+	if err != nil {
+		return nil, fmt.Errorf("get wireguard device %q: %w", name, err)
+	}
+	ids := make(map[wgtypes.Key]string, len(peers))
+	for id, peer := range peers {
+		if peer.PublicKey != nil {
+			ids[peer.PublicKey.WireGuardKey()] = id
+		}
+	}
+	out := &DeviceConfig{
+		Name:         device.Name,
+		Type:         device.Type.String(),
+		ListenPort:   device.ListenPort,
+		PublicKey:    device.PublicKey.String(),
+		FirewallMark: device.FirewallMark,
+		Peers:        make([]PeerConfig, 0, len(device.Peers)),
+	}
+	for _, peer := range device.Peers {
+		cfg := PeerConfig{
+			ID:                  ids[peer.PublicKey],
+			PublicKey:           peer.PublicKey.String(),
+			AllowedIPs:          make([]string, 0, len(peer.AllowedIPs)),
+			LastHandshake:       peer.LastHandshakeTime,
+			PersistentKeepAlive: peer.PersistentKeepaliveInterval,
+			HasPresharedKey:     peer.PresharedKey != (wgtypes.Key{}),
+			ReceiveBytes:        peer.ReceiveBytes,
+			TransmitBytes:       peer.TransmitBytes,
+		}
+		if peer.Endpoint != nil {
+			cfg.Endpoint = peer.Endpoint.String()
+		}
+		for _, ip := range peer.AllowedIPs {
+			cfg.AllowedIPs = append(cfg.AllowedIPs, ip.String())
+		}
+		out.Peers = append(out.Peers, cfg)
+	}
+	sort.Slice(out.Peers, func(i, j int) bool {
+		return out.Peers[i].PublicKey < out.Peers[j].PublicKey
+	})
+	return out, nil
+}
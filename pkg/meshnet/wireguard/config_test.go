@@ -0,0 +1,135 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+type fakeDevice struct {
+	devices map[string]*wgtypes.Device
+}
+
+func (f *fakeDevice) Device(name string) (*wgtypes.Device, error) {
+	dev, ok := f.devices[name]
+	if !ok {
+		return nil, errors.New("device not found")
+	}
+	return dev, nil
+}
+
+func TestReadDeviceConfig(t *testing.T) {
+	t.Parallel()
+
+	key := crypto.MustGenerateKey()
+	knownPeer := crypto.MustGenerateKey().PublicKey()
+	unknownPeer := crypto.MustGenerateKey().PublicKey()
+	handshake := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	psk, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate preshared key: %v", err)
+	}
+	device := &wgtypes.Device{
+		Name:         "webmesh0",
+		Type:         wgtypes.LinuxKernel,
+		PublicKey:    key.PublicKey().WireGuardKey(),
+		ListenPort:   51820,
+		FirewallMark: 42,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:         knownPeer.WireGuardKey(),
+				Endpoint:          &net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 51821},
+				LastHandshakeTime: handshake,
+				AllowedIPs: []net.IPNet{
+					{IP: net.ParseIP("172.16.0.2").To4(), Mask: net.CIDRMask(32, 32)},
+					{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(128, 128)},
+				},
+				PersistentKeepaliveInterval: 25 * time.Second,
+				PresharedKey:                psk,
+				ReceiveBytes:                100,
+				TransmitBytes:               200,
+			},
+			{
+				PublicKey: unknownPeer.WireGuardKey(),
+			},
+		},
+	}
+	reader := &fakeDevice{devices: map[string]*wgtypes.Device{"webmesh0": device}}
+	peers := map[string]Peer{
+		"node-b": {ID: "node-b", PublicKey: knownPeer},
+	}
+
+	config, err := ReadDeviceConfig(reader, "webmesh0", peers)
+	if err != nil {
+		t.Fatalf("read device config: %v", err)
+	}
+	if config.Name != "webmesh0" || config.ListenPort != 51820 || config.FirewallMark != 42 {
+		t.Errorf("unexpected device config: %+v", config)
+	}
+	if config.PublicKey != key.PublicKey().WireGuardKey().String() {
+		t.Errorf("expected public key %s, got %s", key.PublicKey().WireGuardKey(), config.PublicKey)
+	}
+	if config.Type != wgtypes.LinuxKernel.String() {
+		t.Errorf("expected type %s, got %s", wgtypes.LinuxKernel, config.Type)
+	}
+	if len(config.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(config.Peers))
+	}
+	idx := slices.IndexFunc(config.Peers, func(p PeerConfig) bool { return p.ID == "node-b" })
+	if idx < 0 {
+		t.Fatalf("expected known peer to be resolved to its node ID, got %+v", config.Peers)
+	}
+	peer := config.Peers[idx]
+	if peer.PublicKey != knownPeer.WireGuardKey().String() {
+		t.Errorf("expected peer public key %s, got %s", knownPeer.WireGuardKey(), peer.PublicKey)
+	}
+	if peer.Endpoint != "10.1.1.1:51821" {
+		t.Errorf("expected endpoint 10.1.1.1:51821, got %s", peer.Endpoint)
+	}
+	if !slices.Equal(peer.AllowedIPs, []string{"172.16.0.2/32", "fd00::2/128"}) {
+		t.Errorf("unexpected allowed ips: %v", peer.AllowedIPs)
+	}
+	if !peer.LastHandshake.Equal(handshake) {
+		t.Errorf("expected last handshake %v, got %v", handshake, peer.LastHandshake)
+	}
+	if peer.PersistentKeepAlive != 25*time.Second || peer.ReceiveBytes != 100 || peer.TransmitBytes != 200 {
+		t.Errorf("unexpected peer stats: %+v", peer)
+	}
+	if !peer.HasPresharedKey {
+		t.Error("expected the preshared key to be reported")
+	}
+	unknown := config.Peers[1-idx]
+	if unknown.ID != "" || unknown.Endpoint != "" || !unknown.LastHandshake.IsZero() || unknown.HasPresharedKey {
+		t.Errorf("expected unknown peer without id, endpoint, handshake, or preshared key, got %+v", unknown)
+	}
+	// Peers are sorted by public key so dumps are stable.
+	if config.Peers[0].PublicKey > config.Peers[1].PublicKey {
+		t.Error("expected peers to be sorted by public key")
+	}
+
+	if _, err := ReadDeviceConfig(reader, "missing", peers); err == nil {
+		t.Error("expected error for missing device")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// Implementation is the WireGuard implementation backing an interface.
+type Implementation string
+
+const (
+	// ImplementationAuto uses the kernel module when it is available and
+	// falls back to userspace wireguard-go otherwise.
+	ImplementationAuto Implementation = "auto"
+	// ImplementationKernel requires the kernel module.
+	ImplementationKernel Implementation = "kernel"
+	// ImplementationUserspace uses wireguard-go over a TUN device.
+	ImplementationUserspace Implementation = "userspace"
+)
+
+// IsValid returns true if the implementation is known. An empty value is
+// treated as auto.
+func (i Implementation) IsValid() bool {
+	switch i {
+	case "", ImplementationAuto, ImplementationKernel, ImplementationUserspace:
+		return true
+	default:
+		return false
+	}
+}
+
+// SelectImplementation resolves the implementation to use on this host. When
+// loadModule is not nil it is called to load the kernel module first. In auto
+// mode a failure to load the module selects the userspace implementation.
+// Otherwise auto is returned and the kernel interface falls back to userspace
+// if it cannot be created.
+func SelectImplementation(ctx context.Context, impl Implementation, loadModule func(context.Context) error) (Implementation, error) {
+	return selectImplementation(ctx, impl, runtime.GOOS, loadModule)
+}
+
+func selectImplementation(ctx context.Context, impl Implementation, goos string, loadModule func(context.Context) error) (Implementation, error) {
+	log := context.LoggerFrom(ctx)
+	if impl == "" {
+		impl = ImplementationAuto
+	}
+	if !impl.IsValid() {
+		return "", fmt.Errorf("unknown wireguard implementation: %s", impl)
+	}
+	if impl == ImplementationUserspace {
+		return impl, nil
+	}
+	if goos != "linux" && goos != "freebsd" {
+		if impl == ImplementationKernel {
+			return "", fmt.Errorf("kernel wireguard is not supported on %s", goos)
+		}
+		return ImplementationUserspace, nil
+	}
+	if loadModule == nil {
+		return impl, nil
+	}
+	log.Debug("Attempting to load wireguard kernel module")
+	err := loadModule(ctx)
+	if err == nil {
+		return impl, nil
+	}
+	if impl == ImplementationKernel {
+		// The module may be built in, so let interface creation decide.
+		log.Warn("Failed to load wireguard kernel module", slog.String("error", err.Error()))
+		return impl, nil
+	}
+	log.Warn("Failed to load wireguard kernel module, falling back to userspace", slog.String("error", err.Error()))
+	return ImplementationUserspace, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestSelectImplementation(t *testing.T) {
+	t.Parallel()
+
+	failLoad := func(context.Context) error { return errors.New("modprobe: FATAL: Module wireguard not found") }
+	okLoad := func(context.Context) error { return nil }
+	tc := []struct {
+		name       string
+		impl       Implementation
+		goos       string
+		loadModule func(context.Context) error
+		want       Implementation
+		wantErr    bool
+	}{
+		{name: "AutoFallsBackWhenModuleLoadFails", impl: ImplementationAuto, goos: "linux", loadModule: failLoad, want: ImplementationUserspace},
+		{name: "AutoWithModule", impl: ImplementationAuto, goos: "linux", loadModule: okLoad, want: ImplementationAuto},
+		{name: "AutoWithoutModprobe", impl: ImplementationAuto, goos: "linux", want: ImplementationAuto},
+		{name: "EmptyIsAuto", goos: "linux", loadModule: failLoad, want: ImplementationUserspace},
+		{name: "AutoWithoutKernelSupport", impl: ImplementationAuto, goos: "darwin", want: ImplementationUserspace},
+		{name: "KernelIgnoresModuleLoadFailure", impl: ImplementationKernel, goos: "linux", loadModule: failLoad, want: ImplementationKernel},
+		{name: "KernelWithoutKernelSupport", impl: ImplementationKernel, goos: "windows", wantErr: true},
+		{name: "Userspace", impl: ImplementationUserspace, goos: "linux", loadModule: failLoad, want: ImplementationUserspace},
+		{name: "Unknown", impl: "ebpf", goos: "linux", wantErr: true},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := selectImplementation(context.Background(), tt.impl, tt.goos, tt.loadModule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ limitations under the License.
 package wireguard
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -26,6 +27,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
@@ -67,10 +69,16 @@ type Interface interface {
 	PutPeer(ctx context.Context, peer *Peer) error
 	// DeletePeer removes a peer from the wireguard configuration.
 	DeletePeer(ctx context.Context, id string) error
+	// RemoveUnknownPeers removes peers configured on the device that were not
+	// added through PutPeer and returns their public keys.
+	RemoveUnknownPeers(ctx context.Context) ([]string, error)
 	// Peers returns the list of peers in the wireguard configuration.
 	Peers() map[string]Peer
 	// Metrics returns the metrics for the wireguard interface and the host.
 	Metrics() (*v1.InterfaceMetrics, error)
+	// DumpConfig returns the effective configuration of the wireguard interface
+	// as reported by the device.
+	DumpConfig(ctx context.Context) (*DeviceConfig, error)
 	// Close closes the wireguard interface and all client connections.
 	Close(ctx context.Context) error
 }
@@ -91,6 +99,9 @@ type Options struct {
 	ForceName bool
 	// ForceTUN forces the use of a TUN interface.
 	ForceTUN bool
+	// Implementation is the wireguard implementation to use. ForceTUN
+	// takes precedence when set.
+	Implementation Implementation
 	// PersistentKeepAlive is the interval at which to send keepalive packets
 	// to peers. If unset, keepalive packets will automatically be sent to publicly
 	// accessible peers when this instance is behind a NAT. Otherwise, no keep-alive
@@ -117,6 +128,13 @@ type Options struct {
 	DisableIPv6 bool
 	// DisableFullTunnel will ignore routes for a default gateway.
 	DisableFullTunnel bool
+	// FwMark is the firewall mark to set on packets sent by the interface.
+	FwMark int
+	// RoutingTable is the routing table to install mesh routes into. If
+	// unset, routes are added to the main table. This is only supported
+	// on Linux. It should usually be used together with FwMark so that
+	// encapsulated traffic is not routed back into the mesh.
+	RoutingTable int
 	// IgnoreRoutes are additional routes to ignore.
 	IgnoreRoutes []netip.Prefix
 }
@@ -173,14 +191,17 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 	}
 	log.Info("Creating wireguard interface", "name", opts.Name)
 	ifaceopts := &system.Options{
-		Name:        opts.Name,
-		NetNs:       opts.NetNs,
-		AddressV4:   opts.AddressV4,
-		AddressV6:   opts.AddressV6,
-		ForceTUN:    opts.ForceTUN,
-		MTU:         uint32(opts.MTU),
-		DisableIPv4: opts.DisableIPv4,
-		DisableIPv6: opts.DisableIPv6,
+		Name:         opts.Name,
+		NetNs:        opts.NetNs,
+		AddressV4:    opts.AddressV4,
+		AddressV6:    opts.AddressV6,
+		ForceTUN:     opts.ForceTUN || opts.Implementation == ImplementationUserspace,
+		NoFallback:   opts.Implementation == ImplementationKernel,
+		MTU:          uint32(opts.MTU),
+		DisableIPv4:  opts.DisableIPv4,
+		DisableIPv6:  opts.DisableIPv6,
+		RoutingTable: opts.RoutingTable,
+		FwMark:       uint32(opts.FwMark),
 	}
 	log.Debug("Creating system interface", "options", ifaceopts)
 	iface, err := system.New(ctx, ifaceopts)
@@ -293,22 +314,72 @@ func (w *wginterface) configure(ctx context.Context, key crypto.PrivateKey) erro
 	if err != nil {
 		return err
 	}
+	defer cli.Close()
 	var listenPort *int
 	if w.opts.ListenPort != 0 {
+		// Make sure the port is free unless we are already bound to it.
+		device, err := cli.Device(w.Name())
+		if err != nil {
+			return fmt.Errorf("failed to get wireguard device: %w", err)
+		}
+		if device.ListenPort != w.opts.ListenPort {
+			if _, err := SelectListenPort(w.opts.ListenPort); err != nil {
+				return err
+			}
+		}
 		listenPort = &w.opts.ListenPort
 	}
+	var fwmark *int
+	if w.opts.FwMark != 0 {
+		fwmark = &w.opts.FwMark
+	}
 	wgKey := key.WireGuardKey()
 	err = cli.ConfigureDevice(w.Name(), wgtypes.Config{
 		PrivateKey:   &wgKey,
 		ListenPort:   listenPort,
+		FirewallMark: fwmark,
 		ReplacePeers: false,
 	})
 	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return fmt.Errorf("%w: %d", ErrListenPortInUse, w.opts.ListenPort)
+		}
 		return fmt.Errorf("failed to configure wireguard interface: %w", err)
 	}
+	if listenPort == nil {
+		device, err := cli.Device(w.Name())
+		if err != nil {
+			return fmt.Errorf("failed to get wireguard device: %w", err)
+		}
+		w.log.Info("Selected wireguard listen port", "port", device.ListenPort)
+	}
 	return nil
 }
 
+// DumpConfig returns the effective configuration of the wireguard interface.
+func (w *wginterface) DumpConfig(ctx context.Context) (*DeviceConfig, error) {
+	var config *DeviceConfig
+	dump := func() error {
+		cli, err := wgctrl.New()
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+		config, err = ReadDeviceConfig(cli, w.Name(), w.Peers())
+		return err
+	}
+	var err error
+	if runtime.GOOS == "linux" && w.opts.NetNs != "" {
+		err = system.DoInNetNS(w.opts.NetNs, dump)
+	} else {
+		err = dump()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
 // Metrics returns the metrics for the wireguard interface.
 func (w *wginterface) Metrics() (*v1.InterfaceMetrics, error) {
 	var metrics *v1.InterfaceMetrics
@@ -0,0 +1,47 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// ErrListenPortInUse is returned when a fixed listen port is already bound
+// by another process or interface.
+var ErrListenPortInUse = errors.New("wireguard listen port is already in use")
+
+// SelectListenPort returns the port the WireGuard interface should listen on.
+// A port of zero selects an available port. Any other port is checked to be
+// available and an error wrapping ErrListenPortInUse is returned if it is not.
+func SelectListenPort(port int) (int, error) {
+	if port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid wireguard listen port %d", port)
+	}
+	conn, err := net.ListenPacket("udp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return 0, fmt.Errorf("%w: %d", ErrListenPortInUse, port)
+		}
+		return 0, fmt.Errorf("check wireguard listen port %d: %w", port, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestSelectListenPort(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Auto", func(t *testing.T) {
+		t.Parallel()
+		port, err := SelectListenPort(0)
+		if err != nil {
+			t.Fatalf("select listen port: %v", err)
+		}
+		if port <= 0 {
+			t.Fatalf("expected a port to be selected, got %d", port)
+		}
+		// The selected port must be free to bind.
+		conn, err := net.ListenPacket("udp", net.JoinHostPort("", strconv.Itoa(port)))
+		if err != nil {
+			t.Fatalf("expected selected port %d to be available: %v", port, err)
+		}
+		_ = conn.Close()
+	})
+
+	t.Run("Available", func(t *testing.T) {
+		t.Parallel()
+		want, err := SelectListenPort(0)
+		if err != nil {
+			t.Fatalf("select listen port: %v", err)
+		}
+		got, err := SelectListenPort(want)
+		if err != nil {
+			t.Fatalf("select listen port %d: %v", want, err)
+		}
+		if got != want {
+			t.Errorf("expected port %d, got %d", want, got)
+		}
+	})
+
+	t.Run("InUse", func(t *testing.T) {
+		t.Parallel()
+		conn, err := net.ListenPacket("udp", ":0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		defer conn.Close()
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		_, err = SelectListenPort(port)
+		if !errors.Is(err, ErrListenPortInUse) {
+			t.Fatalf("expected ErrListenPortInUse, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, port := range []int{-1, 65536} {
+			if _, err := SelectListenPort(port); err == nil {
+				t.Errorf("expected error for port %d", port)
+			}
+		}
+	})
+}
@@ -58,6 +58,13 @@ type Peer struct {
 	AllowedIPs []netip.Prefix `json:"allowedIPs"`
 	// AllowedRoutes is the list of allowed routes for this peer.
 	AllowedRoutes []netip.Prefix `json:"allowedRoutes"`
+	// PersistentKeepAlive overrides the keepalive interval of the interface
+	// for this peer when non-zero.
+	PersistentKeepAlive time.Duration `json:"persistentKeepAlive,omitempty"`
+	// PresharedKey is the preshared key used with this peer, if any. It is
+	// mixed into the handshake as an additional layer of symmetric
+	// encryption and is never included when the peer is marshaled.
+	PresharedKey wgtypes.Key `json:"-"`
 }
 
 func (p Peer) MarshalJSON() ([]byte, error) {
@@ -70,6 +77,12 @@ func (p Peer) MarshalJSON() ([]byte, error) {
 		"publicKey":  encoded,
 		"endpoint":   p.Endpoint.String(),
 		"allowedIPs": p.AllowedIPs,
+		"persistentKeepAlive": func() string {
+			if p.PersistentKeepAlive == 0 {
+				return ""
+			}
+			return p.PersistentKeepAlive.String()
+		}(),
 		"allowedRoutes": func() []string {
 			var routes []string
 			for _, route := range p.AllowedRoutes {
@@ -96,79 +109,11 @@ func (w *wginterface) PutPeer(ctx context.Context, peer *Peer) error {
 			}
 		}
 	}
-	var keepAlive *time.Duration
-	if w.opts.PersistentKeepAlive != 0 {
-		keepAlive = &w.opts.PersistentKeepAlive
-	} else {
-		dur := time.Second * 30
-		keepAlive = &dur
-	}
-	var allowedIPs []net.IPNet
-	for _, ip := range peer.AllowedIPs {
-		if ip.Addr().IsUnspecified() && ip.Bits() == 0 && w.opts.DisableFullTunnel {
-			continue
-		}
-		if w.isIgnoredRoute(ip) {
-			continue
-		}
-		if ip.Addr().Is4() {
-			if w.opts.DisableIPv4 {
-				continue
-			}
-			allowedIPs = append(allowedIPs, net.IPNet{
-				IP:   ip.Addr().AsSlice(),
-				Mask: net.CIDRMask(ip.Bits(), 32),
-			})
-		} else {
-			if w.opts.DisableIPv6 {
-				continue
-			}
-			allowedIPs = append(allowedIPs, net.IPNet{
-				IP:   ip.Addr().AsSlice(),
-				Mask: net.CIDRMask(ip.Bits(), 128),
-			})
-		}
-	}
-	var allowedRoutes []net.IPNet
-	for _, ip := range peer.AllowedRoutes {
-		if ip.Addr().IsUnspecified() && ip.Bits() == 0 && w.opts.DisableFullTunnel {
-			continue
-		}
-		if w.isIgnoredRoute(ip) {
-			continue
-		}
-		if ip.Addr().Is4() {
-			if w.opts.DisableIPv4 {
-				continue
-			}
-			allowedRoutes = append(allowedRoutes, net.IPNet{
-				IP:   ip.Addr().AsSlice(),
-				Mask: net.CIDRMask(ip.Bits(), 32),
-			})
-		} else {
-			if w.opts.DisableIPv6 {
-				continue
-			}
-			allowedRoutes = append(allowedRoutes, net.IPNet{
-				IP:   ip.Addr().AsSlice(),
-				Mask: net.CIDRMask(ip.Bits(), 128),
-			})
-		}
-	}
-	allIPs := append(allowedIPs, allowedRoutes...)
-	peerCfg := wgtypes.PeerConfig{
-		PublicKey:                   peer.PublicKey.WireGuardKey(),
-		AllowedIPs:                  allIPs,
-		PersistentKeepaliveInterval: keepAlive,
-		ReplaceAllowedIPs:           true,
-	}
-	var err error
-	if peer.Endpoint.IsValid() {
-		peerCfg.Endpoint, err = net.ResolveUDPAddr("udp", peer.Endpoint.String())
-		if err != nil {
-			return fmt.Errorf("failed to resolve endpoint: %w", err)
-		}
+	peerCfg, err := w.newPeerConfig(peer)
+	if err != nil {
+		return err
 	}
+	allIPs := peerCfg.AllowedIPs
 	w.log.Debug("Configuring device with peer", slog.Any("peer", &peerConfigMarshaler{peerCfg}))
 	if runtime.GOOS == "linux" && w.opts.NetNs != "" {
 		err = system.DoInNetNS(w.opts.NetNs, func() error {
@@ -255,6 +200,89 @@ func (w *wginterface) PutPeer(ctx context.Context, peer *Peer) error {
 	return nil
 }
 
+// newPeerConfig builds the device configuration for the given peer.
+func (w *wginterface) newPeerConfig(peer *Peer) (wgtypes.PeerConfig, error) {
+	var keepAlive *time.Duration
+	if peer.PersistentKeepAlive != 0 {
+		keepAlive = &peer.PersistentKeepAlive
+	} else if w.opts.PersistentKeepAlive != 0 {
+		keepAlive = &w.opts.PersistentKeepAlive
+	} else {
+		dur := time.Second * 30
+		keepAlive = &dur
+	}
+	var allowedIPs []net.IPNet
+	for _, ip := range peer.AllowedIPs {
+		if ip.Addr().IsUnspecified() && ip.Bits() == 0 && w.opts.DisableFullTunnel {
+			continue
+		}
+		if w.isIgnoredRoute(ip) {
+			continue
+		}
+		if ip.Addr().Is4() {
+			if w.opts.DisableIPv4 {
+				continue
+			}
+			allowedIPs = append(allowedIPs, net.IPNet{
+				IP:   ip.Addr().AsSlice(),
+				Mask: net.CIDRMask(ip.Bits(), 32),
+			})
+		} else {
+			if w.opts.DisableIPv6 {
+				continue
+			}
+			allowedIPs = append(allowedIPs, net.IPNet{
+				IP:   ip.Addr().AsSlice(),
+				Mask: net.CIDRMask(ip.Bits(), 128),
+			})
+		}
+	}
+	var allowedRoutes []net.IPNet
+	for _, ip := range peer.AllowedRoutes {
+		if ip.Addr().IsUnspecified() && ip.Bits() == 0 && w.opts.DisableFullTunnel {
+			continue
+		}
+		if w.isIgnoredRoute(ip) {
+			continue
+		}
+		if ip.Addr().Is4() {
+			if w.opts.DisableIPv4 {
+				continue
+			}
+			allowedRoutes = append(allowedRoutes, net.IPNet{
+				IP:   ip.Addr().AsSlice(),
+				Mask: net.CIDRMask(ip.Bits(), 32),
+			})
+		} else {
+			if w.opts.DisableIPv6 {
+				continue
+			}
+			allowedRoutes = append(allowedRoutes, net.IPNet{
+				IP:   ip.Addr().AsSlice(),
+				Mask: net.CIDRMask(ip.Bits(), 128),
+			})
+		}
+	}
+	allIPs := append(allowedIPs, allowedRoutes...)
+	peerCfg := wgtypes.PeerConfig{
+		PublicKey:                   peer.PublicKey.WireGuardKey(),
+		AllowedIPs:                  allIPs,
+		PersistentKeepaliveInterval: keepAlive,
+		ReplaceAllowedIPs:           true,
+	}
+	if peer.PresharedKey != (wgtypes.Key{}) {
+		peerCfg.PresharedKey = &peer.PresharedKey
+	}
+	if peer.Endpoint.IsValid() {
+		var err error
+		peerCfg.Endpoint, err = net.ResolveUDPAddr("udp", peer.Endpoint.String())
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("failed to resolve endpoint: %w", err)
+		}
+	}
+	return peerCfg, nil
+}
+
 func (w *wginterface) putPeer(cfg wgtypes.PeerConfig) error {
 	cli, err := wgctrl.New()
 	if err != nil {
@@ -283,6 +311,52 @@ func (w *wginterface) DeletePeer(ctx context.Context, id string) error {
 	return nil
 }
 
+// RemoveUnknownPeers removes peers configured on the device that were not
+// added through PutPeer and returns their public keys.
+func (w *wginterface) RemoveUnknownPeers(ctx context.Context) ([]string, error) {
+	var removed []string
+	remove := func() error {
+		cli, err := wgctrl.New()
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+		device, err := cli.Device(w.Name())
+		if err != nil {
+			return fmt.Errorf("get wireguard device: %w", err)
+		}
+		known := make(map[wgtypes.Key]struct{})
+		for _, peer := range w.Peers() {
+			if peer.PublicKey != nil {
+				known[peer.PublicKey.WireGuardKey()] = struct{}{}
+			}
+		}
+		var cfgs []wgtypes.PeerConfig
+		for _, peer := range device.Peers {
+			if _, ok := known[peer.PublicKey]; ok {
+				continue
+			}
+			w.log.Debug("Removing unknown peer from interface", slog.String("key", peer.PublicKey.String()))
+			cfgs = append(cfgs, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+			removed = append(removed, peer.PublicKey.String())
+		}
+		if len(cfgs) == 0 {
+			return nil
+		}
+		return cli.ConfigureDevice(w.Name(), wgtypes.Config{Peers: cfgs})
+	}
+	var err error
+	if runtime.GOOS == "linux" && w.opts.NetNs != "" {
+		err = system.DoInNetNS(w.opts.NetNs, remove)
+	} else {
+		err = remove()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
 func (w *wginterface) deletePeer(key crypto.PublicKey) error {
 	cli, err := wgctrl.New()
 	if err != nil {
@@ -351,6 +425,7 @@ func (m peerConfigMarshaler) MarshalJSON() ([]byte, error) {
 		"public_key":         m.PublicKey.String(),
 		"endpoint":           m.Endpoint.String(),
 		"keepalive_interval": m.PersistentKeepaliveInterval,
+		"preshared_key":      m.PresharedKey != nil,
 		"allowed_ips": func() []string {
 			var ips []string
 			for _, ip := range m.AllowedIPs {
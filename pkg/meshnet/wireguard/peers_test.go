@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"log/slog"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+func TestNewPeerConfig(t *testing.T) {
+	t.Parallel()
+
+	w := &wginterface{
+		opts: &Options{PersistentKeepAlive: 25 * time.Second},
+		log:  slog.Default(),
+	}
+	psk, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate preshared key: %v", err)
+	}
+	peer := &Peer{
+		ID:         "node-b",
+		PublicKey:  crypto.MustGenerateKey().PublicKey(),
+		Endpoint:   netip.MustParseAddrPort("10.1.1.1:51820"),
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("172.16.0.2/32")},
+	}
+
+	t.Run("WithoutPresharedKey", func(t *testing.T) {
+		t.Parallel()
+		cfg, err := w.newPeerConfig(peer)
+		if err != nil {
+			t.Fatalf("new peer config: %v", err)
+		}
+		if cfg.PresharedKey != nil {
+			t.Errorf("expected no preshared key, got %v", cfg.PresharedKey)
+		}
+		if cfg.PersistentKeepaliveInterval == nil || *cfg.PersistentKeepaliveInterval != 25*time.Second {
+			t.Errorf("expected the interface keepalive, got %v", cfg.PersistentKeepaliveInterval)
+		}
+	})
+
+	t.Run("WithPresharedKey", func(t *testing.T) {
+		t.Parallel()
+		withPSK := *peer
+		withPSK.PresharedKey = psk
+		cfg, err := w.newPeerConfig(&withPSK)
+		if err != nil {
+			t.Fatalf("new peer config: %v", err)
+		}
+		if cfg.PresharedKey == nil || *cfg.PresharedKey != psk {
+			t.Errorf("expected preshared key %s to be configured, got %v", psk, cfg.PresharedKey)
+		}
+		if cfg.PublicKey != peer.PublicKey.WireGuardKey() {
+			t.Errorf("expected public key %s, got %s", peer.PublicKey.WireGuardKey(), cfg.PublicKey)
+		}
+		if cfg.Endpoint == nil || cfg.Endpoint.String() != "10.1.1.1:51820" {
+			t.Errorf("expected endpoint 10.1.1.1:51820, got %v", cfg.Endpoint)
+		}
+	})
+}
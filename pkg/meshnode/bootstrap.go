@@ -84,6 +84,11 @@ func (s *meshStore) bootstrap(ctx context.Context, opts ConnectOptions) error {
 }
 
 func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOptions) error {
+	// Make sure the configured networks won't collide with anything on the
+	// host before we write them to storage.
+	if err := s.checkBootstrapNetworks(ctx, opts.Bootstrap); err != nil {
+		return err
+	}
 	// We'll bootstrap the cluster as just ourselves.
 	s.log.Info("Bootstrapping mesh storage")
 	err := s.storage.Bootstrap(ctx)
@@ -93,6 +98,8 @@ func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOpti
 	bootstrapOpts := storage.BootstrapOptions{
 		MeshDomain:           opts.Bootstrap.MeshDomain,
 		IPv4Network:          opts.Bootstrap.IPv4Network,
+		IPv4NodePrefixLength: opts.Bootstrap.IPv4NodePrefixLength,
+		ExpectedNodes:        opts.Bootstrap.ExpectedNodes,
 		IPv6Network:          opts.Bootstrap.IPv6Network,
 		Admin:                opts.Bootstrap.Admin,
 		DefaultNetworkPolicy: opts.Bootstrap.DefaultNetworkPolicy,
@@ -115,8 +122,8 @@ func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOpti
 	// If we have routes configured, add them to the db
 	meshDB := s.Storage().MeshDB()
 	if len(opts.Routes) > 0 {
-		err = meshDB.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
-			Name: fmt.Sprintf("%s-auto", s.nodeID),
+		err = s.putOwnRoute(ctx, types.Route{Route: &v1.Route{
+			Name: types.AutoRouteName(s.ID()),
 			Node: s.ID().String(),
 			DestinationCIDRs: func() []string {
 				out := make([]string, 0)
@@ -130,6 +137,12 @@ func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOpti
 			return fmt.Errorf("create routes: %w", err)
 		}
 	}
+	if opts.ExitNode {
+		err = s.putOwnRoute(ctx, types.NewExitRoute(s.ID(), !s.opts.DisableIPv4, !s.opts.DisableIPv6))
+		if err != nil {
+			return fmt.Errorf("create exit route: %w", err)
+		}
+	}
 
 	// We need to officially "join" ourselves to the cluster with a wireguard
 	// address. This is done by creating a new node in the database and then
@@ -159,8 +172,9 @@ func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOpti
 	}}
 	var privatev4 netip.Prefix
 	if !s.opts.DisableIPv4 {
-		// Take the first IPv4 address from the network
-		privatev4 = netip.PrefixFrom(results.NetworkV4.Addr().Next(), 32)
+		// Take the first IPv4 address from the network, along with the
+		// rest of the first node subnet.
+		privatev4 = netip.PrefixFrom(results.NetworkV4.Addr().Next(), results.IPv4NodePrefixLength)
 		self.PrivateIPv4 = privatev4.String()
 	}
 	s.log.Debug("Creating ourself in the database", slog.Any("params", self))
@@ -288,3 +302,32 @@ func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOpti
 	s.log.Info("Initial network bootstrap complete")
 	return nil
 }
+
+// checkBootstrapNetworks checks the networks we are about to bootstrap with
+// against the networks already configured on the host. A generated IPv6
+// network is checked once the network manager is started.
+func (s *meshStore) checkBootstrapNetworks(ctx context.Context, opts *BootstrapOptions) error {
+	var networks []netip.Prefix
+	if !s.opts.DisableIPv4 {
+		network := opts.IPv4Network
+		if network == "" {
+			network = storage.DefaultIPv4Network
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return fmt.Errorf("parse IPv4 network: %w", err)
+		}
+		networks = append(networks, prefix)
+	}
+	if !s.opts.DisableIPv6 && opts.IPv6Network != "" {
+		prefix, err := netip.ParsePrefix(opts.IPv6Network)
+		if err != nil {
+			return fmt.Errorf("parse IPv6 network: %w", err)
+		}
+		networks = append(networks, prefix)
+	}
+	if err := s.nw.CheckHostOverlap(ctx, networks...); err != nil {
+		return fmt.Errorf("check bootstrap networks: %w", err)
+	}
+	return nil
+}
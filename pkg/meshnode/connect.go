@@ -44,6 +44,12 @@ type ConnectOptions struct {
 	Features []*v1.FeaturePort
 	// Plugins is a map of plugins to use.
 	Plugins map[string]plugins.Plugin
+	// PluginEvents are options for dispatching events to watch plugins.
+	PluginEvents plugins.EventDispatchOptions
+	// PluginHealth are options for checking the health of plugins.
+	PluginHealth plugins.HealthCheckOptions
+	// PluginDrainTimeout is how long to wait for plugins to flush in-flight work on close.
+	PluginDrainTimeout time.Duration
 	// JoinRoundTripper is the round tripper to use for joining the mesh.
 	JoinRoundTripper transport.JoinRoundTripper
 	// LeaveRoundTripper is the round tripper to use for leaving the mesh.
@@ -52,6 +58,8 @@ type ConnectOptions struct {
 	NetworkOptions meshnet.Options
 	// MaxJoinRetries is the maximum number of join retries.
 	MaxJoinRetries int
+	// JoinToken is a join token to present when joining the mesh.
+	JoinToken string
 	// GRPCAdvertisePort is the port to advertise for gRPC connections.
 	GRPCAdvertisePort int
 	// MeshDNSAdvertisePort is the port to advertise for MeshDNS connections.
@@ -69,12 +77,30 @@ type ConnectOptions struct {
 	RequestObserver bool
 	// Routes are additional routes to broadcast to the mesh.
 	Routes []netip.Prefix
+	// HostRoutes are options for advertising routes from the host routing
+	// table along with Routes. They are kept in sync as the host routing
+	// table changes. Nil disables host route advertisement.
+	HostRoutes *HostRouteOptions
+	// ExitNode advertises this node as an exit node. Other nodes only route
+	// default traffic through it when they explicitly select it and network
+	// ACLs allow it.
+	ExitNode bool
+	// RequireSignedRoutes signs the routes this node advertises with its
+	// identity key and writes them through storage, and rejects unsigned
+	// routes written by plugins. It should be set on every node of a mesh
+	// that requires signed routes, since the leader skips the unsigned
+	// routes sent when joining.
+	RequireSignedRoutes bool
 	// DirectPeers are a map of peers to connect to directly. The values
 	// are the prefered transport to use.
 	DirectPeers map[types.NodeID]v1.ConnectProtocol
 	// Bootstrap are options for bootstrapping the mesh when connecting for
 	// the first time.
 	Bootstrap *BootstrapOptions
+	// Observer are options for connecting to the mesh as a read-only observer.
+	// Observers never join the mesh and do not configure WireGuard. They cannot
+	// be used with Bootstrap or a storage provider that is a consensus member.
+	Observer *ObserverOptions
 	// PreferIPv6 is true if IPv6 should be preferred over IPv4.
 	PreferIPv6 bool
 	// Multiaddrs are the multiaddrs to advertise for this node.
@@ -100,8 +126,11 @@ func (c ConnectOptions) MarshalJSON() ([]byte, error) {
 		"requestVote":        c.RequestVote,
 		"requestObserver":    c.RequestObserver,
 		"routes":             c.Routes,
+		"hostRoutes":         c.HostRoutes,
+		"exitNode":           c.ExitNode,
 		"directPeers":        c.DirectPeers,
 		"bootstrap":          c.Bootstrap,
+		"observer":           c.Observer,
 		"preferIPv6":         c.PreferIPv6,
 		"multiaddrs":         c.Multiaddrs,
 	})
@@ -115,8 +144,14 @@ type BootstrapOptions struct {
 	// IPv4Network is the IPv4 Network to use for the mesh. Defaults to
 	// DefaultIPv4Network.
 	IPv4Network string
-	// IPv6Network is the IPv6 Network to use for the mesh. Defaults to
-	// a randomly generated /32 prefix.
+	// IPv4NodePrefixLength is the prefix length of the IPv4 subnet allocated
+	// to each node. Defaults to a /32 per node.
+	IPv4NodePrefixLength int
+	// ExpectedNodes is the number of nodes the IPv4 network must have room
+	// for. Defaults to the number of bootstrap servers.
+	ExpectedNodes int
+	// IPv6Network is the IPv6 Network to use for the mesh. It must be a
+	// /48 unique local address prefix. Defaults to a randomly generated one.
 	IPv6Network string
 	// MeshDomain is the domain of the mesh network. Defaults to
 	// DefaultMeshDomain.
@@ -140,6 +175,8 @@ type BootstrapOptions struct {
 func (b BootstrapOptions) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]any{
 		"ipv4Network":          b.IPv4Network,
+		"ipv4NodePrefixLength": b.IPv4NodePrefixLength,
+		"expectedNodes":        b.ExpectedNodes,
 		"ipv6Network":          b.IPv6Network,
 		"meshDomain":           b.MeshDomain,
 		"admin":                b.Admin,
@@ -151,6 +188,13 @@ func (b BootstrapOptions) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// ObserverOptions are options for connecting to the mesh as a read-only observer.
+type ObserverOptions struct {
+	// Addrs are the gRPC addresses of storage-providing nodes to read
+	// mesh state from.
+	Addrs []string
+}
+
 // Connect opens the connection to the mesh.
 func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error) {
 	s.mu.Lock()
@@ -160,6 +204,10 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 	}
 	s.storage = opts.StorageProvider
 	s.leaveRTT = opts.LeaveRoundTripper
+	s.staticRoutes = opts.Routes
+	s.hostRoutes = opts.HostRoutes
+	s.signRoutes = opts.RequireSignedRoutes
+	s.roaming = opts.NetworkOptions.Roaming
 	log := s.log
 	log.Debug("Connecting to mesh network", slog.Any("options", opts))
 	// If our key is still nil, generate an ephemeral key.
@@ -174,6 +222,9 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 	// Create the network manager
 	opts.NetworkOptions.StoragePort = int(s.storage.ListenPort())
 	s.nw = meshnet.New(s.Storage().MeshDB(), opts.NetworkOptions, s.ID())
+	if opts.Observer != nil {
+		return s.connectObserver(ctx, opts)
+	}
 	if opts.Bootstrap != nil {
 		// Attempt bootstrap.
 		if err = s.bootstrap(ctx, opts); err != nil {
@@ -185,6 +236,13 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		if err != nil {
 			return fmt.Errorf("join: %w", err)
 		}
+		if opts.ExitNode && s.signRoutes {
+			// The leader skips our unsigned exit route, so sign it ourselves.
+			err = s.putOwnRoute(ctx, types.NewExitRoute(s.ID(), !s.opts.DisableIPv4, !s.opts.DisableIPv6))
+			if err != nil {
+				return fmt.Errorf("create exit route: %w", err)
+			}
+		}
 	} else if opts.StorageProvider.Consensus().IsMember() {
 		// We neither had the bootstrap flag nor any join flags set.
 		// This means we are possibly a single node cluster.
@@ -196,12 +254,22 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		// We got nothing, bail out.
 		return fmt.Errorf("no bootstrap or join options provided")
 	}
+	// Bring the storage schema up to date if we are the leader.
+	if err := s.runMigrations(ctx); err != nil {
+		return fmt.Errorf("run storage migrations: %w", err)
+	}
 	// At this point we are open for business.
 	s.open.Store(true)
 	if s.testStore {
 		// TODO: This only works for the single node case.
 		// Really the test store needs to be a separate implementation
 		// using the mock interfaces from the various test packages.
+		// A plugin manager with the built-in IPAM is still needed by
+		// services run against the test store.
+		s.plugins, err = plugins.NewManager(ctx, plugins.Options{Storage: s.Storage()})
+		if err != nil {
+			return fmt.Errorf("failed to load plugins: %w", err)
+		}
 		return nil
 	}
 	cleanFuncs := []func(){
@@ -223,6 +291,10 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		Plugins:               opts.Plugins,
 		DisableDefaultIPAM:    s.opts.DisableDefaultIPAM,
 		DefaultIPAMStaticIPv4: s.opts.DefaultIPAMStaticIPv4,
+		EventDispatch:         opts.PluginEvents,
+		HealthChecks:          opts.PluginHealth,
+		DrainTimeout:          opts.PluginDrainTimeout,
+		RequireSignedRoutes:   opts.RequireSignedRoutes,
 		Node: plugins.NodeConfig{
 			NodeID:      s.ID(),
 			NetworkIPv4: s.nw.NetworkV4(),
@@ -245,6 +317,7 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 	// If we are using the built-in raftstorage, register the observer
 	if raft, ok := s.storage.(*raftstorage.Provider); ok {
 		raft.OnObservation(s.newObserver())
+		raft.OnSnapshotRestore(s.onSnapshotRestore)
 	}
 	// Register an update hook to watch for network changes.
 	if s.storage.Consensus().IsMember() {
@@ -297,7 +370,13 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 						break
 					}
 					s.log.Debug("Received peer updates", slog.Any("peers", peers))
-					err = s.nw.Peers().Refresh(subctx, peers.Peers)
+					if opts.NetworkOptions.ExitNode != "" {
+						// The leader computes peers without our exit node selection,
+						// so compute them ourselves from storage instead.
+						err = s.nw.Peers().Sync(subctx)
+					} else {
+						err = s.nw.Peers().Refresh(subctx, peers.Peers)
+					}
 					if err != nil {
 						if subctx.Err() != nil {
 							return
@@ -310,6 +389,18 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 			}
 		}()
 	}
+	if s.opts.HeartbeatInterval > 0 && !s.testStore {
+		go s.runHeartbeats()
+	}
+	if (s.hostRoutes != nil || s.signRoutes && len(s.staticRoutes) > 0) && !s.testStore {
+		go s.runHostRouteSync()
+	}
+	if s.roaming.Enabled && !s.testStore {
+		go s.runRoamingSync()
+	}
+	if !s.testStore {
+		go s.runPresharedKeyRotations()
+	}
 	return nil
 }
 
@@ -355,9 +446,5 @@ func (s *meshStore) recoverWireguard(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("configure wireguard: %w", err)
 	}
-	wgpeers, err := meshnet.WireGuardPeersFor(ctx, s.Storage().MeshDB(), s.ID())
-	if err != nil {
-		return fmt.Errorf("get wireguard peers: %w", err)
-	}
-	return s.nw.Peers().Refresh(ctx, wgpeers)
+	return s.nw.Peers().Sync(ctx)
 }
@@ -0,0 +1,144 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// runHeartbeats periodically records that this node is alive until the
+// node is closed. The leader also prunes stale nodes when configured.
+func (s *meshStore) runHeartbeats() {
+	t := s.clock.NewTicker(s.opts.HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closec:
+			return
+		case <-t.C():
+			ctx, cancel := context.WithTimeout(context.WithLogger(context.Background(), s.log), s.opts.HeartbeatInterval)
+			if err := s.sendHeartbeat(ctx); err != nil {
+				s.log.Warn("Failed to send heartbeat", slog.String("error", err.Error()))
+			}
+			if s.opts.PruneStaleNodesAfter > 0 && s.storage.Consensus().IsLeader() {
+				s.pruneStaleNodes(ctx)
+			}
+			cancel()
+		}
+	}
+}
+
+// sendHeartbeat records a heartbeat for this node. The leader writes it
+// directly, all other nodes send an empty update to the leader which records
+// it on their behalf.
+func (s *meshStore) sendHeartbeat(ctx context.Context) error {
+	if s.storage.Consensus().IsLeader() {
+		return s.storage.MeshDB().Peers().PutHeartbeat(ctx, s.ID(), s.clock.Now())
+	}
+	c, err := s.DialLeader(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = v1.NewMembershipClient(c).Update(ctx, &v1.UpdateRequest{
+		Id: s.ID().String(),
+	})
+	return err
+}
+
+// pruneStaleNodes removes nodes that have not sent a heartbeat within the
+// configured threshold from the mesh once they have been down for longer
+// than the grace period.
+func (s *meshStore) pruneStaleNodes(ctx context.Context) {
+	now := s.clock.Now()
+	stale, err := s.storage.MeshDB().Peers().ListStaleNodes(ctx, now.Add(-s.opts.PruneStaleNodesAfter))
+	if err != nil {
+		s.log.Warn("Failed to list stale nodes", slog.String("error", err.Error()))
+		return
+	}
+	down := make(map[types.NodeID]struct{}, len(stale))
+	for _, node := range stale {
+		down[node.NodeID()] = struct{}{}
+	}
+	s.staleNodes.retain(down)
+	for _, node := range stale {
+		if node.NodeID() == s.ID() {
+			continue
+		}
+		if !s.staleNodes.markDown(node.NodeID(), now, s.opts.PruneGracePeriod) {
+			s.log.Debug("Stale node is within the prune grace period", slog.String("node", node.GetId()))
+			continue
+		}
+		s.log.Info("Pruning stale node", slog.String("node", node.GetId()))
+		err := s.storage.Consensus().RemovePeer(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: node.GetId()}}, false)
+		if err != nil {
+			s.log.Warn("Failed to remove stale node from consensus", slog.String("node", node.GetId()), slog.String("error", err.Error()))
+		}
+		if err := s.storage.MeshDB().Peers().Delete(ctx, node.NodeID()); err != nil {
+			s.log.Warn("Failed to remove stale node from database", slog.String("node", node.GetId()), slog.String("error", err.Error()))
+		}
+		s.staleNodes.markUp(node.NodeID())
+	}
+}
+
+// downTracker tracks when nodes were first observed down so that they are
+// only pruned once they have been down for longer than a grace period.
+type downTracker struct {
+	since map[types.NodeID]time.Time
+	mu    sync.Mutex
+}
+
+// markDown records that the given node was observed down at now if it was
+// not already, and reports whether it has been down for at least grace.
+func (d *downTracker) markDown(id types.NodeID, now time.Time, grace time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.since == nil {
+		d.since = make(map[types.NodeID]time.Time)
+	}
+	since, ok := d.since[id]
+	if !ok {
+		since = now
+		d.since[id] = since
+	}
+	return now.Sub(since) >= grace
+}
+
+// markUp clears any down observation for the given node.
+func (d *downTracker) markUp(id types.NodeID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.since, id)
+}
+
+// retain clears down observations for nodes that are not in the given set.
+func (d *downTracker) retain(down map[types.NodeID]struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id := range d.since {
+		if _, ok := down[id]; !ok {
+			delete(d.since, id)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/clock"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestPruneStaleNodes(t *testing.T) {
+	ctx := context.Background()
+	node, err := NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+	st := node.(*meshStore)
+	clk := clock.NewFake(time.Now())
+	st.clock = clk
+	st.opts.HeartbeatInterval = time.Minute
+	st.opts.PruneStaleNodesAfter = 5 * time.Minute
+
+	peers := node.Storage().MeshDB().Peers()
+	heartbeat := func(id types.NodeID) {
+		t.Helper()
+		if err := peers.PutHeartbeat(ctx, id, clk.Now()); err != nil {
+			t.Fatalf("put heartbeat for %s: %v", id, err)
+		}
+	}
+	for _, id := range []types.NodeID{"stale-node", "fresh-node"} {
+		if err := peers.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id.String()}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+		heartbeat(id)
+	}
+	go st.runHeartbeats()
+
+	// Only fresh-node keeps sending heartbeats.
+	clk.Advance(3 * time.Minute)
+	heartbeat("fresh-node")
+	clk.Advance(3 * time.Minute)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, err := peers.Get(ctx, "stale-node")
+		if errors.IsNodeNotFound(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected stale-node to be pruned, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := peers.Get(ctx, "fresh-node"); err != nil {
+		t.Errorf("expected fresh-node to be kept: %v", err)
+	}
+	if _, err := peers.Get(ctx, node.ID()); err != nil {
+		t.Errorf("expected this node to be kept: %v", err)
+	}
+}
+
+func TestPruneGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	node, err := NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+	st := node.(*meshStore)
+	clk := clock.NewFake(time.Now())
+	st.clock = clk
+	st.opts.HeartbeatInterval = time.Minute
+	st.opts.PruneStaleNodesAfter = 5 * time.Minute
+	st.opts.PruneGracePeriod = 5 * time.Minute
+
+	peers := node.Storage().MeshDB().Peers()
+	heartbeat := func(id types.NodeID) {
+		t.Helper()
+		if err := peers.PutHeartbeat(ctx, id, clk.Now()); err != nil {
+			t.Fatalf("put heartbeat for %s: %v", id, err)
+		}
+	}
+	for _, id := range []types.NodeID{"long-outage", "brief-outage"} {
+		if err := peers.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id.String()}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	exists := func(id types.NodeID) bool {
+		t.Helper()
+		_, err := peers.Get(ctx, id)
+		if err != nil && !errors.IsNodeNotFound(err) {
+			t.Fatalf("get node %s: %v", id, err)
+		}
+		return err == nil
+	}
+
+	heartbeat("long-outage")
+	clk.Advance(4 * time.Minute)
+	heartbeat("brief-outage")
+
+	// long-outage is observed down but is within the grace period.
+	clk.Advance(2 * time.Minute)
+	st.pruneStaleNodes(ctx)
+	if !exists("long-outage") {
+		t.Fatal("expected long-outage to be retained within the grace period")
+	}
+
+	// brief-outage is now observed down as well.
+	clk.Advance(4 * time.Minute)
+	st.pruneStaleNodes(ctx)
+	if !exists("long-outage") || !exists("brief-outage") {
+		t.Fatal("expected both nodes to be retained within the grace period")
+	}
+
+	// long-outage has been down longer than the grace period.
+	clk.Advance(2 * time.Minute)
+	st.pruneStaleNodes(ctx)
+	if exists("long-outage") {
+		t.Error("expected long-outage to be pruned after the grace period")
+	}
+	if !exists("brief-outage") {
+		t.Error("expected brief-outage to be retained within the grace period")
+	}
+
+	// A node that recovers has its down observation cleared.
+	heartbeat("brief-outage")
+	st.pruneStaleNodes(ctx)
+	clk.Advance(6 * time.Minute)
+	st.pruneStaleNodes(ctx)
+	if !exists("brief-outage") {
+		t.Error("expected recovered brief-outage to be retained for a new grace period")
+	}
+}
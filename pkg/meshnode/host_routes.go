@@ -0,0 +1,219 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"path"
+	"slices"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+	storerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultHostRouteSyncInterval is the default interval for re-reading the
+// host routing table when advertising host routes.
+const DefaultHostRouteSyncInterval = 30 * time.Second
+
+// HostRouteOptions are options for advertising routes from the host routing
+// table to the mesh.
+type HostRouteOptions struct {
+	// Interfaces are the interfaces whose routes are advertised. Shell
+	// patterns such as "eth*" are supported. Empty matches every interface.
+	Interfaces []string
+	// CIDRs limits the advertised routes to those within one of the given
+	// prefixes. Empty matches every route.
+	CIDRs []netip.Prefix
+	// SyncInterval is how often the host routing table is re-read.
+	// Defaults to DefaultHostRouteSyncInterval.
+	SyncInterval time.Duration
+}
+
+// Matches reports if the given host route passes the interface and CIDR
+// filters.
+func (o HostRouteOptions) Matches(rt routes.HostRoute) bool {
+	if len(o.Interfaces) > 0 && !slices.ContainsFunc(o.Interfaces, func(pattern string) bool {
+		ok, _ := path.Match(pattern, rt.Interface)
+		return ok
+	}) {
+		return false
+	}
+	if len(o.CIDRs) > 0 && !slices.ContainsFunc(o.CIDRs, func(cidr netip.Prefix) bool {
+		return cidr.Bits() <= rt.Destination.Bits() && cidr.Contains(rt.Destination.Addr())
+	}) {
+		return false
+	}
+	return true
+}
+
+// SelectHostRoutes returns the destinations of the host routes matching the
+// given options, sorted and without duplicates. Default, link-local,
+// multicast, and loopback routes are never selected, nor are routes on the
+// skipped interface or overlapping one of the excluded prefixes.
+func SelectHostRoutes(hostRoutes []routes.HostRoute, opts HostRouteOptions, skipInterface string, exclude ...netip.Prefix) []netip.Prefix {
+	out := make([]netip.Prefix, 0)
+	for _, rt := range hostRoutes {
+		dst := rt.Destination
+		if !dst.IsValid() || types.IsDefaultRoute(dst) {
+			continue
+		}
+		addr := dst.Addr()
+		if addr.IsLinkLocalUnicast() || addr.IsMulticast() || addr.IsLoopback() {
+			continue
+		}
+		if skipInterface != "" && rt.Interface == skipInterface {
+			continue
+		}
+		if slices.ContainsFunc(exclude, func(p netip.Prefix) bool { return p.IsValid() && p.Overlaps(dst) }) {
+			continue
+		}
+		if !opts.Matches(rt) {
+			continue
+		}
+		out = append(out, dst.Masked())
+	}
+	slices.SortFunc(out, comparePrefixes)
+	return slices.Compact(out)
+}
+
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// runHostRouteSync advertises the matching host routes until the node is
+// closed, re-reading the host routing table at the configured interval. It
+// also keeps the static routes advertised when the node signs its own routes.
+func (s *meshStore) runHostRouteSync() {
+	var interval time.Duration
+	if s.hostRoutes != nil {
+		interval = s.hostRoutes.SyncInterval
+	}
+	if interval <= 0 {
+		interval = DefaultHostRouteSyncInterval
+	}
+	resync := func() {
+		ctx, cancel := context.WithTimeout(context.WithLogger(context.Background(), s.log), interval)
+		defer cancel()
+		if err := s.syncHostRoutes(ctx); err != nil {
+			s.log.Warn("Failed to sync host routes", slog.String("error", err.Error()))
+		}
+	}
+	resync()
+	t := s.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closec:
+			return
+		case <-t.C():
+			resync()
+		}
+	}
+}
+
+// syncHostRoutes advertises the host routes matching the configured filters
+// if they changed since they were last advertised. They are advertised along
+// with the static routes of the node through its auto route. The leader, or
+// a node signing its own routes, writes the route through storage. All other
+// nodes send an update to the leader. The routes are only marked as
+// advertised once the write succeeded. Without host route options only the
+// static routes are advertised.
+func (s *meshStore) syncHostRoutes(ctx context.Context) error {
+	var selected []netip.Prefix
+	if s.hostRoutes != nil {
+		hostRoutes, err := s.listHostRoutes(ctx)
+		if err != nil {
+			return fmt.Errorf("list host routes: %w", err)
+		}
+		var skip string
+		if wg := s.nw.WireGuard(); wg != nil {
+			skip = wg.Name()
+		}
+		selected = SelectHostRoutes(hostRoutes, *s.hostRoutes, skip, s.nw.NetworkV4(), s.nw.NetworkV6())
+	}
+	s.hostRoutesMu.Lock()
+	defer s.hostRoutesMu.Unlock()
+	if s.hostRoutesSynced && slices.Equal(selected, s.advertisedHostRoutes) {
+		return nil
+	}
+	all := append(slices.Clone(s.staticRoutes), selected...)
+	slices.SortFunc(all, comparePrefixes)
+	all = slices.Compact(all)
+	cidrs := make([]string, len(all))
+	for i, prefix := range all {
+		cidrs[i] = prefix.String()
+	}
+	s.log.Info("Advertising host routes", slog.Any("routes", selected))
+	switch {
+	case len(cidrs) == 0:
+		// An empty membership update leaves routes untouched, so the auto
+		// route is deleted through storage, which forwards the write to the
+		// leader on other nodes. On failure the routes are left unsynced and
+		// the withdrawal is retried on the next sync.
+		err := s.storage.MeshDB().Networking().DeleteRoute(ctx, types.AutoRouteName(s.ID()))
+		if err != nil && !storerrors.IsRouteNotFound(err) {
+			return fmt.Errorf("delete auto route: %w", err)
+		}
+	case s.signRoutes || s.storage.Consensus().IsLeader():
+		err := s.putOwnRoute(ctx, types.Route{Route: &v1.Route{
+			Name:             types.AutoRouteName(s.ID()),
+			Node:             s.ID().String(),
+			DestinationCIDRs: cidrs,
+		}})
+		if err != nil {
+			return fmt.Errorf("put auto route: %w", err)
+		}
+	default:
+		c, err := s.DialLeader(ctx)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		_, err = v1.NewMembershipClient(c).Update(ctx, &v1.UpdateRequest{
+			Id:     s.ID().String(),
+			Routes: cidrs,
+		})
+		if err != nil {
+			return fmt.Errorf("update routes: %w", err)
+		}
+	}
+	s.advertisedHostRoutes = selected
+	s.hostRoutesSynced = true
+	return nil
+}
+
+// putOwnRoute writes a route advertised by this node through storage,
+// signing it first if the node signs its own routes.
+func (s *meshStore) putOwnRoute(ctx context.Context, route types.Route) error {
+	if s.signRoutes {
+		err := route.Sign(s.key)
+		if err != nil {
+			return fmt.Errorf("sign route: %w", err)
+		}
+	}
+	return s.storage.MeshDB().Networking().PutRoute(ctx, route)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestSelectHostRoutes(t *testing.T) {
+	t.Parallel()
+	table := []routes.HostRoute{
+		{Interface: "eth0", Destination: netip.MustParsePrefix("0.0.0.0/0")},
+		{Interface: "eth0", Destination: netip.MustParsePrefix("192.168.1.0/24")},
+		{Interface: "eth1", Destination: netip.MustParsePrefix("10.10.0.0/16")},
+		{Interface: "docker0", Destination: netip.MustParsePrefix("172.17.0.0/16")},
+		{Interface: "eth0", Destination: netip.MustParsePrefix("fe80::/64")},
+		{Interface: "eth0", Destination: netip.MustParsePrefix("224.0.0.0/4")},
+		{Interface: "lo", Destination: netip.MustParsePrefix("127.0.0.0/8")},
+		{Interface: "webmesh0", Destination: netip.MustParsePrefix("10.20.0.0/16")},
+		{Interface: "eth1", Destination: netip.MustParsePrefix("10.10.0.0/16")},
+		{Interface: "eth0", Destination: netip.MustParsePrefix("2001:db8::/32")},
+	}
+	tc := []struct {
+		name    string
+		opts    HostRouteOptions
+		exclude []netip.Prefix
+		want    []string
+	}{
+		{
+			name: "no filters",
+			want: []string{"10.10.0.0/16", "172.17.0.0/16", "192.168.1.0/24", "2001:db8::/32"},
+		},
+		{
+			name: "interface pattern",
+			opts: HostRouteOptions{Interfaces: []string{"eth*"}},
+			want: []string{"10.10.0.0/16", "192.168.1.0/24", "2001:db8::/32"},
+		},
+		{
+			name: "cidr filter",
+			opts: HostRouteOptions{CIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+			want: []string{"10.10.0.0/16"},
+		},
+		{
+			name: "cidr filter narrower than route",
+			opts: HostRouteOptions{CIDRs: []netip.Prefix{netip.MustParsePrefix("10.10.1.0/24")}},
+			want: []string{},
+		},
+		{
+			name:    "excluded prefixes",
+			exclude: []netip.Prefix{netip.MustParsePrefix("172.16.0.0/12")},
+			want:    []string{"10.10.0.0/16", "192.168.1.0/24", "2001:db8::/32"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SelectHostRoutes(table, tt.opts, "webmesh0", tt.exclude...)
+			if !slices.Equal(prefixStrings(got), tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSyncHostRoutes(t *testing.T) {
+	ctx := context.Background()
+	node, err := NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+	st := node.(*meshStore)
+	table := []routes.HostRoute{
+		{Interface: "eth0", Destination: netip.MustParsePrefix("192.168.1.0/24")},
+		{Interface: "docker0", Destination: netip.MustParsePrefix("10.99.0.0/16")},
+	}
+	st.listHostRoutes = func(context.Context) ([]routes.HostRoute, error) {
+		return slices.Clone(table), nil
+	}
+	st.hostRoutes = &HostRouteOptions{Interfaces: []string{"eth*"}}
+	st.staticRoutes = []netip.Prefix{netip.MustParsePrefix("10.50.0.0/16")}
+
+	nw := node.Storage().MeshDB().Networking()
+	assertRoute := func(want ...string) {
+		t.Helper()
+		if err := st.syncHostRoutes(ctx); err != nil {
+			t.Fatalf("sync host routes: %v", err)
+		}
+		route, err := nw.GetRoute(ctx, types.AutoRouteName(st.ID()))
+		if len(want) == 0 {
+			if !errors.IsRouteNotFound(err) {
+				t.Fatalf("expected auto route to be removed, got %v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("get auto route: %v", err)
+		}
+		if !slices.Equal(route.GetDestinationCIDRs(), want) {
+			t.Fatalf("expected advertised routes %v, got %v", want, route.GetDestinationCIDRs())
+		}
+	}
+
+	assertRoute("10.50.0.0/16", "192.168.1.0/24")
+
+	// New matching routes are picked up on the next sync.
+	table = append(table, routes.HostRoute{Interface: "eth1", Destination: netip.MustParsePrefix("10.60.0.0/16")})
+	assertRoute("10.50.0.0/16", "10.60.0.0/16", "192.168.1.0/24")
+
+	// Routes removed from the host are withdrawn.
+	table = table[1:]
+	assertRoute("10.50.0.0/16", "10.60.0.0/16")
+
+	// Without any routes left the auto route is removed.
+	table = nil
+	st.staticRoutes = nil
+	assertRoute()
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	out := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		out[i] = p.String()
+	}
+	return out
+}
@@ -21,13 +21,10 @@ import (
 	"log/slog"
 	"net/netip"
 	"strings"
-	"time"
-
-	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshclient"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
-	"github.com/webmeshproj/webmesh/pkg/services"
 )
 
 func (s *meshStore) join(ctx context.Context, opts ConnectOptions) error {
@@ -35,116 +32,66 @@ func (s *meshStore) join(ctx context.Context, opts ConnectOptions) error {
 	ctx = context.WithLogger(ctx, log)
 	log.Info("Joining webmesh cluster")
 	defer opts.JoinRoundTripper.Close()
-	var tries int
-	encoded, err := s.key.PublicKey().Encode()
+	res, err := meshclient.JoinWithRoundTripper(ctx, opts.JoinRoundTripper, s.newJoinParams(opts))
 	if err != nil {
-		return fmt.Errorf("encode public key: %w", err)
+		return err
 	}
-	for tries <= opts.MaxJoinRetries {
-		if tries > 0 {
-			log.Info("Retrying join request", slog.Int("tries", tries))
-		}
-		req := s.newJoinRequest(opts, encoded)
-		log.Debug("Sending join request to node", slog.Any("req", req))
-		resp, err := opts.JoinRoundTripper.RoundTrip(ctx, req)
-		if err != nil {
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-			err = fmt.Errorf("join: %w", err)
-			log.Error("Join request failed", slog.String("error", err.Error()))
-			if tries >= opts.MaxJoinRetries {
-				return err
-			}
-			tries++
-			time.Sleep(time.Second)
-			continue
-		}
-		err = s.handleJoinResponse(ctx, opts, resp)
-		if err != nil {
-			return fmt.Errorf("handle join response: %w", err)
-		}
-		break
+	err = s.handleJoinResult(ctx, res)
+	if err != nil {
+		return fmt.Errorf("handle join response: %w", err)
 	}
 	return nil
 }
 
-func (s *meshStore) handleJoinResponse(ctx context.Context, opts ConnectOptions, resp *v1.JoinResponse) error {
+func (s *meshStore) handleJoinResult(ctx context.Context, res *meshclient.JoinResult) error {
 	log := context.LoggerFrom(ctx)
-	log.Debug("Received join response", slog.Any("resp", resp))
-	s.meshDomain = resp.GetMeshDomain()
+	s.meshDomain = res.MeshDomain
 	if !strings.HasSuffix(s.meshDomain, ".") {
 		s.meshDomain += "."
 	}
-	var addressv4, addressv6, networkv4, networkv6 netip.Prefix
-	var err error
 	// We always parse addresses and let the net manager decide what to use
-	if resp.GetAddressIPv4() != "" {
-		addressv4, err = netip.ParsePrefix(resp.GetAddressIPv4())
-		if err != nil {
-			return fmt.Errorf("parse ipv4 address: %w", err)
-		}
-	}
-	if resp.GetNetworkIPv4() != "" {
-		networkv4, err = netip.ParsePrefix(resp.GetNetworkIPv4())
-		if err != nil {
-			return fmt.Errorf("parse ipv4 network: %w", err)
-		}
-	}
-	if resp.GetAddressIPv6() != "" {
-		addressv6, err = netip.ParsePrefix(resp.GetAddressIPv6())
-		if err != nil {
-			return fmt.Errorf("parse ipv6 address: %w", err)
-		}
-	}
-	if resp.GetNetworkIPv6() != "" {
-		networkv6, err = netip.ParsePrefix(resp.GetNetworkIPv6())
-		if err != nil {
-			return fmt.Errorf("parse ipv6 network: %w", err)
-		}
-	}
 	startopts := meshnet.StartOptions{
 		Key:       s.key,
-		AddressV4: addressv4,
-		AddressV6: addressv6,
-		NetworkV4: networkv4,
-		NetworkV6: networkv6,
+		AddressV4: res.AddressV4,
+		AddressV6: res.AddressV6,
+		NetworkV4: res.NetworkV4,
+		NetworkV6: res.NetworkV6,
 	}
-	log.Debug("Starting network manager", slog.Any("opts", opts))
-	err = s.nw.Start(ctx, startopts)
+	log.Debug("Starting network manager", slog.Any("opts", startopts))
+	err := s.nw.Start(ctx, startopts)
 	if err != nil {
 		return fmt.Errorf("starting network manager: %w", err)
 	}
-	for _, peer := range resp.GetPeers() {
+	// Preshared keys negotiated during the join must be known before we add
+	// the peers, since we can't read them from storage until we are connected.
+	for id, key := range res.PresharedKeys {
+		err = s.nw.SetPeerPresharedKey(ctx, id.String(), key)
+		if err != nil {
+			log.Error("Failed to set peer preshared key", slog.String("peer", id.String()), slog.String("error", err.Error()))
+		}
+	}
+	for _, peer := range res.Peers {
 		log.Debug("Adding peer", slog.Any("peer", peer))
-		err = s.nw.Peers().Add(ctx, peer, resp.GetIceServers())
+		err = s.nw.Peers().Add(ctx, peer, res.ICEServers)
 		if err != nil {
 			log.Error("Failed to add peer", slog.String("error", err.Error()))
 		}
 	}
 	if s.opts.UseMeshDNS {
-		var servers []netip.AddrPort
+		servers := res.DNSServers
 		if s.opts.LocalMeshDNSAddr != "" {
 			// Use our local port.
 			addr, err := netip.ParseAddrPort(s.opts.LocalMeshDNSAddr)
 			if err != nil {
 				return fmt.Errorf("parsing local dns server: %w", err)
 			}
-			servers = append(servers, addr)
-		} else {
-			for _, server := range resp.GetDnsServers() {
-				addr, err := netip.ParseAddrPort(server)
-				if err != nil {
-					return fmt.Errorf("parsing dns server: %w", err)
-				}
-				servers = append(servers, addr)
-			}
+			servers = []netip.AddrPort{addr}
 		}
 		err = s.nw.DNS().AddServers(ctx, servers)
 		if err != nil {
 			log.Error("Failed to add DNS servers", slog.String("error", err.Error()))
 		}
-		err = s.nw.DNS().AddSearchDomains(ctx, []string{resp.GetMeshDomain()})
+		err = s.nw.DNS().AddSearchDomains(ctx, []string{res.MeshDomain})
 		if err != nil {
 			log.Error("Failed to add DNS search domains", slog.String("error", err.Error()))
 		}
@@ -152,57 +99,24 @@ func (s *meshStore) handleJoinResponse(ctx context.Context, opts ConnectOptions,
 	return nil
 }
 
-func (s *meshStore) newJoinRequest(opts ConnectOptions, encodedKey string) *v1.JoinRequest {
-	if opts.GRPCAdvertisePort <= 0 {
-		// Assume the default port.
-		opts.GRPCAdvertisePort = services.DefaultGRPCPort
-	}
-	req := &v1.JoinRequest{
-		Id:        s.ID().String(),
-		PublicKey: encodedKey,
-		PrimaryEndpoint: func() string {
-			if opts.PrimaryEndpoint.IsValid() {
-				return opts.PrimaryEndpoint.String()
-			}
-			return ""
-		}(),
-		WireguardEndpoints: func() []string {
-			var eps []string
-			for _, ep := range opts.WireGuardEndpoints {
-				eps = append(eps, ep.String())
-			}
-			return eps
-		}(),
-		ZoneAwarenessID:   s.opts.ZoneAwarenessID,
-		AssignIPv4:        !s.opts.DisableIPv4,
-		PreferStorageIPv6: !s.opts.DisableIPv6 && opts.PreferIPv6,
-		AsVoter:           opts.RequestVote,
-		AsObserver:        opts.RequestObserver,
-		Routes: func() []string {
-			var routes []string
-			for _, route := range opts.Routes {
-				routes = append(routes, route.String())
-			}
-			return routes
-		}(),
-		DirectPeers: func() map[string]v1.ConnectProtocol {
-			if len(opts.DirectPeers) == 0 {
-				return nil
-			}
-			out := make(map[string]v1.ConnectProtocol)
-			for id, proto := range opts.DirectPeers {
-				out[id.String()] = proto
-			}
-			return out
-		}(),
-		Features: opts.Features,
-		Multiaddrs: func() []string {
-			var out []string
-			for _, addr := range opts.Multiaddrs {
-				out = append(out, addr.String())
-			}
-			return out
-		}(),
+func (s *meshStore) newJoinParams(opts ConnectOptions) meshclient.JoinParams {
+	return meshclient.JoinParams{
+		NodeID:             s.ID(),
+		Key:                s.key,
+		JoinToken:          opts.JoinToken,
+		PrimaryEndpoint:    opts.PrimaryEndpoint,
+		WireGuardEndpoints: opts.WireGuardEndpoints,
+		ZoneAwarenessID:    s.opts.ZoneAwarenessID,
+		DisableIPv4:        s.opts.DisableIPv4,
+		DisableIPv6:        s.opts.DisableIPv6,
+		PreferIPv6:         opts.PreferIPv6,
+		AsVoter:            opts.RequestVote,
+		AsObserver:         opts.RequestObserver,
+		Routes:             opts.Routes,
+		ExitNode:           opts.ExitNode,
+		DirectPeers:        opts.DirectPeers,
+		Features:           opts.Features,
+		Multiaddrs:         opts.Multiaddrs,
+		MaxRetries:         opts.MaxJoinRetries,
 	}
-	return req
 }
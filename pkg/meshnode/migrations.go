@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"log/slog"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// runMigrations applies any registered storage migrations that have not yet
+// been recorded in the mesh state. Only the leader runs migrations, since the
+// changes are replicated to the rest of the cluster.
+func (s *meshStore) runMigrations(ctx context.Context) error {
+	migrations := storage.RegisteredMigrations()
+	if len(migrations) == 0 || !s.storage.Consensus().IsLeader() {
+		return nil
+	}
+	result, err := migrations.Run(ctx, s.storage.MeshStorage(), s.storage.MeshDB().MeshState(), storage.MigrateOptions{
+		DryRun: s.opts.DryRunMigrations,
+	})
+	if err != nil {
+		return err
+	}
+	if len(result.Applied) == 0 {
+		return nil
+	}
+	if s.opts.DryRunMigrations {
+		for _, change := range result.Changes {
+			s.log.Info("Storage migration would modify key",
+				slog.Uint64("version", change.Version),
+				slog.String("key", string(change.Key)),
+				slog.Bool("delete", change.Delete),
+			)
+		}
+		s.log.Info("Skipped applying storage migrations in dry-run mode",
+			slog.Uint64("from-version", result.FromVersion),
+			slog.Uint64("to-version", result.ToVersion),
+		)
+		return nil
+	}
+	s.log.Info("Applied storage migrations",
+		slog.Uint64("from-version", result.FromVersion),
+		slog.Uint64("to-version", result.ToVersion),
+		slog.Int("applied", len(result.Applied)),
+	)
+	return nil
+}
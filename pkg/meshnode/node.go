@@ -30,9 +30,11 @@ import (
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 
+	"github.com/webmeshproj/webmesh/pkg/clock"
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/plugins"
@@ -103,6 +105,24 @@ type Config struct {
 	// assuming a peer is offline. This is only applicable when currently
 	// the leader of the raft group.
 	HeartbeatPurgeThreshold int
+	// HeartbeatInterval is the interval at which this node records that it
+	// is alive in storage. Heartbeats are disabled when zero.
+	HeartbeatInterval time.Duration
+	// PruneStaleNodesAfter is how long a node may go without a heartbeat
+	// before it is removed from the mesh. This is only applicable when
+	// currently the leader of the raft group. Pruning is disabled when zero.
+	PruneStaleNodesAfter time.Duration
+	// PruneGracePeriod is how long a node must remain down after it is first
+	// observed down before its peer entry and routes are pruned. This applies
+	// to both stale node pruning and the heartbeat purge threshold. Nodes are
+	// pruned as soon as they are observed down when zero.
+	PruneGracePeriod time.Duration
+	// DryRunMigrations logs the changes pending storage migrations would
+	// make instead of applying them.
+	DryRunMigrations bool
+	// Clock is the clock used for heartbeats and pruning stale nodes.
+	// The real clock is used when nil.
+	Clock clock.Clock
 	// ZoneAwarenessID is an to use with zone-awareness to determine
 	// peers in the same LAN segment.
 	ZoneAwarenessID string
@@ -149,6 +169,8 @@ func NewWithLogger(log *slog.Logger, opts Config) Node {
 		log:              log.With(slog.String("node-id", string(opts.NodeID))),
 		kvSubCancel:      func() {},
 		closec:           make(chan struct{}),
+		clock:            clock.OrReal(opts.Clock),
+		listHostRoutes:   routes.List,
 	}
 	return st
 }
@@ -167,9 +189,24 @@ type meshStore struct {
 	routeUpdateGroup *errgroup.Group
 	dnsUpdateGroup   *errgroup.Group
 	leaveRTT         transport.LeaveRoundTripper
+	observer         *ObserverOptions
+	topology         atomic.Pointer[Topology]
 	closec           chan struct{}
+	clock            clock.Clock
 	log              *slog.Logger
-	mu               sync.Mutex
+	// host route advertisement state
+	hostRoutes           *HostRouteOptions
+	staticRoutes         []netip.Prefix
+	signRoutes           bool
+	listHostRoutes       func(context.Context) ([]routes.HostRoute, error)
+	advertisedHostRoutes []netip.Prefix
+	hostRoutesSynced     bool
+	hostRoutesMu         sync.Mutex
+	roaming              meshnet.RoamingOptions
+	rejectedEndpoints    map[string]struct{}
+	roamingMu            sync.Mutex
+	staleNodes           downTracker
+	mu                   sync.Mutex
 	// a flag set on test stores to indicate skipping certain operations
 	testStore bool
 }
@@ -279,6 +316,10 @@ func (s *meshStore) DialNode(ctx context.Context, nodeID types.NodeID) (transpor
 	if s.storage == nil || !s.open.Load() {
 		return nil, ErrNotOpen
 	}
+	if s.observer != nil {
+		// Observers have no peers and any storage node will serve reads.
+		return s.dialObserverAddrs(ctx)
+	}
 	if !s.storage.Consensus().IsMember() {
 		// We are not a raft node and don't have a local copy of the DB.
 		// A call to storage would cause a recursive call to this method.
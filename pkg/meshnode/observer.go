@@ -24,13 +24,13 @@ import (
 	"github.com/hashicorp/raft"
 	v1 "github.com/webmeshproj/api/go/v1"
 
-	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 	failedHeartBeats := make(map[raft.ServerID]int)
+	var unreachable downTracker
 	return func(ctx context.Context, ev raft.Observation) {
 		log := s.log.With("event", "observation")
 		log.Debug("Received observation event", slog.String("type", reflect.TypeOf(ev.Data).String()))
@@ -44,6 +44,10 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 			failedHeartBeats[data.PeerID]++
 			log.Debug("Failed heartbeat", slog.String("peer", string(data.PeerID)), slog.Int("count", failedHeartBeats[data.PeerID]))
 			if failedHeartBeats[data.PeerID] >= s.opts.HeartbeatPurgeThreshold && consensus.IsLeader() {
+				if !unreachable.markDown(types.NodeID(data.PeerID), s.clock.Now(), s.opts.PruneGracePeriod) {
+					log.Debug("Unreachable peer is within the prune grace period", slog.String("peer", string(data.PeerID)))
+					return
+				}
 				// Remove the peer from the cluster
 				log.Info("Failed heartbeat threshold reached, removing peer", slog.String("peer", string(data.PeerID)))
 				if err := consensus.RemovePeer(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: string(data.PeerID)}}, true); err != nil {
@@ -54,10 +58,12 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 					log.Warn("Failed to remove peer from database", slog.String("error", err.Error()))
 				}
 				delete(failedHeartBeats, data.PeerID)
+				unreachable.markUp(types.NodeID(data.PeerID))
 			}
 		case raft.ResumedHeartbeatObservation:
 			if s.opts.HeartbeatPurgeThreshold > 0 {
 				delete(failedHeartBeats, data.PeerID)
+				unreachable.markUp(types.NodeID(data.PeerID))
 			}
 		case raft.PeerObservation:
 			if s.testStore {
@@ -66,13 +72,8 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 			if string(data.Peer.ID) == s.nodeID {
 				return
 			}
-			wgpeers, err := meshnet.WireGuardPeersFor(ctx, provider.MeshDB(), s.ID())
-			if err != nil {
-				log.Warn("Failed to get wireguard peers", slog.String("error", err.Error()))
-			} else {
-				if err := s.nw.Peers().Refresh(ctx, wgpeers); err != nil {
-					log.Warn("Failed to refresh local wireguard peers", slog.String("error", err.Error()))
-				}
+			if err := s.nw.Peers().Sync(ctx); err != nil {
+				log.Warn("Failed to refresh local wireguard peers", slog.String("error", err.Error()))
 			}
 			if s.plugins.HasWatchers() {
 				node, err := provider.MeshDB().Peers().Get(ctx, types.NodeID(data.Peer.ID))
@@ -115,3 +116,36 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 		}
 	}
 }
+
+// onSnapshotRestore refreshes local state after storage has been restored from
+// a snapshot. Watching plugins are sent a join event for every node in the
+// restored state so they can rebuild their view of the mesh.
+func (s *meshStore) onSnapshotRestore(ctx context.Context) {
+	log := s.log.With("event", "snapshot-restore")
+	log.Info("Storage was restored from a snapshot, refreshing local state")
+	if s.testStore {
+		return
+	}
+	if err := s.nw.Peers().Sync(ctx); err != nil {
+		log.Warn("Failed to refresh local wireguard peers", slog.String("error", err.Error()))
+	}
+	if !s.plugins.HasWatchers() {
+		return
+	}
+	nodes, err := s.storage.MeshDB().Peers().List(ctx)
+	if err != nil {
+		log.Warn("Failed to list peers, can't emit events", slog.String("error", err.Error()))
+		return
+	}
+	for _, node := range nodes {
+		err := s.plugins.Emit(ctx, &v1.Event{
+			Type: v1.Event_NODE_JOIN,
+			Event: &v1.Event_Node{
+				Node: node.MeshNode,
+			},
+		})
+		if err != nil {
+			log.Warn("Error sending node join event", slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// PresharedKeyRotationInterval is how often the leader completes preshared
+// key rotations that are past their grace period.
+const PresharedKeyRotationInterval = 30 * time.Second
+
+// runPresharedKeyRotations completes preshared key rotations on the leader
+// until the node is closed, retiring the old keys once every node had time
+// to switch to the new ones.
+func (s *meshStore) runPresharedKeyRotations() {
+	t := s.clock.NewTicker(PresharedKeyRotationInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closec:
+			return
+		case <-t.C():
+			if s.storage.Consensus().IsLeader() {
+				s.completePresharedKeyRotations()
+			}
+		}
+	}
+}
+
+// completePresharedKeyRotations completes the preshared key rotations past
+// their grace period.
+func (s *meshStore) completePresharedKeyRotations() {
+	ctx, cancel := context.WithTimeout(context.WithLogger(context.Background(), s.log), PresharedKeyRotationInterval)
+	defer cancel()
+	completed, err := storage.CompletePresharedKeyRotations(ctx, s.storage.MeshDB().Peers(), s.clock.Now())
+	if err != nil {
+		s.log.Warn("Failed to complete preshared key rotations", slog.String("error", err.Error()))
+		return
+	}
+	if completed > 0 {
+		s.log.Info("Completed preshared key rotations", slog.Int("completed", completed))
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/clock"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestCompletePresharedKeyRotations(t *testing.T) {
+	ctx := context.Background()
+	node, err := NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+	st := node.(*meshStore)
+	clk := clock.NewFake(time.Now())
+	st.clock = clk
+
+	peers := node.Storage().MeshDB().Peers()
+	for _, id := range []string{"node-a", "node-b"} {
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		if err := peers.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PublicKey: encoded}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	if err := peers.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b"}}); err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	_, _, err = storage.RotatePresharedKey(ctx, peers, "node-a", "node-b", time.Minute)
+	if err != nil {
+		t.Fatalf("rotate preshared key: %v", err)
+	}
+	pending := func() bool {
+		t.Helper()
+		edge, err := peers.GetEdge(ctx, "node-a", "node-b")
+		if err != nil {
+			t.Fatalf("get edge: %v", err)
+		}
+		_, ok := types.PresharedKeyRotationFromEdgeAttrs(edge.GetAttributes())
+		return ok
+	}
+
+	// The rotation is kept pending through the grace period after activation.
+	clk.Advance(time.Minute + storage.PresharedKeyRotationGrace/2)
+	st.completePresharedKeyRotations()
+	if !pending() {
+		t.Fatal("expected the rotation to stay pending during the grace period")
+	}
+	clk.Advance(storage.PresharedKeyRotationGrace)
+	st.completePresharedKeyRotations()
+	if pending() {
+		t.Fatal("expected the rotation to be completed after the grace period")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/plugins"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Topology is a read-only view of the mesh as seen by an observer node.
+type Topology struct {
+	// Nodes are the nodes currently in the mesh.
+	Nodes []types.MeshNode
+	// Edges are the edges between nodes in the mesh.
+	Edges types.AdjacencyMap
+}
+
+// TopologyObserver is implemented by nodes that track the mesh topology.
+type TopologyObserver interface {
+	// Topology returns the most recently observed mesh topology.
+	Topology() Topology
+}
+
+// Topology returns the most recently observed mesh topology. It is only
+// populated on nodes connected as observers.
+func (s *meshStore) Topology() Topology {
+	t := s.topology.Load()
+	if t == nil {
+		return Topology{}
+	}
+	return *t
+}
+
+// connectObserver connects to the mesh as a read-only observer. The node is
+// never added as a storage member or peer and the network manager is never
+// started, so no WireGuard interface is configured.
+func (s *meshStore) connectObserver(ctx context.Context, opts ConnectOptions) error {
+	if opts.Bootstrap != nil {
+		return fmt.Errorf("observer nodes cannot bootstrap the mesh")
+	}
+	if opts.RequestVote || opts.RequestObserver {
+		return fmt.Errorf("observer nodes cannot request storage membership")
+	}
+	if opts.StorageProvider.Consensus().IsMember() {
+		return fmt.Errorf("observer nodes require a storage provider that is not a consensus member")
+	}
+	s.log.Info("Connecting to mesh as a read-only observer")
+	s.observer = opts.Observer
+	// Observers never join, so they must never try to leave either.
+	s.leaveRTT = nil
+	s.open.Store(true)
+	state, err := s.storage.MeshDB().MeshState().GetMeshState(ctx)
+	if err != nil {
+		s.log.Warn("Unable to fetch mesh state", slog.String("error", err.Error()))
+	} else {
+		s.meshDomain = state.Domain()
+	}
+	s.plugins = plugins.NewManagerWithDB(s.storage)
+	s.log.Debug("Subscribing to peer updates from storage")
+	s.kvSubCancel, err = s.storage.MeshDB().Peers().Subscribe(context.Background(), s.onObservedPeers)
+	if err != nil {
+		s.open.Store(false)
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	// Subscriptions only report changes, so build the topology as it
+	// stands now.
+	s.onObservedPeers(nil)
+	return nil
+}
+
+func (s *meshStore) onObservedPeers(peers []types.MeshNode) {
+	s.log.Debug("Observed peer update", slog.Int("peers", len(peers)))
+	edges, err := types.NewAdjacencyMap(s.storage.MeshDB().Peers().Graph())
+	if err != nil {
+		s.log.Error("Failed to build observed topology", slog.String("error", err.Error()))
+		return
+	}
+	nodes, err := s.storage.MeshDB().Peers().List(context.Background())
+	if err != nil {
+		s.log.Error("Failed to list observed peers", slog.String("error", err.Error()))
+		return
+	}
+	s.topology.Store(&Topology{Nodes: nodes, Edges: edges})
+}
+
+// dialObserverAddrs dials the first reachable storage node configured for
+// an observer. Observers have no WireGuard peers to dial through.
+func (s *meshStore) dialObserverAddrs(ctx context.Context) (transport.RPCClientConn, error) {
+	var errs []error
+	for _, addr := range s.observer.Addrs {
+		c, err := s.newGRPCConn(ctx, addr)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, fmt.Errorf("dial %s: %w", addr, err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no observer addresses configured")
+	}
+	return nil, fmt.Errorf("dial storage nodes: %w", errors.Join(errs...))
+}
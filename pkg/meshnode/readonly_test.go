@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// readOnlyProvider is a storage provider backed by a local database that
+// reports itself as not being a consensus member, like a passthrough provider.
+type readOnlyProvider struct {
+	storage.Provider
+	db *meshdb.TestDB
+}
+
+func (p *readOnlyProvider) MeshDB() storage.MeshDB          { return p.db }
+func (p *readOnlyProvider) Consensus() storage.Consensus    { return readOnlyConsensus{} }
+func (p *readOnlyProvider) ListenPort() uint16              { return 0 }
+func (p *readOnlyProvider) Close() error                    { return p.db.Close() }
+func (p *readOnlyProvider) Status() *v1.StorageStatus       { return &v1.StorageStatus{} }
+func (p *readOnlyProvider) Start(ctx context.Context) error { return nil }
+
+type readOnlyConsensus struct {
+	storage.Consensus
+}
+
+func (readOnlyConsensus) IsMember() bool { return false }
+func (readOnlyConsensus) IsLeader() bool { return false }
+
+func TestObserverMode(t *testing.T) {
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	for _, id := range []string{"node-a", "node-b"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id}})
+		if err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source: "node-a",
+		Target: "node-b",
+		Weight: 1,
+	}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+
+	node := New(Config{NodeID: "observer"})
+	err = node.Connect(ctx, ConnectOptions{
+		StorageProvider: &readOnlyProvider{db: db},
+		Observer:        &ObserverOptions{},
+	})
+	if err != nil {
+		t.Fatalf("connect observer: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	observer, ok := node.(TopologyObserver)
+	if !ok {
+		t.Fatal("expected node to implement TopologyObserver")
+	}
+	ok = testutil.Eventually[int](func() int {
+		return len(observer.Topology().Nodes)
+	}).ShouldEqual(time.Second*5, time.Millisecond*100, 2)
+	if !ok {
+		t.Fatalf("expected observer to build topology with 2 nodes, got %d", len(observer.Topology().Nodes))
+	}
+	if _, ok := observer.Topology().Edges["node-a"]["node-b"]; !ok {
+		t.Error("expected observed topology to contain the edge from node-a to node-b")
+	}
+	// Later changes should be picked up from the subscription.
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-c"}})
+	if err != nil {
+		t.Fatalf("put node node-c: %v", err)
+	}
+	ok = testutil.Eventually[int](func() int {
+		return len(observer.Topology().Nodes)
+	}).ShouldEqual(time.Second*5, time.Millisecond*100, 3)
+	if !ok {
+		t.Fatalf("expected observer to observe 3 nodes, got %d", len(observer.Topology().Nodes))
+	}
+	// The observer should not have configured an interface or added itself.
+	if node.Network().WireGuard() != nil {
+		t.Error("expected observer to configure no wireguard interface")
+	}
+	if _, err := db.Peers().Get(ctx, "observer"); err == nil {
+		t.Error("expected observer to not be added as a peer")
+	}
+
+	t.Run("RejectsMembers", func(t *testing.T) {
+		node := New(Config{NodeID: "observer-voter"})
+		err := node.Connect(ctx, ConnectOptions{
+			StorageProvider: &readOnlyProvider{db: meshdb.NewTestDB()},
+			Observer:        &ObserverOptions{},
+			RequestVote:     true,
+		})
+		if err == nil {
+			t.Fatal("expected observer requesting a vote to fail")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"log/slog"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+)
+
+// runRoamingSync checks peers for roamed endpoints until the node is
+// closed.
+func (s *meshStore) runRoamingSync() {
+	interval := s.roaming.Interval
+	if interval <= 0 {
+		interval = meshnet.DefaultRoamingInterval
+	}
+	t := s.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closec:
+			return
+		case <-t.C():
+			ctx, cancel := context.WithTimeout(context.WithLogger(context.Background(), s.log), interval)
+			if err := s.syncRoamedEndpoints(ctx); err != nil {
+				s.log.Warn("Failed to sync roamed endpoints", slog.String("error", err.Error()))
+			}
+			cancel()
+		}
+	}
+}
+
+// syncRoamedEndpoints adopts the roamed endpoints of peers that pass
+// validation. Only the leader writes them to storage, other nodes keep
+// using the roamed endpoint locally until the leader learns it as well.
+// Rejected endpoints are logged once and never stored.
+func (s *meshStore) syncRoamedEndpoints(ctx context.Context) error {
+	roamed, err := s.nw.RoamedEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+	s.roamingMu.Lock()
+	defer s.roamingMu.Unlock()
+	if s.rejectedEndpoints == nil {
+		s.rejectedEndpoints = make(map[string]struct{})
+	}
+	isLeader := s.storage.Consensus().IsLeader()
+	for _, rt := range roamed {
+		log := s.log.With(slog.String("peer", rt.NodeID.String()), slog.String("endpoint", rt.Endpoint.String()))
+		if !rt.Accepted {
+			key := rt.NodeID.String() + "/" + rt.Endpoint.String()
+			if _, ok := s.rejectedEndpoints[key]; !ok {
+				log.Warn("Ignoring roamed peer endpoint", slog.String("reason", rt.Reason))
+				s.rejectedEndpoints[key] = struct{}{}
+			}
+			continue
+		}
+		if !isLeader {
+			log.Debug("Peer roamed to a new endpoint, leaving it to the leader to store")
+			continue
+		}
+		log.Info("Peer roamed to a new endpoint, storing it")
+		if err := meshnet.AdoptRoamedEndpoint(ctx, s.storage.MeshDB(), s.roaming, rt); err != nil {
+			log.Warn("Failed to store roamed peer endpoint", slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"sort"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+)
+
+// Capability is a capability a plugin can declare.
+type Capability = v1.PluginInfo_PluginCapability
+
+// SupportedCapabilities are the plugin capabilities this version of the
+// manager knows how to dispatch to. Capabilities declared by newer plugins
+// that are not in this list are ignored during negotiation.
+var SupportedCapabilities = []Capability{
+	v1.PluginInfo_AUTH,
+	v1.PluginInfo_WATCH,
+	v1.PluginInfo_IPAMV4,
+	v1.PluginInfo_STORAGE_QUERIER,
+}
+
+// Capabilities is a set of capabilities negotiated with a plugin. The
+// manager only calls the hooks for capabilities in this set.
+type Capabilities map[Capability]struct{}
+
+// NegotiateCapabilities returns the capabilities declared by the plugin
+// that are also supported by the manager.
+func NegotiateCapabilities(info *v1.PluginInfo) Capabilities {
+	caps := make(Capabilities)
+	for _, declared := range info.GetCapabilities() {
+		for _, supported := range SupportedCapabilities {
+			if declared == supported {
+				caps[declared] = struct{}{}
+				break
+			}
+		}
+	}
+	return caps
+}
+
+// Has returns true if the capability was negotiated.
+func (c Capabilities) Has(cap Capability) bool {
+	_, ok := c[cap]
+	return ok
+}
+
+// List returns the negotiated capabilities sorted by value.
+func (c Capabilities) List() []Capability {
+	out := make([]Capability, 0, len(c))
+	for cap := range c {
+		out = append(out, cap)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// Unsupported returns the capabilities declared by the plugin that
+// were not negotiated.
+func (c Capabilities) Unsupported(info *v1.PluginInfo) []Capability {
+	var out []Capability
+	for _, declared := range info.GetCapabilities() {
+		if !c.Has(declared) {
+			out = append(out, declared)
+		}
+	}
+	return out
+}
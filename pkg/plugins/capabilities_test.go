@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/plugins/clients"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	t.Parallel()
+	info := &v1.PluginInfo{
+		Capabilities: []Capability{
+			v1.PluginInfo_WATCH,
+			v1.PluginInfo_STORAGE_PROVIDER,
+			// A capability from a newer version of the plugin API.
+			Capability(42),
+		},
+	}
+	caps := NegotiateCapabilities(info)
+	if got := caps.List(); len(got) != 1 || got[0] != v1.PluginInfo_WATCH {
+		t.Errorf("expected only WATCH to be negotiated, got %v", got)
+	}
+	if got := caps.Unsupported(info); len(got) != 2 {
+		t.Errorf("expected 2 unsupported capabilities, got %v", got)
+	}
+}
+
+func TestCapabilityGatedDispatch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	// Both plugins implement the watch hook, but only one declares it.
+	storagePlugin := &slowWatchPlugin{info: &v1.PluginInfo{
+		Name:         "storage",
+		Capabilities: []Capability{v1.PluginInfo_STORAGE_PROVIDER},
+	}}
+	watchPlugin := &slowWatchPlugin{}
+	m, err := NewManager(ctx, Options{
+		Plugins: map[string]Plugin{
+			"storage": {Client: clients.NewInProcessClient(storagePlugin)},
+			"watch":   {Client: clients.NewInProcessClient(watchPlugin)},
+		},
+		Node:               NodeConfig{Key: crypto.MustGenerateKey()},
+		DisableDefaultIPAM: true,
+		HealthChecks:       HealthCheckOptions{Disabled: true},
+	})
+	if err != nil {
+		t.Fatalf("create plugin manager: %v", err)
+	}
+	defer m.Close()
+
+	caps, ok := m.Capabilities("storage")
+	if !ok {
+		t.Fatal("expected storage plugin to be loaded")
+	}
+	if caps.Has(v1.PluginInfo_WATCH) {
+		t.Error("expected storage plugin not to have the WATCH capability")
+	}
+	if _, ok := m.Capabilities("missing"); ok {
+		t.Error("expected no capabilities for a missing plugin")
+	}
+	if m.HasAuth() {
+		t.Error("expected no auth plugin")
+	}
+	for _, typ := range []v1.Event_WatchEvent{v1.Event_NODE_JOIN, v1.Event_NODE_LEAVE, v1.Event_LEADER_CHANGE} {
+		err := m.Emit(ctx, &v1.Event{Type: typ, Event: &v1.Event_Node{Node: &v1.MeshNode{Id: "node-a"}}})
+		if err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+	// Closing the manager flushes any queued events.
+	if err := m.Close(); err != nil {
+		t.Fatalf("close manager: %v", err)
+	}
+	if got := watchPlugin.count(); got != 3 {
+		t.Errorf("expected watch plugin to receive 3 events, got %d", got)
+	}
+	if got := storagePlugin.count(); got != 0 {
+		t.Errorf("expected storage plugin to receive no events, got %d", got)
+	}
+}
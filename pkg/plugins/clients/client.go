@@ -19,6 +19,8 @@ package clients
 
 import (
 	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
 )
 
 // PluginClient is an extension of the interface for a plugin client.
@@ -35,3 +37,13 @@ type PluginClient interface {
 	// IPAM returns an IPAM client.
 	IPAM() v1.IPAMPluginClient
 }
+
+// RestartablePluginClient is a plugin client that can be restarted
+// when it becomes unhealthy, such as a plugin running in an external process.
+type RestartablePluginClient interface {
+	PluginClient
+
+	// Restart restarts the plugin. The plugin must be configured again
+	// after it is restarted.
+	Restart(ctx context.Context) error
+}
@@ -23,16 +23,21 @@ import (
 	"os/exec"
 	"strconv"
 	"sync"
-	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 )
 
+// ErrPluginExited is returned when calling a plugin whose process has exited.
+// The plugin manager will restart the process on its next health check.
+var ErrPluginExited = status.Error(codes.Unavailable, "plugin process has exited")
+
 // NewExternalProcessClient creates a new plugin client for an external plugin process.
 func NewExternalProcessClient(ctx context.Context, path string) (PluginClient, error) {
 	p := &externalProcessPlugin{path: path}
@@ -40,39 +45,33 @@ func NewExternalProcessClient(ctx context.Context, path string) (PluginClient, e
 }
 
 type externalProcessPlugin struct {
-	path string
-	cmd  *exec.Cmd
-	mux  sync.Mutex
-	cli  v1.PluginClient
-	conn *grpc.ClientConn
+	path   string
+	cmd    *exec.Cmd
+	exited chan struct{}
+	mux    sync.Mutex
+	cli    v1.PluginClient
+	conn   *grpc.ClientConn
 }
 
 func (p *externalProcessPlugin) GetInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*v1.PluginInfo, error) {
-	return p.cli.GetInfo(ctx, in)
+	return p.client().GetInfo(ctx, in)
 }
 
 func (p *externalProcessPlugin) Configure(ctx context.Context, in *v1.PluginConfiguration, opts ...grpc.CallOption) (*emptypb.Empty, error) {
-	return p.cli.Configure(ctx, in)
+	return p.client().Configure(ctx, in)
 }
 
 func (p *externalProcessPlugin) Close(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 	errs := make([]error, 0, 3)
-	if p.cli != nil {
+	if p.cli != nil && !p.hasExited() {
 		if _, err := p.cli.Close(ctx, in); err != nil {
 			errs = append(errs, err)
 		}
 	}
-	if p.conn != nil {
-		if err := p.conn.Close(); err != nil {
-			errs = append(errs, err)
-		}
-	}
-	if p.cmd != nil && p.cmd.ProcessState == nil {
-		if err := p.cmd.Process.Kill(); err != nil {
-			errs = append(errs, err)
-		}
+	if err := p.stop(); err != nil {
+		errs = append(errs, err)
 	}
 	if len(errs) > 0 {
 		return nil, fmt.Errorf("close: %v", errs)
@@ -80,42 +79,89 @@ func (p *externalProcessPlugin) Close(ctx context.Context, in *emptypb.Empty, op
 	return &emptypb.Empty{}, nil
 }
 
+// Restart stops the plugin process if it is still running and starts a new one.
+func (p *externalProcessPlugin) Restart(ctx context.Context) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if err := p.stop(); err != nil {
+		return fmt.Errorf("stop plugin: %w", err)
+	}
+	return p.start(ctx)
+}
+
 func (p *externalProcessPlugin) Storage() v1.StorageQuerierPluginClient {
-	return v1.NewStorageQuerierPluginClient(p.conn)
+	return v1.NewStorageQuerierPluginClient(p.connection())
 }
 
 func (p *externalProcessPlugin) Auth() v1.AuthPluginClient {
-	return v1.NewAuthPluginClient(p.conn)
+	return v1.NewAuthPluginClient(p.connection())
 }
 
 func (p *externalProcessPlugin) Events() v1.WatchPluginClient {
-	return v1.NewWatchPluginClient(p.conn)
+	return v1.NewWatchPluginClient(p.connection())
 }
 
 func (p *externalProcessPlugin) IPAM() v1.IPAMPluginClient {
-	return v1.NewIPAMPluginClient(p.conn)
+	return v1.NewIPAMPluginClient(p.connection())
+}
+
+func (p *externalProcessPlugin) client() v1.PluginClient {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.cli
 }
 
-// checkProcess checks if the process is running and restarts it if it is not.
-func (p *externalProcessPlugin) checkProcess(ctx context.Context) error {
+func (p *externalProcessPlugin) connection() *grpc.ClientConn {
 	p.mux.Lock()
 	defer p.mux.Unlock()
-	if p.cmd.ProcessState != nil {
-		if p.conn != nil {
-			_ = p.conn.Close()
+	return p.conn
+}
+
+// checkProcess returns ErrPluginExited if the plugin process has exited.
+func (p *externalProcessPlugin) checkProcess() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.hasExited() {
+		return ErrPluginExited
+	}
+	return nil
+}
+
+// hasExited reports whether the plugin process has exited. The lock must be held.
+func (p *externalProcessPlugin) hasExited() bool {
+	if p.exited == nil {
+		return true
+	}
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop closes the connection and kills the plugin process. The lock must be held.
+func (p *externalProcessPlugin) stop() error {
+	errs := make([]error, 0, 2)
+	if p.conn != nil {
+		if err := p.conn.Close(); err != nil {
+			errs = append(errs, err)
 		}
-		_, ok := ctx.Deadline()
-		if !ok {
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithDeadline(ctx, time.Now().Add(5*time.Second))
-			defer cancel()
+		p.conn = nil
+	}
+	if p.cmd != nil && !p.hasExited() {
+		if err := p.cmd.Process.Kill(); err != nil {
+			errs = append(errs, err)
 		}
-		return p.start(ctx)
+		<-p.exited
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
 	}
 	return nil
 }
 
-// start starts the plugin server.
+// start starts the plugin server. The lock must be held or the plugin not yet shared.
 func (p *externalProcessPlugin) start(ctx context.Context) error {
 	r, w, err := os.Pipe()
 	if err != nil {
@@ -128,27 +174,38 @@ func (p *externalProcessPlugin) start(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("start plugin: %w", err)
 	}
+	// Reap the process so we can tell when it exits.
+	cmd, exited := p.cmd, make(chan struct{})
+	p.exited = exited
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	handleErr := func(cause error) error {
+		_ = p.stop()
+		return cause
+	}
 	// Wait for the address to be written to the pipe.
 	b := bufio.NewReader(r)
 	if deadline, ok := ctx.Deadline(); ok {
 		err = r.SetReadDeadline(deadline)
 		if err != nil {
-			return fmt.Errorf("set read deadline: %w", err)
+			return handleErr(fmt.Errorf("set read deadline: %w", err))
 		}
 	}
 	addr, err := b.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("read address: %w", err)
+		return handleErr(fmt.Errorf("read address: %w", err))
 	}
 	interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		if err := p.checkProcess(ctx); err != nil {
+		if err := p.checkProcess(); err != nil {
 			return err
 		}
-		return invoker(ctx, method, req, reply, p.conn, opts...)
+		return invoker(context.AppendRequestIDToOutgoingContext(ctx), method, req, reply, cc, opts...)
 	}
 	p.conn, err = grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithUnaryInterceptor(interceptor))
 	if err != nil {
-		return fmt.Errorf("dial: %w", err)
+		return handleErr(fmt.Errorf("dial: %w", err))
 	}
 	p.cli = v1.NewPluginClient(p.conn)
 	return nil
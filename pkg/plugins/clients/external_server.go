@@ -80,7 +80,7 @@ func NewExternalServerClient(ctx context.Context, cfg *ExternalServerConfig) (Pl
 		}
 		opt = grpc.WithTransportCredentials(credentials.NewTLS(&tlsConfig))
 	}
-	c, err := grpc.DialContext(ctx, cfg.Server, opt)
+	c, err := grpc.DialContext(ctx, cfg.Server, opt, grpc.WithUnaryInterceptor(context.RequestIDUnaryClientInterceptor()))
 	if err != nil {
 		return nil, fmt.Errorf("dial: %w", err)
 	}
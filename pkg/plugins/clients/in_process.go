@@ -43,13 +43,16 @@ func (p *inProcessPlugin) Configure(ctx context.Context, in *v1.PluginConfigurat
 }
 
 func (p *inProcessPlugin) Close(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	// Close the plugin before its query stream so it can flush any
+	// buffered writes to storage.
+	res, err := p.server.Close(ctx, &emptypb.Empty{})
 	if p.queryStream != nil {
 		err := p.queryStream.CloseSend()
 		if err != nil {
 			context.LoggerFrom(ctx).Error("error closing query stream", "error", err)
 		}
 	}
-	return p.server.Close(ctx, &emptypb.Empty{})
+	return res, err
 }
 
 func (p *inProcessPlugin) Storage() v1.StorageQuerierPluginClient {
@@ -0,0 +1,202 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// EventQueuePolicy is the policy for handling events when a plugin's
+// event queue is full.
+type EventQueuePolicy string
+
+const (
+	// EventQueuePolicyBlock blocks the caller until there is room in the
+	// queue or the caller's context is cancelled.
+	EventQueuePolicyBlock EventQueuePolicy = "block"
+	// EventQueuePolicyDrop drops the event and returns ErrEventDropped.
+	EventQueuePolicyDrop EventQueuePolicy = "drop"
+)
+
+const (
+	// DefaultEventWorkers is the default number of workers delivering events
+	// to each watch plugin.
+	DefaultEventWorkers = 1
+	// DefaultEventQueueSize is the default number of events buffered for
+	// each watch plugin.
+	DefaultEventQueueSize = 1024
+	// DefaultEventQueuePolicy is the default policy for full event queues.
+	DefaultEventQueuePolicy = EventQueuePolicyBlock
+)
+
+var (
+	// ErrEventDropped is returned when an event was dropped because a plugin's
+	// event queue was full.
+	ErrEventDropped = status.Error(codes.ResourceExhausted, "plugin event queue is full")
+	// ErrManagerClosed is returned when emitting events after the manager was closed.
+	ErrManagerClosed = status.Error(codes.Unavailable, "plugin manager is closed")
+)
+
+// EventDispatchOptions are options for dispatching events to watch plugins.
+// Events are delivered to each plugin by a bounded pool of workers reading
+// from a buffered queue, so a slow plugin cannot stall the caller.
+type EventDispatchOptions struct {
+	// Workers is the number of workers delivering events to each plugin.
+	// Events are only guaranteed to be delivered in order with a single worker.
+	Workers int
+	// QueueSize is the number of events buffered for each plugin.
+	QueueSize int
+	// Policy is the policy for handling events when a plugin's queue is full.
+	Policy EventQueuePolicy
+}
+
+// Default returns a copy of the options with defaults applied to unset values.
+func (o EventDispatchOptions) Default() EventDispatchOptions {
+	if o.Workers <= 0 {
+		o.Workers = DefaultEventWorkers
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultEventQueueSize
+	}
+	if o.Policy == "" {
+		o.Policy = DefaultEventQueuePolicy
+	}
+	return o
+}
+
+// Validate validates the options.
+func (o EventDispatchOptions) Validate() error {
+	switch o.Policy {
+	case "", EventQueuePolicyBlock, EventQueuePolicyDrop:
+	default:
+		return fmt.Errorf("invalid event queue policy: %s", o.Policy)
+	}
+	if o.Workers < 0 {
+		return fmt.Errorf("event workers must be non-negative")
+	}
+	if o.QueueSize < 0 {
+		return fmt.Errorf("event queue size must be non-negative")
+	}
+	return nil
+}
+
+// eventDispatcher delivers events to a single watch plugin.
+type eventDispatcher struct {
+	plugin *Plugin
+	policy EventQueuePolicy
+	queue  chan *v1.Event
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+	log    *slog.Logger
+}
+
+func newEventDispatcher(plugin *Plugin, opts EventDispatchOptions, log *slog.Logger) *eventDispatcher {
+	opts = opts.Default()
+	d := &eventDispatcher{
+		plugin: plugin,
+		policy: opts.Policy,
+		queue:  make(chan *v1.Event, opts.QueueSize),
+		done:   make(chan struct{}),
+		log:    log.With("plugin", plugin.name),
+	}
+	d.wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// enqueue queues the event for delivery according to the queue policy.
+func (d *eventDispatcher) enqueue(ctx context.Context, ev *v1.Event) error {
+	select {
+	case <-d.done:
+		return ErrManagerClosed
+	default:
+	}
+	if d.policy == EventQueuePolicyDrop {
+		select {
+		case d.queue <- ev:
+			return nil
+		default:
+			d.log.Warn("Plugin event queue is full, dropping event", "event", ev.GetType().String())
+			return fmt.Errorf("%s: %w", d.plugin.name, ErrEventDropped)
+		}
+	}
+	select {
+	case d.queue <- ev:
+		return nil
+	case <-d.done:
+		return ErrManagerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting events and waits for queued events to be delivered
+// or the context to be cancelled.
+func (d *eventDispatcher) close(ctx context.Context) error {
+	d.once.Do(func() { close(d.done) })
+	drained := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s: drain events: %w", d.plugin.name, ctx.Err())
+	}
+}
+
+func (d *eventDispatcher) work() {
+	defer d.wg.Done()
+	ctx := context.WithLogger(context.Background(), d.log)
+	for {
+		select {
+		case ev := <-d.queue:
+			d.emit(ctx, ev)
+		case <-d.done:
+			// Drain anything left in the queue before exiting.
+			for {
+				select {
+				case ev := <-d.queue:
+					d.emit(ctx, ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *eventDispatcher) emit(ctx context.Context, ev *v1.Event) {
+	d.log.Debug("Emitting event", "event", ev.String())
+	_, err := d.plugin.Client.Events().Emit(ctx, ev)
+	if err != nil {
+		d.log.Error("Error emitting event to plugin", "event", ev.GetType().String(), "error", err)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/plugins/clients"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestEventDispatch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	// Each event takes the plugin far longer to handle than we allow
+	// a single raft apply to take.
+	const delay = time.Millisecond * 100
+	const maxApplyLatency = time.Millisecond * 20
+
+	t.Run("Drop", func(t *testing.T) {
+		t.Parallel()
+		plugin := &slowWatchPlugin{delay: delay}
+		m := newTestManager(t, plugin, EventDispatchOptions{
+			Workers:   1,
+			QueueSize: 2,
+			Policy:    EventQueuePolicyDrop,
+		})
+		const numEvents = 20
+		var dropped int
+		for i := 0; i < numEvents; i++ {
+			start := time.Now()
+			err := m.Emit(ctx, &v1.Event{Type: v1.Event_NODE_JOIN})
+			if elapsed := time.Since(start); elapsed > maxApplyLatency {
+				t.Fatalf("emit blocked for %s with a slow plugin", elapsed)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrEventDropped) {
+					t.Fatalf("expected ErrEventDropped, got %v", err)
+				}
+				dropped++
+			}
+		}
+		if dropped == 0 {
+			t.Fatal("expected events to be dropped when the queue is full")
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("close manager: %v", err)
+		}
+		if got := plugin.count(); got != numEvents-dropped {
+			t.Errorf("expected %d events to be delivered, got %d", numEvents-dropped, got)
+		}
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		t.Parallel()
+		plugin := &slowWatchPlugin{delay: delay}
+		const numEvents = 5
+		m := newTestManager(t, plugin, EventDispatchOptions{
+			Workers:   1,
+			QueueSize: numEvents,
+			Policy:    EventQueuePolicyBlock,
+		})
+		// Events should not block while there is room in the queue.
+		for i := 0; i < numEvents; i++ {
+			start := time.Now()
+			if err := m.Emit(ctx, &v1.Event{Type: v1.Event_NODE_JOIN}); err != nil {
+				t.Fatalf("emit: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > maxApplyLatency {
+				t.Fatalf("emit blocked for %s with room in the queue", elapsed)
+			}
+		}
+		// Once the queue is full the caller's context bounds the wait.
+		for i := 0; i < 2; i++ {
+			ctx, cancel := context.WithTimeout(ctx, maxApplyLatency)
+			start := time.Now()
+			err := m.Emit(ctx, &v1.Event{Type: v1.Event_NODE_JOIN})
+			elapsed := time.Since(start)
+			cancel()
+			if errors.Is(err, ErrEventDropped) {
+				t.Fatal("expected events not to be dropped with the block policy")
+			}
+			if elapsed > maxApplyLatency*2 {
+				t.Fatalf("emit blocked for %s past the caller's deadline", elapsed)
+			}
+		}
+		// Closing the manager should deliver everything that was queued.
+		if err := m.Close(); err != nil {
+			t.Fatalf("close manager: %v", err)
+		}
+		if got := plugin.count(); got < numEvents {
+			t.Errorf("expected at least %d events to be delivered, got %d", numEvents, got)
+		}
+		if err := m.Emit(ctx, &v1.Event{Type: v1.Event_NODE_JOIN}); !errors.Is(err, ErrManagerClosed) {
+			t.Errorf("expected ErrManagerClosed after close, got %v", err)
+		}
+	})
+
+	t.Run("InvalidPolicy", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewManager(ctx, Options{
+			DisableDefaultIPAM: true,
+			EventDispatch:      EventDispatchOptions{Policy: "invalid"},
+		})
+		if err == nil {
+			t.Fatal("expected error for invalid event queue policy")
+		}
+	})
+}
+
+func newTestManager(t *testing.T, plugin *slowWatchPlugin, opts EventDispatchOptions) Manager {
+	t.Helper()
+	m, err := NewManager(context.Background(), Options{
+		Plugins: map[string]Plugin{
+			"slow": {Client: clients.NewInProcessClient(plugin)},
+		},
+		Node:               NodeConfig{Key: crypto.MustGenerateKey()},
+		DisableDefaultIPAM: true,
+		EventDispatch:      opts,
+	})
+	if err != nil {
+		t.Fatalf("create plugin manager: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+// slowWatchPlugin is a watch plugin that takes a long time to handle events.
+// The info it returns can be overridden to declare other capabilities.
+type slowWatchPlugin struct {
+	v1.UnimplementedPluginServer
+	v1.UnimplementedWatchPluginServer
+
+	info   *v1.PluginInfo
+	delay  time.Duration
+	events int
+	mu     sync.Mutex
+}
+
+func (p *slowWatchPlugin) GetInfo(context.Context, *emptypb.Empty) (*v1.PluginInfo, error) {
+	if p.info != nil {
+		return p.info, nil
+	}
+	return &v1.PluginInfo{
+		Name:         "slow",
+		Capabilities: []v1.PluginInfo_PluginCapability{v1.PluginInfo_WATCH},
+	}, nil
+}
+
+func (p *slowWatchPlugin) Configure(context.Context, *v1.PluginConfiguration) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (p *slowWatchPlugin) Emit(context.Context, *v1.Event) (*emptypb.Empty, error) {
+	time.Sleep(p.delay)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events++
+	return &emptypb.Empty{}, nil
+}
+
+func (p *slowWatchPlugin) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.events
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/plugins/clients"
+)
+
+func TestDrainOnClose(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("FlushesBufferedItems", func(t *testing.T) {
+		t.Parallel()
+		plugin := &bufferingPlugin{}
+		m := newDrainTestManager(t, plugin, time.Second*5)
+		const numEvents = 10
+		for i := 0; i < numEvents; i++ {
+			if err := m.Emit(ctx, &v1.Event{Type: v1.Event_NODE_JOIN}); err != nil {
+				t.Fatalf("emit: %v", err)
+			}
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("close manager: %v", err)
+		}
+		if got := plugin.flushedCount(); got != numEvents {
+			t.Errorf("expected %d flushed items, got %d", numEvents, got)
+		}
+	})
+
+	t.Run("BoundedByTimeout", func(t *testing.T) {
+		t.Parallel()
+		plugin := &bufferingPlugin{flushDelay: time.Minute}
+		timeout := time.Millisecond * 100
+		m := newDrainTestManager(t, plugin, timeout)
+		start := time.Now()
+		err := m.Close()
+		if err == nil {
+			t.Fatal("expected error when plugins do not drain in time")
+		}
+		if elapsed := time.Since(start); elapsed > timeout*10 {
+			t.Errorf("expected close to be bounded by the drain timeout, took %s", elapsed)
+		}
+	})
+}
+
+func newDrainTestManager(t *testing.T, plugin *bufferingPlugin, timeout time.Duration) Manager {
+	t.Helper()
+	m, err := NewManager(context.Background(), Options{
+		Plugins: map[string]Plugin{
+			"buffering": {Client: clients.NewInProcessClient(plugin)},
+		},
+		Node:               NodeConfig{Key: crypto.MustGenerateKey()},
+		DisableDefaultIPAM: true,
+		HealthChecks:       HealthCheckOptions{Disabled: true},
+		DrainTimeout:       timeout,
+	})
+	if err != nil {
+		t.Fatalf("create plugin manager: %v", err)
+	}
+	return m
+}
+
+// bufferingPlugin buffers events in memory and only flushes them when closed,
+// like a plugin mirroring storage to an external system in batches.
+type bufferingPlugin struct {
+	v1.UnimplementedPluginServer
+	v1.UnimplementedWatchPluginServer
+
+	flushDelay time.Duration
+	buffered   []*v1.Event
+	flushed    []*v1.Event
+	mu         sync.Mutex
+}
+
+func (p *bufferingPlugin) GetInfo(context.Context, *emptypb.Empty) (*v1.PluginInfo, error) {
+	return &v1.PluginInfo{
+		Name:         "buffering",
+		Capabilities: []Capability{v1.PluginInfo_WATCH},
+	}, nil
+}
+
+func (p *bufferingPlugin) Configure(context.Context, *v1.PluginConfiguration) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (p *bufferingPlugin) Emit(_ context.Context, ev *v1.Event) (*emptypb.Empty, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buffered = append(p.buffered, ev)
+	return &emptypb.Empty{}, nil
+}
+
+func (p *bufferingPlugin) Close(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	select {
+	case <-time.After(p.flushDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.flushed = append(p.flushed, p.buffered...)
+	p.buffered = nil
+	return &emptypb.Empty{}, nil
+}
+
+func (p *bufferingPlugin) flushedCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.flushed)
+}
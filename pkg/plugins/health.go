@@ -0,0 +1,266 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/plugins/clients"
+)
+
+// PluginState is the health state of a plugin.
+type PluginState string
+
+const (
+	// PluginStateHealthy means the plugin is passing health checks.
+	PluginStateHealthy PluginState = "healthy"
+	// PluginStateUnhealthy means the plugin is failing health checks and
+	// is being restarted if possible.
+	PluginStateUnhealthy PluginState = "unhealthy"
+	// PluginStateFailed means the plugin failed too many times in a row
+	// and will no longer be restarted.
+	PluginStateFailed PluginState = "failed"
+)
+
+const (
+	// DefaultHealthCheckInterval is the default interval between plugin health checks.
+	DefaultHealthCheckInterval = time.Second * 10
+	// DefaultHealthCheckTimeout is the default timeout for a plugin health check.
+	DefaultHealthCheckTimeout = time.Second * 5
+	// DefaultMaxRestarts is the default number of consecutive restarts before
+	// a plugin is marked as failed.
+	DefaultMaxRestarts = 5
+	// DefaultRestartBackoff is the default initial backoff between restarts.
+	DefaultRestartBackoff = time.Second
+	// DefaultMaxRestartBackoff is the default maximum backoff between restarts.
+	DefaultMaxRestartBackoff = time.Minute
+)
+
+// HealthCheckOptions are options for checking the health of plugins.
+type HealthCheckOptions struct {
+	// Disabled disables plugin health checks.
+	Disabled bool
+	// Interval is the interval between health checks.
+	Interval time.Duration
+	// Timeout is the timeout for each health check.
+	Timeout time.Duration
+	// MaxRestarts is the number of consecutive restarts without a passing
+	// health check before a plugin is marked as failed.
+	MaxRestarts int
+	// RestartBackoff is the initial backoff between restarts. It doubles
+	// with each consecutive failure up to MaxRestartBackoff.
+	RestartBackoff time.Duration
+	// MaxRestartBackoff is the maximum backoff between restarts.
+	MaxRestartBackoff time.Duration
+}
+
+// Default returns a copy of the options with defaults applied to unset values.
+func (o HealthCheckOptions) Default() HealthCheckOptions {
+	if o.Interval <= 0 {
+		o.Interval = DefaultHealthCheckInterval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultHealthCheckTimeout
+	}
+	if o.MaxRestarts <= 0 {
+		o.MaxRestarts = DefaultMaxRestarts
+	}
+	if o.RestartBackoff <= 0 {
+		o.RestartBackoff = DefaultRestartBackoff
+	}
+	if o.MaxRestartBackoff <= 0 {
+		o.MaxRestartBackoff = DefaultMaxRestartBackoff
+	}
+	return o
+}
+
+// PluginStatus is the health status of a plugin.
+type PluginStatus struct {
+	// Name is the name of the plugin.
+	Name string
+	// State is the current health state of the plugin.
+	State PluginState
+	// Restarts is the total number of times the plugin has been restarted.
+	Restarts int
+	// LastCheck is the time of the last health check.
+	LastCheck time.Time
+	// LastError is the error from the last failed health check or restart.
+	LastError string
+}
+
+// healthChecker periodically checks the health of a single plugin and
+// restarts it when it becomes unhealthy.
+type healthChecker struct {
+	plugin    *Plugin
+	opts      HealthCheckOptions
+	configure func(ctx context.Context, plugin *Plugin) error
+	status    PluginStatus
+	failures  int // only accessed by the run goroutine
+	mu        sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	started   bool
+	once      sync.Once
+	log       *slog.Logger
+}
+
+func newHealthChecker(name string, plugin *Plugin, opts HealthCheckOptions, configure func(context.Context, *Plugin) error, log *slog.Logger) *healthChecker {
+	return &healthChecker{
+		plugin:    plugin,
+		opts:      opts.Default(),
+		configure: configure,
+		status:    PluginStatus{Name: name, State: PluginStateHealthy},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		log:       log.With("plugin", name),
+	}
+}
+
+// Status returns the current status of the plugin.
+func (h *healthChecker) Status() PluginStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// start starts running health checks in the background.
+func (h *healthChecker) start() {
+	h.started = true
+	go h.run()
+}
+
+// run runs health checks until close is called.
+func (h *healthChecker) run() {
+	defer close(h.done)
+	t := time.NewTicker(h.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-t.C:
+		}
+		if !h.check() {
+			return
+		}
+	}
+}
+
+// close stops health checks and waits for any in-flight restart.
+func (h *healthChecker) close() {
+	h.once.Do(func() { close(h.stop) })
+	if h.started {
+		<-h.done
+	}
+}
+
+// check runs a single health check, restarting the plugin until it is healthy,
+// it has failed too many times, or the checker is stopped. It returns false
+// if health checks should no longer be run.
+func (h *healthChecker) check() bool {
+	err := h.probe()
+	if err == nil {
+		h.setHealthy()
+		return true
+	}
+	for {
+		h.setUnhealthy(err)
+		restartable, ok := h.plugin.Client.(clients.RestartablePluginClient)
+		if !ok {
+			// Nothing we can do but keep checking.
+			return true
+		}
+		h.failures++
+		failures := h.failures
+		if failures > h.opts.MaxRestarts {
+			h.log.Error("Plugin failed too many times, no longer restarting", "max-restarts", h.opts.MaxRestarts, "error", err)
+			h.mu.Lock()
+			h.status.State = PluginStateFailed
+			h.mu.Unlock()
+			return false
+		}
+		if failures > 1 {
+			select {
+			case <-h.stop:
+				return false
+			case <-time.After(h.backoff(failures - 1)):
+			}
+		}
+		h.log.Warn("Plugin is unhealthy, restarting", "attempt", failures, "error", err)
+		err = h.restart(restartable)
+		if err == nil {
+			err = h.probe()
+		}
+		if err == nil {
+			h.log.Info("Plugin restarted")
+			h.setHealthy()
+			return true
+		}
+	}
+}
+
+func (h *healthChecker) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.Timeout)
+	defer cancel()
+	_, err := h.plugin.Client.GetInfo(ctx, &emptypb.Empty{})
+	return err
+}
+
+func (h *healthChecker) restart(restartable clients.RestartablePluginClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.Timeout)
+	defer cancel()
+	h.mu.Lock()
+	h.status.Restarts++
+	h.mu.Unlock()
+	if err := restartable.Restart(ctx); err != nil {
+		return err
+	}
+	return h.configure(ctx, h.plugin)
+}
+
+func (h *healthChecker) backoff(attempt int) time.Duration {
+	backoff := h.opts.RestartBackoff
+	for i := 1; i < attempt && backoff < h.opts.MaxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > h.opts.MaxRestartBackoff {
+		backoff = h.opts.MaxRestartBackoff
+	}
+	return backoff
+}
+
+func (h *healthChecker) setHealthy() {
+	h.failures = 0
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.State = PluginStateHealthy
+	h.status.LastCheck = time.Now()
+	h.status.LastError = ""
+}
+
+func (h *healthChecker) setUnhealthy(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.State = PluginStateUnhealthy
+	h.status.LastCheck = time.Now()
+	h.status.LastError = err.Error()
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/plugins/clients"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+)
+
+func TestHealthChecks(t *testing.T) {
+	t.Parallel()
+	opts := HealthCheckOptions{
+		Interval:          time.Millisecond * 10,
+		Timeout:           time.Second,
+		MaxRestarts:       3,
+		RestartBackoff:    time.Millisecond,
+		MaxRestartBackoff: time.Millisecond * 10,
+	}
+
+	t.Run("RestartsAfterCrash", func(t *testing.T) {
+		t.Parallel()
+		plugin := &crashingPlugin{}
+		m := newHealthTestManager(t, plugin, opts)
+		if status := m.Status()[0]; status.State != PluginStateHealthy {
+			t.Fatalf("expected plugin to start healthy, got %s", status.State)
+		}
+		plugin.crash(false)
+		ok := testutil.Eventually[int](func() int {
+			return plugin.restartCount()
+		}).ShouldEqual(time.Second*5, time.Millisecond*10, 1)
+		if !ok {
+			t.Fatalf("expected plugin to be restarted once, got %d restarts", plugin.restartCount())
+		}
+		ok = testutil.Eventually[PluginState](func() PluginState {
+			return m.Status()[0].State
+		}).ShouldEqual(time.Second*5, time.Millisecond*10, PluginStateHealthy)
+		if !ok {
+			t.Fatalf("expected plugin to be healthy after restart, got %s", m.Status()[0].State)
+		}
+		status := m.Status()[0]
+		if status.Restarts != 1 {
+			t.Errorf("expected 1 restart, got %d", status.Restarts)
+		}
+		// The plugin must be configured again after it restarts.
+		if got := plugin.configureCount(); got != 2 {
+			t.Errorf("expected plugin to be configured twice, got %d", got)
+		}
+	})
+
+	t.Run("FailsAfterRepeatedCrashes", func(t *testing.T) {
+		t.Parallel()
+		plugin := &crashingPlugin{}
+		m := newHealthTestManager(t, plugin, opts)
+		plugin.crash(true)
+		ok := testutil.Eventually[PluginState](func() PluginState {
+			return m.Status()[0].State
+		}).ShouldEqual(time.Second*5, time.Millisecond*10, PluginStateFailed)
+		if !ok {
+			t.Fatalf("expected plugin to be marked failed, got %s", m.Status()[0].State)
+		}
+		if got := plugin.restartCount(); got != opts.MaxRestarts {
+			t.Errorf("expected %d restarts before failing, got %d", opts.MaxRestarts, got)
+		}
+		// No more restarts should be attempted once the plugin has failed.
+		time.Sleep(opts.Interval * 5)
+		if got := plugin.restartCount(); got != opts.MaxRestarts {
+			t.Errorf("expected no restarts after failing, got %d", got)
+		}
+		if m.Status()[0].LastError == "" {
+			t.Error("expected last error to be set on a failed plugin")
+		}
+	})
+}
+
+func newHealthTestManager(t *testing.T, plugin *crashingPlugin, opts HealthCheckOptions) Manager {
+	t.Helper()
+	m, err := NewManager(context.Background(), Options{
+		Plugins: map[string]Plugin{
+			"crashing": {Client: plugin},
+		},
+		Node:               NodeConfig{Key: crypto.MustGenerateKey()},
+		DisableDefaultIPAM: true,
+		HealthChecks:       opts,
+	})
+	if err != nil {
+		t.Fatalf("create plugin manager: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+// crashingPlugin is a restartable plugin client that can be made to crash.
+// When crashLoop is set it crashes again immediately after every restart.
+type crashingPlugin struct {
+	clients.PluginClient
+
+	crashed    bool
+	crashLoop  bool
+	restarts   int
+	configures int
+	mu         sync.Mutex
+}
+
+var errCrashed = status.Error(codes.Unavailable, "plugin crashed")
+
+func (p *crashingPlugin) crash(loop bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.crashed = true
+	p.crashLoop = loop
+}
+
+func (p *crashingPlugin) restartCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.restarts
+}
+
+func (p *crashingPlugin) configureCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.configures
+}
+
+func (p *crashingPlugin) GetInfo(context.Context, *emptypb.Empty, ...grpc.CallOption) (*v1.PluginInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.crashed {
+		return nil, errCrashed
+	}
+	return &v1.PluginInfo{Name: "crashing"}, nil
+}
+
+func (p *crashingPlugin) Configure(context.Context, *v1.PluginConfiguration, ...grpc.CallOption) (*emptypb.Empty, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.crashed {
+		return nil, errCrashed
+	}
+	p.configures++
+	return &emptypb.Empty{}, nil
+}
+
+func (p *crashingPlugin) Close(context.Context, *emptypb.Empty, ...grpc.CallOption) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (p *crashingPlugin) Restart(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.restarts++
+	p.crashed = p.crashLoop
+	return nil
+}
@@ -18,7 +18,9 @@ limitations under the License.
 package plugins
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
 	"net/netip"
 	"sync"
 
@@ -28,6 +30,8 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 // BuiltinIPAM is the built-in IPAM plugin that uses the mesh database
@@ -43,6 +47,9 @@ type BuiltinIPAM struct {
 type IPAMConfig struct {
 	// Storage is the storage plugin to use for IPAM.
 	Storage storage.MeshDB
+	// Reservations is the storage used for addresses reserved for nodes
+	// ahead of them joining. Addresses cannot be reserved when it is nil.
+	Reservations storage.MeshStorage
 	// StaticIPv4 is a map of node names to IPv4 addresses.
 	StaticIPv4 map[string]string
 }
@@ -62,32 +69,106 @@ func (p *BuiltinIPAM) Allocate(ctx context.Context, r *v1.AllocateIPRequest, opt
 			Ip: addr,
 		}, nil
 	}
-	return p.allocateV4(ctx, r)
+	reserved, err := p.reservations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := reserved[types.NodeID(r.GetNodeID())]; ok {
+		return &v1.AllocatedIP{
+			Ip: addr.String(),
+		}, nil
+	}
+	return p.allocateV4(ctx, r, reserved)
 }
 
+// Release removes the address reserved for the node in the request. Addresses
+// allocated from the mesh database are freed when the node is removed.
 func (p *BuiltinIPAM) Release(ctx context.Context, req *v1.ReleaseIPRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return nil, ErrUnsupported
+	if p.Reservations == nil {
+		return nil, ErrUnsupported
+	}
+	err := storage.DeleteIPReservation(ctx, p.Reservations, types.NodeID(req.GetNodeID()))
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
 }
 
-func (p *BuiltinIPAM) allocateV4(ctx context.Context, r *v1.AllocateIPRequest) (*v1.AllocatedIP, error) {
+// Reserve reserves the given address for a node ahead of it joining. The
+// address must not be allocated or reserved for any other node.
+func (p *BuiltinIPAM) Reserve(ctx context.Context, nodeID string, addr netip.Prefix) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Reservations == nil {
+		return ErrUnsupported
+	}
+	for id, static := range p.StaticIPv4 {
+		prefix, err := netip.ParsePrefix(static)
+		if err == nil && id != nodeID && prefix.Overlaps(addr) {
+			return fmt.Errorf("%w: %s is statically assigned to %s", ErrAddressInUse, addr, id)
+		}
+	}
+	nodes, err := p.Storage.Peers().List(ctx, storage.FilterAgainstNode(types.NodeID(nodeID)))
+	if err != nil {
+		return fmt.Errorf("list nodes: %w", err)
+	}
+	for _, node := range nodes {
+		if node.PrivateAddrV4().IsValid() && node.PrivateAddrV4().Overlaps(addr) {
+			return fmt.Errorf("%w: %s is allocated to %s", ErrAddressInUse, addr, node.GetId())
+		}
+	}
+	reserved, err := p.reservations(ctx)
+	if err != nil {
+		return err
+	}
+	for id, prefix := range reserved {
+		if id.String() != nodeID && prefix.Overlaps(addr) {
+			return fmt.Errorf("%w: %s is reserved for %s", ErrAddressInUse, addr, id)
+		}
+	}
+	return storage.PutIPReservation(ctx, p.Reservations, types.NodeID(nodeID), addr)
+}
+
+// reservations returns the reserved addresses keyed by node ID.
+func (p *BuiltinIPAM) reservations(ctx context.Context) (map[types.NodeID]netip.Prefix, error) {
+	if p.Reservations == nil {
+		return nil, nil
+	}
+	return storage.ListIPReservations(ctx, p.Reservations)
+}
+
+func (p *BuiltinIPAM) allocateV4(ctx context.Context, r *v1.AllocateIPRequest, reserved map[types.NodeID]netip.Prefix) (*v1.AllocatedIP, error) {
 	globalPrefix, err := netip.ParsePrefix(r.GetSubnet())
 	if err != nil {
 		return nil, fmt.Errorf("parse subnet: %w", err)
 	}
+	nodeBits := storage.DefaultIPv4NodePrefixLength
+	state, err := p.Storage.MeshState().GetMeshState(ctx)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("get mesh state: %w", err)
+	} else if err == nil {
+		nodeBits = state.NodePrefixLengthV4()
+	}
+	if nodeBits < globalPrefix.Bits() || nodeBits > 32 {
+		return nil, fmt.Errorf("node prefix length /%d does not fit in %s", nodeBits, globalPrefix)
+	}
 	nodes, err := p.Storage.Peers().List(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("list nodes: %w", err)
 	}
-	allocated := make(map[netip.Prefix]struct{}, len(nodes))
+	allocated := make([]netip.Prefix, 0, len(nodes)+len(reserved))
 	for _, node := range nodes {
 		n := node
 		if n.PrivateAddrV4().IsValid() {
-			allocated[n.PrivateAddrV4()] = struct{}{}
+			allocated = append(allocated, n.PrivateAddrV4().Masked())
 		}
 	}
-	prefix, err := p.next32(globalPrefix, allocated)
+	for _, prefix := range reserved {
+		allocated = append(allocated, prefix.Masked())
+	}
+	prefix, err := p.nextSubnet(globalPrefix.Masked(), nodeBits, allocated)
 	if err != nil {
 		return nil, fmt.Errorf("find next available IPv4: %w", err)
 	}
@@ -96,22 +177,37 @@ func (p *BuiltinIPAM) allocateV4(ctx context.Context, r *v1.AllocateIPRequest) (
 	}, nil
 }
 
-func (p *BuiltinIPAM) next32(cidr netip.Prefix, set map[netip.Prefix]struct{}) (netip.Prefix, error) {
-	ip := cidr.Addr().Next()
-	for cidr.Contains(ip) {
-		prefix := netip.PrefixFrom(ip, 32)
-		if _, ok := set[prefix]; !ok && !p.isStaticAllocation(prefix) {
-			return prefix, nil
+// nextSubnet returns the first subnet of the given size in cidr that does not
+// overlap any allocated or static prefix. Single addresses are returned as /32s,
+// larger subnets as the first host address in the subnet with the subnet's prefix
+// length. The network address of cidr is never handed out.
+func (p *BuiltinIPAM) nextSubnet(cidr netip.Prefix, bits int, allocated []netip.Prefix) (netip.Prefix, error) {
+	block := netip.PrefixFrom(cidr.Addr(), bits)
+	for cidr.Contains(block.Addr()) {
+		ip := block.Addr()
+		if bits < 32 {
+			ip = ip.Next()
+		}
+		if ip != cidr.Addr() && !overlapsAny(block, allocated) && !p.isStaticAllocation(block) {
+			return netip.PrefixFrom(ip, bits), nil
 		}
-		ip = ip.Next()
+		next, ok := nextBlock(block)
+		if !ok {
+			break
+		}
+		block = next
 	}
-	return netip.Prefix{}, fmt.Errorf("no more addresses in %s", cidr)
+	return netip.Prefix{}, fmt.Errorf("no more /%d subnets in %s", bits, cidr)
 }
 
-func (p *BuiltinIPAM) isStaticAllocation(ip netip.Prefix) bool {
-	if ip.Addr().Is4() {
+func (p *BuiltinIPAM) isStaticAllocation(block netip.Prefix) bool {
+	if block.Addr().Is4() {
 		for _, addr := range p.StaticIPv4 {
-			if addr == ip.String() {
+			prefix, err := netip.ParsePrefix(addr)
+			if err != nil {
+				continue
+			}
+			if prefix.Overlaps(block) {
 				return true
 			}
 		}
@@ -119,3 +215,23 @@ func (p *BuiltinIPAM) isStaticAllocation(ip netip.Prefix) bool {
 	}
 	return false
 }
+
+// nextBlock returns the subnet of the same size directly after block.
+func nextBlock(block netip.Prefix) (netip.Prefix, bool) {
+	addr := block.Addr().As4()
+	next := uint64(binary.BigEndian.Uint32(addr[:])) + uint64(1)<<(32-block.Bits())
+	if next > math.MaxUint32 {
+		return netip.Prefix{}, false
+	}
+	binary.BigEndian.PutUint32(addr[:], uint32(next))
+	return netip.PrefixFrom(netip.AddrFrom4(addr), block.Bits()), true
+}
+
+func overlapsAny(block netip.Prefix, prefixes []netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Overlaps(block) {
+			return true
+		}
+	}
+	return false
+}
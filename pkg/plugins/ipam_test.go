@@ -0,0 +1,173 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestBuiltinIPAMNodePrefixLength(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newIPAM := func(t *testing.T, network string, nodeBits int, static map[string]string) (storage.MeshDB, *BuiltinIPAM) {
+		t.Helper()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		_, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{
+			IPv4Network:          network,
+			IPv4NodePrefixLength: nodeBits,
+		})
+		if err != nil {
+			t.Fatalf("bootstrap: %v", err)
+		}
+		return db, NewBuiltinIPAM(IPAMConfig{Storage: db, StaticIPv4: static})
+	}
+	allocate := func(t *testing.T, db storage.MeshDB, ipam *BuiltinIPAM, network, id string) (string, error) {
+		t.Helper()
+		res, err := ipam.Allocate(ctx, &v1.AllocateIPRequest{NodeID: id, Subnet: network})
+		if err != nil {
+			return "", err
+		}
+		err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PrivateIPv4: res.GetIp(),
+		}})
+		if err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+		return res.GetIp(), nil
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		db, ipam := newIPAM(t, "10.10.0.0/24", 0, nil)
+		for i, want := range []string{"10.10.0.1/32", "10.10.0.2/32"} {
+			got, err := allocate(t, db, ipam, "10.10.0.0/24", fmt.Sprintf("node-%d", i))
+			if err != nil {
+				t.Fatalf("allocate: %v", err)
+			}
+			if got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		}
+	})
+
+	t.Run("CustomPrefix", func(t *testing.T) {
+		t.Parallel()
+		db, ipam := newIPAM(t, "10.10.0.0/24", 30, map[string]string{"static": "10.10.0.6/32"})
+		// The second /30 is taken by the static assignment.
+		for i, want := range []string{"10.10.0.1/30", "10.10.0.9/30", "10.10.0.13/30"} {
+			got, err := allocate(t, db, ipam, "10.10.0.0/24", fmt.Sprintf("node-%d", i))
+			if err != nil {
+				t.Fatalf("allocate: %v", err)
+			}
+			if got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		t.Parallel()
+		db, ipam := newIPAM(t, "10.10.0.0/29", 30, nil)
+		for _, id := range []string{"node-a", "node-b"} {
+			if _, err := allocate(t, db, ipam, "10.10.0.0/29", id); err != nil {
+				t.Fatalf("allocate: %v", err)
+			}
+		}
+		if _, err := allocate(t, db, ipam, "10.10.0.0/29", "node-c"); err == nil {
+			t.Fatal("expected allocation from an exhausted network to fail")
+		}
+	})
+}
+
+func TestBuiltinIPAMReservations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	db := meshdb.NewFromStorage(st)
+	_, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{IPv4Network: "10.10.0.0/24"})
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	ipam := NewBuiltinIPAM(IPAMConfig{
+		Storage:      db,
+		Reservations: st,
+		StaticIPv4:   map[string]string{"static": "10.10.0.2/32"},
+	})
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "existing", PrivateIPv4: "10.10.0.3/32"}})
+	if err != nil {
+		t.Fatalf("put node: %v", err)
+	}
+
+	// Addresses in use by other nodes cannot be reserved.
+	for _, addr := range []string{"10.10.0.2/32", "10.10.0.3/32"} {
+		err := ipam.Reserve(ctx, "registered", netip.MustParsePrefix(addr))
+		if !errors.Is(err, ErrAddressInUse) {
+			t.Errorf("expected reserving %s to fail with ErrAddressInUse, got %v", addr, err)
+		}
+	}
+	if err := ipam.Reserve(ctx, "registered", netip.MustParsePrefix("10.10.0.1/32")); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if err := ipam.Reserve(ctx, "other", netip.MustParsePrefix("10.10.0.1/32")); !errors.Is(err, ErrAddressInUse) {
+		t.Errorf("expected reserving an address reserved for another node to fail, got %v", err)
+	}
+
+	// Other nodes are allocated around the reservation, and the node it is
+	// reserved for is allocated the reserved address.
+	res, err := ipam.Allocate(ctx, &v1.AllocateIPRequest{NodeID: "other", Subnet: "10.10.0.0/24"})
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if res.GetIp() != "10.10.0.4/32" {
+		t.Errorf("expected 10.10.0.4/32 for another node, got %s", res.GetIp())
+	}
+	res, err = ipam.Allocate(ctx, &v1.AllocateIPRequest{NodeID: "registered", Subnet: "10.10.0.0/24"})
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if res.GetIp() != "10.10.0.1/32" {
+		t.Errorf("expected the reserved address, got %s", res.GetIp())
+	}
+
+	// Releasing the reservation makes the address available again.
+	if _, err := ipam.Release(ctx, &v1.ReleaseIPRequest{NodeID: "registered"}); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	res, err = ipam.Allocate(ctx, &v1.AllocateIPRequest{NodeID: "other", Subnet: "10.10.0.0/24"})
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if res.GetIp() != "10.10.0.1/32" {
+		t.Errorf("expected the released address, got %s", res.GetIp())
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"sync"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestRegisterLocal(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := NewManagerWithDB(nil)
+	plugin := &localPlugin{}
+	if err := m.RegisterLocal(ctx, "local", plugin); err != nil {
+		t.Fatalf("register local plugin: %v", err)
+	}
+	if err := m.RegisterLocal(ctx, "local", &localPlugin{}); err == nil {
+		t.Fatal("expected error registering a duplicate plugin")
+	}
+	if _, ok := m.Get("local"); !ok {
+		t.Fatal("expected local plugin to be returned by Get")
+	}
+	caps, ok := m.Capabilities("local")
+	if !ok || !caps.Has(v1.PluginInfo_WATCH) || !caps.Has(v1.PluginInfo_IPAMV4) {
+		t.Fatalf("expected WATCH and IPAMV4 capabilities, got %v", caps.List())
+	}
+	if plugin.configured != 1 {
+		t.Errorf("expected plugin to be configured once, got %d", plugin.configured)
+	}
+
+	// The local plugin should be used for IPAM.
+	addr, err := m.AllocateIP(ctx, &v1.AllocateIPRequest{NodeID: "node-a"})
+	if err != nil {
+		t.Fatalf("allocate ip: %v", err)
+	}
+	if addr.String() != "172.16.0.1/32" {
+		t.Errorf("expected address from local plugin, got %s", addr)
+	}
+
+	// And it should receive events like any external watch plugin.
+	if !m.HasWatchers() {
+		t.Fatal("expected manager to have watchers")
+	}
+	for _, typ := range []v1.Event_WatchEvent{v1.Event_NODE_JOIN, v1.Event_LEADER_CHANGE} {
+		if err := m.Emit(ctx, &v1.Event{Type: typ}); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("close manager: %v", err)
+	}
+	if got := plugin.received(); len(got) != 2 || got[0] != v1.Event_NODE_JOIN || got[1] != v1.Event_LEADER_CHANGE {
+		t.Errorf("expected NODE_JOIN and LEADER_CHANGE events, got %v", got)
+	}
+	if !plugin.closed {
+		t.Error("expected local plugin to be closed with the manager")
+	}
+	if err := m.RegisterLocal(ctx, "late", &localPlugin{}); !errors.Is(err, ErrManagerClosed) {
+		t.Errorf("expected ErrManagerClosed registering after close, got %v", err)
+	}
+}
+
+// localPlugin is an in-process watch and IPAM plugin.
+type localPlugin struct {
+	v1.UnimplementedPluginServer
+	v1.UnimplementedWatchPluginServer
+	v1.UnimplementedIPAMPluginServer
+
+	configured int
+	closed     bool
+	events     []v1.Event_WatchEvent
+	mu         sync.Mutex
+}
+
+func (p *localPlugin) GetInfo(context.Context, *emptypb.Empty) (*v1.PluginInfo, error) {
+	return &v1.PluginInfo{
+		Name:         "local",
+		Capabilities: []Capability{v1.PluginInfo_WATCH, v1.PluginInfo_IPAMV4},
+	}, nil
+}
+
+func (p *localPlugin) Configure(context.Context, *v1.PluginConfiguration) (*emptypb.Empty, error) {
+	p.configured++
+	return &emptypb.Empty{}, nil
+}
+
+func (p *localPlugin) Close(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	p.closed = true
+	return &emptypb.Empty{}, nil
+}
+
+func (p *localPlugin) Emit(_ context.Context, ev *v1.Event) (*emptypb.Empty, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, ev.GetType())
+	return &emptypb.Empty{}, nil
+}
+
+func (p *localPlugin) Allocate(context.Context, *v1.AllocateIPRequest) (*v1.AllocatedIP, error) {
+	return &v1.AllocatedIP{Ip: "172.16.0.1/32"}, nil
+}
+
+func (p *localPlugin) received() []v1.Event_WatchEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]v1.Event_WatchEvent(nil), p.events...)
+}
@@ -22,7 +22,10 @@ import (
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc"
@@ -44,6 +47,9 @@ var (
 	// ErrUnsupported is returned when a plugin capability is not supported
 	// by any of the registered plugins.
 	ErrUnsupported = status.Error(codes.Unimplemented, "unsupported plugin capability")
+	// ErrAddressInUse is returned when an address cannot be reserved because
+	// it is allocated or reserved for another node.
+	ErrAddressInUse = status.Error(codes.AlreadyExists, "address is in use by another node")
 )
 
 // Options are the options for creating a new plugin manager.
@@ -58,8 +64,21 @@ type Options struct {
 	DisableDefaultIPAM bool
 	// DefaultIPAMStaticIPv4 is a map of node names to IPv4 addresses.
 	DefaultIPAMStaticIPv4 map[string]string
+	// EventDispatch are options for dispatching events to watch plugins.
+	EventDispatch EventDispatchOptions
+	// HealthChecks are options for checking the health of plugins.
+	HealthChecks HealthCheckOptions
+	// DrainTimeout is how long to wait for queued events to be delivered and
+	// for plugins to flush in-flight work when closing. Defaults to DefaultDrainTimeout.
+	DrainTimeout time.Duration
+	// RequireSignedRoutes rejects routes put by plugins through storage
+	// queries that are not signed by the node advertising them.
+	RequireSignedRoutes bool
 }
 
+// DefaultDrainTimeout is the default time to wait for plugins to drain when closing.
+const DefaultDrainTimeout = time.Second * 10
+
 // NodeConfig is the configuration of the node to pass to each plugin.
 type NodeConfig struct {
 	// NodeID is the ID of the node.
@@ -78,6 +97,34 @@ type NodeConfig struct {
 	Key crypto.PrivateKey
 }
 
+// configure configures the plugin with its configuration and the node configuration.
+func (n NodeConfig) configure(ctx context.Context, plugin *Plugin) error {
+	conf, err := structpb.NewStruct(plugin.Config)
+	if err != nil {
+		return fmt.Errorf("convert plugin config to structpb: %w", err)
+	}
+	var key []byte
+	if n.Key != nil {
+		key = n.Key.Bytes()
+	}
+	_, err = plugin.Client.Configure(ctx, &v1.PluginConfiguration{
+		Config: conf,
+		NodeConfig: &v1.NodeConfiguration{
+			Id:          n.NodeID.String(),
+			NetworkIPv4: n.NetworkIPv4.String(),
+			NetworkIPv6: n.NetworkIPv6.String(),
+			AddressIPv4: n.AddressIPv4.String(),
+			AddressIPv6: n.AddressIPv6.String(),
+			Domain:      n.Domain,
+			PrivateKey:  key,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("configure plugin: %w", err)
+	}
+	return nil
+}
+
 // Plugin represents a plugin client and its configuration.
 type Plugin struct {
 	// Client is the plugin client.
@@ -85,26 +132,33 @@ type Plugin struct {
 	// Config is the plugin configuration.
 	Config map[string]any
 
-	// capabilities discovered from the plugin when we started.
-	capabilities []v1.PluginInfo_PluginCapability
+	// capabilities negotiated with the plugin when we started.
+	capabilities Capabilities
 	// name is the name returned by the plugin.
 	name string
 }
 
-// hasCapability returns true if the plugin has the given capability.
+// Capabilities returns the capabilities negotiated with the plugin.
+func (p *Plugin) Capabilities() Capabilities {
+	return p.capabilities
+}
+
+// hasCapability returns true if the given capability was negotiated with the plugin.
 func (p *Plugin) hasCapability(cap v1.PluginInfo_PluginCapability) bool {
-	for _, c := range p.capabilities {
-		if c == cap {
-			return true
-		}
-	}
-	return false
+	return p.capabilities.Has(cap)
 }
 
 // Manager is the interface for managing plugins.
 type Manager interface {
 	// Get returns the plugin with the given name.
 	Get(name string) (clients.PluginClient, bool)
+	// RegisterLocal registers an in-process plugin implemented directly in Go.
+	// The plugin is loaded and wired into the same dispatch paths as external
+	// plugins for the capabilities it declares. Auth plugins must be registered
+	// before any services using the manager's interceptors are started.
+	RegisterLocal(ctx context.Context, name string, impl v1.PluginServer) error
+	// Capabilities returns the capabilities negotiated with the plugin with the given name.
+	Capabilities(name string) (Capabilities, bool)
 	// HasAuth returns true if the manager has an auth plugin.
 	HasAuth() bool
 	// HasWatchers returns true if the manager has any watch plugins.
@@ -121,9 +175,18 @@ type Manager interface {
 	// ReleaseIP calls the configured IPAM plugin to release an IP address for the given request.
 	// If no IPAM plugin is configured, ErrUnsupported is returned.
 	ReleaseIP(ctx context.Context, req *v1.ReleaseIPRequest) error
-	// Emit emits an event to all watch plugins.
+	// ReserveIP calls the configured IPAM plugin to reserve the given IPv4 address for a node
+	// ahead of it joining. Later allocations for the node return the reserved address. If the
+	// IPAM plugin cannot reserve addresses, ErrUnsupported is returned.
+	ReserveIP(ctx context.Context, nodeID string, addr netip.Prefix) error
+	// Status returns the health status of each plugin sorted by name.
+	Status() []PluginStatus
+	// Emit queues an event for delivery to all watch plugins. Events are
+	// delivered asynchronously, and an error is only returned if the event
+	// could not be queued for one or more plugins.
 	Emit(ctx context.Context, ev *v1.Event) error
-	// Close closes all plugins.
+	// Close drains queued events and closes all plugins, giving them a chance to
+	// flush in-flight work. It waits at most the configured drain timeout.
 	Close() error
 }
 
@@ -131,44 +194,32 @@ type Manager interface {
 func NewManager(ctx context.Context, opts Options) (Manager, error) {
 	// Create the manager.
 	log := context.LoggerFrom(ctx).With("component", "plugin-manager")
+	if err := opts.EventDispatch.Validate(); err != nil {
+		return nil, err
+	}
+	m := &manager{
+		storage: opts.Storage,
+		plugins: make(map[string]*Plugin, len(opts.Plugins)),
+		opts:    opts,
+		log:     log,
+	}
 	plugins := make(map[string]*Plugin, len(opts.Plugins))
-	for n, plugin := range opts.Plugins {
-		name := n
+	for n, p := range opts.Plugins {
+		name, plugin := n, p
 		plugins[name] = &plugin
 	}
 	// Query each plugin for its capabilities.
 	for name, plugin := range plugins {
-		log.Debug("Querying plugin capabilities", "plugin", name)
-		resp, err := plugin.Client.GetInfo(ctx, &emptypb.Empty{})
-		if err != nil {
-			return nil, fmt.Errorf("get plugin info: %w", err)
-		}
-		log.Debug("Plugin info", slog.Any("info", resp))
-		plugin.capabilities = resp.GetCapabilities()
-		plugin.name = resp.GetName()
-		// Configure the plugin
-		conf, err := structpb.NewStruct(plugin.Config)
+		err := m.loadPlugin(ctx, name, plugin)
 		if err != nil {
-			return nil, fmt.Errorf("convert plugin config to structpb: %w", err)
-		}
-		_, err = plugin.Client.Configure(ctx, &v1.PluginConfiguration{
-			Config: conf,
-			NodeConfig: &v1.NodeConfiguration{
-				Id:          opts.Node.NodeID.String(),
-				NetworkIPv4: opts.Node.NetworkIPv4.String(),
-				NetworkIPv6: opts.Node.NetworkIPv6.String(),
-				AddressIPv4: opts.Node.AddressIPv4.String(),
-				AddressIPv6: opts.Node.AddressIPv6.String(),
-				Domain:      opts.Node.Domain,
-				PrivateKey:  opts.Node.Key.Bytes(),
-			},
-		})
-		if err != nil {
-			return nil, fmt.Errorf("configure plugin: %w", err)
+			return nil, err
 		}
 	}
 	handleErr := func(cause error) error {
 		// Make sure we close all plugins if we fail to start.
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultDrainTimeout)
+		defer cancel()
+		_ = m.stop(ctx)
 		for _, plugin := range plugins {
 			_, err := plugin.Client.Close(context.Background(), &emptypb.Empty{})
 			if err != nil {
@@ -182,35 +233,20 @@ func NewManager(ctx context.Context, opts Options) (Manager, error) {
 	}
 	// We only support a single auth and IPv4 mechanism for now. So only
 	// track the first ones we see
-	var auth *Plugin
-	var ipamv4 IPAMPlugin
 	for name, plugin := range plugins {
-		if plugin.hasCapability(v1.PluginInfo_AUTH) {
-			if auth != nil {
-				return nil, handleErr(fmt.Errorf("multiple auth plugins found: %s, %s", auth.name, name))
-			}
-			auth = plugin
-		}
-		if plugin.hasCapability(v1.PluginInfo_IPAMV4) {
-			if ipamv4 != nil {
-				return nil, handleErr(fmt.Errorf("extra IPAM plugin found: %s", name))
-			}
-			ipamv4 = plugin.Client.IPAM()
+		err := m.registerPlugin(name, plugin)
+		if err != nil {
+			return nil, handleErr(err)
 		}
 	}
 	// If we didn't find any IPAM plugins, register the default one
-	if ipamv4 == nil && !opts.DisableDefaultIPAM {
-		ipamv4 = NewBuiltinIPAM(IPAMConfig{
-			Storage:    opts.Storage.MeshDB(),
-			StaticIPv4: opts.DefaultIPAMStaticIPv4,
+	if m.ipamv4 == nil && !opts.DisableDefaultIPAM {
+		m.ipamv4 = NewBuiltinIPAM(IPAMConfig{
+			Storage:      opts.Storage.MeshDB(),
+			Reservations: opts.Storage.MeshStorage(),
+			StaticIPv4:   opts.DefaultIPAMStaticIPv4,
 		})
-	}
-	m := &manager{
-		storage: opts.Storage,
-		plugins: plugins,
-		auth:    auth,
-		ipamv4:  ipamv4,
-		log:     log,
+		m.defaultIPAM = true
 	}
 	go m.handleQueries(opts.Storage)
 	return m, nil
@@ -222,9 +258,63 @@ func NewManagerWithDB(db storage.Provider) Manager {
 	return &manager{
 		storage: db,
 		plugins: make(map[string]*Plugin),
+		opts:    Options{HealthChecks: HealthCheckOptions{Disabled: true}},
+		log:     slog.Default().With("component", "plugin-manager"),
 	}
 }
 
+// loadPlugin negotiates capabilities with the plugin and configures it.
+func (m *manager) loadPlugin(ctx context.Context, name string, plugin *Plugin) error {
+	m.log.Debug("Querying plugin capabilities", "plugin", name)
+	resp, err := plugin.Client.GetInfo(ctx, &emptypb.Empty{})
+	if err != nil {
+		return fmt.Errorf("get plugin info: %w", err)
+	}
+	m.log.Debug("Plugin info", slog.Any("info", resp))
+	plugin.capabilities = NegotiateCapabilities(resp)
+	plugin.name = resp.GetName()
+	if unsupported := plugin.capabilities.Unsupported(resp); len(unsupported) > 0 {
+		m.log.Warn("Plugin declared unsupported capabilities, they will not be used", "plugin", name, "capabilities", unsupported)
+	}
+	m.log.Debug("Negotiated plugin capabilities", "plugin", name, "capabilities", plugin.capabilities.List())
+	return m.opts.Node.configure(ctx, plugin)
+}
+
+// registerPlugin wires a loaded plugin into the dispatch paths for its capabilities.
+func (m *manager) registerPlugin(name string, plugin *Plugin) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrManagerClosed
+	}
+	if _, ok := m.plugins[name]; ok {
+		return fmt.Errorf("plugin already registered: %s", name)
+	}
+	if plugin.hasCapability(v1.PluginInfo_AUTH) && m.auth != nil {
+		return fmt.Errorf("multiple auth plugins found: %s, %s", m.auth.name, name)
+	}
+	if plugin.hasCapability(v1.PluginInfo_IPAMV4) && m.ipamv4 != nil && !m.defaultIPAM {
+		return fmt.Errorf("extra IPAM plugin found: %s", name)
+	}
+	if plugin.hasCapability(v1.PluginInfo_AUTH) {
+		m.auth = plugin
+	}
+	if plugin.hasCapability(v1.PluginInfo_IPAMV4) {
+		m.ipamv4 = plugin.Client.IPAM()
+		m.defaultIPAM = false
+	}
+	if plugin.hasCapability(v1.PluginInfo_WATCH) {
+		m.dispatchers = append(m.dispatchers, newEventDispatcher(plugin, m.opts.EventDispatch, m.log))
+	}
+	checker := newHealthChecker(name, plugin, m.opts.HealthChecks, m.opts.Node.configure, m.log)
+	if !m.opts.HealthChecks.Disabled {
+		checker.start()
+	}
+	m.checkers = append(m.checkers, checker)
+	m.plugins[name] = plugin
+	return nil
+}
+
 // IPAMPlugin wraps the interface of the IPAM plugin only exposing the Allocate method.
 // This makes for ease of use with the built-in IPAM.
 type IPAMPlugin interface {
@@ -232,47 +322,90 @@ type IPAMPlugin interface {
 	Release(ctx context.Context, r *v1.ReleaseIPRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
 
+// IPAMReserver is implemented by IPAM plugins that can reserve an address for
+// a node ahead of it joining.
+type IPAMReserver interface {
+	Reserve(ctx context.Context, nodeID string, addr netip.Prefix) error
+}
+
 type manager struct {
-	storage storage.Provider
-	plugins map[string]*Plugin
-	auth    *Plugin
-	ipamv4  IPAMPlugin
-	log     context.Logger
+	storage     storage.Provider
+	plugins     map[string]*Plugin
+	auth        *Plugin
+	ipamv4      IPAMPlugin
+	defaultIPAM bool
+	dispatchers []*eventDispatcher
+	checkers    []*healthChecker
+	opts        Options
+	closed      bool
+	mu          sync.RWMutex
+	log         context.Logger
+}
+
+// RegisterLocal registers an in-process plugin implemented directly in Go.
+// The plugin is loaded and wired into the same dispatch paths as external
+// plugins for the capabilities it declares.
+func (m *manager) RegisterLocal(ctx context.Context, name string, impl v1.PluginServer) error {
+	if _, ok := m.Get(name); ok {
+		return fmt.Errorf("plugin already registered: %s", name)
+	}
+	plugin := &Plugin{Client: clients.NewInProcessClient(impl)}
+	err := m.loadPlugin(ctx, name, plugin)
+	if err != nil {
+		return err
+	}
+	err = m.registerPlugin(name, plugin)
+	if err != nil {
+		_, _ = plugin.Client.Close(context.Background(), &emptypb.Empty{})
+		return err
+	}
+	m.startQueries(m.storage, name, plugin)
+	return nil
 }
 
 // Get returns the plugin with the given name.
 func (m *manager) Get(name string) (clients.PluginClient, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	p, ok := m.plugins[name]
-	return p.Client, ok
+	if !ok {
+		return nil, false
+	}
+	return p.Client, true
+}
+
+// Capabilities returns the capabilities negotiated with the plugin with the given name.
+func (m *manager) Capabilities(name string) (Capabilities, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.plugins[name]
+	if !ok {
+		return nil, false
+	}
+	return p.Capabilities(), true
 }
 
 // HasAuth returns true if the manager has an auth plugin.
 func (m *manager) HasAuth() bool {
-	return m.auth != nil
+	return m.authPlugin() != nil
 }
 
 // HasWatchers returns true if the manager has any watch plugins.
 func (m *manager) HasWatchers() bool {
-	for _, plugin := range m.plugins {
-		if plugin.hasCapability(v1.PluginInfo_WATCH) {
-			return true
-		}
-	}
-	return false
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.dispatchers) > 0
 }
 
 // AuthUnaryInterceptor returns a unary interceptor for the configured auth plugin.
 // If no plugin is configured, the returned function is a no-op.
 func (m *manager) AuthUnaryInterceptor() grpc.UnaryServerInterceptor {
-	var icep grpc.UnaryServerInterceptor
-	if m.auth != nil {
-		icep = NewAuthUnaryInterceptor(m.auth.Client.Auth())
-	}
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if m.auth == nil {
+		auth := m.authPlugin()
+		if auth == nil {
 			return handler(ctx, req)
 		}
-		return icep(ctx, req, info, handler)
+		return NewAuthUnaryInterceptor(auth.Client.Auth())(ctx, req, info, handler)
 	}
 }
 
@@ -293,15 +426,12 @@ func NewAuthUnaryInterceptor(plugin v1.AuthPluginClient) grpc.UnaryServerInterce
 // AuthStreamInterceptor returns a stream interceptor for the configured auth plugin.
 // If no plugin is configured, the returned function is a no-op.
 func (m *manager) AuthStreamInterceptor() grpc.StreamServerInterceptor {
-	var icep grpc.StreamServerInterceptor
-	if m.auth != nil {
-		icep = NewAuthStreamInterceptor(m.auth.Client.Auth())
-	}
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		if m.auth == nil {
+		auth := m.authPlugin()
+		if auth == nil {
 			return handler(srv, ss)
 		}
-		return icep(srv, ss, info, handler)
+		return NewAuthStreamInterceptor(auth.Client.Auth())(srv, ss, info, handler)
 	}
 }
 
@@ -324,10 +454,11 @@ func NewAuthStreamInterceptor(plugin v1.AuthPluginClient) grpc.StreamServerInter
 func (m *manager) AllocateIP(ctx context.Context, req *v1.AllocateIPRequest) (netip.Prefix, error) {
 	var addr netip.Prefix
 	var err error
-	if m.ipamv4 == nil {
+	ipamv4 := m.ipamPlugin()
+	if ipamv4 == nil {
 		return addr, ErrUnsupported
 	}
-	res, err := m.ipamv4.Allocate(ctx, req)
+	res, err := ipamv4.Allocate(ctx, req)
 	if err != nil {
 		return addr, fmt.Errorf("allocate IPv4: %w", err)
 	}
@@ -341,23 +472,49 @@ func (m *manager) AllocateIP(ctx context.Context, req *v1.AllocateIPRequest) (ne
 // ReleaseIP calls the configured IPAM plugin to release an IP address for the given request.
 // If no IPAM plugin is configured, ErrUnsupported is returned.
 func (m *manager) ReleaseIP(ctx context.Context, req *v1.ReleaseIPRequest) error {
-	if m.ipamv4 == nil {
+	ipamv4 := m.ipamPlugin()
+	if ipamv4 == nil {
 		return ErrUnsupported
 	}
-	_, err := m.ipamv4.Release(ctx, req)
+	_, err := ipamv4.Release(ctx, req)
 	return err
 }
 
-// Emit emits an event to all watch plugins.
+// ReserveIP calls the configured IPAM plugin to reserve the given IPv4 address for a node
+// ahead of it joining. If the IPAM plugin cannot reserve addresses, ErrUnsupported is returned.
+func (m *manager) ReserveIP(ctx context.Context, nodeID string, addr netip.Prefix) error {
+	reserver, ok := m.ipamPlugin().(IPAMReserver)
+	if !ok {
+		return ErrUnsupported
+	}
+	return reserver.Reserve(ctx, nodeID, addr)
+}
+
+// Status returns the health status of each plugin sorted by name.
+func (m *manager) Status() []PluginStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]PluginStatus, 0, len(m.checkers))
+	for _, checker := range m.checkers {
+		out = append(out, checker.Status())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Emit queues an event for delivery to all watch plugins. Events are
+// delivered asynchronously, and an error is only returned if the event
+// could not be queued for one or more plugins.
 func (m *manager) Emit(ctx context.Context, ev *v1.Event) error {
+	m.mu.RLock()
+	dispatchers := m.dispatchers
+	m.mu.RUnlock()
 	errs := make([]error, 0)
-	for _, plugin := range m.plugins {
-		if plugin.hasCapability(v1.PluginInfo_WATCH) {
-			m.log.Debug("Emitting event", "plugin", plugin.name, "event", ev.String())
-			_, err := plugin.Client.Events().Emit(ctx, ev)
-			if err != nil {
-				errs = append(errs, err)
-			}
+	for _, d := range dispatchers {
+		if err := d.enqueue(ctx, ev); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if len(errs) > 0 {
@@ -366,48 +523,130 @@ func (m *manager) Emit(ctx context.Context, ev *v1.Event) error {
 	return nil
 }
 
-// Close closes all plugins.
+// Close drains queued events and closes all plugins, giving them a chance to
+// flush in-flight work. It waits at most the configured drain timeout.
 func (m *manager) Close() error {
-	errs := make([]error, 0)
-	for _, p := range m.plugins {
-		_, err := p.Client.Close(context.Background(), &emptypb.Empty{})
-		if err != nil {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	timeout := m.opts.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	errs := m.stop(ctx)
+	m.mu.RLock()
+	plugins := make(map[string]*Plugin, len(m.plugins))
+	for name, plugin := range m.plugins {
+		plugins[name] = plugin
+	}
+	m.mu.RUnlock()
+	// Close plugins concurrently so a slow plugin doesn't eat into the
+	// time the others have to flush.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, plugin := range plugins {
+		wg.Add(1)
+		go func(name string, plugin *Plugin) {
+			defer wg.Done()
+			m.log.Debug("Closing plugin", "plugin", name)
+			_, err := plugin.Client.Close(ctx, &emptypb.Empty{})
 			// Don't report unimplemented close methods.
-			if status.Code(err) != codes.Unimplemented {
-				errs = append(errs, err)
+			if err != nil && status.Code(err) != codes.Unimplemented {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				mu.Unlock()
 			}
-		}
+		}(name, plugin)
 	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		mu.Lock()
+		errs = append(errs, fmt.Errorf("timed out waiting for plugins to close: %w", ctx.Err()))
+		mu.Unlock()
+	}
+	mu.Lock()
+	defer mu.Unlock()
 	if len(errs) > 0 {
-		return fmt.Errorf("close: %v", errs)
+		return fmt.Errorf("close: %w", errors.Join(errs...))
 	}
 	return nil
 }
 
+// stop stops health checks and waits for queued events to be delivered
+// or the context to be cancelled.
+func (m *manager) stop(ctx context.Context) []error {
+	m.mu.RLock()
+	checkers, dispatchers := m.checkers, m.dispatchers
+	m.mu.RUnlock()
+	for _, checker := range checkers {
+		checker.close()
+	}
+	var errs []error
+	for _, d := range dispatchers {
+		if err := d.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (m *manager) authPlugin() *Plugin {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.auth
+}
+
+func (m *manager) ipamPlugin() IPAMPlugin {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ipamv4
+}
+
 // handleQueries handles SQL queries from plugins.
 func (m *manager) handleQueries(db storage.Provider) {
-	for plugin, client := range m.plugins {
-		if !client.hasCapability(v1.PluginInfo_STORAGE_QUERIER) {
-			return
-		}
-		ctx := context.Background()
-		m.log.Debug("Starting plugin query stream", "plugin", plugin)
-		q, err := client.Client.Storage().InjectQuerier(ctx)
-		if err != nil {
-			if status.Code(err) == codes.Unimplemented {
-				m.log.Debug("plugin does not implement queries", "plugin", plugin)
-				return
-			}
-			m.log.Error("Start query stream", "plugin", plugin, "error", err)
+	m.mu.RLock()
+	plugins := make(map[string]*Plugin, len(m.plugins))
+	for name, plugin := range m.plugins {
+		plugins[name] = plugin
+	}
+	m.mu.RUnlock()
+	for name, plugin := range plugins {
+		m.startQueries(db, name, plugin)
+	}
+}
+
+// startQueries starts handling SQL queries from the plugin if it is a storage querier.
+func (m *manager) startQueries(db storage.Provider, name string, plugin *Plugin) {
+	if !plugin.hasCapability(v1.PluginInfo_STORAGE_QUERIER) {
+		return
+	}
+	ctx := context.Background()
+	m.log.Debug("Starting plugin query stream", "plugin", name)
+	q, err := plugin.Client.Storage().InjectQuerier(ctx)
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			m.log.Debug("plugin does not implement queries", "plugin", name)
 			return
 		}
-		go m.handleQueryClient(plugin, db, q)
+		m.log.Error("Start query stream", "plugin", name, "error", err)
+		return
 	}
+	go m.handleQueryClient(name, db, q)
 }
 
 // handleQueryClient handles a query client.
 func (m *manager) handleQueryClient(plugin string, db storage.Provider, queries v1.StorageQuerierPlugin_InjectQuerierClient) {
-	err := rpcsrv.Serve(context.WithLogger(context.Background(), m.log), db, queries)
+	err := rpcsrv.ServeWithOptions(context.WithLogger(context.Background(), m.log), db, queries, rpcsrv.Options{
+		RequireSignedRoutes: m.opts.RequireSignedRoutes,
+	})
 	if err != nil {
 		m.log.Error("Error handling query stream", "plugin", plugin, "error", err)
 	}
@@ -0,0 +1,65 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// CodecName is the content subtype used by the admin extensions service.
+// Requests and responses are encoded as JSON, using protojson for protobuf
+// messages.
+const CodecName = "webmesh-admin-json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec is a gRPC codec for messages that are not generated from
+// protobuf definitions.
+type jsonCodec struct{}
+
+// Marshal implements encoding.Codec.
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	switch msg := v.(type) {
+	case json.Marshaler:
+		return msg.MarshalJSON()
+	case proto.Message:
+		return protojson.Marshal(msg)
+	}
+	return json.Marshal(v)
+}
+
+// Unmarshal implements encoding.Codec.
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	switch msg := v.(type) {
+	case json.Unmarshaler:
+		return msg.UnmarshalJSON(data)
+	case proto.Message:
+		return protojson.Unmarshal(data, msg)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Name implements encoding.Codec.
+func (jsonCodec) Name() string {
+	return CodecName
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var cordonNodeAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ROUTES,
+		Verb:     v1.RuleVerb_VERB_PUT,
+	},
+}
+
+var leaveNodeAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_VOTES,
+		Verb:     v1.RuleVerb_VERB_DELETE,
+	},
+}
+
+// CordonRequest is a request to change the scheduling state of a node.
+type CordonRequest struct {
+	// NodeID is the ID of the node.
+	NodeID string `json:"nodeID"`
+}
+
+// DrainRequest is a request to drain a node.
+type DrainRequest struct {
+	// NodeID is the ID of the node.
+	NodeID string `json:"nodeID"`
+	// Leave removes the node from the mesh once it is marked as draining.
+	Leave bool `json:"leave,omitempty"`
+}
+
+// Cordon marks a node as unschedulable. Peers stop routing through the node
+// for any destination another node provides, but keep using it for the
+// routes only it advertises.
+func (s *Server) Cordon(ctx context.Context, req *CordonRequest) (*v1.MeshNode, error) {
+	return s.setCordonState(ctx, req.NodeID, types.CordonStateCordoned)
+}
+
+// Uncordon marks a cordoned or draining node as schedulable again.
+func (s *Server) Uncordon(ctx context.Context, req *CordonRequest) (*v1.MeshNode, error) {
+	return s.setCordonState(ctx, req.NodeID, types.CordonStateNone)
+}
+
+// Drain marks a node as draining, steering peers away from it. When Leave is
+// set the node is then removed from the mesh the same way it would be if it
+// had left on its own. The returned node is its last recorded state.
+func (s *Server) Drain(ctx context.Context, req *DrainRequest) (*v1.MeshNode, error) {
+	if req.Leave {
+		if ok, err := s.rbacEval.Evaluate(ctx, leaveNodeAction.For(req.NodeID)); !ok {
+			if err != nil {
+				context.LoggerFrom(ctx).Error("failed to evaluate leave node action", "error", err)
+			}
+			return nil, status.Error(codes.PermissionDenied, "caller does not have permission to remove nodes")
+		}
+	}
+	node, err := s.setCordonState(ctx, req.NodeID, types.CordonStateDraining)
+	if err != nil || !req.Leave {
+		return node, err
+	}
+	log := context.LoggerFrom(ctx).With("node", req.NodeID)
+	if (types.MeshNode{MeshNode: node}).PortFor(v1.Feature_STORAGE_PROVIDER) != 0 {
+		log.Info("Removing drained node from storage consensus")
+		err := s.storage.Consensus().RemovePeer(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: req.NodeID}}, false)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to remove raft member: %v", err)
+		}
+	}
+	log.Info("Removing drained node from peers DB")
+	err = s.db.Peers().Delete(ctx, types.NodeID(req.NodeID))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete peer: %v", err)
+	}
+	return node, nil
+}
+
+func (s *Server) setCordonState(ctx context.Context, nodeID string, state types.CordonState) (*v1.MeshNode, error) {
+	if !s.storage.Consensus().IsLeader() {
+		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+	}
+	if nodeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id cannot be empty")
+	}
+	if !types.IsValidNodeID(nodeID) {
+		return nil, status.Error(codes.InvalidArgument, "invalid node id")
+	}
+	if ok, err := s.rbacEval.Evaluate(ctx, cordonNodeAction.For(nodeID)); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate cordon node action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to cordon nodes")
+	}
+	node, err := s.db.Peers().Get(ctx, types.NodeID(nodeID))
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "node %q not found", nodeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if node.CordonState() == state {
+		return node.MeshNode, nil
+	}
+	zoneID, err := node.WithCordonState(state)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	node.ZoneAwarenessID = zoneID
+	err = s.db.Peers().Put(ctx, node)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	context.LoggerFrom(ctx).Info("Changed node cordon state", slog.String("node", nodeID), slog.String("state", string(state)))
+	return node.MeshNode, nil
+}
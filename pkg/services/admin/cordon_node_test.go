@@ -0,0 +1,161 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestCordonAndDrain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	db := server.storage.MeshDB()
+	nodes, err := db.Peers().List(ctx)
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("expected the local node in storage, got %v: %v", nodes, err)
+	}
+	self := nodes[0].NodeID()
+	for i, id := range []string{"node-b", "node-c"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:              id,
+			PublicKey:       newEncodedPubKey(t),
+			PrivateIPv4:     []string{"172.16.0.20/32", "172.16.0.30/32"}[i],
+			ZoneAwarenessID: "zone-a",
+		}})
+		if err != nil {
+			t.Fatalf("put peer: %v", err)
+		}
+		err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: self.String(), Target: id}})
+		if err != nil {
+			t.Fatalf("put edge: %v", err)
+		}
+	}
+	routes := []*v1.Route{
+		{Name: "node-b-routes", Node: "node-b", DestinationCIDRs: []string{"10.0.0.0/24", "10.1.0.0/24"}},
+		{Name: "node-c-routes", Node: "node-c", DestinationCIDRs: []string{"10.0.0.0/24"}},
+	}
+	for _, rt := range routes {
+		if err := db.Networking().PutRoute(ctx, types.Route{Route: rt}); err != nil {
+			t.Fatalf("put route: %v", err)
+		}
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	routesFor := func() map[string][]string {
+		t.Helper()
+		peers, err := meshnet.WireGuardPeersFor(ctx, db, self)
+		if err != nil {
+			t.Fatalf("get wireguard peers: %v", err)
+		}
+		out := make(map[string][]string)
+		for _, peer := range peers {
+			routes := slices.Clone(peer.GetAllowedRoutes())
+			slices.Sort(routes)
+			out[peer.GetNode().GetId()] = routes
+		}
+		return out
+	}
+
+	// Ties are broken by node ID, so node-b serves the shared route.
+	got := routesFor()
+	if !slices.Equal(got["node-b"], []string{"10.0.0.0/24", "10.1.0.0/24"}) {
+		t.Fatalf("expected node-b to serve both routes, got %v", got)
+	}
+
+	node, err := server.Cordon(ctx, &CordonRequest{NodeID: "node-b"})
+	if err != nil {
+		t.Fatalf("cordon: %v", err)
+	}
+	if state := (types.MeshNode{MeshNode: node}).CordonState(); state != types.CordonStateCordoned {
+		t.Errorf("expected node-b to be cordoned, got %q", state)
+	}
+	if !slices.Contains((types.MeshNode{MeshNode: node}).Zones(), "zone-a") {
+		t.Errorf("expected cordoning to keep the node's zones, got %q", node.GetZoneAwarenessID())
+	}
+	// The shared route moves away while the unique one keeps being served.
+	got = routesFor()
+	if !slices.Equal(got["node-b"], []string{"10.1.0.0/24"}) {
+		t.Errorf("expected cordoned node-b to only keep its unique route, got %v", got["node-b"])
+	}
+	if !slices.Equal(got["node-c"], []string{"10.0.0.0/24"}) {
+		t.Errorf("expected node-c to take over the shared route, got %v", got["node-c"])
+	}
+
+	if _, err := server.Uncordon(ctx, &CordonRequest{NodeID: "node-b"}); err != nil {
+		t.Fatalf("uncordon: %v", err)
+	}
+	if got := routesFor(); !slices.Equal(got["node-b"], []string{"10.0.0.0/24", "10.1.0.0/24"}) {
+		t.Errorf("expected uncordoned node-b to serve both routes again, got %v", got)
+	}
+
+	node, err = server.Drain(ctx, &DrainRequest{NodeID: "node-b"})
+	if err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if state := (types.MeshNode{MeshNode: node}).CordonState(); state != types.CordonStateDraining {
+		t.Errorf("expected node-b to be draining, got %q", state)
+	}
+	if got := routesFor(); !slices.Equal(got["node-b"], []string{"10.1.0.0/24"}) {
+		t.Errorf("expected draining node-b to only keep its unique route, got %v", got["node-b"])
+	}
+
+	if _, err := server.Drain(ctx, &DrainRequest{NodeID: "node-b", Leave: true}); err != nil {
+		t.Fatalf("drain and leave: %v", err)
+	}
+	if _, err := db.Peers().Get(ctx, "node-b"); !errors.IsNodeNotFound(err) {
+		t.Errorf("expected node-b to have left the mesh, got %v", err)
+	}
+	if got := routesFor(); len(got["node-b"]) != 0 || !slices.Equal(got["node-c"], []string{"10.0.0.0/24"}) {
+		t.Errorf("expected only node-c to remain, got %v", got)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			id   string
+			code codes.Code
+		}{
+			{name: "EmptyID", id: "", code: codes.InvalidArgument},
+			{name: "InvalidID", id: "node b", code: codes.InvalidArgument},
+			{name: "NotFound", id: "node-z", code: codes.NotFound},
+		} {
+			_, err := server.Cordon(ctx, &CordonRequest{NodeID: tc.id})
+			if status.Code(err) != tc.code {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.code, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+var dumpWireGuardConfigAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ALL,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// DumpWireGuardConfig returns the effective configuration of the local
+// WireGuard interface for diagnostics.
+func (s *Server) DumpWireGuardConfig(ctx context.Context, _ *emptypb.Empty) (*wireguard.DeviceConfig, error) {
+	if s.network == nil {
+		return nil, status.Error(codes.Unavailable, "network manager is not available")
+	}
+	if ok, err := s.rbacEval.Evaluate(ctx, dumpWireGuardConfigAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate dump wireguard config action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to read the wireguard configuration")
+	}
+	config, err := s.network.DumpConfig(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return config, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+func TestDumpWireGuardConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server := newTestNetworkServer(t)
+	key := crypto.MustGenerateKey().PublicKey()
+	err := server.network.WireGuard().PutPeer(ctx, &wireguard.Peer{
+		ID:         "peer",
+		PublicKey:  key,
+		Endpoint:   netip.MustParseAddrPort("10.1.1.1:51820"),
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("172.16.0.10/32")},
+	})
+	if err != nil {
+		t.Fatalf("put peer: %v", err)
+	}
+	config, err := server.DumpWireGuardConfig(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if config.Name != "webmesh0" {
+		t.Errorf("expected interface name webmesh0, got %q", config.Name)
+	}
+	if config.PublicKey == "" {
+		t.Error("expected interface public key to be set")
+	}
+	if len(config.Peers) != 1 {
+		t.Fatalf("expected 1 peer, got %+v", config.Peers)
+	}
+	peer := config.Peers[0]
+	if peer.ID != "peer" || peer.PublicKey != key.WireGuardKey().String() {
+		t.Errorf("expected the peer to be resolved by its public key, got %+v", peer)
+	}
+	if peer.Endpoint != "10.1.1.1:51820" {
+		t.Errorf("expected endpoint 10.1.1.1:51820, got %q", peer.Endpoint)
+	}
+	if len(peer.AllowedIPs) != 1 || peer.AllowedIPs[0] != "172.16.0.10/32" {
+		t.Errorf("expected allowed IPs [172.16.0.10/32], got %v", peer.AllowedIPs)
+	}
+	if peer.HasPresharedKey {
+		t.Error("expected no preshared key to be reported")
+	}
+
+	noNetwork := NewServer(server.storage, rbac.NewNoopEvaluator(), nil)
+	_, err = noNetwork.DumpWireGuardConfig(context.Background(), &emptypb.Empty{})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable without a network manager, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+var effectiveRoutesAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ROUTES,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// EffectiveRoutesResponse is the routing table of the local node.
+type EffectiveRoutesResponse struct {
+	// Routes are the routes installed for each peer.
+	Routes []meshnet.EffectiveRoute `json:"routes"`
+}
+
+// EffectiveRoutes returns the routes the local node installs for its peers
+// as a result of mesh routes and allowed IPs.
+func (s *Server) EffectiveRoutes(ctx context.Context, _ *emptypb.Empty) (*EffectiveRoutesResponse, error) {
+	if s.network == nil {
+		return nil, status.Error(codes.Unavailable, "network manager is not available")
+	}
+	if ok, err := s.rbacEval.Evaluate(ctx, effectiveRoutesAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate effective routes action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to get routes")
+	}
+	routes, err := s.network.EffectiveRoutes(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &EffectiveRoutesResponse{Routes: routes}, nil
+}
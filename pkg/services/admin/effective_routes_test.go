@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestEffectiveRoutes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	db := server.storage.MeshDB()
+	nodes, err := db.Peers().List(ctx)
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("expected the local node in storage, got %v: %v", nodes, err)
+	}
+	self := nodes[0].NodeID()
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          "gateway",
+		PublicKey:   newEncodedPubKey(t),
+		PrivateIPv4: "172.16.0.20/32",
+	}})
+	if err != nil {
+		t.Fatalf("put peer: %v", err)
+	}
+	if err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: self.String(), Target: "gateway"}}); err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "office",
+		Node:             "gateway",
+		DestinationCIDRs: []string{"10.10.0.0/24"},
+	}})
+	if err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+
+	res, err := server.EffectiveRoutes(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	var found bool
+	for _, rt := range res.Routes {
+		if rt.Route == "office" {
+			found = true
+			if rt.Peer != "gateway" || rt.Destination.String() != "10.10.0.0/24" {
+				t.Errorf("expected the office route through the gateway, got %+v", rt)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the office route, got %+v", res.Routes)
+	}
+
+	noNetwork := NewServer(server.storage, rbac.NewNoopEvaluator(), nil)
+	_, err = noNetwork.EffectiveRoutes(ctx, &emptypb.Empty{})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable without a network manager, got %v", err)
+	}
+}
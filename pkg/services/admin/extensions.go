@@ -0,0 +1,410 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"io"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Full method names of the admin extensions service.
+const (
+	AdminExtensions_IssueJoinToken_FullMethodName      = "/v1.AdminExtensions/IssueJoinToken"
+	AdminExtensions_DumpWireGuardConfig_FullMethodName = "/v1.AdminExtensions/DumpWireGuardConfig"
+	AdminExtensions_ReconfigureNetwork_FullMethodName  = "/v1.AdminExtensions/ReconfigureNetwork"
+	AdminExtensions_VerifyPeers_FullMethodName         = "/v1.AdminExtensions/VerifyPeers"
+	AdminExtensions_StorageStats_FullMethodName        = "/v1.AdminExtensions/StorageStats"
+	AdminExtensions_Cordon_FullMethodName              = "/v1.AdminExtensions/Cordon"
+	AdminExtensions_Uncordon_FullMethodName            = "/v1.AdminExtensions/Uncordon"
+	AdminExtensions_Drain_FullMethodName               = "/v1.AdminExtensions/Drain"
+	AdminExtensions_SetLogLevel_FullMethodName         = "/v1.AdminExtensions/SetLogLevel"
+	AdminExtensions_GetLogLevels_FullMethodName        = "/v1.AdminExtensions/GetLogLevels"
+	AdminExtensions_EffectiveRoutes_FullMethodName     = "/v1.AdminExtensions/EffectiveRoutes"
+	AdminExtensions_Snapshot_FullMethodName            = "/v1.AdminExtensions/Snapshot"
+	AdminExtensions_RestoreSnapshot_FullMethodName     = "/v1.AdminExtensions/RestoreSnapshot"
+	AdminExtensions_QuarantineNode_FullMethodName      = "/v1.AdminExtensions/QuarantineNode"
+	AdminExtensions_UnquarantineNode_FullMethodName    = "/v1.AdminExtensions/UnquarantineNode"
+	AdminExtensions_RotatePresharedKey_FullMethodName  = "/v1.AdminExtensions/RotatePresharedKey"
+	AdminExtensions_PreRegisterNode_FullMethodName     = "/v1.AdminExtensions/PreRegisterNode"
+)
+
+// snapshotChunkSize is the size of the chunks snapshots are streamed in.
+const snapshotChunkSize = 64 * 1024
+
+// SnapshotChunk is a chunk of an exported snapshot streamed to RestoreSnapshot.
+type SnapshotChunk struct {
+	// Data is the contents of the chunk.
+	Data []byte `json:"data"`
+}
+
+// ExtensionsServer is the server API for the admin extensions service. It
+// carries the admin operations that are not part of the v1.Admin API.
+type ExtensionsServer interface {
+	IssueJoinToken(context.Context, *jointokens.IssueJoinTokenRequest) (*jointokens.JoinToken, error)
+	DumpWireGuardConfig(context.Context, *emptypb.Empty) (*wireguard.DeviceConfig, error)
+	ReconfigureNetwork(context.Context, *emptypb.Empty) (*meshnet.ReconfigureResult, error)
+	VerifyPeers(context.Context, *VerifyPeersRequest) (*VerifyPeersResponse, error)
+	StorageStats(context.Context, *emptypb.Empty) (*storage.StorageStats, error)
+	Cordon(context.Context, *CordonRequest) (*v1.MeshNode, error)
+	Uncordon(context.Context, *CordonRequest) (*v1.MeshNode, error)
+	Drain(context.Context, *DrainRequest) (*v1.MeshNode, error)
+	SetLogLevel(context.Context, *SetLogLevelRequest) (*LogLevels, error)
+	GetLogLevels(context.Context, *emptypb.Empty) (*LogLevels, error)
+	EffectiveRoutes(context.Context, *emptypb.Empty) (*EffectiveRoutesResponse, error)
+	Snapshot(context.Context, *emptypb.Empty) (*raftstorage.SnapshotMeta, error)
+	RestoreSnapshot(context.Context, io.Reader) (*emptypb.Empty, error)
+	QuarantineNode(context.Context, *QuarantineRequest) (*v1.MeshNode, error)
+	UnquarantineNode(context.Context, *QuarantineRequest) (*v1.MeshNode, error)
+	RotatePresharedKey(context.Context, *RotatePresharedKeyRequest) (*RotatePresharedKeyResponse, error)
+	PreRegisterNode(context.Context, *types.NodeRegistration) (*emptypb.Empty, error)
+}
+
+// Extensions_ServiceDesc is the grpc.ServiceDesc for the admin extensions service.
+var Extensions_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "v1.AdminExtensions",
+	HandlerType: (*ExtensionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "IssueJoinToken",
+			Handler:    unaryHandler(AdminExtensions_IssueJoinToken_FullMethodName, ExtensionsServer.IssueJoinToken),
+		},
+		{
+			MethodName: "DumpWireGuardConfig",
+			Handler:    unaryHandler(AdminExtensions_DumpWireGuardConfig_FullMethodName, ExtensionsServer.DumpWireGuardConfig),
+		},
+		{
+			MethodName: "ReconfigureNetwork",
+			Handler:    unaryHandler(AdminExtensions_ReconfigureNetwork_FullMethodName, ExtensionsServer.ReconfigureNetwork),
+		},
+		{
+			MethodName: "VerifyPeers",
+			Handler:    unaryHandler(AdminExtensions_VerifyPeers_FullMethodName, ExtensionsServer.VerifyPeers),
+		},
+		{
+			MethodName: "StorageStats",
+			Handler:    unaryHandler(AdminExtensions_StorageStats_FullMethodName, ExtensionsServer.StorageStats),
+		},
+		{
+			MethodName: "Cordon",
+			Handler:    unaryHandler(AdminExtensions_Cordon_FullMethodName, ExtensionsServer.Cordon),
+		},
+		{
+			MethodName: "Uncordon",
+			Handler:    unaryHandler(AdminExtensions_Uncordon_FullMethodName, ExtensionsServer.Uncordon),
+		},
+		{
+			MethodName: "Drain",
+			Handler:    unaryHandler(AdminExtensions_Drain_FullMethodName, ExtensionsServer.Drain),
+		},
+		{
+			MethodName: "SetLogLevel",
+			Handler:    unaryHandler(AdminExtensions_SetLogLevel_FullMethodName, ExtensionsServer.SetLogLevel),
+		},
+		{
+			MethodName: "GetLogLevels",
+			Handler:    unaryHandler(AdminExtensions_GetLogLevels_FullMethodName, ExtensionsServer.GetLogLevels),
+		},
+		{
+			MethodName: "EffectiveRoutes",
+			Handler:    unaryHandler(AdminExtensions_EffectiveRoutes_FullMethodName, ExtensionsServer.EffectiveRoutes),
+		},
+		{
+			MethodName: "Snapshot",
+			Handler:    unaryHandler(AdminExtensions_Snapshot_FullMethodName, ExtensionsServer.Snapshot),
+		},
+		{
+			MethodName: "QuarantineNode",
+			Handler:    unaryHandler(AdminExtensions_QuarantineNode_FullMethodName, ExtensionsServer.QuarantineNode),
+		},
+		{
+			MethodName: "UnquarantineNode",
+			Handler:    unaryHandler(AdminExtensions_UnquarantineNode_FullMethodName, ExtensionsServer.UnquarantineNode),
+		},
+		{
+			MethodName: "RotatePresharedKey",
+			Handler:    unaryHandler(AdminExtensions_RotatePresharedKey_FullMethodName, ExtensionsServer.RotatePresharedKey),
+		},
+		{
+			MethodName: "PreRegisterNode",
+			Handler:    unaryHandler(AdminExtensions_PreRegisterNode_FullMethodName, ExtensionsServer.PreRegisterNode),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RestoreSnapshot",
+			Handler:       restoreSnapshotHandler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pkg/services/admin/extensions.go",
+}
+
+// RegisterExtensionsServer registers the admin extensions service. Callers
+// must use the CodecName content subtype.
+func RegisterExtensionsServer(s grpc.ServiceRegistrar, srv ExtensionsServer) {
+	s.RegisterService(&Extensions_ServiceDesc, srv)
+}
+
+// ExtensionMethods returns how the leader proxy routes each method of the
+// admin extensions service.
+func ExtensionMethods() map[string]leaderproxy.Method {
+	return map[string]leaderproxy.Method{
+		AdminExtensions_IssueJoinToken_FullMethodName:      leaderMethod[jointokens.JoinToken](),
+		AdminExtensions_DumpWireGuardConfig_FullMethodName: localMethod(),
+		AdminExtensions_ReconfigureNetwork_FullMethodName:  localMethod(),
+		AdminExtensions_VerifyPeers_FullMethodName:         localMethod(),
+		AdminExtensions_StorageStats_FullMethodName:        localMethod(),
+		AdminExtensions_Cordon_FullMethodName:              leaderMethod[v1.MeshNode](),
+		AdminExtensions_Uncordon_FullMethodName:            leaderMethod[v1.MeshNode](),
+		AdminExtensions_Drain_FullMethodName:               leaderMethod[v1.MeshNode](),
+		AdminExtensions_SetLogLevel_FullMethodName:         localMethod(),
+		AdminExtensions_GetLogLevels_FullMethodName:        localMethod(),
+		AdminExtensions_EffectiveRoutes_FullMethodName:     localMethod(),
+		AdminExtensions_Snapshot_FullMethodName:            leaderMethod[raftstorage.SnapshotMeta](),
+		AdminExtensions_RestoreSnapshot_FullMethodName: {
+			Policy:      leaderproxy.RequireLeader,
+			NewRequest:  func() any { return new(SnapshotChunk) },
+			NewResponse: func() any { return new(emptypb.Empty) },
+			Stream:      &Extensions_ServiceDesc.Streams[0],
+			CallOptions: []grpc.CallOption{grpc.CallContentSubtype(CodecName)},
+		},
+		AdminExtensions_QuarantineNode_FullMethodName:     leaderMethod[v1.MeshNode](),
+		AdminExtensions_UnquarantineNode_FullMethodName:   leaderMethod[v1.MeshNode](),
+		AdminExtensions_RotatePresharedKey_FullMethodName: leaderMethod[RotatePresharedKeyResponse](),
+		AdminExtensions_PreRegisterNode_FullMethodName:    leaderMethod[emptypb.Empty](),
+	}
+}
+
+// ForwardedMeta returns the incoming metadata keys of the admin API the leader
+// proxy passes on to the leader.
+func ForwardedMeta() []string {
+	return []string{RouteSignatureMeta}
+}
+
+// ExtensionsClient is the client API for the admin extensions service.
+type ExtensionsClient interface {
+	IssueJoinToken(ctx context.Context, in *jointokens.IssueJoinTokenRequest, opts ...grpc.CallOption) (*jointokens.JoinToken, error)
+	DumpWireGuardConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wireguard.DeviceConfig, error)
+	ReconfigureNetwork(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*meshnet.ReconfigureResult, error)
+	VerifyPeers(ctx context.Context, in *VerifyPeersRequest, opts ...grpc.CallOption) (*VerifyPeersResponse, error)
+	StorageStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*storage.StorageStats, error)
+	Cordon(ctx context.Context, in *CordonRequest, opts ...grpc.CallOption) (*v1.MeshNode, error)
+	Uncordon(ctx context.Context, in *CordonRequest, opts ...grpc.CallOption) (*v1.MeshNode, error)
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*v1.MeshNode, error)
+	SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*LogLevels, error)
+	GetLogLevels(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*LogLevels, error)
+	EffectiveRoutes(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*EffectiveRoutesResponse, error)
+	Snapshot(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*raftstorage.SnapshotMeta, error)
+	// RestoreSnapshot streams the snapshot read from src to the server.
+	RestoreSnapshot(ctx context.Context, src io.Reader, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	QuarantineNode(ctx context.Context, in *QuarantineRequest, opts ...grpc.CallOption) (*v1.MeshNode, error)
+	UnquarantineNode(ctx context.Context, in *QuarantineRequest, opts ...grpc.CallOption) (*v1.MeshNode, error)
+	RotatePresharedKey(ctx context.Context, in *RotatePresharedKeyRequest, opts ...grpc.CallOption) (*RotatePresharedKeyResponse, error)
+	PreRegisterNode(ctx context.Context, in *types.NodeRegistration, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type extensionsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewExtensionsClient returns a client for the admin extensions service.
+func NewExtensionsClient(cc grpc.ClientConnInterface) ExtensionsClient {
+	return &extensionsClient{cc}
+}
+
+func (c *extensionsClient) IssueJoinToken(ctx context.Context, in *jointokens.IssueJoinTokenRequest, opts ...grpc.CallOption) (*jointokens.JoinToken, error) {
+	return invoke[jointokens.JoinToken](ctx, c.cc, AdminExtensions_IssueJoinToken_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) DumpWireGuardConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wireguard.DeviceConfig, error) {
+	return invoke[wireguard.DeviceConfig](ctx, c.cc, AdminExtensions_DumpWireGuardConfig_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) ReconfigureNetwork(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*meshnet.ReconfigureResult, error) {
+	return invoke[meshnet.ReconfigureResult](ctx, c.cc, AdminExtensions_ReconfigureNetwork_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) VerifyPeers(ctx context.Context, in *VerifyPeersRequest, opts ...grpc.CallOption) (*VerifyPeersResponse, error) {
+	return invoke[VerifyPeersResponse](ctx, c.cc, AdminExtensions_VerifyPeers_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) StorageStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*storage.StorageStats, error) {
+	return invoke[storage.StorageStats](ctx, c.cc, AdminExtensions_StorageStats_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) Cordon(ctx context.Context, in *CordonRequest, opts ...grpc.CallOption) (*v1.MeshNode, error) {
+	return invoke[v1.MeshNode](ctx, c.cc, AdminExtensions_Cordon_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) Uncordon(ctx context.Context, in *CordonRequest, opts ...grpc.CallOption) (*v1.MeshNode, error) {
+	return invoke[v1.MeshNode](ctx, c.cc, AdminExtensions_Uncordon_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*v1.MeshNode, error) {
+	return invoke[v1.MeshNode](ctx, c.cc, AdminExtensions_Drain_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*LogLevels, error) {
+	return invoke[LogLevels](ctx, c.cc, AdminExtensions_SetLogLevel_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) GetLogLevels(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*LogLevels, error) {
+	return invoke[LogLevels](ctx, c.cc, AdminExtensions_GetLogLevels_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) EffectiveRoutes(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*EffectiveRoutesResponse, error) {
+	return invoke[EffectiveRoutesResponse](ctx, c.cc, AdminExtensions_EffectiveRoutes_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) Snapshot(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*raftstorage.SnapshotMeta, error) {
+	return invoke[raftstorage.SnapshotMeta](ctx, c.cc, AdminExtensions_Snapshot_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) RestoreSnapshot(ctx context.Context, src io.Reader, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Extensions_ServiceDesc.Streams[0], AdminExtensions_RestoreSnapshot_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, snapshotChunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&SnapshotChunk{Data: buf[:n]}); err != nil {
+				if err == io.EOF {
+					// The server ended the stream, its status is returned by RecvMsg.
+					break
+				}
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	out := new(emptypb.Empty)
+	if err := stream.RecvMsg(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *extensionsClient) QuarantineNode(ctx context.Context, in *QuarantineRequest, opts ...grpc.CallOption) (*v1.MeshNode, error) {
+	return invoke[v1.MeshNode](ctx, c.cc, AdminExtensions_QuarantineNode_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) UnquarantineNode(ctx context.Context, in *QuarantineRequest, opts ...grpc.CallOption) (*v1.MeshNode, error) {
+	return invoke[v1.MeshNode](ctx, c.cc, AdminExtensions_UnquarantineNode_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) RotatePresharedKey(ctx context.Context, in *RotatePresharedKeyRequest, opts ...grpc.CallOption) (*RotatePresharedKeyResponse, error) {
+	return invoke[RotatePresharedKeyResponse](ctx, c.cc, AdminExtensions_RotatePresharedKey_FullMethodName, in, opts)
+}
+
+func (c *extensionsClient) PreRegisterNode(ctx context.Context, in *types.NodeRegistration, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	return invoke[emptypb.Empty](ctx, c.cc, AdminExtensions_PreRegisterNode_FullMethodName, in, opts)
+}
+
+func invoke[Resp any](ctx context.Context, cc grpc.ClientConnInterface, method string, in any, opts []grpc.CallOption) (*Resp, error) {
+	out := new(Resp)
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+	if err := cc.Invoke(ctx, method, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// methodHandler is the handler type of a grpc.MethodDesc.
+type methodHandler = func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error)
+
+func unaryHandler[Req, Resp any](method string, call func(ExtensionsServer, context.Context, *Req) (*Resp, error)) methodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(Req)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(ExtensionsServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: method,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(ExtensionsServer), ctx, req.(*Req))
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+func leaderMethod[Resp any]() leaderproxy.Method {
+	return leaderproxy.Method{
+		Policy:      leaderproxy.RequireLeader,
+		NewResponse: func() any { return new(Resp) },
+		CallOptions: []grpc.CallOption{grpc.CallContentSubtype(CodecName)},
+	}
+}
+
+func localMethod() leaderproxy.Method {
+	return leaderproxy.Method{Policy: leaderproxy.RequireLocal}
+}
+
+func restoreSnapshotHandler(srv any, stream grpc.ServerStream) error {
+	resp, err := srv.(ExtensionsServer).RestoreSnapshot(stream.Context(), &snapshotChunkReader{stream: stream})
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(resp)
+}
+
+// snapshotChunkReader reads the chunks of a RestoreSnapshot stream.
+type snapshotChunkReader struct {
+	stream  grpc.ServerStream
+	pending []byte
+}
+
+// Read implements io.Reader.
+func (r *snapshotChunkReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		var chunk SnapshotChunk
+		if err := r.stream.RecvMsg(&chunk); err != nil {
+			return 0, err
+		}
+		r.pending = chunk.Data
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
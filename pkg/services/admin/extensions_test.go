@@ -0,0 +1,367 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// newTestExtensionsClient serves the extensions service of the given server
+// over an in-memory listener and returns a client for it.
+func newTestExtensionsClient(t *testing.T, server *Server) ExtensionsClient {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	RegisterExtensionsServer(srv, server)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal("error dialing test server:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewExtensionsClient(conn)
+}
+
+func TestExtensionsIssueJoinToken(t *testing.T) {
+	t.Parallel()
+
+	client := newTestExtensionsClient(t, newTestServer(t))
+
+	tok, err := client.IssueJoinToken(context.Background(), &jointokens.IssueJoinTokenRequest{NodeID: "new-node"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if tok.Token == "" {
+		t.Error("expected token to be set")
+	}
+	if tok.NodeID != "new-node" {
+		t.Errorf("expected node id new-node, got %q", tok.NodeID)
+	}
+}
+
+func TestExtensionsDumpWireGuardConfig(t *testing.T) {
+	t.Parallel()
+
+	client := newTestExtensionsClient(t, newTestNetworkServer(t))
+
+	config, err := client.DumpWireGuardConfig(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if config.Name != "webmesh0" {
+		t.Errorf("expected interface name webmesh0, got %q", config.Name)
+	}
+}
+
+func TestExtensionsReconfigureNetwork(t *testing.T) {
+	t.Parallel()
+
+	client := newTestExtensionsClient(t, newTestNetworkServer(t))
+
+	res, err := client.ReconfigureNetwork(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res.Changed() {
+		t.Errorf("expected no changes on a mesh without peers, got %+v", res)
+	}
+}
+
+func TestExtensionsVerifyPeers(t *testing.T) {
+	t.Parallel()
+
+	client := newTestExtensionsClient(t, newTestNetworkServer(t))
+
+	res, err := client.VerifyPeers(context.Background(), &VerifyPeersRequest{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !res.Healthy || len(res.Peers) != 0 {
+		t.Errorf("expected a healthy result without peers, got %+v", res)
+	}
+}
+
+func TestExtensionsStorageStats(t *testing.T) {
+	t.Parallel()
+
+	client := newTestExtensionsClient(t, newTestServer(t))
+
+	stats, err := client.StorageStats(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if stats.Total.Keys == 0 {
+		t.Errorf("expected keys in a bootstrapped mesh, got %+v", stats.Total)
+	}
+}
+
+func TestExtensionsCordon(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	client := newTestExtensionsClient(t, server)
+	err := server.storage.MeshDB().Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          "node-b",
+		PublicKey:   newEncodedPubKey(t),
+		PrivateIPv4: "172.16.0.20/32",
+	}})
+	if err != nil {
+		t.Fatalf("put peer: %v", err)
+	}
+
+	node, err := client.Cordon(ctx, &CordonRequest{NodeID: "node-b"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if (types.MeshNode{MeshNode: node}).CordonState() != types.CordonStateCordoned {
+		t.Errorf("expected node-b to be cordoned, got %+v", node)
+	}
+	node, err = client.Drain(ctx, &DrainRequest{NodeID: "node-b"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if (types.MeshNode{MeshNode: node}).CordonState() != types.CordonStateDraining {
+		t.Errorf("expected node-b to be draining, got %+v", node)
+	}
+	node, err = client.Uncordon(ctx, &CordonRequest{NodeID: "node-b"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if (types.MeshNode{MeshNode: node}).CordonState() != types.CordonStateNone {
+		t.Errorf("expected node-b to be schedulable, got %+v", node)
+	}
+}
+
+func TestExtensionsLogLevels(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	client := newTestExtensionsClient(t, newTestServer(t))
+	const component = "admin-extensions-log-level-test"
+	t.Cleanup(func() { logging.Levels.ResetLevel(component) })
+
+	_, err := client.SetLogLevel(ctx, &SetLogLevelRequest{Component: component, Level: "debug"})
+	if err != nil {
+		t.Fatalf("set log level: %v", err)
+	}
+	res, err := client.GetLogLevels(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("get log levels: %v", err)
+	}
+	if res.Levels[component] != slog.LevelDebug.String() {
+		t.Errorf("expected %s to be at debug, got %v", component, res.Levels)
+	}
+}
+
+func TestExtensionsEffectiveRoutes(t *testing.T) {
+	t.Parallel()
+
+	client := newTestExtensionsClient(t, newTestNetworkServer(t))
+
+	res, err := client.EffectiveRoutes(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res.Routes) != 0 {
+		t.Errorf("expected no routes on a mesh without peers, got %+v", res.Routes)
+	}
+}
+
+func TestExtensionsSnapshot(t *testing.T) {
+	t.Parallel()
+
+	client := newTestExtensionsClient(t, newTestServer(t))
+
+	meta, err := client.Snapshot(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if meta.ID == "" || meta.Index == 0 {
+		t.Errorf("expected snapshot metadata, got %+v", meta)
+	}
+}
+
+func TestExtensionsRestoreSnapshot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	client := newTestExtensionsClient(t, server)
+	provider, ok := server.storage.(*raftstorage.Provider)
+	if !ok {
+		t.Fatalf("expected a raft storage provider, got %T", server.storage)
+	}
+	st := provider.MeshStorage()
+	if err := st.PutValue(ctx, []byte("/registry/kept"), []byte("before"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+	var exported bytes.Buffer
+	if _, err := provider.ExportSnapshot(ctx, &exported); err != nil {
+		t.Fatalf("export snapshot: %v", err)
+	}
+	if err := st.PutValue(ctx, []byte("/registry/kept"), []byte("after"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+
+	// Restoring is refused unless explicitly allowed.
+	_, err := client.RestoreSnapshot(ctx, bytes.NewReader(exported.Bytes()))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected restore to be refused, got %v", err)
+	}
+	provider.Options.AllowSnapshotRestore = true
+	if _, err := client.RestoreSnapshot(ctx, bytes.NewReader(exported.Bytes())); err != nil {
+		t.Fatalf("restore snapshot: %v", err)
+	}
+	value, err := st.GetValue(ctx, []byte("/registry/kept"))
+	if err != nil {
+		t.Fatalf("get value: %v", err)
+	}
+	if string(value) != "before" {
+		t.Errorf("expected restored value %q, got %q", "before", value)
+	}
+}
+
+func TestExtensionsQuarantineNode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	client := newTestExtensionsClient(t, server)
+	err := server.storage.MeshDB().Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          "node-b",
+		PublicKey:   newEncodedPubKey(t),
+		PrivateIPv4: "172.16.0.20/32",
+	}})
+	if err != nil {
+		t.Fatalf("put peer: %v", err)
+	}
+
+	node, err := client.QuarantineNode(ctx, &QuarantineRequest{NodeID: "node-b"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if node.GetId() != "node-b" {
+		t.Errorf("expected node-b to be returned, got %+v", node)
+	}
+	if ok, err := storage.IsQuarantined(ctx, server.db.Networking(), "node-b"); err != nil || !ok {
+		t.Errorf("expected node-b to be quarantined, got %v: %v", ok, err)
+	}
+	if _, err := client.UnquarantineNode(ctx, &QuarantineRequest{NodeID: "node-b"}); err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if ok, err := storage.IsQuarantined(ctx, server.db.Networking(), "node-b"); err != nil || ok {
+		t.Errorf("expected node-b to be released, got %v: %v", ok, err)
+	}
+}
+
+func TestExtensionsRotatePresharedKey(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	client := newTestExtensionsClient(t, server)
+	p := server.storage.MeshDB().Peers()
+	for _, id := range []string{"node-b", "node-c"} {
+		err := p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:        id,
+			PublicKey: newEncodedPubKey(t),
+		}})
+		if err != nil {
+			t.Fatalf("put peer: %v", err)
+		}
+	}
+	err := p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source: "node-b",
+		Target: "node-c",
+		Weight: 1,
+	}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+
+	resp, err := client.RotatePresharedKey(ctx, &RotatePresharedKeyRequest{Source: "node-b", Target: "node-c", Window: time.Minute})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if time.Until(resp.ActivateAt) <= 0 {
+		t.Errorf("expected the key to activate in the future, got %s", resp.ActivateAt)
+	}
+	edge, err := p.GetEdge(ctx, "node-b", "node-c")
+	if err != nil {
+		t.Fatalf("get edge: %v", err)
+	}
+	if activateAt, ok := types.PresharedKeyRotationFromEdgeAttrs(edge.GetAttributes()); !ok || !activateAt.Equal(resp.ActivateAt) {
+		t.Errorf("expected a rotation activating at %s, got %s", resp.ActivateAt, activateAt)
+	}
+	_, err = client.RotatePresharedKey(ctx, &RotatePresharedKeyRequest{Source: "node-b", Target: "node-c"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected a rotation in progress to fail with %s, got %v", codes.FailedPrecondition, err)
+	}
+}
+
+func TestExtensionsPreRegisterNode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	client := newTestExtensionsClient(t, server)
+	pubKey := newEncodedPubKey(t)
+
+	_, err := client.PreRegisterNode(ctx, &types.NodeRegistration{ID: "new-node", PublicKey: pubKey, PrivateIPv4: "172.16.10.10/32"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	reg, err := storage.GetNodeRegistration(ctx, server.storage.MeshStorage(), "new-node")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if reg.PublicKey != pubKey || reg.PrivateIPv4 != "172.16.10.10/32" {
+		t.Errorf("unexpected registration: %+v", reg)
+	}
+	_, err = client.PreRegisterNode(ctx, &types.NodeRegistration{ID: "other-node", PublicKey: pubKey, PrivateIPv4: "172.16.10.10/32"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected a reserved address to fail with %s, got %v", codes.AlreadyExists, err)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+var issueJoinTokenAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ROLE_BINDINGS,
+		Verb:     v1.RuleVerb_VERB_PUT,
+	},
+}
+
+// IssueJoinToken issues a signed, single-use token that allows the given node
+// to join the mesh with the requested roles bound to it.
+func (s *Server) IssueJoinToken(ctx context.Context, req *jointokens.IssueJoinTokenRequest) (*jointokens.JoinToken, error) {
+	if !s.storage.Consensus().IsLeader() {
+		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+	}
+	if s.opts.JoinTokenKey == nil {
+		return nil, status.Error(codes.Unavailable, "no key is configured for signing join tokens")
+	}
+	if req.NodeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id cannot be empty")
+	}
+	// Binding roles to a node is equivalent to putting a rolebinding.
+	if ok, err := s.rbacEval.Evaluate(ctx, issueJoinTokenAction.For(req.NodeID)); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate issue join token action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to issue join tokens")
+	}
+	for _, role := range req.Roles {
+		_, err := s.db.RBAC().GetRole(ctx, role)
+		if err != nil {
+			if errors.IsRoleNotFound(err) {
+				return nil, status.Errorf(codes.InvalidArgument, "role %q does not exist", role)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	tok, err := s.tokens.Issue(ctx, req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return tok, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
+)
+
+func TestIssueJoinToken(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+
+	_, err := server.PutRole(context.Background(), &v1.Role{
+		Name: "test-role",
+		Rules: []*v1.Rule{
+			{
+				Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+				Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_PUT},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	tt := []testCase[jointokens.IssueJoinTokenRequest]{
+		{
+			name: "no node id",
+			code: codes.InvalidArgument,
+			req:  &jointokens.IssueJoinTokenRequest{},
+		},
+		{
+			name: "invalid node id",
+			code: codes.InvalidArgument,
+			req:  &jointokens.IssueJoinTokenRequest{NodeID: "invalid node"},
+		},
+		{
+			name: "non-existent role",
+			code: codes.InvalidArgument,
+			req:  &jointokens.IssueJoinTokenRequest{NodeID: "new-node", Roles: []string{"non-existent"}},
+		},
+		{
+			name: "valid token",
+			code: codes.OK,
+			req:  &jointokens.IssueJoinTokenRequest{NodeID: "new-node", Roles: []string{"test-role"}},
+		},
+	}
+
+	runTestCases(t, tt, server.IssueJoinToken)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+var setLogLevelAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ALL,
+		Verb:     v1.RuleVerb_VERB_PUT,
+	},
+}
+
+var getLogLevelsAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ALL,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// SetLogLevelRequest is a request to change the log level of a component.
+type SetLogLevelRequest struct {
+	// Component is the component to set the level for. It also applies to
+	// any component it is a prefix of.
+	Component string `json:"component"`
+	// Level is one of debug, info, warn, or error. An empty level removes
+	// the override for the component.
+	Level string `json:"level,omitempty"`
+}
+
+// LogLevels are the log level overrides in effect on a node.
+type LogLevels struct {
+	// Levels maps components to their log level.
+	Levels map[string]string `json:"levels"`
+}
+
+// SetLogLevel changes the log level of a component on this node. The change
+// takes effect immediately and is not persisted across restarts.
+func (s *Server) SetLogLevel(ctx context.Context, req *SetLogLevelRequest) (*LogLevels, error) {
+	if req.Component == "" {
+		return nil, status.Error(codes.InvalidArgument, "component cannot be empty")
+	}
+	var level slog.Level
+	if req.Level != "" {
+		var err error
+		level, err = logging.ParseLevel(req.Level)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if ok, err := s.rbacEval.Evaluate(ctx, setLogLevelAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate set log level action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to set log levels")
+	}
+	if req.Level == "" {
+		logging.Levels.ResetLevel(req.Component)
+	} else {
+		logging.Levels.SetLevel(req.Component, level)
+	}
+	context.LoggerFrom(ctx).Info("Changed component log level", slog.String("log-component", req.Component), slog.String("level", req.Level))
+	return currentLogLevels(), nil
+}
+
+// GetLogLevels returns the log level overrides in effect on this node.
+func (s *Server) GetLogLevels(ctx context.Context, _ *emptypb.Empty) (*LogLevels, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, getLogLevelsAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate get log levels action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to get log levels")
+	}
+	return currentLogLevels(), nil
+}
+
+func currentLogLevels() *LogLevels {
+	overrides := logging.Levels.Overrides()
+	out := &LogLevels{Levels: make(map[string]string, len(overrides))}
+	for component, level := range overrides {
+		out.Levels[component] = level.String()
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/logging"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := newTestServer(t)
+	const component = "admin-log-level-test"
+	t.Cleanup(func() { logging.Levels.ResetLevel(component) })
+
+	res, err := server.SetLogLevel(ctx, &SetLogLevelRequest{Component: component, Level: "debug"})
+	if err != nil {
+		t.Fatalf("set log level: %v", err)
+	}
+	if res.Levels[component] != slog.LevelDebug.String() {
+		t.Errorf("expected %s to be at debug, got %v", component, res.Levels)
+	}
+	if level, ok := logging.Levels.Level(component); !ok || level != slog.LevelDebug {
+		t.Errorf("expected the registry to be updated, got %v", level)
+	}
+	res, err = server.GetLogLevels(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("get log levels: %v", err)
+	}
+	if _, ok := res.Levels[component]; !ok {
+		t.Errorf("expected %s in log levels, got %v", component, res.Levels)
+	}
+
+	// An empty level removes the override.
+	res, err = server.SetLogLevel(ctx, &SetLogLevelRequest{Component: component})
+	if err != nil {
+		t.Fatalf("reset log level: %v", err)
+	}
+	if _, ok := res.Levels[component]; ok {
+		t.Errorf("expected %s override to be removed, got %v", component, res.Levels)
+	}
+
+	for _, req := range []*SetLogLevelRequest{
+		{Component: "", Level: "debug"},
+		{Component: component, Level: "verbose"},
+	} {
+		_, err := server.SetLogLevel(ctx, req)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument for %+v, got %v", req, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/plugins"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// PreRegisterNode creates a registration for a node ahead of it joining the
+// mesh. When the node joins it must present the registered public key, is
+// assigned the registered IPv4 address if it requests one, and has the
+// registered roles bound to it. Registering an existing node replaces its
+// registration.
+func (s *Server) PreRegisterNode(ctx context.Context, req *types.NodeRegistration) (*emptypb.Empty, error) {
+	if !s.storage.Consensus().IsLeader() {
+		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+	}
+	if err := types.ValidateNodeRegistration(*req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// Registering roles for a node is equivalent to issuing it a join token.
+	if ok, err := s.rbacEval.Evaluate(ctx, issueJoinTokenAction.For(req.ID.String())); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate pre-register node action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to pre-register nodes")
+	}
+	for _, role := range req.Roles {
+		_, err := s.db.RBAC().GetRole(ctx, role)
+		if err != nil {
+			if errors.IsRoleNotFound(err) {
+				return nil, status.Errorf(codes.InvalidArgument, "role %q does not exist", role)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if addr := req.PrivateAddrV4(); addr.IsValid() {
+		state, err := s.db.MeshState().GetMeshState(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !state.NetworkV4().Contains(addr.Addr()) {
+			return nil, status.Errorf(codes.InvalidArgument, "private ipv4 %s is not in the mesh network %s", addr, state.NetworkV4())
+		}
+		if s.opts.Plugins == nil {
+			return nil, status.Error(codes.FailedPrecondition, "no IPAM plugin is available to reserve the private ipv4")
+		}
+		// Reserve the address so it is not allocated to another node
+		// before this one joins.
+		err = s.opts.Plugins.ReserveIP(ctx, req.ID.String(), addr)
+		if err != nil {
+			switch {
+			case errors.Is(err, plugins.ErrUnsupported):
+				return nil, status.Error(codes.FailedPrecondition, "the IPAM plugin does not support reserving addresses")
+			case errors.Is(err, plugins.ErrAddressInUse):
+				return nil, status.Errorf(codes.AlreadyExists, "private ipv4 %s: %v", addr, err)
+			}
+			return nil, status.Errorf(codes.Internal, "reserve private ipv4: %v", err)
+		}
+	} else if s.opts.Plugins != nil {
+		// Drop any address reserved by a previous registration.
+		err := s.opts.Plugins.ReleaseIP(ctx, &v1.ReleaseIPRequest{NodeID: req.ID.String()})
+		if err != nil && !errors.Is(err, plugins.ErrUnsupported) {
+			return nil, status.Errorf(codes.Internal, "release reserved ipv4: %v", err)
+		}
+	}
+	err := storage.PutNodeRegistration(ctx, s.storage.MeshStorage(), *req)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestPreRegisterNode(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := server.PutRole(ctx, &v1.Role{
+		Name: "test-role",
+		Rules: []*v1.Rule{
+			{
+				Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+				Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_PUT},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	pubKey := newEncodedPubKey(t)
+
+	tt := []testCase[types.NodeRegistration]{
+		{
+			name: "no node id",
+			code: codes.InvalidArgument,
+			req:  &types.NodeRegistration{PublicKey: pubKey},
+		},
+		{
+			name: "invalid public key",
+			code: codes.InvalidArgument,
+			req:  &types.NodeRegistration{ID: "new-node", PublicKey: "invalid"},
+		},
+		{
+			name: "invalid private ipv4",
+			code: codes.InvalidArgument,
+			req:  &types.NodeRegistration{ID: "new-node", PublicKey: pubKey, PrivateIPv4: "fd00::1/128"},
+		},
+		{
+			name: "private ipv4 outside mesh network",
+			code: codes.InvalidArgument,
+			req:  &types.NodeRegistration{ID: "new-node", PublicKey: pubKey, PrivateIPv4: "10.0.0.1/32"},
+		},
+		{
+			name: "non-existent role",
+			code: codes.InvalidArgument,
+			req:  &types.NodeRegistration{ID: "new-node", PublicKey: pubKey, Roles: []string{"non-existent"}},
+		},
+		{
+			name: "valid registration",
+			code: codes.OK,
+			req: &types.NodeRegistration{
+				ID:          "new-node",
+				PublicKey:   pubKey,
+				PrivateIPv4: "172.16.10.10/32",
+				Roles:       []string{"test-role"},
+			},
+			tval: func(t *testing.T) {
+				reg, err := storage.GetNodeRegistration(ctx, server.storage.MeshStorage(), "new-node")
+				if err != nil {
+					t.Fatal("expected no error, got", err)
+				}
+				if reg.PublicKey != pubKey || reg.PrivateIPv4 != "172.16.10.10/32" {
+					t.Errorf("unexpected registration: %+v", reg)
+				}
+				reserved, err := storage.ListIPReservations(ctx, server.storage.MeshStorage())
+				if err != nil {
+					t.Fatal("expected no error, got", err)
+				}
+				if reserved["new-node"].String() != "172.16.10.10/32" {
+					t.Errorf("expected the address to be reserved, got %v", reserved)
+				}
+			},
+		},
+		{
+			name: "private ipv4 reserved for another node",
+			code: codes.AlreadyExists,
+			req:  &types.NodeRegistration{ID: "other-node", PublicKey: pubKey, PrivateIPv4: "172.16.10.10/32"},
+		},
+		{
+			name: "registration without private ipv4",
+			code: codes.OK,
+			req:  &types.NodeRegistration{ID: "new-node", PublicKey: pubKey},
+			tval: func(t *testing.T) {
+				reserved, err := storage.ListIPReservations(ctx, server.storage.MeshStorage())
+				if err != nil {
+					t.Fatal("expected no error, got", err)
+				}
+				if _, ok := reserved["new-node"]; ok {
+					t.Error("expected the previous reservation to be released")
+				}
+			},
+		},
+	}
+
+	runTestCases(t, tt, server.PreRegisterNode)
+}
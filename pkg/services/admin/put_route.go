@@ -18,16 +18,26 @@ limitations under the License.
 package admin
 
 import (
+	"encoding/base64"
+	"fmt"
+
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
+// RouteSignatureMeta is the gRPC metadata key used to carry the signature of
+// a route put through the v1 API, encoded as standard base64.
+const RouteSignatureMeta = "x-webmesh-route-signature"
+
 var putRouteAction = rbac.Actions{
 	{
 		Resource: v1.RuleResource_RESOURCE_ROUTES,
@@ -35,24 +45,80 @@ var putRouteAction = rbac.Actions{
 	},
 }
 
+// PutRoute creates or updates a route. A signature from the node advertising
+// the route may be passed in the RouteSignatureMeta metadata.
 func (s *Server) PutRoute(ctx context.Context, route *v1.Route) (*emptypb.Empty, error) {
+	sig, err := RouteSignatureFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.putRoute(ctx, types.Route{Route: route, Signature: sig})
+}
+
+// PutSignedRoute creates or updates a route carrying a signature from the
+// node advertising it. The signature is verified against the identity key of
+// the node before the route is stored.
+func (s *Server) PutSignedRoute(ctx context.Context, route types.Route) (*emptypb.Empty, error) {
+	if !route.IsSigned() {
+		return nil, status.Error(codes.InvalidArgument, "route is not signed")
+	}
+	return s.putRoute(ctx, route)
+}
+
+func (s *Server) putRoute(ctx context.Context, rt types.Route) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
 		return nil, status.Error(codes.FailedPrecondition, "not the leader")
 	}
-	rt := types.Route{Route: route}
 	err := types.ValidateRoute(rt)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	if ok, err := s.rbacEval.Evaluate(ctx, putRouteAction.For(route.GetName())); !ok {
+	if s.opts.RequireSignedRoutes && !rt.IsSigned() {
+		return nil, status.Errorf(codes.InvalidArgument, "route must be signed by node %q", rt.GetNode())
+	}
+	if ok, err := s.rbacEval.Evaluate(ctx, putRouteAction.For(rt.GetName())); !ok {
 		if err != nil {
 			context.LoggerFrom(ctx).Error("failed to evaluate put route action", "error", err)
 		}
 		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to put network routes")
 	}
+	err = storage.CheckRouteLimit(ctx, s.storage.MeshStorage(), rt, s.opts.MaxRoutesPerNode)
+	if err != nil {
+		if errors.IsRouteLimitExceeded(err) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	err = s.db.Networking().PutRoute(ctx, rt)
 	if err != nil {
+		if errors.IsInvalidRouteSignature(err) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	return &emptypb.Empty{}, nil
 }
+
+// RouteSignatureFromContext returns the route signature presented in the
+// incoming gRPC metadata of the given context, if any.
+func RouteSignatureFromContext(ctx context.Context) ([]byte, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	vals := md.Get(RouteSignatureMeta)
+	if len(vals) == 0 || vals[0] == "" {
+		return nil, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(vals[0])
+	if err != nil {
+		return nil, fmt.Errorf("decode route signature: %w", err)
+	}
+	return sig, nil
+}
+
+// AppendRouteSignatureToOutgoingContext returns a context that passes the
+// given route signature in the outgoing gRPC metadata.
+func AppendRouteSignatureToOutgoingContext(ctx context.Context, sig []byte) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, RouteSignatureMeta, base64.StdEncoding.EncodeToString(sig))
+}
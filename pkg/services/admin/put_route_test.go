@@ -18,10 +18,17 @@ package admin
 
 import (
 	"context"
+	"encoding/base64"
 	"testing"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 func TestPutRoute(t *testing.T) {
@@ -133,3 +140,123 @@ func TestPutRoute(t *testing.T) {
 
 	runTestCases(t, tt, server.PutRoute)
 }
+
+func TestPutRouteLimit(t *testing.T) {
+	t.Parallel()
+
+	base := newTestServer(t)
+	server := NewServerWithOptions(base.storage, rbac.NewNoopEvaluator(), nil, Options{MaxRoutesPerNode: 2})
+
+	tt := []testCase[v1.Route]{
+		{
+			name: "route at the limit",
+			code: codes.OK,
+			req: &v1.Route{
+				Name:             "gateway-a",
+				Node:             "gateway",
+				DestinationCIDRs: []string{"10.0.0.0/24", "10.0.1.0/24"},
+			},
+		},
+		{
+			name: "route above the limit",
+			code: codes.ResourceExhausted,
+			req: &v1.Route{
+				Name:             "gateway-b",
+				Node:             "gateway",
+				DestinationCIDRs: []string{"10.0.2.0/24"},
+			},
+		},
+		{
+			name: "replaced route above the limit",
+			code: codes.ResourceExhausted,
+			req: &v1.Route{
+				Name:             "gateway-a",
+				Node:             "gateway",
+				DestinationCIDRs: []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24"},
+			},
+		},
+		{
+			name: "replaced route within the limit",
+			code: codes.OK,
+			req: &v1.Route{
+				Name:             "gateway-a",
+				Node:             "gateway",
+				DestinationCIDRs: []string{"10.0.0.0/24"},
+			},
+		},
+		{
+			name: "route for another node",
+			code: codes.OK,
+			req: &v1.Route{
+				Name:             "other",
+				Node:             "other",
+				DestinationCIDRs: []string{"10.1.0.0/24", "10.1.1.0/24"},
+			},
+		},
+	}
+
+	runTestCases(t, tt, server.PutRoute)
+}
+
+func TestPutSignedRoute(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := newTestServer(t)
+	server := NewServerWithOptions(base.storage, rbac.NewNoopEvaluator(), nil, Options{RequireSignedRoutes: true})
+	key := crypto.MustGenerateKey()
+	encoded, err := key.PublicKey().Encode()
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+	err = base.storage.MeshDB().Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "gateway", PublicKey: encoded}})
+	if err != nil {
+		t.Fatalf("put node: %v", err)
+	}
+	newRoute := func() types.Route {
+		return types.Route{Route: &v1.Route{
+			Name:             "gateway-signed",
+			Node:             "gateway",
+			DestinationCIDRs: []string{"10.0.0.0/24"},
+		}}
+	}
+
+	_, err = server.PutRoute(ctx, newRoute().Route)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected unsigned route to be rejected with %v, got %v", codes.InvalidArgument, err)
+	}
+
+	signed := newRoute()
+	if err := signed.Sign(key); err != nil {
+		t.Fatalf("sign route: %v", err)
+	}
+	_, err = server.PutSignedRoute(ctx, signed)
+	if err != nil {
+		t.Fatalf("expected signed route to be accepted, got %v", err)
+	}
+
+	// The signature can also be passed in metadata through the v1 API.
+	signed.DestinationCIDRs = []string{"10.0.0.0/16"}
+	if err := signed.Sign(key); err != nil {
+		t.Fatalf("sign route: %v", err)
+	}
+	md := metadata.Pairs(RouteSignatureMeta, base64.StdEncoding.EncodeToString(signed.Signature))
+	_, err = server.PutRoute(metadata.NewIncomingContext(ctx, md), signed.Route)
+	if err != nil {
+		t.Fatalf("expected route signed in metadata to be accepted, got %v", err)
+	}
+	md = metadata.Pairs(RouteSignatureMeta, "not base64")
+	_, err = server.PutRoute(metadata.NewIncomingContext(ctx, md), signed.Route)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected malformed signature to be rejected with %v, got %v", codes.InvalidArgument, err)
+	}
+
+	forged := newRoute()
+	if err := forged.Sign(crypto.MustGenerateKey()); err != nil {
+		t.Fatalf("sign route: %v", err)
+	}
+	_, err = server.PutSignedRoute(ctx, forged)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected forged route to be rejected with %v, got %v", codes.PermissionDenied, err)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var quarantineNodeAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+		Verb:     v1.RuleVerb_VERB_PUT,
+	},
+}
+
+var unquarantineNodeAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+		Verb:     v1.RuleVerb_VERB_DELETE,
+	},
+}
+
+// QuarantineRequest is a request to quarantine or release a node.
+type QuarantineRequest struct {
+	// NodeID is the ID of the node.
+	NodeID string `json:"nodeID"`
+}
+
+// QuarantineNode cuts a node off from the rest of the mesh by inserting
+// highest-priority ACLs that deny all of its traffic. The node is not removed
+// and every other node drops it from its peers on the next refresh.
+func (s *Server) QuarantineNode(ctx context.Context, req *QuarantineRequest) (*v1.MeshNode, error) {
+	node, err := s.quarantineTarget(ctx, req.NodeID, quarantineNodeAction, true)
+	if err != nil {
+		return nil, err
+	}
+	err = storage.QuarantineNode(ctx, s.db.Networking(), node.NodeID())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	context.LoggerFrom(ctx).Warn("Quarantined node", slog.String("node", req.NodeID))
+	return node.MeshNode, nil
+}
+
+// UnquarantineNode removes the quarantine ACLs for a node, restoring its
+// traffic to what the remaining ACLs allow. The node does not need to exist,
+// so that ACLs left behind by a removed node can be cleaned up.
+func (s *Server) UnquarantineNode(ctx context.Context, req *QuarantineRequest) (*v1.MeshNode, error) {
+	node, err := s.quarantineTarget(ctx, req.NodeID, unquarantineNodeAction, false)
+	if err != nil {
+		return nil, err
+	}
+	err = storage.UnquarantineNode(ctx, s.db.Networking(), node.NodeID())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	context.LoggerFrom(ctx).Info("Released node from quarantine", slog.String("node", req.NodeID))
+	return node.MeshNode, nil
+}
+
+// quarantineTarget validates a quarantine request and returns the node it targets.
+// If mustExist is false a missing node is returned with only its ID set.
+func (s *Server) quarantineTarget(ctx context.Context, nodeID string, action rbac.Actions, mustExist bool) (types.MeshNode, error) {
+	if !s.storage.Consensus().IsLeader() {
+		return types.MeshNode{}, status.Error(codes.FailedPrecondition, "not the leader")
+	}
+	if nodeID == "" {
+		return types.MeshNode{}, status.Error(codes.InvalidArgument, "node id cannot be empty")
+	}
+	if !types.IsValidNodeID(nodeID) {
+		return types.MeshNode{}, status.Error(codes.InvalidArgument, "invalid node id")
+	}
+	if ok, err := s.rbacEval.Evaluate(ctx, action.For(nodeID)); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate quarantine node action", "error", err)
+		}
+		return types.MeshNode{}, status.Error(codes.PermissionDenied, "caller does not have permission to quarantine nodes")
+	}
+	node, err := s.db.Peers().Get(ctx, types.NodeID(nodeID))
+	if err != nil {
+		if errors.IsNodeNotFound(err) && !mustExist {
+			return types.MeshNode{MeshNode: &v1.MeshNode{Id: nodeID}}, nil
+		}
+		if errors.IsNodeNotFound(err) {
+			return types.MeshNode{}, status.Errorf(codes.NotFound, "node %q not found", nodeID)
+		}
+		return types.MeshNode{}, status.Error(codes.Internal, err.Error())
+	}
+	return node, nil
+}
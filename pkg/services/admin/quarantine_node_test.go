@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestQuarantineNode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	err := server.db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          "node-b",
+		PublicKey:   newEncodedPubKey(t),
+		PrivateIPv4: "172.16.0.20/32",
+	}})
+	if err != nil {
+		t.Fatalf("put peer: %v", err)
+	}
+	quarantined := func(t *testing.T, id types.NodeID, want bool) {
+		t.Helper()
+		ok, err := storage.IsQuarantined(ctx, server.db.Networking(), id)
+		if err != nil {
+			t.Fatalf("is quarantined: %v", err)
+		}
+		if ok != want {
+			t.Errorf("expected %s quarantined to be %v, got %v", id, want, ok)
+		}
+	}
+
+	tt := []testCase[QuarantineRequest]{
+		{
+			name: "no node id",
+			code: codes.InvalidArgument,
+			req:  &QuarantineRequest{},
+		},
+		{
+			name: "invalid node id",
+			code: codes.InvalidArgument,
+			req:  &QuarantineRequest{NodeID: "invalid node"},
+		},
+		{
+			name: "non-existent node",
+			code: codes.NotFound,
+			req:  &QuarantineRequest{NodeID: "node-c"},
+		},
+		{
+			name: "existing node",
+			code: codes.OK,
+			req:  &QuarantineRequest{NodeID: "node-b"},
+			tval: func(t *testing.T) { quarantined(t, "node-b", true) },
+		},
+	}
+	runTestCases(t, tt, server.QuarantineNode)
+
+	tt = []testCase[QuarantineRequest]{
+		{
+			name: "no node id",
+			code: codes.InvalidArgument,
+			req:  &QuarantineRequest{},
+		},
+		{
+			name: "non-existent node",
+			code: codes.OK,
+			req:  &QuarantineRequest{NodeID: "node-c"},
+			tval: func(t *testing.T) { quarantined(t, "node-c", false) },
+		},
+		{
+			name: "quarantined node",
+			code: codes.OK,
+			req:  &QuarantineRequest{NodeID: "node-b"},
+			tval: func(t *testing.T) { quarantined(t, "node-b", false) },
+		},
+	}
+	runTestCases(t, tt, server.UnquarantineNode)
+}
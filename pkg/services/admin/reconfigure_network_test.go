@@ -0,0 +1,117 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestReconfigureNetwork(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestNetworkServer(t)
+	db := server.storage.MeshDB()
+	nodes, err := db.Peers().List(ctx)
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("expected the local node in storage, got %v: %v", nodes, err)
+	}
+	self := nodes[0].GetId()
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          "peer",
+		PublicKey:   newEncodedPubKey(t),
+		PrivateIPv4: "172.16.0.10/32",
+	}})
+	if err != nil {
+		t.Fatalf("put peer: %v", err)
+	}
+	err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: self, Target: "peer"}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+
+	res, err := server.ReconfigureNetwork(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("reconfigure network: %v", err)
+	}
+	if !slices.Contains(res.PeersAdded, "peer") {
+		t.Errorf("expected peer to be added, got %+v", res)
+	}
+
+	// Introduce drift on the interface and make sure it is corrected.
+	wg := server.network.WireGuard()
+	if err := wg.DeletePeer(ctx, "peer"); err != nil {
+		t.Fatalf("delete peer: %v", err)
+	}
+	err = wg.PutPeer(ctx, &wireguard.Peer{ID: "stray", PublicKey: crypto.MustGenerateKey().PublicKey()})
+	if err != nil {
+		t.Fatalf("put stray peer: %v", err)
+	}
+	res, err = server.ReconfigureNetwork(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("reconfigure network: %v", err)
+	}
+	if !slices.Equal(res.PeersAdded, []string{"peer"}) {
+		t.Errorf("expected peer to be restored, got %v", res.PeersAdded)
+	}
+	if !slices.Equal(res.PeersRemoved, []string{"stray"}) {
+		t.Errorf("expected stray peer to be removed, got %v", res.PeersRemoved)
+	}
+	peers := wg.Peers()
+	if _, ok := peers["peer"]; !ok {
+		t.Error("expected peer to be configured on the interface")
+	}
+	if _, ok := peers["stray"]; ok {
+		t.Error("expected stray peer to be removed from the interface")
+	}
+
+	// Reconfiguring a converged network is a no-op.
+	res, err = server.ReconfigureNetwork(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("reconfigure network: %v", err)
+	}
+	if res.Changed() {
+		t.Errorf("expected no changes, got %+v", res)
+	}
+
+	noNetwork := NewServer(server.storage, rbac.NewNoopEvaluator(), nil)
+	_, err = noNetwork.ReconfigureNetwork(ctx, &emptypb.Empty{})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable without a network manager, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// RotatePresharedKeyRequest is a request to rotate the WireGuard preshared
+// key on the edge between two nodes.
+type RotatePresharedKeyRequest struct {
+	// Source is one end of the edge.
+	Source string `json:"source"`
+	// Target is the other end of the edge.
+	Target string `json:"target"`
+	// Window is the time both ends have to learn the new key before they
+	// switch to it. Defaults to storage.DefaultPresharedKeyRotationWindow.
+	Window time.Duration `json:"window,omitempty"`
+}
+
+// RotatePresharedKeyResponse is the result of starting a preshared key
+// rotation. The key itself is never returned.
+type RotatePresharedKeyResponse struct {
+	// ActivateAt is the time both ends switch to the new key.
+	ActivateAt time.Time `json:"activateAt"`
+}
+
+// RotatePresharedKey starts a rotation of the preshared key on the edge
+// between two nodes. Any previous rotation on the edge that has become
+// active is completed first.
+func (s *Server) RotatePresharedKey(ctx context.Context, req *RotatePresharedKeyRequest) (*RotatePresharedKeyResponse, error) {
+	if !s.storage.Consensus().IsLeader() {
+		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+	}
+	if req.Source == "" || req.Target == "" {
+		return nil, status.Error(codes.InvalidArgument, "source and target cannot be empty")
+	}
+	if req.Window < 0 {
+		return nil, status.Error(codes.InvalidArgument, "window cannot be negative")
+	}
+	for _, id := range []string{req.Source, req.Target} {
+		if !types.IsValidNodeID(id) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid node ID: %s", id)
+		}
+		if ok, err := s.rbacEval.Evaluate(ctx, putEdgeAction.For(id)); !ok {
+			if err != nil {
+				context.LoggerFrom(ctx).Error("failed to evaluate put edge action", "error", err)
+			}
+			return nil, status.Error(codes.PermissionDenied, "caller does not have permission to put the given edge")
+		}
+	}
+	_, activateAt, err := storage.RotatePresharedKey(ctx, s.db.Peers(), types.NodeID(req.Source), types.NodeID(req.Target), req.Window)
+	if err != nil {
+		switch {
+		case errors.IsEdgeNotFound(err):
+			return nil, status.Error(codes.NotFound, "edge not found")
+		case errors.Is(err, errors.ErrRotationInProgress):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &RotatePresharedKeyResponse{ActivateAt: activateAt}, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRotatePresharedKey(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	p := server.storage.MeshDB().Peers()
+	fooKey := crypto.MustGenerateKey()
+	for _, peer := range []string{"foo", "bar", "baz"} {
+		encoded := newEncodedPubKey(t)
+		if peer == "foo" {
+			var err error
+			encoded, err = fooKey.PublicKey().Encode()
+			if err != nil {
+				t.Fatalf("encode public key: %v", err)
+			}
+		}
+		err := p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:        peer,
+			PublicKey: encoded,
+		}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	current, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	recipients, err := storage.PresharedKeyRecipients(ctx, p, "foo", "bar")
+	if err != nil {
+		t.Fatalf("get recipients: %v", err)
+	}
+	attrs, err := types.EdgeAttrsWithPresharedKey(nil, current, recipients)
+	if err != nil {
+		t.Fatalf("seal preshared key: %v", err)
+	}
+	err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source:     "foo",
+		Target:     "bar",
+		Weight:     1,
+		Attributes: attrs,
+	}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+
+	tt := []testCase[RotatePresharedKeyRequest]{
+		{
+			name: "no source",
+			code: codes.InvalidArgument,
+			req:  &RotatePresharedKeyRequest{Target: "bar"},
+		},
+		{
+			name: "invalid target",
+			code: codes.InvalidArgument,
+			req:  &RotatePresharedKeyRequest{Source: "foo", Target: "bar,"},
+		},
+		{
+			name: "negative window",
+			code: codes.InvalidArgument,
+			req:  &RotatePresharedKeyRequest{Source: "foo", Target: "bar", Window: -time.Second},
+		},
+		{
+			name: "missing edge",
+			code: codes.NotFound,
+			req:  &RotatePresharedKeyRequest{Source: "foo", Target: "baz"},
+		},
+		{
+			name: "valid rotation",
+			code: codes.OK,
+			req:  &RotatePresharedKeyRequest{Source: "bar", Target: "foo", Window: time.Minute},
+			tval: func(t *testing.T) {
+				edge, err := p.GetEdge(ctx, "foo", "bar")
+				if err != nil {
+					t.Fatalf("get edge: %v", err)
+				}
+				next, activateAt, ok := types.PendingPresharedKeyFromEdgeAttrs(edge.GetAttributes(), "foo", fooKey)
+				if !ok {
+					t.Fatal("expected a pending preshared key on the edge")
+				}
+				if next == current {
+					t.Error("expected a new preshared key")
+				}
+				if strings.Contains(edge.GetAttributes()[types.PendingPresharedKeyEdgeAttribute], next.String()) {
+					t.Error("expected the pending key to not be stored in plaintext")
+				}
+				if time.Until(activateAt) <= 0 {
+					t.Errorf("expected the key to activate in the future, got %s", activateAt)
+				}
+				// The current key stays in use until the rotation activates.
+				if key, ok := types.ActivePresharedKeyFromEdgeAttrs(edge.GetAttributes(), time.Now(), "foo", fooKey); !ok || key != current {
+					t.Error("expected the current key to stay active")
+				}
+			},
+		},
+		{
+			name: "rotation in progress",
+			code: codes.FailedPrecondition,
+			req:  &RotatePresharedKeyRequest{Source: "foo", Target: "bar"},
+		},
+	}
+
+	runTestCases(t, tt, server.RotatePresharedKey)
+}
@@ -20,6 +20,10 @@ package admin
 import (
 	v1 "github.com/webmeshproj/api/go/v1"
 
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/plugins"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 )
@@ -31,13 +35,41 @@ type Server struct {
 	storage  storage.Provider
 	db       storage.MeshDB
 	rbacEval rbac.Evaluator
+	tokens   *jointokens.Issuer
+	network  meshnet.Manager
+	opts     Options
 }
 
-// New creates a new admin server.
-func NewServer(storage storage.Provider, rbac rbac.Evaluator) *Server {
+// Options are options for the admin server.
+type Options struct {
+	// MaxRoutesPerNode is the maximum number of destination CIDRs a single
+	// node may advertise across all of its routes. Zero means no limit.
+	MaxRoutesPerNode int
+	// RequireSignedRoutes rejects routes that are not signed by the
+	// identity key of the node advertising them.
+	RequireSignedRoutes bool
+	// JoinTokenKey is the key join tokens are signed with. Join tokens cannot
+	// be issued without it.
+	JoinTokenKey crypto.PrivateKey
+	// Plugins is the plugin manager used to reserve the addresses of
+	// pre-registered nodes with the IPAM plugin.
+	Plugins plugins.Manager
+}
+
+// New creates a new admin server. The network manager is used for diagnostics
+// about the local node and may be nil.
+func NewServer(storage storage.Provider, rbac rbac.Evaluator, network meshnet.Manager) *Server {
+	return NewServerWithOptions(storage, rbac, network, Options{})
+}
+
+// NewServerWithOptions creates a new admin server with the given options.
+func NewServerWithOptions(storage storage.Provider, rbac rbac.Evaluator, network meshnet.Manager, opts Options) *Server {
 	return &Server{
 		storage:  storage,
 		db:       storage.MeshDB(),
 		rbacEval: rbac,
+		tokens:   jointokens.NewIssuer(storage.MeshStorage(), opts.JoinTokenKey),
+		network:  network,
+		opts:     opts,
 	}
 }
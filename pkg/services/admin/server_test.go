@@ -25,6 +25,8 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	meshnettest "github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 )
@@ -39,7 +41,39 @@ func newTestServer(t *testing.T) *Server {
 	t.Cleanup(func() {
 		store.Close(ctx)
 	})
-	return NewServer(store.Storage(), rbac.NewNoopEvaluator())
+	return NewServerWithOptions(store.Storage(), rbac.NewNoopEvaluator(), store.Network(), Options{JoinTokenKey: store.Key(), Plugins: store.Plugins()})
+}
+
+// newTestNetworkServer returns a server backed by a started test network
+// manager on the mesh network. Its wireguard interface is an in-memory fake
+// read through a fake wgctrl device.
+func newTestNetworkServer(t *testing.T) *Server {
+	t.Helper()
+	ctx := context.Background()
+	store, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatal(fmt.Errorf("error creating test store: %w", err))
+	}
+	t.Cleanup(func() {
+		store.Close(ctx)
+	})
+	state, err := store.Storage().MeshDB().MeshState().GetMeshState(ctx)
+	if err != nil {
+		t.Fatal(fmt.Errorf("error getting mesh state: %w", err))
+	}
+	nw := meshnettest.NewManagerWithDB(store.Storage().MeshDB(), meshnet.Options{InterfaceName: "webmesh0"}, store.ID())
+	err = nw.Start(ctx, meshnet.StartOptions{
+		Key:       crypto.MustGenerateKey(),
+		NetworkV4: state.NetworkV4(),
+		NetworkV6: state.NetworkV6(),
+	})
+	if err != nil {
+		t.Fatal(fmt.Errorf("error starting test network: %w", err))
+	}
+	t.Cleanup(func() {
+		nw.Close(ctx)
+	})
+	return NewServer(store.Storage(), rbac.NewNoopEvaluator(), nw)
 }
 
 func newEncodedPubKey(t *testing.T) string {
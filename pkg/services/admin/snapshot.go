@@ -0,0 +1,101 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"io"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+)
+
+var snapshotAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ALL,
+		Verb:     v1.RuleVerb_VERB_ALL,
+	},
+}
+
+// Snapshotter is a storage provider that supports manual snapshots.
+type Snapshotter interface {
+	// Snapshot takes a snapshot of the current storage state and returns
+	// its metadata.
+	Snapshot(ctx context.Context) (*raftstorage.SnapshotMeta, error)
+}
+
+// SnapshotRestorer is a storage provider that supports restoring from an
+// exported snapshot.
+type SnapshotRestorer interface {
+	// RestoreSnapshot replaces all storage state with the given snapshot.
+	RestoreSnapshot(ctx context.Context, src io.Reader) error
+}
+
+// Snapshot triggers a snapshot of the storage state on the leader and returns
+// its metadata.
+func (s *Server) Snapshot(ctx context.Context, _ *emptypb.Empty) (*raftstorage.SnapshotMeta, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, snapshotAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate snapshot action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to take snapshots")
+	}
+	snapshotter, ok := s.storage.(Snapshotter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "storage provider does not support snapshots")
+	}
+	meta, err := snapshotter.Snapshot(ctx)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotLeader) {
+			return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return meta, nil
+}
+
+// RestoreSnapshot restores the storage state on the leader from a previously
+// exported snapshot. This is destructive and must be explicitly enabled on the
+// storage provider.
+func (s *Server) RestoreSnapshot(ctx context.Context, src io.Reader) (*emptypb.Empty, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, snapshotAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate snapshot action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to restore snapshots")
+	}
+	restorer, ok := s.storage.(SnapshotRestorer)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "storage provider does not support restoring snapshots")
+	}
+	if err := restorer.RestoreSnapshot(ctx, src); err != nil {
+		switch {
+		case errors.Is(err, errors.ErrNotLeader):
+			return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		case errors.Is(err, raftstorage.ErrSnapshotRestoreDisabled):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
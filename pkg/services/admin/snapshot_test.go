@@ -0,0 +1,62 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	_, err := server.PutRoute(ctx, &v1.Route{
+		Name:             "snapshot-route",
+		Node:             "foo",
+		DestinationCIDRs: []string{"0.0.0.0/0"},
+	})
+	if err != nil {
+		t.Fatal("put route:", err)
+	}
+	meta, err := server.Snapshot(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if meta.ID == "" {
+		t.Error("expected snapshot to have an ID")
+	}
+	if meta.Index == 0 || meta.Term == 0 {
+		t.Errorf("expected non-zero index and term, got %d and %d", meta.Index, meta.Term)
+	}
+	if meta.Size <= 0 {
+		t.Errorf("expected non-empty snapshot, got size %d", meta.Size)
+	}
+
+	// Snapshotting again succeeds even when there is nothing new to snapshot.
+	again, err := server.Snapshot(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if again.Index < meta.Index {
+		t.Errorf("expected snapshot at or after index %d, got %d", meta.Index, again.Index)
+	}
+}
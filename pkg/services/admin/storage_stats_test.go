@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func TestStorageStats(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestServer(t)
+	before, err := server.StorageStats(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	const routes = 3
+	for _, name := range []string{"stats-a", "stats-b", "stats-c"} {
+		_, err := server.PutRoute(ctx, &v1.Route{
+			Name:             name,
+			Node:             "foo",
+			DestinationCIDRs: []string{"0.0.0.0/0"},
+		})
+		if err != nil {
+			t.Fatal("put route:", err)
+		}
+	}
+	after, err := server.StorageStats(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	prefix := storage.RoutesPrefix.String()
+	if got := after.Prefixes[prefix].Keys - before.Prefixes[prefix].Keys; got != routes {
+		t.Errorf("expected %d new route keys, got %d", routes, got)
+	}
+	if after.Total.Keys < before.Total.Keys+routes {
+		t.Errorf("expected total keys to grow by at least %d, got %d -> %d", routes, before.Total.Keys, after.Total.Keys)
+	}
+}
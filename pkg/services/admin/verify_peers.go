@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+var verifyPeersAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ALL,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// VerifyPeersRequest is a request to verify connectivity to all current peers.
+type VerifyPeersRequest struct {
+	// Ping enables pinging the mesh address of each peer.
+	Ping bool `json:"ping,omitempty"`
+	// MaxHandshakeAge is the age after which a handshake is considered stale.
+	// Defaults to meshnet.DefaultMaxHandshakeAge.
+	MaxHandshakeAge time.Duration `json:"maxHandshakeAge,omitempty"`
+}
+
+// VerifyPeersResponse is the result of verifying connectivity to peers.
+type VerifyPeersResponse struct {
+	// Peers is the status of each peer on the interface.
+	Peers []meshnet.PeerStatus `json:"peers"`
+	// Healthy is true if every peer is healthy.
+	Healthy bool `json:"healthy"`
+}
+
+// VerifyPeers checks the handshake age of every peer on the local WireGuard
+// interface and optionally pings their mesh addresses.
+func (s *Server) VerifyPeers(ctx context.Context, req *VerifyPeersRequest) (*VerifyPeersResponse, error) {
+	if s.network == nil {
+		return nil, status.Error(codes.Unavailable, "network manager is not available")
+	}
+	if ok, err := s.rbacEval.Evaluate(ctx, verifyPeersAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate verify peers action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to verify peers")
+	}
+	peers, err := s.network.VerifyPeers(ctx, meshnet.VerifyPeersOptions{
+		MaxHandshakeAge: req.MaxHandshakeAge,
+		Ping:            req.Ping,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	res := &VerifyPeersResponse{Peers: peers, Healthy: true}
+	for _, peer := range peers {
+		if !peer.Healthy {
+			res.Healthy = false
+			break
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	meshnettest "github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+func TestVerifyPeers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	server := newTestNetworkServer(t)
+	wg := server.network.WireGuard()
+	for _, id := range []string{"fresh", "stale"} {
+		err := wg.PutPeer(ctx, &wireguard.Peer{
+			ID:          id,
+			PublicKey:   crypto.MustGenerateKey().PublicKey(),
+			PrivateIPv4: netip.MustParsePrefix("172.16.0.10/32"),
+		})
+		if err != nil {
+			t.Fatalf("put peer %s: %v", id, err)
+		}
+	}
+	fake, ok := wg.(*meshnettest.WireGuardInterface)
+	if !ok {
+		t.Fatalf("expected a test wireguard interface, got %T", wg)
+	}
+	fake.SetLastHandshake("fresh", time.Now().Add(-30*time.Second))
+	fake.SetLastHandshake("stale", time.Now().Add(-10*time.Minute))
+
+	res, err := server.VerifyPeers(ctx, &VerifyPeersRequest{Ping: true})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res.Healthy {
+		t.Error("expected the mesh to be unhealthy with a stale peer")
+	}
+	if len(res.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %+v", res.Peers)
+	}
+	for _, peer := range res.Peers {
+		switch peer.ID {
+		case "fresh":
+			if !peer.Healthy || peer.HandshakeStale || !peer.Pinged {
+				t.Errorf("expected fresh peer to be healthy and pinged, got %+v", peer)
+			}
+		case "stale":
+			if peer.Healthy || !peer.HandshakeStale {
+				t.Errorf("expected stale peer to be unhealthy, got %+v", peer)
+			}
+		default:
+			t.Errorf("unexpected peer %+v", peer)
+		}
+	}
+
+	// A larger handshake age tolerates the stale peer.
+	res, err = server.VerifyPeers(ctx, &VerifyPeersRequest{MaxHandshakeAge: 15 * time.Minute})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !res.Healthy {
+		t.Errorf("expected all peers to be healthy, got %+v", res.Peers)
+	}
+
+	noNetwork := NewServer(server.storage, rbac.NewNoopEvaluator(), nil)
+	_, err = noNetwork.VerifyPeers(ctx, &VerifyPeersRequest{})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable without a network manager, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/admin"
+	"github.com/webmeshproj/webmesh/pkg/services/membership"
+)
+
+// FeatureServices maps each feature to the gRPC services that provide it.
+// Services not listed here are not gated by any feature.
+var FeatureServices = map[v1.Feature][]string{
+	v1.Feature_NODES:           {v1.Node_ServiceDesc.ServiceName},
+	v1.Feature_MEMBERSHIP:      {v1.Membership_ServiceDesc.ServiceName, membership.Extensions_ServiceDesc.ServiceName},
+	v1.Feature_STORAGE_QUERIER: {v1.StorageQueryService_ServiceDesc.ServiceName},
+	v1.Feature_MESH_API:        {v1.Mesh_ServiceDesc.ServiceName},
+	v1.Feature_ADMIN_API:       {v1.Admin_ServiceDesc.ServiceName, admin.Extensions_ServiceDesc.ServiceName},
+	v1.Feature_ICE_NEGOTIATION: {v1.WebRTC_ServiceDesc.ServiceName},
+	v1.Feature_REGISTRAR:       {v1.Registrar_ServiceDesc.ServiceName},
+}
+
+// FeatureForService returns the feature that gates the given service.
+func FeatureForService(serviceName string) (v1.Feature, bool) {
+	for feature, services := range FeatureServices {
+		for _, svc := range services {
+			if svc == serviceName {
+				return feature, true
+			}
+		}
+	}
+	return v1.Feature_FEATURE_NONE, false
+}
+
+// FeatureGate is a grpc.ServiceRegistrar that only registers the services
+// of enabled features.
+type FeatureGate struct {
+	reg     grpc.ServiceRegistrar
+	enabled map[v1.Feature]struct{}
+	log     *slog.Logger
+}
+
+// NewFeatureGate returns a gate registering services to reg for the given
+// enabled features.
+func NewFeatureGate(ctx context.Context, reg grpc.ServiceRegistrar, features []*v1.FeaturePort) *FeatureGate {
+	enabled := make(map[v1.Feature]struct{}, len(features))
+	for _, feat := range features {
+		enabled[feat.GetFeature()] = struct{}{}
+	}
+	return &FeatureGate{
+		reg:     reg,
+		enabled: enabled,
+		log:     context.LoggerFrom(ctx).With("component", "feature-gate"),
+	}
+}
+
+// For returns a gate with the same enabled features that registers
+// services to reg.
+func (g *FeatureGate) For(reg grpc.ServiceRegistrar) *FeatureGate {
+	return &FeatureGate{reg: reg, enabled: g.enabled, log: g.log}
+}
+
+// Enabled returns true if the given feature is enabled.
+func (g *FeatureGate) Enabled(feature v1.Feature) bool {
+	_, ok := g.enabled[feature]
+	return ok
+}
+
+// RegisterService implements grpc.ServiceRegistrar. Services belonging to a
+// disabled feature are not registered.
+func (g *FeatureGate) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	if feature, ok := FeatureForService(desc.ServiceName); ok && !g.Enabled(feature) {
+		g.log.Debug("Not registering service for disabled feature",
+			slog.String("service", desc.ServiceName),
+			slog.String("feature", feature.String()))
+		return
+	}
+	g.reg.RegisterService(desc, impl)
+}
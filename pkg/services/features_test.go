@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestFeatureGate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	tc := []struct {
+		name     string
+		features []v1.Feature
+		want     []string
+	}{
+		{
+			name: "NoFeatures",
+			want: []string{},
+		},
+		{
+			name:     "NodesOnly",
+			features: []v1.Feature{v1.Feature_NODES},
+			want:     []string{v1.Node_ServiceDesc.ServiceName},
+		},
+		{
+			name:     "MeshAndAdmin",
+			features: []v1.Feature{v1.Feature_NODES, v1.Feature_MESH_API, v1.Feature_ADMIN_API},
+			want: []string{
+				v1.Node_ServiceDesc.ServiceName,
+				v1.Mesh_ServiceDesc.ServiceName,
+				v1.Admin_ServiceDesc.ServiceName,
+			},
+		},
+		{
+			name:     "ICEWithoutNodes",
+			features: []v1.Feature{v1.Feature_ICE_NEGOTIATION, v1.Feature_TURN_SERVER},
+			want:     []string{v1.WebRTC_ServiceDesc.ServiceName},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var features []*v1.FeaturePort
+			for _, f := range tt.features {
+				features = append(features, &v1.FeaturePort{Feature: f, Port: 8443})
+			}
+			srv := grpc.NewServer()
+			gate := NewFeatureGate(ctx, srv, features)
+			v1.RegisterNodeServer(gate, v1.UnimplementedNodeServer{})
+			v1.RegisterMeshServer(gate, v1.UnimplementedMeshServer{})
+			v1.RegisterAdminServer(gate, v1.UnimplementedAdminServer{})
+			v1.RegisterWebRTCServer(gate, v1.UnimplementedWebRTCServer{})
+			v1.RegisterMembershipServer(gate, v1.UnimplementedMembershipServer{})
+			// Services without a feature are always registered.
+			v1.RegisterAppDaemonServer(gate, v1.UnimplementedAppDaemonServer{})
+
+			info := srv.GetServiceInfo()
+			if _, ok := info[v1.AppDaemon_ServiceDesc.ServiceName]; !ok {
+				t.Error("expected ungated service to be registered")
+			}
+			delete(info, v1.AppDaemon_ServiceDesc.ServiceName)
+			if len(info) != len(tt.want) {
+				t.Errorf("expected %d gated services, got %d: %v", len(tt.want), len(info), info)
+			}
+			for _, name := range tt.want {
+				if _, ok := info[name]; !ok {
+					t.Errorf("expected %s to be registered", name)
+				}
+			}
+		})
+	}
+}
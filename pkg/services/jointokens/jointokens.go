@@ -0,0 +1,332 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jointokens provides signed, short-lived, single-use tokens that
+// can be presented by a node when joining the mesh.
+package jointokens
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/clock"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	storerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// MetadataKey is the gRPC metadata key used to present a join token.
+const MetadataKey = "x-webmesh-join-token"
+
+const (
+	// DefaultTTL is the lifetime of a token when none is requested.
+	DefaultTTL = 15 * time.Minute
+	// MaxTTL is the longest lifetime a token can be issued with.
+	MaxTTL = 24 * time.Hour
+)
+
+var (
+	// SigningKeysPrefix is the prefix where the public keys trusted to sign
+	// join tokens are stored, keyed by their ID. The private keys never leave
+	// the nodes that issued tokens with them.
+	SigningKeysPrefix = types.RegistryPrefix.ForString("join-tokens-keys")
+	// UsedTokensPrefix is the prefix where the IDs of consumed tokens are stored
+	// until they expire.
+	UsedTokensPrefix = types.RegistryPrefix.ForString("join-tokens-used")
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature
+	// does not match.
+	ErrInvalidToken = errors.New("invalid join token")
+	// ErrTokenExpired is returned when a token is past its expiry.
+	ErrTokenExpired = errors.New("join token expired")
+	// ErrTokenUsed is returned when a token has already been consumed.
+	ErrTokenUsed = errors.New("join token already used")
+)
+
+// IsInvalidToken returns true if the given error indicates a token was
+// rejected rather than an error occurring while checking it.
+func IsInvalidToken(err error) bool {
+	return errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenUsed)
+}
+
+// RoleBindingName returns the name of the rolebinding created for a role
+// granted to a node by a join token.
+func RoleBindingName(nodeID, role string) string {
+	return fmt.Sprintf("join-token-%s-%s", nodeID, role)
+}
+
+// IssueJoinTokenRequest is a request to issue a new join token.
+type IssueJoinTokenRequest struct {
+	// NodeID is the ID of the node permitted to join with the token.
+	NodeID string `json:"nodeID"`
+	// Roles are the roles bound to the node when it joins with the token.
+	Roles []string `json:"roles,omitempty"`
+	// TTL is how long the token is valid for. Defaults to DefaultTTL.
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// JoinToken is an issued join token.
+type JoinToken struct {
+	// Token is the encoded token to present when joining.
+	Token string `json:"token"`
+	// ID is the unique ID of the token.
+	ID string `json:"id"`
+	// NodeID is the ID of the node permitted to join with the token.
+	NodeID string `json:"nodeID"`
+	// Roles are the roles bound to the node when it joins with the token.
+	Roles []string `json:"roles,omitempty"`
+	// Expires is when the token expires.
+	Expires time.Time `json:"expires"`
+}
+
+// claims are the signed contents of a token.
+type claims struct {
+	ID       string   `json:"jti"`
+	KeyID    string   `json:"kid"`
+	NodeID   string   `json:"sub"`
+	Roles    []string `json:"roles,omitempty"`
+	IssuedAt int64    `json:"iat"`
+	Expires  int64    `json:"exp"`
+}
+
+// Issuer issues and consumes join tokens backed by mesh storage.
+type Issuer struct {
+	st    storage.MeshStorage
+	key   crypto.PrivateKey
+	clock clock.Clock
+	mu    sync.Mutex
+}
+
+// NewIssuer returns a new Issuer using the given storage. Tokens are signed
+// with the given key, which may be nil for an Issuer that only verifies and
+// consumes tokens.
+func NewIssuer(st storage.MeshStorage, key crypto.PrivateKey) *Issuer {
+	return NewIssuerWithClock(st, key, clock.Real())
+}
+
+// NewIssuerWithClock returns a new Issuer using the given storage, signing key
+// and clock for issuing and expiring tokens.
+func NewIssuerWithClock(st storage.MeshStorage, key crypto.PrivateKey, clk clock.Clock) *Issuer {
+	return &Issuer{st: st, key: key, clock: clock.OrReal(clk)}
+}
+
+// Issue issues a new token for the given request. The public half of the
+// signing key is stored on first use so that any node can verify the token.
+func (i *Issuer) Issue(ctx context.Context, req *IssueJoinTokenRequest) (*JoinToken, error) {
+	if !types.IsValidNodeID(req.NodeID) {
+		return nil, fmt.Errorf("invalid node id %q", req.NodeID)
+	}
+	for _, role := range req.Roles {
+		if !types.IsValidID(role) {
+			return nil, fmt.Errorf("invalid role %q", role)
+		}
+	}
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	} else if ttl > MaxTTL {
+		return nil, fmt.Errorf("ttl %s exceeds maximum of %s", ttl, MaxTTL)
+	}
+	if i.key == nil {
+		return nil, errors.New("no key is configured for signing join tokens")
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	err := i.trustSigningKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := crypto.NewRandomID()
+	if err != nil {
+		return nil, fmt.Errorf("generate token id: %w", err)
+	}
+	issued := i.clock.Now().UTC()
+	c := claims{
+		ID:       id,
+		KeyID:    i.key.ID(),
+		NodeID:   req.NodeID,
+		Roles:    req.Roles,
+		IssuedAt: issued.Unix(),
+		Expires:  issued.Add(ttl).Unix(),
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("marshal token claims: %w", err)
+	}
+	sig := ed25519.Sign(i.key.AsNative(), data)
+	return &JoinToken{
+		Token:   base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(sig),
+		ID:      c.ID,
+		NodeID:  c.NodeID,
+		Roles:   c.Roles,
+		Expires: time.Unix(c.Expires, 0).UTC(),
+	}, nil
+}
+
+// Verify checks the signature and expiry of the given token without
+// consuming it.
+func (i *Issuer) Verify(ctx context.Context, token string) (*JoinToken, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.verify(ctx, token)
+}
+
+// Consume verifies the given token and marks it as used. A token can only
+// be consumed once. The used marker is written only if it is absent, so
+// concurrent consumers on any node cannot both succeed.
+func (i *Issuer) Consume(ctx context.Context, token string) (*JoinToken, error) {
+	tok, err := i.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the marker until the token would have expired anyway.
+	ttl := tok.Expires.Sub(i.clock.Now())
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	batch := i.st.Batch()
+	batch.PutValueIfAbsent(UsedTokensPrefix.ForString(tok.ID), []byte(tok.NodeID), ttl)
+	err = batch.Commit(ctx)
+	if err != nil {
+		if storerrors.IsKeyExists(err) {
+			return nil, ErrTokenUsed
+		}
+		return nil, fmt.Errorf("mark token used: %w", err)
+	}
+	return tok, nil
+}
+
+// Release returns a consumed token to an unused state. This is used when
+// a join fails after the token was consumed so the node may retry.
+func (i *Issuer) Release(ctx context.Context, tok *JoinToken) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	err := i.st.Delete(ctx, UsedTokensPrefix.ForString(tok.ID))
+	if err != nil && !storerrors.IsKeyNotFound(err) {
+		return fmt.Errorf("release token: %w", err)
+	}
+	return nil
+}
+
+func (i *Issuer) verify(ctx context.Context, token string) (*JoinToken, error) {
+	encData, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encData)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if c.ID == "" || c.KeyID == "" || !types.IsValidNodeID(c.NodeID) {
+		return nil, ErrInvalidToken
+	}
+	key, err := i.signingKey(ctx, c.KeyID)
+	if err != nil {
+		if storerrors.IsKeyNotFound(err) {
+			// The key was never trusted to sign tokens.
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if !ed25519.Verify(key.AsNative(), data, sig) {
+		return nil, ErrInvalidToken
+	}
+	expires := time.Unix(c.Expires, 0).UTC()
+	if !i.clock.Now().Before(expires) {
+		return nil, ErrTokenExpired
+	}
+	return &JoinToken{
+		Token:   token,
+		ID:      c.ID,
+		NodeID:  c.NodeID,
+		Roles:   c.Roles,
+		Expires: expires,
+	}, nil
+}
+
+// trustSigningKey stores the public half of the issuer's key, if it is not
+// already stored.
+func (i *Issuer) trustSigningKey(ctx context.Context) error {
+	key := SigningKeysPrefix.ForString(i.key.ID())
+	_, err := i.st.GetValue(ctx, key)
+	if err == nil {
+		return nil
+	}
+	if !storerrors.IsKeyNotFound(err) {
+		return fmt.Errorf("get signing key: %w", err)
+	}
+	encoded, err := i.key.PublicKey().Encode()
+	if err != nil {
+		return fmt.Errorf("encode signing key: %w", err)
+	}
+	err = i.st.PutValue(ctx, key, []byte(encoded), 0)
+	if err != nil {
+		return fmt.Errorf("store signing key: %w", err)
+	}
+	return nil
+}
+
+// signingKey returns the trusted public key with the given ID.
+func (i *Issuer) signingKey(ctx context.Context, id string) (crypto.PublicKey, error) {
+	data, err := i.st.GetValue(ctx, SigningKeysPrefix.ForString(id))
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.DecodePublicKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode signing key: %w", err)
+	}
+	return key, nil
+}
+
+// FromContext returns the join token presented in the incoming gRPC metadata
+// of the given context, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		token := md.Get(MetadataKey)
+		if len(token) > 0 && token[0] != "" {
+			return token[0], true
+		}
+	}
+	return "", false
+}
+
+// AppendToOutgoingContext returns a context that presents the given join
+// token in the outgoing gRPC metadata.
+func AppendToOutgoingContext(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, token)
+}
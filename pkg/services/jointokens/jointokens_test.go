@@ -0,0 +1,191 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jointokens
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/clock"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestJoinTokens(t *testing.T) {
+	ctx := context.Background()
+
+	newIssuer := func(t *testing.T) *Issuer {
+		t.Helper()
+		st := badgerdb.NewTestStorage(false)
+		t.Cleanup(func() { _ = st.Close() })
+		return NewIssuer(st, crypto.MustGenerateKey())
+	}
+	newIssuerWithClock := func(t *testing.T, clk clock.Clock) *Issuer {
+		t.Helper()
+		st := badgerdb.NewTestStorage(false)
+		t.Cleanup(func() { _ = st.Close() })
+		return NewIssuerWithClock(st, crypto.MustGenerateKey(), clk)
+	}
+
+	t.Run("AcceptedOnce", func(t *testing.T) {
+		issuer := newIssuer(t)
+		tok, err := issuer.Issue(ctx, &IssueJoinTokenRequest{NodeID: "node-a", Roles: []string{"voters"}})
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		got, err := issuer.Consume(ctx, tok.Token)
+		if err != nil {
+			t.Fatalf("consume token: %v", err)
+		}
+		if got.NodeID != "node-a" || len(got.Roles) != 1 || got.Roles[0] != "voters" {
+			t.Errorf("unexpected token claims: %+v", got)
+		}
+		if _, err := issuer.Consume(ctx, tok.Token); !errors.Is(err, ErrTokenUsed) {
+			t.Fatalf("expected ErrTokenUsed on reuse, got %v", err)
+		}
+		// Releasing the token allows it to be used again.
+		if err := issuer.Release(ctx, got); err != nil {
+			t.Fatalf("release token: %v", err)
+		}
+		if _, err := issuer.Consume(ctx, tok.Token); err != nil {
+			t.Fatalf("consume released token: %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		clk := clock.NewFake(time.Unix(1700000000, 0))
+		issuer := newIssuerWithClock(t, clk)
+		tok, err := issuer.Issue(ctx, &IssueJoinTokenRequest{NodeID: "node-a", TTL: time.Minute})
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		// The token is valid right up until it expires.
+		clk.Advance(59 * time.Second)
+		if _, err := issuer.Verify(ctx, tok.Token); err != nil {
+			t.Fatalf("expected token to be valid before expiry, got %v", err)
+		}
+		clk.Advance(time.Second)
+		if _, err := issuer.Consume(ctx, tok.Token); !errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("expected ErrTokenExpired, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		issuer := newIssuer(t)
+		tok, err := issuer.Issue(ctx, &IssueJoinTokenRequest{NodeID: "node-a"})
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		other := newIssuer(t)
+		if _, err := other.Issue(ctx, &IssueJoinTokenRequest{NodeID: "node-a"}); err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		data, sig, _ := strings.Cut(tok.Token, ".")
+		tc := map[string]string{
+			"Empty":         "",
+			"NoSignature":   data,
+			"BadEncoding":   "!!!." + sig,
+			"Tampered":      data + "A." + sig,
+			"ForeignIssuer": tok.Token,
+		}
+		for name, token := range tc {
+			i := issuer
+			if name == "ForeignIssuer" {
+				i = other
+			}
+			if _, err := i.Consume(ctx, token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		issuer := newIssuer(t)
+		for _, req := range []*IssueJoinTokenRequest{
+			{NodeID: ""},
+			{NodeID: "node a"},
+			{NodeID: "node-a", Roles: []string{"bad role"}},
+			{NodeID: "node-a", TTL: MaxTTL + time.Second},
+		} {
+			if _, err := issuer.Issue(ctx, req); err == nil {
+				t.Errorf("expected error issuing token for %+v", req)
+			}
+		}
+	})
+	t.Run("ConsumedOnceAcrossIssuers", func(t *testing.T) {
+		st := badgerdb.NewTestStorage(false)
+		t.Cleanup(func() { _ = st.Close() })
+		tok, err := NewIssuer(st, crypto.MustGenerateKey()).Issue(ctx, &IssueJoinTokenRequest{NodeID: "node-a"})
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		// Separate issuers share no lock, so only storage can reject the reuse.
+		const consumers = 8
+		var wg sync.WaitGroup
+		var accepted atomic.Int32
+		for n := 0; n < consumers; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := NewIssuer(st, nil).Consume(ctx, tok.Token)
+				if err == nil {
+					accepted.Add(1)
+				} else if !errors.Is(err, ErrTokenUsed) {
+					t.Errorf("expected ErrTokenUsed, got %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if got := accepted.Load(); got != 1 {
+			t.Fatalf("expected the token to be consumed once, got %d", got)
+		}
+	})
+	t.Run("OnlyPublicKeyStored", func(t *testing.T) {
+		st := badgerdb.NewTestStorage(false)
+		t.Cleanup(func() { _ = st.Close() })
+		key := crypto.MustGenerateKey()
+		issuer := NewIssuer(st, key)
+		tok, err := issuer.Issue(ctx, &IssueJoinTokenRequest{NodeID: "node-a"})
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		data, err := st.GetValue(ctx, SigningKeysPrefix.ForString(key.ID()))
+		if err != nil {
+			t.Fatalf("get signing key: %v", err)
+		}
+		stored, err := crypto.DecodePublicKey(string(data))
+		if err != nil {
+			t.Fatalf("expected a stored public key: %v", err)
+		}
+		if !stored.Equals(key.PublicKey()) {
+			t.Error("expected the public half of the signing key to be stored")
+		}
+		// An issuer without a key can still consume tokens but not issue them.
+		verifier := NewIssuer(st, nil)
+		if _, err := verifier.Consume(ctx, tok.Token); err != nil {
+			t.Fatalf("consume token: %v", err)
+		}
+		if _, err := verifier.Issue(ctx, &IssueJoinTokenRequest{NodeID: "node-a"}); err == nil {
+			t.Error("expected an error issuing a token without a key")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderproxy
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/clock"
+)
+
+// BreakerOptions are options for the circuit breaker around leader dials.
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures to reach the
+	// leader before the breaker opens.
+	FailureThreshold int
+	// InitialBackoff is how long the breaker stays open after it first trips.
+	// A single probe is let through each time the backoff elapses.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound on the backoff, which doubles after each
+	// failed probe.
+	MaxBackoff time.Duration
+	// Clock is the clock to use for the backoff. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// DefaultBreakerOptions returns the default breaker options.
+func DefaultBreakerOptions() BreakerOptions {
+	return BreakerOptions{
+		FailureThreshold: 3,
+		InitialBackoff:   time.Second,
+		MaxBackoff:       15 * time.Second,
+	}
+}
+
+// breaker is a circuit breaker that fast-fails proxied requests while the
+// leader is unreachable.
+type breaker struct {
+	opts      BreakerOptions
+	clock     clock.Clock
+	failures  int
+	backoff   time.Duration
+	openUntil time.Time
+	mu        sync.Mutex
+}
+
+func newBreaker(opts BreakerOptions) *breaker {
+	defaults := DefaultBreakerOptions()
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaults.FailureThreshold
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaults.InitialBackoff
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = max(defaults.MaxBackoff, opts.InitialBackoff)
+	}
+	return &breaker{opts: opts, clock: clock.OrReal(opts.Clock)}
+}
+
+// allow returns an Unavailable error if the breaker is open. Once the backoff
+// has elapsed a single caller is let through to probe the leader, and the
+// next probe is scheduled for after another backoff period.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	now := b.clock.Now()
+	if now.Before(b.openUntil) {
+		return status.Errorf(codes.Unavailable, "leader is unavailable, retry in %s", b.openUntil.Sub(now).Round(time.Millisecond))
+	}
+	b.openUntil = now.Add(b.backoff)
+	return nil
+}
+
+// record records the result of a request proxied to the leader.
+func (b *breaker) record(err error) {
+	if err == nil {
+		b.success()
+		return
+	}
+	if _, ok := status.FromError(err); !ok {
+		// Errors from the dialer itself are not status errors.
+		b.failure()
+		return
+	}
+	switch status.Code(err) {
+	case codes.Unavailable:
+		b.failure()
+	case codes.Canceled, codes.DeadlineExceeded:
+		// The caller gave up, we don't know if the leader is reachable.
+	default:
+		// The leader handled the request.
+		b.success()
+	}
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.backoff = 0
+	b.openUntil = time.Time{}
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	now := b.clock.Now()
+	switch {
+	case !b.openUntil.IsZero():
+		// A probe failed, back off further.
+		b.backoff = min(b.backoff*2, b.opts.MaxBackoff)
+		b.openUntil = now.Add(b.backoff)
+	case b.failures >= b.opts.FailureThreshold:
+		b.backoff = b.opts.InitialBackoff
+		b.openUntil = now.Add(b.backoff)
+	}
+}
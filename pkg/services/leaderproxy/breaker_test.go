@@ -0,0 +1,151 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderproxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/clock"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestLeaderBreaker(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// Start a fake leader that is initially unreachable.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	v1.RegisterMeshServer(srv, &testLeader{})
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	dialer := &testDialer{addr: lis.Addr().String()}
+	clk := clock.NewFake(time.Now())
+	proxy := NewWithBreaker("follower", nil, dialer, nil, BreakerOptions{
+		FailureThreshold: 2,
+		InitialBackoff:   time.Second,
+		MaxBackoff:       4 * time.Second,
+		Clock:            clk,
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: v1.Mesh_GetNode_FullMethodName}
+	call := func() error {
+		_, err := proxy.proxyUnaryToLeader(ctx, &v1.GetNodeRequest{Id: "leader"}, info, nil)
+		return err
+	}
+	expectDials := func(want int64) {
+		t.Helper()
+		if got := dialer.dials.Load(); got != want {
+			t.Fatalf("expected %d leader dials, got %d", want, got)
+		}
+	}
+	expectFastFail := func() {
+		t.Helper()
+		err := call()
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected unavailable error, got %v", err)
+		}
+	}
+
+	// The breaker should open after the failure threshold is reached.
+	for i := 0; i < 2; i++ {
+		if err := call(); err == nil {
+			t.Fatal("expected error dialing unreachable leader")
+		}
+	}
+	expectDials(2)
+	// Requests should now fail fast without dialing the leader.
+	for i := 0; i < 5; i++ {
+		expectFastFail()
+	}
+	expectDials(2)
+
+	// After the backoff a single probe is let through.
+	clk.Advance(time.Second)
+	if err := call(); err == nil {
+		t.Fatal("expected error dialing unreachable leader")
+	}
+	expectDials(3)
+	expectFastFail()
+	expectDials(3)
+
+	// The failed probe should double the backoff.
+	clk.Advance(time.Second)
+	expectFastFail()
+	expectDials(3)
+
+	// Bring the leader back, the next probe should close the breaker.
+	dialer.setUp(true)
+	clk.Advance(time.Second)
+	for i := 0; i < 3; i++ {
+		if err := call(); err != nil {
+			t.Fatalf("expected leader to recover, got %v", err)
+		}
+	}
+	expectDials(6)
+}
+
+type testLeader struct {
+	v1.UnimplementedMeshServer
+}
+
+func (*testLeader) GetNode(_ context.Context, req *v1.GetNodeRequest) (*v1.MeshNode, error) {
+	return &v1.MeshNode{Id: req.GetId()}, nil
+}
+
+type testDialer struct {
+	addr  string
+	up    bool
+	dials atomic.Int64
+	mu    sync.Mutex
+}
+
+func (d *testDialer) setUp(up bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.up = up
+}
+
+func (d *testDialer) DialLeader(ctx context.Context) (transport.RPCClientConn, error) {
+	d.dials.Add(1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.up {
+		return nil, errors.New("leader is down")
+	}
+	return grpc.DialContext(ctx, d.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+func (d *testDialer) DialNode(ctx context.Context, _ types.NodeID) (transport.RPCClientConn, error) {
+	return d.DialLeader(ctx)
+}
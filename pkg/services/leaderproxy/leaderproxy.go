@@ -20,6 +20,7 @@ package leaderproxy
 import (
 	"io"
 	"log/slog"
+	"strings"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc"
@@ -30,6 +31,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -40,6 +42,37 @@ type Interceptor struct {
 	consensus storage.Consensus
 	dialer    Dialer
 	network   context.Network
+	breaker   *breaker
+	methods   map[string]Method
+	forward   []string
+}
+
+// Options are options for the leader proxy interceptor.
+type Options struct {
+	// Breaker are the options for the circuit breaker around leader dials.
+	Breaker BreakerOptions
+	// Methods are additional methods the interceptor knows how to route.
+	// They take precedence over the MethodPolicyMap.
+	Methods map[string]Method
+	// ForwardMeta are incoming metadata keys that are passed on to the
+	// leader when a request is proxied.
+	ForwardMeta []string
+}
+
+// Method describes how to route a method that is not part of the v1 API.
+type Method struct {
+	// Policy is the policy for routing the method.
+	Policy MethodPolicy
+	// NewRequest returns an empty request for the method. It is required
+	// for streaming methods that are proxied to the leader.
+	NewRequest func() any
+	// NewResponse returns an empty response for the method. It is required
+	// for methods that are proxied to the leader.
+	NewResponse func() any
+	// Stream is the description of a streaming method.
+	Stream *grpc.StreamDesc
+	// CallOptions are passed when forwarding the method to the leader.
+	CallOptions []grpc.CallOption
 }
 
 // Dialer is the interface required for the leader proxy interceptor.
@@ -50,14 +83,37 @@ type Dialer interface {
 
 // New returns a new leader proxy interceptor.
 func New(nodeID types.NodeID, consensus storage.Consensus, dialer Dialer, network context.Network) *Interceptor {
+	return NewWithBreaker(nodeID, consensus, dialer, network, DefaultBreakerOptions())
+}
+
+// NewWithBreaker returns a new leader proxy interceptor using the given options
+// for the circuit breaker around leader dials.
+func NewWithBreaker(nodeID types.NodeID, consensus storage.Consensus, dialer Dialer, network context.Network, opts BreakerOptions) *Interceptor {
+	return NewWithOptions(nodeID, consensus, dialer, network, Options{Breaker: opts})
+}
+
+// NewWithOptions returns a new leader proxy interceptor with the given options.
+func NewWithOptions(nodeID types.NodeID, consensus storage.Consensus, dialer Dialer, network context.Network, opts Options) *Interceptor {
 	return &Interceptor{
 		nodeID:    nodeID,
 		consensus: consensus,
 		dialer:    dialer,
 		network:   network,
+		breaker:   newBreaker(opts.Breaker),
+		methods:   opts.Methods,
+		forward:   opts.ForwardMeta,
 	}
 }
 
+// policyFor returns the policy for the given method.
+func (i *Interceptor) policyFor(method string) (MethodPolicy, bool) {
+	if m, ok := i.methods[method]; ok {
+		return m.Policy, true
+	}
+	policy, ok := MethodPolicyMap[method]
+	return policy, ok
+}
+
 // UnaryInterceptor returns a gRPC unary interceptor that proxies requests to the leader node.
 func (i *Interceptor) UnaryInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
@@ -75,7 +131,7 @@ func (i *Interceptor) UnaryInterceptor() grpc.UnaryServerInterceptor {
 				return nil, status.Errorf(codes.PermissionDenied, "request is not in-network")
 			}
 		}
-		policy, ok := MethodPolicyMap[info.FullMethod]
+		policy, ok := i.policyFor(info.FullMethod)
 		if ok {
 			switch policy {
 			case RequireLocal:
@@ -109,7 +165,7 @@ func (i *Interceptor) StreamInterceptor() grpc.StreamServerInterceptor {
 				return status.Errorf(codes.PermissionDenied, "request is not in-network")
 			}
 		}
-		policy, ok := MethodPolicyMap[info.FullMethod]
+		policy, ok := i.policyFor(info.FullMethod)
 		if ok {
 			switch policy {
 			case RequireLocal:
@@ -128,7 +184,49 @@ func (i *Interceptor) StreamInterceptor() grpc.StreamServerInterceptor {
 	}
 }
 
+// appendForwardedMeta copies the incoming values of the forwarded metadata
+// keys to the outgoing context.
+func (i *Interceptor) appendForwardedMeta(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	for _, key := range i.forward {
+		for _, val := range md.Get(key) {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, val)
+		}
+	}
+	return ctx
+}
+
+// forwardHeader passes any webmesh headers returned by the leader back
+// to the original caller.
+func forwardHeader(ctx context.Context, header metadata.MD) {
+	md := metadata.MD{}
+	for key, vals := range header {
+		if strings.HasPrefix(key, "x-webmesh-") {
+			md.Append(key, vals...)
+		}
+	}
+	if len(md) == 0 {
+		return
+	}
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		context.LoggerFrom(ctx).Debug("Failed to forward leader header", slog.String("error", err.Error()))
+	}
+}
+
 func (i *Interceptor) proxyUnaryToLeader(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := i.breaker.allow(); err != nil {
+		context.LoggerFrom(ctx).Debug("Leader circuit breaker is open", slog.String("method", info.FullMethod))
+		return nil, err
+	}
+	resp, err := i.forwardUnaryToLeader(ctx, req, info)
+	i.breaker.record(err)
+	return resp, err
+}
+
+func (i *Interceptor) forwardUnaryToLeader(ctx context.Context, req any, info *grpc.UnaryServerInfo) (any, error) {
 	conn, err := i.dialer.DialLeader(ctx)
 	if err != nil {
 		return nil, err
@@ -138,10 +236,18 @@ func (i *Interceptor) proxyUnaryToLeader(ctx context.Context, req any, info *grp
 	if peer, ok := context.AuthenticatedCallerFrom(ctx); ok {
 		ctx = metadata.AppendToOutgoingContext(ctx, ProxiedForMeta, peer)
 	}
+	ctx = context.AppendRequestIDToOutgoingContext(ctx)
+	if token, ok := jointokens.FromContext(ctx); ok {
+		ctx = jointokens.AppendToOutgoingContext(ctx, token)
+	}
+	ctx = i.appendForwardedMeta(ctx)
 	switch info.FullMethod {
 	// Membership API
 	case v1.Membership_Join_FullMethodName:
-		return v1.NewMembershipClient(conn).Join(ctx, req.(*v1.JoinRequest))
+		var header metadata.MD
+		resp, err := v1.NewMembershipClient(conn).Join(ctx, req.(*v1.JoinRequest), grpc.Header(&header))
+		forwardHeader(ctx, header)
+		return resp, err
 	case v1.Membership_Update_FullMethodName:
 		return v1.NewMembershipClient(conn).Update(ctx, req.(*v1.UpdateRequest))
 	case v1.Membership_Leave_FullMethodName:
@@ -225,11 +331,29 @@ func (i *Interceptor) proxyUnaryToLeader(ctx context.Context, req any, info *grp
 		return v1.NewAdminClient(conn).ListEdges(ctx, req.(*emptypb.Empty))
 
 	default:
-		return nil, status.Errorf(codes.Unimplemented, "unimplemented leader-proxy method: %s", info.FullMethod)
+		m, ok := i.methods[info.FullMethod]
+		if !ok || m.NewResponse == nil {
+			return nil, status.Errorf(codes.Unimplemented, "unimplemented leader-proxy method: %s", info.FullMethod)
+		}
+		resp := m.NewResponse()
+		if err := conn.Invoke(ctx, info.FullMethod, req, resp, m.CallOptions...); err != nil {
+			return nil, err
+		}
+		return resp, nil
 	}
 }
 
 func (i *Interceptor) proxyStreamToLeader(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := i.breaker.allow(); err != nil {
+		context.LoggerFrom(ss.Context()).Debug("Leader circuit breaker is open", slog.String("method", info.FullMethod))
+		return err
+	}
+	err := i.forwardStreamToLeader(ss, info)
+	i.breaker.record(err)
+	return err
+}
+
+func (i *Interceptor) forwardStreamToLeader(ss grpc.ServerStream, info *grpc.StreamServerInfo) error {
 	conn, err := i.dialer.DialLeader(ss.Context())
 	if err != nil {
 		return err
@@ -239,6 +363,8 @@ func (i *Interceptor) proxyStreamToLeader(srv any, ss grpc.ServerStream, info *g
 	if peer, ok := context.AuthenticatedCallerFrom(ctx); ok {
 		ctx = metadata.AppendToOutgoingContext(ctx, ProxiedForMeta, peer)
 	}
+	ctx = context.AppendRequestIDToOutgoingContext(ctx)
+	ctx = i.appendForwardedMeta(ctx)
 	switch info.FullMethod {
 
 	// Node API
@@ -286,8 +412,59 @@ func (i *Interceptor) proxyStreamToLeader(srv any, ss grpc.ServerStream, info *g
 		return proxyStream[v1.SubscribeRequest, v1.SubscriptionEvent](ctx, ss, stream)
 
 	default:
-		return status.Errorf(codes.Unimplemented, "unimplemented leader-proxy method: %s", info.FullMethod)
+		m, ok := i.methods[info.FullMethod]
+		if !ok || m.Stream == nil || m.NewRequest == nil || m.NewResponse == nil {
+			return status.Errorf(codes.Unimplemented, "unimplemented leader-proxy method: %s", info.FullMethod)
+		}
+		stream, err := conn.NewStream(ctx, m.Stream, info.FullMethod, m.CallOptions...)
+		if err != nil {
+			return err
+		}
+		return proxyMethodStream(ss, stream, m)
+	}
+}
+
+// proxyMethodStream proxies a stream described by a Method. Unlike proxyStream
+// it waits for the leader to finish the stream before returning, so the
+// response of a client stream reaches the caller.
+func proxyMethodStream(ss grpc.ServerStream, cs grpc.ClientStream, m Method) error {
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			msg := m.NewResponse()
+			if err := cs.RecvMsg(msg); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errs <- err
+				return
+			}
+			if err := ss.SendMsg(msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for {
+		msg := m.NewRequest()
+		if err := ss.RecvMsg(msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if err := cs.SendMsg(msg); err != nil {
+			if err == io.EOF {
+				// The leader ended the stream, its status is returned by RecvMsg.
+				break
+			}
+			return err
+		}
+	}
+	if err := cs.CloseSend(); err != nil {
+		return err
 	}
+	return <-errs
 }
 
 func proxyStream[S, R any](ctx context.Context, ss grpc.ServerStream, cs grpc.ClientStream) error {
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// rebindableListener is a net.Listener that can be moved to a different set
+// of addresses while a server is accepting on it.
+type rebindableListener struct {
+	conns     chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+	lis       []net.Listener
+	addr      net.Addr
+	mu        sync.Mutex
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newRebindableListener(lis net.Listener) *rebindableListener {
+	l := &rebindableListener{
+		conns: make(chan acceptResult),
+		done:  make(chan struct{}),
+	}
+	l.serve([]net.Listener{lis})
+	return l
+}
+
+// Accept waits for and returns the next connection on any of the
+// current listeners.
+func (l *rebindableListener) Accept() (net.Conn, error) {
+	select {
+	case res := <-l.conns:
+		return res.conn, res.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Addr returns the address of the first current listener.
+func (l *rebindableListener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.addr
+}
+
+// Close closes all current listeners.
+func (l *rebindableListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, lis := range l.lis {
+			err = errors.Join(err, lis.Close())
+		}
+		l.lis = nil
+	})
+	return err
+}
+
+// Rebind closes the current listeners and starts listening on the given
+// addresses. Connections already accepted are unaffected. If any address
+// fails to bind, the previous addresses are restored.
+func (l *rebindableListener) Rebind(addrs []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.done:
+		return net.ErrClosed
+	default:
+	}
+	// The old listeners are closed first, since they may be bound to
+	// a wildcard address on the same port.
+	var previous []string
+	for _, lis := range l.lis {
+		previous = append(previous, lis.Addr().String())
+		_ = lis.Close()
+	}
+	listeners, err := listenAll(addrs)
+	if err != nil {
+		restored, rerr := listenAll(previous)
+		if rerr != nil {
+			l.lis = nil
+			return fmt.Errorf("%w, restore previous listeners: %v", err, rerr)
+		}
+		l.serve(restored)
+		return err
+	}
+	l.serve(listeners)
+	return nil
+}
+
+// serve starts accepting on the given listeners. The lock must be held
+// if the listener is in use.
+func (l *rebindableListener) serve(listeners []net.Listener) {
+	l.lis = listeners
+	if len(listeners) > 0 {
+		l.addr = listeners[0].Addr()
+	}
+	for _, lis := range listeners {
+		go l.acceptLoop(lis)
+	}
+}
+
+func (l *rebindableListener) acceptLoop(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				// The listener was closed or replaced.
+				return
+			}
+			select {
+			case l.conns <- acceptResult{err: err}:
+			case <-l.done:
+			}
+			return
+		}
+		select {
+		case l.conns <- acceptResult{conn: conn}:
+		case <-l.done:
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+func listenAll(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
@@ -26,12 +26,41 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
 )
 
 func (s *Server) Apply(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApplyResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	provider, err := s.checkApplyPeer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return provider.ApplyRaftLog(ctx, log)
+}
+
+// ApplyBatch applies a batch forwarded by another voter as a single raft log.
+func (s *Server) ApplyBatch(ctx context.Context, req *wrapperspb.BytesValue) (*v1.RaftApplyResponse, error) {
+	batch, err := fsm.UnmarshalLogBatch(req.GetValue())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid log batch: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	provider, err := s.checkApplyPeer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return provider.ApplyRaftLogBatch(ctx, batch)
+}
+
+// checkApplyPeer checks that we are the leader and that the caller is an
+// in-network voter allowed to apply logs. It must be called with s.mu held.
+func (s *Server) checkApplyPeer(ctx context.Context) (*raftstorage.Provider, error) {
 	// Make sure the request is coming from in-network
 	if !context.IsInNetwork(ctx, s.meshnet) {
 		addr, _ := context.PeerAddrFrom(ctx)
@@ -45,8 +74,6 @@ func (s *Server) Apply(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApply
 	if !provider.Consensus().IsLeader() {
 		return nil, status.Errorf(codes.FailedPrecondition, "not leader")
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
 	peer, ok := peer.FromContext(ctx)
 	if !ok {
 		return nil, status.Errorf(codes.FailedPrecondition, "no peer")
@@ -68,5 +95,5 @@ func (s *Server) Apply(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApply
 	if !found {
 		return nil, status.Errorf(codes.FailedPrecondition, "peer not found in configuration")
 	}
-	return provider.ApplyRaftLog(ctx, log)
+	return provider, nil
 }
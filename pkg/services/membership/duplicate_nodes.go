@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultDuplicateNodeWindow is the default window after a node joins in which
+// a join for the same ID with a different public key from a different source
+// is rejected.
+const DefaultDuplicateNodeWindow = time.Minute
+
+// joinSource returns where a join request came from. This is the address of
+// the caller as seen by the transport, since anything in the request can be
+// chosen by the caller. Joins proxied by another node are attributed to the
+// proxying node, as the address of the original caller is not passed on.
+// An empty string means the source is unknown.
+func joinSource(ctx context.Context) string {
+	if proxiedFrom, ok := leaderproxy.ProxiedFrom(ctx); ok {
+		return "node/" + proxiedFrom
+	}
+	if addr, ok := context.PeerAddrFrom(ctx); ok {
+		return addr.String()
+	}
+	return ""
+}
+
+// checkDuplicateNode rejects a join for a node ID that recently joined with a
+// different public key from a different source. This is almost always two
+// machines configured with the same ID, which would otherwise overwrite each
+// other's keys on every join. A node rotating its key from the same source is
+// allowed, as is any join once the window has passed or the node has left.
+// Joins are recorded in storage so that the check holds across leader changes.
+// The caller must hold s.mu.
+func (s *Server) checkDuplicateNode(ctx context.Context, req *v1.JoinRequest) error {
+	if s.duplicateWindow <= 0 {
+		return nil
+	}
+	last, ok, err := storage.GetRecentJoin(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get recent join: %v", err)
+	}
+	if !ok || last.PublicKey == req.GetPublicKey() || time.Since(last.JoinedAt) > s.duplicateWindow {
+		return nil
+	}
+	source := joinSource(ctx)
+	if source == "" || last.Source == "" || source == last.Source {
+		return nil
+	}
+	context.LoggerFrom(ctx).Warn("Rejecting join for node ID recently joined with a different public key",
+		"source", source, "previous-source", last.Source)
+	return status.Errorf(codes.AlreadyExists,
+		"node id %s joined from %s with a different public key %s ago, refusing to replace it from %s",
+		req.GetId(), last.Source, time.Since(last.JoinedAt).Round(time.Second), source)
+}
+
+// recordJoinInBatch queues recording a join for duplicate node detection in
+// the batch writing the node. The record expires once it leaves the window.
+func (s *Server) recordJoinInBatch(ctx context.Context, batch storage.Batch, req *v1.JoinRequest) error {
+	if s.duplicateWindow <= 0 {
+		return nil
+	}
+	return storage.PutRecentJoinInBatch(batch, types.RecentJoin{
+		ID:        types.NodeID(req.GetId()),
+		PublicKey: req.GetPublicKey(),
+		Source:    joinSource(ctx),
+		JoinedAt:  time.Now().UTC(),
+	}, s.duplicateWindow)
+}
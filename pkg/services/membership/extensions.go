@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+)
+
+// ExtensionsServer is the server API for the membership extensions service.
+// It carries the internal membership operations that are not part of the
+// v1.Membership API.
+type ExtensionsServer interface {
+	ApplyBatch(context.Context, *wrapperspb.BytesValue) (*v1.RaftApplyResponse, error)
+}
+
+// Extensions_ServiceDesc is the grpc.ServiceDesc for the membership extensions
+// service.
+var Extensions_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "v1.MembershipExtensions",
+	HandlerType: (*ExtensionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ApplyBatch",
+			Handler:    applyBatchHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pkg/services/membership/extensions.go",
+}
+
+// RegisterExtensionsServer registers the membership extensions service.
+func RegisterExtensionsServer(s grpc.ServiceRegistrar, srv ExtensionsServer) {
+	s.RegisterService(&Extensions_ServiceDesc, srv)
+}
+
+// ExtensionMethods returns how the leader proxy routes each method of the
+// membership extensions service. Batches are sent straight to the leader by
+// the voter committing them, so they are never proxied.
+func ExtensionMethods() map[string]leaderproxy.Method {
+	return map[string]leaderproxy.Method{
+		raftstorage.MembershipExtensions_ApplyBatch_FullMethodName: {Policy: leaderproxy.RequireLocal},
+	}
+}
+
+func applyBatchHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtensionsServer).ApplyBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: raftstorage.MembershipExtensions_ApplyBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(ExtensionsServer).ApplyBatch(ctx, req.(*wrapperspb.BytesValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	// ErrMissingRequiredFeature is returned when a joining node does not
+	// advertise a feature required by the cluster.
+	ErrMissingRequiredFeature = errors.New("missing required feature")
+	// ErrUnsupportedFeature is returned when a joining node advertises a
+	// feature the cluster does not support and strict negotiation is enabled.
+	ErrUnsupportedFeature = errors.New("unsupported feature")
+)
+
+// FeatureNegotiation is the result of negotiating the features of a joining node.
+type FeatureNegotiation struct {
+	// Accepted are the features that will be stored for the node.
+	Accepted []*v1.FeaturePort
+	// Unsupported are the requested features that were dropped.
+	Unsupported []v1.Feature
+}
+
+// Degraded returns true if any requested features were dropped.
+func (f *FeatureNegotiation) Degraded() bool {
+	return len(f.Unsupported) > 0
+}
+
+// Header returns the gRPC header metadata describing the negotiation.
+func (f *FeatureNegotiation) Header() metadata.MD {
+	md := metadata.MD{}
+	for _, feat := range f.Accepted {
+		md.Append(types.FeaturesHeader, feat.GetFeature().String())
+	}
+	for _, feat := range f.Unsupported {
+		md.Append(types.UnsupportedFeaturesHeader, feat.String())
+	}
+	return md
+}
+
+// NegotiateFeatures negotiates the features requested by a joining node
+// against the features supported and required by the cluster. An empty
+// supported list means every known feature is supported. Unsupported
+// features are dropped from the result unless strict is true, in which
+// case they are an error. Required features missing from the request are
+// always an error.
+func NegotiateFeatures(requested []*v1.FeaturePort, supported, required []v1.Feature, strict bool) (*FeatureNegotiation, error) {
+	var res FeatureNegotiation
+	seen := make(map[v1.Feature]struct{}, len(requested))
+	for _, feat := range requested {
+		f := feat.GetFeature()
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		if !IsSupportedFeature(f, supported) {
+			if strict {
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedFeature, f)
+			}
+			res.Unsupported = append(res.Unsupported, f)
+			continue
+		}
+		res.Accepted = append(res.Accepted, feat)
+	}
+	var missing []string
+	for _, f := range required {
+		if !slices.ContainsFunc(res.Accepted, func(feat *v1.FeaturePort) bool { return feat.GetFeature() == f }) {
+			missing = append(missing, f.String())
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMissingRequiredFeature, strings.Join(missing, ", "))
+	}
+	return &res, nil
+}
+
+// IsSupportedFeature returns true if the feature is a known feature contained
+// in supported. An empty supported list matches every known feature.
+func IsSupportedFeature(f v1.Feature, supported []v1.Feature) bool {
+	if _, ok := v1.Feature_name[int32(f)]; !ok || f == v1.Feature_FEATURE_NONE {
+		return false
+	}
+	return len(supported) == 0 || slices.Contains(supported, f)
+}
+
+// sendFeatureHeader returns the result of a negotiation to the caller.
+func sendFeatureHeader(ctx context.Context, res *FeatureNegotiation) {
+	if err := grpc.SetHeader(ctx, res.Header()); err != nil {
+		// This happens when the server is invoked outside of a gRPC stream.
+		context.LoggerFrom(ctx).Debug("Could not send feature header", slog.String("error", err.Error()))
+	}
+}
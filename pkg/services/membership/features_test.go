@@ -0,0 +1,208 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNegotiateFeatures(t *testing.T) {
+	t.Parallel()
+
+	ports := func(features ...v1.Feature) []*v1.FeaturePort {
+		out := make([]*v1.FeaturePort, len(features))
+		for i, f := range features {
+			out[i] = &v1.FeaturePort{Feature: f, Port: int32(8000 + i)}
+		}
+		return out
+	}
+	tc := []struct {
+		name            string
+		requested       []*v1.FeaturePort
+		supported       []v1.Feature
+		required        []v1.Feature
+		strict          bool
+		wantAccepted    []v1.Feature
+		wantUnsupported []v1.Feature
+		wantErr         error
+	}{
+		{
+			name:         "Compatible",
+			requested:    ports(v1.Feature_NODES, v1.Feature_MESH_DNS),
+			supported:    []v1.Feature{v1.Feature_NODES, v1.Feature_MESH_DNS, v1.Feature_METRICS},
+			required:     []v1.Feature{v1.Feature_NODES},
+			wantAccepted: []v1.Feature{v1.Feature_NODES, v1.Feature_MESH_DNS},
+		},
+		{
+			name:         "AllKnownSupportedByDefault",
+			requested:    ports(v1.Feature_NODES, v1.Feature_TURN_SERVER, v1.Feature_NODES),
+			wantAccepted: []v1.Feature{v1.Feature_NODES, v1.Feature_TURN_SERVER},
+		},
+		{
+			name:            "Degraded",
+			requested:       ports(v1.Feature_NODES, v1.Feature_TURN_SERVER, v1.Feature(1000)),
+			supported:       []v1.Feature{v1.Feature_NODES},
+			wantAccepted:    []v1.Feature{v1.Feature_NODES},
+			wantUnsupported: []v1.Feature{v1.Feature_TURN_SERVER, v1.Feature(1000)},
+		},
+		{
+			name:      "MissingRequired",
+			requested: ports(v1.Feature_NODES),
+			required:  []v1.Feature{v1.Feature_NODES, v1.Feature_MESH_DNS},
+			wantErr:   ErrMissingRequiredFeature,
+		},
+		{
+			name:      "RequiredButUnsupported",
+			requested: ports(v1.Feature_NODES, v1.Feature_MESH_DNS),
+			supported: []v1.Feature{v1.Feature_NODES},
+			required:  []v1.Feature{v1.Feature_MESH_DNS},
+			wantErr:   ErrMissingRequiredFeature,
+		},
+		{
+			name:      "StrictUnsupported",
+			requested: ports(v1.Feature_NODES, v1.Feature_TURN_SERVER),
+			supported: []v1.Feature{v1.Feature_NODES},
+			strict:    true,
+			wantErr:   ErrUnsupportedFeature,
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			res, err := NegotiateFeatures(tt.requested, tt.supported, tt.required, tt.strict)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("negotiate features: %v", err)
+			}
+			var accepted []v1.Feature
+			for _, feat := range res.Accepted {
+				accepted = append(accepted, feat.GetFeature())
+			}
+			if !slices.Equal(accepted, tt.wantAccepted) {
+				t.Errorf("expected accepted features %v, got %v", tt.wantAccepted, accepted)
+			}
+			if !slices.Equal(res.Unsupported, tt.wantUnsupported) {
+				t.Errorf("expected unsupported features %v, got %v", tt.wantUnsupported, res.Unsupported)
+			}
+			if res.Degraded() != (len(tt.wantUnsupported) > 0) {
+				t.Errorf("expected degraded to be %v", len(tt.wantUnsupported) > 0)
+			}
+			// The header should round trip the known features.
+			gotAccepted, gotUnsupported := types.FeaturesFromHeader(res.Header())
+			if !slices.Equal(gotAccepted, tt.wantAccepted) {
+				t.Errorf("expected header features %v, got %v", tt.wantAccepted, gotAccepted)
+			}
+			for _, f := range gotUnsupported {
+				if !slices.Contains(tt.wantUnsupported, f) {
+					t.Errorf("unexpected unsupported feature in header: %v", f)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinFeatureNegotiation(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:            node.ID(),
+		Storage:           node.Storage(),
+		Plugins:           node.Plugins(),
+		RBAC:              rbac.NewNoopEvaluator(),
+		Meshnet:           node.Network(),
+		SupportedFeatures: []v1.Feature{v1.Feature_NODES, v1.Feature_MESH_DNS},
+		RequiredFeatures:  []v1.Feature{v1.Feature_NODES},
+	})
+	join := func(id string, features ...v1.Feature) error {
+		t.Helper()
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		req := &v1.JoinRequest{Id: id, PublicKey: encoded}
+		for _, f := range features {
+			req.Features = append(req.Features, &v1.FeaturePort{Feature: f, Port: 8443})
+		}
+		_, err = srv.Join(ctx, req)
+		return err
+	}
+	storedFeatures := func(id string) []v1.Feature {
+		t.Helper()
+		peer, err := node.Storage().MeshDB().Peers().Get(ctx, types.NodeID(id))
+		if err != nil {
+			t.Fatalf("get peer %s: %v", id, err)
+		}
+		var out []v1.Feature
+		for _, feat := range peer.GetFeatures() {
+			out = append(out, feat.GetFeature())
+		}
+		return out
+	}
+
+	t.Run("Compatible", func(t *testing.T) {
+		if err := join("compatible-node", v1.Feature_NODES, v1.Feature_MESH_DNS); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		want := []v1.Feature{v1.Feature_NODES, v1.Feature_MESH_DNS}
+		if got := storedFeatures("compatible-node"); !slices.Equal(got, want) {
+			t.Errorf("expected features %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Degraded", func(t *testing.T) {
+		if err := join("degraded-node", v1.Feature_NODES, v1.Feature_TURN_SERVER); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		want := []v1.Feature{v1.Feature_NODES}
+		if got := storedFeatures("degraded-node"); !slices.Equal(got, want) {
+			t.Errorf("expected unsupported features to be dropped, got %v", got)
+		}
+	})
+
+	t.Run("Incompatible", func(t *testing.T) {
+		err := join("incompatible-node", v1.Feature_MESH_DNS)
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got %v", err)
+		}
+		if _, err := node.Storage().MeshDB().Peers().Get(ctx, "incompatible-node"); err == nil {
+			t.Error("expected incompatible node not to be registered")
+		}
+	})
+}
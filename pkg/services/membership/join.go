@@ -24,6 +24,8 @@ import (
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -32,11 +34,13 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
 )
 
 var canVoteAction = &rbac.Action{
@@ -60,13 +64,20 @@ var canPutEdgeAction = &rbac.Action{
 }
 
 func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinResponse, error) {
+	ctx, span := tracing.Start(ctx, "membership.Join", attribute.String("node.id", req.GetId()))
+	resp, err := s.join(ctx, req)
+	tracing.End(span, &err)
+	return resp, err
+}
+
+func (s *Server) join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinResponse, error) {
 	if !s.storage.Consensus().IsLeader() {
 		return nil, status.Errorf(codes.FailedPrecondition, "not leader")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	log := s.log.With("op", "join", "id", req.GetId())
-	ctx = context.WithLogger(ctx, log)
+	ctx = context.WithLogger(ctx, s.log.With("op", "join", "id", req.GetId()))
+	log := context.LoggerFrom(ctx)
 
 	log.Info("Join request received", slog.Any("request", req))
 	// Check if we haven't loaded the mesh domain and prefixes into memory yet
@@ -76,37 +87,56 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	}
 
 	// Validate inputs
-	if req.GetId() == "" {
-		return nil, status.Error(codes.InvalidArgument, "node id required")
-	} else if !types.IsValidNodeID(req.GetId()) {
-		return nil, status.Error(codes.InvalidArgument, "node id is invalid")
+	if err := s.validateJoinRequest(req); err != nil {
+		return nil, err
 	}
 
-	if s.plugins.HasAuth() {
+	// A join token authenticates the caller as the node it was issued for.
+	var joinToken *jointokens.JoinToken
+	if token, ok := jointokens.FromContext(ctx); ok {
+		joinToken, err = s.tokens.Verify(ctx, token)
+		if err != nil {
+			if jointokens.IsInvalidToken(err) {
+				return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to verify join token: %v", err)
+		}
+		if joinToken.NodeID != req.GetId() {
+			return nil, status.Errorf(codes.PermissionDenied, "join token was not issued for node id %s", req.GetId())
+		}
+		ctx = context.WithAuthenticatedCaller(ctx, req.GetId())
+	} else if s.plugins != nil && s.plugins.HasAuth() {
 		if !nodeIDMatchesContext(ctx, req.GetId()) {
 			return nil, status.Errorf(codes.PermissionDenied, "node id %s does not match authenticated caller", req.GetId())
 		}
 	}
 
-	if len(req.GetRoutes()) > 0 {
-		for _, route := range req.GetRoutes() {
-			route, err := netip.ParsePrefix(route)
-			if err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, "invalid route %q: %v", route, err)
-			}
-			// Make sure the route does not overlap with a mesh reserved prefix
-			if route.Contains(s.ipv4Prefix.Addr()) || route.Contains(s.ipv6Prefix.Addr()) {
-				return nil, status.Errorf(codes.InvalidArgument, "route %q overlaps with mesh prefix", route)
-			}
-		}
-	}
 	publicKey, err := crypto.DecodePublicKey(req.GetPublicKey())
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
 	}
+	if err := s.checkKeyBoundID(req.GetId(), publicKey); err != nil {
+		return nil, err
+	}
+	if err := s.checkDuplicateNode(ctx, req); err != nil {
+		return nil, err
+	}
+	// A pre-registered node must match its registration.
+	registration, registered, err := s.checkNodeRegistration(ctx, req, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	negotiated, err := NegotiateFeatures(req.GetFeatures(), s.features.supported, s.features.required, s.features.strict)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "feature negotiation failed: %v", err)
+	}
+	if negotiated.Degraded() {
+		log.Warn("Dropping unsupported features from join request", slog.Any("features", negotiated.Unsupported))
+	}
+	features := negotiated.Accepted
 	var storagePort int32
 	if req.GetAsVoter() || req.GetAsObserver() {
-		for _, feat := range req.GetFeatures() {
+		for _, feat := range features {
 			if feat.Feature == v1.Feature_STORAGE_PROVIDER {
 				storagePort = feat.Port
 				break
@@ -117,6 +147,45 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		}
 	}
 
+	// Start building a list of clean up functions to run if we fail
+	cleanFuncs := make([]func(), 0)
+	handleErr := func(cause error) error {
+		for _, f := range cleanFuncs {
+			f()
+		}
+		return cause
+	}
+
+	if joinToken != nil {
+		// Consume the token and bind its roles before evaluating what the
+		// node is allowed to do.
+		_, err = s.tokens.Consume(ctx, joinToken.Token)
+		if err != nil {
+			if jointokens.IsInvalidToken(err) {
+				return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to consume join token: %v", err)
+		}
+		cleanFuncs = append(cleanFuncs, func() {
+			err := s.tokens.Release(ctx, joinToken)
+			if err != nil {
+				log.Warn("Failed to release join token", slog.String("error", err.Error()))
+			}
+		})
+		bound, err := s.bindNodeRoles(ctx, req.GetId(), joinToken.Roles, jointokens.RoleBindingName)
+		cleanFuncs = append(cleanFuncs, bound...)
+		if err != nil {
+			return nil, handleErr(status.Errorf(codes.Internal, "failed to bind join token roles: %v", err))
+		}
+	}
+	if registered {
+		bound, err := s.bindNodeRoles(ctx, req.GetId(), registration.Roles, NodeRegistrationRoleBindingName)
+		cleanFuncs = append(cleanFuncs, bound...)
+		if err != nil {
+			return nil, handleErr(status.Errorf(codes.Internal, "failed to bind node registration roles: %v", err))
+		}
+	}
+
 	// We can go ahead and check here if the node is allowed to do what
 	// they want.
 	var actions rbac.Actions
@@ -139,29 +208,24 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	if len(actions) > 0 {
 		allowed, err := s.rbac.Evaluate(ctx, actions)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to evaluate permissions: %v", err)
+			return nil, handleErr(status.Errorf(codes.Internal, "failed to evaluate permissions: %v", err))
 		}
 		if !allowed {
 			s.log.Warn("Node not allowed to perform requested actions",
 				slog.String("id", req.GetId()),
 				slog.Any("actions", actions))
-			return nil, status.Error(codes.PermissionDenied, "not allowed")
-		}
-	}
-
-	// Start building a list of clean up functions to run if we fail
-	cleanFuncs := make([]func(), 0)
-	handleErr := func(cause error) error {
-		for _, f := range cleanFuncs {
-			f()
+			return nil, handleErr(status.Error(codes.PermissionDenied, "not allowed"))
 		}
-		return cause
 	}
 
 	// Handle any new routes
-	if len(req.GetRoutes()) > 0 {
-		created, err := s.ensurePeerRoutes(ctx, types.NodeID(req.GetId()), req.GetRoutes())
+	routes, exitRoutes := splitExitRoutes(req.GetRoutes())
+	if len(routes) > 0 {
+		created, err := s.ensurePeerRoutes(ctx, types.NodeID(req.GetId()), routes)
 		if err != nil {
+			if errors.IsRouteLimitExceeded(err) {
+				return nil, handleErr(status.Error(codes.ResourceExhausted, err.Error()))
+			}
 			return nil, handleErr(status.Errorf(codes.Internal, "failed to ensure peer routes: %v", err))
 		} else if created {
 			cleanFuncs = append(cleanFuncs, func() {
@@ -172,6 +236,22 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 			})
 		}
 	}
+	if len(exitRoutes) > 0 {
+		created, err := s.ensureExitRoute(ctx, types.NodeID(req.GetId()), exitRoutes)
+		if err != nil {
+			if errors.IsRouteLimitExceeded(err) {
+				return nil, handleErr(status.Error(codes.ResourceExhausted, err.Error()))
+			}
+			return nil, handleErr(status.Errorf(codes.Internal, "failed to ensure exit route: %v", err))
+		} else if created {
+			cleanFuncs = append(cleanFuncs, func() {
+				err := s.storage.MeshDB().Networking().DeleteRoute(ctx, types.ExitRouteName(types.NodeID(req.GetId())))
+				if err != nil {
+					log.Warn("Failed to delete exit route", slog.String("error", err.Error()))
+				}
+			})
+		}
+	}
 
 	var leasev4, leasev6 netip.Prefix
 	// We always generate an IPv6 address for the peer from their public key
@@ -179,6 +259,9 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	log.Debug("Assigned IPv6 address to peer", slog.String("ipv6", leasev6.String()))
 	// Acquire an IPv4 address for the peer only if requested
 	if req.GetAssignIPv4() {
+		if s.plugins == nil {
+			return nil, handleErr(status.Errorf(codes.Unavailable, "no IPAM plugin is available to allocate an IPv4 address"))
+		}
 		log.Debug("Assigning IPv4 address to peer")
 		leasev4, err = s.plugins.AllocateIP(ctx, &v1.AllocateIPRequest{
 			NodeID: req.GetId(),
@@ -187,31 +270,45 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		if err != nil {
 			return nil, handleErr(status.Errorf(codes.Internal, "failed to allocate IPv4 address: %v", err))
 		}
+		// Pre-registered addresses are reserved with the IPAM plugin when
+		// the node is registered, so the allocation must return it.
+		if registered && registration.PrivateAddrV4().IsValid() && leasev4 != registration.PrivateAddrV4() {
+			return nil, handleErr(status.Errorf(codes.FailedPrecondition,
+				"allocated ipv4 address %s does not match the registered address %s", leasev4, registration.PrivateAddrV4()))
+		}
 		log.Debug("Assigned IPv4 address to peer", slog.String("ipv4", leasev4.String()))
 	}
-	// Write the peer to the database
+	// Queue the peer and all of its edges into a single batch so they
+	// are written to the database atomically.
 	p := s.storage.MeshDB().Peers()
-	err = p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+	batch := s.storage.MeshStorage().Batch()
+	// A new peer is only created if it is still absent when the batch is
+	// committed, so a concurrent join for the same ID cannot overwrite it.
+	putNode := storage.PutNodeInBatch
+	_, err = p.Get(ctx, types.NodeID(req.GetId()))
+	if err != nil {
+		if !errors.IsNodeNotFound(err) {
+			return nil, handleErr(status.Errorf(codes.Internal, "failed to get peer: %v", err))
+		}
+		putNode = storage.PutNodeIfAbsentInBatch
+	}
+	err = putNode(batch, types.MeshNode{MeshNode: &v1.MeshNode{
 		Id:                 req.GetId(),
 		PrimaryEndpoint:    req.GetPrimaryEndpoint(),
 		WireguardEndpoints: req.GetWireguardEndpoints(),
 		ZoneAwarenessID:    req.GetZoneAwarenessID(),
 		PublicKey:          req.GetPublicKey(),
-		PrivateIPv4:        leasev4.String(),
+		PrivateIPv4:        prefixString(leasev4),
 		PrivateIPv6:        leasev6.String(),
-		Features:           req.GetFeatures(),
+		Features:           features,
 		Multiaddrs:         req.GetMultiaddrs(),
 		JoinedAt:           timestamppb.New(time.Now().UTC()),
 	}})
 	if err != nil {
 		return nil, handleErr(status.Errorf(codes.Internal, "failed to persist peer details to storage: %v", err))
 	}
-	cleanFuncs = append(cleanFuncs, func() {
-		err := p.Delete(ctx, types.NodeID(req.GetId()))
-		if err != nil {
-			log.Warn("failed to delete peer", slog.String("error", err.Error()))
-		}
-	})
+	// Preshared keys negotiated for the caller, keyed by peer ID.
+	psks := make(map[types.NodeID]wgtypes.Key)
 	// At this point we want to
 	// Add an edge from the joining server to the caller
 	joiningServer := s.nodeID
@@ -219,17 +316,17 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		joiningServer = types.NodeID(proxiedFrom)
 	}
 	log.Debug("Adding edge between caller and joining server", slog.String("join-edge", joiningServer.String()))
-	err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+	err = s.putJoinEdge(ctx, batch, psks, types.NodeID(req.GetId()), publicKey, &v1.MeshEdge{
 		Source: joiningServer.String(),
 		Target: req.GetId(),
 		Weight: 1,
-	}})
+	})
 	if err != nil {
 		return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
 	}
 	if req.GetPrimaryEndpoint() != "" {
 		// Add an edge between the caller and all other nodes with public endpoints
-		// TODO: This should be done according to network policy and batched
+		// TODO: This should be done according to network policy
 		allPeers, err := p.List(ctx, storage.FilterByIsPublic())
 		if err != nil {
 			return nil, handleErr(status.Errorf(codes.Internal, "failed to list peers: %v", err))
@@ -237,11 +334,11 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		for _, peer := range allPeers {
 			if peer.GetId() != req.GetId() && peer.PrimaryEndpoint != "" {
 				log.Debug("adding edge from public peer to public caller", slog.String("peer", peer.GetId()))
-				err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+				err = s.putJoinEdge(ctx, batch, psks, types.NodeID(req.GetId()), publicKey, &v1.MeshEdge{
 					Source: peer.GetId(),
 					Target: req.GetId(),
 					Weight: 99,
-				}})
+				})
 				if err != nil {
 					return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
 				}
@@ -251,8 +348,8 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	if req.GetZoneAwarenessID() != "" {
 		// Add an edge between the caller and all other nodes in the same zone
 		// with public endpoints.
-		// TODO: Same as above - this should be done according to network policy and batched
-		zonePeers, err := p.List(ctx, storage.FilterByZoneID(req.GetZoneAwarenessID()))
+		// TODO: Same as above - this should be done according to network policy
+		zonePeers, err := p.List(ctx, storage.FilterByAnyZone(types.ParseZones(req.GetZoneAwarenessID())))
 		if err != nil {
 			return nil, handleErr(status.Errorf(codes.Internal, "failed to list peers: %v", err))
 		}
@@ -262,11 +359,11 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 			}
 			log.Debug("Adding edges to peer in the same zone", slog.String("peer", peer.GetId()))
 			if peer.GetId() != req.GetId() {
-				err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+				err = s.putJoinEdge(ctx, batch, psks, types.NodeID(req.GetId()), publicKey, &v1.MeshEdge{
 					Source: peer.GetId(),
 					Target: req.GetId(),
 					Weight: 1,
-				}})
+				})
 				if err != nil {
 					return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
 				}
@@ -278,9 +375,6 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		// Put an edge between the caller and all direct peers
 		for peer, proto := range req.GetDirectPeers() {
 			// Check if the peer exists
-			if !types.IsValidNodeID(peer) {
-				return nil, handleErr(status.Errorf(codes.InvalidArgument, "invalid peer id %q", peer))
-			}
 			_, err := p.Get(ctx, types.NodeID(peer))
 			if err != nil {
 				if !errors.IsNodeNotFound(err) {
@@ -288,26 +382,45 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 				}
 				// The peer doesn't exist, so create a placeholder for it
 				log.Debug("Registering empty peer", slog.String("peer", peer))
-				err = p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: peer}})
+				err = storage.PutNodeIfAbsentInBatch(batch, types.MeshNode{MeshNode: &v1.MeshNode{Id: peer}})
 				if err != nil {
 					return nil, handleErr(status.Errorf(codes.Internal, "failed to register peer: %v", err))
 				}
 			}
 			log.Debug("Adding ICE edge to peer", slog.String("peer", peer))
-			err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+			err = s.putJoinEdge(ctx, batch, psks, types.NodeID(req.GetId()), publicKey, &v1.MeshEdge{
 				Source:     peer,
 				Target:     req.GetId(),
 				Weight:     1,
 				Attributes: types.EdgeAttrsForConnectProto(proto),
-			}})
+			})
 			if err != nil {
 				return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
 			}
 		}
 	}
 
+	if err := s.recordJoinInBatch(ctx, batch, req); err != nil {
+		return nil, handleErr(status.Errorf(codes.Internal, "failed to record join: %v", err))
+	}
+	// Write the peer and its edges to the database
+	log.Debug("Committing peer to storage", slog.Int("operations", batch.Len()))
+	err = batch.Commit(ctx)
+	if err != nil {
+		if errors.IsKeyExists(err) {
+			return nil, handleErr(status.Errorf(codes.Aborted, "peer was created by a concurrent join: %v", err))
+		}
+		return nil, handleErr(status.Errorf(codes.Internal, "failed to persist peer details to storage: %v", err))
+	}
+	cleanFuncs = append(cleanFuncs, func() {
+		err := p.Delete(ctx, types.NodeID(req.GetId()))
+		if err != nil {
+			log.Warn("failed to delete peer", slog.String("error", err.Error()))
+		}
+	})
+
 	// Collect the list of peers we will send to the new node
-	peers, err := meshnet.WireGuardPeersFor(ctx, s.storage.MeshDB(), types.NodeID(req.GetId()))
+	peers, err := meshnet.WireGuardPeersWithOptions(ctx, s.storage.MeshDB(), types.NodeID(req.GetId()), s.peerMapOptions())
 	if err != nil {
 		return nil, handleErr(status.Errorf(codes.Internal, "failed to get wireguard peers: %v", err))
 	}
@@ -318,13 +431,8 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		NetworkIPv4: s.ipv4Prefix.String(),
 		NetworkIPv6: s.ipv6Prefix.String(),
 		AddressIPv6: leasev6.String(),
-		AddressIPv4: func() string {
-			if leasev4.IsValid() {
-				return leasev4.String()
-			}
-			return ""
-		}(),
-		Peers: peers,
+		AddressIPv4: prefixString(leasev4),
+		Peers:       peers,
 	}
 
 	// Add the node to Raft if requested
@@ -345,27 +453,11 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 				// Use IPv6 for raft
 				storageAddress = net.JoinHostPort(leasev6.Addr().String(), strconv.Itoa(int(storagePort)))
 			}
-			if req.GetAsVoter() {
-				log.Info("Adding voter to cluster", slog.String("raft_address", storageAddress))
-				if err := s.storage.Consensus().AddVoter(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{
-					Id:        req.GetId(),
-					PublicKey: req.GetPublicKey(),
-					Address:   storageAddress,
-				}}); err != nil {
-					log.Error("Failed to add voter", slog.String("error", err.Error()))
-					return
-				}
-			} else if req.GetAsObserver() {
-				log.Info("Adding observer to cluster", slog.String("raft_address", storageAddress))
-				if err := s.storage.Consensus().AddObserver(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{
-					Id:        req.GetId(),
-					PublicKey: req.GetPublicKey(),
-					Address:   storageAddress,
-				}}); err != nil {
-					log.Error("Failed to add observer", slog.String("error", err.Error()))
-					return
-				}
-			}
+			s.addStorageMember(context.WithLogger(ctx, log), types.StoragePeer{StoragePeer: &v1.StoragePeer{
+				Id:        req.GetId(),
+				PublicKey: req.GetPublicKey(),
+				Address:   storageAddress,
+			}}, req.GetAsVoter())
 		}
 		go addStorageMember()
 	}
@@ -403,7 +495,7 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 			return nil, handleErr(status.Errorf(codes.Internal, "failed to list peers by ICE feature: %v", err))
 		}
 		for _, peer := range peers {
-			if peer.GetId() == req.GetId() {
+			if peer.GetId() == req.GetId() || peer.Cordoned() {
 				continue
 			}
 			// We only return peers that are publicly accessible for now.
@@ -430,9 +522,9 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 						WireguardEndpoints: req.GetWireguardEndpoints(),
 						ZoneAwarenessID:    req.GetZoneAwarenessID(),
 						PublicKey:          req.GetPublicKey(),
-						PrivateIPv4:        leasev4.String(),
+						PrivateIPv4:        prefixString(leasev4),
 						PrivateIPv6:        leasev6.String(),
-						Features:           req.GetFeatures(),
+						Features:           features,
 						JoinedAt:           timestamppb.New(time.Now().UTC()),
 					},
 				},
@@ -443,6 +535,17 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		}
 	}()
 
+	sendFeatureHeader(ctx, negotiated)
+	sendPresharedKeysHeader(ctx, psks)
 	log.Debug("Sending join response", slog.Any("response", resp))
 	return resp, nil
 }
+
+// prefixString returns the string form of the prefix, or an empty string
+// if it was never assigned.
+func prefixString(p netip.Prefix) string {
+	if !p.IsValid() {
+		return ""
+	}
+	return p.String()
+}
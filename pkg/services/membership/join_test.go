@@ -0,0 +1,566 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
+)
+
+func TestJoinTracing(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	recorder := tracetest.NewSpanRecorder()
+	tracing.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { tracing.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: node.Plugins(),
+		RBAC:    rbac.NewNoopEvaluator(),
+		Meshnet: node.Network(),
+	})
+	encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+	_, err = srv.Join(ctx, &v1.JoinRequest{
+		Id:        "traced-node",
+		PublicKey: encoded,
+		Routes:    []string{"192.168.100.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	// Collect the spans belonging to the join trace.
+	var root sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "membership.Join" {
+			root = span
+		}
+	}
+	if root == nil {
+		t.Fatal("expected a membership.Join span")
+	}
+	if root.Parent().IsValid() {
+		t.Errorf("expected membership.Join to be a root span")
+	}
+	children := map[trace.SpanID][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() || span == root {
+			continue
+		}
+		children[span.Parent().SpanID()] = append(children[span.Parent().SpanID()], span)
+	}
+
+	// Every storage write made by the join should nest under it, and the
+	// raft apply for each write should nest under the write.
+	var commits int
+	for _, span := range children[root.SpanContext().SpanID()] {
+		switch span.Name() {
+		case "storage.PutValue", "storage.Delete", "storage.CommitBatch":
+		default:
+			t.Errorf("unexpected child span of membership.Join: %s", span.Name())
+			continue
+		}
+		if span.Name() == "storage.CommitBatch" {
+			commits++
+		}
+		applies := children[span.SpanContext().SpanID()]
+		if len(applies) != 1 || applies[0].Name() != "raft.ApplyLog" {
+			var names []string
+			for _, s := range applies {
+				names = append(names, s.Name())
+			}
+			t.Errorf("expected a single raft.ApplyLog span under %s, got %v", span.Name(), names)
+		}
+	}
+	// One batch for the route and one for the peer and its edges.
+	if commits < 2 {
+		t.Errorf("expected at least 2 storage.CommitBatch spans under membership.Join, got %d", commits)
+	}
+}
+
+func TestJoinExitNode(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: node.Plugins(),
+		RBAC:    rbac.NewNoopEvaluator(),
+		Meshnet: node.Network(),
+	})
+	encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+	_, err = srv.Join(ctx, &v1.JoinRequest{
+		Id:        "exit-node",
+		PublicKey: encoded,
+		Routes:    []string{"192.168.100.0/24", "0.0.0.0/0", "::/0"},
+	})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	nw := node.Storage().MeshDB().Networking()
+	exit, err := nw.GetRoute(ctx, types.ExitRouteName("exit-node"))
+	if err != nil {
+		t.Fatalf("get exit route: %v", err)
+	}
+	if !exit.IsExitRoute() {
+		t.Errorf("expected %q to be an exit route", exit.GetName())
+	}
+	if got := exit.GetDestinationCIDRs(); len(got) != 2 || got[0] != "0.0.0.0/0" || got[1] != "::/0" {
+		t.Errorf("expected exit route to advertise default routes, got %v", got)
+	}
+	auto, err := nw.GetRoute(ctx, nodeAutoRoute("exit-node"))
+	if err != nil {
+		t.Fatalf("get auto route: %v", err)
+	}
+	if got := auto.GetDestinationCIDRs(); len(got) != 1 || got[0] != "192.168.100.0/24" {
+		t.Errorf("expected auto route to only contain the site route, got %v", got)
+	}
+}
+
+func TestJoinPresharedKeys(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	join := func(id string, psks bool) {
+		t.Helper()
+		srv := NewServer(ctx, Options{
+			NodeID:        node.ID(),
+			Storage:       node.Storage(),
+			Plugins:       node.Plugins(),
+			RBAC:          rbac.NewNoopEvaluator(),
+			Meshnet:       node.Network(),
+			PresharedKeys: psks,
+		})
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		_, err = srv.Join(ctx, &v1.JoinRequest{Id: id, PublicKey: encoded})
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+	edgeAttrs := func(id string) map[string]string {
+		t.Helper()
+		edge, err := node.Storage().MeshDB().Peers().GetEdge(ctx, node.ID(), types.NodeID(id))
+		if err != nil {
+			t.Fatalf("get edge: %v", err)
+		}
+		return edge.GetAttributes()
+	}
+
+	join("psk-node", true)
+	if _, ok := types.PresharedKeyFromEdgeAttrs(edgeAttrs("psk-node"), node.ID(), node.Key()); !ok {
+		t.Error("expected a preshared key on the edge to the joining node")
+	}
+	join("plain-node", false)
+	if _, ok := types.PresharedKeyFromEdgeAttrs(edgeAttrs("plain-node"), node.ID(), node.Key()); ok {
+		t.Error("expected no preshared key when preshared keys are disabled")
+	}
+}
+
+func TestJoinDuplicateNodeID(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:              node.ID(),
+		Storage:             node.Storage(),
+		Plugins:             node.Plugins(),
+		RBAC:                rbac.NewNoopEvaluator(),
+		Meshnet:             node.Network(),
+		DuplicateNodeWindow: time.Minute,
+	})
+	newKey := func() string {
+		t.Helper()
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		return encoded
+	}
+	joinTo := func(srv *Server, id, key, addr, endpoint string) error {
+		ctx := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 40000}})
+		_, err := srv.Join(ctx, &v1.JoinRequest{Id: id, PublicKey: key, PrimaryEndpoint: endpoint})
+		return err
+	}
+	join := func(id, key, addr string) error {
+		return joinTo(srv, id, key, addr, "")
+	}
+	storedKey := func(id string) string {
+		t.Helper()
+		peer, err := node.Storage().MeshDB().Peers().Get(ctx, types.NodeID(id))
+		if err != nil {
+			t.Fatalf("get peer: %v", err)
+		}
+		return peer.GetPublicKey()
+	}
+
+	t.Run("Flapping", func(t *testing.T) {
+		first, second := newKey(), newKey()
+		if err := join("flapping-node", first, "10.1.0.1"); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		// Another machine with the same ID is rejected every time it tries.
+		for i := 0; i < 3; i++ {
+			err := join("flapping-node", second, "10.1.0.2")
+			if status.Code(err) != codes.AlreadyExists {
+				t.Fatalf("expected AlreadyExists, got %v", err)
+			}
+			if got := storedKey("flapping-node"); got != first {
+				t.Fatalf("expected the original key to be kept, got %s", got)
+			}
+			// The original machine can keep rejoining.
+			if err := join("flapping-node", first, "10.1.0.1"); err != nil {
+				t.Fatalf("rejoin: %v", err)
+			}
+		}
+		// Once the window has passed the new machine may take over the ID.
+		last, ok, err := storage.GetRecentJoin(ctx, node.Storage().MeshStorage(), "flapping-node")
+		if err != nil || !ok {
+			t.Fatalf("expected the join to be recorded, got %v: %v", ok, err)
+		}
+		last.JoinedAt = last.JoinedAt.Add(-2 * time.Minute)
+		batch := node.Storage().MeshStorage().Batch()
+		if err := storage.PutRecentJoinInBatch(batch, last, time.Minute); err != nil {
+			t.Fatalf("put recent join: %v", err)
+		}
+		if err := batch.Commit(ctx); err != nil {
+			t.Fatalf("commit recent join: %v", err)
+		}
+		if err := join("flapping-node", second, "10.1.0.2"); err != nil {
+			t.Fatalf("join after window: %v", err)
+		}
+		if got := storedKey("flapping-node"); got != second {
+			t.Errorf("expected the new key to be stored, got %s", got)
+		}
+	})
+
+	t.Run("KeyRotation", func(t *testing.T) {
+		if err := join("rotating-node", newKey(), "10.2.0.1"); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		rotated := newKey()
+		if err := join("rotating-node", rotated, "10.2.0.1"); err != nil {
+			t.Fatalf("expected key rotation from the same source to be allowed, got %v", err)
+		}
+		if got := storedKey("rotating-node"); got != rotated {
+			t.Errorf("expected the rotated key to be stored, got %s", got)
+		}
+	})
+
+	t.Run("SpoofedEndpoint", func(t *testing.T) {
+		key := newKey()
+		if err := joinTo(srv, "spoofed-node", key, "10.3.0.1", "10.3.0.1"); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		// Claiming the endpoint of the original node does not make a
+		// join come from the same source.
+		err := joinTo(srv, "spoofed-node", newKey(), "10.3.0.2", "10.3.0.1")
+		if status.Code(err) != codes.AlreadyExists {
+			t.Fatalf("expected AlreadyExists, got %v", err)
+		}
+		if got := storedKey("spoofed-node"); got != key {
+			t.Errorf("expected the original key to be kept, got %s", got)
+		}
+	})
+
+	t.Run("NewServer", func(t *testing.T) {
+		key := newKey()
+		if err := join("handover-node", key, "10.4.0.1"); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		// Joins are recorded in storage, so a new leader keeps rejecting
+		// duplicates.
+		next := NewServer(ctx, Options{
+			NodeID:              node.ID(),
+			Storage:             node.Storage(),
+			Plugins:             node.Plugins(),
+			RBAC:                rbac.NewNoopEvaluator(),
+			Meshnet:             node.Network(),
+			DuplicateNodeWindow: time.Minute,
+		})
+		err := joinTo(next, "handover-node", newKey(), "10.4.0.2", "")
+		if status.Code(err) != codes.AlreadyExists {
+			t.Fatalf("expected AlreadyExists, got %v", err)
+		}
+		if got := storedKey("handover-node"); got != key {
+			t.Errorf("expected the original key to be kept, got %s", got)
+		}
+	})
+}
+
+func TestJoinKeyBoundIDs(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:             node.ID(),
+		Storage:            node.Storage(),
+		Plugins:            node.Plugins(),
+		RBAC:               rbac.NewNoopEvaluator(),
+		Meshnet:            node.Network(),
+		RequireKeyBoundIDs: true,
+	})
+	key := crypto.MustGenerateKey()
+	encoded, err := key.PublicKey().Encode()
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+
+	_, err = srv.Join(ctx, &v1.JoinRequest{Id: key.ID(), PublicKey: encoded})
+	if err != nil {
+		t.Fatalf("expected key-bound id to be accepted, got %v", err)
+	}
+	for _, id := range []string{"spoofed-node", crypto.MustGenerateKey().ID(), node.ID().String()} {
+		_, err = srv.Join(ctx, &v1.JoinRequest{Id: id, PublicKey: encoded})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expected spoofed id %s to be denied, got %v", id, err)
+		}
+	}
+}
+
+func TestJoinPreRegisteredNode(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: node.Plugins(),
+		RBAC:    rbac.NewNoopEvaluator(),
+		Meshnet: node.Network(),
+	})
+	err = node.Storage().MeshDB().RBAC().PutRole(ctx, types.Role{Role: &v1.Role{
+		Name: "registered-role",
+		Rules: []*v1.Rule{{
+			Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+			Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_PUT},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("put role: %v", err)
+	}
+	encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+	err = storage.PutNodeRegistration(ctx, node.Storage().MeshStorage(), types.NodeRegistration{
+		ID:          "registered-node",
+		PublicKey:   encoded,
+		PrivateIPv4: "172.16.20.20/32",
+		Roles:       []string{"registered-role"},
+	})
+	if err != nil {
+		t.Fatalf("put node registration: %v", err)
+	}
+	err = node.Plugins().ReserveIP(ctx, "registered-node", netip.MustParsePrefix("172.16.20.20/32"))
+	if err != nil {
+		t.Fatalf("reserve registered address: %v", err)
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		other, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		_, err = srv.Join(ctx, &v1.JoinRequest{Id: "registered-node", PublicKey: other, AssignIPv4: true})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected PermissionDenied, got %v", err)
+		}
+		if _, err := node.Storage().MeshDB().Peers().Get(ctx, "registered-node"); !errors.IsNodeNotFound(err) {
+			t.Errorf("expected the node not to be stored, got %v", err)
+		}
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		resp, err := srv.Join(ctx, &v1.JoinRequest{Id: "registered-node", PublicKey: encoded, AssignIPv4: true})
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		if resp.GetAddressIPv4() != "172.16.20.20/32" {
+			t.Errorf("expected the registered address, got %s", resp.GetAddressIPv4())
+		}
+		binding := NodeRegistrationRoleBindingName("registered-node", "registered-role")
+		if _, err := node.Storage().MeshDB().RBAC().GetRoleBinding(ctx, binding); err != nil {
+			t.Errorf("expected the registered role to be bound: %v", err)
+		}
+	})
+}
+
+func TestJoinConcurrentNewNode(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	// Each server serializes its own joins, so use several to let the joins
+	// race on storage.
+	const joiners = 4
+	servers := make([]*Server, joiners)
+	keys := make([]string, joiners)
+	for i := range servers {
+		servers[i] = NewServer(ctx, Options{
+			NodeID:  node.ID(),
+			Storage: node.Storage(),
+			Plugins: node.Plugins(),
+			RBAC:    rbac.NewNoopEvaluator(),
+			Meshnet: node.Network(),
+		})
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		keys[i] = encoded
+	}
+	errs := make([]error, joiners)
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = servers[i].Join(ctx, &v1.JoinRequest{Id: "racing-node", PublicKey: keys[i]})
+		}(i)
+	}
+	wg.Wait()
+	var succeeded []string
+	for i, err := range errs {
+		switch status.Code(err) {
+		case codes.OK:
+			succeeded = append(succeeded, keys[i])
+		case codes.Aborted:
+			// Another join created the node first.
+		default:
+			t.Fatalf("unexpected join error: %v", err)
+		}
+	}
+	if len(succeeded) == 0 {
+		t.Fatal("expected at least one join to succeed")
+	}
+	peer, err := node.Storage().MeshDB().Peers().Get(ctx, "racing-node")
+	if err != nil {
+		t.Fatalf("get peer: %v", err)
+	}
+	if !slices.Contains(succeeded, peer.GetPublicKey()) {
+		t.Errorf("stored key %s does not belong to a successful join", peer.GetPublicKey())
+	}
+}
+
+func TestJoinValidationDetails(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: node.Plugins(),
+		RBAC:    rbac.NewNoopEvaluator(),
+		Meshnet: node.Network(),
+	})
+	_, err = srv.Join(ctx, &v1.JoinRequest{
+		Id:                 "invalid-fields",
+		PublicKey:          "not-a-public-key",
+		PrimaryEndpoint:    "not-an-address",
+		WireguardEndpoints: []string{"10.0.0.1:51820", "10.0.0.1"},
+	})
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	var violations []string
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				if violation.GetDescription() == "" {
+					t.Errorf("expected a description for field %s", violation.GetField())
+				}
+				violations = append(violations, violation.GetField())
+			}
+		}
+	}
+	want := []string{"publicKey", "primaryEndpoint", "wireguardEndpoints[1]"}
+	if !slices.Equal(violations, want) {
+		t.Fatalf("expected field violations %v, got %v", want, violations)
+	}
+	// Nothing is stored for a rejected join.
+	if _, err := node.Storage().MeshDB().Peers().Get(ctx, "invalid-fields"); !errors.IsNodeNotFound(err) {
+		t.Errorf("expected node not found, got %v", err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -49,7 +50,7 @@ func (s *Server) Leave(ctx context.Context, req *v1.LeaveRequest) (*v1.LeaveResp
 
 	s.log.Info("Leave request received", slog.Any("request", req))
 	// Check that the node is indeed who they say they are
-	if s.plugins.HasAuth() {
+	if s.plugins != nil && s.plugins.HasAuth() {
 		if proxiedFor, ok := leaderproxy.ProxiedFor(ctx); ok {
 			if proxiedFor != req.GetId() {
 				return nil, status.Errorf(codes.PermissionDenied, "proxied for %s, not %s", proxiedFor, req.GetId())
@@ -81,6 +82,9 @@ func (s *Server) Leave(ctx context.Context, req *v1.LeaveRequest) (*v1.LeaveResp
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to remove raft member: %v", err)
 		}
+		// Promote an observer if the leaving node takes the voters
+		// below the minimum.
+		go s.balanceVoters(context.Background())
 	}
 
 	s.log.Info("Removing mesh node from peers DB", "id", req.GetId())
@@ -88,6 +92,20 @@ func (s *Server) Leave(ctx context.Context, req *v1.LeaveRequest) (*v1.LeaveResp
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to delete peer: %v", err)
 	}
+	// A node that has left may be replaced right away.
+	if err := storage.DeleteRecentJoin(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId())); err != nil {
+		s.log.Warn("Failed to delete recent join", "error", err.Error())
+	}
+
+	if s.pruneRoutes {
+		pruned, err := storage.PruneOrphanedRoutes(ctx, s.storage.MeshDB().Networking())
+		if err != nil {
+			// The node is already gone, so don't fail the request.
+			s.log.Warn("Failed to prune orphaned routes", "error", err.Error())
+		} else if len(pruned) > 0 {
+			s.log.Info("Pruned orphaned routes", "id", req.GetId(), "count", len(pruned))
+		}
+	}
 
 	go func() {
 		// Notify any watching plugins
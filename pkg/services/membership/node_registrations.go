@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"fmt"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NodeRegistrationRoleBindingName returns the name of the rolebinding created
+// for a role granted to a node by its registration.
+func NodeRegistrationRoleBindingName(nodeID, role string) string {
+	return fmt.Sprintf("node-registration-%s-%s", nodeID, role)
+}
+
+// checkNodeRegistration looks up the registration of a joining node and
+// ensures the join matches it. ok is false if the node is not registered.
+func (s *Server) checkNodeRegistration(ctx context.Context, req *v1.JoinRequest, publicKey crypto.PublicKey) (reg types.NodeRegistration, ok bool, err error) {
+	reg, err = storage.GetNodeRegistration(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+	if err != nil {
+		if errors.IsNodeRegistrationNotFound(err) {
+			return reg, false, nil
+		}
+		return reg, false, status.Errorf(codes.Internal, "failed to get node registration: %v", err)
+	}
+	registeredKey, err := crypto.DecodePublicKey(reg.PublicKey)
+	if err != nil {
+		return reg, false, status.Errorf(codes.Internal, "invalid public key in node registration: %v", err)
+	}
+	if !registeredKey.Equals(publicKey) {
+		return reg, false, status.Errorf(codes.PermissionDenied, "public key does not match the registration for node %s", req.GetId())
+	}
+	return reg, true, nil
+}
+
+// bindNodeRoles binds the given roles to a joining node. The returned
+// functions remove the created rolebindings and are returned even when
+// binding fails part of the way through.
+func (s *Server) bindNodeRoles(ctx context.Context, nodeID string, roles []string, name func(nodeID, role string) string) ([]func(), error) {
+	var cleanFuncs []func()
+	for _, role := range roles {
+		name := name(nodeID, role)
+		err := s.storage.MeshDB().RBAC().PutRoleBinding(ctx, types.RoleBinding{RoleBinding: &v1.RoleBinding{
+			Name: name,
+			Role: role,
+			Subjects: []*v1.Subject{{
+				Name: nodeID,
+				Type: v1.SubjectType_SUBJECT_NODE,
+			}},
+		}})
+		if err != nil {
+			return cleanFuncs, err
+		}
+		cleanFuncs = append(cleanFuncs, func() {
+			err := s.storage.MeshDB().RBAC().DeleteRoleBinding(ctx, name)
+			if err != nil {
+				context.LoggerFrom(ctx).Warn("Failed to delete rolebinding", slog.String("error", err.Error()))
+			}
+		})
+	}
+	return cleanFuncs, nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"fmt"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// putJoinEdge adds an edge for the joining caller to the batch. When
+// preshared keys are enabled, a new key is generated for the edge, sealed
+// to the keys of the caller and the node on the other end, and recorded in
+// psks under the ID of the other node. No key is generated when the other
+// node does not have a known public key yet.
+func (s *Server) putJoinEdge(ctx context.Context, batch storage.Batch, psks map[types.NodeID]wgtypes.Key, caller types.NodeID, callerKey crypto.PublicKey, edge *v1.MeshEdge) error {
+	if s.psks && edge.GetSource() != edge.GetTarget() {
+		peer := types.NodeID(edge.GetSource())
+		if peer == caller {
+			peer = types.NodeID(edge.GetTarget())
+		}
+		peerKey, err := s.peerPublicKey(ctx, peer)
+		if err != nil {
+			context.LoggerFrom(ctx).Debug("Not generating preshared key for peer without a known public key",
+				slog.String("peer", peer.String()), slog.String("error", err.Error()))
+			return storage.PutEdgeInBatch(batch, types.MeshEdge{MeshEdge: edge})
+		}
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("generate preshared key: %w", err)
+		}
+		edge.Attributes, err = types.EdgeAttrsWithPresharedKey(edge.GetAttributes(), key, map[types.NodeID]crypto.PublicKey{
+			caller: callerKey,
+			peer:   peerKey,
+		})
+		if err != nil {
+			return err
+		}
+		psks[peer] = key
+	}
+	return storage.PutEdgeInBatch(batch, types.MeshEdge{MeshEdge: edge})
+}
+
+// peerPublicKey returns the public key of the given node from storage.
+func (s *Server) peerPublicKey(ctx context.Context, id types.NodeID) (crypto.PublicKey, error) {
+	node, err := s.storage.MeshDB().Peers().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return node.DecodePublicKey()
+}
+
+// sendPresharedKeysHeader returns the preshared keys negotiated for a joining
+// node to the caller.
+func sendPresharedKeysHeader(ctx context.Context, psks map[types.NodeID]wgtypes.Key) {
+	if len(psks) == 0 {
+		return
+	}
+	if err := grpc.SetHeader(ctx, types.PresharedKeysToHeader(psks)); err != nil {
+		// This happens when the server is invoked outside of a gRPC stream.
+		context.LoggerFrom(ctx).Debug("Could not send preshared keys header", slog.String("error", err.Error()))
+	}
+}
@@ -21,16 +21,23 @@ import (
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"slices"
 	"sync"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/plugins"
+	"github.com/webmeshproj/webmesh/pkg/services/jointokens"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -38,16 +45,28 @@ import (
 type Server struct {
 	v1.UnimplementedMembershipServer
 
-	nodeID     types.NodeID
-	storage    storage.Provider
-	plugins    plugins.Manager
-	rbac       rbac.Evaluator
-	meshnet    meshnet.Manager
-	ipv4Prefix netip.Prefix
-	ipv6Prefix netip.Prefix
-	meshDomain string
-	log        *slog.Logger
-	mu         sync.Mutex
+	nodeID      types.NodeID
+	storage     storage.Provider
+	plugins     plugins.Manager
+	rbac        rbac.Evaluator
+	tokens      *jointokens.Issuer
+	meshnet     meshnet.Manager
+	ipv4Prefix  netip.Prefix
+	ipv6Prefix  netip.Prefix
+	meshDomain  string
+	pruneRoutes bool
+	psks        bool
+	keyBoundIDs bool
+	voters      voterLimits
+	maxRoutes   int
+	signedOnly  bool
+	votermu     sync.Mutex
+	features    featureOptions
+	// duplicateWindow is used to detect nodes joining with the ID of
+	// another node.
+	duplicateWindow time.Duration
+	log             *slog.Logger
+	mu              sync.Mutex
 }
 
 // Options are the options for the Membership service.
@@ -57,17 +76,77 @@ type Options struct {
 	Plugins plugins.Manager
 	RBAC    rbac.Evaluator
 	Meshnet meshnet.Manager
+	// PruneRoutesOnLeave removes any routes left without a node
+	// when a node leaves the mesh.
+	PruneRoutesOnLeave bool
+	// SupportedFeatures are the features joining nodes may advertise.
+	// When empty, every known feature is supported.
+	SupportedFeatures []v1.Feature
+	// RequiredFeatures are features every joining node must advertise.
+	RequiredFeatures []v1.Feature
+	// StrictFeatures rejects joining nodes that advertise unsupported
+	// features instead of dropping them.
+	StrictFeatures bool
+	// PresharedKeys generates a WireGuard preshared key for every edge
+	// created for a joining node. Keys are stored on the edges and returned
+	// to the joining node in the header of the join response. Every node in
+	// the mesh must support preshared keys before this is enabled.
+	PresharedKeys bool
+	// DuplicateNodeWindow is how long after a node joins that a join for
+	// the same ID with a different public key from a different source is
+	// rejected as a conflict. A node rotating its key from the same source
+	// is not affected. Zero disables the check.
+	DuplicateNodeWindow time.Duration
+	// RequireKeyBoundIDs requires the ID of every joining node to be the ID
+	// derived from its public key. This prevents a node from claiming the ID
+	// of another node without holding its key.
+	RequireKeyBoundIDs bool
+	// MinVoters is the minimum number of storage voters to maintain.
+	// Observers are promoted to voters until it is reached. Zero disables
+	// promotion.
+	MinVoters int
+	// MaxVoters is the maximum number of storage voters. Nodes asking to
+	// join or be promoted as voters past it remain observers. An odd number
+	// of voters is kept, so an even maximum is reduced by one. Zero means
+	// no limit.
+	MaxVoters int
+	// MaxRoutesPerNode is the maximum number of destination CIDRs a single
+	// node may advertise across all of its routes. Zero means no limit.
+	MaxRoutesPerNode int
+	// RequireSignedRoutes skips the routes requested by joining and updating
+	// nodes, since they cannot be signed. Such nodes sign and write their
+	// routes themselves.
+	RequireSignedRoutes bool
+}
+
+type featureOptions struct {
+	supported []v1.Feature
+	required  []v1.Feature
+	strict    bool
 }
 
 // NewServer returns a new Server.
 func NewServer(ctx context.Context, opts Options) *Server {
 	return &Server{
-		nodeID:  opts.NodeID,
-		storage: opts.Storage,
-		plugins: opts.Plugins,
-		rbac:    opts.RBAC,
-		meshnet: opts.Meshnet,
-		log:     context.LoggerFrom(ctx).With("component", "membership-server"),
+		nodeID:      opts.NodeID,
+		storage:     opts.Storage,
+		plugins:     opts.Plugins,
+		rbac:        opts.RBAC,
+		tokens:      jointokens.NewIssuer(opts.Storage.MeshStorage(), nil),
+		meshnet:     opts.Meshnet,
+		pruneRoutes: opts.PruneRoutesOnLeave,
+		psks:        opts.PresharedKeys,
+		keyBoundIDs: opts.RequireKeyBoundIDs,
+		voters:      voterLimits{min: opts.MinVoters, max: opts.MaxVoters},
+		maxRoutes:   opts.MaxRoutesPerNode,
+		signedOnly:  opts.RequireSignedRoutes,
+		features: featureOptions{
+			supported: opts.SupportedFeatures,
+			required:  opts.RequiredFeatures,
+			strict:    opts.StrictFeatures,
+		},
+		duplicateWindow: opts.DuplicateNodeWindow,
+		log:             context.LoggerFrom(ctx).With("component", "membership-server"),
 	}
 }
 
@@ -89,39 +168,127 @@ func (s *Server) loadMeshState(ctx context.Context) error {
 	return nil
 }
 
+// checkKeyBoundID ensures the given node ID is the ID derived from the node's
+// public key when key-bound IDs are required.
+func (s *Server) checkKeyBoundID(id string, key crypto.PublicKey) error {
+	if !s.keyBoundIDs || key.ID() == id {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "node id %s is not bound to its public key, expected %s", id, key.ID())
+}
+
+// peerMapOptions returns the options for computing the peers of other nodes.
+func (s *Server) peerMapOptions() meshnet.PeerMapOptions {
+	return meshnet.PeerMapOptions{RequireSignedRoutes: s.signedOnly}
+}
+
+// ensurePeerRoutes makes sure the given node advertises the given routes
+// through its auto route. Nothing is written when every route is already
+// advertised by one of the node's routes and the auto route holds no routes
+// that are no longer requested. Otherwise the auto route is replaced, which
+// withdraws routes the node stopped advertising. It reports whether the auto
+// route was created. When signed routes are required nothing is written.
 func (s *Server) ensurePeerRoutes(ctx context.Context, nodeID types.NodeID, routes []string) (created bool, err error) {
+	if len(routes) == 0 {
+		return false, nil
+	}
+	if s.signedOnly {
+		s.log.Warn("Skipping unsigned routes requested by node", "node", nodeID, "routes", routes)
+		return false, nil
+	}
 	nw := s.storage.MeshDB().Networking()
 	current, err := nw.GetRoutesByNode(ctx, nodeID)
 	if err != nil {
 		return false, fmt.Errorf("get routes for node %q: %w", nodeID, err)
 	}
-Routes:
-	for _, route := range routes {
-		for _, r := range current {
-			for _, cidr := range r.DestinationCIDRs {
-				if cidr == route {
-					continue Routes
-				}
-			}
+	var auto types.Route
+	advertised := make(map[string]struct{})
+	for _, r := range current {
+		if r.GetName() == nodeAutoRoute(nodeID) {
+			auto = r
 		}
-		// This is a new route, start managing an auto route for the node.
-		rt := types.Route{Route: &v1.Route{
-			Name:             nodeAutoRoute(nodeID),
-			Node:             nodeID.String(),
-			DestinationCIDRs: routes,
-		}}
-		s.log.Debug("Adding new route for node", "node", nodeID, "route", &rt)
-		err = nw.PutRoute(ctx, rt)
-		if err != nil {
-			return true, fmt.Errorf("put route for node %q: %w", nodeID, err)
+		for _, cidr := range r.DestinationCIDRs {
+			advertised[cidr] = struct{}{}
+		}
+	}
+	changed := slices.ContainsFunc(routes, func(route string) bool {
+		_, ok := advertised[route]
+		return !ok
+	})
+	if !changed && auto.Route != nil {
+		changed = slices.ContainsFunc(auto.GetDestinationCIDRs(), func(cidr string) bool {
+			return !slices.Contains(routes, cidr)
+		})
+	}
+	if !changed {
+		return false, nil
+	}
+	rt := types.Route{Route: &v1.Route{
+		Name:             nodeAutoRoute(nodeID),
+		Node:             nodeID.String(),
+		DestinationCIDRs: routes,
+	}}
+	err = storage.CheckRouteLimit(ctx, s.storage.MeshStorage(), rt, s.maxRoutes)
+	if err != nil {
+		return false, err
+	}
+	s.log.Debug("Updating routes for node", "node", nodeID, "route", &rt)
+	err = nw.PutRoute(ctx, rt)
+	if err != nil {
+		return false, fmt.Errorf("put route for node %q: %w", nodeID, err)
+	}
+	return auto.Route == nil, nil
+}
+
+// ensureExitRoute ensures the node's exit route advertises the given default
+// routes. When signed routes are required nothing is written.
+func (s *Server) ensureExitRoute(ctx context.Context, nodeID types.NodeID, routes []string) (created bool, err error) {
+	if s.signedOnly {
+		s.log.Warn("Skipping unsigned exit route requested by node", "node", nodeID)
+		return false, nil
+	}
+	nw := s.storage.MeshDB().Networking()
+	name := types.ExitRouteName(nodeID)
+	current, err := nw.GetRoute(ctx, name)
+	if err != nil && !errors.Is(err, errors.ErrRouteNotFound) {
+		return false, fmt.Errorf("get exit route for node %q: %w", nodeID, err)
+	}
+	if err == nil && slices.Equal(current.GetDestinationCIDRs(), routes) {
+		return false, nil
+	}
+	rt := types.Route{Route: &v1.Route{
+		Name:             name,
+		Node:             nodeID.String(),
+		DestinationCIDRs: routes,
+	}}
+	err = storage.CheckRouteLimit(ctx, s.storage.MeshStorage(), rt, s.maxRoutes)
+	if err != nil {
+		return false, err
+	}
+	s.log.Debug("Advertising node as an exit node", "node", nodeID, "route", &rt)
+	err = nw.PutRoute(ctx, rt)
+	if err != nil {
+		return false, fmt.Errorf("put exit route for node %q: %w", nodeID, err)
+	}
+	return current.Route == nil, nil
+}
+
+// splitExitRoutes separates the default routes a node advertises as an exit
+// node from the rest of its routes.
+func splitExitRoutes(routes []string) (rest, exit []string) {
+	for _, route := range routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err == nil && types.IsDefaultRoute(prefix) {
+			exit = append(exit, prefix.String())
+			continue
 		}
-		break
+		rest = append(rest, route)
 	}
-	return false, nil
+	return
 }
 
 func nodeAutoRoute(nodeID types.NodeID) string {
-	return fmt.Sprintf("%s-auto", nodeID)
+	return types.AutoRouteName(nodeID)
 }
 
 func nodeIDMatchesContext(ctx context.Context, nodeID string) bool {
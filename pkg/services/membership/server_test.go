@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+func TestEnsurePeerRoutes(t *testing.T) {
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	srv := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: node.Plugins(),
+		RBAC:    rbac.NewNoopEvaluator(),
+		Meshnet: node.Network(),
+	})
+	nw := node.Storage().MeshDB().Networking()
+	tc := []struct {
+		name        string
+		routes      []string
+		wantCreated bool
+		want        []string
+	}{
+		{"initial routes", []string{"10.1.0.0/16", "10.2.0.0/16"}, true, []string{"10.1.0.0/16", "10.2.0.0/16"}},
+		{"unchanged routes", []string{"10.1.0.0/16", "10.2.0.0/16"}, false, []string{"10.1.0.0/16", "10.2.0.0/16"}},
+		{"added route", []string{"10.1.0.0/16", "10.2.0.0/16", "10.3.0.0/16"}, false, []string{"10.1.0.0/16", "10.2.0.0/16", "10.3.0.0/16"}},
+		{"withdrawn route", []string{"10.3.0.0/16"}, false, []string{"10.3.0.0/16"}},
+		{"empty update", nil, false, []string{"10.3.0.0/16"}},
+	}
+	for _, tt := range tc {
+		created, err := srv.ensurePeerRoutes(ctx, "site-node", tt.routes)
+		if err != nil {
+			t.Fatalf("%s: ensure peer routes: %v", tt.name, err)
+		}
+		if created != tt.wantCreated {
+			t.Errorf("%s: expected created to be %v, got %v", tt.name, tt.wantCreated, created)
+		}
+		route, err := nw.GetRoute(ctx, nodeAutoRoute("site-node"))
+		if err != nil {
+			t.Fatalf("%s: get auto route: %v", tt.name, err)
+		}
+		if !slices.Equal(route.GetDestinationCIDRs(), tt.want) {
+			t.Errorf("%s: expected routes %v, got %v", tt.name, tt.want, route.GetDestinationCIDRs())
+		}
+	}
+
+	// Unsigned routes are skipped when signed routes are required.
+	signedOnly := NewServer(ctx, Options{
+		NodeID:              node.ID(),
+		Storage:             node.Storage(),
+		Plugins:             node.Plugins(),
+		RBAC:                rbac.NewNoopEvaluator(),
+		Meshnet:             node.Network(),
+		RequireSignedRoutes: true,
+	})
+	created, err := signedOnly.ensurePeerRoutes(ctx, "unsigned-node", []string{"10.4.0.0/16"})
+	if err != nil || created {
+		t.Fatalf("expected unsigned routes to be skipped, got created=%v err=%v", created, err)
+	}
+	created, err = signedOnly.ensureExitRoute(ctx, "unsigned-node", []string{"0.0.0.0/0"})
+	if err != nil || created {
+		t.Fatalf("expected unsigned exit route to be skipped, got created=%v err=%v", created, err)
+	}
+	routes, err := nw.GetRoutesByNode(ctx, "unsigned-node")
+	if err != nil {
+		t.Fatalf("get routes: %v", err)
+	}
+	if len(routes) != 0 {
+		t.Errorf("expected no routes to be written, got %v", routes)
+	}
+}
@@ -44,7 +44,7 @@ func (s *Server) SubscribePeers(req *v1.SubscribePeersRequest, stream v1.Members
 	} else if !types.IsValidNodeID(req.GetId()) {
 		return status.Error(codes.InvalidArgument, "node id is invalid")
 	}
-	if s.plugins.HasAuth() {
+	if s.plugins != nil && s.plugins.HasAuth() {
 		// If we are running with authorization, ensure the node id matches the authenticated caller.
 		if !nodeIDMatchesContext(stream.Context(), req.GetId()) {
 			return status.Errorf(codes.PermissionDenied, "node id %s does not match authenticated caller", req.GetId())
@@ -77,7 +77,7 @@ func (s *Server) SubscribePeers(req *v1.SubscribePeersRequest, stream v1.Members
 			log.Error("failed to get mdns servers", "error", err.Error())
 			return
 		}
-		peers, err := meshnet.WireGuardPeersFor(ctx, db, peerID)
+		peers, err := meshnet.WireGuardPeersWithOptions(ctx, db, peerID, s.peerMapOptions())
 		if err != nil {
 			log.Error("failed to get wireguard peers", "error", err.Error())
 			return
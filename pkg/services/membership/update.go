@@ -20,6 +20,7 @@ import (
 	"log/slog"
 	"net/netip"
 	"sort"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	v1 "github.com/webmeshproj/api/go/v1"
@@ -29,6 +30,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -44,8 +46,8 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	log := s.log.With("op", "update", "id", req.GetId())
-	ctx = context.WithLogger(ctx, log)
+	ctx = context.WithLogger(ctx, s.log.With("op", "update", "id", req.GetId()))
+	log := context.LoggerFrom(ctx)
 
 	log.Debug("Update request received", slog.Any("request", req))
 	// Check if we haven't loaded the mesh domain and prefixes into memory yet
@@ -60,7 +62,7 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 	} else if !types.IsValidNodeID(req.GetId()) {
 		return nil, status.Error(codes.InvalidArgument, "node id is invalid")
 	}
-	if s.plugins.HasAuth() {
+	if s.plugins != nil && s.plugins.HasAuth() {
 		if !nodeIDMatchesContext(ctx, req.GetId()) {
 			return nil, status.Errorf(codes.PermissionDenied, "node id %s does not match authenticated caller", req.GetId())
 		}
@@ -84,6 +86,9 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 		if err != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
 		}
+		if err := s.checkKeyBoundID(req.GetId(), publicKey); err != nil {
+			return nil, err
+		}
 	}
 
 	// We can go ahead and check here if the node is allowed to do what they want.
@@ -128,9 +133,16 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 			break
 		}
 	}
+	// Any update from a node doubles as a heartbeat.
+	if err := p.PutHeartbeat(ctx, peer.NodeID(), time.Now()); err != nil {
+		log.Warn("Failed to record heartbeat", slog.String("error", err.Error()))
+	}
 	// Ensure any new routes
 	_, err = s.ensurePeerRoutes(ctx, peer.NodeID(), req.GetRoutes())
 	if err != nil {
+		if errors.IsRouteLimitExceeded(err) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
 		return nil, status.Errorf(codes.Internal, "failed to ensure peer routes: %v", err)
 	}
 	// Overwrite any provided fields
@@ -162,9 +174,22 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 		}
 	}
 	// Zone awareness
-	if req.GetZoneAwarenessID() != "" && req.GetZoneAwarenessID() != peer.GetZoneAwarenessID() {
-		toUpdate.ZoneAwarenessID = req.GetZoneAwarenessID()
-		hasChanges = true
+	var zonesChanged bool
+	if zoneID := req.GetZoneAwarenessID(); zoneID != "" {
+		// The cordon state is managed through the admin API and is kept
+		// regardless of what the node reports.
+		if _, ok := types.ParseLabels(zoneID)[types.CordonLabel]; ok || peer.Cordoned() {
+			requested := types.MeshNode{MeshNode: &v1.MeshNode{ZoneAwarenessID: zoneID}}
+			zoneID, err = requested.WithCordonState(peer.CordonState())
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid zone awareness id: %v", err)
+			}
+		}
+		if zoneID != peer.GetZoneAwarenessID() {
+			toUpdate.ZoneAwarenessID = zoneID
+			hasChanges = true
+			zonesChanged = true
+		}
 	}
 	// Multiaddrs
 	if len(req.GetMultiaddrs()) > 0 {
@@ -189,12 +214,45 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 			return nil, status.Errorf(codes.Internal, "failed to update peer: %v", err)
 		}
 	}
+	if zonesChanged {
+		// Make sure the peer has edges to any public nodes in its new zones.
+		zonePeers, err := p.List(ctx, storage.FilterByAnyZone(toUpdate.Zones()))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list peers: %v", err)
+		}
+		for _, zonePeer := range zonePeers {
+			if zonePeer.GetId() == req.GetId() || zonePeer.PrimaryEndpoint == "" {
+				continue
+			}
+			log.Debug("Adding edges to peer in the same zone", slog.String("peer", zonePeer.GetId()))
+			err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+				Source: zonePeer.GetId(),
+				Target: req.GetId(),
+				Weight: 1,
+			}})
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to add edge: %v", err)
+			}
+		}
+	}
 
 	// Change to voter if requested and not already
 	if req.GetAsVoter() && currentSuffrage != v1.ClusterStatus_CLUSTER_VOTER {
 		if currentAddress == "" {
 			return nil, status.Errorf(codes.Internal, "failed to lookup peer address")
 		}
+		s.votermu.Lock()
+		defer s.votermu.Unlock()
+		if s.voters.max > 0 {
+			voters, err := countVoters(ctx, s.storage.Consensus())
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to count voters: %v", err)
+			}
+			if !s.voters.allowVoter(voters) {
+				log.Info("Maximum number of voters reached, not promoting peer", slog.Int("max-voters", s.voters.maxVoters()))
+				return &v1.UpdateResponse{}, nil
+			}
+		}
 		// Promote to voter
 		log.Debug("Promoting peer to voter", slog.String("storage-address", string(currentAddress)))
 		if err := s.storage.Consensus().AddVoter(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{
@@ -203,6 +261,8 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 		}}); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to promote to voter: %v", err)
 		}
+		// Promote another observer if this left an even number of voters.
+		go s.balanceVoters(context.Background())
 	}
 	return &v1.UpdateResponse{}, nil
 }
@@ -0,0 +1,135 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// fieldViolations collects the invalid fields of a request so they can be
+// returned to the caller together. Fields are named as they appear in the
+// protobuf definition, with repeated and map fields indexed by position or key.
+type fieldViolations []*errdetails.BadRequest_FieldViolation
+
+// add records that the given field is invalid.
+func (v *fieldViolations) add(field, format string, args ...any) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns an InvalidArgument status carrying the violations as
+// BadRequest details, or nil if there are none.
+func (v fieldViolations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(v))
+	for i, violation := range v {
+		msgs[i] = violation.GetDescription()
+	}
+	st := status.New(codes.InvalidArgument, strings.Join(msgs, "; "))
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v})
+	if err != nil {
+		// Only possible if the details cannot be marshaled.
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// validateJoinRequest checks every field of a join request that can be
+// validated without consulting storage. All invalid fields are reported at
+// once so clients can map them back to their inputs. The caller must have
+// loaded the mesh state.
+func (s *Server) validateJoinRequest(req *v1.JoinRequest) error {
+	var violations fieldViolations
+	if req.GetId() == "" {
+		violations.add("id", "node id required")
+	} else if !types.IsValidNodeID(req.GetId()) {
+		violations.add("id", "node id is invalid")
+	}
+	labels := types.ParseLabels(req.GetZoneAwarenessID())
+	if err := types.ValidateLabels(labels); err != nil {
+		violations.add("zoneAwarenessID", "invalid labels: %v", err)
+	} else if _, ok := labels[types.CordonLabel]; ok {
+		violations.add("zoneAwarenessID", "label %q is reserved", types.CordonLabel)
+	}
+	if _, err := crypto.DecodePublicKey(req.GetPublicKey()); err != nil {
+		violations.add("publicKey", "invalid public key: %v", err)
+	}
+	if endpoint := req.GetPrimaryEndpoint(); endpoint != "" {
+		if _, err := netip.ParseAddr(endpoint); err != nil {
+			violations.add("primaryEndpoint", "invalid primary endpoint %q: must be an IP address", endpoint)
+		}
+	}
+	for i, endpoint := range req.GetWireguardEndpoints() {
+		if err := validateHostPort(endpoint); err != nil {
+			violations.add(fmt.Sprintf("wireguardEndpoints[%d]", i), "invalid wireguard endpoint %q: %v", endpoint, err)
+		}
+	}
+	for i, route := range req.GetRoutes() {
+		field := fmt.Sprintf("routes[%d]", i)
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			violations.add(field, "invalid route %q: %v", route, err)
+			continue
+		}
+		if types.IsDefaultRoute(prefix) {
+			// Default routes advertise the node as an exit node.
+			continue
+		}
+		// Make sure the route does not overlap with a mesh reserved prefix
+		if prefix.Contains(s.ipv4Prefix.Addr()) || prefix.Contains(s.ipv6Prefix.Addr()) {
+			violations.add(field, "route %q overlaps with mesh prefix", route)
+		}
+	}
+	for peer := range req.GetDirectPeers() {
+		if !types.IsValidNodeID(peer) {
+			violations.add(fmt.Sprintf("directPeers[%s]", peer), "invalid peer id %q", peer)
+		}
+	}
+	return violations.err()
+}
+
+// validateHostPort checks that the endpoint is a host and a non-zero port.
+func validateHostPort(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// voterLimits are the minimum and maximum number of voters to keep in the
+// storage consensus group. Zero values disable the respective limit.
+type voterLimits struct {
+	min, max int
+}
+
+// enabled reports if the number of voters is managed.
+func (l voterLimits) enabled() bool {
+	return l.min > 0 || l.max > 0
+}
+
+// maxVoters returns the effective maximum number of voters. An even
+// maximum is reduced by one, as the extra voter would not raise the number
+// of failures tolerated.
+func (l voterLimits) maxVoters() int {
+	if l.max > 0 && l.max%2 == 0 {
+		return l.max - 1
+	}
+	return l.max
+}
+
+// target returns the number of voters to aim for given the current number of
+// voters and observers. Observers are promoted to reach the minimum and no
+// voters are added past the maximum. An odd target is preferred so that every
+// voter added raises the number of failures tolerated. Voters are only
+// demoted when there are more than the maximum.
+func (l voterLimits) target(voters, observers int) int {
+	maxVoters := l.maxVoters()
+	target := max(voters, l.min)
+	if target%2 == 0 {
+		target++
+	}
+	if maxVoters > 0 && target > maxVoters {
+		target = maxVoters
+	}
+	if available := voters + observers; target > available {
+		target = available
+		if target%2 == 0 && target > voters {
+			target--
+		}
+	}
+	if target < voters && (maxVoters == 0 || voters <= maxVoters) {
+		target = voters
+	}
+	return target
+}
+
+// allowVoter reports if another voter may be added to the group.
+func (l voterLimits) allowVoter(voters int) bool {
+	return l.max == 0 || voters < l.maxVoters()
+}
+
+// balanceVoters promotes observers to voters, or demotes voters to
+// observers, until the number of voters matches the target for the
+// configured limits. Observers with the lowest IDs are promoted first and
+// voters with the highest IDs are demoted first. The leader is never
+// demoted.
+func balanceVoters(ctx context.Context, consensus storage.Consensus, limits voterLimits) error {
+	if !limits.enabled() {
+		return nil
+	}
+	peers, err := consensus.GetPeers(ctx)
+	if err != nil {
+		return fmt.Errorf("get storage peers: %w", err)
+	}
+	voters, observers := splitVoters(peers)
+	target := limits.target(len(voters), len(observers))
+	log := context.LoggerFrom(ctx)
+	for i := 0; len(voters) < target && i < len(observers); i++ {
+		peer := observers[i]
+		log.Info("Promoting observer to reach target voter count", slog.String("id", peer.GetId()), slog.Int("target", target))
+		if err := consensus.AddVoter(ctx, peer); err != nil {
+			return fmt.Errorf("promote %s to voter: %w", peer.GetId(), err)
+		}
+		voters = append(voters, peer)
+	}
+	for i := len(voters) - 1; len(voters) > target && i >= 0; i-- {
+		peer := voters[i]
+		if peer.GetClusterStatus() == v1.ClusterStatus_CLUSTER_LEADER {
+			continue
+		}
+		log.Info("Demoting voter to reach target voter count", slog.String("id", peer.GetId()), slog.Int("target", target))
+		if err := consensus.DemoteVoter(ctx, peer); err != nil {
+			return fmt.Errorf("demote voter %s: %w", peer.GetId(), err)
+		}
+		voters = slices.Delete(voters, i, i+1)
+	}
+	return nil
+}
+
+// countVoters returns the number of voters in the storage consensus group.
+func countVoters(ctx context.Context, consensus storage.Consensus) (int, error) {
+	peers, err := consensus.GetPeers(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("get storage peers: %w", err)
+	}
+	voters, _ := splitVoters(peers)
+	return len(voters), nil
+}
+
+// splitVoters splits the given peers into voters and observers, each
+// sorted by ID.
+func splitVoters(peers []types.StoragePeer) (voters, observers []types.StoragePeer) {
+	for _, peer := range peers {
+		switch peer.GetClusterStatus() {
+		case v1.ClusterStatus_CLUSTER_LEADER, v1.ClusterStatus_CLUSTER_VOTER:
+			voters = append(voters, peer)
+		case v1.ClusterStatus_CLUSTER_OBSERVER:
+			observers = append(observers, peer)
+		}
+	}
+	byID := func(a, b types.StoragePeer) int { return strings.Compare(a.GetId(), b.GetId()) }
+	slices.SortFunc(voters, byID)
+	slices.SortFunc(observers, byID)
+	return voters, observers
+}
+
+// addStorageMember adds the given peer to the storage consensus group and
+// balances the voters afterwards. The peer is added as an observer instead
+// of a voter when the maximum number of voters has been reached.
+func (s *Server) addStorageMember(ctx context.Context, peer types.StoragePeer, asVoter bool) {
+	// Rebalance once the member is added, this may promote the new
+	// member to reach the minimum.
+	defer s.balanceVoters(context.Background())
+	s.votermu.Lock()
+	defer s.votermu.Unlock()
+	log := context.LoggerFrom(ctx)
+	consensus := s.storage.Consensus()
+	if asVoter && s.voters.max > 0 {
+		voters, err := countVoters(ctx, consensus)
+		if err != nil {
+			log.Warn("Failed to count storage voters", slog.String("error", err.Error()))
+		} else if !s.voters.allowVoter(voters) {
+			log.Info("Maximum number of voters reached, adding as observer", slog.Int("max-voters", s.voters.maxVoters()))
+			asVoter = false
+		}
+	}
+	if asVoter {
+		log.Info("Adding voter to cluster", slog.String("raft_address", peer.GetAddress()))
+		if err := consensus.AddVoter(ctx, peer); err != nil {
+			log.Error("Failed to add voter", slog.String("error", err.Error()))
+		}
+		return
+	}
+	log.Info("Adding observer to cluster", slog.String("raft_address", peer.GetAddress()))
+	if err := consensus.AddObserver(ctx, peer); err != nil {
+		log.Error("Failed to add observer", slog.String("error", err.Error()))
+	}
+}
+
+// balanceVoters balances the voters in the storage consensus group
+// according to the configured limits, logging any failure. Voter changes
+// are serialized so concurrent joins cannot overshoot the limits.
+func (s *Server) balanceVoters(ctx context.Context) {
+	s.votermu.Lock()
+	defer s.votermu.Unlock()
+	if err := balanceVoters(context.WithLogger(ctx, s.log), s.storage.Consensus(), s.voters); err != nil {
+		s.log.Error("Failed to balance storage voters", slog.String("error", err.Error()))
+	}
+}
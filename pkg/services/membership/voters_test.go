@@ -0,0 +1,158 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// fakeConsensus is an in-memory storage consensus group led by "leader".
+type fakeConsensus struct {
+	storage.Consensus
+	peers map[string]v1.ClusterStatus
+	mu    sync.Mutex
+}
+
+func newFakeConsensus() *fakeConsensus {
+	return &fakeConsensus{peers: map[string]v1.ClusterStatus{"leader": v1.ClusterStatus_CLUSTER_LEADER}}
+}
+
+func (f *fakeConsensus) GetPeers(context.Context) ([]types.StoragePeer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]types.StoragePeer, 0, len(f.peers))
+	for id, status := range f.peers {
+		out = append(out, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: id, ClusterStatus: status}})
+	}
+	return out, nil
+}
+
+func (f *fakeConsensus) set(peer types.StoragePeer, status v1.ClusterStatus) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.peers[peer.GetId()] == v1.ClusterStatus_CLUSTER_LEADER {
+		return fmt.Errorf("cannot change the leader")
+	}
+	f.peers[peer.GetId()] = status
+	return nil
+}
+
+func (f *fakeConsensus) AddVoter(_ context.Context, peer types.StoragePeer) error {
+	return f.set(peer, v1.ClusterStatus_CLUSTER_VOTER)
+}
+
+func (f *fakeConsensus) AddObserver(_ context.Context, peer types.StoragePeer) error {
+	return f.set(peer, v1.ClusterStatus_CLUSTER_OBSERVER)
+}
+
+func (f *fakeConsensus) DemoteVoter(_ context.Context, peer types.StoragePeer) error {
+	return f.set(peer, v1.ClusterStatus_CLUSTER_OBSERVER)
+}
+
+func (f *fakeConsensus) status(id string) v1.ClusterStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.peers[id]
+}
+
+type fakeConsensusProvider struct {
+	storage.Provider
+	consensus *fakeConsensus
+}
+
+func (f fakeConsensusProvider) Consensus() storage.Consensus {
+	return f.consensus
+}
+
+func TestVoterLimitsTarget(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name              string
+		limits            voterLimits
+		voters, observers int
+		want              int
+	}{
+		{"promote to minimum", voterLimits{min: 3, max: 5}, 1, 4, 3},
+		{"not enough observers for an odd count", voterLimits{min: 3, max: 5}, 1, 1, 1},
+		{"even minimum rounds up", voterLimits{min: 4, max: 7}, 1, 6, 5},
+		{"even maximum rounds down", voterLimits{min: 5, max: 4}, 1, 6, 3},
+		{"between limits keeps voters", voterLimits{min: 3, max: 7}, 5, 2, 5},
+		{"even voters promote one more", voterLimits{min: 1, max: 7}, 4, 2, 5},
+		{"demote above maximum", voterLimits{min: 3, max: 5}, 7, 0, 5},
+		{"no maximum", voterLimits{min: 3}, 9, 3, 9},
+	}
+	for _, tt := range tc {
+		if got := tt.limits.target(tt.voters, tt.observers); got != tt.want {
+			t.Errorf("%s: expected target of %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestVoterPromotion(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	consensus := newFakeConsensus()
+	srv := &Server{
+		storage: fakeConsensusProvider{consensus: consensus},
+		voters:  voterLimits{min: 3, max: 5},
+		log:     slog.Default(),
+	}
+	join := func(id string, asVoter bool) {
+		srv.addStorageMember(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: id}}, asVoter)
+	}
+	countStatus := func() (voters, observers int) {
+		peers, _ := consensus.GetPeers(ctx)
+		v, o := splitVoters(peers)
+		return len(v), len(o)
+	}
+
+	// Observers are promoted until the minimum is reached.
+	join("node-a", false)
+	if got := consensus.status("node-a"); got != v1.ClusterStatus_CLUSTER_OBSERVER {
+		t.Fatalf("expected node-a to stay an observer until an odd count can be reached, got %s", got)
+	}
+	join("node-b", false)
+	if voters, observers := countStatus(); voters != 3 || observers != 0 {
+		t.Fatalf("expected 3 voters and 0 observers, got %d and %d", voters, observers)
+	}
+	join("node-c", false)
+	if got := consensus.status("node-c"); got != v1.ClusterStatus_CLUSTER_OBSERVER {
+		t.Errorf("expected node-c to remain an observer past the minimum, got %s", got)
+	}
+
+	// Voters may join up to the maximum, excess joiners remain observers.
+	for _, id := range []string{"node-d", "node-e", "node-f", "node-g"} {
+		join(id, true)
+	}
+	if voters, observers := countStatus(); voters != 5 || observers != 3 {
+		t.Fatalf("expected 5 voters and 3 observers, got %d and %d", voters, observers)
+	}
+	for _, id := range []string{"node-f", "node-g"} {
+		if got := consensus.status(id); got != v1.ClusterStatus_CLUSTER_OBSERVER {
+			t.Errorf("expected %s to remain an observer past the maximum, got %s", id, got)
+		}
+	}
+}
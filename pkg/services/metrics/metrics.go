@@ -29,6 +29,7 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
 )
 
 // DefaultListenAddress is the default listen address for the node Metrics.
@@ -63,22 +64,34 @@ func New(ctx context.Context, o Options) *Server {
 // ListenAndServe starts the server and blocks until the server exits.
 func (s *Server) ListenAndServe() error {
 	s.log.Info("Starting Prometheus metrics server", slog.String("listen_address", s.ListenAddress), slog.String("path", s.Path))
+	handler := Handler(promapi.DefaultRegisterer, promapi.DefaultGatherer)
 	srv := &http.Server{
 		Addr: s.ListenAddress,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path == s.Path {
-				promhttp.Handler().ServeHTTP(w, r)
+				handler.ServeHTTP(w, r)
 			} else {
 				http.NotFound(w, r)
 			}
 		}),
 	}
+	s.srv = srv
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		s.log.Error("metrics server failed", slog.String("error", err.Error()))
 	}
 	return nil
 }
 
+// Handler returns an HTTP handler exposing the metrics of the given gatherer.
+// The OpenMetrics format is served to clients that accept it, which is the
+// only format that carries exemplars. Metrics about the handler itself are
+// registered with the given registerer.
+func Handler(reg promapi.Registerer, g promapi.Gatherer) http.Handler {
+	return promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(g, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}))
+}
+
 // Shutdown attempts to stop the server gracefully.
 func (s *Server) Shutdown(ctx context.Context) error {
 	context.LoggerFrom(ctx).Info("Shutting down Prometheus metrics server")
@@ -90,8 +103,10 @@ func (s *Server) Shutdown(ctx context.Context) error {
 func AppendMetricsMiddlewares(log *slog.Logger, uu []grpc.UnaryServerInterceptor, ss []grpc.StreamServerInterceptor) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor, error) {
 	log.Debug("registering gRPC metrics interceptors")
 	metrics := prometheus.NewServerMetrics(prometheus.WithServerHandlingTimeHistogram())
-	uu = append(uu, metrics.UnaryServerInterceptor())
-	ss = append(ss, metrics.StreamServerInterceptor())
+	// Link handling times to the trace of the call when tracing is enabled.
+	exemplars := prometheus.WithExemplarFromContext(tracing.Exemplar)
+	uu = append(uu, metrics.UnaryServerInterceptor(exemplars))
+	ss = append(ss, metrics.StreamServerInterceptor(exemplars))
 	if err := promapi.Register(metrics); err != nil {
 		return nil, nil, err
 	}
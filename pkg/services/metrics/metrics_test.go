@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
+)
+
+const openMetricsAccept = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+var (
+	openMetricsLabels   = `\{(?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*",?)*\}`
+	openMetricsValue    = `(?:[-+]?[0-9.eE+-]+|[-+]?Inf|NaN)`
+	openMetricsExemplar = regexp.MustCompile(` # (` + openMetricsLabels + `) ` + openMetricsValue + `(?: [0-9.eE+-]+)?$`)
+	openMetricsSample   = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(?:` + openMetricsLabels + `)? ` + openMetricsValue + `(?: [0-9.eE+-]+)?(?: # .*)?$`)
+	openMetricsMeta     = regexp.MustCompile(`^# (?:TYPE [a-zA-Z_:][a-zA-Z0-9_:]* (?:counter|gauge|histogram|gaugehistogram|stateset|info|summary|unknown)|HELP [a-zA-Z_:][a-zA-Z0-9_:]* .*|UNIT [a-zA-Z_:][a-zA-Z0-9_:]* .*)$`)
+)
+
+func TestOpenMetricsExemplars(t *testing.T) {
+	t.Parallel()
+	reg := prometheus.NewRegistry()
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "webmesh",
+		Name:      "test_latency_seconds",
+		Help:      "Test latency.",
+		Buckets:   []float64{0.1, 1},
+	})
+	reg.MustRegister(latency)
+
+	// Observations without a trace carry no exemplar.
+	tracing.Observe(context.Background(), latency, 0.05)
+	if tracing.Exemplar(context.Background()) != nil {
+		t.Fatal("expected no exemplar without a span in the context")
+	}
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	tracing.Observe(ctx, latency, 0.5)
+
+	srv := httptest.NewServer(Handler(reg, reg))
+	t.Cleanup(srv.Close)
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+	req.Header.Set("Accept", openMetricsAccept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Fatalf("expected an OpenMetrics content type, got %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if lines[len(lines)-1] != "# EOF" {
+		t.Fatalf("expected the exposition to end with # EOF, got %q", lines[len(lines)-1])
+	}
+	var exemplars []string
+	for _, line := range lines[:len(lines)-1] {
+		switch {
+		case openMetricsMeta.MatchString(line):
+		case openMetricsSample.MatchString(line):
+			if m := openMetricsExemplar.FindStringSubmatch(line); m != nil {
+				exemplars = append(exemplars, m[1])
+			}
+		default:
+			t.Errorf("invalid OpenMetrics line: %q", line)
+		}
+	}
+	if len(exemplars) != 1 {
+		t.Fatalf("expected a single exemplar, got %v", exemplars)
+	}
+	for _, label := range []string{`trace_id="4bf92f3577b34da6a3ce929d0e0e4736"`, `span_id="00f067aa0ba902b7"`} {
+		if !strings.Contains(exemplars[0], label) {
+			t.Errorf("expected exemplar %s to contain %s", exemplars[0], label)
+		}
+	}
+}
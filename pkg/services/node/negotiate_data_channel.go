@@ -64,6 +64,8 @@ func (s *Server) NegotiateDataChannel(stream v1.Node_NegotiateDataChannelServer)
 			SrcAddress:  req.GetSrc(),
 			DstAddress:  net.JoinHostPort(req.GetDst(), strconv.Itoa(int(req.GetPort()))),
 			STUNServers: req.GetStunServers(),
+			// TODO: Make this configurable.
+			ICEGatheringTimeout: datachannels.DefaultICEGatheringTimeout,
 		})
 		if err != nil {
 			return err
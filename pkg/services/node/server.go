@@ -44,14 +44,15 @@ type Server struct {
 
 // Options are options for the Node service.
 type Options struct {
-	NodeID      types.NodeID
-	Description string
-	Version     version.BuildInfo
-	Storage     storage.Provider
-	Meshnet     meshnet.Manager
-	NodeDialer  transport.NodeDialer
-	Plugins     plugins.Manager
-	Features    []*v1.FeaturePort
+	NodeID       types.NodeID
+	Description  string
+	Version      version.BuildInfo
+	Storage      storage.Provider
+	Meshnet      meshnet.Manager
+	NodeDialer   transport.NodeDialer
+	LeaderDialer transport.LeaderDialer
+	Plugins      plugins.Manager
+	Features     []*v1.FeaturePort
 }
 
 // NewServer returns a new Server. Features are used for returning what features are enabled.
@@ -0,0 +1,71 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// UpdateZones updates the zones this node is available in. The new zones are
+// written to storage through the leader so that other nodes re-evaluate the
+// endpoints they use for us, and our own peers are re-evaluated against them.
+// At least one zone must be given.
+func (s *Server) UpdateZones(ctx context.Context, zones []string) error {
+	if len(zones) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one zone is required")
+	}
+	// Keep any labels stored alongside our current zones.
+	var labels types.Labels
+	if s.Storage != nil {
+		self, err := s.Storage.MeshDB().Peers().Get(ctx, s.NodeID)
+		if err == nil {
+			labels = self.Labels()
+		}
+	}
+	zoneID, err := types.EncodeZoneAwarenessID(zones, labels)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if s.LeaderDialer == nil {
+		return status.Error(codes.Unavailable, "no leader dialer configured")
+	}
+	s.log.Info("Updating available zones", slog.Any("zones", types.ParseZones(zoneID)))
+	conn, err := s.LeaderDialer.DialLeader(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to dial leader: %v", err)
+	}
+	defer conn.Close()
+	_, err = v1.NewMembershipClient(conn).Update(ctx, &v1.UpdateRequest{
+		Id:              s.NodeID.String(),
+		ZoneAwarenessID: zoneID,
+	})
+	if err != nil {
+		return err
+	}
+	err = s.Meshnet.SetZones(ctx, zones)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to re-evaluate peers: %v", err)
+	}
+	return nil
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -61,19 +62,33 @@ func (a *Action) For(resource string) *Action {
 	}
 }
 
-// action returns the underlying v1.Action.
-func (a *Action) action() *v1.RBACAction {
-	return (*v1.RBACAction)(a)
+// RBACResource returns the resource the action is performed on.
+func (a *Action) RBACResource() types.RBACResource {
+	return types.RBACResource{
+		Type: a.Resource,
+		Name: a.ResourceName,
+	}
+}
+
+// StoreEvaluatorOptions are options for a store evaluator.
+type StoreEvaluatorOptions struct {
+	// AllowWildcards is true if resource names in a rule ending in "*" should
+	// match by prefix, and a bare "*" should grant access to every resource name.
+	AllowWildcards bool
 }
 
 // NewStoreEvaluator returns a ActionEvaluator that evaluates actions
 // against the roles in the given store.
-func NewStoreEvaluator(store storage.MeshDB) Evaluator {
-	return &storeEvaluator{rbac: store.RBAC()}
+func NewStoreEvaluator(store storage.MeshDB, opts StoreEvaluatorOptions) Evaluator {
+	return &storeEvaluator{
+		rbac: store.RBAC(),
+		opts: types.EvalOptions{AllowWildcardResourceNames: opts.AllowWildcards},
+	}
 }
 
 type storeEvaluator struct {
 	rbac storage.RBAC
+	opts types.EvalOptions
 }
 
 func (s *storeEvaluator) IsSecure() bool {
@@ -94,18 +109,13 @@ func (s *storeEvaluator) Evaluate(ctx context.Context, actions Actions) (bool, e
 	if peerName == "" {
 		return false, fmt.Errorf("no peer information in context")
 	}
-	// We treat nodes and users as the same entity for the purpose of authorization.
-	nodeRoles, err := s.rbac.ListNodeRoles(ctx, types.NodeID(peerName))
-	if err != nil {
-		return false, err
-	}
-	userRoles, err := s.rbac.ListUserRoles(ctx, types.NodeID(peerName))
-	if err != nil {
-		return false, err
-	}
 	for _, action := range actions {
-		if !nodeRoles.Eval(action.action()) && !userRoles.Eval(action.action()) {
-			return false, nil
+		err := s.rbac.Enforce(ctx, types.NodeID(peerName), action.Verb, action.RBACResource(), s.opts)
+		if err != nil {
+			if errors.IsPermissionDenied(err) {
+				return false, nil
+			}
+			return false, err
 		}
 	}
 	return true, nil
@@ -22,9 +22,11 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"golang.org/x/net/http2"
@@ -43,6 +45,10 @@ const DefaultGRPCPort = 8443
 // DefaultGRPCListenAddress is the default listen address for the gRPC server.
 const DefaultGRPCListenAddress = "[::]:8443"
 
+// DefaultDrainTimeout is the default maximum time to wait for in-flight
+// RPCs to finish during shutdown.
+const DefaultDrainTimeout = 10 * time.Second
+
 // MeshServer is the generic interface for additional services that
 // can be managed by this server.
 type MeshServer interface {
@@ -89,6 +95,17 @@ type Options struct {
 	AllowedOrigins []string
 	// ListenAddress is the address to start the gRPC server on.
 	ListenAddress string
+	// Listener is an optional listener to serve gRPC on instead of
+	// listening on ListenAddress, such as one side of a connection muxer.
+	Listener net.Listener
+	// InternalListenAddress is an optional separate address to serve
+	// internal-only services, such as the admin API, on. When empty,
+	// internal services are served alongside everything else.
+	InternalListenAddress string
+	// ReadOnlyListenAddress is an optional separate address to serve
+	// read-only services, such as replica storage queries, on. Read-only
+	// services are only registered when this is set.
+	ReadOnlyListenAddress string
 	// ServerOptions are options for the server. This should include
 	// any registered authentication mechanisms.
 	ServerOptions []grpc.ServerOption
@@ -96,6 +113,10 @@ type Options struct {
 	LibP2POptions *LibP2POptions
 	// Servers are additional servers to manage alongside the gRPC server.
 	Servers MeshServers
+	// DrainTimeout is the maximum time to wait for in-flight RPCs to finish
+	// during shutdown before the server is stopped forcefully. Defaults to
+	// DefaultDrainTimeout.
+	DrainTimeout time.Duration
 }
 
 // LibP2POptions are options for serving the gRPC server over libp2p.
@@ -115,20 +136,28 @@ func (o *Options) GetServer(typ any) (MeshServer, bool) {
 
 // Server is the gRPC server.
 type Server struct {
-	opts    Options
-	hostlis net.Listener
-	lis     *net.TCPListener
-	srv     *grpc.Server
-	websrv  *http.Server
-	srvs    []MeshServer
-	log     *slog.Logger
-	mu      sync.Mutex
+	opts        Options
+	hostlis     net.Listener
+	extralis    []net.Listener
+	lis         *rebindableListener
+	srv         *grpc.Server
+	internallis *net.TCPListener
+	internalsrv *grpc.Server
+	readonlylis *net.TCPListener
+	readonlysrv *grpc.Server
+	websrv      *http.Server
+	srvs        []MeshServer
+	log         *slog.Logger
+	mu          sync.Mutex
 }
 
 // NewServer returns a new Server.
 // TODO: We need to dynamically expose certain services only to the internal mesh.
 func NewServer(ctx context.Context, o Options) (*Server, error) {
 	log := context.LoggerFrom(ctx).With("component", "mesh-services")
+	if o.DrainTimeout <= 0 {
+		o.DrainTimeout = DefaultDrainTimeout
+	}
 	server := &Server{
 		opts: o,
 		srvs: o.Servers,
@@ -139,13 +168,45 @@ func NewServer(ctx context.Context, o Options) (*Server, error) {
 		log.Debug("Registering reflection service")
 		reflection.Register(server)
 		// Go ahead and start the listener.
-		if o.ListenAddress != "" {
+		if o.Listener != nil {
+			log.Debug("Serving on provided listener", "address", o.Listener.Addr().String())
+			server.lis = newRebindableListener(o.Listener)
+		} else if o.ListenAddress != "" {
 			log.Debug("Starting TCP listener", "address", o.ListenAddress)
 			lis, err := net.Listen("tcp", o.ListenAddress)
 			if err != nil {
 				return nil, fmt.Errorf("start TCP listener: %w", err)
 			}
-			server.lis = lis.(*net.TCPListener)
+			server.lis = newRebindableListener(lis)
+		}
+		if o.InternalListenAddress != "" {
+			log.Debug("Starting internal TCP listener", "address", o.InternalListenAddress)
+			lis, err := net.Listen("tcp", o.InternalListenAddress)
+			if err != nil {
+				if server.lis != nil {
+					_ = server.lis.Close()
+				}
+				return nil, fmt.Errorf("start internal TCP listener: %w", err)
+			}
+			server.internallis = lis.(*net.TCPListener)
+			server.internalsrv = grpc.NewServer(o.ServerOptions...)
+			reflection.Register(server.internalsrv)
+		}
+		if o.ReadOnlyListenAddress != "" {
+			log.Debug("Starting read-only TCP listener", "address", o.ReadOnlyListenAddress)
+			lis, err := net.Listen("tcp", o.ReadOnlyListenAddress)
+			if err != nil {
+				if server.lis != nil {
+					_ = server.lis.Close()
+				}
+				if server.internallis != nil {
+					_ = server.internallis.Close()
+				}
+				return nil, fmt.Errorf("start read-only TCP listener: %w", err)
+			}
+			server.readonlylis = lis.(*net.TCPListener)
+			server.readonlysrv = grpc.NewServer(o.ServerOptions...)
+			reflection.Register(server.readonlysrv)
 		}
 		if o.LibP2POptions != nil {
 			log.Debug("Starting libp2p host listener")
@@ -225,6 +286,26 @@ func (s *Server) ListenAndServe() error {
 			return nil
 		})
 	}
+	if s.internallis != nil {
+		g.Go(func() error {
+			defer s.internallis.Close()
+			s.log.Info(fmt.Sprintf("Starting internal gRPC server on %s", s.internallis.Addr().String()))
+			if err := s.internalsrv.Serve(s.internallis); err != nil {
+				return fmt.Errorf("internal grpc serve: %w", err)
+			}
+			return nil
+		})
+	}
+	if s.readonlylis != nil {
+		g.Go(func() error {
+			defer s.readonlylis.Close()
+			s.log.Info(fmt.Sprintf("Starting read-only gRPC server on %s", s.readonlylis.Addr().String()))
+			if err := s.readonlysrv.Serve(s.readonlylis); err != nil {
+				return fmt.Errorf("read-only grpc serve: %w", err)
+			}
+			return nil
+		})
+	}
 	if s.hostlis != nil {
 		g.Go(func() error {
 			defer s.hostlis.Close()
@@ -235,10 +316,33 @@ func (s *Server) ListenAndServe() error {
 			return nil
 		})
 	}
+	for _, lis := range s.extralis {
+		l := lis
+		g.Go(func() error {
+			defer l.Close()
+			s.log.Info(fmt.Sprintf("Starting gRPC server on %s listener %s", l.Addr().Network(), l.Addr().String()))
+			if err := s.srv.Serve(l); err != nil {
+				return fmt.Errorf("grpc serve: %w", err)
+			}
+			return nil
+		})
+	}
 	s.mu.Unlock()
 	return g.Wait()
 }
 
+// AddListener adds an additional listener to serve the gRPC server on, such
+// as one for connections arriving over WebRTC data channels. It must be
+// called before ListenAndServe.
+func (s *Server) AddListener(lis net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.DisableGRPC {
+		return
+	}
+	s.extralis = append(s.extralis, lis)
+}
+
 // RegisterService implements grpc.RegistrarService.
 func (s *Server) RegisterService(desc *grpc.ServiceDesc, impl any) {
 	if s.opts.DisableGRPC {
@@ -247,6 +351,24 @@ func (s *Server) RegisterService(desc *grpc.ServiceDesc, impl any) {
 	s.srv.RegisterService(desc, impl)
 }
 
+// Internal returns the registrar for internal-only services. If no internal
+// listen address is configured, this is the server itself.
+func (s *Server) Internal() grpc.ServiceRegistrar {
+	if s.internalsrv == nil {
+		return s
+	}
+	return s.internalsrv
+}
+
+// ReadOnly returns the registrar for read-only services. It returns nil if
+// no read-only listen address was configured.
+func (s *Server) ReadOnly() grpc.ServiceRegistrar {
+	if s.readonlysrv == nil {
+		return nil
+	}
+	return s.readonlysrv
+}
+
 // GetServiceInfo implements reflection.ServiceInfoProvider.
 func (s *Server) GetServiceInfo() map[string]grpc.ServiceInfo {
 	if s.opts.DisableGRPC {
@@ -263,11 +385,55 @@ func (s *Server) GRPCListenPort() int {
 	return s.lis.Addr().(*net.TCPAddr).Port
 }
 
+// BindMeshAddresses moves the gRPC listener to the given mesh addresses on the
+// port it is currently listening on. This is used to stop serving the API on
+// public interfaces once the WireGuard interface is up, after the initial
+// listener has been used for bootstrapping. Connections that were already
+// accepted are unaffected.
+func (s *Server) BindMeshAddresses(addrs ...netip.Addr) error {
+	if s.lis == nil {
+		return fmt.Errorf("gRPC listener is not running")
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no mesh addresses to bind to")
+	}
+	port := uint16(s.GRPCListenPort())
+	listenAddrs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		listenAddrs[i] = netip.AddrPortFrom(addr, port).String()
+	}
+	s.log.Info("Binding gRPC server to mesh addresses", slog.Any("addresses", listenAddrs))
+	if err := s.lis.Rebind(listenAddrs); err != nil {
+		return fmt.Errorf("rebind gRPC listener: %w", err)
+	}
+	return nil
+}
+
+// ReadOnlyListenPort returns the port the read-only gRPC server is listening on.
+func (s *Server) ReadOnlyListenPort() int {
+	if s.readonlylis == nil {
+		return 0
+	}
+	return s.readonlylis.Addr().(*net.TCPAddr).Port
+}
+
+// InternalListenPort returns the port the internal gRPC server is listening on.
+func (s *Server) InternalListenPort() int {
+	if s.internallis == nil {
+		return 0
+	}
+	return s.internallis.Addr().(*net.TCPAddr).Port
+}
+
 // Shutdown stops the gRPC server and all mesh services gracefully.
-// You cannot use the server again after calling Stop.
+// New RPCs are refused while in-flight ones are given until the drain
+// timeout or the context is done to finish, after which the server is
+// stopped forcefully. You cannot use the server again after calling Stop.
 func (s *Server) Shutdown(ctx context.Context) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, s.opts.DrainTimeout)
+	defer cancel()
 	for _, srv := range s.srvs {
 		s.log.Debug("Shutting down mesh server")
 		err := srv.Shutdown(ctx)
@@ -279,9 +445,35 @@ func (s *Server) Shutdown(ctx context.Context) {
 		s.log.Info("Shutting down gRPC-web server")
 		if err := s.websrv.Shutdown(ctx); err != nil {
 			s.log.Error("gRPC-web server shutdown failed", slog.String("error", err.Error()))
+			_ = s.websrv.Close()
 		}
 	} else if s.srv != nil {
 		s.log.Info("Shutting down gRPC server")
-		s.srv.GracefulStop()
+		s.drain(ctx, "gRPC", s.srv)
+	}
+	if s.internalsrv != nil {
+		s.log.Info("Shutting down internal gRPC server")
+		s.drain(ctx, "internal gRPC", s.internalsrv)
+	}
+	if s.readonlysrv != nil {
+		s.log.Info("Shutting down read-only gRPC server")
+		s.drain(ctx, "read-only gRPC", s.readonlysrv)
+	}
+}
+
+// drain gracefully stops the given server, falling back to a hard stop
+// if in-flight RPCs do not finish before the context is done.
+func (s *Server) drain(ctx context.Context, name string, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.GracefulStop()
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.log.Warn(fmt.Sprintf("Timed out draining %s server, forcing stop", name))
+		srv.Stop()
+		<-done
 	}
 }
@@ -18,8 +18,20 @@ limitations under the License.
 package services
 
 import (
+	"fmt"
+	"net"
+	"net/netip"
 	"testing"
+	"time"
 
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
 )
 
@@ -47,3 +59,225 @@ func TestGetServerByType(t *testing.T) {
 		t.Fatal("expected server to not be nil")
 	}
 }
+
+type testAdminServer struct {
+	v1.UnimplementedAdminServer
+}
+
+func (testAdminServer) ListRoles(context.Context, *emptypb.Empty) (*v1.Roles, error) {
+	return &v1.Roles{}, nil
+}
+
+func TestInternalListenAddress(t *testing.T) {
+	ctx := context.Background()
+	srv, err := NewServer(ctx, Options{
+		ListenAddress:         "127.0.0.1:0",
+		InternalListenAddress: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	v1.RegisterAdminServer(srv.Internal(), testAdminServer{})
+	go func() { _ = srv.ListenAndServe() }()
+	t.Cleanup(func() { srv.Shutdown(ctx) })
+	if srv.GRPCListenPort() == srv.InternalListenPort() {
+		t.Fatal("expected the internal server to listen on a separate port")
+	}
+
+	listRoles := func(port int) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, fmt.Sprintf("127.0.0.1:%d", port),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = v1.NewAdminClient(conn).ListRoles(ctx, &emptypb.Empty{})
+		return err
+	}
+
+	// The admin API should not be reachable on the main port.
+	err = listRoles(srv.GRPCListenPort())
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented on the main port, got %v", err)
+	}
+	// It should be served on the internal port.
+	if err := listRoles(srv.InternalListenPort()); err != nil {
+		t.Errorf("expected admin API on the internal port, got %v", err)
+	}
+}
+
+type blockingAdminServer struct {
+	v1.UnimplementedAdminServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingAdminServer) ListRoles(ctx context.Context, _ *emptypb.Empty) (*v1.Roles, error) {
+	close(b.started)
+	select {
+	case <-b.release:
+		return &v1.Roles{}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func TestShutdownDrainsInFlightRPCs(t *testing.T) {
+	ctx := context.Background()
+	dial := func(ctx context.Context, port int) (*grpc.ClientConn, error) {
+		return grpc.DialContext(ctx, fmt.Sprintf("127.0.0.1:%d", port),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		)
+	}
+
+	t.Run("CompletesDuringDrain", func(t *testing.T) {
+		srv, err := NewServer(ctx, Options{
+			ListenAddress: "127.0.0.1:0",
+			DrainTimeout:  10 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("new server: %v", err)
+		}
+		admin := &blockingAdminServer{started: make(chan struct{}), release: make(chan struct{})}
+		v1.RegisterAdminServer(srv, admin)
+		go func() { _ = srv.ListenAndServe() }()
+		port := srv.GRPCListenPort()
+
+		dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		conn, err := dial(dialCtx, port)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		rpcErr := make(chan error, 1)
+		go func() {
+			_, err := v1.NewAdminClient(conn).ListRoles(ctx, &emptypb.Empty{})
+			rpcErr <- err
+		}()
+		<-admin.started
+
+		shutdown := make(chan struct{})
+		go func() {
+			defer close(shutdown)
+			srv.Shutdown(ctx)
+		}()
+		// New connections should be refused while draining.
+		time.Sleep(100 * time.Millisecond)
+		refusedCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+		if newConn, err := dial(refusedCtx, port); err == nil {
+			newConn.Close()
+			t.Fatal("expected new connections to be refused while draining")
+		}
+		select {
+		case <-shutdown:
+			t.Fatal("expected shutdown to wait for the in-flight RPC")
+		default:
+		}
+
+		close(admin.release)
+		if err := <-rpcErr; err != nil {
+			t.Errorf("expected in-flight RPC to complete, got %v", err)
+		}
+		select {
+		case <-shutdown:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for shutdown")
+		}
+	})
+
+	t.Run("ForcesStopAfterTimeout", func(t *testing.T) {
+		srv, err := NewServer(ctx, Options{
+			ListenAddress: "127.0.0.1:0",
+			DrainTimeout:  200 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("new server: %v", err)
+		}
+		admin := &blockingAdminServer{started: make(chan struct{}), release: make(chan struct{})}
+		v1.RegisterAdminServer(srv, admin)
+		go func() { _ = srv.ListenAndServe() }()
+
+		dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		conn, err := dial(dialCtx, srv.GRPCListenPort())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		rpcErr := make(chan error, 1)
+		go func() {
+			_, err := v1.NewAdminClient(conn).ListRoles(ctx, &emptypb.Empty{})
+			rpcErr <- err
+		}()
+		<-admin.started
+
+		start := time.Now()
+		srv.Shutdown(ctx)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("expected shutdown to be bounded by the drain timeout, took %s", elapsed)
+		}
+		if err := <-rpcErr; err == nil {
+			t.Error("expected the in-flight RPC to be cut off")
+		}
+	})
+}
+
+func TestBindMeshAddresses(t *testing.T) {
+	ctx := context.Background()
+	// Use a second loopback address to stand in for the mesh address.
+	meshAddr := netip.MustParseAddr("127.0.0.2")
+	if lis, err := net.Listen("tcp", netip.AddrPortFrom(meshAddr, 0).String()); err != nil {
+		t.Skipf("cannot listen on %s: %v", meshAddr, err)
+	} else {
+		lis.Close()
+	}
+	srv, err := NewServer(ctx, Options{
+		ListenAddress: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	v1.RegisterAdminServer(srv, testAdminServer{})
+	go func() { _ = srv.ListenAndServe() }()
+	t.Cleanup(func() { srv.Shutdown(ctx) })
+	port := srv.GRPCListenPort()
+
+	listRoles := func(addr string) error {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, fmt.Sprintf("%s:%d", addr, port),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = v1.NewAdminClient(conn).ListRoles(ctx, &emptypb.Empty{})
+		return err
+	}
+
+	// The bootstrap listener should serve the API before binding.
+	if err := listRoles("127.0.0.1"); err != nil {
+		t.Fatalf("expected API on the bootstrap address, got %v", err)
+	}
+	if err := srv.BindMeshAddresses(meshAddr); err != nil {
+		t.Fatalf("bind mesh addresses: %v", err)
+	}
+	if got := srv.GRPCListenPort(); got != port {
+		t.Errorf("expected port %d to be kept, got %d", port, got)
+	}
+	if err := listRoles(meshAddr.String()); err != nil {
+		t.Errorf("expected API on the mesh address, got %v", err)
+	}
+	if err := listRoles("127.0.0.1"); err == nil {
+		t.Error("expected API to be unreachable on the bootstrap address")
+	}
+}
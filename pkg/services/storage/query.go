@@ -20,7 +20,9 @@ import (
 	"log/slog"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
@@ -37,5 +39,10 @@ func (s *Server) Query(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResp
 		// In theory - non-storage members shouldn't even expose the Node service.
 		return nil, status.Error(codes.Unavailable, "node not available to query")
 	}
-	return rpcsrv.ServeQuery(ctx, s.storage, req), nil
+	if err := s.reportReplicaStatus(ctx, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) }); err != nil {
+		return nil, err
+	}
+	return rpcsrv.ServeQueryWithOptions(ctx, s.storage, req, rpcsrv.Options{
+		RequireSignedRoutes: s.opts.RequireSignedRoutes,
+	}), nil
 }
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"log/slog"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/rpcsrv"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ReadOnlyServer serves storage queries from the local applied state of
+// the node without consulting the leader. It is intended to be exposed on
+// followers to offload read-heavy workloads, such as topology exports and
+// monitoring, from the leader. Every response carries the replica status
+// of the node so clients know how fresh the data is, and clients may bound
+// the staleness they are willing to accept. Writes are rejected.
+type ReadOnlyServer struct {
+	*Server
+}
+
+// NewReadOnlyServer returns a new read-only storage server.
+func NewReadOnlyServer(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator, mnet meshnet.Manager) *ReadOnlyServer {
+	srv := NewServer(ctx, storage, rbac, mnet)
+	srv.log = srv.log.With("read-only", true)
+	return &ReadOnlyServer{Server: srv}
+}
+
+func (s *ReadOnlyServer) Query(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResponse, error) {
+	if !context.IsInNetwork(ctx, s.mnet) {
+		addr, _ := context.PeerAddrFrom(ctx)
+		s.log.Warn("Received Query request from out of network", slog.String("peer", addr.String()))
+		return nil, status.Errorf(codes.PermissionDenied, "request is not in-network")
+	}
+	if !s.storage.Consensus().IsMember() {
+		return nil, status.Error(codes.Unavailable, "node not available to query")
+	}
+	switch req.GetCommand() {
+	case v1.QueryRequest_GET, v1.QueryRequest_LIST:
+	default:
+		return nil, status.Errorf(codes.PermissionDenied, "%s queries are not allowed on a read-only endpoint", req.GetCommand())
+	}
+	if err := s.reportReplicaStatus(ctx, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) }); err != nil {
+		return nil, err
+	}
+	return rpcsrv.ServeQuery(ctx, s.storage, req), nil
+}
+
+func (s *ReadOnlyServer) Publish(ctx context.Context, req *v1.PublishRequest) (*v1.PublishResponse, error) {
+	return nil, status.Error(codes.PermissionDenied, "publish is not allowed on a read-only endpoint")
+}
+
+func (s *ReadOnlyServer) Subscribe(req *v1.SubscribeRequest, srv v1.StorageQueryService_SubscribeServer) error {
+	if err := s.reportReplicaStatus(srv.Context(), srv.SetHeader); err != nil {
+		return err
+	}
+	return s.Server.Subscribe(req, srv)
+}
+
+// reportReplicaStatus sets the replica status of local storage on the
+// response header and enforces any staleness bound requested by the caller.
+func (s *Server) reportReplicaStatus(ctx context.Context, setHeader func(metadata.MD) error) error {
+	var bound time.Duration
+	var hasBound bool
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		var err error
+		bound, hasBound, err = types.MaxStalenessFromHeader(md)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	reporter, ok := s.storage.(storage.ReplicaStatusReporter)
+	if !ok {
+		if hasBound && !s.storage.Consensus().IsLeader() {
+			return status.Error(codes.FailedPrecondition, "storage provider cannot report its staleness")
+		}
+		return nil
+	}
+	replica := reporter.ReplicaStatus()
+	if err := setHeader(replica.Header()); err != nil {
+		s.log.Debug("Failed to set replica status header", slog.String("error", err.Error()))
+	}
+	if hasBound && replica.Staleness > bound {
+		return status.Errorf(codes.FailedPrecondition, "local storage is %s stale, exceeding the requested bound of %s", replica.Staleness, bound)
+	}
+	return nil
+}
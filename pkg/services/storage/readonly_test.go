@@ -0,0 +1,194 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	meshnettest "github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// followerProvider wraps a provider to behave like a follower that is
+// lagging behind the leader.
+type followerProvider struct {
+	storage.Provider
+	status types.ReplicaStatus
+}
+
+func (f *followerProvider) Consensus() storage.Consensus {
+	return followerConsensus{f.Provider.Consensus()}
+}
+
+func (f *followerProvider) ReplicaStatus() types.ReplicaStatus {
+	return f.status
+}
+
+type followerConsensus struct {
+	storage.Consensus
+}
+
+func (followerConsensus) IsLeader() bool { return false }
+
+// headerStream captures the headers set by a unary handler.
+type headerStream struct {
+	header metadata.MD
+}
+
+func (h *headerStream) Method() string { return v1.StorageQueryService_Query_FullMethodName }
+
+func (h *headerStream) SetHeader(md metadata.MD) error {
+	h.header = metadata.Join(h.header, md)
+	return nil
+}
+
+func (h *headerStream) SendHeader(md metadata.MD) error { return h.SetHeader(md) }
+
+func (h *headerStream) SetTrailer(md metadata.MD) error { return nil }
+
+func TestReadOnlyServer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { _ = node.Close(ctx) })
+
+	follower := &followerProvider{
+		Provider: node.Storage(),
+		status: types.ReplicaStatus{
+			AppliedIndex: 40,
+			CommitIndex:  42,
+			Staleness:    5 * time.Second,
+		},
+	}
+	// The test node never starts its network, so serve from a test network
+	// manager on the mesh network to make requests in-network.
+	state, err := node.Storage().MeshDB().MeshState().GetMeshState(ctx)
+	if err != nil {
+		t.Fatalf("get mesh state: %v", err)
+	}
+	nw := meshnettest.NewManagerWithDB(node.Storage().MeshDB(), meshnet.Options{}, node.ID())
+	err = nw.Start(ctx, meshnet.StartOptions{
+		Key:       crypto.MustGenerateKey(),
+		NetworkV4: state.NetworkV4(),
+		NetworkV6: state.NetworkV6(),
+	})
+	if err != nil {
+		t.Fatalf("start network manager: %v", err)
+	}
+	t.Cleanup(func() { _ = nw.Close(ctx) })
+	srv := NewReadOnlyServer(ctx, follower, rbac.NewNoopEvaluator(), nw)
+	peerAddr := nw.NetworkV4().Addr().Next().Next()
+	if !nw.NetworkV4().Contains(peerAddr) {
+		t.Fatalf("expected peer address %s to be in network %s", peerAddr, nw.NetworkV4())
+	}
+
+	newCtx := func(t *testing.T, md metadata.MD) (context.Context, *headerStream) {
+		t.Helper()
+		stream := &headerStream{}
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: peerAddr.AsSlice(), Port: 12345},
+		})
+		ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+		if md != nil {
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+		return ctx, stream
+	}
+
+	t.Run("ServesReads", func(t *testing.T) {
+		t.Parallel()
+		ctx, stream := newCtx(t, nil)
+		res, err := srv.Query(ctx, &v1.QueryRequest{
+			Command: v1.QueryRequest_LIST,
+			Type:    v1.QueryRequest_PEERS,
+		})
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		if res.GetError() != "" {
+			t.Fatalf("query error: %s", res.GetError())
+		}
+		if len(res.GetItems()) == 0 {
+			t.Error("expected the follower to return the local node")
+		}
+		replica, ok, err := types.ReplicaStatusFromHeader(stream.header)
+		if err != nil || !ok {
+			t.Fatalf("expected replica status header, got %v (err: %v)", stream.header, err)
+		}
+		if replica != follower.status {
+			t.Errorf("expected replica status %+v, got %+v", follower.status, replica)
+		}
+		if replica.Lag() != 2 {
+			t.Errorf("expected a lag of 2 logs, got %d", replica.Lag())
+		}
+	})
+
+	t.Run("StalenessBound", func(t *testing.T) {
+		t.Parallel()
+		ctx, _ := newCtx(t, metadata.Pairs(types.MaxStalenessHeader, "1s"))
+		_, err := srv.Query(ctx, &v1.QueryRequest{
+			Command: v1.QueryRequest_LIST,
+			Type:    v1.QueryRequest_PEERS,
+		})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected failed precondition for a stale replica, got %v", err)
+		}
+		ctx, _ = newCtx(t, metadata.Pairs(types.MaxStalenessHeader, "10s"))
+		_, err = srv.Query(ctx, &v1.QueryRequest{
+			Command: v1.QueryRequest_LIST,
+			Type:    v1.QueryRequest_PEERS,
+		})
+		if err != nil {
+			t.Errorf("expected query within the staleness bound to succeed, got %v", err)
+		}
+	})
+
+	t.Run("RejectsWrites", func(t *testing.T) {
+		t.Parallel()
+		ctx, _ := newCtx(t, nil)
+		_, err := srv.Query(ctx, &v1.QueryRequest{
+			Command: v1.QueryRequest_PUT,
+			Type:    v1.QueryRequest_VALUE,
+			Item:    []byte("value"),
+		})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expected permission denied for a put query, got %v", err)
+		}
+		_, err = srv.Publish(ctx, &v1.PublishRequest{Key: []byte("key"), Value: []byte("value")})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expected permission denied for publish, got %v", err)
+		}
+	})
+}
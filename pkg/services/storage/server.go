@@ -38,15 +38,29 @@ type Server struct {
 	storage storage.Provider
 	rbac    rbac.Evaluator
 	mnet    meshnet.Manager
+	opts    Options
 	log     *slog.Logger
 }
 
+// Options are options for the storage Server.
+type Options struct {
+	// RequireSignedRoutes rejects routes put through queries that are not
+	// signed by the node advertising them.
+	RequireSignedRoutes bool
+}
+
 // NewServer returns a new storage Server.
 func NewServer(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator, mnet meshnet.Manager) *Server {
+	return NewServerWithOptions(ctx, storage, rbac, mnet, Options{})
+}
+
+// NewServerWithOptions returns a new storage Server with the given options.
+func NewServerWithOptions(ctx context.Context, storage storage.Provider, rbac rbac.Evaluator, mnet meshnet.Manager, opts Options) *Server {
 	return &Server{
 		storage: storage,
 		rbac:    rbac,
 		mnet:    mnet,
+		opts:    opts,
 		log:     context.LoggerFrom(ctx).With("component", "storage-server"),
 	}
 }
@@ -18,9 +18,12 @@ limitations under the License.
 package webrtc
 
 import (
+	"time"
+
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/datachannels"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
@@ -47,6 +50,18 @@ type Options struct {
 	NodeDialer  transport.NodeDialer
 	RBAC        rbac.Evaluator
 	STUNServers []string
+	// ICEGatheringTimeout is the maximum time to wait for ICE candidate
+	// gathering before proceeding with the candidates gathered so far.
+	// Defaults to datachannels.DefaultICEGatheringTimeout.
+	ICEGatheringTimeout time.Duration
+	// MaxICERestarts is the number of ICE restarts attempted when a
+	// connection fails before it is closed. Defaults to
+	// datachannels.DefaultMaxICERestarts. A negative value disables restarts.
+	MaxICERestarts int
+	// GRPCListener receives connections from peers that request the
+	// datachannels.ProtocolGRPC protocol. It should be served by the
+	// mesh gRPC server. When nil, gRPC over WebRTC is disabled.
+	GRPCListener *datachannels.ConnListener
 }
 
 // NewServer returns a new Server.
@@ -54,6 +69,12 @@ func NewServer(opts Options) *Server {
 	if len(opts.STUNServers) == 0 {
 		opts.STUNServers = DefaultSTUNServers
 	}
+	if opts.ICEGatheringTimeout <= 0 {
+		opts.ICEGatheringTimeout = datachannels.DefaultICEGatheringTimeout
+	}
+	if opts.MaxICERestarts == 0 {
+		opts.MaxICERestarts = datachannels.DefaultMaxICERestarts
+	}
 	return &Server{
 		wg:       opts.Wireguard,
 		rbacEval: opts.RBAC,
@@ -18,12 +18,16 @@ limitations under the License.
 package webrtc
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/pion/webrtc/v3"
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
@@ -99,7 +103,7 @@ func (s *Server) StartDataChannel(stream v1.WebRTC_StartDataChannelServer) error
 		log.Error("Request has invalid port")
 		return status.Error(codes.InvalidArgument, "invalid port provided in request")
 	}
-	if r.GetPort() == 0 && r.GetProto() != "udp" {
+	if r.GetPort() == 0 && r.GetProto() != "udp" && r.GetProto() != datachannels.ProtocolGRPC {
 		log.Error("Request has invalid port")
 		return status.Error(codes.InvalidArgument, "invalid port provided in request")
 	}
@@ -114,6 +118,9 @@ func (s *Server) handleLocalNegotiation(log *slog.Logger, stream v1.WebRTC_Start
 	log.Info("Handling negotiation locally")
 	var conn datachannels.ManagedServerChannel
 	var err error
+	// Sends on the stream can come from the candidate and restart goroutines.
+	var sendmu sync.Mutex
+	answers := make(chan string, 1)
 	if r.GetProto() == "udp" && r.GetPort() == 0 {
 		log.Info("Negotiating WireGuard proxy connection")
 		// Lookup our WireGuard port.
@@ -126,16 +133,32 @@ func (s *Server) handleLocalNegotiation(log *slog.Logger, stream v1.WebRTC_Start
 			return err
 		}
 	} else {
-		log.Info("Negotiating standard WebRTC connection")
-		conn, err = datachannels.NewPeerConnectionServer(stream.Context(), &datachannels.OfferOptions{
-			Proto:       r.GetProto(),
-			SrcAddress:  remoteAddr,
-			DstAddress:  net.JoinHostPort(r.GetDst(), strconv.Itoa(int(r.GetPort()))),
-			STUNServers: s.opts.STUNServers,
-		})
+		opts := &datachannels.OfferOptions{
+			Proto:               r.GetProto(),
+			SrcAddress:          remoteAddr,
+			DstAddress:          net.JoinHostPort(r.GetDst(), strconv.Itoa(int(r.GetPort()))),
+			STUNServers:         s.opts.STUNServers,
+			ICEGatheringTimeout: s.opts.ICEGatheringTimeout,
+		}
+		if r.GetProto() == datachannels.ProtocolGRPC {
+			if s.opts.GRPCListener == nil {
+				return status.Error(codes.Unimplemented, "gRPC over WebRTC is not enabled on this node")
+			}
+			log.Info("Negotiating gRPC WebRTC connection")
+			opts.Handler = s.opts.GRPCListener.Handle
+		} else {
+			log.Info("Negotiating standard WebRTC connection")
+		}
+		conn, err = datachannels.NewPeerConnectionServer(stream.Context(), opts)
 		if err != nil {
 			return err
 		}
+		if s.opts.MaxICERestarts > 0 {
+			pc := conn.(*datachannels.PeerConnectionServer)
+			pc.EnableICERestart(stream.Context(), restartSignaler(stream, &sendmu, answers), datachannels.ICERestartOptions{
+				MaxRestarts: s.opts.MaxICERestarts,
+			})
+		}
 	}
 	go func() {
 		<-conn.Closed()
@@ -186,9 +209,11 @@ func (s *Server) handleLocalNegotiation(log *slog.Logger, stream v1.WebRTC_Start
 				continue
 			}
 			log.Debug("Sending ICE candidate", slog.String("candidate", candidate))
+			sendmu.Lock()
 			err := stream.Send(&v1.DataChannelOffer{
 				Candidate: candidate,
 			})
+			sendmu.Unlock()
 			if err != nil {
 				if status.Code(err) != codes.Canceled {
 					return
@@ -207,6 +232,16 @@ func (s *Server) handleLocalNegotiation(log *slog.Logger, stream v1.WebRTC_Start
 			log.Error("Error receiving ICE candidate", slog.String("error", err.Error()))
 			return err
 		}
+		if candidate.GetAnswer() != "" {
+			// This is an answer to an ICE restart offer.
+			log.Debug("Received ICE restart answer", slog.String("answer", candidate.GetAnswer()))
+			select {
+			case answers <- candidate.GetAnswer():
+			default:
+				log.Warn("Dropping unexpected answer from client")
+			}
+			continue
+		}
 		if candidate.GetCandidate() == "" {
 			continue
 		}
@@ -361,3 +396,34 @@ func (s *Server) handleRemoteNegotiation(log *slog.Logger, clientStream v1.WebRT
 		}
 	}
 }
+
+// restartSignaler returns a signaler that sends ICE restart offers to the
+// client over the negotiation stream and waits for the answer to be
+// delivered on answers.
+func restartSignaler(stream v1.WebRTC_StartDataChannelServer, sendmu *sync.Mutex, answers <-chan string) datachannels.RestartSignaler {
+	return datachannels.RestartSignalerFunc(func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		var answer webrtc.SessionDescription
+		data, err := json.Marshal(offer)
+		if err != nil {
+			return answer, fmt.Errorf("marshal offer: %w", err)
+		}
+		sendmu.Lock()
+		err = stream.Send(&v1.DataChannelOffer{Offer: string(data)})
+		sendmu.Unlock()
+		if err != nil {
+			return answer, fmt.Errorf("send offer: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return answer, ctx.Err()
+		case <-stream.Context().Done():
+			return answer, stream.Context().Err()
+		case data := <-answers:
+			err = json.Unmarshal([]byte(data), &answer)
+			if err != nil {
+				return answer, fmt.Errorf("unmarshal answer: %w", err)
+			}
+			return answer, nil
+		}
+	})
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// BatchOps is a list of queued batch operations. It can be embedded by
+// Batch implementations to handle queueing operations.
+type BatchOps struct {
+	ops    []*v1.RaftLogEntry
+	absent [][]byte
+}
+
+// PutValue queues setting the value of a key.
+func (b *BatchOps) PutValue(key, value []byte, ttl time.Duration) {
+	b.ops = append(b.ops, &v1.RaftLogEntry{
+		Type:  v1.RaftCommandType_PUT,
+		Key:   key,
+		Value: value,
+		Ttl:   durationpb.New(ttl),
+	})
+}
+
+// PutValueIfAbsent queues setting the value of a key that must not exist
+// when the batch is committed.
+func (b *BatchOps) PutValueIfAbsent(key, value []byte, ttl time.Duration) {
+	b.absent = append(b.absent, key)
+	b.PutValue(key, value, ttl)
+}
+
+// Delete queues the removal of a key.
+func (b *BatchOps) Delete(key []byte) {
+	b.ops = append(b.ops, &v1.RaftLogEntry{
+		Type: v1.RaftCommandType_DELETE,
+		Key:  key,
+	})
+}
+
+// Len returns the number of queued operations.
+func (b *BatchOps) Len() int {
+	return len(b.ops)
+}
+
+// Ops returns the queued operations.
+func (b *BatchOps) Ops() []*v1.RaftLogEntry {
+	return b.ops
+}
+
+// Absent returns the keys that must not exist when the batch is committed.
+func (b *BatchOps) Absent() [][]byte {
+	return b.absent
+}
+
+// LogBatch returns the queued operations and their preconditions for
+// replication.
+func (b *BatchOps) LogBatch() LogBatch {
+	return LogBatch{Absent: b.absent, Entries: b.ops}
+}
+
+// LogBatch is a batch of operations as it is replicated between nodes.
+type LogBatch struct {
+	// Absent are the keys that must not exist when the batch is applied.
+	Absent [][]byte
+	// Entries are the operations to apply.
+	Entries []*v1.RaftLogEntry
+}
+
+// CheckAbsent returns ErrKeyExists if any of the given keys exist in storage.
+func CheckAbsent(ctx context.Context, st MeshStorage, keys [][]byte) error {
+	for _, key := range keys {
+		_, err := st.GetValue(ctx, key)
+		if err == nil {
+			return errors.NewKeyExistsError(key)
+		}
+		if !errors.IsKeyNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewSequentialBatch returns a Batch that commits its operations one at a time
+// against the given storage. It does not provide atomicity and is intended for
+// storage implementations that have no way to group writes together. A failed
+// Commit leaves the operations before the failure applied.
+func NewSequentialBatch(st MeshStorage) Batch {
+	return &sequentialBatch{st: st}
+}
+
+type sequentialBatch struct {
+	BatchOps
+	st MeshStorage
+}
+
+// Commit applies the queued operations in order. It stops at the first error.
+// Keys that must be absent are checked before any writes are made, but since
+// the writes are not grouped the check is not atomic with them.
+func (b *sequentialBatch) Commit(ctx context.Context) error {
+	if err := CheckAbsent(ctx, b.st, b.Absent()); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	for _, op := range b.Ops() {
+		var err error
+		switch op.GetType() {
+		case v1.RaftCommandType_PUT:
+			err = b.st.PutValue(ctx, op.GetKey(), op.GetValue(), op.GetTtl().AsDuration())
+		case v1.RaftCommandType_DELETE:
+			err = b.st.Delete(ctx, op.GetKey())
+		default:
+			err = fmt.Errorf("unknown batch operation: %v", op.GetType())
+		}
+		if err != nil {
+			return fmt.Errorf("commit batch: %w", err)
+		}
+	}
+	return nil
+}
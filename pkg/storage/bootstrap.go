@@ -34,6 +34,9 @@ const (
 	DefaultMeshDomain = "webmesh.internal"
 	// DefaultIPv4Network is the default IPv4 network for the mesh.
 	DefaultIPv4Network = "172.16.0.0/12"
+	// DefaultIPv4NodePrefixLength is the default prefix length of the IPv4
+	// subnet allocated to each node.
+	DefaultIPv4NodePrefixLength = 32
 	// DefaultNetworkPolicy is the default network policy for the mesh.
 	DefaultNetworkPolicy = "accept"
 	// DefaultBootstrapListenAddress is the default listen address for the bootstrap transport.
@@ -52,8 +55,15 @@ type BootstrapOptions struct {
 	MeshDomain string
 	// IPv4Network is the IPv4 prefix.
 	IPv4Network string
-	// IPv6Network is the IPv6 prefix. If left unset,
-	// one will be generated.
+	// IPv4NodePrefixLength is the prefix length of the IPv4 subnet
+	// allocated to each node. Defaults to a /32 per node.
+	IPv4NodePrefixLength int
+	// ExpectedNodes is the number of nodes the IPv4 network must have
+	// room for. Defaults to the number of bootstrap nodes.
+	ExpectedNodes int
+	// IPv6Network is the IPv6 prefix. It must be a /48 unique local
+	// address prefix within fd00::/8. If left unset, a random one will
+	// be generated.
 	IPv6Network string
 	// Admin is the admin node ID.
 	Admin string
@@ -71,6 +81,9 @@ func (b *BootstrapOptions) Default() {
 	if b.IPv4Network == "" {
 		b.IPv4Network = DefaultIPv4Network
 	}
+	if b.IPv4NodePrefixLength == 0 {
+		b.IPv4NodePrefixLength = DefaultIPv4NodePrefixLength
+	}
 	if b.MeshDomain == "" {
 		b.MeshDomain = DefaultMeshDomain
 	}
@@ -82,10 +95,38 @@ func (b *BootstrapOptions) Default() {
 	}
 }
 
+// ValidateIPv4Network checks that the given IPv4 network can be split into
+// subnets of the given prefix length for at least expectedNodes nodes.
+// When handing out single addresses, the network address itself is never
+// allocated.
+func ValidateIPv4Network(network netip.Prefix, nodePrefixLength, expectedNodes int) error {
+	if !network.IsValid() || !network.Addr().Is4() {
+		return fmt.Errorf("%s is not a valid IPv4 network", network)
+	}
+	if network.Masked() != network {
+		return fmt.Errorf("%s is not a network address, expected %s", network, network.Masked())
+	}
+	if nodePrefixLength < network.Bits() || nodePrefixLength > 32 {
+		return fmt.Errorf("node prefix length /%d must be between /%d and /32", nodePrefixLength, network.Bits())
+	}
+	available := uint64(1) << (nodePrefixLength - network.Bits())
+	if nodePrefixLength == 32 {
+		available--
+	}
+	need := max(expectedNodes, 1)
+	if uint64(need) > available {
+		return fmt.Errorf("%s only has room for %d /%d subnets, need %d", network, available, nodePrefixLength, need)
+	}
+	return nil
+}
+
 // BoostrapResults are the results of bootstrapping the database.
 type BootstrapResults struct {
 	// NetworkV4 is the IPv4 network.
 	NetworkV4 netip.Prefix
+	// IPv4NodePrefixLength is the prefix length of the IPv4 subnet
+	// allocated to each node.
+	IPv4NodePrefixLength int
 	// NetworkV6 is the IPv6 network.
 	NetworkV6 netip.Prefix
 	// MeshDomain is the mesh domain.
@@ -105,6 +146,7 @@ func Bootstrap(ctx context.Context, db MeshDB, opts *BootstrapOptions) (results
 		return
 	} else if err == nil {
 		results.NetworkV4 = state.NetworkV4()
+		results.IPv4NodePrefixLength = state.NodePrefixLengthV4()
 		results.NetworkV6 = state.NetworkV6()
 		results.MeshDomain = state.Domain()
 		return results, errors.ErrAlreadyBootstrapped
@@ -115,14 +157,23 @@ func Bootstrap(ctx context.Context, db MeshDB, opts *BootstrapOptions) (results
 		err = fmt.Errorf("parse IPv4 network: %w", err)
 		return
 	}
+	results.IPv4NodePrefixLength = opts.IPv4NodePrefixLength
+	expectedNodes := opts.ExpectedNodes
+	if expectedNodes == 0 {
+		expectedNodes = len(opts.BootstrapNodes)
+	}
+	if err = ValidateIPv4Network(results.NetworkV4, opts.IPv4NodePrefixLength, expectedNodes); err != nil {
+		err = fmt.Errorf("invalid IPv4 network: %w", err)
+		return
+	}
 	if opts.IPv6Network != "" {
 		results.NetworkV6, err = netip.ParsePrefix(opts.IPv6Network)
 		if err != nil {
 			err = fmt.Errorf("parse IPv6 network: %w", err)
 			return
 		}
-		if results.NetworkV6.Bits() != netutil.DefaultULABits {
-			err = fmt.Errorf("IPv6 network must be /%d", netutil.DefaultULABits)
+		if err = netutil.ValidateULA(results.NetworkV6); err != nil {
+			err = fmt.Errorf("invalid IPv6 network: %w", err)
 			return
 		}
 	} else {
@@ -140,6 +191,7 @@ func Bootstrap(ctx context.Context, db MeshDB, opts *BootstrapOptions) (results
 			NetworkV6: results.NetworkV6.String(),
 			Domain:    opts.MeshDomain,
 		},
+		IPv4NodePrefixLength: opts.IPv4NodePrefixLength,
 	})
 	if err != nil {
 		err = fmt.Errorf("set network state to db: %w", err)
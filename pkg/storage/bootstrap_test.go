@@ -0,0 +1,155 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+func TestBootstrapULA(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("Supplied", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		want := netip.MustParsePrefix("fdaa:bbcc:ddee::/48")
+		results, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{IPv6Network: want.String()})
+		if err != nil {
+			t.Fatalf("bootstrap: %v", err)
+		}
+		if results.NetworkV6 != want {
+			t.Errorf("expected network %s, got %s", want, results.NetworkV6)
+		}
+		state, err := db.MeshState().GetMeshState(ctx)
+		if err != nil {
+			t.Fatalf("get mesh state: %v", err)
+		}
+		if state.NetworkV6() != want {
+			t.Errorf("expected stored network %s, got %s", want, state.NetworkV6())
+		}
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		results, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{})
+		if err != nil {
+			t.Fatalf("bootstrap: %v", err)
+		}
+		if !netip.MustParsePrefix("fd00::/8").Contains(results.NetworkV6.Addr()) {
+			t.Errorf("expected a generated ULA, got %s", results.NetworkV6)
+		}
+	})
+
+	t.Run("NotULA", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		_, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{IPv6Network: "2001:db8::/48"})
+		if err == nil {
+			t.Fatal("expected bootstrap with a non-ULA prefix to fail")
+		}
+		if _, err := db.MeshState().GetMeshState(ctx); err == nil {
+			t.Error("expected no mesh state to be written")
+		}
+	})
+}
+
+func TestBootstrapIPv4NodePrefixLength(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("CustomPrefix", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		_, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{
+			IPv4Network:          "10.10.0.0/24",
+			IPv4NodePrefixLength: 30,
+			ExpectedNodes:        64,
+		})
+		if err != nil {
+			t.Fatalf("bootstrap: %v", err)
+		}
+		state, err := db.MeshState().GetMeshState(ctx)
+		if err != nil {
+			t.Fatalf("get mesh state: %v", err)
+		}
+		if state.NetworkV4() != netip.MustParsePrefix("10.10.0.0/24") {
+			t.Errorf("expected stored network 10.10.0.0/24, got %s", state.NetworkV4())
+		}
+		if state.NodePrefixLengthV4() != 30 {
+			t.Errorf("expected stored node prefix length 30, got %d", state.NodePrefixLengthV4())
+		}
+	})
+
+	t.Run("TooSmall", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		_, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{
+			IPv4Network:          "10.10.0.0/24",
+			IPv4NodePrefixLength: 30,
+			ExpectedNodes:        65,
+		})
+		if err == nil {
+			t.Fatal("expected bootstrap with too small a network to fail")
+		}
+		if _, err := db.MeshState().GetMeshState(ctx); err == nil {
+			t.Error("expected no mesh state to be written")
+		}
+	})
+}
+
+func TestValidateIPv4Network(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name     string
+		network  string
+		nodeBits int
+		expected int
+		wantErr  bool
+	}{
+		{name: "Default", network: "172.16.0.0/12", nodeBits: 32, expected: 1},
+		{name: "Subnets", network: "10.10.0.0/24", nodeBits: 30, expected: 64},
+		{name: "SingleAddresses", network: "10.10.0.0/30", nodeBits: 32, expected: 3},
+		{name: "TooManyAddresses", network: "10.10.0.0/30", nodeBits: 32, expected: 4, wantErr: true},
+		{name: "TooManySubnets", network: "10.10.0.0/24", nodeBits: 30, expected: 65, wantErr: true},
+		{name: "NodeLargerThanNetwork", network: "10.10.0.0/24", nodeBits: 16, wantErr: true},
+		{name: "NodeTooSmall", network: "10.10.0.0/24", nodeBits: 33, wantErr: true},
+		{name: "NotMasked", network: "10.10.0.1/24", nodeBits: 32, wantErr: true},
+		{name: "IPv6", network: "fd00::/48", nodeBits: 32, wantErr: true},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := storage.ValidateIPv4Network(netip.MustParsePrefix(tt.network), tt.nodeBits, tt.expected)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateIPv4Network() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// changeFeedResyncInterval is how often a change feed checks for new revisions
+// when it has not been notified of any changes. It guards against changes made
+// while a subscription is still being established.
+const changeFeedResyncInterval = 10 * time.Second
+
+// ChangeEvent is a change to a node, route, or network ACL observed by a ChangeFeed.
+type ChangeEvent struct {
+	Change
+	// Revision is the storage revision at which the change was observed. All
+	// events observed at the same revision are delivered together. For raft
+	// storage it is the applied raft index, which is the same on every node.
+	Revision uint64 `json:"revision"`
+	// Node is the node after the change. It is only set for create and update
+	// events on nodes.
+	Node types.MeshNode `json:"node,omitempty"`
+	// Route is the route after the change. It is only set for create and update
+	// events on routes.
+	Route types.Route `json:"route,omitempty"`
+	// NetworkACL is the network ACL after the change. It is only set for create
+	// and update events on network ACLs.
+	NetworkACL types.NetworkACL `json:"networkACL,omitempty"`
+}
+
+// ChangeStream is a stream of ChangeEvents returned by ChangeFeed.
+type ChangeStream struct {
+	events chan ChangeEvent
+	cancel context.CancelFunc
+	err    error
+	mu     sync.Mutex
+}
+
+// Events returns the channel of events. It is closed when the stream is
+// closed or fails.
+func (s *ChangeStream) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Err returns the error that caused the stream to stop, if any. It should be
+// checked after the events channel is closed.
+func (s *ChangeStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close stops the stream.
+func (s *ChangeStream) Close() {
+	s.cancel()
+}
+
+// ChangeFeed returns a stream of changes to nodes, routes, and network ACLs made
+// after the given revision. Changes are found by replaying the storage at each
+// revision against the last one seen, and new revisions are detected with a
+// storage subscription. Intermediate states between two observed revisions are
+// coalesced, so a consumer receives the net change to every object.
+//
+// A consumer can resume after a restart by passing the revision of the last
+// event it fully processed. A revision of zero replays the current contents
+// of the storage as creates. Revisions of raft storage are applied raft
+// indexes, so they describe the same state on every node. Only a bounded
+// window of recent revisions is retained, and on a node other than the one
+// that returned it a revision is only available while it is the last applied
+// index. An unavailable revision fails with ErrInvalidRevision, and the
+// consumer must then resync from zero.
+func ChangeFeed(ctx context.Context, st MeshStorage, sinceRevision uint64) (*ChangeStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	feed := &changeFeed{
+		st:      st,
+		nodes:   NewRegistry[*v1.MeshNode](st, NodesPrefix),
+		acls:    NewRegistry[*v1.NetworkACL](st, NetworkACLsPrefix),
+		routes:  NewRegistry[*v1.Route](st, RoutesPrefix),
+		metrics: NewRegistry[*wrapperspb.UInt32Value](st, RouteMetricsPrefix),
+		exclude: NewRegistry[*wrapperspb.StringValue](st, RouteExclusionsPrefix),
+		sigs:    NewRegistry[*wrapperspb.BytesValue](st, RouteSignaturesPrefix),
+		notify:  make(chan struct{}, 1),
+	}
+	current, err := st.Revision(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("get current revision: %w", err)
+	}
+	if sinceRevision > current {
+		cancel()
+		return nil, fmt.Errorf("%w: %d is newer than the current revision %d", errors.ErrInvalidRevision, sinceRevision, current)
+	}
+	prev := newFeedState()
+	if sinceRevision > 0 {
+		prev, err = feed.stateAt(ctx, sinceRevision)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("replay revision %d: %w", sinceRevision, err)
+		}
+	}
+	// Subscribe before reading any newer revisions so no change is missed.
+	for _, prefix := range []types.StoragePrefix{NodesPrefix, NetworkACLsPrefix, RoutesPrefix, RouteMetricsPrefix, RouteExclusionsPrefix, RouteSignaturesPrefix} {
+		_, err := st.Subscribe(ctx, prefix, func(_, _ []byte) {
+			select {
+			case feed.notify <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("subscribe to %s: %w", prefix, err)
+		}
+	}
+	stream := &ChangeStream{
+		events: make(chan ChangeEvent),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(stream.events)
+		err := feed.run(ctx, sinceRevision, prev, stream.events)
+		if err != nil && ctx.Err() == nil {
+			stream.mu.Lock()
+			stream.err = err
+			stream.mu.Unlock()
+		}
+	}()
+	return stream, nil
+}
+
+type changeFeed struct {
+	st      MeshStorage
+	nodes   *Registry[*v1.MeshNode]
+	acls    *Registry[*v1.NetworkACL]
+	routes  *Registry[*v1.Route]
+	metrics *Registry[*wrapperspb.UInt32Value]
+	exclude *Registry[*wrapperspb.StringValue]
+	sigs    *Registry[*wrapperspb.BytesValue]
+	notify  chan struct{}
+}
+
+func (f *changeFeed) run(ctx context.Context, revision uint64, prev *feedState, out chan<- ChangeEvent) error {
+	t := time.NewTicker(changeFeedResyncInterval)
+	defer t.Stop()
+	for {
+		current, err := f.st.Revision(ctx)
+		if err != nil {
+			return fmt.Errorf("get current revision: %w", err)
+		}
+		if current > revision {
+			next, err := f.stateAt(ctx, current)
+			if err != nil {
+				return fmt.Errorf("replay revision %d: %w", current, err)
+			}
+			for _, event := range prev.diff(next, current) {
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			prev, revision = next, current
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.notify:
+		case <-t.C:
+		}
+	}
+}
+
+// feedState is the state of the objects tracked by a change feed at a revision.
+type feedState struct {
+	nodes  map[string]types.MeshNode
+	routes map[string]types.Route
+	acls   map[string]types.NetworkACL
+}
+
+func newFeedState() *feedState {
+	return &feedState{
+		nodes:  make(map[string]types.MeshNode),
+		routes: make(map[string]types.Route),
+		acls:   make(map[string]types.NetworkACL),
+	}
+}
+
+func (f *changeFeed) stateAt(ctx context.Context, revision uint64) (*feedState, error) {
+	state := newFeedState()
+	err := f.nodes.IterAt(ctx, revision, func(name string, node *v1.MeshNode) error {
+		state.nodes[name] = types.MeshNode{MeshNode: node}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	err = f.acls.IterAt(ctx, revision, func(name string, acl *v1.NetworkACL) error {
+		state.acls[name] = types.NetworkACL{NetworkACL: acl}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list network acls: %w", err)
+	}
+	metrics := make(map[string]uint32)
+	err = f.metrics.IterAt(ctx, revision, func(name string, metric *wrapperspb.UInt32Value) error {
+		metrics[name] = metric.GetValue()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list route metrics: %w", err)
+	}
+	excluded := make(map[string][]string)
+	err = f.exclude.IterAt(ctx, revision, func(name string, cidrs *wrapperspb.StringValue) error {
+		excluded[name] = DecodeRouteExclusions(cidrs)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list route exclusions: %w", err)
+	}
+	signatures := make(map[string][]byte)
+	err = f.sigs.IterAt(ctx, revision, func(name string, sig *wrapperspb.BytesValue) error {
+		signatures[name] = sig.GetValue()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list route signatures: %w", err)
+	}
+	err = f.routes.IterAt(ctx, revision, func(name string, rt *v1.Route) error {
+		state.routes[name] = types.Route{Route: rt, Metric: metrics[name], ExcludedCIDRs: excluded[name], Signature: signatures[name]}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	return state, nil
+}
+
+// diff returns the events that turn s into next, sorted by kind and name.
+func (s *feedState) diff(next *feedState, revision uint64) []ChangeEvent {
+	var events []ChangeEvent
+	events = diffFeedObjects(events, ResourceNode, revision, s.nodes, next.nodes,
+		func(a, b types.MeshNode) bool { return proto.Equal(a.MeshNode, b.MeshNode) },
+		func(ev *ChangeEvent, node types.MeshNode) { ev.Node = node },
+	)
+	events = diffFeedObjects(events, ResourceRoute, revision, s.routes, next.routes,
+		routesEqual,
+		func(ev *ChangeEvent, route types.Route) { ev.Route = route },
+	)
+	events = diffFeedObjects(events, ResourceNetworkACL, revision, s.acls, next.acls,
+		func(a, b types.NetworkACL) bool { return proto.Equal(a.NetworkACL, b.NetworkACL) },
+		func(ev *ChangeEvent, acl types.NetworkACL) { ev.NetworkACL = acl },
+	)
+	slices.SortFunc(events, func(a, b ChangeEvent) int {
+		if a.Kind != b.Kind {
+			return cmp.Compare(a.Kind, b.Kind)
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return events
+}
+
+func diffFeedObjects[T any](events []ChangeEvent, kind ResourceKind, revision uint64, prev, next map[string]T, equal func(a, b T) bool, set func(*ChangeEvent, T)) []ChangeEvent {
+	for name, obj := range next {
+		old, ok := prev[name]
+		if ok && equal(old, obj) {
+			continue
+		}
+		event := ChangeEvent{
+			Change:   Change{Type: ChangeCreate, Kind: kind, Name: name},
+			Revision: revision,
+		}
+		if ok {
+			event.Type = ChangeUpdate
+		}
+		set(&event, obj)
+		events = append(events, event)
+	}
+	for name := range prev {
+		if _, ok := next[name]; ok {
+			continue
+		}
+		events = append(events, ChangeEvent{
+			Change:   Change{Type: ChangeDelete, Kind: kind, Name: name},
+			Revision: revision,
+		})
+	}
+	return events
+}
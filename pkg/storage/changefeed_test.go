@@ -0,0 +1,270 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestChangeFeed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	db := meshdb.NewFromStorage(st)
+
+	putNode := func(id string) {
+		t.Helper()
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	putRoute := func(name, cidr string) {
+		t.Helper()
+		err := db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+			Name: name, Node: "node-a", DestinationCIDRs: []string{cidr},
+		}})
+		if err != nil {
+			t.Fatalf("put route %s: %v", name, err)
+		}
+	}
+	putACL := func(name string, action v1.ACLAction) {
+		t.Helper()
+		err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+			Name: name, Action: action, SourceNodes: []string{"*"},
+		}})
+		if err != nil {
+			t.Fatalf("put network acl %s: %v", name, err)
+		}
+	}
+	// collect reads n events from the stream and then ensures no more arrive.
+	collect := func(stream *storage.ChangeStream, n int) []storage.ChangeEvent {
+		t.Helper()
+		var events []storage.ChangeEvent
+		timeout := time.After(30 * time.Second)
+		for len(events) < n {
+			select {
+			case ev, ok := <-stream.Events():
+				if !ok {
+					t.Fatalf("stream closed after %d events: %v", len(events), stream.Err())
+				}
+				events = append(events, ev)
+			case <-timeout:
+				t.Fatalf("timed out waiting for events, got %v", events)
+			}
+		}
+		select {
+		case ev := <-stream.Events():
+			t.Fatalf("unexpected extra event %v", ev.Change)
+		case <-time.After(100 * time.Millisecond):
+		}
+		return events
+	}
+	changes := func(events []storage.ChangeEvent) []storage.Change {
+		out := make([]storage.Change, len(events))
+		for i, ev := range events {
+			out[i] = ev.Change
+		}
+		return out
+	}
+
+	putNode("node-a")
+	putNode("node-b")
+	putRoute("route-a", "10.0.0.0/24")
+	putACL("acl-a", v1.ACLAction_ACTION_ACCEPT)
+
+	// A feed from the beginning replays the current state as creates.
+	stream, err := storage.ChangeFeed(ctx, st, 0)
+	if err != nil {
+		t.Fatalf("open change feed: %v", err)
+	}
+	events := collect(stream, 4)
+	want := []storage.Change{
+		{Type: storage.ChangeCreate, Kind: storage.ResourceNetworkACL, Name: "acl-a"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceNode, Name: "node-a"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceNode, Name: "node-b"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceRoute, Name: "route-a"},
+	}
+	if got := changes(events); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if events[0].NetworkACL.GetAction() != v1.ACLAction_ACTION_ACCEPT {
+		t.Errorf("expected event to carry the acl, got %v", events[0].NetworkACL)
+	}
+	checkpoint := events[len(events)-1].Revision
+
+	// Live changes are streamed with increasing revisions.
+	putRoute("route-b", "10.0.1.0/24")
+	live := collect(stream, 1)
+	if live[0].Change != (storage.Change{Type: storage.ChangeCreate, Kind: storage.ResourceRoute, Name: "route-b"}) {
+		t.Fatalf("expected route-b to be created, got %v", live[0].Change)
+	}
+	if live[0].Revision <= checkpoint {
+		t.Errorf("expected revision after %d, got %d", checkpoint, live[0].Revision)
+	}
+	checkpoint = live[0].Revision
+
+	// Simulate the consumer going away and changes being made while it is gone.
+	stream.Close()
+	for range stream.Events() {
+	}
+	putNode("node-c")
+	putACL("acl-a", v1.ACLAction_ACTION_DENY)
+	if err := db.Networking().DeleteRoute(ctx, "route-a"); err != nil {
+		t.Fatalf("delete route: %v", err)
+	}
+	if err := db.Peers().Delete(ctx, "node-b"); err != nil {
+		t.Fatalf("delete node: %v", err)
+	}
+
+	// Resuming yields exactly the missed events.
+	stream, err = storage.ChangeFeed(ctx, st, checkpoint)
+	if err != nil {
+		t.Fatalf("resume change feed: %v", err)
+	}
+	t.Cleanup(stream.Close)
+	events = collect(stream, 4)
+	want = []storage.Change{
+		{Type: storage.ChangeUpdate, Kind: storage.ResourceNetworkACL, Name: "acl-a"},
+		{Type: storage.ChangeDelete, Kind: storage.ResourceNode, Name: "node-b"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceNode, Name: "node-c"},
+		{Type: storage.ChangeDelete, Kind: storage.ResourceRoute, Name: "route-a"},
+	}
+	if got := changes(events); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for _, ev := range events {
+		if ev.Revision <= checkpoint {
+			t.Errorf("expected revision after %d, got %d", checkpoint, ev.Revision)
+		}
+	}
+	if events[0].NetworkACL.GetAction() != v1.ACLAction_ACTION_DENY {
+		t.Errorf("expected updated acl, got %v", events[0].NetworkACL)
+	}
+
+	// Revisions the storage does not retain are rejected instead of
+	// replaying the wrong state.
+	for _, rev := range []uint64{1, checkpoint + 1<<32} {
+		if _, err := storage.ChangeFeed(ctx, st, rev); !errors.Is(err, errors.ErrInvalidRevision) {
+			t.Errorf("expected revision %d to be invalid, got %v", rev, err)
+		}
+	}
+}
+
+func TestListPeersChangedSince(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	db := meshdb.NewFromStorage(st)
+
+	putNode := func(id, zoneID string) {
+		t.Helper()
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, ZoneAwarenessID: zoneID}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	type change struct {
+		Type storage.ChangeType
+		ID   types.NodeID
+	}
+	list := func(revision uint64) ([]storage.PeerChange, uint64) {
+		t.Helper()
+		changes, next, err := storage.ListPeersChangedSince(ctx, st, revision)
+		if err != nil {
+			t.Fatalf("list peers changed since %d: %v", revision, err)
+		}
+		return changes, next
+	}
+	summarize := func(changes []storage.PeerChange) []change {
+		out := make([]change, len(changes))
+		for i, c := range changes {
+			out[i] = change{Type: c.Type, ID: c.ID}
+		}
+		return out
+	}
+
+	putNode("node-a", "zone-a")
+	putNode("node-b", "zone-a")
+	putNode("node-c", "zone-a")
+
+	// Starting from zero returns every peer.
+	changes, revision := list(0)
+	want := []change{
+		{Type: storage.ChangeCreate, ID: "node-a"},
+		{Type: storage.ChangeCreate, ID: "node-b"},
+		{Type: storage.ChangeCreate, ID: "node-c"},
+	}
+	if got := summarize(changes); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// Polling again without changes returns nothing.
+	changes, next := list(revision)
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", summarize(changes))
+	}
+	if next != revision {
+		t.Errorf("expected revision %d, got %d", revision, next)
+	}
+
+	// Only the peers changed since the revision are returned.
+	putNode("node-b", "zone-b")
+	putNode("node-d", "zone-a")
+	if err := db.Peers().Delete(ctx, "node-c"); err != nil {
+		t.Fatalf("delete node: %v", err)
+	}
+	// Rewriting a peer without changes is not reported.
+	putNode("node-a", "zone-a")
+	changes, next = list(revision)
+	want = []change{
+		{Type: storage.ChangeUpdate, ID: "node-b"},
+		{Type: storage.ChangeDelete, ID: "node-c"},
+		{Type: storage.ChangeCreate, ID: "node-d"},
+	}
+	if got := summarize(changes); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if next <= revision {
+		t.Errorf("expected revision after %d, got %d", revision, next)
+	}
+	if changes[0].Node.GetZoneAwarenessID() != "zone-b" {
+		t.Errorf("expected updated peer to be returned, got %v", changes[0].Node)
+	}
+	if changes[1].Node.MeshNode != nil {
+		t.Errorf("expected deleted peer to be unset, got %v", changes[1].Node)
+	}
+
+	// Revisions from the future and revisions the storage does not retain
+	// are rejected so the client resyncs instead of applying a wrong delta.
+	for _, rev := range []uint64{next + 100, 1} {
+		if _, _, err := storage.ListPeersChangedSince(ctx, st, rev); !errors.Is(err, errors.ErrInvalidRevision) {
+			t.Errorf("expected revision %d to be invalid, got %v", rev, err)
+		}
+	}
+}
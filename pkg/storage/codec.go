@@ -0,0 +1,131 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// BinaryFormatMarker is prepended to values encoded with the binary codec.
+// It can never be the first byte of a protojson value, which allows readers
+// to decode values written with either codec.
+const BinaryFormatMarker byte = 0x00
+
+// Codec encodes and decodes protobuf messages stored as values in MeshStorage.
+type Codec interface {
+	// Name returns the name of the codec.
+	Name() string
+	// Marshal encodes the given message.
+	Marshal(msg proto.Message) ([]byte, error)
+	// Unmarshal decodes the given data into the message.
+	Unmarshal(data []byte, msg proto.Message) error
+}
+
+var (
+	// ProtoJSONCodec encodes values as protojson. It is human-readable and
+	// useful for debugging.
+	ProtoJSONCodec Codec = protoJSONCodec{}
+	// ProtoBinaryCodec encodes values as binary protobuf prefixed with
+	// BinaryFormatMarker. It is more compact and faster than protojson.
+	ProtoBinaryCodec Codec = protoBinaryCodec{}
+)
+
+// ValueCodecStorage is implemented by MeshStorage implementations that are
+// configured with the codec values are written with. Values are always
+// decoded according to their format marker, so switching from ProtoJSONCodec
+// to ProtoBinaryCodec is safe on a mesh with existing data once every node
+// understands the marker. Nodes that predate ProtoBinaryCodec cannot read
+// binary values. Storage that wraps another MeshStorage should implement this
+// interface and return the codec of the wrapped storage.
+type ValueCodecStorage interface {
+	// ValueCodec returns the codec values are written with.
+	ValueCodec() Codec
+}
+
+// ValueCodecFor returns the codec values are written to the given storage
+// with. ProtoJSONCodec is used unless the storage is configured with another
+// codec.
+func ValueCodecFor(st MeshStorage) Codec {
+	if cs, ok := st.(ValueCodecStorage); ok {
+		if codec := cs.ValueCodec(); codec != nil {
+			return codec
+		}
+	}
+	return ProtoJSONCodec
+}
+
+// CodecByName returns the codec with the given name.
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case ProtoJSONCodec.Name(), "":
+		return ProtoJSONCodec, nil
+	case ProtoBinaryCodec.Name():
+		return ProtoBinaryCodec, nil
+	default:
+		return nil, fmt.Errorf("unknown storage codec: %s", name)
+	}
+}
+
+// MarshalValue encodes the given message with the codec of the storage it
+// is written to.
+func MarshalValue(st MeshStorage, msg proto.Message) ([]byte, error) {
+	return ValueCodecFor(st).Marshal(msg)
+}
+
+// UnmarshalValue decodes the given data into the message. The codec is
+// detected from the format of the data, so values written with any codec
+// known to this version can be read.
+func UnmarshalValue(data []byte, msg proto.Message) error {
+	if len(data) > 0 && data[0] == BinaryFormatMarker {
+		return ProtoBinaryCodec.Unmarshal(data, msg)
+	}
+	return ProtoJSONCodec.Unmarshal(data, msg)
+}
+
+type protoJSONCodec struct{}
+
+func (protoJSONCodec) Name() string { return "json" }
+
+func (protoJSONCodec) Marshal(msg proto.Message) ([]byte, error) {
+	return protojson.Marshal(msg)
+}
+
+func (protoJSONCodec) Unmarshal(data []byte, msg proto.Message) error {
+	return protojson.Unmarshal(data, msg)
+}
+
+type protoBinaryCodec struct{}
+
+func (protoBinaryCodec) Name() string { return "binary" }
+
+func (protoBinaryCodec) Marshal(msg proto.Message) ([]byte, error) {
+	data, err := proto.MarshalOptions{}.MarshalAppend([]byte{BinaryFormatMarker}, msg)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (protoBinaryCodec) Unmarshal(data []byte, msg proto.Message) error {
+	if len(data) == 0 || data[0] != BinaryFormatMarker {
+		return fmt.Errorf("missing binary format marker")
+	}
+	return proto.Unmarshal(data[1:], msg)
+}
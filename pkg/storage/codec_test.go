@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func testACL() *v1.NetworkACL {
+	return &v1.NetworkACL{
+		Name:             "allow-zone-a",
+		Priority:         100,
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"group:zone-a", "node-1", "node-2"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"10.0.0.0/16", "fd00:dead:beef::/48"},
+		DestinationCIDRs: []string{"0.0.0.0/0"},
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	t.Parallel()
+
+	for _, codec := range []Codec{ProtoJSONCodec, ProtoBinaryCodec} {
+		codec := codec
+		t.Run(codec.Name(), func(t *testing.T) {
+			t.Parallel()
+			in := testACL()
+			data, err := codec.Marshal(in)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			// Values from any codec must be readable without knowing the codec.
+			var out v1.NetworkACL
+			if err := UnmarshalValue(data, &out); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !proto.Equal(in, &out) {
+				t.Errorf("expected %v, got %v", in, &out)
+			}
+			got, err := CodecByName(codec.Name())
+			if err != nil {
+				t.Fatalf("codec by name: %v", err)
+			}
+			if got.Name() != codec.Name() {
+				t.Errorf("expected codec %q, got %q", codec.Name(), got.Name())
+			}
+		})
+	}
+
+	t.Run("BinaryRequiresMarker", func(t *testing.T) {
+		t.Parallel()
+		data, err := ProtoJSONCodec.Marshal(testACL())
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		var out v1.NetworkACL
+		if err := ProtoBinaryCodec.Unmarshal(data, &out); err == nil {
+			t.Error("expected error decoding protojson with the binary codec")
+		}
+	})
+
+	t.Run("StorageCodec", func(t *testing.T) {
+		t.Parallel()
+		if got := ValueCodecFor(nil); got.Name() != ProtoJSONCodec.Name() {
+			t.Errorf("expected default codec %q, got %q", ProtoJSONCodec.Name(), got.Name())
+		}
+		st := codecStorage{codec: ProtoBinaryCodec}
+		data, err := MarshalValue(st, testACL())
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if len(data) == 0 || data[0] != BinaryFormatMarker {
+			t.Error("expected value to be written with the storage codec")
+		}
+	})
+
+	t.Run("WrappedStorageCodec", func(t *testing.T) {
+		t.Parallel()
+		st := codecStorage{codec: ProtoBinaryCodec}
+		tenant, err := NewTenantStorage(st, "tenant-a")
+		if err != nil {
+			t.Fatalf("new tenant storage: %v", err)
+		}
+		wrapped := map[string]MeshStorage{
+			"tenant":   tenant,
+			"revision": NewRevisionStorage(st, 1),
+			"dry-run":  &dryRunStorage{MeshStorage: st},
+		}
+		for name, st := range wrapped {
+			if got := ValueCodecFor(st); got.Name() != ProtoBinaryCodec.Name() {
+				t.Errorf("%s: expected codec %q, got %q", name, ProtoBinaryCodec.Name(), got.Name())
+			}
+		}
+	})
+
+	t.Run("UnknownCodec", func(t *testing.T) {
+		t.Parallel()
+		if _, err := CodecByName("yaml"); err == nil {
+			t.Error("expected error for unknown codec")
+		}
+	})
+}
+
+type codecStorage struct {
+	MeshStorage
+	codec Codec
+}
+
+func (c codecStorage) ValueCodec() Codec { return c.codec }
+
+func BenchmarkCodecs(b *testing.B) {
+	acl := testACL()
+	for _, codec := range []Codec{ProtoJSONCodec, ProtoBinaryCodec} {
+		data, err := codec.Marshal(acl)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("%s/Marshal", codec.Name()), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(data)), "bytes/value")
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.Marshal(acl); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("%s/Unmarshal", codec.Name()), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(data)), "bytes/value")
+			for i := 0; i < b.N; i++ {
+				var out v1.NetworkACL
+				if err := UnmarshalValue(data, &out); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
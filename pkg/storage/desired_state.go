@@ -0,0 +1,475 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DesiredState is the complete desired configuration of a mesh. Any object
+// managed by the desired state that is not present in it is removed when the
+// state is applied.
+type DesiredState struct {
+	// NetworkACLs are the desired network ACLs. The bootstrap nodes ACL is
+	// never modified or removed.
+	NetworkACLs types.NetworkACLs `json:"networkACLs,omitempty"`
+	// Routes are the desired routes.
+	Routes types.Routes `json:"routes,omitempty"`
+	// RBAC is the desired RBAC policy. System roles, rolebindings, and groups
+	// are never modified or removed. When nil, RBAC is left untouched.
+	RBAC *types.RBACPolicy `json:"rbac,omitempty"`
+	// Labels are the desired labels for nodes. Nodes that are not present
+	// have their labels removed. When nil, node labels are left untouched.
+	Labels map[types.NodeID]types.Labels `json:"labels,omitempty"`
+}
+
+// ApplyOptions are options for applying a DesiredState.
+type ApplyOptions struct {
+	// DryRun computes and returns the changes that would be made without
+	// writing anything to storage.
+	DryRun bool
+	// RequireSignedRoutes rejects desired routes that are not signed by the
+	// node advertising them.
+	RequireSignedRoutes bool
+}
+
+// ChangeType is the type of change made to an object when applying a DesiredState.
+type ChangeType string
+
+const (
+	// ChangeCreate means the object was created.
+	ChangeCreate ChangeType = "create"
+	// ChangeUpdate means the object was updated.
+	ChangeUpdate ChangeType = "update"
+	// ChangeDelete means the object was deleted.
+	ChangeDelete ChangeType = "delete"
+)
+
+// ResourceKind is the kind of object changed when applying a DesiredState.
+type ResourceKind string
+
+const (
+	// ResourceNetworkACL is a network ACL.
+	ResourceNetworkACL ResourceKind = "network-acl"
+	// ResourceRoute is a route.
+	ResourceRoute ResourceKind = "route"
+	// ResourceRole is an RBAC role.
+	ResourceRole ResourceKind = "role"
+	// ResourceRoleBinding is an RBAC rolebinding.
+	ResourceRoleBinding ResourceKind = "rolebinding"
+	// ResourceGroup is an RBAC group.
+	ResourceGroup ResourceKind = "group"
+	// ResourceNodeLabels are the labels of a node.
+	ResourceNodeLabels ResourceKind = "node-labels"
+	// ResourceNode is a node.
+	ResourceNode ResourceKind = "node"
+)
+
+// Change is a single change made when applying a DesiredState.
+type Change struct {
+	// Type is the type of change.
+	Type ChangeType `json:"type"`
+	// Kind is the kind of object that changed.
+	Kind ResourceKind `json:"kind"`
+	// Name is the name of the object that changed.
+	Name string `json:"name"`
+}
+
+// String returns a string representation of the change.
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s/%s", c.Type, c.Kind, c.Name)
+}
+
+// ApplyResult is the result of applying a DesiredState.
+type ApplyResult struct {
+	// Changes are the changes that were made, sorted by kind and name.
+	// The order is stable, so results can be compared directly.
+	Changes []Change `json:"changes,omitempty"`
+	// DryRun is true if the changes were computed but not written.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Equal returns true if both results contain the same changes.
+func (r *ApplyResult) Equal(other *ApplyResult) bool {
+	return slices.Equal(r.Changes, other.Changes)
+}
+
+// Empty returns true if no changes were made or, for a dry run, would be made.
+func (r *ApplyResult) Empty() bool {
+	return len(r.Changes) == 0
+}
+
+// Count returns the number of changes of the given type.
+func (r *ApplyResult) Count(t ChangeType) int {
+	var n int
+	for _, c := range r.Changes {
+		if c.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+// ApplyDesiredState diffs the desired state against the current contents of the
+// database and applies the differences in a single batch. The desired state is
+// fully validated before anything is written, so either all changes are applied
+// or none are. The database is used for reading current state and the storage
+// for writing changes. When opts.DryRun is set, the changes are computed and
+// returned without being written.
+func ApplyDesiredState(ctx context.Context, db MeshDB, st MeshStorage, desired *DesiredState, opts ApplyOptions) (*ApplyResult, error) {
+	if desired == nil {
+		desired = &DesiredState{}
+	}
+	plan := &desiredStatePlan{
+		st:           st,
+		opts:         opts,
+		batch:        st.Batch(),
+		acls:         NewRegistry[*v1.NetworkACL](st, NetworkACLsPrefix),
+		routes:       NewRegistry[*v1.Route](st, RoutesPrefix),
+		metrics:      NewRegistry[*wrapperspb.UInt32Value](st, RouteMetricsPrefix),
+		exclusions:   NewRegistry[*wrapperspb.StringValue](st, RouteExclusionsPrefix),
+		signatures:   NewRegistry[*wrapperspb.BytesValue](st, RouteSignaturesPrefix),
+		roles:        NewRegistry[*v1.Role](st, RolesPrefix),
+		rolebindings: NewRegistry[*v1.RoleBinding](st, RoleBindingsPrefix),
+		groups:       NewRegistry[*v1.Group](st, GroupsPrefix),
+	}
+	err := plan.diffNetworkACLs(ctx, db, desired.NetworkACLs)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.diffRoutes(ctx, db, desired.Routes)
+	if err != nil {
+		return nil, err
+	}
+	if desired.RBAC != nil {
+		err = plan.diffRBAC(ctx, db, *desired.RBAC)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if desired.Labels != nil {
+		err = plan.diffLabels(ctx, db, desired.Labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.SortFunc(plan.changes, func(a, b Change) int {
+		if a.Kind != b.Kind {
+			return cmp.Compare(a.Kind, b.Kind)
+		}
+		if a.Name != b.Name {
+			return cmp.Compare(a.Name, b.Name)
+		}
+		return cmp.Compare(a.Type, b.Type)
+	})
+	result := &ApplyResult{Changes: plan.changes, DryRun: opts.DryRun}
+	if opts.DryRun || plan.batch.Len() == 0 {
+		return result, nil
+	}
+	err = plan.batch.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("commit desired state: %w", err)
+	}
+	return result, nil
+}
+
+type desiredStatePlan struct {
+	st           MeshStorage
+	opts         ApplyOptions
+	batch        Batch
+	changes      []Change
+	acls         *Registry[*v1.NetworkACL]
+	routes       *Registry[*v1.Route]
+	metrics      *Registry[*wrapperspb.UInt32Value]
+	exclusions   *Registry[*wrapperspb.StringValue]
+	signatures   *Registry[*wrapperspb.BytesValue]
+	roles        *Registry[*v1.Role]
+	rolebindings *Registry[*v1.RoleBinding]
+	groups       *Registry[*v1.Group]
+}
+
+func (p *desiredStatePlan) record(t ChangeType, kind ResourceKind, name string) {
+	p.changes = append(p.changes, Change{Type: t, Kind: kind, Name: name})
+}
+
+// diffObjects compares the current and desired objects of a kind by name and
+// calls put for every object that must be written and del for every object
+// that must be removed. Objects for which skip returns true are never touched.
+func diffObjects[T any](p *desiredStatePlan, kind ResourceKind, current, desired map[string]T, equal func(a, b T) bool, skip func(string) bool, put func(T) error, del func(string)) error {
+	for _, name := range sortedKeys(desired) {
+		if skip(name) {
+			continue
+		}
+		want := desired[name]
+		have, ok := current[name]
+		if ok && equal(have, want) {
+			continue
+		}
+		if err := put(want); err != nil {
+			return fmt.Errorf("put %s %q: %w", kind, name, err)
+		}
+		if ok {
+			p.record(ChangeUpdate, kind, name)
+		} else {
+			p.record(ChangeCreate, kind, name)
+		}
+	}
+	for _, name := range sortedKeys(current) {
+		if _, ok := desired[name]; ok || skip(name) {
+			continue
+		}
+		del(name)
+		p.record(ChangeDelete, kind, name)
+	}
+	return nil
+}
+
+// indexByName indexes the given objects by name, returning an error on duplicates.
+func indexByName[T interface{ GetName() string }](kind ResourceKind, objs []T) (map[string]T, error) {
+	out := make(map[string]T, len(objs))
+	for _, obj := range objs {
+		if _, ok := out[obj.GetName()]; ok {
+			return nil, fmt.Errorf("duplicate %s %q in desired state", kind, obj.GetName())
+		}
+		out[obj.GetName()] = obj
+	}
+	return out, nil
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	out := make([]K, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func neverSkip(string) bool { return false }
+
+// routesEqual reports if two routes are equal including the fields stored
+// outside of the Route protobuf.
+func routesEqual(a, b types.Route) bool {
+	return a.Metric == b.Metric &&
+		slices.Equal(a.ExcludedCIDRs, b.ExcludedCIDRs) &&
+		bytes.Equal(a.Signature, b.Signature) &&
+		proto.Equal(a.Route, b.Route)
+}
+
+func (p *desiredStatePlan) diffNetworkACLs(ctx context.Context, db MeshDB, acls types.NetworkACLs) error {
+	for _, acl := range acls {
+		if err := types.ValidateACL(acl); err != nil {
+			return fmt.Errorf("%w: %w", errors.ErrInvalidACL, err)
+		}
+	}
+	desired, err := indexByName(ResourceNetworkACL, acls)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidACL, err)
+	}
+	list, err := db.Networking().ListNetworkACLs(ctx)
+	if err != nil {
+		return fmt.Errorf("list network acls: %w", err)
+	}
+	current, _ := indexByName(ResourceNetworkACL, list)
+	return diffObjects(p, ResourceNetworkACL, current, desired,
+		func(a, b types.NetworkACL) bool { return proto.Equal(a.NetworkACL, b.NetworkACL) },
+		func(name string) bool { return name == string(BootstrapNodesNetworkACLName) },
+		func(acl types.NetworkACL) error { return p.acls.PutInBatch(p.batch, acl.GetName(), acl.NetworkACL) },
+		func(name string) { p.acls.DeleteInBatch(p.batch, name) },
+	)
+}
+
+func (p *desiredStatePlan) diffRoutes(ctx context.Context, db MeshDB, routes types.Routes) error {
+	for _, route := range routes {
+		if err := types.ValidateRoute(route); err != nil {
+			return fmt.Errorf("%w: %w", errors.ErrInvalidRoute, err)
+		}
+		if err := CheckRouteSigned(route, p.opts.RequireSignedRoutes); err != nil {
+			return err
+		}
+		if route.IsSigned() {
+			node, err := db.Peers().Get(ctx, types.NodeID(route.GetNode()))
+			if err != nil && !errors.IsNodeNotFound(err) {
+				return fmt.Errorf("get route node: %w", err)
+			}
+			if err := VerifyRouteSignature(route, node); err != nil {
+				return err
+			}
+			if err := CheckRouteSignatureVersion(ctx, p.st, route); err != nil {
+				return err
+			}
+		}
+	}
+	desired, err := indexByName(ResourceRoute, routes)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidRoute, err)
+	}
+	list, err := db.Networking().ListRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("list routes: %w", err)
+	}
+	current, _ := indexByName(ResourceRoute, list)
+	return diffObjects(p, ResourceRoute, current, desired,
+		routesEqual,
+		neverSkip,
+		func(route types.Route) error {
+			err := p.routes.PutInBatch(p.batch, route.GetName(), route.Route)
+			if err != nil {
+				return err
+			}
+			if len(route.ExcludedCIDRs) > 0 {
+				err = p.exclusions.PutInBatch(p.batch, route.GetName(), EncodeRouteExclusions(route.ExcludedCIDRs))
+				if err != nil {
+					return err
+				}
+			} else {
+				p.exclusions.DeleteInBatch(p.batch, route.GetName())
+			}
+			if route.IsSigned() {
+				err = p.signatures.PutInBatch(p.batch, route.GetName(), wrapperspb.Bytes(route.Signature))
+				if err != nil {
+					return err
+				}
+				err = PutRouteSignatureVersionInBatch(p.st, p.batch, route)
+				if err != nil {
+					return err
+				}
+			} else {
+				p.signatures.DeleteInBatch(p.batch, route.GetName())
+			}
+			if route.Metric > 0 {
+				return p.metrics.PutInBatch(p.batch, route.GetName(), wrapperspb.UInt32(route.Metric))
+			}
+			p.metrics.DeleteInBatch(p.batch, route.GetName())
+			return nil
+		},
+		func(name string) {
+			p.routes.DeleteInBatch(p.batch, name)
+			p.metrics.DeleteInBatch(p.batch, name)
+			p.exclusions.DeleteInBatch(p.batch, name)
+			p.signatures.DeleteInBatch(p.batch, name)
+		},
+	)
+}
+
+func (p *desiredStatePlan) diffRBAC(ctx context.Context, db MeshDB, policy types.RBACPolicy) error {
+	err := policy.Validate()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidRBACPolicy, err)
+	}
+	current, err := ExportRBAC(ctx, db.RBAC())
+	if err != nil {
+		return fmt.Errorf("export current rbac: %w", err)
+	}
+	err = validateRBACReferences(current, policy, RBACImportReplace)
+	if err != nil {
+		return err
+	}
+	desiredRoles, err := indexByName(ResourceRole, policy.Roles)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidRBACPolicy, err)
+	}
+	desiredRoleBindings, err := indexByName(ResourceRoleBinding, policy.RoleBindings)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidRBACPolicy, err)
+	}
+	desiredGroups, err := indexByName(ResourceGroup, policy.Groups)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidRBACPolicy, err)
+	}
+	currentRoles, _ := indexByName(ResourceRole, current.Roles)
+	currentRoleBindings, _ := indexByName(ResourceRoleBinding, current.RoleBindings)
+	currentGroups, _ := indexByName(ResourceGroup, current.Groups)
+	err = diffObjects(p, ResourceRole, currentRoles, desiredRoles,
+		func(a, b types.Role) bool { return proto.Equal(a.Role, b.Role) },
+		IsSystemRole,
+		func(role types.Role) error { return p.roles.PutInBatch(p.batch, role.GetName(), role.Role) },
+		func(name string) { p.roles.DeleteInBatch(p.batch, name) },
+	)
+	if err != nil {
+		return err
+	}
+	err = diffObjects(p, ResourceRoleBinding, currentRoleBindings, desiredRoleBindings,
+		func(a, b types.RoleBinding) bool { return proto.Equal(a.RoleBinding, b.RoleBinding) },
+		IsSystemRoleBinding,
+		func(rb types.RoleBinding) error {
+			return p.rolebindings.PutInBatch(p.batch, rb.GetName(), rb.RoleBinding)
+		},
+		func(name string) { p.rolebindings.DeleteInBatch(p.batch, name) },
+	)
+	if err != nil {
+		return err
+	}
+	return diffObjects(p, ResourceGroup, currentGroups, desiredGroups,
+		func(a, b types.Group) bool { return proto.Equal(a.Group, b.Group) },
+		IsSystemGroup,
+		func(group types.Group) error { return p.groups.PutInBatch(p.batch, group.GetName(), group.Group) },
+		func(name string) { p.groups.DeleteInBatch(p.batch, name) },
+	)
+}
+
+func (p *desiredStatePlan) diffLabels(ctx context.Context, db MeshDB, labels map[types.NodeID]types.Labels) error {
+	nodes, err := db.Peers().List(ctx)
+	if err != nil {
+		return fmt.Errorf("list nodes: %w", err)
+	}
+	exists := make(map[types.NodeID]struct{}, len(nodes))
+	for _, node := range nodes {
+		exists[node.NodeID()] = struct{}{}
+	}
+	for _, id := range sortedKeys(labels) {
+		if _, ok := exists[id]; !ok {
+			return fmt.Errorf("set labels for %q: %w", id, errors.ErrNodeNotFound)
+		}
+		if err := types.ValidateLabels(labels[id]); err != nil {
+			return fmt.Errorf("set labels for %q: %w", id, err)
+		}
+	}
+	slices.SortFunc(nodes, func(a, b types.MeshNode) int {
+		return cmp.Compare(a.NodeID(), b.NodeID())
+	})
+	for _, node := range nodes {
+		want := labels[node.NodeID()]
+		if maps.Equal(node.Labels(), want) {
+			continue
+		}
+		zoneID, err := types.EncodeZoneAwarenessID(node.Zones(), want)
+		if err != nil {
+			return fmt.Errorf("set labels for %q: %w", node.NodeID(), err)
+		}
+		updated := node.DeepCopy()
+		updated.ZoneAwarenessID = zoneID
+		if err := PutNodeInBatch(p.batch, updated); err != nil {
+			return fmt.Errorf("set labels for %q: %w", node.NodeID(), err)
+		}
+		p.record(ChangeUpdate, ResourceNodeLabels, node.NodeID().String())
+	}
+	return nil
+}
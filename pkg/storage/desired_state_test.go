@@ -0,0 +1,284 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestApplyDesiredState(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	db := meshdb.NewFromStorage(st)
+
+	for _, node := range []types.MeshNode{
+		{MeshNode: &v1.MeshNode{Id: "node-a", ZoneAwarenessID: "zone-a,role=old"}},
+		{MeshNode: &v1.MeshNode{Id: "node-b", ZoneAwarenessID: "zone-b"}},
+	} {
+		if err := db.Peers().Put(ctx, node); err != nil {
+			t.Fatalf("put node %s: %v", node.GetId(), err)
+		}
+	}
+	// Objects that exist before the desired state is applied.
+	err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:        "stale-acl",
+		Action:      v1.ACLAction_ACTION_DENY,
+		SourceNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name: "site", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/24"},
+	}})
+	if err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+
+	policy := testRBACPolicy()
+	desired := &storage.DesiredState{
+		NetworkACLs: types.NetworkACLs{{NetworkACL: &v1.NetworkACL{
+			Name:             "allow-all",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+		}}},
+		Routes: types.Routes{
+			{Route: &v1.Route{Name: "site", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/16"}}, Metric: 10},
+			{Route: &v1.Route{Name: "default", Node: "node-b", DestinationCIDRs: []string{"0.0.0.0/0"}}},
+		},
+		RBAC: &policy,
+		Labels: map[types.NodeID]types.Labels{
+			"node-b": {"role": "gateway"},
+		},
+	}
+
+	result, err := storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("apply desired state: %v", err)
+	}
+	want := []storage.Change{
+		{Type: storage.ChangeCreate, Kind: storage.ResourceGroup, Name: "admins"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceNetworkACL, Name: "allow-all"},
+		{Type: storage.ChangeDelete, Kind: storage.ResourceNetworkACL, Name: "stale-acl"},
+		{Type: storage.ChangeUpdate, Kind: storage.ResourceNodeLabels, Name: "node-a"},
+		{Type: storage.ChangeUpdate, Kind: storage.ResourceNodeLabels, Name: "node-b"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceRole, Name: "acl-viewer"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceRole, Name: "route-admin"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceRoleBinding, Name: "route-admins"},
+		{Type: storage.ChangeCreate, Kind: storage.ResourceRoute, Name: "default"},
+		{Type: storage.ChangeUpdate, Kind: storage.ResourceRoute, Name: "site"},
+	}
+	if !slices.Equal(result.Changes, want) {
+		t.Fatalf("expected changes %v, got %v", want, result.Changes)
+	}
+
+	// The database should now match the desired state.
+	if _, err := db.Networking().GetNetworkACL(ctx, "stale-acl"); !errors.Is(err, errors.ErrACLNotFound) {
+		t.Errorf("expected stale-acl to be deleted, got %v", err)
+	}
+	route, err := db.Networking().GetRoute(ctx, "site")
+	if err != nil {
+		t.Fatalf("get route: %v", err)
+	}
+	if route.Metric != 10 || !slices.Equal(route.GetDestinationCIDRs(), []string{"10.0.0.0/16"}) {
+		t.Errorf("expected route to be updated, got %v (metric %d)", route.Route, route.Metric)
+	}
+	nodeA, err := db.Peers().Get(ctx, "node-a")
+	if err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if len(nodeA.Labels()) != 0 || !slices.Equal(nodeA.Zones(), []string{"zone-a"}) {
+		t.Errorf("expected node-a labels removed and zones kept, got %q", nodeA.GetZoneAwarenessID())
+	}
+	nodeB, err := db.Peers().Get(ctx, "node-b")
+	if err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if nodeB.Labels()["role"] != "gateway" || !slices.Equal(nodeB.Zones(), []string{"zone-b"}) {
+		t.Errorf("expected node-b to be labeled, got %q", nodeB.GetZoneAwarenessID())
+	}
+
+	// Applying the same state again should converge with no changes.
+	result, err = storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("reapply desired state: %v", err)
+	}
+	if !result.Empty() {
+		t.Errorf("expected no changes on reapply, got %v", result.Changes)
+	}
+
+	// Removing objects from the desired state deletes them. System RBAC
+	// objects are never removed.
+	desired = &storage.DesiredState{RBAC: &types.RBACPolicy{}}
+	result, err = storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("apply empty desired state: %v", err)
+	}
+	if got := result.Count(storage.ChangeDelete); got != len(result.Changes) || got != 7 {
+		t.Errorf("expected 7 deletions, got %v", result.Changes)
+	}
+	routes, err := db.Networking().ListRoutes(ctx)
+	if err != nil {
+		t.Fatalf("list routes: %v", err)
+	}
+	if len(routes) != 0 {
+		t.Errorf("expected all routes to be deleted, got %d", len(routes))
+	}
+	roles, err := db.RBAC().ListRoles(ctx)
+	if err != nil {
+		t.Fatalf("list roles: %v", err)
+	}
+	for _, role := range roles {
+		if !storage.IsSystemRole(role.GetName()) {
+			t.Errorf("expected role %q to be deleted", role.GetName())
+		}
+	}
+	// Labels were left unmanaged.
+	nodeB, err = db.Peers().Get(ctx, "node-b")
+	if err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if nodeB.Labels()["role"] != "gateway" {
+		t.Errorf("expected unmanaged labels to be kept, got %q", nodeB.GetZoneAwarenessID())
+	}
+
+	// Invalid desired states are rejected without writing anything.
+	desired = &storage.DesiredState{
+		Routes: types.Routes{{Route: &v1.Route{Name: "valid", Node: "node-a", DestinationCIDRs: []string{"10.1.0.0/16"}}}},
+		NetworkACLs: types.NetworkACLs{{NetworkACL: &v1.NetworkACL{
+			Name:        "invalid",
+			SourceCIDRs: []string{"10.0.0.0/33"},
+		}}},
+	}
+	if _, err := storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{}); !errors.Is(err, errors.ErrInvalidACL) {
+		t.Fatalf("expected invalid acl error, got %v", err)
+	}
+	if _, err := db.Networking().GetRoute(ctx, "valid"); !errors.Is(err, errors.ErrRouteNotFound) {
+		t.Errorf("expected nothing to be written, got %v", err)
+	}
+}
+
+func TestApplyDesiredStateDryRun(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	db := meshdb.NewFromStorage(st)
+
+	if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-a", ZoneAwarenessID: "zone-a"}}); err != nil {
+		t.Fatalf("put node: %v", err)
+	}
+	err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:        "stale-acl",
+		Action:      v1.ACLAction_ACTION_DENY,
+		SourceNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name: "site", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/24"},
+	}})
+	if err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+
+	dump := func() map[string]string {
+		t.Helper()
+		out := make(map[string]string)
+		err := st.IterPrefix(ctx, types.RegistryPrefix, func(key, value []byte) error {
+			out[string(key)] = string(value)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("iterate storage: %v", err)
+		}
+		return out
+	}
+
+	policy := testRBACPolicy()
+	desired := &storage.DesiredState{
+		Routes: types.Routes{
+			{Route: &v1.Route{Name: "site", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/16"}}},
+			{Route: &v1.Route{Name: "default", Node: "node-a", DestinationCIDRs: []string{"0.0.0.0/0"}}},
+		},
+		RBAC:   &policy,
+		Labels: map[types.NodeID]types.Labels{"node-a": {"role": "gateway"}},
+	}
+
+	before := dump()
+	plan, err := storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !plan.DryRun {
+		t.Error("expected result to be marked as a dry run")
+	}
+	if plan.Empty() {
+		t.Fatal("expected dry run to report changes")
+	}
+	if after := dump(); !maps.Equal(before, after) {
+		t.Fatal("expected dry run to write nothing")
+	}
+
+	// Repeated dry runs are stable.
+	again, err := storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !plan.Equal(again) {
+		t.Errorf("expected stable dry run results, got %v and %v", plan.Changes, again.Changes)
+	}
+
+	// The real apply executes exactly the planned changes.
+	applied, err := storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("apply desired state: %v", err)
+	}
+	if applied.DryRun {
+		t.Error("expected result not to be marked as a dry run")
+	}
+	if !plan.Equal(applied) {
+		t.Errorf("expected applied changes %v to match plan %v", applied.Changes, plan.Changes)
+	}
+	if after := dump(); maps.Equal(before, after) {
+		t.Error("expected apply to write changes")
+	}
+
+	// A dry run after convergence plans nothing.
+	plan, err = storage.ApplyDesiredState(ctx, db, st, desired, storage.ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("expected no planned changes after apply, got %v", plan.Changes)
+	}
+}
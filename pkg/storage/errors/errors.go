@@ -22,11 +22,16 @@ import (
 	"fmt"
 
 	"github.com/dominikbraun/graph"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Is is a shortcut for errors.Is.
 var Is = errors.Is
 
+// As is a shortcut for errors.As.
+var As = errors.As
+
 // Common errors for storage providers to use.
 var (
 	// ErrNodeNotFound is returned when a node is not found.
@@ -51,10 +56,18 @@ var (
 	ErrKeyNotFound = errors.New("key not found")
 	// ErrNotFound is an alias to ErrKeyNotFound
 	ErrNotFound = ErrKeyNotFound
+	// ErrKeyExists is the error returned when a key that must not exist already does.
+	ErrKeyExists = errors.New("key already exists")
+	// ErrNodeExists is returned when creating a node that already exists.
+	ErrNodeExists = errors.New("node already exists")
 	// ErrInvalidKey is the error returned when a key is invalid.
 	ErrInvalidKey = errors.New("invalid key")
 	// ErrInvalidPrefix is the error returned when a prefix is invalid.
 	ErrInvalidPrefix = errors.New("invalid prefix")
+	// ErrInvalidRevision is the error returned when a revision is invalid.
+	ErrInvalidRevision = errors.New("invalid revision")
+	// ErrReadOnly is returned when writing to a read-only view of storage.
+	ErrReadOnly = errors.New("storage is read-only")
 	// ErrEdgeNotFound is returned when an edge is not found.
 	ErrEdgeNotFound = graph.ErrEdgeNotFound
 	// ErrRoleNotFound is returned when a role is not found.
@@ -77,12 +90,31 @@ var (
 	ErrInvalidACL = errors.New("invalid network acl")
 	// ErrInvalidRoute is returned when a Route is invalid.
 	ErrInvalidRoute = errors.New("invalid route")
+	// ErrRouteLimitExceeded is returned when a node would advertise more
+	// routes than it is allowed to.
+	ErrRouteLimitExceeded = errors.New("route limit exceeded")
+	// ErrInvalidRouteSignature is returned when a Route carries a signature
+	// that was not made by the node advertising it.
+	ErrInvalidRouteSignature = errors.New("invalid route signature")
+	// ErrInvalidRBACPolicy is returned when an RBAC policy is invalid.
+	ErrInvalidRBACPolicy = errors.New("invalid rbac policy")
+	// ErrPermissionDenied is returned when an identity is not allowed to perform an action.
+	ErrPermissionDenied = errors.New("permission denied")
 	// ErrEmptyNodeID is returned when a node ID is empty.
 	ErrEmptyNodeID = errors.New("node ID must not be empty")
 	// ErrInvalidNodeID is returned when a node ID is invalid.
 	ErrInvalidNodeID = errors.New("node ID is invalid")
 	// ErrInvalidQuery is returned when a query is invalid.
 	ErrInvalidQuery = errors.New("invalid query")
+	// ErrRotationInProgress is returned when a preshared key rotation is
+	// started on an edge with a rotation that has not become active yet.
+	ErrRotationInProgress = errors.New("preshared key rotation in progress")
+	// ErrNodeRegistrationNotFound is returned when a node has not been registered.
+	ErrNodeRegistrationNotFound = errors.New("node registration not found")
+	// ErrInsufficientDiskSpace is returned when writes are refused because the
+	// storage volume is low on space. It carries the ResourceExhausted code so
+	// it is reported correctly over gRPC.
+	ErrInsufficientDiskSpace = status.Error(codes.ResourceExhausted, "insufficient disk space")
 )
 
 // NewKeyNotFoundError returns a new ErrKeyNotFound error.
@@ -90,6 +122,11 @@ func NewKeyNotFoundError(key []byte) error {
 	return fmt.Errorf("%w: %s", ErrKeyNotFound, string(key))
 }
 
+// NewKeyExistsError returns a new ErrKeyExists error.
+func NewKeyExistsError(key []byte) error {
+	return fmt.Errorf("%w: %s", ErrKeyExists, string(key))
+}
+
 // IsNotFound returns if the error matches any of the known not found errors.
 func IsNotFound(err error) bool {
 	return IsKeyNotFound(err) ||
@@ -99,7 +136,8 @@ func IsNotFound(err error) bool {
 		IsRoleBindingNotFound(err) ||
 		IsGroupNotFound(err) ||
 		IsACLNotFound(err) ||
-		IsRouteNotFound(err)
+		IsRouteNotFound(err) ||
+		IsNodeRegistrationNotFound(err)
 }
 
 // IsKeyNotFoundError returns true if the given error is a ErrKeyNotFound error.
@@ -112,6 +150,21 @@ func IsNodeNotFound(err error) bool {
 	return Is(err, ErrNodeNotFound)
 }
 
+// IsKeyExists returns true if the given error is a ErrKeyExists error.
+func IsKeyExists(err error) bool {
+	return Is(err, ErrKeyExists)
+}
+
+// IsNodeExists returns true if the given error is a ErrNodeExists error.
+func IsNodeExists(err error) bool {
+	return Is(err, ErrNodeExists)
+}
+
+// IsNodeRegistrationNotFound returns true if the given error is a ErrNodeRegistrationNotFound error.
+func IsNodeRegistrationNotFound(err error) bool {
+	return Is(err, ErrNodeRegistrationNotFound)
+}
+
 // IsAlreadyBootstrappedError returns true if the given error is a ErrAlreadyBootstrapped error.
 func IsAlreadyBootstrapped(err error) bool {
 	return Is(err, ErrAlreadyBootstrapped)
@@ -132,6 +185,11 @@ func IsInvalidRoute(err error) bool {
 	return Is(err, ErrInvalidRoute)
 }
 
+// IsInvalidRouteSignature returns true if the given error is a ErrInvalidRouteSignature error.
+func IsInvalidRouteSignature(err error) bool {
+	return Is(err, ErrInvalidRouteSignature)
+}
+
 // IsRouteNotFound returns true if the given error is a ErrRouteNotFound error.
 func IsRouteNotFound(err error) bool {
 	return Is(err, ErrRouteNotFound)
@@ -157,7 +215,39 @@ func IsGroupNotFound(err error) bool {
 	return Is(err, ErrGroupNotFound)
 }
 
+// IsPermissionDenied returns true if the given error is a ErrPermissionDenied error.
+func IsPermissionDenied(err error) bool {
+	return Is(err, ErrPermissionDenied)
+}
+
 // IsNoLeader returns true if the given error is a ErrNoLeader error.
 func IsNoLeader(err error) bool {
 	return Is(err, ErrNoLeader)
 }
+
+// RouteLimitError is returned when a node would advertise more destination
+// CIDRs across its routes than the configured limit. It matches
+// ErrRouteLimitExceeded.
+type RouteLimitError struct {
+	// NodeID is the node advertising the routes.
+	NodeID string
+	// Limit is the maximum number of destination CIDRs the node may advertise.
+	Limit int
+	// Count is the number of destination CIDRs the node would advertise.
+	Count int
+}
+
+// Error implements error.
+func (e *RouteLimitError) Error() string {
+	return fmt.Sprintf("%s: node %q would advertise %d routes, the limit is %d", ErrRouteLimitExceeded, e.NodeID, e.Count, e.Limit)
+}
+
+// Unwrap returns ErrRouteLimitExceeded.
+func (e *RouteLimitError) Unwrap() error {
+	return ErrRouteLimitExceeded
+}
+
+// IsRouteLimitExceeded returns true if the given error is a ErrRouteLimitExceeded error.
+func IsRouteLimitExceeded(err error) bool {
+	return Is(err, ErrRouteLimitExceeded)
+}
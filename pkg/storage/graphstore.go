@@ -18,6 +18,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/dominikbraun/graph"
 
@@ -31,6 +32,13 @@ type GraphStore interface {
 
 	// Subscribe subscribes to changes to nodes and edges.
 	Subscribe(ctx context.Context, fn PeerSubscribeFunc) (context.CancelFunc, error)
+	// CreateNode stores a node that must not already exist. ErrNodeExists
+	// is returned if it does.
+	CreateNode(ctx context.Context, node types.MeshNode) error
+	// PutHeartbeat records that the node was seen at the given time.
+	PutHeartbeat(ctx context.Context, id types.NodeID, at time.Time) error
+	// ListHeartbeats returns the last time each node was seen.
+	ListHeartbeats(ctx context.Context) (map[types.NodeID]time.Time, error)
 }
 
 // NewGraphWithStore creates a new Graph instance with the given graph storage implementation.
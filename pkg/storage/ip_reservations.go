@@ -0,0 +1,71 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// IPReservationsPrefix is where IPv4 addresses reserved for nodes ahead of
+// them joining are stored.
+var IPReservationsPrefix = types.RegistryPrefix.ForString("ip-reservations")
+
+// PutIPReservation reserves the given IPv4 address for a node, replacing any
+// previous reservation for the node.
+func PutIPReservation(ctx context.Context, st MeshStorage, id types.NodeID, addr netip.Prefix) error {
+	err := st.PutValue(ctx, IPReservationsPrefix.For(id.Bytes()), []byte(addr.String()), 0)
+	if err != nil {
+		return fmt.Errorf("put ip reservation: %w", err)
+	}
+	return nil
+}
+
+// ListIPReservations returns all reserved IPv4 addresses keyed by node ID.
+func ListIPReservations(ctx context.Context, st MeshStorage) (map[types.NodeID]netip.Prefix, error) {
+	out := make(map[types.NodeID]netip.Prefix)
+	err := st.IterPrefix(ctx, IPReservationsPrefix, func(key, value []byte) error {
+		if bytes.Equal(key, IPReservationsPrefix) {
+			return nil
+		}
+		id := types.NodeID(IPReservationsPrefix.TrimFrom(key))
+		addr, err := netip.ParsePrefix(string(value))
+		if err != nil {
+			return fmt.Errorf("parse ip reservation for %s: %w", id, err)
+		}
+		out[id] = addr
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list ip reservations: %w", err)
+	}
+	return out, nil
+}
+
+// DeleteIPReservation removes the reserved IPv4 address of a node.
+func DeleteIPReservation(ctx context.Context, st MeshStorage, id types.NodeID) error {
+	err := st.Delete(ctx, IPReservationsPrefix.For(id.Bytes()))
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete ip reservation: %w", err)
+	}
+	return nil
+}
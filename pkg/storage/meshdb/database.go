@@ -22,6 +22,7 @@ import (
 	"context"
 	"fmt"
 	"net/netip"
+	"time"
 
 	"github.com/dominikbraun/graph"
 	v1 "github.com/webmeshproj/api/go/v1"
@@ -59,12 +60,26 @@ func New(db storage.MeshDataStore) storage.MeshDB {
 // NewFromStorage creates a new MeshDB instance from the given MeshStorage. The same
 // information applies as for New.
 func NewFromStorage(st storage.MeshStorage) storage.MeshDB {
-	return New(&MeshDataStore{
+	db := New(&MeshDataStore{
 		graph:   graphstore.NewStore(st),
 		rbac:    rbac.New(st),
 		mesh:    state.New(st),
 		network: networking.New(st),
-	})
+	}).(*Database)
+	db.st = st
+	return db
+}
+
+// NewForTenant creates a new MeshDB instance scoped to the given tenant. All
+// registry data is namespaced to the tenant, so tenants sharing the same
+// MeshStorage can not read or modify each other's peers, networking, or RBAC
+// data.
+func NewForTenant(st storage.MeshStorage, tenant string) (storage.MeshDB, error) {
+	tenantStorage, err := storage.NewTenantStorage(st, tenant)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromStorage(tenantStorage), nil
 }
 
 // MeshDataStore is a data store using an underlying MeshStorage instance.
@@ -100,6 +115,7 @@ func (m *MeshDataStore) Networking() storage.Networking {
 // read methods to perform validation. So any locks used internally must be reentrant.
 type Database struct {
 	db         storage.MeshDataStore
+	st         storage.MeshStorage
 	graphStore storage.GraphStore
 	peers      storage.Peers
 	rbac       storage.RBAC
@@ -107,6 +123,20 @@ type Database struct {
 	network    storage.Networking
 }
 
+// AtCurrentRevision returns a read-only MeshDB of the current revision of the
+// underlying storage. The database itself is returned if it was not created
+// from a MeshStorage.
+func (d *Database) AtCurrentRevision(ctx context.Context) (storage.MeshDB, error) {
+	if d.st == nil {
+		return d, nil
+	}
+	st, err := storage.AtCurrentRevision(ctx, d.st)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromStorage(st), nil
+}
+
 // Peers returns the underlying storage.MeshDB's Peers instance with
 // validators run before operations.
 func (d *Database) Peers() storage.Peers {
@@ -149,10 +179,13 @@ func (v *ValidatingMeshStateStore) SetMeshState(ctx context.Context, state types
 		return fmt.Errorf("domain can not be empty")
 	}
 	if state.GetNetworkV4() != "" {
-		_, err := netip.ParsePrefix(state.GetNetworkV4())
+		prefix, err := netip.ParsePrefix(state.GetNetworkV4())
 		if err != nil {
 			return fmt.Errorf("parse IPv4 prefix: %w", err)
 		}
+		if state.IPv4NodePrefixLength != 0 && (state.IPv4NodePrefixLength < prefix.Bits() || state.IPv4NodePrefixLength > 32) {
+			return fmt.Errorf("node prefix length /%d does not fit in %s", state.IPv4NodePrefixLength, prefix)
+		}
 	}
 	if state.GetNetworkV6() != "" {
 		_, err := netip.ParsePrefix(state.GetNetworkV6())
@@ -168,6 +201,14 @@ func (v *ValidatingMeshStateStore) GetMeshState(ctx context.Context) (types.Netw
 	return v.MeshState.GetMeshState(ctx)
 }
 
+// SetLeader records the current storage leader.
+func (v *ValidatingMeshStateStore) SetLeader(ctx context.Context, leader types.StoragePeer) error {
+	if leader.StoragePeer == nil || leader.GetId() == "" {
+		return fmt.Errorf("leader id can not be empty")
+	}
+	return v.MeshState.SetLeader(ctx, leader)
+}
+
 // ValidatingPeerStore wraps graph store implementation with a simpler to use
 // peer store interface.
 type ValidatingPeerStore struct {
@@ -198,6 +239,16 @@ func (p *ValidatingPeerStore) Put(ctx context.Context, node types.MeshNode) erro
 	return nil
 }
 
+// Create validates the node and then saves it to the underlying graph storage
+// if it does not already exist.
+func (p *ValidatingPeerStore) Create(ctx context.Context, node types.MeshNode) error {
+	validated, err := types.ValidateMeshNode(node)
+	if err != nil {
+		return fmt.Errorf("validate node: %w", err)
+	}
+	return p.graphStore.CreateNode(ctx, validated)
+}
+
 // Get validates the node ID and then retrieves it from the underlying graph storage.
 func (p *ValidatingPeerStore) Get(ctx context.Context, id types.NodeID) (types.MeshNode, error) {
 	if !id.IsValid() {
@@ -233,6 +284,83 @@ func (p *ValidatingPeerStore) GetByPubKey(ctx context.Context, key crypto.Public
 	return types.MeshNode{}, errors.ErrNodeNotFound
 }
 
+// GetByAddress gets the node that owns the given mesh address. Nodes whose
+// private address contains addr are preferred over nodes that only share the
+// same IPv6 /64.
+func (p *ValidatingPeerStore) GetByAddress(ctx context.Context, addr netip.Addr) (types.MeshNode, error) {
+	if !addr.IsValid() {
+		return types.MeshNode{}, fmt.Errorf("invalid address")
+	}
+	addr = addr.Unmap()
+	nodes, err := p.List(ctx)
+	if err != nil {
+		return types.MeshNode{}, fmt.Errorf("list nodes: %w", err)
+	}
+	var candidate *types.MeshNode
+	for i, node := range nodes {
+		if addr.Is4() {
+			if prefix := node.PrivateAddrV4(); prefix.IsValid() && prefix.Contains(addr) {
+				return node, nil
+			}
+			continue
+		}
+		prefix := node.PrivateAddrV6()
+		if !prefix.IsValid() {
+			continue
+		}
+		if prefix.Contains(addr) {
+			return node, nil
+		}
+		if candidate == nil && prefix.Bits() > 64 {
+			subnet, err := prefix.Addr().Prefix(64)
+			if err == nil && subnet.Contains(addr) {
+				candidate = &nodes[i]
+			}
+		}
+	}
+	if candidate != nil {
+		return *candidate, nil
+	}
+	return types.MeshNode{}, errors.ErrNodeNotFound
+}
+
+// PutHeartbeat records that the node was seen at the given time.
+func (p *ValidatingPeerStore) PutHeartbeat(ctx context.Context, id types.NodeID, at time.Time) error {
+	if id.IsEmpty() {
+		return errors.ErrEmptyNodeID
+	}
+	if !id.IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, id)
+	}
+	return p.graphStore.PutHeartbeat(ctx, id, at)
+}
+
+// ListStaleNodes lists all nodes that have not been seen since the given time.
+func (p *ValidatingPeerStore) ListStaleNodes(ctx context.Context, notSeenSince time.Time) ([]types.MeshNode, error) {
+	heartbeats, err := p.graphStore.ListHeartbeats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list heartbeats: %w", err)
+	}
+	nodes, err := p.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	stale := make([]types.MeshNode, 0)
+	for _, node := range nodes {
+		lastSeen, ok := heartbeats[node.NodeID()]
+		if !ok {
+			if node.GetJoinedAt() == nil {
+				continue
+			}
+			lastSeen = node.GetJoinedAt().AsTime()
+		}
+		if lastSeen.Before(notSeenSince) {
+			stale = append(stale, node)
+		}
+	}
+	return stale, nil
+}
+
 // Delete removes the node by first removing any edges it is a part of and then
 // removing it from the graph.
 func (p *ValidatingPeerStore) Delete(ctx context.Context, id types.NodeID) error {
@@ -277,6 +405,14 @@ func (p *ValidatingPeerStore) List(ctx context.Context, filters ...storage.PeerF
 	return storage.PeerFilters(filters).Filter(out), nil
 }
 
+// ListPeersByLabel lists all nodes whose labels match the selector.
+func (p *ValidatingPeerStore) ListPeersByLabel(ctx context.Context, selector types.LabelSelector) ([]types.MeshNode, error) {
+	if len(selector) == 0 {
+		return nil, fmt.Errorf("label selector must not be empty")
+	}
+	return p.List(ctx, storage.FilterByLabels(selector))
+}
+
 // ListIDs returns all node IDs in the graph.
 func (p *ValidatingPeerStore) ListIDs(ctx context.Context) ([]types.NodeID, error) {
 	return p.graphStore.ListVertices()
@@ -659,3 +795,9 @@ func (v *ValidatingRBACStore) ListUserRoles(ctx context.Context, userID types.No
 	}
 	return v.RBAC.ListUserRoles(ctx, userID)
 }
+
+// ImportRBAC applies the given policy using the given mode. The policy is
+// validated by the underlying store before any changes are made.
+func (v *ValidatingRBACStore) ImportRBAC(ctx context.Context, policy types.RBACPolicy, mode storage.RBACImportMode) error {
+	return v.RBAC.ImportRBAC(ctx, policy, mode)
+}
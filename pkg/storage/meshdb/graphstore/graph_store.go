@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/dominikbraun/graph"
 
@@ -142,9 +143,51 @@ func (g *GraphStore) RemoveVertex(nodeID types.NodeID) error {
 	if err := g.Delete(ctx, key); err != nil {
 		return fmt.Errorf("delete node: %w", err)
 	}
+	if err := g.Delete(ctx, storage.HeartbeatKey(nodeID)); err != nil {
+		return fmt.Errorf("delete heartbeat: %w", err)
+	}
+	return nil
+}
+
+// CreateNode stores a node that must not already exist. The existence check
+// and the write are committed together, so of any concurrent creates for the
+// same node exactly one succeeds.
+func (g *GraphStore) CreateNode(ctx context.Context, node types.MeshNode) error {
+	batch := g.Batch()
+	if err := storage.PutNodeIfAbsentInBatch(batch, node); err != nil {
+		return err
+	}
+	if err := batch.Commit(ctx); err != nil {
+		if errors.IsKeyExists(err) {
+			return fmt.Errorf("%w: %s", errors.ErrNodeExists, node.GetId())
+		}
+		return fmt.Errorf("create node: %w", err)
+	}
 	return nil
 }
 
+// PutHeartbeat records that the node was seen at the given time.
+func (g *GraphStore) PutHeartbeat(ctx context.Context, id types.NodeID, at time.Time) error {
+	if !id.IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, id)
+	}
+	return g.PutValue(ctx, storage.HeartbeatKey(id), storage.EncodeHeartbeat(at), 0)
+}
+
+// ListHeartbeats returns the last time each node was seen.
+func (g *GraphStore) ListHeartbeats(ctx context.Context) (map[types.NodeID]time.Time, error) {
+	out := make(map[types.NodeID]time.Time)
+	err := g.IterPrefix(ctx, storage.HeartbeatsPrefix, func(key, value []byte) error {
+		id, at, err := storage.DecodeHeartbeat(key, value)
+		if err != nil {
+			return err
+		}
+		out[id] = at
+		return nil
+	})
+	return out, err
+}
+
 // ListVertices should return all vertices in the graph in a slice.
 func (g *GraphStore) ListVertices() ([]types.NodeID, error) {
 	g.mu.RLock()
@@ -385,6 +428,12 @@ func (g *GraphStore) Subscribe(ctx context.Context, fn storage.PeerSubscribeFunc
 				}
 				nodes = append(nodes, node)
 			}
+		case bytes.HasPrefix(key, storage.NetworkACLsPrefix):
+			// ACL changes can affect the peers of any node, so subscribers
+			// are notified without any nodes to trigger a full refresh.
+			if bytes.Equal(key, storage.NetworkACLsPrefix) {
+				return
+			}
 		default:
 			return
 		}
@@ -393,5 +442,5 @@ func (g *GraphStore) Subscribe(ctx context.Context, fn storage.PeerSubscribeFunc
 }
 
 func newEdgeKey(source, target types.NodeID) []byte {
-	return storage.EdgesPrefix.For(source.Bytes()).For(target.Bytes())
+	return storage.EdgeKey(source, target)
 }
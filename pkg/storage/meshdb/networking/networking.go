@@ -18,13 +18,18 @@ limitations under the License.
 package networking
 
 import (
-	"bytes"
 	"fmt"
 	"net/netip"
 
+	"github.com/dominikbraun/graph"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/graphstore"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -32,11 +37,40 @@ type Networking = storage.Networking
 
 // New returns a new Networking interface.
 func New(st storage.MeshStorage) Networking {
-	return &networking{st}
+	gs := graphstore.NewStore(st)
+	return &networking{
+		st:      st,
+		rbac:    rbac.New(st),
+		graph:   gs,
+		labels:  storage.NewGraphLabelResolver(gs),
+		acls:    storage.NewRegistry[*v1.NetworkACL](st, storage.NetworkACLsPrefix),
+		routes:  storage.NewRegistry[*v1.Route](st, storage.RoutesPrefix),
+		metrics: storage.NewRegistry[*wrapperspb.UInt32Value](st, storage.RouteMetricsPrefix),
+		exclude: storage.NewRegistry[*wrapperspb.StringValue](st, storage.RouteExclusionsPrefix),
+		sigs:    storage.NewRegistry[*wrapperspb.BytesValue](st, storage.RouteSignaturesPrefix),
+	}
 }
 
 type networking struct {
-	storage.MeshStorage
+	st      storage.MeshStorage
+	rbac    storage.RBAC
+	graph   types.PeerGraphStore
+	labels  storage.LabelResolver
+	acls    *storage.Registry[*v1.NetworkACL]
+	routes  *storage.Registry[*v1.Route]
+	metrics *storage.Registry[*wrapperspb.UInt32Value]
+	exclude *storage.Registry[*wrapperspb.StringValue]
+	sigs    *storage.Registry[*wrapperspb.BytesValue]
+}
+
+// atCurrentRevision returns a read-only networking of the current revision of
+// the storage, so that reads spanning several registries observe one state.
+func (n *networking) atCurrentRevision(ctx context.Context) (*networking, error) {
+	st, err := storage.AtCurrentRevision(ctx, n.st)
+	if err != nil {
+		return nil, fmt.Errorf("read current revision: %w", err)
+	}
+	return New(st).(*networking), nil
 }
 
 // PutNetworkACL creates or updates a NetworkACL.
@@ -45,12 +79,7 @@ func (n *networking) PutNetworkACL(ctx context.Context, acl types.NetworkACL) er
 	if err != nil {
 		return fmt.Errorf("%w: %w", errors.ErrInvalidACL, err)
 	}
-	key := storage.NetworkACLsPrefix.For([]byte(acl.GetName()))
-	data, err := acl.MarshalProtoJSON()
-	if err != nil {
-		return fmt.Errorf("marshal network acl: %w", err)
-	}
-	err = n.PutValue(ctx, key, data, 0)
+	err = n.acls.Put(ctx, acl.GetName(), acl.NetworkACL)
 	if err != nil {
 		return fmt.Errorf("put network acl: %w", err)
 	}
@@ -59,45 +88,35 @@ func (n *networking) PutNetworkACL(ctx context.Context, acl types.NetworkACL) er
 
 // GetNetworkACL returns a NetworkACL by name.
 func (n *networking) GetNetworkACL(ctx context.Context, name string) (types.NetworkACL, error) {
-	key := storage.NetworkACLsPrefix.For([]byte(name))
-	data, err := n.GetValue(ctx, key)
+	acl, err := n.acls.Get(ctx, name)
 	if err != nil {
 		if errors.IsKeyNotFound(err) {
 			return types.NetworkACL{}, errors.ErrACLNotFound
 		}
 		return types.NetworkACL{}, fmt.Errorf("get network acl: %w", err)
 	}
-	var acl types.NetworkACL
-	err = acl.UnmarshalProtoJSON(data)
-	if err != nil {
-		return types.NetworkACL{}, fmt.Errorf("unmarshal network acl: %w", err)
-	}
-	return acl, nil
+	return types.NetworkACL{NetworkACL: acl}, nil
 }
 
 // DeleteNetworkACL deletes a NetworkACL by name.
 func (n *networking) DeleteNetworkACL(ctx context.Context, name string) error {
-	key := storage.NetworkACLsPrefix.For([]byte(name))
-	err := n.Delete(ctx, key)
-	if err != nil && !errors.IsKeyNotFound(err) {
+	err := n.acls.Delete(ctx, name)
+	if err != nil {
 		return fmt.Errorf("delete network acl: %w", err)
 	}
 	return nil
 }
 
-// ListNetworkACLs returns a list of NetworkACLs.
+// ListNetworkACLs returns a list of NetworkACLs as they were at a single
+// revision of the storage.
 func (n *networking) ListNetworkACLs(ctx context.Context) (types.NetworkACLs, error) {
+	view, err := n.atCurrentRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
 	out := make(types.NetworkACLs, 0)
-	err := n.IterPrefix(ctx, storage.NetworkACLsPrefix, func(key, value []byte) error {
-		if bytes.Equal(key, storage.NetworkACLsPrefix) {
-			return nil
-		}
-		var acl types.NetworkACL
-		err := acl.UnmarshalProtoJSON(value)
-		if err != nil {
-			return fmt.Errorf("unmarshal network acl: %w", err)
-		}
-		out = append(out, acl)
+	err = view.acls.Iter(ctx, func(_ string, acl *v1.NetworkACL) error {
+		out = append(out, types.NetworkACL{NetworkACL: acl})
 		return nil
 	})
 	return out, err
@@ -109,34 +128,137 @@ func (n *networking) PutRoute(ctx context.Context, route types.Route) error {
 	if err != nil {
 		return fmt.Errorf("%w: %w", errors.ErrInvalidRoute, err)
 	}
-	key := storage.RoutesPrefix.For([]byte(route.GetName()))
-	data, err := route.MarshalProtoJSON()
+	prev, err := n.routes.Get(ctx, route.GetName())
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("get network route: %w", err)
+	}
+	carried := false
+	if prev != nil {
+		route, carried, err = n.mergeStoredRoute(ctx, route)
+		if err != nil {
+			return err
+		}
+	}
+	if route.IsSigned() {
+		node, _, err := n.graph.Vertex(types.NodeID(route.GetNode()))
+		if err != nil && !errors.Is(err, graph.ErrVertexNotFound) {
+			return fmt.Errorf("get route node: %w", err)
+		}
+		err = storage.VerifyRouteSignature(route, node)
+		if err != nil {
+			if carried {
+				return fmt.Errorf("%w: route %q is signed and cannot be changed without a new signature", errors.ErrInvalidRouteSignature, route.GetName())
+			}
+			return err
+		}
+		err = storage.CheckRouteSignatureVersion(ctx, n.st, route)
+		if err != nil {
+			return err
+		}
+	}
+	// Write the route, its metric, its exclusions, its signature and its
+	// node index together so readers never see one without the others.
+	batch := n.st.Batch()
+	err = n.routes.PutInBatch(batch, route.GetName(), route.Route)
 	if err != nil {
-		return fmt.Errorf("marshal route: %w", err)
+		return fmt.Errorf("put network route: %w", err)
+	}
+	if prev != nil && prev.GetNode() != route.GetNode() {
+		storage.UnindexRouteInBatch(batch, prev.GetNode(), route.GetName())
 	}
-	err = n.PutValue(ctx, key, data, 0)
+	storage.IndexRouteInBatch(batch, route)
+	// An unset metric keeps the one already stored, so that updates from
+	// the API, which cannot carry one, do not reset it.
+	if route.Metric > 0 {
+		err = n.metrics.PutInBatch(batch, route.GetName(), wrapperspb.UInt32(route.Metric))
+		if err != nil {
+			return fmt.Errorf("put network route metric: %w", err)
+		}
+	}
+	// Likewise nil exclusions keep the stored ones, while an empty list
+	// clears them.
+	switch {
+	case len(route.ExcludedCIDRs) > 0:
+		err = n.exclude.PutInBatch(batch, route.GetName(), storage.EncodeRouteExclusions(route.ExcludedCIDRs))
+		if err != nil {
+			return fmt.Errorf("put network route exclusions: %w", err)
+		}
+	case route.ExcludedCIDRs != nil:
+		n.exclude.DeleteInBatch(batch, route.GetName())
+	}
+	if route.IsSigned() {
+		err = n.sigs.PutInBatch(batch, route.GetName(), wrapperspb.Bytes(route.Signature))
+		if err != nil {
+			return fmt.Errorf("put network route signature: %w", err)
+		}
+		err = storage.PutRouteSignatureVersionInBatch(n.st, batch, route)
+		if err != nil {
+			return fmt.Errorf("put network route signature version: %w", err)
+		}
+	}
+	err = batch.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("put network route: %w", err)
 	}
 	return nil
 }
 
+// mergeStoredRoute fills the metric, exclusions and signature left unset on
+// the given route from the ones stored for it. It reports if the signature
+// was carried over from the stored route.
+func (n *networking) mergeStoredRoute(ctx context.Context, route types.Route) (types.Route, bool, error) {
+	if route.Metric == 0 {
+		metric, err := n.metrics.Get(ctx, route.GetName())
+		if err != nil && !errors.IsKeyNotFound(err) {
+			return route, false, fmt.Errorf("get network route metric: %w", err)
+		}
+		route.Metric = metric.GetValue()
+	}
+	if route.ExcludedCIDRs == nil {
+		excluded, err := n.exclude.Get(ctx, route.GetName())
+		if err != nil && !errors.IsKeyNotFound(err) {
+			return route, false, fmt.Errorf("get network route exclusions: %w", err)
+		}
+		route.ExcludedCIDRs = storage.DecodeRouteExclusions(excluded)
+	}
+	if route.IsSigned() {
+		return route, false, nil
+	}
+	sig, err := n.sigs.Get(ctx, route.GetName())
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return route, false, fmt.Errorf("get network route signature: %w", err)
+	}
+	route.Signature = sig.GetValue()
+	return route, route.IsSigned(), nil
+}
+
 // GetRoute returns a Route by name.
 func (n *networking) GetRoute(ctx context.Context, name string) (types.Route, error) {
-	key := storage.RoutesPrefix.For([]byte(name))
-	data, err := n.GetValue(ctx, key)
+	rt, err := n.routes.Get(ctx, name)
 	if err != nil {
 		if errors.IsKeyNotFound(err) {
 			return types.Route{}, errors.ErrRouteNotFound
 		}
 		return types.Route{}, fmt.Errorf("get network route: %w", err)
 	}
-	var rt types.Route
-	err = rt.UnmarshalProtoJSON(data)
-	if err != nil {
-		return types.Route{}, fmt.Errorf("unmarshal network route: %w", err)
+	metric, err := n.metrics.Get(ctx, name)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return types.Route{}, fmt.Errorf("get network route metric: %w", err)
+	}
+	excluded, err := n.exclude.Get(ctx, name)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return types.Route{}, fmt.Errorf("get network route exclusions: %w", err)
+	}
+	sig, err := n.sigs.Get(ctx, name)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return types.Route{}, fmt.Errorf("get network route signature: %w", err)
 	}
-	return rt, nil
+	return types.Route{
+		Route:         rt,
+		Metric:        metric.GetValue(),
+		ExcludedCIDRs: storage.DecodeRouteExclusions(excluded),
+		Signature:     sig.GetValue(),
+	}, nil
 }
 
 // GetRoutesByNode returns a list of Routes for a given Node.
@@ -179,28 +301,72 @@ func (n *networking) GetRoutesByCIDR(ctx context.Context, cidr netip.Prefix) (ty
 
 // DeleteRoute deletes a Route by name.
 func (n *networking) DeleteRoute(ctx context.Context, name string) error {
-	key := storage.RoutesPrefix.For([]byte(name))
-	err := n.Delete(ctx, key)
+	rt, err := n.routes.Get(ctx, name)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("get network route: %w", err)
+	}
+	batch := n.st.Batch()
+	n.routes.DeleteInBatch(batch, name)
+	n.metrics.DeleteInBatch(batch, name)
+	n.exclude.DeleteInBatch(batch, name)
+	n.sigs.DeleteInBatch(batch, name)
+	if rt != nil {
+		storage.UnindexRouteInBatch(batch, rt.GetNode(), name)
+	}
+	err = batch.Commit(ctx)
 	if err != nil && !errors.IsKeyNotFound(err) {
 		return fmt.Errorf("delete network route: %w", err)
 	}
 	return nil
 }
 
-// ListRoutes returns a list of Routes.
+// ListRoutes returns a list of Routes as they were at a single revision of
+// the storage.
 func (n *networking) ListRoutes(ctx context.Context) (types.Routes, error) {
+	view, err := n.atCurrentRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]uint32)
+	err = view.metrics.Iter(ctx, func(name string, metric *wrapperspb.UInt32Value) error {
+		metrics[name] = metric.GetValue()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list network route metrics: %w", err)
+	}
+	excluded := make(map[string][]string)
+	err = view.exclude.Iter(ctx, func(name string, cidrs *wrapperspb.StringValue) error {
+		excluded[name] = storage.DecodeRouteExclusions(cidrs)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list network route exclusions: %w", err)
+	}
+	signatures := make(map[string][]byte)
+	err = view.sigs.Iter(ctx, func(name string, sig *wrapperspb.BytesValue) error {
+		signatures[name] = sig.GetValue()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list network route signatures: %w", err)
+	}
 	out := make([]types.Route, 0)
-	err := n.IterPrefix(ctx, storage.RoutesPrefix, func(key, value []byte) error {
-		if bytes.Equal(key, storage.RoutesPrefix) {
-			return nil
-		}
-		var rt types.Route
-		err := rt.UnmarshalProtoJSON(value)
-		if err != nil {
-			return fmt.Errorf("unmarshal network route: %w", err)
-		}
-		out = append(out, rt)
+	err = view.routes.Iter(ctx, func(name string, rt *v1.Route) error {
+		out = append(out, types.Route{Route: rt, Metric: metrics[name], ExcludedCIDRs: excluded[name], Signature: signatures[name]})
 		return nil
 	})
 	return out, err
 }
+
+// ResolveRoute returns the most specific Route containing the given address
+// and the node serving it.
+func (n *networking) ResolveRoute(ctx context.Context, addr netip.Addr) (*v1.Route, string, error) {
+	return storage.ResolveRoute(ctx, n, n.rbac, n.labels, addr)
+}
+
+// ListOrphanedRoutes returns all routes whose node no longer exists in the
+// mesh.
+func (n *networking) ListOrphanedRoutes(ctx context.Context) (types.Routes, error) {
+	return storage.ListOrphanedRoutes(ctx, n, n.graph)
+}
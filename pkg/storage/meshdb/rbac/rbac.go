@@ -31,9 +31,9 @@ import (
 )
 
 var (
-	rolesPrefix        = types.RegistryPrefix.ForString("roles")
-	rolebindingsPrefix = types.RegistryPrefix.ForString("rolebindings")
-	groupsPrefix       = types.RegistryPrefix.ForString("groups")
+	rolesPrefix        = storage.RolesPrefix
+	rolebindingsPrefix = storage.RoleBindingsPrefix
+	groupsPrefix       = storage.GroupsPrefix
 	rbacDisabledKey    = types.RegistryPrefix.ForString("rbac-disabled")
 )
 
@@ -329,3 +329,18 @@ func (r *rbac) ListUserRoles(ctx context.Context, user types.NodeID) (types.Role
 	}
 	return out, nil
 }
+
+// Enforce returns nil if the identity is allowed to perform the verb on the resource.
+func (r *rbac) Enforce(ctx context.Context, identity types.NodeID, verb v1.RuleVerb, resource types.RBACResource, opts types.EvalOptions) error {
+	return storage.Enforce(ctx, r, identity, verb, resource, opts)
+}
+
+// ExportRBAC returns all roles, rolebindings, and groups as a policy.
+func (r *rbac) ExportRBAC(ctx context.Context) (types.RBACPolicy, error) {
+	return storage.ExportRBAC(ctx, r)
+}
+
+// ImportRBAC applies the given policy using the given mode.
+func (r *rbac) ImportRBAC(ctx context.Context, policy types.RBACPolicy, mode storage.RBACImportMode) error {
+	return storage.ImportRBAC(ctx, r, r.Batch(), policy, mode)
+}
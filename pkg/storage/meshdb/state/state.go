@@ -20,10 +20,12 @@ package state
 import (
 	"context"
 	"net/netip"
+	"strconv"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -36,8 +38,14 @@ var (
 	IPv6PrefixKey = append(MeshStatePrefix, []byte("/ipv6prefix")...)
 	// IPv4PrefixKey is the key for the IPv4 prefix.
 	IPv4PrefixKey = append(MeshStatePrefix, []byte("/ipv4prefix")...)
+	// IPv4NodePrefixLengthKey is the key for the per-node IPv4 prefix length.
+	IPv4NodePrefixLengthKey = append(MeshStatePrefix, []byte("/ipv4nodeprefixlen")...)
 	// MeshDomainKey is the key for the mesh domain.
 	MeshDomainKey = append(MeshStatePrefix, []byte("/meshdomain")...)
+	// LeaderKey is the key for the current storage leader.
+	LeaderKey = append(MeshStatePrefix, []byte("/leader")...)
+	// SchemaVersionKey is the key for the storage schema version.
+	SchemaVersionKey = append(MeshStatePrefix, []byte("/schemaversion")...)
 )
 
 type state struct {
@@ -81,6 +89,22 @@ func (s *state) SetIPv4Prefix(ctx context.Context, prefix netip.Prefix) error {
 	return nil
 }
 
+func (s *state) GetIPv4NodePrefixLength(ctx context.Context) (int, error) {
+	resp, err := s.GetValue(ctx, IPv4NodePrefixLengthKey)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(resp))
+}
+
+func (s *state) SetIPv4NodePrefixLength(ctx context.Context, bits int) error {
+	err := s.PutValue(ctx, IPv4NodePrefixLengthKey, []byte(strconv.Itoa(bits)), 0)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *state) GetMeshDomain(ctx context.Context) (string, error) {
 	resp, err := s.GetValue(ctx, MeshDomainKey)
 	if err != nil {
@@ -97,6 +121,41 @@ func (s *state) SetMeshDomain(ctx context.Context, domain string) error {
 	return nil
 }
 
+func (s *state) SetLeader(ctx context.Context, leader types.StoragePeer) error {
+	data, err := storage.MarshalValue(s.MeshStorage, leader.StoragePeer)
+	if err != nil {
+		return err
+	}
+	return s.PutValue(ctx, LeaderKey, data, 0)
+}
+
+func (s *state) GetLeader(ctx context.Context) (types.StoragePeer, error) {
+	data, err := s.GetValue(ctx, LeaderKey)
+	if err != nil {
+		return types.StoragePeer{}, err
+	}
+	var leader v1.StoragePeer
+	if err := storage.UnmarshalValue(data, &leader); err != nil {
+		return types.StoragePeer{}, err
+	}
+	return types.StoragePeer{StoragePeer: &leader}, nil
+}
+
+func (s *state) SetSchemaVersion(ctx context.Context, version uint64) error {
+	return s.PutValue(ctx, SchemaVersionKey, []byte(strconv.FormatUint(version, 10)), 0)
+}
+
+func (s *state) GetSchemaVersion(ctx context.Context) (uint64, error) {
+	data, err := s.GetValue(ctx, SchemaVersionKey)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseUint(string(data), 10, 64)
+}
+
 func (s *state) SetMeshState(ctx context.Context, state types.NetworkState) error {
 	if state.NetworkV4().IsValid() {
 		err := s.SetIPv4Prefix(ctx, state.NetworkV4())
@@ -104,6 +163,12 @@ func (s *state) SetMeshState(ctx context.Context, state types.NetworkState) erro
 			return err
 		}
 	}
+	if state.IPv4NodePrefixLength != 0 {
+		err := s.SetIPv4NodePrefixLength(ctx, state.IPv4NodePrefixLength)
+		if err != nil {
+			return err
+		}
+	}
 	if state.NetworkV6().IsValid() {
 		err := s.SetIPv6Prefix(ctx, state.NetworkV6())
 		if err != nil {
@@ -131,6 +196,11 @@ func (s *state) GetMeshState(ctx context.Context) (types.NetworkState, error) {
 		return state, err
 	}
 	state.NetworkState.NetworkV4 = networkV4.String()
+	nodeBits, err := s.GetIPv4NodePrefixLength(ctx)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return state, err
+	}
+	state.IPv4NodePrefixLength = nodeBits
 	networkv6, err := s.GetIPv6Prefix(ctx)
 	if err != nil {
 		return state, err
@@ -28,4 +28,17 @@ type MeshState interface {
 	SetMeshState(ctx context.Context, state types.NetworkState) error
 	// GetMeshState returns the full mesh state.
 	GetMeshState(ctx context.Context) (types.NetworkState, error)
+	// SetLeader records the current leader of the storage group. It is
+	// called by the leader whenever leadership changes.
+	SetLeader(ctx context.Context, leader types.StoragePeer) error
+	// GetLeader returns the last recorded leader of the storage group.
+	// This does not require a consensus round-trip, but may briefly be
+	// stale during a leadership change.
+	GetLeader(ctx context.Context) (types.StoragePeer, error)
+	// SetSchemaVersion records the version of the storage schema after
+	// migrations have been applied.
+	SetSchemaVersion(ctx context.Context, version uint64) error
+	// GetSchemaVersion returns the version of the storage schema. Zero is
+	// returned when no version has been recorded.
+	GetSchemaVersion(ctx context.Context) (uint64, error)
 }
@@ -0,0 +1,247 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// Migration is a versioned upgrade of the records in storage.
+type Migration struct {
+	// Version is the schema version storage is at once the migration has run.
+	// Versions must be unique and greater than zero.
+	Version uint64
+	// Name is a short description of the migration.
+	Name string
+	// Migrate upgrades the records in storage. It must be idempotent, since a
+	// migration that fails part way through is run again from the start.
+	Migrate func(ctx context.Context, st MeshStorage) error
+}
+
+// Migrations is a set of migrations. They are always run in version order.
+type Migrations []Migration
+
+// MigrateOptions are options for running migrations.
+type MigrateOptions struct {
+	// DryRun runs the migrations without writing to storage or recording the
+	// new schema version. The writes each migration would make are returned
+	// in the result. Migrations do not observe their own writes when dry-run.
+	DryRun bool
+}
+
+// MigrationChange is a write made by a migration.
+type MigrationChange struct {
+	// Version is the version of the migration that made the change.
+	Version uint64
+	// Key is the key that was written.
+	Key []byte
+	// Value is the new value of the key. It is nil for deletes.
+	Value []byte
+	// Delete is true if the key was removed.
+	Delete bool
+}
+
+// MigrationResult is the result of running migrations.
+type MigrationResult struct {
+	// FromVersion is the schema version before the migrations ran.
+	FromVersion uint64
+	// ToVersion is the schema version after the migrations ran.
+	ToVersion uint64
+	// Applied are the migrations that were run.
+	Applied []Migration
+	// Changes are the writes that would have been made. They are only
+	// recorded in dry-run mode.
+	Changes []MigrationChange
+}
+
+var (
+	registeredMigrations Migrations
+	migrationsMu         sync.Mutex
+)
+
+// RegisterMigration registers a migration to run when storage is opened.
+func RegisterMigration(m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	registeredMigrations = append(registeredMigrations, m)
+}
+
+// RegisteredMigrations returns the migrations registered with RegisterMigration.
+func RegisteredMigrations() Migrations {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	return append(Migrations(nil), registeredMigrations...)
+}
+
+// Validate checks that every migration has a unique, non-zero version and a
+// migrate function.
+func (m Migrations) Validate() error {
+	seen := make(map[uint64]string, len(m))
+	for _, migration := range m {
+		if migration.Version == 0 {
+			return fmt.Errorf("migration %q: version must be greater than zero", migration.Name)
+		}
+		if migration.Migrate == nil {
+			return fmt.Errorf("migration %q: migrate function is required", migration.Name)
+		}
+		if other, ok := seen[migration.Version]; ok {
+			return fmt.Errorf("migrations %q and %q have the same version %d", other, migration.Name, migration.Version)
+		}
+		seen[migration.Version] = migration.Name
+	}
+	return nil
+}
+
+// LatestVersion returns the highest version in the set of migrations.
+func (m Migrations) LatestVersion() uint64 {
+	var latest uint64
+	for _, migration := range m {
+		if migration.Version > latest {
+			latest = migration.Version
+		}
+	}
+	return latest
+}
+
+// Run applies every migration newer than the schema version recorded in the
+// mesh state, in version order. The schema version is recorded after each
+// migration so that an interrupted run resumes where it left off.
+func (m Migrations) Run(ctx context.Context, st MeshStorage, state MeshState, opts MigrateOptions) (*MigrationResult, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	log := context.LoggerFrom(ctx)
+	current, err := state.GetSchemaVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get schema version: %w", err)
+	}
+	pending := make(Migrations, 0, len(m))
+	for _, migration := range m {
+		if migration.Version > current {
+			pending = append(pending, migration)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	result := &MigrationResult{FromVersion: current, ToVersion: current}
+	for _, migration := range pending {
+		log.Info("Running storage migration",
+			slog.Uint64("version", migration.Version),
+			slog.String("name", migration.Name),
+			slog.Bool("dry-run", opts.DryRun),
+		)
+		target := st
+		var dryRun *dryRunStorage
+		if opts.DryRun {
+			dryRun = &dryRunStorage{MeshStorage: st, version: migration.Version}
+			target = dryRun
+		}
+		if err := migration.Migrate(ctx, target); err != nil {
+			return result, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if opts.DryRun {
+			result.Changes = append(result.Changes, dryRun.changes...)
+		} else if err := state.SetSchemaVersion(ctx, migration.Version); err != nil {
+			return result, fmt.Errorf("set schema version %d: %w", migration.Version, err)
+		}
+		result.Applied = append(result.Applied, migration)
+		result.ToVersion = migration.Version
+	}
+	return result, nil
+}
+
+// dryRunStorage records the writes made by a migration instead of applying them.
+type dryRunStorage struct {
+	MeshStorage
+	version uint64
+	changes []MigrationChange
+	mu      sync.Mutex
+}
+
+func (d *dryRunStorage) record(key, value []byte, delete bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.changes = append(d.changes, MigrationChange{
+		Version: d.version,
+		Key:     append([]byte(nil), key...),
+		Value:   append([]byte(nil), value...),
+		Delete:  delete,
+	})
+}
+
+func (d *dryRunStorage) PutValue(_ context.Context, key, value []byte, _ time.Duration) error {
+	d.record(key, value, false)
+	return nil
+}
+
+func (d *dryRunStorage) Delete(_ context.Context, key []byte) error {
+	d.record(key, nil, true)
+	return nil
+}
+
+func (d *dryRunStorage) Batch() Batch {
+	return &dryRunBatch{st: d}
+}
+
+func (d *dryRunStorage) Close() error {
+	return nil
+}
+
+// ValueCodec returns the codec of the underlying storage, so that recorded
+// changes match what the migration would write.
+func (d *dryRunStorage) ValueCodec() Codec {
+	return ValueCodecFor(d.MeshStorage)
+}
+
+type dryRunBatch struct {
+	st     *dryRunStorage
+	ops    []MigrationChange
+	absent [][]byte
+}
+
+func (b *dryRunBatch) PutValue(key, value []byte, _ time.Duration) {
+	b.ops = append(b.ops, MigrationChange{Key: key, Value: value})
+}
+
+func (b *dryRunBatch) PutValueIfAbsent(key, value []byte, ttl time.Duration) {
+	b.absent = append(b.absent, key)
+	b.PutValue(key, value, ttl)
+}
+
+func (b *dryRunBatch) Delete(key []byte) {
+	b.ops = append(b.ops, MigrationChange{Key: key, Delete: true})
+}
+
+func (b *dryRunBatch) Len() int {
+	return len(b.ops)
+}
+
+func (b *dryRunBatch) Commit(ctx context.Context) error {
+	if err := CheckAbsent(ctx, b.st.MeshStorage, b.absent); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		b.st.record(op.Key, op.Value, op.Delete)
+	}
+	b.ops = nil
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	meshState := state.New(st)
+
+	prefix := []byte("/registry/widgets/")
+	for _, name := range []string{"a", "b"} {
+		if err := st.PutValue(ctx, append(prefix, name...), []byte("v1:"+name), 0); err != nil {
+			t.Fatalf("put value: %v", err)
+		}
+	}
+	var order []uint64
+	runs := make(map[uint64]int)
+	migrations := storage.Migrations{
+		{
+			Version: 2,
+			Name:    "tag-widgets",
+			Migrate: func(ctx context.Context, st storage.MeshStorage) error {
+				order = append(order, 2)
+				runs[2]++
+				return st.PutValue(ctx, []byte("/registry/widgets-tagged"), []byte("true"), 0)
+			},
+		},
+		{
+			Version: 1,
+			Name:    "rewrite-widgets",
+			Migrate: func(ctx context.Context, st storage.MeshStorage) error {
+				order = append(order, 1)
+				runs[1]++
+				keys, err := st.ListKeys(ctx, prefix)
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					value, err := st.GetValue(ctx, key)
+					if err != nil {
+						return err
+					}
+					if !bytes.HasPrefix(value, []byte("v1:")) {
+						continue
+					}
+					upgraded := "v2:" + strings.TrimPrefix(string(value), "v1:")
+					if err := st.PutValue(ctx, key, []byte(upgraded), 0); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	// A dry-run reports the changes without applying them.
+	result, err := migrations.Run(ctx, st, meshState, storage.MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry-run migrations: %v", err)
+	}
+	if len(result.Changes) != 3 {
+		t.Fatalf("expected 3 changes in dry-run, got %d", len(result.Changes))
+	}
+	if value, _ := st.GetValue(ctx, append(prefix, 'a')); string(value) != "v1:a" {
+		t.Fatalf("expected dry-run not to modify records, got %q", value)
+	}
+	if version, _ := meshState.GetSchemaVersion(ctx); version != 0 {
+		t.Fatalf("expected dry-run not to record a schema version, got %d", version)
+	}
+
+	// A real run applies the migrations in version order.
+	order = nil
+	result, err = migrations.Run(ctx, st, meshState, storage.MigrateOptions{})
+	if err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	if result.FromVersion != 0 || result.ToVersion != 2 || len(result.Applied) != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Fatalf("expected migrations to run in version order, got %v", order)
+	}
+	for _, name := range []string{"a", "b"} {
+		value, err := st.GetValue(ctx, append(prefix, name...))
+		if err != nil {
+			t.Fatalf("get value: %v", err)
+		}
+		if string(value) != "v2:"+name {
+			t.Errorf("expected record %q to be rewritten, got %q", name, value)
+		}
+	}
+	version, err := meshState.GetSchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("get schema version: %v", err)
+	}
+	if version != 2 {
+		t.Errorf("expected schema version 2, got %d", version)
+	}
+
+	// Running again is a no-op.
+	result, err = migrations.Run(ctx, st, meshState, storage.MigrateOptions{})
+	if err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	if len(result.Applied) != 0 {
+		t.Errorf("expected no migrations to run again, got %d", len(result.Applied))
+	}
+	// Each migration ran once for the dry-run and once for real.
+	if runs[1] != 2 || runs[2] != 2 {
+		t.Errorf("expected each migration to run once after the dry-run, got %v", runs)
+	}
+}
+
+func TestMigrationsValidate(t *testing.T) {
+	t.Parallel()
+	noop := func(context.Context, storage.MeshStorage) error { return nil }
+	for name, migrations := range map[string]storage.Migrations{
+		"zero version":      {{Name: "zero", Migrate: noop}},
+		"missing migrate":   {{Version: 1, Name: "missing"}},
+		"duplicate version": {{Version: 1, Name: "a", Migrate: noop}, {Version: 1, Name: "b", Migrate: noop}},
+	} {
+		if err := migrations.Validate(); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
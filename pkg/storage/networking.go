@@ -17,10 +17,14 @@ limitations under the License.
 package storage
 
 import (
+	"fmt"
 	"net/netip"
 	"slices"
 	"strings"
 
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
@@ -33,8 +37,36 @@ var (
 	NetworkACLsPrefix = types.RegistryPrefix.For([]byte("network-acls"))
 	// RoutesPrefix is where Routes are stored in the database.
 	RoutesPrefix = types.RegistryPrefix.For([]byte("routes"))
+	// RouteMetricsPrefix is where the metrics for Routes are stored in the database.
+	// They are kept separately because the metric is not part of the Route protobuf.
+	RouteMetricsPrefix = types.RegistryPrefix.For([]byte("route-metrics"))
+	// RouteExclusionsPrefix is where the excluded CIDRs for Routes are stored in the
+	// database. They are stored as a comma-separated list for the same reason.
+	RouteExclusionsPrefix = types.RegistryPrefix.For([]byte("route-exclusions"))
+	// RouteSignaturesPrefix is where the signatures of signed Routes are stored
+	// in the database.
+	RouteSignaturesPrefix = types.RegistryPrefix.For([]byte("route-signatures"))
+	// RouteSignatureVersionsPrefix is where the newest signature version
+	// stored for each route name is kept. It outlives the route so that a
+	// deleted signed route cannot be restored by replaying an old signature.
+	RouteSignatureVersionsPrefix = types.RegistryPrefix.For([]byte("route-signature-versions"))
 )
 
+// EncodeRouteExclusions encodes the excluded CIDRs of a route for storage
+// under RouteExclusionsPrefix.
+func EncodeRouteExclusions(cidrs []string) *wrapperspb.StringValue {
+	return wrapperspb.String(strings.Join(cidrs, ","))
+}
+
+// DecodeRouteExclusions decodes the excluded CIDRs of a route stored under
+// RouteExclusionsPrefix. A nil value decodes to no exclusions.
+func DecodeRouteExclusions(value *wrapperspb.StringValue) []string {
+	if value.GetValue() == "" {
+		return nil
+	}
+	return strings.Split(value.GetValue(), ",")
+}
+
 // Networking is the interface to the database models for network resources.
 type Networking interface {
 	// PutNetworkACL creates or updates a NetworkACL.
@@ -45,7 +77,11 @@ type Networking interface {
 	DeleteNetworkACL(ctx context.Context, name string) error
 	// ListNetworkACLs returns a list of NetworkACLs.
 	ListNetworkACLs(ctx context.Context) (types.NetworkACLs, error)
-	// PutRoute creates or updates a Route.
+	// PutRoute creates or updates a Route. A zero Metric keeps the metric
+	// already stored for the route, as do nil ExcludedCIDRs for its
+	// exclusions. An empty, non-nil ExcludedCIDRs clears them. An unsigned
+	// update keeps the stored signature and is rejected if it no longer
+	// matches the route.
 	PutRoute(ctx context.Context, route types.Route) error
 	// GetRoute returns a Route by name.
 	GetRoute(ctx context.Context, name string) (types.Route, error)
@@ -57,13 +93,58 @@ type Networking interface {
 	DeleteRoute(ctx context.Context, name string) error
 	// ListRoutes returns a list of Routes.
 	ListRoutes(ctx context.Context) (types.Routes, error)
+	// ResolveRoute returns the most specific Route whose destination contains
+	// the given address and the ID of the node serving it. If the context
+	// carries an authenticated caller, only routes the caller is allowed to
+	// reach by network ACLs are considered. ErrRouteNotFound is returned if
+	// there is no match.
+	ResolveRoute(ctx context.Context, addr netip.Addr) (*v1.Route, string, error)
+	// ListOrphanedRoutes returns all routes whose node no longer exists in
+	// the mesh.
+	ListOrphanedRoutes(ctx context.Context) (types.Routes, error)
+}
+
+// LabelResolver resolves label selectors to the nodes they match. It is
+// implemented by Peers.
+type LabelResolver interface {
+	// ListPeersByLabel lists all nodes whose labels match the selector.
+	ListPeersByLabel(ctx context.Context, selector types.LabelSelector) ([]types.MeshNode, error)
+}
+
+// NewGraphLabelResolver returns a LabelResolver that lists nodes directly
+// from the given graph store.
+func NewGraphLabelResolver(store types.PeerGraphStore) LabelResolver {
+	return &graphLabelResolver{store}
+}
+
+type graphLabelResolver struct {
+	store types.PeerGraphStore
+}
+
+func (g *graphLabelResolver) ListPeersByLabel(ctx context.Context, selector types.LabelSelector) ([]types.MeshNode, error) {
+	ids, err := g.store.ListVertices()
+	if err != nil {
+		return nil, fmt.Errorf("list vertices: %w", err)
+	}
+	var out []types.MeshNode
+	for _, id := range ids {
+		node, _, err := g.store.Vertex(id)
+		if err != nil {
+			return nil, fmt.Errorf("get vertex: %w", err)
+		}
+		if selector.Matches(node.Labels()) {
+			out = append(out, node)
+		}
+	}
+	return out, nil
 }
 
 // ExpandACLs will use the given RBAC interface to expand any group references
-// in the ACLs.
-func ExpandACLs(ctx context.Context, rbac RBAC, acls types.NetworkACLs) error {
+// and the given LabelResolver to expand any label references in the ACLs.
+// Label references are dropped if the resolver is nil.
+func ExpandACLs(ctx context.Context, rbac RBAC, labels LabelResolver, acls types.NetworkACLs) error {
 	for _, acl := range acls {
-		if err := ExpandACL(ctx, rbac, acl); err != nil {
+		if err := ExpandACL(ctx, rbac, labels, acl); err != nil {
 			return err
 		}
 	}
@@ -71,57 +152,177 @@ func ExpandACLs(ctx context.Context, rbac RBAC, acls types.NetworkACLs) error {
 }
 
 // ExpandACL will use the given RBAC interface to expand any group references
-// in the ACL.
-func ExpandACL(ctx context.Context, rbac RBAC, acl types.NetworkACL) error {
-	// Expand group references in the source nodes
-	var srcNodes []string
-	for _, node := range acl.GetSourceNodes() {
-		if !strings.HasPrefix(node, types.GroupReference) {
-			srcNodes = append(srcNodes, node)
-			continue
+// and the given LabelResolver to expand any label references in the ACL.
+// References to missing groups or selectors matching no nodes expand to nothing.
+func ExpandACL(ctx context.Context, rbac RBAC, labels LabelResolver, acl types.NetworkACL) error {
+	srcNodes, err := expandACLNodes(ctx, rbac, labels, acl.GetSourceNodes())
+	if err != nil {
+		return err
+	}
+	acl.SourceNodes = srcNodes
+	// The same for destination nodes
+	dstNodes, err := expandACLNodes(ctx, rbac, labels, acl.GetDestinationNodes())
+	if err != nil {
+		return err
+	}
+	acl.DestinationNodes = dstNodes
+	return nil
+}
+
+func expandACLNodes(ctx context.Context, rbac RBAC, labels LabelResolver, nodes []string) ([]string, error) {
+	log := context.LoggerFrom(ctx)
+	out := []string{}
+	add := func(node string) {
+		if !slices.Contains(out, node) {
+			out = append(out, node)
 		}
-		groupName := strings.TrimPrefix(node, types.GroupReference)
-		context.LoggerFrom(ctx).Debug("Expanding group reference", "group", groupName)
-		group, err := rbac.GetGroup(ctx, groupName)
-		if err != nil {
-			if !errors.Is(err, errors.ErrGroupNotFound) {
-				context.LoggerFrom(ctx).Error("Failed to lookup group", "group", groupName, "error", err.Error())
-				return err
+	}
+	for _, node := range nodes {
+		switch {
+		case strings.HasPrefix(node, types.GroupReference):
+			groupName := strings.TrimPrefix(node, types.GroupReference)
+			log.Debug("Expanding group reference", "group", groupName)
+			group, err := rbac.GetGroup(ctx, groupName)
+			if err != nil {
+				if !errors.IsGroupNotFound(err) {
+					log.Error("Failed to lookup group", "group", groupName, "error", err.Error())
+					return nil, err
+				}
+				// If the group doesn't exist, we'll just ignore it.
+				continue
 			}
-			// If the group doesn't exist, we'll just ignore it.
-			continue
-		}
-		for _, subject := range group.GetSubjects() {
-			if !slices.Contains(srcNodes, subject.GetName()) {
-				srcNodes = append(srcNodes, subject.GetName())
+			for _, subject := range group.GetSubjects() {
+				add(subject.GetName())
 			}
+		case strings.HasPrefix(node, types.LabelReference):
+			selector, err := types.ParseLabelSelector(strings.TrimPrefix(node, types.LabelReference))
+			if err != nil {
+				// Invalid selectors are rejected on write, ignore any that slipped through.
+				log.Warn("Ignoring invalid label reference", "reference", node, "error", err.Error())
+				continue
+			}
+			if labels == nil {
+				log.Debug("No label resolver configured, ignoring label reference", "reference", node)
+				continue
+			}
+			log.Debug("Expanding label reference", "selector", selector.String())
+			peers, err := labels.ListPeersByLabel(ctx, selector)
+			if err != nil {
+				log.Error("Failed to resolve label selector", "selector", selector.String(), "error", err.Error())
+				return nil, err
+			}
+			for _, peer := range peers {
+				add(peer.GetId())
+			}
+		default:
+			add(node)
 		}
 	}
-	acl.SourceNodes = srcNodes
-	// The same for destination nodes
-	var dstNodes []string
-	for _, node := range acl.GetDestinationNodes() {
-		if !strings.HasPrefix(node, types.GroupReference) {
-			dstNodes = append(dstNodes, node)
-			continue
+	return out, nil
+}
+
+// ResolveRoute implements Networking.ResolveRoute using the given networking,
+// RBAC and label resolver interfaces. When multiple routes contain the address, the one with the
+// longest prefix wins, followed by the lowest metric and then the route name.
+func ResolveRoute(ctx context.Context, nw Networking, rbac RBAC, labels LabelResolver, addr netip.Addr) (*v1.Route, string, error) {
+	if !addr.IsValid() {
+		return nil, "", fmt.Errorf("invalid address: %v", addr)
+	}
+	routes, err := nw.ListRoutes(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("list routes: %w", err)
+	}
+	caller, hasCaller := context.AuthenticatedCallerFrom(ctx)
+	var acls types.NetworkACLs
+	if hasCaller {
+		acls, err = nw.ListNetworkACLs(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("list network acls: %w", err)
 		}
-		groupName := strings.TrimPrefix(node, types.GroupReference)
-		context.LoggerFrom(ctx).Debug("Expanding group reference", "group", groupName)
-		group, err := rbac.GetGroup(ctx, groupName)
+		err = ExpandACLs(ctx, rbac, labels, acls)
 		if err != nil {
-			if !errors.IsGroupNotFound(err) {
-				context.LoggerFrom(ctx).Error("Failed to lookup group", "group", groupName, "error", err.Error())
-				return err
+			return nil, "", fmt.Errorf("expand network acls: %w", err)
+		}
+		acls.Sort(types.SortDescending)
+	}
+	var best *types.Route
+	var bestBits int
+Routes:
+	for i, route := range routes {
+		for _, excluded := range route.ExcludedPrefixes() {
+			if excluded.Contains(addr) {
+				continue Routes
 			}
-			// If the group doesn't exist, we'll just ignore it.
-			continue
 		}
-		for _, subject := range group.GetSubjects() {
-			if !slices.Contains(dstNodes, subject.GetName()) {
-				dstNodes = append(dstNodes, subject.GetName())
+		for _, prefix := range route.DestinationPrefixes() {
+			if !prefix.Contains(addr) {
+				continue
+			}
+			if best != nil {
+				if prefix.Bits() < bestBits {
+					continue
+				}
+				if prefix.Bits() == bestBits {
+					if route.Metric > best.Metric {
+						continue
+					}
+					if route.Metric == best.Metric && route.GetName() >= best.GetName() {
+						continue
+					}
+				}
+			}
+			if hasCaller {
+				action := types.NetworkAction{NetworkAction: &v1.NetworkAction{
+					SrcNode: caller,
+					DstNode: route.GetNode(),
+					DstCIDR: netip.PrefixFrom(addr, addr.BitLen()).String(),
+				}}
+				if !acls.Accept(ctx, action) {
+					continue
+				}
 			}
+			best = &routes[i]
+			bestBits = prefix.Bits()
 		}
 	}
-	acl.DestinationNodes = dstNodes
-	return nil
+	if best == nil {
+		return nil, "", errors.ErrRouteNotFound
+	}
+	return best.Route, best.GetNode(), nil
+}
+
+// ListOrphanedRoutes implements Networking.ListOrphanedRoutes using the given
+// networking and the peer graph to look up which nodes exist.
+func ListOrphanedRoutes(ctx context.Context, nw Networking, peers types.PeerGraphStore) (types.Routes, error) {
+	routes, err := nw.ListRoutes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	ids, err := peers.ListVertices()
+	if err != nil {
+		return nil, fmt.Errorf("list peer ids: %w", err)
+	}
+	out := make(types.Routes, 0)
+	for _, route := range routes {
+		if !slices.Contains(ids, types.NodeID(route.GetNode())) {
+			out = append(out, route)
+		}
+	}
+	return out, nil
+}
+
+// PruneOrphanedRoutes deletes all routes whose node no longer exists in the
+// peers store and returns the routes that were removed.
+func PruneOrphanedRoutes(ctx context.Context, nw Networking) (types.Routes, error) {
+	orphaned, err := nw.ListOrphanedRoutes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list orphaned routes: %w", err)
+	}
+	for _, route := range orphaned {
+		context.LoggerFrom(ctx).Debug("Pruning orphaned route", "route", route.GetName(), "node", route.GetNode())
+		if err := nw.DeleteRoute(ctx, route.GetName()); err != nil {
+			return nil, fmt.Errorf("delete route %q: %w", route.GetName(), err)
+		}
+	}
+	return orphaned, nil
 }
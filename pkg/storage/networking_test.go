@@ -0,0 +1,308 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestOrphanedRoutes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	for _, id := range []string{"node-a", "node-b"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id}})
+		if err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	routes := []*v1.Route{
+		{Name: "route-a", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/24"}},
+		{Name: "route-b", Node: "node-b", DestinationCIDRs: []string{"10.0.1.0/24"}},
+	}
+	for _, rt := range routes {
+		if err := db.Networking().PutRoute(ctx, types.Route{Route: rt}); err != nil {
+			t.Fatalf("put route %s: %v", rt.GetName(), err)
+		}
+	}
+
+	// Nothing should be orphaned while all nodes exist.
+	orphaned, err := db.Networking().ListOrphanedRoutes(ctx)
+	if err != nil {
+		t.Fatalf("list orphaned routes: %v", err)
+	}
+	if len(orphaned) != 0 {
+		t.Fatalf("expected no orphaned routes, got %d", len(orphaned))
+	}
+
+	// Remove a node and its route should be reported.
+	if err := db.Peers().Delete(ctx, "node-b"); err != nil {
+		t.Fatalf("delete node: %v", err)
+	}
+	orphaned, err = db.Networking().ListOrphanedRoutes(ctx)
+	if err != nil {
+		t.Fatalf("list orphaned routes: %v", err)
+	}
+	if len(orphaned) != 1 || orphaned[0].GetName() != "route-b" {
+		t.Fatalf("expected route-b to be orphaned, got %v", orphaned)
+	}
+
+	// Pruning should remove only the orphaned route.
+	pruned, err := storage.PruneOrphanedRoutes(ctx, db.Networking())
+	if err != nil {
+		t.Fatalf("prune orphaned routes: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].GetName() != "route-b" {
+		t.Fatalf("expected route-b to be pruned, got %v", pruned)
+	}
+	if _, err := db.Networking().GetRoute(ctx, "route-b"); !errors.Is(err, errors.ErrRouteNotFound) {
+		t.Errorf("expected route-b to be deleted, got %v", err)
+	}
+	if _, err := db.Networking().GetRoute(ctx, "route-a"); err != nil {
+		t.Errorf("expected route-a to remain, got %v", err)
+	}
+}
+
+func TestResolveRoute(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	routes := []*v1.Route{
+		{Name: "gateway", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/8"}},
+		{Name: "site", Node: "node-b", DestinationCIDRs: []string{"10.20.0.0/16"}},
+		{Name: "host", Node: "node-c", DestinationCIDRs: []string{"10.20.30.40/32"}},
+		{Name: "v6", Node: "node-a", DestinationCIDRs: []string{"fd00::/8"}},
+	}
+	for _, rt := range routes {
+		if err := db.Networking().PutRoute(ctx, types.Route{Route: rt}); err != nil {
+			t.Fatalf("put route %s: %v", rt.GetName(), err)
+		}
+	}
+	// Only allow node-a to reach node-a and node-b.
+	err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "node-a-to-a-and-b",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"node-a"},
+		DestinationNodes: []string{"node-a", "node-b"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+
+	tc := []struct {
+		name      string
+		addr      string
+		caller    string
+		wantRoute string
+		wantNode  string
+		wantErr   error
+	}{
+		{name: "ExactMatch", addr: "10.20.30.40", wantRoute: "host", wantNode: "node-c"},
+		{name: "MostSpecific", addr: "10.20.1.1", wantRoute: "site", wantNode: "node-b"},
+		{name: "LeastSpecific", addr: "10.1.1.1", wantRoute: "gateway", wantNode: "node-a"},
+		{name: "IPv6", addr: "fd00::1", wantRoute: "v6", wantNode: "node-a"},
+		{name: "NoMatch", addr: "192.168.1.1", wantErr: errors.ErrRouteNotFound},
+		{name: "RespectsACLs", addr: "10.20.30.40", caller: "node-a", wantRoute: "site", wantNode: "node-b"},
+		{name: "DeniedByACLs", addr: "10.20.30.40", caller: "node-c", wantErr: errors.ErrRouteNotFound},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := ctx
+			if tt.caller != "" {
+				ctx = context.WithAuthenticatedCaller(ctx, tt.caller)
+			}
+			route, node, err := db.Networking().ResolveRoute(ctx, netip.MustParseAddr(tt.addr))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve route: %v", err)
+			}
+			if route.GetName() != tt.wantRoute {
+				t.Errorf("expected route %q, got %q", tt.wantRoute, route.GetName())
+			}
+			if node != tt.wantNode {
+				t.Errorf("expected node %q, got %q", tt.wantNode, node)
+			}
+		})
+	}
+}
+
+func TestExpandACLLabelReferences(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	putNode := func(id, zoneID string) {
+		t.Helper()
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, ZoneAwarenessID: zoneID}})
+		if err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	putNode("gateway-a", "zone-a,role=gateway")
+	putNode("gateway-b", "role=gateway")
+	putNode("worker-a", "zone-a,role=worker")
+
+	expand := func(ref string) []string {
+		t.Helper()
+		acl := types.NetworkACL{NetworkACL: &v1.NetworkACL{
+			Name:             "label-acl",
+			SourceNodes:      []string{ref, "node-static"},
+			DestinationNodes: []string{ref},
+		}}
+		if err := storage.ExpandACL(ctx, db.RBAC(), db.Peers(), acl); err != nil {
+			t.Fatalf("expand acl: %v", err)
+		}
+		if !slices.Contains(acl.GetSourceNodes(), "node-static") {
+			t.Errorf("expected plain node references to be kept, got %v", acl.GetSourceNodes())
+		}
+		got := slices.Clone(acl.GetDestinationNodes())
+		slices.Sort(got)
+		return got
+	}
+
+	if got := expand("label:role=gateway"); !slices.Equal(got, []string{"gateway-a", "gateway-b"}) {
+		t.Errorf("expected both gateways, got %v", got)
+	}
+	if got := expand("label:role!=gateway"); !slices.Equal(got, []string{"worker-a"}) {
+		t.Errorf("expected only the worker, got %v", got)
+	}
+	// Selectors matching nothing expand to nothing.
+	if got := expand("label:role=database"); len(got) != 0 {
+		t.Errorf("expected no nodes, got %v", got)
+	}
+
+	// Changing labels changes the expansion.
+	putNode("worker-a", "zone-a,role=gateway")
+	putNode("gateway-b", "role=worker")
+	if got := expand("label:role=gateway"); !slices.Equal(got, []string{"gateway-a", "worker-a"}) {
+		t.Errorf("expected relabeled nodes, got %v", got)
+	}
+
+	// Expanded ACLs should be enforced against the labeled nodes.
+	acls := types.NetworkACLs{{NetworkACL: &v1.NetworkACL{
+		Name:             "gateways",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"label:role=gateway"},
+		DestinationNodes: []string{"label:role=gateway"},
+	}}}
+	if err := storage.ExpandACLs(ctx, db.RBAC(), db.Peers(), acls); err != nil {
+		t.Fatalf("expand acls: %v", err)
+	}
+	allowed := acls.Accept(ctx, types.NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "gateway-a", DstNode: "worker-a"}})
+	if !allowed {
+		t.Error("expected gateways to be allowed to communicate")
+	}
+	denied := acls.Accept(ctx, types.NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "gateway-a", DstNode: "gateway-b"}})
+	if denied {
+		t.Error("expected relabeled node to be denied")
+	}
+}
+
+func TestPutRouteKeepsMetric(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	rt := &v1.Route{Name: "route-a", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/24"}}
+	if err := db.Networking().PutRoute(ctx, types.Route{Route: rt, Metric: 10}); err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	// An update without a metric, as made through the API, keeps it.
+	updated := &v1.Route{Name: "route-a", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/16"}}
+	if err := db.Networking().PutRoute(ctx, types.Route{Route: updated}); err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	got, err := db.Networking().GetRoute(ctx, "route-a")
+	if err != nil {
+		t.Fatalf("get route: %v", err)
+	}
+	if got.Metric != 10 {
+		t.Errorf("expected metric 10 to be kept, got %d", got.Metric)
+	}
+	if !slices.Equal(got.GetDestinationCIDRs(), []string{"10.0.0.0/16"}) {
+		t.Errorf("expected destinations to be updated, got %v", got.GetDestinationCIDRs())
+	}
+	// A new metric replaces it.
+	if err := db.Networking().PutRoute(ctx, types.Route{Route: updated, Metric: 20}); err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	got, err = db.Networking().GetRoute(ctx, "route-a")
+	if err != nil {
+		t.Fatalf("get route: %v", err)
+	}
+	if got.Metric != 20 {
+		t.Errorf("expected metric 20, got %d", got.Metric)
+	}
+}
+
+func TestPutRouteKeepsExclusions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	rt := &v1.Route{Name: "route-a", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/16"}}
+	err := db.Networking().PutRoute(ctx, types.Route{Route: rt, ExcludedCIDRs: []string{"10.0.1.0/24"}})
+	if err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	// An update without exclusions, as made through the API, keeps them.
+	if err := db.Networking().PutRoute(ctx, types.Route{Route: rt}); err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	got, err := db.Networking().GetRoute(ctx, "route-a")
+	if err != nil {
+		t.Fatalf("get route: %v", err)
+	}
+	if !slices.Equal(got.ExcludedCIDRs, []string{"10.0.1.0/24"}) {
+		t.Errorf("expected exclusions to be kept, got %v", got.ExcludedCIDRs)
+	}
+	// An empty list clears them.
+	if err := db.Networking().PutRoute(ctx, types.Route{Route: rt, ExcludedCIDRs: []string{}}); err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	got, err = db.Networking().GetRoute(ctx, "route-a")
+	if err != nil {
+		t.Fatalf("get route: %v", err)
+	}
+	if len(got.ExcludedCIDRs) != 0 {
+		t.Errorf("expected exclusions to be cleared, got %v", got.ExcludedCIDRs)
+	}
+}
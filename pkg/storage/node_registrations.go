@@ -0,0 +1,72 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NodeRegistrationsPrefix is where node registrations are stored.
+var NodeRegistrationsPrefix = types.RegistryPrefix.ForString("node-registrations")
+
+// PutNodeRegistration validates and stores the given node registration,
+// replacing any existing registration for the node.
+func PutNodeRegistration(ctx context.Context, st MeshStorage, reg types.NodeRegistration) error {
+	if err := types.ValidateNodeRegistration(reg); err != nil {
+		return fmt.Errorf("validate node registration: %w", err)
+	}
+	data, err := json.Marshal(reg)
+	if err != nil {
+		return fmt.Errorf("marshal node registration: %w", err)
+	}
+	err = st.PutValue(ctx, NodeRegistrationsPrefix.For(reg.ID.Bytes()), data, 0)
+	if err != nil {
+		return fmt.Errorf("put node registration: %w", err)
+	}
+	return nil
+}
+
+// GetNodeRegistration returns the registration for the given node. It returns
+// ErrNodeRegistrationNotFound if the node has not been registered.
+func GetNodeRegistration(ctx context.Context, st MeshStorage, id types.NodeID) (types.NodeRegistration, error) {
+	data, err := st.GetValue(ctx, NodeRegistrationsPrefix.For(id.Bytes()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return types.NodeRegistration{}, errors.ErrNodeRegistrationNotFound
+		}
+		return types.NodeRegistration{}, fmt.Errorf("get node registration: %w", err)
+	}
+	var reg types.NodeRegistration
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return types.NodeRegistration{}, fmt.Errorf("unmarshal node registration: %w", err)
+	}
+	return reg, nil
+}
+
+// DeleteNodeRegistration removes the registration for the given node.
+func DeleteNodeRegistration(ctx context.Context, st MeshStorage, id types.NodeID) error {
+	err := st.Delete(ctx, NodeRegistrationsPrefix.For(id.Bytes()))
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete node registration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// PeerChange is a change to a peer returned by ListPeersChangedSince.
+type PeerChange struct {
+	// Type is the type of change.
+	Type ChangeType `json:"type"`
+	// ID is the ID of the peer.
+	ID types.NodeID `json:"id"`
+	// Node is the peer after the change. It is unset for deletes.
+	Node types.MeshNode `json:"node,omitempty"`
+}
+
+// ListPeersChangedSince returns the peers added, updated, or removed after the
+// given revision, sorted by ID, along with the current revision. Clients that
+// cannot stream changes can poll with the returned revision to receive only the
+// net changes made since their previous call. A revision of zero returns every
+// peer as a create. Like ChangeFeed, a revision that is not available, such as
+// one that is no longer retained or one returned by a node that has applied
+// more of the log, fails with ErrInvalidRevision instead of returning a wrong
+// delta. The client must then discard its peers and resync from zero.
+func ListPeersChangedSince(ctx context.Context, st MeshStorage, revision uint64) ([]PeerChange, uint64, error) {
+	current, err := st.Revision(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get current revision: %w", err)
+	}
+	if revision > current {
+		return nil, 0, fmt.Errorf("%w: %d is newer than the current revision %d", errors.ErrInvalidRevision, revision, current)
+	}
+	if revision == current {
+		return nil, current, nil
+	}
+	reg := NewRegistry[*v1.MeshNode](st, NodesPrefix)
+	nodesAt := func(rev uint64) (map[string]types.MeshNode, error) {
+		nodes := make(map[string]types.MeshNode)
+		if rev == 0 {
+			return nodes, nil
+		}
+		err := reg.IterAt(ctx, rev, func(name string, node *v1.MeshNode) error {
+			nodes[name] = types.MeshNode{MeshNode: node}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list nodes at revision %d: %w", rev, err)
+		}
+		return nodes, nil
+	}
+	prev, err := nodesAt(revision)
+	if err != nil {
+		return nil, 0, err
+	}
+	next, err := nodesAt(current)
+	if err != nil {
+		return nil, 0, err
+	}
+	events := diffFeedObjects(nil, ResourceNode, current, prev, next,
+		peerContentEqual,
+		func(ev *ChangeEvent, node types.MeshNode) { ev.Node = node },
+	)
+	changes := make([]PeerChange, len(events))
+	for i, ev := range events {
+		changes[i] = PeerChange{Type: ev.Type, ID: types.NodeID(ev.Name), Node: ev.Node}
+	}
+	slices.SortFunc(changes, func(a, b PeerChange) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return changes, current, nil
+}
+
+// peerContentEqual compares two peers ignoring their joined timestamp, which
+// is refreshed every time a peer is written.
+func peerContentEqual(a, b types.MeshNode) bool {
+	ac := proto.Clone(a.MeshNode).(*v1.MeshNode)
+	bc := proto.Clone(b.MeshNode).(*v1.MeshNode)
+	ac.JoinedAt, bc.JoinedAt = nil, nil
+	return proto.Equal(ac, bc)
+}
@@ -18,11 +18,14 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"net/netip"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -35,6 +38,40 @@ var NodesPrefix = types.RegistryPrefix.ForString("nodes")
 // in the format /registry/edges/<source>/<target>.
 var EdgesPrefix = types.RegistryPrefix.ForString("edges")
 
+// HeartbeatsPrefix is where the last time each node was seen is stored.
+// Heartbeats are indexed by node ID in the format /registry/heartbeats/<id>.
+var HeartbeatsPrefix = types.RegistryPrefix.ForString("heartbeats")
+
+// NodeKey returns the key for the node with the given ID.
+func NodeKey(id types.NodeID) []byte {
+	return NodesPrefix.For(id.Bytes())
+}
+
+// HeartbeatKey returns the key for the heartbeat of the node with the given ID.
+func HeartbeatKey(id types.NodeID) []byte {
+	return HeartbeatsPrefix.For(id.Bytes())
+}
+
+// EncodeHeartbeat encodes a heartbeat time for storage.
+func EncodeHeartbeat(at time.Time) []byte {
+	return []byte(at.UTC().Format(time.RFC3339Nano))
+}
+
+// DecodeHeartbeat decodes a heartbeat stored at the given key.
+func DecodeHeartbeat(key, value []byte) (types.NodeID, time.Time, error) {
+	id := types.NodeID(HeartbeatsPrefix.TrimFrom(key))
+	at, err := time.Parse(time.RFC3339Nano, string(value))
+	if err != nil {
+		return id, time.Time{}, fmt.Errorf("parse heartbeat for %s: %w", id, err)
+	}
+	return id, at, nil
+}
+
+// EdgeKey returns the key for the edge between the given nodes.
+func EdgeKey(source, target types.NodeID) []byte {
+	return EdgesPrefix.For(source.Bytes()).For(target.Bytes())
+}
+
 // PeerSubscribeFunc is a function that can be used to subscribe to peer changes.
 // The function is called with multiple peers when the change reflects a new edge
 // being added or removed. The function is called with a single peer when the
@@ -47,16 +84,30 @@ type Peers interface {
 	Graph() types.PeerGraph
 	// Put creates or updates a node.
 	Put(ctx context.Context, n types.MeshNode) error
+	// Create creates a node that must not already exist. ErrNodeExists is
+	// returned if it does, including when a concurrent create won.
+	Create(ctx context.Context, n types.MeshNode) error
 	// Get gets a node by ID.
 	Get(ctx context.Context, id types.NodeID) (types.MeshNode, error)
 	// GetByPubKey gets a node by their public key.
 	GetByPubKey(ctx context.Context, key crypto.PublicKey) (types.MeshNode, error)
+	// GetByAddress gets the node that owns the given mesh address. IPv6
+	// addresses anywhere within a node's /64 are matched.
+	GetByAddress(ctx context.Context, addr netip.Addr) (types.MeshNode, error)
 	// Delete deletes a node.
 	Delete(ctx context.Context, id types.NodeID) error
 	// List lists all nodes.
 	List(ctx context.Context, filters ...PeerFilter) ([]types.MeshNode, error)
 	// ListIDs lists all node IDs.
 	ListIDs(ctx context.Context) ([]types.NodeID, error)
+	// ListPeersByLabel lists all nodes whose labels match the selector.
+	ListPeersByLabel(ctx context.Context, selector types.LabelSelector) ([]types.MeshNode, error)
+	// PutHeartbeat records that the node was seen at the given time.
+	PutHeartbeat(ctx context.Context, id types.NodeID, at time.Time) error
+	// ListStaleNodes lists all nodes that have not been seen since the given
+	// time. Nodes that have never sent a heartbeat are judged by the time
+	// they joined, and are skipped if that is unknown.
+	ListStaleNodes(ctx context.Context, notSeenSince time.Time) ([]types.MeshNode, error)
 	// Subscribe subscribes to node changes.
 	Subscribe(ctx context.Context, fn PeerSubscribeFunc) (context.CancelFunc, error)
 	// AddEdge adds an edge between two nodes.
@@ -109,9 +160,26 @@ func FilterByIsPublic() PeerFilter {
 }
 
 // FilterByZoneID returns a new filter that matches nodes in a given zone.
+// Nodes available in multiple zones match any of them.
 func FilterByZoneID(zoneID string) PeerFilter {
 	return func(node types.MeshNode) bool {
-		return node.GetZoneAwarenessID() == zoneID
+		return node.GetZoneAwarenessID() == zoneID || node.InZone(zoneID)
+	}
+}
+
+// FilterByAnyZone returns a new filter that matches nodes sharing at least one
+// of the given zones.
+func FilterByAnyZone(zones []string) PeerFilter {
+	return func(node types.MeshNode) bool {
+		return types.ZonesOverlap(node.Zones(), zones)
+	}
+}
+
+// FilterByLabels returns a new filter that matches nodes whose labels
+// satisfy the given selector.
+func FilterByLabels(selector types.LabelSelector) PeerFilter {
+	return func(node types.MeshNode) bool {
+		return selector.Matches(node.Labels())
 	}
 }
 
@@ -137,3 +205,57 @@ func FilterAgainstNode(nodeID types.NodeID) PeerFilter {
 		return node.NodeID() != nodeID
 	}
 }
+
+// PutNodeInBatch validates the given node and queues it for writing in the batch.
+func PutNodeInBatch(batch Batch, node types.MeshNode) error {
+	key, data, err := encodeNode(node)
+	if err != nil {
+		return err
+	}
+	batch.PutValue(key, data, 0)
+	return nil
+}
+
+// PutNodeIfAbsentInBatch validates the given node and queues it for writing in
+// the batch only if it does not already exist. Committing the batch fails with
+// ErrKeyExists if it does.
+func PutNodeIfAbsentInBatch(batch Batch, node types.MeshNode) error {
+	key, data, err := encodeNode(node)
+	if err != nil {
+		return err
+	}
+	batch.PutValueIfAbsent(key, data, 0)
+	return nil
+}
+
+func encodeNode(node types.MeshNode) (key, data []byte, err error) {
+	validated, err := types.ValidateMeshNode(node)
+	if err != nil {
+		return nil, nil, fmt.Errorf("validate node: %w", err)
+	}
+	data, err = validated.MarshalProtoJSON()
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal node: %w", err)
+	}
+	return NodeKey(validated.NodeID()), data, nil
+}
+
+// PutEdgeInBatch validates the given edge and queues it for writing in the batch.
+// Edges from a node to itself are ignored.
+func PutEdgeInBatch(batch Batch, edge types.MeshEdge) error {
+	if edge.Source == edge.Target {
+		return nil
+	}
+	if !edge.SourceID().IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, edge.SourceID())
+	}
+	if !edge.TargetID().IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, edge.TargetID())
+	}
+	data, err := edge.MarshalProtoJSON()
+	if err != nil {
+		return fmt.Errorf("marshal edge: %w", err)
+	}
+	batch.PutValue(EdgeKey(edge.SourceID(), edge.TargetID()), data, 0)
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultPresharedKeyRotationWindow is the default time between starting a
+// preshared key rotation and both ends of the edge switching to the new key.
+const DefaultPresharedKeyRotationWindow = 30 * time.Second
+
+// PresharedKeyRotationGrace is how long a rotation stays pending after its
+// activation time before the old key is retired. Nodes switch keys at the
+// activation time on their own clocks, so it keeps the rotation readable by
+// nodes whose clocks are behind.
+const PresharedKeyRotationGrace = 2 * time.Minute
+
+// RotatePresharedKey starts a rotation of the WireGuard preshared key on the
+// edge between the given nodes. A new key is stored on the edge as pending
+// along with the time, window from now, at which both ends switch to it.
+// Both ends learn the new key ahead of time and switch independently at the
+// activation time. WireGuard sessions outlive a change of preshared key, so
+// the tunnel is kept as long as both ends switch before the next handshake.
+// The old key is retired once the rotation is completed.
+//
+// The activation time is taken from the local clock and each end switches by
+// its own clock, since WireGuard only accepts a single preshared key per
+// peer. The clocks of the two ends must therefore agree to well within the
+// 90 seconds WireGuard keeps retrying a handshake. A handshake attempted
+// between the two switches fails and is retried until both ends switched.
+func RotatePresharedKey(ctx context.Context, peers Peers, source, target types.NodeID, window time.Duration) (wgtypes.Key, time.Time, error) {
+	if window <= 0 {
+		window = DefaultPresharedKeyRotationWindow
+	}
+	edge, err := peers.GetEdge(ctx, source, target)
+	if err != nil {
+		return wgtypes.Key{}, time.Time{}, fmt.Errorf("get edge: %w", err)
+	}
+	recipients, err := PresharedKeyRecipients(ctx, peers, edge.SourceID(), edge.TargetID())
+	if err != nil {
+		return wgtypes.Key{}, time.Time{}, err
+	}
+	now := time.Now()
+	attrs, _ := types.EdgeAttrsWithCompletedRotation(edge.GetAttributes(), now.Add(-PresharedKeyRotationGrace))
+	if activateAt, ok := types.PresharedKeyRotationFromEdgeAttrs(attrs); ok {
+		return wgtypes.Key{}, time.Time{}, fmt.Errorf("%w: activates at %s", errors.ErrRotationInProgress, activateAt)
+	}
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		return wgtypes.Key{}, time.Time{}, fmt.Errorf("generate preshared key: %w", err)
+	}
+	activateAt := now.Add(window)
+	attrs, err = types.EdgeAttrsWithPendingPresharedKey(attrs, key, recipients, activateAt)
+	if err != nil {
+		return wgtypes.Key{}, time.Time{}, err
+	}
+	err = peers.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source:     edge.GetSource(),
+		Target:     edge.GetTarget(),
+		Weight:     edge.GetWeight(),
+		Attributes: attrs,
+	}})
+	if err != nil {
+		return wgtypes.Key{}, time.Time{}, fmt.Errorf("put edge: %w", err)
+	}
+	context.LoggerFrom(ctx).Info("Started preshared key rotation",
+		"source", edge.GetSource(), "target", edge.GetTarget(), "activate-at", activateAt)
+	return key, activateAt, nil
+}
+
+// CompletePresharedKeyRotations promotes every pending preshared key that
+// has been active for at least PresharedKeyRotationGrace at the given time
+// to the current key of its edge, retiring the old keys. It is run
+// periodically by the leader. It returns the number of rotations completed.
+func CompletePresharedKeyRotations(ctx context.Context, peers Peers, now time.Time) (int, error) {
+	edges, err := peers.Graph().Edges()
+	if err != nil {
+		return 0, fmt.Errorf("list edges: %w", err)
+	}
+	before := now.Add(-PresharedKeyRotationGrace)
+	var completed int
+	for _, edge := range edges {
+		attrs, ok := types.EdgeAttrsWithCompletedRotation(edge.Properties.Attributes, before)
+		if !ok {
+			continue
+		}
+		err = peers.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+			Source:     edge.Source.String(),
+			Target:     edge.Target.String(),
+			Weight:     int32(edge.Properties.Weight),
+			Attributes: attrs,
+		}})
+		if err != nil {
+			return completed, fmt.Errorf("put edge: %w", err)
+		}
+		context.LoggerFrom(ctx).Debug("Completed preshared key rotation", "source", edge.Source, "target", edge.Target)
+		completed++
+	}
+	return completed, nil
+}
+
+// PresharedKeyRecipients returns the public keys of the nodes on either end
+// of an edge, keyed by node ID. Preshared keys stored on the edge are sealed
+// to these keys.
+func PresharedKeyRecipients(ctx context.Context, peers Peers, source, target types.NodeID) (map[types.NodeID]crypto.PublicKey, error) {
+	recipients := make(map[types.NodeID]crypto.PublicKey, 2)
+	for _, id := range []types.NodeID{source, target} {
+		node, err := peers.Get(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("get node %s: %w", id, err)
+		}
+		key, err := node.DecodePublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("decode public key of %s: %w", id, err)
+		}
+		recipients[id] = key
+	}
+	return recipients, nil
+}
@@ -56,6 +56,14 @@ type Provider interface {
 	MeshStorage() MeshStorage
 }
 
+// ReplicaStatusReporter is implemented by providers that can report how up
+// to date their local storage is. It allows non-leaders to serve reads from
+// their applied state while telling clients how fresh the data is.
+type ReplicaStatusReporter interface {
+	// ReplicaStatus returns the current replication status of local storage.
+	ReplicaStatus() types.ReplicaStatus
+}
+
 // MeshDB is the interface for the mesh database. It provides access to all
 // storage interfaces.
 type MeshDB interface {
@@ -66,6 +74,14 @@ type MeshDB interface {
 	Peers() Peers
 }
 
+// RevisionMeshDB is implemented by MeshDBs that can be read at a single
+// revision of their storage.
+type RevisionMeshDB interface {
+	// AtCurrentRevision returns a read-only MeshDB of the current revision
+	// of the storage.
+	AtCurrentRevision(ctx context.Context) (MeshDB, error)
+}
+
 // MeshDataStore is an interface for storing and retrieving data about the state of the mesh.
 // It can be implemented by external providers to be wrapped into a MeshDB for use throughout
 // the library.
@@ -134,9 +150,48 @@ type MeshStorage interface {
 	// that the iterator not attempt any write operations as this will cause
 	// a deadlock. The iteration will stop if the iterator returns an error.
 	IterPrefix(ctx context.Context, prefix []byte, fn PrefixIterator) error
+	// Revision returns the current revision of the storage. It can be passed
+	// to IterPrefixAt to iterate over a consistent view of the storage.
+	Revision(ctx context.Context) (uint64, error)
+	// IterPrefixAt iterates over all keys with a given prefix as they existed
+	// at the given revision. Writes made after the revision are not observed,
+	// so the iterator may safely perform write operations. Only a bounded
+	// number of recent revisions are retained, and ErrInvalidRevision is
+	// returned for a revision that is not.
+	IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn PrefixIterator) error
 	// Subscribe will call the given function whenever a key with the given prefix is changed.
 	// The returned function can be called to unsubscribe.
 	Subscribe(ctx context.Context, prefix []byte, fn KVSubscribeFunc) (context.CancelFunc, error)
+	// Batch returns a new Batch for grouping write operations. Whether the
+	// batch is committed atomically depends on the implementation, see Batch.
+	Batch() Batch
+}
+
+// Batch is a group of write operations that are committed to storage together.
+// Operations are not visible to readers until Commit is called.
+//
+// Batches from the raft and badger storage are atomic: either all of the
+// operations are applied or none of them are. Storage with no way to group
+// writes, such as the passthrough, external and rpcdb storage, returns a batch
+// from NewSequentialBatch instead. Those batches apply operations one at a
+// time, so a failed Commit can leave the operations before the failure
+// applied, and readers can observe a partially committed batch.
+type Batch interface {
+	// PutValue queues setting the value of a key. TTL is optional and can be set to 0.
+	PutValue(key, value []byte, ttl time.Duration)
+	// PutValueIfAbsent queues setting the value of a key that must not exist
+	// when the batch is committed. If it does, Commit fails with ErrKeyExists
+	// and none of the operations are applied. For sequential batches the check
+	// is made before any writes, but is not atomic with them.
+	PutValueIfAbsent(key, value []byte, ttl time.Duration)
+	// Delete queues the removal of a key.
+	Delete(key []byte)
+	// Len returns the number of queued operations.
+	Len() int
+	// Commit applies all queued operations. For atomic batches either all of
+	// the operations are applied or none of them are. A batch should not be
+	// reused after Commit.
+	Commit(ctx context.Context) error
 }
 
 // ConsensusStorage is the interface for storing and retrieving data about the state of consensus.
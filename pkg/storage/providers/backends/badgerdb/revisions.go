@@ -0,0 +1,109 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package badgerdb
+
+import (
+	"github.com/dgraph-io/badger/v4"
+)
+
+// RevisionHistory is the number of revisions returned by Revision that are
+// retained for IterPrefixAt. Each retained revision holds a read transaction
+// open, which keeps badger from discarding the versions it observes.
+var RevisionHistory = 256
+
+// revisionPin is a read transaction held open for a revision.
+type revisionPin struct {
+	txn     *badger.Txn
+	refs    int
+	evicted bool
+}
+
+// revisionPins are the revisions retained for IterPrefixAt.
+type revisionPins struct {
+	pins  map[uint64]*revisionPin
+	order []uint64
+}
+
+// pinRevision retains the current revision of the database and returns it.
+func (db *badgerDB) pinRevision() uint64 {
+	txn := db.db.NewTransaction(false)
+	rev := txn.ReadTs()
+	db.pinmu.Lock()
+	defer db.pinmu.Unlock()
+	if db.revisions.pins == nil {
+		db.revisions.pins = make(map[uint64]*revisionPin)
+	}
+	if _, ok := db.revisions.pins[rev]; ok {
+		txn.Discard()
+		return rev
+	}
+	db.revisions.pins[rev] = &revisionPin{txn: txn}
+	db.revisions.order = append(db.revisions.order, rev)
+	for len(db.revisions.order) > max(RevisionHistory, 1) {
+		db.evictRevision(db.revisions.order[0])
+		db.revisions.order = db.revisions.order[1:]
+	}
+	return rev
+}
+
+// acquireRevision returns the read transaction of a retained revision. The
+// transaction must be released with releaseRevision.
+func (db *badgerDB) acquireRevision(rev uint64) (*revisionPin, bool) {
+	db.pinmu.Lock()
+	defer db.pinmu.Unlock()
+	pin, ok := db.revisions.pins[rev]
+	if !ok {
+		return nil, false
+	}
+	pin.refs++
+	return pin, true
+}
+
+// releaseRevision releases a transaction returned by acquireRevision.
+func (db *badgerDB) releaseRevision(pin *revisionPin) {
+	db.pinmu.Lock()
+	defer db.pinmu.Unlock()
+	pin.refs--
+	if pin.evicted && pin.refs == 0 {
+		pin.txn.Discard()
+	}
+}
+
+// releaseRevisions stops retaining every revision. It is called before the
+// contents of the database are replaced.
+func (db *badgerDB) releaseRevisions() {
+	db.pinmu.Lock()
+	defer db.pinmu.Unlock()
+	for _, rev := range db.revisions.order {
+		db.evictRevision(rev)
+	}
+	db.revisions.order = nil
+}
+
+// evictRevision stops retaining a revision. The transaction is discarded
+// once no iteration is using it. It must be called with pinmu held.
+func (db *badgerDB) evictRevision(rev uint64) {
+	pin, ok := db.revisions.pins[rev]
+	if !ok {
+		return
+	}
+	delete(db.revisions.pins, rev)
+	pin.evicted = true
+	if pin.refs == 0 {
+		pin.txn.Discard()
+	}
+}
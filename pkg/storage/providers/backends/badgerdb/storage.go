@@ -73,6 +73,8 @@ type badgerDB struct {
 	db                *badger.DB
 	firstIdx, lastIdx atomic.Uint64
 	mu                sync.Mutex
+	revisions         revisionPins
+	pinmu             sync.Mutex
 }
 
 // New creates a new BadgerDB storage.
@@ -174,6 +176,7 @@ func (t *TempDiskStorage) Close() error {
 func (db *badgerDB) DropAll(ctx context.Context) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.releaseRevisions()
 	return db.db.DropAll()
 }
 
@@ -228,6 +231,56 @@ func (db *badgerDB) Delete(ctx context.Context, key []byte) error {
 	return nil
 }
 
+// Batch returns a new batch that commits all operations in a single transaction.
+func (db *badgerDB) Batch() storage.Batch {
+	return &badgerBatch{db: db}
+}
+
+type badgerBatch struct {
+	storage.BatchOps
+	db *badgerDB
+}
+
+// Commit applies all queued operations in a single transaction.
+func (b *badgerBatch) Commit(ctx context.Context) error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+	err := b.db.db.Update(func(txn *badger.Txn) error {
+		for _, key := range b.Absent() {
+			_, err := txn.Get(key)
+			if err == nil {
+				return errors.NewKeyExistsError(key)
+			}
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+		}
+		for _, op := range b.Ops() {
+			switch op.GetType() {
+			case v1.RaftCommandType_PUT:
+				entry := badger.NewEntry(op.GetKey(), op.GetValue())
+				if ttl := op.GetTtl().AsDuration(); ttl > 0 {
+					entry = entry.WithTTL(ttl)
+				}
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
+			case v1.RaftCommandType_DELETE:
+				if err := txn.Delete(op.GetKey()); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown batch operation: %v", op.GetType())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
+
 // ListKeys returns all keys with a given prefix.
 func (db *badgerDB) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
 	db.mu.Lock()
@@ -297,6 +350,49 @@ func (db *badgerDB) IterPrefix(ctx context.Context, prefix []byte, fn storage.Pr
 	return err
 }
 
+// Revision returns the current read timestamp of the database. The last
+// RevisionHistory revisions returned are retained for IterPrefixAt. The
+// timestamps are local to the database and mean nothing on another node.
+func (db *badgerDB) Revision(ctx context.Context) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.pinRevision(), nil
+}
+
+// IterPrefixAt iterates over all keys with a given prefix as they existed at
+// the given revision. The revision must be one returned by Revision that is
+// still retained, otherwise ErrInvalidRevision is returned. The database lock
+// is not held during iteration, so the iterator is free to perform write
+// operations.
+func (db *badgerDB) IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn storage.PrefixIterator) error {
+	pin, ok := db.acquireRevision(revision)
+	if !ok {
+		return fmt.Errorf("%w: %d is not retained", errors.ErrInvalidRevision, revision)
+	}
+	defer db.releaseRevision(pin)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := pin.txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("iterate prefix at revision: %w", err)
+		}
+		item := it.Item()
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return fmt.Errorf("iterate prefix at revision: %w", err)
+		}
+		if err := fn(item.KeyCopy(nil), val); err != nil {
+			if errors.Is(err, storage.ErrStopIteration) {
+				return nil
+			}
+			return fmt.Errorf("iterate prefix at revision: %w", err)
+		}
+	}
+	return nil
+}
+
 // Subscribe will call the given function whenever a key with the given prefix is changed.
 // The returned function can be called to unsubscribe.
 func (db *badgerDB) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) (context.CancelFunc, error) {
@@ -391,6 +487,7 @@ func (db *badgerDB) Restore(ctx context.Context, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("badger restore: %w", err)
 	}
+	db.releaseRevisions()
 	err = db.db.DropAll()
 	if err != nil {
 		return fmt.Errorf("badger restore: %w", err)
@@ -422,6 +519,7 @@ func (db *badgerDB) Restore(ctx context.Context, r io.Reader) error {
 func (db *badgerDB) Close() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.releaseRevisions()
 	return db.db.Close()
 }
 
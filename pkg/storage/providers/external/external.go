@@ -59,6 +59,9 @@ type Options struct {
 	Server string
 	// TLSConfig is the TLS configuration for the storage provider.
 	TLSConfig *tls.Config
+	// Codec is the codec values are written to storage with. Defaults to
+	// storage.ProtoJSONCodec.
+	Codec storage.Codec
 	// LogLevel is the log level for the storage provider.
 	LogLevel string
 	// LogFormat is the log format for the storage provider.
@@ -361,6 +364,11 @@ type ExternalStorage struct {
 	*Provider
 }
 
+// ValueCodec returns the codec values are written with.
+func (ext *ExternalStorage) ValueCodec() storage.Codec {
+	return ext.Codec
+}
+
 // GetValue returns the value of a key.
 func (ext *ExternalStorage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	ext.mu.RLock()
@@ -471,6 +479,22 @@ func (ext *ExternalStorage) IterPrefix(ctx context.Context, prefix []byte, fn st
 	return nil
 }
 
+// Revision is not supported by the plugin interface.
+func (ext *ExternalStorage) Revision(ctx context.Context) (uint64, error) {
+	return 0, errors.ErrNotImplemented
+}
+
+// IterPrefixAt is not supported by the plugin interface.
+func (ext *ExternalStorage) IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn storage.PrefixIterator) error {
+	return errors.ErrNotImplemented
+}
+
+// Batch returns a new batch. The plugin interface has no way to group writes,
+// so operations are committed one at a time and the batch is not atomic.
+func (ext *ExternalStorage) Batch() storage.Batch {
+	return storage.NewSequentialBatch(ext)
+}
+
 // Subscribe will call the given function whenever a key with the given prefix is changed.
 // The returned function can be called to unsubscribe.
 func (ext *ExternalStorage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) (context.CancelFunc, error) {
@@ -34,6 +34,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -255,6 +256,52 @@ func (g *GraphStore) Subscribe(ctx context.Context, fn storage.PeerSubscribeFunc
 	return func() {}, errors.ErrNotStorageNode
 }
 
+func (g *GraphStore) CreateNode(ctx context.Context, node types.MeshNode) error {
+	return errors.ErrNotStorageNode
+}
+
+func (g *GraphStore) PutHeartbeat(ctx context.Context, id types.NodeID, at time.Time) error {
+	return errors.ErrNotStorageNode
+}
+
+func (g *GraphStore) ListHeartbeats(ctx context.Context) (map[types.NodeID]time.Time, error) {
+	err := g.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.cli.Query(ctx, &v1.QueryRequest{
+		Command: v1.QueryRequest_LIST,
+		Type:    v1.QueryRequest_KEYS,
+		Query:   types.NewQueryFilters().WithID(storage.HeartbeatsPrefix.String()).Encode(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[types.NodeID]time.Time, len(resp.GetItems()))
+	for _, key := range resp.GetItems() {
+		value, err := g.cli.Query(ctx, &v1.QueryRequest{
+			Command: v1.QueryRequest_GET,
+			Type:    v1.QueryRequest_VALUE,
+			Query:   types.NewQueryFilters().WithID(string(key)).Encode(),
+		})
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, err
+		}
+		if len(value.GetItems()) == 0 {
+			continue
+		}
+		id, at, err := storage.DecodeHeartbeat(key, value.GetItems()[0])
+		if err != nil {
+			return nil, err
+		}
+		out[id] = at
+	}
+	return out, nil
+}
+
 // RBACStore is a passthrough RBAC store that uses the storage API to field
 // read requests.
 type RBACStore struct {
@@ -488,6 +535,18 @@ func (r *RBACStore) ListUserRoles(ctx context.Context, user types.NodeID) (types
 	return r.ListNodeRoles(ctx, user)
 }
 
+func (r *RBACStore) Enforce(ctx context.Context, identity types.NodeID, verb v1.RuleVerb, resource types.RBACResource, opts types.EvalOptions) error {
+	return storage.Enforce(ctx, r, identity, verb, resource, opts)
+}
+
+func (r *RBACStore) ExportRBAC(ctx context.Context) (types.RBACPolicy, error) {
+	return storage.ExportRBAC(ctx, r)
+}
+
+func (r *RBACStore) ImportRBAC(ctx context.Context, policy types.RBACPolicy, mode storage.RBACImportMode) error {
+	return errors.ErrNotStorageNode
+}
+
 // StateStore is a passthrough state store that uses the storage API to field
 // read requests.
 type StateStore struct {
@@ -498,6 +557,64 @@ func (st *StateStore) SetMeshState(_ context.Context, _ types.NetworkState) erro
 	return errors.ErrNotStorageNode
 }
 
+func (st *StateStore) SetLeader(_ context.Context, _ types.StoragePeer) error {
+	return errors.ErrNotStorageNode
+}
+
+func (st *StateStore) GetLeader(ctx context.Context) (types.StoragePeer, error) {
+	var leader types.StoragePeer
+	err := st.dial(ctx)
+	if err != nil {
+		return leader, err
+	}
+	resp, err := st.cli.Query(ctx, &v1.QueryRequest{
+		Command: v1.QueryRequest_GET,
+		Type:    v1.QueryRequest_VALUE,
+		Query:   types.NewQueryFilters().WithID(string(state.LeaderKey)).Encode(),
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return leader, errors.NewKeyNotFoundError(state.LeaderKey)
+		}
+		return leader, err
+	}
+	if len(resp.GetItems()) == 0 {
+		return leader, errors.NewKeyNotFoundError(state.LeaderKey)
+	}
+	var peer v1.StoragePeer
+	if err := storage.UnmarshalValue(resp.GetItems()[0], &peer); err != nil {
+		return leader, err
+	}
+	leader.StoragePeer = &peer
+	return leader, nil
+}
+
+func (st *StateStore) SetSchemaVersion(_ context.Context, _ uint64) error {
+	return errors.ErrNotStorageNode
+}
+
+func (st *StateStore) GetSchemaVersion(ctx context.Context) (uint64, error) {
+	err := st.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := st.cli.Query(ctx, &v1.QueryRequest{
+		Command: v1.QueryRequest_GET,
+		Type:    v1.QueryRequest_VALUE,
+		Query:   types.NewQueryFilters().WithID(string(state.SchemaVersionKey)).Encode(),
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(resp.GetItems()) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseUint(string(resp.GetItems()[0]), 10, 64)
+}
+
 func (st *StateStore) GetMeshState(ctx context.Context) (types.NetworkState, error) {
 	var state types.NetworkState
 	err := st.dial(ctx)
@@ -691,3 +808,13 @@ func (nw *NetworkingStore) ListRoutes(ctx context.Context) (types.Routes, error)
 	}
 	return out, nil
 }
+
+func (nw *NetworkingStore) ResolveRoute(ctx context.Context, addr netip.Addr) (*v1.Route, string, error) {
+	return storage.ResolveRoute(ctx, nw, nw.MeshDataStore.RBAC(), storage.NewGraphLabelResolver(nw.MeshDataStore.GraphStore()), addr)
+}
+
+// ListOrphanedRoutes returns all routes whose node no longer exists in
+// the mesh.
+func (nw *NetworkingStore) ListOrphanedRoutes(ctx context.Context) (types.Routes, error) {
+	return storage.ListOrphanedRoutes(ctx, nw, nw.MeshDataStore.GraphStore())
+}
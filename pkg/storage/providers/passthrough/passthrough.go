@@ -49,6 +49,9 @@ type Options struct {
 	NodeID string
 	// Dialer is the dialer to use for connecting to other nodes.
 	Dialer transport.NodeDialer
+	// Codec is the codec values are written to storage with. Defaults to
+	// storage.ProtoJSONCodec.
+	Codec storage.Codec
 	// LogLevel is the log level to use.
 	LogLevel string
 	// LogFormat is the log format to use.
@@ -248,6 +251,11 @@ type Storage struct {
 	*Provider
 }
 
+// ValueCodec returns the codec values are written with.
+func (p *Storage) ValueCodec() storage.Codec {
+	return p.Codec
+}
+
 // GetValue returns the value of a key.
 func (p *Storage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	cli, close, err := p.newStorageClient(ctx)
@@ -338,6 +346,22 @@ func (p *Storage) IterPrefix(ctx context.Context, prefix []byte, fn storage.Pref
 	return nil
 }
 
+// Revision is not supported over the passthrough storage.
+func (p *Storage) Revision(ctx context.Context) (uint64, error) {
+	return 0, errors.ErrNotImplemented
+}
+
+// IterPrefixAt is not supported over the passthrough storage.
+func (p *Storage) IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn storage.PrefixIterator) error {
+	return errors.ErrNotImplemented
+}
+
+// Batch returns a new batch. Operations are committed one at a time, so the
+// batch is not atomic.
+func (p *Storage) Batch() storage.Batch {
+	return storage.NewSequentialBatch(p)
+}
+
 // Subscribe will call the given function whenever a key with the given prefix is changed.
 // The returned function can be called to unsubscribe.
 func (p *Storage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) (context.CancelFunc, error) {
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+const (
+	// DefaultMinFreeDiskSpace is the default minimum free space required on the
+	// filesystem containing the data directory.
+	DefaultMinFreeDiskSpace = 64 << 20 // 64 MiB
+	// DefaultDiskSpaceCheckInterval is the default interval for checking free
+	// disk space while running.
+	DefaultDiskSpaceCheckInterval = 30 * time.Second
+)
+
+// DiskSpaceFunc returns the number of bytes available for writing on the
+// filesystem containing the given path.
+type DiskSpaceFunc func(path string) (uint64, error)
+
+// diskSpaceFunc returns the configured DiskSpaceFunc or the system default.
+func (o *Options) diskSpaceFunc() DiskSpaceFunc {
+	if o.DiskSpace != nil {
+		return o.DiskSpace
+	}
+	return AvailableDiskSpace
+}
+
+// checkDiskSpace returns an error wrapping ErrInsufficientDiskSpace if the
+// free space at the given path is below the configured minimum.
+func (o *Options) checkDiskSpace(path string) error {
+	if o.InMemory || o.MinFreeDiskSpace == 0 {
+		return nil
+	}
+	free, err := o.diskSpaceFunc()(path)
+	if err != nil {
+		return fmt.Errorf("check free disk space: %w", err)
+	}
+	if free < o.MinFreeDiskSpace {
+		return fmt.Errorf("%w: %d bytes free in %s, need at least %d",
+			errors.ErrInsufficientDiskSpace, free, path, o.MinFreeDiskSpace)
+	}
+	return nil
+}
+
+// checkWritable returns ErrInsufficientDiskSpace if writes are paused due
+// to low disk space.
+func (r *Provider) checkWritable() error {
+	if r.diskSpaceLow.Load() {
+		return errors.ErrInsufficientDiskSpace
+	}
+	return nil
+}
+
+// diskSpaceLogStore is a raft.LogStore that refuses to store command logs while
+// writes are paused due to low disk space. This covers followers, which never go
+// through ApplyRaftLog: they reject AppendEntries from the leader instead of
+// persisting and applying the commands, and the leader backs off and retries
+// them until space is freed. Configuration and no-op logs are small and keep
+// elections working, so they are always stored.
+type diskSpaceLogStore struct {
+	raft.LogStore
+	provider *Provider
+}
+
+// StoreLog stores a log entry if there is enough disk space.
+func (d *diskSpaceLogStore) StoreLog(log *raft.Log) error {
+	return d.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries if there is enough disk space.
+func (d *diskSpaceLogStore) StoreLogs(logs []*raft.Log) error {
+	for _, log := range logs {
+		if log.Type != raft.LogCommand {
+			continue
+		}
+		if err := d.provider.checkWritable(); err != nil {
+			return err
+		}
+		break
+	}
+	return d.LogStore.StoreLogs(logs)
+}
+
+// watchDiskSpace periodically checks the free disk space and pauses writes
+// while it is below the configured minimum.
+func (r *Provider) watchDiskSpace() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	interval := r.Options.DiskSpaceCheckInterval
+	if interval <= 0 {
+		interval = DefaultDiskSpaceCheckInterval
+	}
+	go func() {
+		defer close(doneCh)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-t.C:
+				r.updateDiskSpace()
+			}
+		}
+	}()
+	return closeCh, doneCh
+}
+
+// updateDiskSpace checks the free disk space and updates whether writes
+// are paused.
+func (r *Provider) updateDiskSpace() {
+	err := r.Options.checkDiskSpace(r.Options.DataDir)
+	low := errors.Is(err, errors.ErrInsufficientDiskSpace)
+	if err != nil && !low {
+		r.log.Warn("Failed to check free disk space", slog.String("error", err.Error()))
+		return
+	}
+	if was := r.diskSpaceLow.Swap(low); was != low {
+		if low {
+			r.log.Error("Disk space is low, pausing writes", slog.String("error", err.Error()))
+		} else {
+			r.log.Info("Disk space recovered, resuming writes")
+		}
+	}
+}
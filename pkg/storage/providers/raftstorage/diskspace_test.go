@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestDiskSpace(t *testing.T) {
+	ctx := context.Background()
+	const minFree = 1 << 20
+
+	newProvider := func(t *testing.T, free *atomic.Uint64) *Provider {
+		t.Helper()
+		transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+			Addr:    "127.0.0.1:0",
+			MaxPool: 10,
+			Timeout: time.Second,
+		})
+		if err != nil {
+			t.Fatalf("failed to create raft transport: %v", err)
+		}
+		opts := newTestOptions(transport)
+		opts.InMemory = false
+		opts.DataDir = t.TempDir()
+		opts.MinFreeDiskSpace = minFree
+		opts.DiskSpaceCheckInterval = time.Hour
+		opts.DiskSpace = func(string) (uint64, error) {
+			return free.Load(), nil
+		}
+		return NewProvider(opts)
+	}
+
+	t.Run("RefusesToOpen", func(t *testing.T) {
+		var free atomic.Uint64
+		free.Store(minFree - 1)
+		p := newProvider(t, &free)
+		err := p.Start(ctx)
+		if err == nil {
+			_ = p.Close()
+			t.Fatal("expected start to fail on a full disk")
+		}
+		if !errors.Is(err, errors.ErrInsufficientDiskSpace) {
+			t.Fatalf("expected ErrInsufficientDiskSpace, got %v", err)
+		}
+	})
+
+	t.Run("PausesWrites", func(t *testing.T) {
+		var free atomic.Uint64
+		free.Store(minFree * 2)
+		p := newProvider(t, &free)
+		if err := p.Start(ctx); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		t.Cleanup(func() { _ = p.Close() })
+		if err := p.Bootstrap(ctx); err != nil {
+			t.Fatalf("bootstrap: %v", err)
+		}
+		put := func() error {
+			return p.MeshStorage().PutValue(ctx, []byte("/registry/test"), []byte("value"), 0)
+		}
+		if err := put(); err != nil {
+			t.Fatalf("put with enough disk space: %v", err)
+		}
+
+		// Simulate the disk filling up.
+		free.Store(minFree - 1)
+		p.updateDiskSpace()
+		err := put()
+		if !errors.Is(err, errors.ErrInsufficientDiskSpace) {
+			t.Fatalf("expected ErrInsufficientDiskSpace, got %v", err)
+		}
+		if code := status.Code(err); code != codes.ResourceExhausted {
+			t.Errorf("expected ResourceExhausted, got %v", code)
+		}
+		// Reads should still work.
+		if _, err := p.MeshStorage().GetValue(ctx, []byte("/registry/test")); err != nil {
+			t.Errorf("get while disk space is low: %v", err)
+		}
+
+		// Writes resume once space is freed.
+		free.Store(minFree * 2)
+		p.updateDiskSpace()
+		if err := put(); err != nil {
+			t.Errorf("put after disk space recovered: %v", err)
+		}
+	})
+
+	t.Run("RefusesReplicatedLogs", func(t *testing.T) {
+		var free atomic.Uint64
+		free.Store(minFree - 1)
+		p := newProvider(t, &free)
+		p.updateDiskSpace()
+		inmem := raft.NewInmemStore()
+		logs := &diskSpaceLogStore{LogStore: inmem, provider: p}
+		entry := &raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("data")}
+		if err := logs.StoreLogs([]*raft.Log{entry}); !errors.Is(err, errors.ErrInsufficientDiskSpace) {
+			t.Fatalf("expected ErrInsufficientDiskSpace, got %v", err)
+		}
+		if last, _ := inmem.LastIndex(); last != 0 {
+			t.Fatalf("expected no logs to be stored, got last index %d", last)
+		}
+		// Elections must still be able to store no-op entries.
+		if err := logs.StoreLog(&raft.Log{Index: 1, Term: 2, Type: raft.LogNoop}); err != nil {
+			t.Fatalf("store noop while disk space is low: %v", err)
+		}
+		free.Store(minFree * 2)
+		p.updateDiskSpace()
+		entry.Index, entry.Term = 2, 2
+		if err := logs.StoreLog(entry); err != nil {
+			t.Fatalf("store log after disk space recovered: %v", err)
+		}
+	})
+}
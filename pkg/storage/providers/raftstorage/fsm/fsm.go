@@ -18,6 +18,7 @@ limitations under the License.
 package fsm
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log/slog"
@@ -28,12 +29,16 @@ import (
 	"github.com/golang/snappy"
 	"github.com/hashicorp/raft"
 	v1 "github.com/webmeshproj/api/go/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/raftlogs"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
 )
 
 // Ensure that RaftFSM implements the raft.FSM interface.
@@ -54,6 +59,13 @@ type RaftFSM struct {
 type Options struct {
 	// ApplyTimeout is the timeout for applying a log entry.
 	ApplyTimeout time.Duration
+	// OnApplyLog is called after each command log is applied to storage.
+	// The context carries the span of the apply when tracing is enabled.
+	OnApplyLog func(ctx context.Context, l *raft.Log)
+	// SnapshotEncryption enables encryption of sensitive values in snapshots.
+	SnapshotEncryption *snapshots.Encryption
+	// OnSnapshotRestore is called after a snapshot has been restored to storage.
+	OnSnapshotRestore func(ctx context.Context)
 }
 
 // New returns a new RaftFSM. The storage interface must be a direct
@@ -63,7 +75,7 @@ func New(ctx context.Context, st storage.DualStorage, opts Options) *RaftFSM {
 		store:       st,
 		opts:        opts,
 		log:         context.LoggerFrom(ctx).With("component", "raft-fsm"),
-		snapshotter: snapshots.New(ctx, st),
+		snapshotter: snapshots.New(ctx, st, snapshots.Options{Encryption: opts.SnapshotEncryption}),
 	}
 }
 
@@ -72,6 +84,18 @@ func (r *RaftFSM) LastAppliedIndex() uint64 {
 	return r.lastAppliedIndex.Load()
 }
 
+// Revision returns the last applied index along with the revision of the
+// underlying storage once it was applied.
+func (r *RaftFSM) Revision(ctx context.Context) (index, local uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	local, err = r.store.Revision(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.lastAppliedIndex.Load(), local, nil
+}
+
 // CurrentTerm returns the current term.
 func (r *RaftFSM) CurrentTerm() uint64 {
 	return r.currentTerm.Load()
@@ -94,6 +118,9 @@ func (r *RaftFSM) Restore(rdr io.ReadCloser) error {
 	if err != nil {
 		return fmt.Errorf("restore snapshot: %w", err)
 	}
+	if r.opts.OnSnapshotRestore != nil {
+		r.opts.OnSnapshotRestore(context.Background())
+	}
 	return nil
 }
 
@@ -152,6 +179,46 @@ func (r *RaftFSM) applyLog(l *raft.Log) (cmd *v1.RaftLogEntry, res *v1.RaftApply
 		}
 	}
 
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if r.opts.ApplyTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), r.opts.ApplyTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	ctx = context.WithLogger(ctx, log)
+
+	// Continue the trace of the request that submitted the log, if any.
+	ext, traceparent := SplitTraceExtension(l.Extensions)
+	ctx, span := tracing.Start(tracing.Extract(ctx, traceparent), "raft.ApplyLog",
+		attribute.Int64("raft.index", int64(l.Index)),
+		attribute.Int64("raft.term", int64(l.Term)),
+	)
+	defer func() {
+		if res.GetError() != "" {
+			span.SetStatus(codes.Error, res.GetError())
+		}
+		span.End()
+	}()
+	if r.opts.OnApplyLog != nil {
+		defer r.opts.OnApplyLog(ctx, l)
+	}
+
+	if bytes.Equal(ext, BatchExtension) {
+		// Decode and apply the batch of entries atomically.
+		batch, err := UnmarshalLogBatch(l.Data)
+		if err != nil {
+			log.Error("Error decoding raft log batch", slog.String("error", err.Error()))
+			return nil, &v1.RaftApplyResponse{
+				Time:  time.Since(start).String(),
+				Error: fmt.Sprintf("decode log batch: %s", err.Error()),
+			}
+		}
+		log.Debug("Applying log batch", slog.Int("count", len(batch.Entries)))
+		return nil, raftlogs.ApplyBatch(ctx, r.store, batch)
+	}
+
 	// Decode the log entry
 	cmd, err := UnmarshalLogEntry(l.Data)
 	if err != nil {
@@ -165,20 +232,107 @@ func (r *RaftFSM) applyLog(l *raft.Log) (cmd *v1.RaftLogEntry, res *v1.RaftApply
 	}
 	log.Debug("Applying log entry", slog.String("command", cmd.String()))
 
-	var ctx context.Context
-	var cancel context.CancelFunc
-	if r.opts.ApplyTimeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), r.opts.ApplyTimeout)
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
-	}
-	defer cancel()
-	ctx = context.WithLogger(ctx, log)
-
 	// Apply the log entry to the database.
 	return cmd, raftlogs.Apply(ctx, r.store, cmd)
 }
 
+// BatchExtension is set on the extensions of raft logs that contain
+// a batch of entries instead of a single entry.
+var BatchExtension = []byte("webmesh-batch")
+
+// traceExtensionSeparator separates the trace context from any other
+// extensions on a raft log.
+const traceExtensionSeparator = '\n'
+
+// WithTraceExtension returns the given extensions with the trace context of
+// the span in the context appended. The extensions are returned unchanged
+// when no span is being recorded, so logs are only extended when tracing
+// is enabled.
+func WithTraceExtension(ctx context.Context, ext []byte) []byte {
+	traceparent := tracing.Inject(ctx)
+	if traceparent == "" {
+		return ext
+	}
+	out := make([]byte, 0, len(ext)+1+len(traceparent))
+	out = append(out, ext...)
+	out = append(out, traceExtensionSeparator)
+	return append(out, traceparent...)
+}
+
+// SplitTraceExtension splits the trace context from the given extensions.
+func SplitTraceExtension(ext []byte) ([]byte, string) {
+	i := bytes.LastIndexByte(ext, traceExtensionSeparator)
+	if i < 0 {
+		return ext, ""
+	}
+	return ext[:i], string(ext[i+1:])
+}
+
+// Field numbers of a marshaled log batch.
+const (
+	// logBatchEntryField holds an encoded RaftLogEntry to apply.
+	logBatchEntryField protowire.Number = 1
+	// logBatchAbsentField holds a key that must not exist when the batch
+	// is applied.
+	logBatchAbsentField protowire.Number = 2
+)
+
+// MarshalLogBatch marshals a batch into a single log payload.
+func MarshalLogBatch(batch storage.LogBatch) ([]byte, error) {
+	var data []byte
+	for _, key := range batch.Absent {
+		data = protowire.AppendTag(data, logBatchAbsentField, protowire.BytesType)
+		data = protowire.AppendBytes(data, key)
+	}
+	for _, entry := range batch.Entries {
+		b, err := proto.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("encode log entry: %w", err)
+		}
+		data = protowire.AppendTag(data, logBatchEntryField, protowire.BytesType)
+		data = protowire.AppendBytes(data, b)
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// UnmarshalLogBatch unmarshals a batch. Unknown fields are rejected so that a
+// precondition is never silently dropped.
+func UnmarshalLogBatch(data []byte) (storage.LogBatch, error) {
+	var batch storage.LogBatch
+	data, err := snappy.Decode(nil, data)
+	if err != nil {
+		return batch, fmt.Errorf("decode log batch: %w", err)
+	}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return batch, fmt.Errorf("decode log batch: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			return batch, fmt.Errorf("decode log batch: unexpected wire type %d for field %d", typ, num)
+		}
+		b, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return batch, fmt.Errorf("decode log batch: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch num {
+		case logBatchAbsentField:
+			batch.Absent = append(batch.Absent, b)
+		case logBatchEntryField:
+			entry := &v1.RaftLogEntry{}
+			if err := proto.Unmarshal(b, entry); err != nil {
+				return batch, fmt.Errorf("unmarshal log entry: %w", err)
+			}
+			batch.Entries = append(batch.Entries, entry)
+		default:
+			return batch, fmt.Errorf("decode log batch: unknown field %d", num)
+		}
+	}
+	return batch, nil
+}
+
 // MarshalLogEntry marshals a RaftLogEntry.
 func MarshalLogEntry(logEntry *v1.RaftLogEntry) ([]byte, error) {
 	data, err := proto.Marshal(logEntry)
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"testing"
+
+	"github.com/golang/snappy"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func TestLogBatchRoundTrip(t *testing.T) {
+	t.Parallel()
+	var ops storage.BatchOps
+	ops.PutValueIfAbsent([]byte("/registry/a"), []byte("a"), 0)
+	ops.Delete([]byte("/registry/b"))
+	data, err := MarshalLogBatch(ops.LogBatch())
+	if err != nil {
+		t.Fatalf("marshal log batch: %v", err)
+	}
+	batch, err := UnmarshalLogBatch(data)
+	if err != nil {
+		t.Fatalf("unmarshal log batch: %v", err)
+	}
+	if len(batch.Absent) != 1 || string(batch.Absent[0]) != "/registry/a" {
+		t.Fatalf("unexpected absent keys: %q", batch.Absent)
+	}
+	if len(batch.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(batch.Entries))
+	}
+	if batch.Entries[0].GetType() != v1.RaftCommandType_PUT || batch.Entries[1].GetType() != v1.RaftCommandType_DELETE {
+		t.Fatalf("unexpected entry types: %v, %v", batch.Entries[0].GetType(), batch.Entries[1].GetType())
+	}
+}
+
+func TestUnmarshalLogBatchRejectsUnknownFields(t *testing.T) {
+	t.Parallel()
+	data := protowire.AppendTag(nil, 3, protowire.BytesType)
+	data = protowire.AppendBytes(data, []byte("/registry/a"))
+	if _, err := UnmarshalLogBatch(snappy.Encode(nil, data)); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
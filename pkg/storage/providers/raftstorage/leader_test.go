@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+)
+
+func TestRecordLeader(t *testing.T) {
+	ctx := context.Background()
+	builder := &builder{}
+	providers := builder.newProviders(t, 2)
+	for _, p := range providers {
+		p := p
+		t.Cleanup(func() { _ = p.Close() })
+	}
+	first, second := providers[0].(*Provider), providers[1].(*Provider)
+	testutil.MustStartProvider(ctx, t, first)
+	testutil.MustStartProvider(ctx, t, second)
+	testutil.MustBootstrapProvider(ctx, t, first)
+
+	storedLeader := func(p *Provider) string {
+		leader, err := p.MeshDB().MeshState().GetLeader(ctx)
+		if err != nil {
+			return ""
+		}
+		return leader.GetId()
+	}
+
+	// The bootstrap leader should record itself.
+	ok := testutil.Eventually[string](func() string {
+		return storedLeader(first)
+	}).ShouldEqual(time.Second*15, time.Millisecond*250, string(first.nodeID))
+	if !ok {
+		t.Fatalf("expected stored leader %q, got %q", first.nodeID, storedLeader(first))
+	}
+
+	// Hand leadership to the second node and the stored leader should follow
+	// on both nodes.
+	testutil.MustAddVoter(ctx, t, first, second)
+	ok = testutil.Eventually[string](func() string {
+		return storedLeader(second)
+	}).ShouldEqual(time.Second*15, time.Millisecond*250, string(first.nodeID))
+	if !ok {
+		t.Fatalf("expected new voter to replicate the stored leader")
+	}
+	if err := first.Consensus().StepDown(ctx); err != nil {
+		t.Fatalf("step down: %v", err)
+	}
+	ok = testutil.Eventually[bool](func() bool {
+		return second.Consensus().IsLeader()
+	}).ShouldEqual(time.Second*15, time.Millisecond*250, true)
+	if !ok {
+		t.Fatal("expected the second node to become leader")
+	}
+	for _, p := range []*Provider{first, second} {
+		p := p
+		ok = testutil.Eventually[string](func() string {
+			return storedLeader(p)
+		}).ShouldEqual(time.Second*15, time.Millisecond*250, string(second.nodeID))
+		if !ok {
+			t.Fatalf("expected stored leader %q, got %q", second.nodeID, storedLeader(p))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"sync"
+)
+
+// LeadershipCallback is a callback invoked when the leader of the raft
+// group changes. isLeader is true when the local node is the new leader
+// and leaderID is empty while an election is in progress.
+type LeadershipCallback func(isLeader bool, leaderID string)
+
+// OnLeadershipChange registers a callback for leadership changes. Callbacks are
+// invoked one at a time in the order they were registered, and every callback
+// sees the same events in the same order. Only changes are delivered, repeated
+// observations of the same leader are suppressed. The returned function removes
+// the callback.
+func (r *Provider) OnLeadershipChange(cb LeadershipCallback) (cancel func()) {
+	return r.leadership.subscribe(cb)
+}
+
+type leadershipEvent struct {
+	isLeader bool
+	leaderID string
+}
+
+type leadershipSubscriber struct {
+	id uint64
+	cb LeadershipCallback
+}
+
+// leadershipNotifier multiplexes leadership events to subscribers. Events
+// are queued and delivered by a single dispatcher so that a slow subscriber
+// never blocks the raft observer and ordering is preserved across subscribers.
+type leadershipNotifier struct {
+	subs        []leadershipSubscriber
+	nextID      uint64
+	last        leadershipEvent
+	seen        bool
+	pending     []leadershipEvent
+	dispatching bool
+	mu          sync.Mutex
+}
+
+func (n *leadershipNotifier) subscribe(cb LeadershipCallback) func() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nextID++
+	id := n.nextID
+	n.subs = append(n.subs, leadershipSubscriber{id: id, cb: cb})
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for i, sub := range n.subs {
+			if sub.id == id {
+				n.subs = append(n.subs[:i:i], n.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (n *leadershipNotifier) notify(isLeader bool, leaderID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ev := leadershipEvent{isLeader: isLeader, leaderID: leaderID}
+	if n.seen && ev == n.last {
+		return
+	}
+	n.last, n.seen = ev, true
+	n.pending = append(n.pending, ev)
+	if !n.dispatching {
+		n.dispatching = true
+		go n.dispatch()
+	}
+}
+
+func (n *leadershipNotifier) dispatch() {
+	for {
+		n.mu.Lock()
+		if len(n.pending) == 0 {
+			n.dispatching = false
+			n.mu.Unlock()
+			return
+		}
+		ev := n.pending[0]
+		n.pending = n.pending[1:]
+		subs := make([]leadershipSubscriber, len(n.subs))
+		copy(subs, n.subs)
+		n.mu.Unlock()
+		for _, sub := range subs {
+			sub.cb(ev.isLeader, ev.leaderID)
+		}
+	}
+}
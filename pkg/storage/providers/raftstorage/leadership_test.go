@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+)
+
+type leadershipRecorder struct {
+	events []string
+	mu     sync.Mutex
+}
+
+func (r *leadershipRecorder) record(isLeader bool, leaderID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%s:%v", leaderID, isLeader))
+}
+
+func (r *leadershipRecorder) Events() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprint(r.events)
+}
+
+func TestLeadershipNotifier(t *testing.T) {
+	var n leadershipNotifier
+	recorders := make([]*leadershipRecorder, 3)
+	for i := range recorders {
+		recorders[i] = &leadershipRecorder{}
+		n.subscribe(recorders[i].record)
+	}
+	cancelled := &leadershipRecorder{}
+	cancel := n.subscribe(cancelled.record)
+	cancel()
+
+	n.notify(false, "")
+	n.notify(true, "node-a")
+	n.notify(true, "node-a")
+	n.notify(false, "")
+	n.notify(false, "node-b")
+
+	want := fmt.Sprint([]string{":false", "node-a:true", ":false", "node-b:false"})
+	for i, rec := range recorders {
+		ok := testutil.Eventually[string](rec.Events).ShouldEqual(time.Second*5, time.Millisecond*10, want)
+		if !ok {
+			t.Fatalf("subscriber %d: expected events %s, got %s", i, want, rec.Events())
+		}
+	}
+	if got := cancelled.Events(); got != fmt.Sprint([]string(nil)) {
+		t.Fatalf("expected no events for cancelled subscriber, got %s", got)
+	}
+}
+
+func TestOnLeadershipChange(t *testing.T) {
+	ctx := context.Background()
+	builder := &builder{}
+	provider := builder.newProviders(t, 1)[0].(*Provider)
+	t.Cleanup(func() { _ = provider.Close() })
+
+	recorders := []*leadershipRecorder{{}, {}}
+	for _, rec := range recorders {
+		provider.OnLeadershipChange(rec.record)
+	}
+	testutil.MustStartProvider(ctx, t, provider)
+	testutil.MustBootstrapProvider(ctx, t, provider)
+
+	want := fmt.Sprint([]string{string(provider.nodeID) + ":true"})
+	for i, rec := range recorders {
+		ok := testutil.Eventually[string](rec.Events).ShouldEqual(time.Second*15, time.Millisecond*250, want)
+		if !ok {
+			t.Fatalf("subscriber %d: expected events %s, got %s", i, want, rec.Events())
+		}
+	}
+}
@@ -19,27 +19,51 @@ package raftstorage
 import (
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/raft"
 	v1 "github.com/webmeshproj/api/go/v1"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
 )
 
 // Ensure we satisfy the MeshStorage interface.
 var _ storage.MeshStorage = &RaftStorage{}
 
+// MembershipExtensions_ApplyBatch_FullMethodName is the full method name used
+// to forward a batch to the leader. The request is a wrapperspb.BytesValue
+// holding the batch encoded with fsm.MarshalLogBatch, and the response is a
+// v1.RaftApplyResponse.
+const MembershipExtensions_ApplyBatch_FullMethodName = "/v1.MembershipExtensions/ApplyBatch"
+
 // RaftStorage wraps the storage.Storage interface to force write operations through the Raft log.
 type RaftStorage struct {
 	storage    storage.MeshStorage
+	fsm        *fsm.RaftFSM
 	writecount atomic.Int32
 	raft       *Provider
+	// revisions maps the raft indexes returned by Revision to the revision
+	// of the underlying storage at the time.
+	revisions map[uint64]uint64
+	revorder  []uint64
+	revmu     sync.Mutex
+}
+
+// ValueCodec returns the codec values are written with.
+func (rs *RaftStorage) ValueCodec() storage.Codec {
+	return rs.raft.Codec
 }
 
 // Close closes the storage.
@@ -77,16 +101,104 @@ func (rs *RaftStorage) IterPrefix(ctx context.Context, prefix []byte, fn storage
 	return rs.storage.IterPrefix(ctx, prefix, fn)
 }
 
+// Revision returns the index of the last raft log applied to the storage.
+// Every node applies the same logs, so the revision describes the same state
+// on every node. The revision is only retained for IterPrefixAt on the node
+// that returned it, or on any node while it is the last applied index.
+func (rs *RaftStorage) Revision(ctx context.Context) (uint64, error) {
+	if !rs.raft.started.Load() {
+		return 0, errors.ErrClosed
+	}
+	index, local, err := rs.fsm.Revision(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rs.recordRevision(index, local)
+	return index, nil
+}
+
+// IterPrefixAt iterates over all keys with a given prefix at the given revision.
+// ErrInvalidRevision is returned if the revision is not retained by this node.
+func (rs *RaftStorage) IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn storage.PrefixIterator) error {
+	if !rs.raft.started.Load() {
+		return errors.ErrClosed
+	}
+	rs.revmu.Lock()
+	local, ok := rs.revisions[revision]
+	rs.revmu.Unlock()
+	if !ok {
+		index, current, err := rs.fsm.Revision(ctx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case revision > index:
+			return fmt.Errorf("%w: %d has not been applied, the last applied index is %d", errors.ErrInvalidRevision, revision, index)
+		case revision < index:
+			return fmt.Errorf("%w: %d is not retained by this node", errors.ErrInvalidRevision, revision)
+		}
+		rs.recordRevision(index, current)
+		local = current
+	}
+	return rs.storage.IterPrefixAt(ctx, prefix, local, fn)
+}
+
+// recordRevision records the revision of the underlying storage for a raft index.
+func (rs *RaftStorage) recordRevision(index, local uint64) {
+	rs.revmu.Lock()
+	defer rs.revmu.Unlock()
+	if rs.revisions == nil {
+		rs.revisions = make(map[uint64]uint64)
+	}
+	if _, ok := rs.revisions[index]; ok {
+		return
+	}
+	rs.revisions[index] = local
+	rs.revorder = append(rs.revorder, index)
+	for len(rs.revorder) > max(badgerdb.RevisionHistory, 1) {
+		delete(rs.revisions, rs.revorder[0])
+		rs.revorder = rs.revorder[1:]
+	}
+}
+
+// resetRevisions forgets every recorded revision.
+func (rs *RaftStorage) resetRevisions() {
+	rs.revmu.Lock()
+	defer rs.revmu.Unlock()
+	rs.revisions = nil
+	rs.revorder = nil
+}
+
 // Subscribe subscribes to changes to a prefix.
 func (rs *RaftStorage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) (context.CancelFunc, error) {
 	if !rs.raft.started.Load() {
 		return func() {}, errors.ErrClosed
 	}
-	return rs.storage.Subscribe(ctx, prefix, fn)
+	metrics := rs.raft.metrics
+	if metrics == nil {
+		return rs.storage.Subscribe(ctx, prefix, fn)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	_, err := rs.storage.Subscribe(ctx, prefix, func(key, value []byte) {
+		metrics.observeDelivery()
+		fn(key, value)
+	})
+	if err != nil {
+		cancel()
+		return func() {}, err
+	}
+	metrics.Subscribers.Inc()
+	go func() {
+		<-ctx.Done()
+		metrics.Subscribers.Dec()
+	}()
+	return cancel, nil
 }
 
 // Put sets the value of a key.
-func (rs *RaftStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+func (rs *RaftStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) (err error) {
+	ctx, span := tracing.Start(ctx, "storage.PutValue", attribute.String("storage.key", string(key)))
+	defer tracing.End(span, &err)
 	if !rs.raft.started.Load() {
 		return errors.ErrClosed
 	}
@@ -111,7 +223,9 @@ func (rs *RaftStorage) PutValue(ctx context.Context, key, value []byte, ttl time
 }
 
 // Delete removes a key.
-func (rs *RaftStorage) Delete(ctx context.Context, key []byte) error {
+func (rs *RaftStorage) Delete(ctx context.Context, key []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "storage.Delete", attribute.String("storage.key", string(key)))
+	defer tracing.End(span, &err)
 	if !rs.raft.started.Load() {
 		return errors.ErrClosed
 	}
@@ -133,6 +247,82 @@ func (rs *RaftStorage) Delete(ctx context.Context, key []byte) error {
 	return rs.sendLogToLeader(ctx, &logEntry)
 }
 
+// Batch returns a new batch that is applied as a single raft log entry.
+// Batches committed on a non-leader are forwarded to the leader as a whole.
+func (rs *RaftStorage) Batch() storage.Batch {
+	return &raftBatch{rs: rs}
+}
+
+type raftBatch struct {
+	storage.BatchOps
+	rs *RaftStorage
+}
+
+// Commit applies all queued operations in a single raft log entry.
+func (b *raftBatch) Commit(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "storage.CommitBatch", attribute.Int("storage.operations", b.Len()))
+	defer tracing.End(span, &err)
+	if !b.rs.raft.started.Load() {
+		return errors.ErrClosed
+	}
+	for _, op := range b.Ops() {
+		if !types.IsValidPathID(string(op.GetKey())) {
+			return fmt.Errorf("%w: %q", errors.ErrInvalidKey, op.GetKey())
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	if !b.rs.raft.isVoter() {
+		return errors.ErrNotVoter
+	}
+	if !b.rs.raft.Consensus().IsLeader() {
+		// We need to forward the batch to the leader.
+		return b.rs.sendBatchToLeader(ctx, b.LogBatch())
+	}
+	res, err := b.rs.raft.ApplyRaftLogBatch(ctx, b.LogBatch())
+	if err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			return errors.ErrNotLeader
+		}
+		return fmt.Errorf("apply log batch: %w", err)
+	}
+	return batchResponseError(res)
+}
+
+func (rs *RaftStorage) sendBatchToLeader(ctx context.Context, batch storage.LogBatch) error {
+	log := context.LoggerFrom(ctx)
+	log.Debug("sending log batch to leader")
+	data, err := fsm.MarshalLogBatch(batch)
+	if err != nil {
+		return fmt.Errorf("marshal log batch: %w", err)
+	}
+	c, err := rs.raft.Options.Transport.DialLeader(ctx)
+	if err != nil {
+		return fmt.Errorf("dial leader: %w", err)
+	}
+	defer c.Close()
+	var resp v1.RaftApplyResponse
+	err = c.Invoke(ctx, MembershipExtensions_ApplyBatch_FullMethodName, wrapperspb.Bytes(data), &resp)
+	if err != nil {
+		return fmt.Errorf("apply log batch: %w", err)
+	}
+	log.Debug("applied log batch", slog.String("time", resp.GetTime()))
+	return batchResponseError(&resp)
+}
+
+// batchResponseError returns the error carried by the response to a batch.
+func batchResponseError(res *v1.RaftApplyResponse) error {
+	if res.GetError() == "" {
+		return nil
+	}
+	if strings.HasPrefix(res.GetError(), errors.ErrKeyExists.Error()) {
+		// Restore the sentinel so callers can detect the failed condition.
+		return fmt.Errorf("apply log batch data: %w%s", errors.ErrKeyExists, strings.TrimPrefix(res.GetError(), errors.ErrKeyExists.Error()))
+	}
+	return fmt.Errorf("apply log batch data: %s", res.GetError())
+}
+
 func (rs *RaftStorage) sendLogToLeader(ctx context.Context, logEntry *v1.RaftLogEntry) error {
 	log := context.LoggerFrom(ctx)
 	log.Debug("sending log to leader")
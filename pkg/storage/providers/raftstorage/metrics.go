@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/tracing"
+)
+
+// Metrics are the prometheus metrics recorded by the raft storage provider.
+type Metrics struct {
+	// LastAppliedIndex is the index of the last raft log applied to storage.
+	LastAppliedIndex prometheus.Gauge
+	// ApplyLatency tracks the time between a log being appended by the
+	// leader and it being applied to storage.
+	ApplyLatency prometheus.Histogram
+	// Subscribers is the current number of storage subscribers.
+	Subscribers prometheus.Gauge
+	// SubscriptionLag tracks the time between the most recent raft apply
+	// and an event being delivered to a subscriber.
+	SubscriptionLag prometheus.Histogram
+
+	lastAppliedAt atomic.Int64
+}
+
+// NewMetrics creates the raft storage metrics and registers them with the
+// given registerer. Metrics already registered by another provider are
+// reused.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	var m Metrics
+	var err error
+	m.LastAppliedIndex, err = register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "webmesh",
+		Name:      "raft_last_applied_index",
+		Help:      "The index of the last raft log applied to storage.",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	m.ApplyLatency, err = register(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "webmesh",
+		Name:      "raft_apply_latency_seconds",
+		Help:      "Time between a raft log being appended by the leader and applied to storage.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	m.Subscribers, err = register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "webmesh",
+		Name:      "storage_subscribers",
+		Help:      "The current number of storage subscribers.",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	m.SubscriptionLag, err = register(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "webmesh",
+		Name:      "storage_subscription_lag_seconds",
+		Help:      "Time between the most recent raft apply and a storage event being delivered to a subscriber.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// observeApply records the application of the given log. The latency is
+// linked to the trace of the apply when there is one.
+func (m *Metrics) observeApply(ctx context.Context, l *raft.Log) {
+	now := time.Now()
+	m.lastAppliedAt.Store(now.UnixNano())
+	m.LastAppliedIndex.Set(float64(l.Index))
+	if !l.AppendedAt.IsZero() {
+		tracing.Observe(ctx, m.ApplyLatency, now.Sub(l.AppendedAt).Seconds())
+	}
+}
+
+// observeDelivery records the delivery of an event to a subscriber.
+func (m *Metrics) observeDelivery() {
+	if at := m.lastAppliedAt.Load(); at != 0 {
+		m.SubscriptionLag.Observe(time.Since(time.Unix(0, at)).Seconds())
+	}
+}
+
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) (T, error) {
+	err := reg.Register(c)
+	if err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
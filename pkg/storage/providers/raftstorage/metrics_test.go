@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+		Addr:    "[::]:0",
+		MaxPool: 10,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft transport: %v", err)
+	}
+	reg := prometheus.NewRegistry()
+	opts := newTestOptions(transport)
+	opts.RecordMetrics = true
+	opts.MetricsRegisterer = reg
+	provider := NewProvider(opts)
+	defer provider.Close()
+	testutil.MustStartProvider(ctx, t, provider)
+	testutil.MustBootstrapProvider(ctx, t, provider)
+	ok := testutil.Eventually[bool](func() bool {
+		return provider.Consensus().IsLeader()
+	}).ShouldEqual(time.Second*30, time.Second, true)
+	if !ok {
+		t.Fatal("provider did not become the leader")
+	}
+
+	// All metrics should be registered once the provider is started.
+	families := gatherMetrics(t, reg)
+	for _, name := range []string{
+		"webmesh_raft_last_applied_index",
+		"webmesh_raft_apply_latency_seconds",
+		"webmesh_storage_subscribers",
+		"webmesh_storage_subscription_lag_seconds",
+	} {
+		if _, ok := families[name]; !ok {
+			t.Fatalf("expected metric %s to be registered", name)
+		}
+	}
+	startIndex := families["webmesh_raft_last_applied_index"].GetMetric()[0].GetGauge().GetValue()
+
+	var delivered atomic.Int64
+	prefix := types.RegistryPrefix.ForString("metrics-test")
+	cancel, err := provider.MeshStorage().Subscribe(ctx, prefix, func(key, value []byte) {
+		delivered.Add(1)
+	})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	if got := gaugeValue(t, reg, "webmesh_storage_subscribers"); got != 1 {
+		t.Fatalf("expected 1 subscriber, got %v", got)
+	}
+
+	// Put some load on storage and the metrics should move.
+	const numKeys = 20
+	for i := 0; i < numKeys; i++ {
+		key := prefix.ForString(fmt.Sprintf("key-%d", i))
+		if err := provider.MeshStorage().PutValue(ctx, key, []byte("value"), 0); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+	}
+	ok = testutil.Eventually[int64](func() int64 {
+		return delivered.Load()
+	}).Should(time.Second*10, time.Millisecond*100, func(n int64) bool { return n >= numKeys })
+	if !ok {
+		t.Fatalf("expected at least %d subscription events, got %d", numKeys, delivered.Load())
+	}
+	families = gatherMetrics(t, reg)
+	if got := families["webmesh_raft_last_applied_index"].GetMetric()[0].GetGauge().GetValue(); got < startIndex+numKeys {
+		t.Errorf("expected last applied index to be at least %v, got %v", startIndex+numKeys, got)
+	}
+	if got := families["webmesh_raft_apply_latency_seconds"].GetMetric()[0].GetHistogram().GetSampleCount(); got < numKeys {
+		t.Errorf("expected at least %d apply latency samples, got %d", numKeys, got)
+	}
+	if got := families["webmesh_storage_subscription_lag_seconds"].GetMetric()[0].GetHistogram().GetSampleCount(); got < numKeys {
+		t.Errorf("expected at least %d subscription lag samples, got %d", numKeys, got)
+	}
+
+	// Cancelling the subscription should drop the subscriber count.
+	cancel()
+	ok = testutil.Eventually[float64](func() float64 {
+		return gaugeValue(t, reg, "webmesh_storage_subscribers")
+	}).ShouldEqual(time.Second*10, time.Millisecond*100, 0)
+	if !ok {
+		t.Fatal("expected subscriber count to drop to 0")
+	}
+}
+
+func gatherMetrics(t *testing.T, reg *prometheus.Registry) map[string]*dto.MetricFamily {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	out := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		out[family.GetName()] = family
+	}
+	return out
+}
+
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	family, ok := gatherMetrics(t, reg)[name]
+	if !ok || len(family.GetMetric()) == 0 {
+		t.Fatalf("metric %s not found", name)
+	}
+	return family.GetMetric()[0].GetGauge().GetValue()
+}
@@ -22,10 +22,13 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -90,27 +93,60 @@ type Options struct {
 	LogLevel string
 	// LogFormat is the log format for the raft backend.
 	LogFormat string
+	// RecordMetrics enables recording of raft and storage metrics.
+	RecordMetrics bool
+	// MetricsRegisterer is the registerer to record metrics with. Defaults
+	// to the default prometheus registerer.
+	MetricsRegisterer prometheus.Registerer
+	// MinFreeDiskSpace is the minimum free space in bytes required on the
+	// filesystem containing DataDir. Startup fails if there is less, and
+	// writes are refused while running if free space drops below it. This
+	// includes commands replicated from the leader, which followers refuse
+	// to store until space is freed.
+	// Set to 0 to disable the check.
+	MinFreeDiskSpace uint64
+	// DiskSpaceCheckInterval is the interval at which to check free disk
+	// space while running. Defaults to DefaultDiskSpaceCheckInterval.
+	DiskSpaceCheckInterval time.Duration
+	// DiskSpace reports the free space on the filesystem containing a path.
+	// Defaults to AvailableDiskSpace.
+	DiskSpace DiskSpaceFunc
+	// SnapshotEncryptionKey is the AES-256 key used to encrypt the values
+	// of sensitive keys in snapshots. Snapshots are not encrypted when empty.
+	SnapshotEncryptionKey []byte
+	// SnapshotSensitivePrefixes are the key prefixes whose values are
+	// encrypted in snapshots.
+	SnapshotSensitivePrefixes []string
+	// Codec is the codec values are written to storage with. Defaults to
+	// storage.ProtoJSONCodec.
+	Codec storage.Codec
+	// AllowSnapshotRestore allows restoring the cluster from an exported
+	// snapshot with RestoreSnapshot. This replaces all storage state and
+	// is disabled by default.
+	AllowSnapshotRestore bool
 }
 
 // NewOptions returns new raft options with sensible defaults.
 func NewOptions(nodeID types.NodeID, transport transport.RaftTransport) Options {
 	return Options{
-		NodeID:             nodeID,
-		Transport:          transport,
-		DataDir:            DefaultDataDir,
-		ConnectionTimeout:  time.Second * 3,
-		HeartbeatTimeout:   time.Second * 3,
-		ElectionTimeout:    time.Second * 3,
-		ApplyTimeout:       time.Second * 15,
-		CommitTimeout:      time.Second * 15,
-		LeaderLeaseTimeout: time.Second * 3,
-		SnapshotInterval:   time.Minute * 3,
-		SnapshotThreshold:  5,
-		MaxAppendEntries:   15,
-		SnapshotRetention:  3,
-		ObserverChanBuffer: 100,
-		BarrierThreshold:   DefaultBarrierThreshold,
-		LogLevel:           "info",
+		NodeID:                 nodeID,
+		Transport:              transport,
+		DataDir:                DefaultDataDir,
+		ConnectionTimeout:      time.Second * 3,
+		HeartbeatTimeout:       time.Second * 3,
+		ElectionTimeout:        time.Second * 3,
+		ApplyTimeout:           time.Second * 15,
+		CommitTimeout:          time.Second * 15,
+		LeaderLeaseTimeout:     time.Second * 3,
+		SnapshotInterval:       time.Minute * 3,
+		SnapshotThreshold:      5,
+		MaxAppendEntries:       15,
+		SnapshotRetention:      3,
+		ObserverChanBuffer:     100,
+		BarrierThreshold:       DefaultBarrierThreshold,
+		LogLevel:               "info",
+		MinFreeDiskSpace:       DefaultMinFreeDiskSpace,
+		DiskSpaceCheckInterval: DefaultDiskSpaceCheckInterval,
 	}
 }
 
@@ -147,3 +183,16 @@ func (o *Options) RaftConfig(ctx context.Context, nodeID string) *raft.Config {
 	config.Logger = logging.NewHCLogAdapter("", o.LogLevel, context.LoggerFrom(ctx).With("component", "raft"))
 	return config
 }
+
+// snapshotEncryption returns the snapshot encryption options, or nil if
+// snapshot encryption is disabled.
+func (o *Options) snapshotEncryption() *snapshots.Encryption {
+	if len(o.SnapshotEncryptionKey) == 0 {
+		return nil
+	}
+	enc := &snapshots.Encryption{Key: o.SnapshotEncryptionKey}
+	for _, prefix := range o.SnapshotSensitivePrefixes {
+		enc.SensitivePrefixes = append(enc.SensitivePrefixes, []byte(prefix))
+	}
+	return enc
+}
@@ -24,12 +24,14 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/logging"
@@ -38,10 +40,14 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 // Ensure we satisfy the provider interface.
-var _ storage.Provider = &Provider{}
+var (
+	_ storage.Provider              = &Provider{}
+	_ storage.ReplicaStatusReporter = &Provider{}
+)
 
 // Ensure that RaftStorage implements a MonothonicLogStore.
 var _ = raft.MonotonicLogStore(&MonotonicLogStore{})
@@ -93,12 +99,20 @@ type Provider struct {
 	started                     atomic.Bool
 	raft                        *raft.Raft
 	raftStorage                 *RaftStorage
+	snapshots                   raft.SnapshotStore
 	meshDB                      storage.MeshDB
 	consensus                   *Consensus
 	observer                    *raft.Observer
 	observerChan                chan raft.Observation
 	observerClose, observerDone chan struct{}
 	observerCbs                 []ObservationCallback
+	restoreCbs                  []SnapshotRestoreCallback
+	restoremu                   sync.Mutex
+	leadership                  leadershipNotifier
+	diskSpaceLow                atomic.Bool
+	diskClose, diskDone         chan struct{}
+	metrics                     *Metrics
+	startedAt                   time.Time
 	log                         *slog.Logger
 	mu                          sync.RWMutex
 }
@@ -151,25 +165,43 @@ func (r *Provider) Start(ctx context.Context) error {
 		return errors.ErrStarted
 	}
 	r.log.Debug("Starting raft storage provider")
+	if err := r.Options.snapshotEncryption().Validate(); err != nil {
+		return fmt.Errorf("snapshot encryption: %w", err)
+	}
 	storage, err := r.createStorage()
 	if err != nil {
 		return fmt.Errorf("create storage: %w", err)
 	}
 	// Set the raft storage instance.
 	r.raftStorage.storage = storage
-	snapshots, err := r.createSnapshotStorage()
+	fsmOpts := fsm.Options{
+		ApplyTimeout:       r.Options.ApplyTimeout,
+		SnapshotEncryption: r.Options.snapshotEncryption(),
+		OnSnapshotRestore:  r.onSnapshotRestore,
+	}
+	if r.Options.RecordMetrics {
+		reg := r.Options.MetricsRegisterer
+		if reg == nil {
+			reg = prometheus.DefaultRegisterer
+		}
+		r.metrics, err = NewMetrics(reg)
+		if err != nil {
+			return fmt.Errorf("register metrics: %w", err)
+		}
+		fsmOpts.OnApplyLog = r.metrics.observeApply
+	}
+	r.snapshots, err = r.createSnapshotStorage()
 	if err != nil {
 		return fmt.Errorf("create snapshot storage: %w", err)
 	}
 	r.log.Debug("Starting raft instance", slog.String("listen-addr", string(r.Options.Transport.LocalAddr())))
+	r.raftStorage.fsm = fsm.New(ctx, storage, fsmOpts)
 	r.raft, err = raft.NewRaft(
 		r.Options.RaftConfig(ctx, string(r.nodeID)),
-		fsm.New(ctx, storage, fsm.Options{
-			ApplyTimeout: r.Options.ApplyTimeout,
-		}),
-		&MonotonicLogStore{storage},
+		r.raftStorage.fsm,
+		&MonotonicLogStore{&diskSpaceLogStore{LogStore: storage, provider: r}},
 		storage,
-		snapshots,
+		r.snapshots,
 		r.Options.Transport,
 	)
 	if err != nil {
@@ -182,7 +214,12 @@ func (r *Provider) Start(ctx context.Context) error {
 	})
 	r.raft.RegisterObserver(r.observer)
 	r.observerClose, r.observerDone = r.observe()
+	if !r.Options.InMemory && r.Options.MinFreeDiskSpace > 0 {
+		r.updateDiskSpace()
+		r.diskClose, r.diskDone = r.watchDiskSpace()
+	}
 	// We're done here.
+	r.startedAt = time.Now()
 	r.started.Store(true)
 	return nil
 }
@@ -228,6 +265,10 @@ func (r *Provider) Status() *v1.StorageStatus {
 		}
 		return "not a leader, voter, or observer"
 	}()
+	if r.diskSpaceLow.Load() {
+		status.IsWritable = false
+		status.Message += ", disk space is low"
+	}
 	foundSelf := false
 	for _, server := range config.Servers {
 		if server.ID == r.nodeID {
@@ -320,6 +361,11 @@ func (r *Provider) Close() error {
 	defer r.started.Store(false)
 	defer r.raftStorage.Close()
 	defer r.Options.Transport.Close()
+	if r.diskClose != nil {
+		close(r.diskClose)
+		<-r.diskDone
+		r.diskClose, r.diskDone = nil, nil
+	}
 	// If we were not running in memory, force a snapshot.
 	if !r.Options.InMemory {
 		r.log.Debug("Taking raft storage snapshot")
@@ -342,6 +388,31 @@ func (r *Provider) GetRaftConfiguration() raft.Configuration {
 	return r.raft.GetConfiguration().Configuration()
 }
 
+// ReplicaStatus returns how up to date the local storage is. Staleness is
+// measured from the last contact with the leader and is zero on the leader.
+func (r *Provider) ReplicaStatus() types.ReplicaStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.started.Load() {
+		return types.ReplicaStatus{}
+	}
+	status := types.ReplicaStatus{
+		AppliedIndex: r.raft.AppliedIndex(),
+	}
+	if commit, err := strconv.ParseUint(r.raft.Stats()["commit_index"], 10, 64); err == nil {
+		status.CommitIndex = commit
+	}
+	if r.raft.State() != raft.Leader {
+		if contact := r.raft.LastContact(); !contact.IsZero() {
+			status.Staleness = time.Since(contact)
+		} else {
+			// We have never heard from a leader.
+			status.Staleness = time.Since(r.startedAt)
+		}
+	}
+	return status
+}
+
 // ApplyRaftLog applies a raft log entry.
 func (r *Provider) ApplyRaftLog(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApplyResponse, error) {
 	r.mu.Lock()
@@ -352,6 +423,9 @@ func (r *Provider) ApplyRaftLog(ctx context.Context, log *v1.RaftLogEntry) (*v1.
 	if !r.Consensus().IsLeader() {
 		return nil, errors.ErrNotLeader
 	}
+	if err := r.checkWritable(); err != nil {
+		return nil, err
+	}
 	var timeout time.Duration
 	if deadline, ok := ctx.Deadline(); ok {
 		timeout = time.Until(deadline)
@@ -365,7 +439,51 @@ func (r *Provider) ApplyRaftLog(ctx context.Context, log *v1.RaftLogEntry) (*v1.
 	if err != nil {
 		return nil, fmt.Errorf("marshal log entry: %w", err)
 	}
-	f := r.raft.Apply(data, timeout)
+	f := r.raft.ApplyLog(raft.Log{
+		Data:       data,
+		Extensions: fsm.WithTraceExtension(ctx, nil),
+	}, timeout)
+	err = f.Error()
+	if err != nil {
+		return nil, fmt.Errorf("apply: %w", err)
+	}
+	resp, ok := f.Response().(*v1.RaftApplyResponse)
+	if !ok {
+		return nil, fmt.Errorf("apply: invalid response type")
+	}
+	return resp, nil
+}
+
+// ApplyRaftLogBatch applies a batch of raft log entries as a single raft log.
+// The entries are applied atomically by the FSM.
+func (r *Provider) ApplyRaftLogBatch(ctx context.Context, batch storage.LogBatch) (*v1.RaftApplyResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started.Load() {
+		return nil, errors.ErrClosed
+	}
+	if !r.Consensus().IsLeader() {
+		return nil, errors.ErrNotLeader
+	}
+	if err := r.checkWritable(); err != nil {
+		return nil, err
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	r.log.Debug("Applying log batch",
+		slog.Int("count", len(batch.Entries)),
+		slog.Duration("timeout", timeout),
+	)
+	data, err := fsm.MarshalLogBatch(batch)
+	if err != nil {
+		return nil, fmt.Errorf("marshal log batch: %w", err)
+	}
+	f := r.raft.ApplyLog(raft.Log{
+		Data:       data,
+		Extensions: fsm.WithTraceExtension(ctx, fsm.BatchExtension),
+	}, timeout)
 	err = f.Error()
 	if err != nil {
 		return nil, fmt.Errorf("apply: %w", err)
@@ -423,6 +541,10 @@ func (r *Provider) createStorage() (storage.DualStorage, error) {
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, fmt.Errorf("ensure data directory: %w", err)
 	}
+	// Refuse to open the store if the disk is already full.
+	if err := r.Options.checkDiskSpace(dataDir); err != nil {
+		return nil, err
+	}
 	db, err := badgerdb.New(badgerdb.Options{
 		DiskPath:   dataDir,
 		SyncWrites: true,
@@ -472,6 +594,10 @@ func (r *Provider) observe() (closeCh, doneCh chan struct{}) {
 					r.log.Debug("PeerObservation", slog.Any("data", data))
 				case raft.LeaderObservation:
 					r.log.Debug("LeaderObservation", slog.Any("data", data))
+					r.leadership.notify(data.LeaderID == r.nodeID, string(data.LeaderID))
+					if data.LeaderID == r.nodeID {
+						go r.recordLeader(data)
+					}
 				case raft.ResumedHeartbeatObservation:
 					r.log.Debug("ResumedHeartbeatObservation", slog.Any("data", data))
 				case raft.FailedHeartbeatObservation:
@@ -485,3 +611,22 @@ func (r *Provider) observe() (closeCh, doneCh chan struct{}) {
 	}()
 	return closeCh, doneCh
 }
+
+// recordLeader records this node as the leader in the mesh state so that
+// other nodes can look up the leader without a consensus round-trip.
+func (r *Provider) recordLeader(obs raft.LeaderObservation) {
+	timeout := r.Options.ApplyTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	leader := types.StoragePeer{StoragePeer: &v1.StoragePeer{
+		Id:            string(obs.LeaderID),
+		Address:       string(obs.LeaderAddr),
+		ClusterStatus: v1.ClusterStatus_CLUSTER_LEADER,
+	}}
+	if err := r.MeshDB().MeshState().SetLeader(ctx, leader); err != nil {
+		r.log.Warn("Failed to record leader in mesh state", slog.String("error", err.Error()))
+	}
+}
@@ -64,3 +64,44 @@ func Apply(ctx context.Context, db storage.MeshStorage, logEntry *v1.RaftLogEntr
 		}
 	}
 }
+
+// ApplyBatch applies a batch of raft logs to the given storage. The entries
+// are committed together using the storage's Batch, so either all of them
+// are applied or none of them are.
+func ApplyBatch(ctx context.Context, db storage.MeshStorage, logBatch storage.LogBatch) *v1.RaftApplyResponse {
+	start := time.Now()
+	log := context.LoggerFrom(ctx)
+	res := &v1.RaftApplyResponse{}
+	// Logs are applied one at a time, so checking for absent keys here is
+	// atomic with the writes that follow.
+	if err := storage.CheckAbsent(ctx, db, logBatch.Absent); err != nil {
+		res.Error = err.Error()
+		res.Time = time.Since(start).String()
+		return res
+	}
+	batch := db.Batch()
+	for _, logEntry := range logBatch.Entries {
+		switch logEntry.GetType() {
+		case v1.RaftCommandType_PUT:
+			log.Debug("Queueing put",
+				slog.String("key", string(logEntry.GetKey())),
+				slog.String("value", string(logEntry.GetValue())),
+			)
+			batch.PutValue(logEntry.GetKey(), logEntry.GetValue(), logEntry.Ttl.AsDuration())
+		case v1.RaftCommandType_DELETE:
+			log.Debug("Queueing delete",
+				slog.String("key", string(logEntry.GetKey())),
+			)
+			batch.Delete(logEntry.GetKey())
+		default:
+			res.Error = fmt.Sprintf("unknown command type: %v", logEntry.GetType())
+			res.Time = time.Since(start).String()
+			return res
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		res.Error = err.Error()
+	}
+	res.Time = time.Since(start).String()
+	return res
+}
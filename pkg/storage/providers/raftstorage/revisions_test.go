@@ -0,0 +1,104 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRevisionsAcrossNodes(t *testing.T) {
+	ctx := context.Background()
+	builder := &builder{}
+	providers := builder.newProviders(t, 2)
+	for _, p := range providers {
+		p := p
+		t.Cleanup(func() { _ = p.Close() })
+	}
+	first, second := providers[0].(*Provider), providers[1].(*Provider)
+	testutil.MustStartProvider(ctx, t, first)
+	testutil.MustStartProvider(ctx, t, second)
+	testutil.MustBootstrapProvider(ctx, t, first)
+	testutil.MustAddVoter(ctx, t, first, second)
+
+	putNode := func(id string) {
+		t.Helper()
+		err := first.MeshDB().Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id}})
+		if err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	revision := func(p *Provider) uint64 {
+		rev, err := p.MeshStorage().Revision(ctx)
+		if err != nil {
+			t.Fatalf("get revision: %v", err)
+		}
+		return rev
+	}
+	// Wait for the follower without asking it for revisions, which it would
+	// then retain.
+	caughtUp := func() uint64 {
+		t.Helper()
+		want := first.raftStorage.fsm.LastAppliedIndex()
+		ok := testutil.Eventually[uint64](func() uint64 {
+			return second.raftStorage.fsm.LastAppliedIndex()
+		}).ShouldEqual(time.Second*15, time.Millisecond*250, want)
+		if !ok {
+			t.Fatalf("expected the second node to apply up to %d", want)
+		}
+		return want
+	}
+
+	putNode("node-a")
+	rev := caughtUp()
+
+	// A revision from the leader describes the same state on the follower.
+	changes, next, err := storage.ListPeersChangedSince(ctx, second.MeshStorage(), rev)
+	if err != nil {
+		t.Fatalf("list peers changed since %d: %v", rev, err)
+	}
+	if len(changes) != 0 || next != rev {
+		t.Fatalf("expected no changes at revision %d, got %v at %d", rev, changes, next)
+	}
+
+	// Once the follower moves on, a revision it never retained is rejected
+	// instead of producing a delta against the wrong state.
+	putNode("node-b")
+	leaderRev := revision(first)
+	putNode("node-c")
+	caughtUp()
+	_, _, err = storage.ListPeersChangedSince(ctx, second.MeshStorage(), leaderRev)
+	if !errors.Is(err, errors.ErrInvalidRevision) {
+		t.Fatalf("expected an unretained revision to be invalid, got %v", err)
+	}
+	// The node that handed out the revision can still serve it.
+	changes, _, err = storage.ListPeersChangedSince(ctx, first.MeshStorage(), leaderRev)
+	if err != nil {
+		t.Fatalf("list peers changed since %d: %v", leaderRev, err)
+	}
+	if len(changes) != 1 || changes[0].ID != "node-c" {
+		t.Fatalf("expected node-c to be created, got %v", changes)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// ErrSnapshotRestoreDisabled is returned when restoring a snapshot without
+// AllowSnapshotRestore set.
+var ErrSnapshotRestoreDisabled = fmt.Errorf("snapshot restore is disabled")
+
+// SnapshotRestoreCallback is a callback invoked after a snapshot has been
+// restored to storage.
+type SnapshotRestoreCallback func(ctx context.Context)
+
+// OnSnapshotRestore registers a callback for when a snapshot is restored to
+// storage. This includes snapshots installed from the leader and snapshots
+// restored with RestoreSnapshot. Callbacks are invoked asynchronously.
+func (r *Provider) OnSnapshotRestore(cb SnapshotRestoreCallback) {
+	r.restoremu.Lock()
+	defer r.restoremu.Unlock()
+	r.restoreCbs = append(r.restoreCbs, cb)
+}
+
+func (r *Provider) onSnapshotRestore(ctx context.Context) {
+	// Revisions handed out before the restore no longer describe the storage.
+	r.raftStorage.resetRevisions()
+	r.restoremu.Lock()
+	cbs := r.restoreCbs
+	r.restoremu.Unlock()
+	for _, cb := range cbs {
+		go cb(ctx)
+	}
+}
+
+// Snapshot takes a snapshot of the current raft state and returns its
+// metadata. Snapshots may only be triggered on the leader. If there is
+// nothing new to snapshot, the metadata of the latest snapshot is returned.
+func (r *Provider) Snapshot(ctx context.Context) (*SnapshotMeta, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started.Load() {
+		return nil, errors.ErrClosed
+	}
+	if !r.Consensus().IsLeader() {
+		return nil, errors.ErrNotLeader
+	}
+	r.log.Debug("Taking manual raft snapshot")
+	f := r.raft.Snapshot()
+	errc := make(chan error, 1)
+	go func() { errc <- f.Error() }()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-errc:
+		if err == raft.ErrNothingNewToSnapshot {
+			return r.latestSnapshot()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	meta, rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open snapshot: %w", err)
+	}
+	defer rc.Close()
+	r.log.Info("Took manual raft snapshot",
+		slog.String("id", meta.ID),
+		slog.Uint64("index", meta.Index),
+		slog.Uint64("term", meta.Term),
+		slog.Int64("size", meta.Size),
+	)
+	return meta, nil
+}
+
+// latestSnapshot returns the metadata of the most recent snapshot.
+func (r *Provider) latestSnapshot() (*SnapshotMeta, error) {
+	snapshots, err := r.snapshots.List()
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("snapshot: %w", raft.ErrNothingNewToSnapshot)
+	}
+	return snapshots[0], nil
+}
+
+// ExportSnapshot takes a snapshot and writes its contents to dst so that it
+// can later be restored with RestoreSnapshot.
+func (r *Provider) ExportSnapshot(ctx context.Context, dst io.Writer) (*SnapshotMeta, error) {
+	meta, err := r.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, rc, err := r.snapshots.Open(meta.ID)
+	if err != nil {
+		return nil, fmt.Errorf("open snapshot: %w", err)
+	}
+	defer rc.Close()
+	if _, err := io.Copy(dst, rc); err != nil {
+		return nil, fmt.Errorf("export snapshot: %w", err)
+	}
+	return meta, nil
+}
+
+// RestoreSnapshot replaces all storage state with the contents of a snapshot
+// previously written by ExportSnapshot. Writes are paused while the snapshot is
+// installed on the leader, after which it is replicated to the rest of the
+// cluster. This is only intended for disaster recovery and requires
+// AllowSnapshotRestore.
+func (r *Provider) RestoreSnapshot(ctx context.Context, src io.Reader) error {
+	if !r.Options.AllowSnapshotRestore {
+		return ErrSnapshotRestoreDisabled
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started.Load() {
+		return errors.ErrClosed
+	}
+	if !r.Consensus().IsLeader() {
+		return errors.ErrNotLeader
+	}
+	// Raft needs the size of the snapshot up front.
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, src); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	r.log.Warn("Restoring storage from snapshot", slog.Int("size", buf.Len()))
+	meta := &raft.SnapshotMeta{
+		Version: raft.SnapshotVersionMax,
+		Size:    int64(buf.Len()),
+	}
+	if err := r.raft.Restore(meta, &buf, timeout); err != nil {
+		return fmt.Errorf("restore snapshot: %w", err)
+	}
+	r.log.Info("Restored storage from snapshot")
+	return nil
+}
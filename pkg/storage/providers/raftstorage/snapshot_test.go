@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+)
+
+func TestExportRestoreSnapshot(t *testing.T) {
+	ctx := context.Background()
+	builder := &builder{}
+	provider := builder.newProviders(t, 1)[0].(*Provider)
+	t.Cleanup(func() { _ = provider.Close() })
+	restored := make(chan struct{}, 1)
+	provider.OnSnapshotRestore(func(context.Context) {
+		select {
+		case restored <- struct{}{}:
+		default:
+		}
+	})
+	testutil.MustStartProvider(ctx, t, provider)
+	testutil.MustBootstrapProvider(ctx, t, provider)
+	ok := testutil.Eventually[bool](func() bool {
+		return provider.Consensus().IsLeader()
+	}).ShouldEqual(time.Second*15, time.Millisecond*250, true)
+	if !ok {
+		t.Fatal("expected provider to become leader")
+	}
+
+	st := provider.MeshStorage()
+	if err := st.PutValue(ctx, []byte("/registry/kept"), []byte("before"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+	var exported bytes.Buffer
+	meta, err := provider.ExportSnapshot(ctx, &exported)
+	if err != nil {
+		t.Fatalf("export snapshot: %v", err)
+	}
+	if meta.Index == 0 || exported.Len() == 0 {
+		t.Fatalf("expected a non-empty snapshot, got index %d and %d bytes", meta.Index, exported.Len())
+	}
+
+	// Change the state after the export.
+	if err := st.PutValue(ctx, []byte("/registry/kept"), []byte("after"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+	if err := st.PutValue(ctx, []byte("/registry/added"), []byte("after"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+
+	// Restoring is refused unless explicitly allowed.
+	err = provider.RestoreSnapshot(ctx, bytes.NewReader(exported.Bytes()))
+	if !errors.Is(err, ErrSnapshotRestoreDisabled) {
+		t.Fatalf("expected restore to be disabled, got %v", err)
+	}
+	provider.Options.AllowSnapshotRestore = true
+	if err := provider.RestoreSnapshot(ctx, bytes.NewReader(exported.Bytes())); err != nil {
+		t.Fatalf("restore snapshot: %v", err)
+	}
+
+	value, err := st.GetValue(ctx, []byte("/registry/kept"))
+	if err != nil {
+		t.Fatalf("get value: %v", err)
+	}
+	if string(value) != "before" {
+		t.Errorf("expected restored value %q, got %q", "before", value)
+	}
+	if _, err := st.GetValue(ctx, []byte("/registry/added")); !errors.IsKeyNotFound(err) {
+		t.Errorf("expected key written after the export to be gone, got %v", err)
+	}
+	select {
+	case <-restored:
+	case <-time.After(5 * time.Second):
+		t.Error("expected snapshot restore callbacks to be invoked")
+	}
+}
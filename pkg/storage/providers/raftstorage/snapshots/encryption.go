@@ -0,0 +1,142 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshots
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// EncryptionKeySize is the size of snapshot encryption keys in bytes.
+const EncryptionKeySize = 32
+
+// encryptedValueMarker prefixes values encrypted in a snapshot. Its first
+// byte can never start a protojson value, a value written with the binary
+// storage codec, or valid UTF-8.
+var encryptedValueMarker = []byte("\xffwmenc1")
+
+// Encryption configures the encryption of sensitive values in snapshots.
+// Values are sealed with AES-256-GCM using the storage key as additional
+// data, so an encrypted value cannot be moved to another key.
+type Encryption struct {
+	// Key is the AES-256 key used to encrypt sensitive values.
+	Key []byte
+	// SensitivePrefixes are the key prefixes whose values are encrypted.
+	SensitivePrefixes [][]byte
+}
+
+// Validate validates the encryption options.
+func (e *Encryption) Validate() error {
+	if e == nil {
+		return nil
+	}
+	if len(e.Key) != EncryptionKeySize {
+		return fmt.Errorf("snapshot encryption key must be %d bytes, got %d", EncryptionKeySize, len(e.Key))
+	}
+	return nil
+}
+
+// IsSensitive returns true if values for the given key should be encrypted.
+func (e *Encryption) IsSensitive(key []byte) bool {
+	if e == nil {
+		return false
+	}
+	for _, prefix := range e.SensitivePrefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptSnapshot encrypts the values of sensitive keys in the given
+// serialized snapshot.
+func (e *Encryption) EncryptSnapshot(data []byte) ([]byte, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+	var snapshot v1.RaftSnapshot
+	if err := proto.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	for _, item := range snapshot.GetKv() {
+		if !e.IsSensitive(item.GetKey()) || isEncrypted(item.GetValue()) {
+			continue
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, fmt.Errorf("generate nonce: %w", err)
+		}
+		out := make([]byte, 0, len(encryptedValueMarker)+len(nonce)+len(item.GetValue())+aead.Overhead())
+		out = append(out, encryptedValueMarker...)
+		out = append(out, nonce...)
+		item.Value = aead.Seal(out, nonce, item.GetValue(), item.GetKey())
+	}
+	return proto.Marshal(&snapshot)
+}
+
+// DecryptSnapshot decrypts any encrypted values in the given serialized
+// snapshot. Values are decrypted regardless of the current sensitive
+// prefixes so that snapshots remain restorable after they change.
+func (e *Encryption) DecryptSnapshot(data []byte) ([]byte, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+	var snapshot v1.RaftSnapshot
+	if err := proto.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	for _, item := range snapshot.GetKv() {
+		if !isEncrypted(item.GetValue()) {
+			continue
+		}
+		sealed := item.GetValue()[len(encryptedValueMarker):]
+		if len(sealed) < aead.NonceSize() {
+			return nil, fmt.Errorf("decrypt value for key %q: ciphertext too short", item.GetKey())
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		item.Value, err = aead.Open(nil, nonce, ciphertext, item.GetKey())
+		if err != nil {
+			return nil, fmt.Errorf("decrypt value for key %q: %w", item.GetKey(), err)
+		}
+	}
+	return proto.Marshal(&snapshot)
+}
+
+func (e *Encryption) aead() (cipher.AEAD, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(e.Key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func isEncrypted(value []byte) bool {
+	return bytes.HasPrefix(value, encryptedValueMarker)
+}
@@ -39,16 +39,25 @@ type Snapshotter interface {
 	Restore(ctx context.Context, r io.ReadCloser) error
 }
 
+// Options are options for a Snapshotter.
+type Options struct {
+	// Encryption enables encryption of sensitive values in snapshots.
+	// Snapshots are written and restored in plain text when nil.
+	Encryption *Encryption
+}
+
 type snapshotter struct {
-	st  storage.ConsensusStorage
-	log *slog.Logger
+	st   storage.ConsensusStorage
+	opts Options
+	log  *slog.Logger
 }
 
 // New returns a new Snapshotter.
-func New(ctx context.Context, st storage.ConsensusStorage) Snapshotter {
+func New(ctx context.Context, st storage.ConsensusStorage, opts Options) Snapshotter {
 	return &snapshotter{
-		st:  st,
-		log: context.LoggerFrom(ctx).With("component", "snapshots"),
+		st:   st,
+		opts: opts,
+		log:  context.LoggerFrom(ctx).With("component", "snapshots"),
 	}
 }
 
@@ -59,6 +68,17 @@ func (s *snapshotter) Snapshot(ctx context.Context) (raft.FSMSnapshot, error) {
 	if err != nil {
 		return nil, fmt.Errorf("get snapshot: %w", err)
 	}
+	if s.opts.Encryption != nil {
+		raw, err := io.ReadAll(data)
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot data: %w", err)
+		}
+		encrypted, err := s.opts.Encryption.EncryptSnapshot(raw)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt snapshot: %w", err)
+		}
+		data = bytes.NewReader(encrypted)
+	}
 	var buf bytes.Buffer
 	gzw := gzip.NewWriter(&buf)
 	if _, err := io.Copy(gzw, data); err != nil {
@@ -88,6 +108,12 @@ func (s *snapshotter) Restore(ctx context.Context, r io.ReadCloser) error {
 	if err != nil {
 		return fmt.Errorf("read snapshot: %w", err)
 	}
+	if s.opts.Encryption != nil {
+		data, err = s.opts.Encryption.DecryptSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("decrypt snapshot: %w", err)
+		}
+	}
 	if err := s.st.Restore(ctx, bytes.NewReader(data)); err != nil {
 		return fmt.Errorf("restore snapshot: %w", err)
 	}
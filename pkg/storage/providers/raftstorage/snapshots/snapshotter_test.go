@@ -18,10 +18,13 @@ package snapshots
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"io"
 	"testing"
 
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
 )
 
@@ -45,7 +48,7 @@ func TestSnapshotter(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
-	snaps := New(context.Background(), db)
+	snaps := New(context.Background(), db, Options{})
 
 	// Take a snapshot.
 	snap, err := snaps.Snapshot(context.Background())
@@ -86,6 +89,101 @@ func TestSnapshotter(t *testing.T) {
 	}
 }
 
+func TestSnapshotterEncryption(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	enc := &Encryption{
+		Key:               key,
+		SensitivePrefixes: [][]byte{[]byte("/registry/secrets/")},
+	}
+	testValues := map[string][]byte{
+		"/registry/secrets/join-token": []byte("super-secret-token"),
+		"/registry/secrets/psk":        []byte("super-secret-psk"),
+		"/registry/public":             []byte("public-value"),
+	}
+	newDB := func(t *testing.T) storage.DualStorage {
+		t.Helper()
+		db, err := badgerdb.NewInMemory(badgerdb.Options{})
+		if err != nil {
+			t.Fatalf("create test db: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	}
+	db := newDB(t)
+	for key, val := range testValues {
+		if err := db.PutValue(ctx, []byte(key), val, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap, err := New(ctx, db, Options{Encryption: enc}).Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+	buf := new(bytes.Buffer)
+	if err := snap.Persist(&testSnapshotSink{buf}); err != nil {
+		t.Fatal(err)
+	}
+	persisted := buf.Bytes()
+
+	// Sensitive values must not appear in the persisted snapshot.
+	gzr, err := gzip.NewReader(bytes.NewReader(persisted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gzr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, val := range testValues {
+		sensitive := enc.IsSensitive([]byte(key))
+		if bytes.Contains(raw, val) == sensitive {
+			t.Errorf("unexpected plaintext visibility for %s (sensitive: %v)", key, sensitive)
+		}
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		t.Parallel()
+		restored := newDB(t)
+		err := New(ctx, restored, Options{Encryption: enc}).Restore(ctx, io.NopCloser(bytes.NewReader(persisted)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, val := range testValues {
+			got, err := restored.GetValue(ctx, []byte(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, val) {
+				t.Errorf("got %q, want %q", got, val)
+			}
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		t.Parallel()
+		wrong := &Encryption{Key: bytes.Repeat([]byte{1}, EncryptionKeySize)}
+		err := New(ctx, newDB(t), Options{Encryption: wrong}).Restore(ctx, io.NopCloser(bytes.NewReader(persisted)))
+		if err == nil {
+			t.Error("expected restore with the wrong key to fail")
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		t.Parallel()
+		invalid := &Encryption{Key: []byte("short")}
+		if _, err := New(ctx, db, Options{Encryption: invalid}).Snapshot(ctx); err == nil {
+			t.Error("expected snapshot with an invalid key to fail")
+		}
+	})
+}
+
 type testSnapshotSink struct {
 	io.ReadWriter
 }
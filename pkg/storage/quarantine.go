@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"math"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// QuarantinePriority is the priority of the network ACLs that quarantine a
+// node. Deny ACLs are evaluated before accept ACLs of the same priority, so
+// quarantine ACLs take precedence over every other ACL.
+const QuarantinePriority = math.MaxInt32
+
+// QuarantineACLs returns the network ACLs that deny all traffic to and from
+// the given node.
+func QuarantineACLs(nodeID types.NodeID) types.NetworkACLs {
+	return types.NetworkACLs{
+		{NetworkACL: &v1.NetworkACL{
+			Name:             quarantineACLName(nodeID, "egress"),
+			Priority:         QuarantinePriority,
+			SourceNodes:      []string{nodeID.String()},
+			DestinationNodes: []string{"*"},
+			Action:           v1.ACLAction_ACTION_DENY,
+		}},
+		{NetworkACL: &v1.NetworkACL{
+			Name:             quarantineACLName(nodeID, "ingress"),
+			Priority:         QuarantinePriority,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{nodeID.String()},
+			Action:           v1.ACLAction_ACTION_DENY,
+		}},
+	}
+}
+
+func quarantineACLName(nodeID types.NodeID, direction string) string {
+	return fmt.Sprintf("quarantine-%s-%s", nodeID, direction)
+}
+
+// QuarantineNode cuts the given node off from the rest of the mesh by adding
+// ACLs that deny all of its traffic. The node itself is left in place.
+func QuarantineNode(ctx context.Context, nw Networking, nodeID types.NodeID) error {
+	for _, acl := range QuarantineACLs(nodeID) {
+		err := nw.PutNetworkACL(ctx, acl)
+		if err != nil {
+			return fmt.Errorf("put quarantine acl %q: %w", acl.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// UnquarantineNode removes the ACLs added by QuarantineNode. It is not an
+// error if the node is not quarantined.
+func UnquarantineNode(ctx context.Context, nw Networking, nodeID types.NodeID) error {
+	for _, acl := range QuarantineACLs(nodeID) {
+		err := nw.DeleteNetworkACL(ctx, acl.GetName())
+		if err != nil && !errors.IsACLNotFound(err) {
+			return fmt.Errorf("delete quarantine acl %q: %w", acl.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// IsQuarantined returns true if any of the quarantine ACLs for the given node exist.
+func IsQuarantined(ctx context.Context, nw Networking, nodeID types.NodeID) (bool, error) {
+	for _, acl := range QuarantineACLs(nodeID) {
+		_, err := nw.GetNetworkACL(ctx, acl.GetName())
+		if err == nil {
+			return true, nil
+		}
+		if !errors.IsACLNotFound(err) {
+			return false, fmt.Errorf("get quarantine acl %q: %w", acl.GetName(), err)
+		}
+	}
+	return false, nil
+}
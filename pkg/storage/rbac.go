@@ -18,11 +18,22 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 var (
+	// RolesPrefix is where Roles are stored in the database.
+	RolesPrefix = types.RegistryPrefix.ForString("roles")
+	// RoleBindingsPrefix is where RoleBindings are stored in the database.
+	RoleBindingsPrefix = types.RegistryPrefix.ForString("rolebindings")
+	// GroupsPrefix is where Groups are stored in the database.
+	GroupsPrefix = types.RegistryPrefix.ForString("groups")
 	// MeshAdminRole is the name of the mesh admin role.
 	MeshAdminRole = []byte("mesh-admin")
 	// MeshAdminRoleBinding is the name of the mesh admin rolebinding.
@@ -73,6 +84,41 @@ type RBAC interface {
 	ListNodeRoles(ctx context.Context, nodeID types.NodeID) (types.RolesList, error)
 	// ListUserRoles returns a list of all roles for a user.
 	ListUserRoles(ctx context.Context, user types.NodeID) (types.RolesList, error)
+
+	// Enforce returns nil if the identity is allowed to perform the verb on
+	// the resource, or an error wrapping errors.ErrPermissionDenied if not.
+	// Roles are evaluated with the given options. Role lookups are cached in
+	// contexts created with WithRoleCache.
+	Enforce(ctx context.Context, identity types.NodeID, verb v1.RuleVerb, resource types.RBACResource, opts types.EvalOptions) error
+
+	// ExportRBAC returns all roles, rolebindings, and groups as a policy.
+	ExportRBAC(ctx context.Context) (types.RBACPolicy, error)
+	// ImportRBAC applies the given policy using the given mode.
+	ImportRBAC(ctx context.Context, policy types.RBACPolicy, mode RBACImportMode) error
+}
+
+// RBACImportMode is the mode used when importing an RBAC policy.
+type RBACImportMode int
+
+const (
+	// RBACImportMerge creates or updates the items in the policy and leaves
+	// all other items untouched.
+	RBACImportMerge RBACImportMode = iota
+	// RBACImportReplace creates or updates the items in the policy and removes
+	// all other non-system items.
+	RBACImportReplace
+)
+
+// String returns the string representation of the mode.
+func (m RBACImportMode) String() string {
+	switch m {
+	case RBACImportMerge:
+		return "merge"
+	case RBACImportReplace:
+		return "replace"
+	default:
+		return fmt.Sprintf("RBACImportMode(%d)", int(m))
+	}
 }
 
 // IsSystemRole returns true if the role is a system role.
@@ -89,3 +135,250 @@ func IsSystemRoleBinding(name string) bool {
 func IsSystemGroup(name string) bool {
 	return name == string(VotersGroup)
 }
+
+type roleCacheKey struct{}
+
+// roleCache caches the roles resolved for identities during a request.
+type roleCache struct {
+	roles map[types.NodeID]types.RolesList
+	mu    sync.Mutex
+}
+
+// WithRoleCache returns a context that caches the roles looked up by Enforce.
+// It should be used for the lifetime of a single request so that multiple
+// permission checks only resolve an identity's roles once. If the context
+// already has a cache, it is returned unchanged.
+func WithRoleCache(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(roleCacheKey{}).(*roleCache); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, roleCacheKey{}, &roleCache{
+		roles: make(map[types.NodeID]types.RolesList),
+	})
+}
+
+// Enforce implements RBAC.Enforce using the given RBAC interface. Nodes and users
+// are treated as the same entity, so roles bound to either apply to the identity.
+// The roles of the identity are evaluated with the given options.
+func Enforce(ctx context.Context, rbac RBAC, identity types.NodeID, verb v1.RuleVerb, resource types.RBACResource, opts types.EvalOptions) error {
+	if identity == "" {
+		return fmt.Errorf("%w: no identity provided", errors.ErrPermissionDenied)
+	}
+	roles, err := resolveRoles(ctx, rbac, identity)
+	if err != nil {
+		return err
+	}
+	if !opts.Eval(roles, resource.Action(verb)) {
+		return fmt.Errorf("%w: %s cannot %s %s %q", errors.ErrPermissionDenied, identity, verb, resource.Type, resource.Name)
+	}
+	return nil
+}
+
+func resolveRoles(ctx context.Context, rbac RBAC, identity types.NodeID) (types.RolesList, error) {
+	cache, ok := ctx.Value(roleCacheKey{}).(*roleCache)
+	if ok {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if roles, ok := cache.roles[identity]; ok {
+			return roles, nil
+		}
+	}
+	nodeRoles, err := rbac.ListNodeRoles(ctx, identity)
+	if err != nil {
+		return nil, fmt.Errorf("list node roles: %w", err)
+	}
+	userRoles, err := rbac.ListUserRoles(ctx, identity)
+	if err != nil {
+		return nil, fmt.Errorf("list user roles: %w", err)
+	}
+	roles := append(nodeRoles, userRoles...)
+	if cache != nil {
+		cache.roles[identity] = roles
+	}
+	return roles, nil
+}
+
+// ExportRBAC implements RBAC.ExportRBAC using the given RBAC interface.
+func ExportRBAC(ctx context.Context, rbac RBAC) (types.RBACPolicy, error) {
+	var policy types.RBACPolicy
+	var err error
+	policy.Roles, err = rbac.ListRoles(ctx)
+	if err != nil {
+		return policy, fmt.Errorf("list roles: %w", err)
+	}
+	policy.RoleBindings, err = rbac.ListRoleBindings(ctx)
+	if err != nil {
+		return policy, fmt.Errorf("list rolebindings: %w", err)
+	}
+	policy.Groups, err = rbac.ListGroups(ctx)
+	if err != nil {
+		return policy, fmt.Errorf("list groups: %w", err)
+	}
+	return policy, nil
+}
+
+// ImportRBAC implements RBAC.ImportRBAC using the given RBAC interface to read the
+// current state. The policy is validated before any changes are made. Rolebindings
+// must reference roles that exist in the policy or, when merging, already exist in
+// storage. The same applies to group subjects. System roles, rolebindings, and groups
+// are never modified. All changes are queued in the given batch and committed
+// together, so a failed import leaves storage untouched when the batch is atomic.
+func ImportRBAC(ctx context.Context, rbac RBAC, batch Batch, policy types.RBACPolicy, mode RBACImportMode) error {
+	if mode != RBACImportMerge && mode != RBACImportReplace {
+		return fmt.Errorf("invalid rbac import mode: %s", mode)
+	}
+	err := policy.Validate()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidRBACPolicy, err)
+	}
+	current, err := ExportRBAC(ctx, rbac)
+	if err != nil {
+		return fmt.Errorf("export current rbac: %w", err)
+	}
+	err = validateRBACReferences(current, policy, mode)
+	if err != nil {
+		return err
+	}
+	// Queue the policy. Roles and groups go first so rolebindings never
+	// reference missing items, even when the batch is applied sequentially.
+	policyRoles := make(map[string]struct{})
+	for _, role := range policy.Roles {
+		policyRoles[role.GetName()] = struct{}{}
+		if IsSystemRole(role.GetName()) {
+			continue
+		}
+		if err := PutRoleInBatch(batch, role); err != nil {
+			return fmt.Errorf("put role %q: %w", role.GetName(), err)
+		}
+	}
+	policyGroups := make(map[string]struct{})
+	for _, group := range policy.Groups {
+		policyGroups[group.GetName()] = struct{}{}
+		if IsSystemGroup(group.GetName()) {
+			continue
+		}
+		if err := PutGroupInBatch(batch, group); err != nil {
+			return fmt.Errorf("put group %q: %w", group.GetName(), err)
+		}
+	}
+	policyRoleBindings := make(map[string]struct{})
+	for _, rb := range policy.RoleBindings {
+		policyRoleBindings[rb.GetName()] = struct{}{}
+		if IsSystemRoleBinding(rb.GetName()) {
+			continue
+		}
+		if err := PutRoleBindingInBatch(batch, rb); err != nil {
+			return fmt.Errorf("put rolebinding %q: %w", rb.GetName(), err)
+		}
+	}
+	if mode == RBACImportReplace {
+		queueRBACDeletes(batch, current, policyRoles, policyGroups, policyRoleBindings)
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("import rbac: %w", err)
+	}
+	return nil
+}
+
+// queueRBACDeletes queues the removal of everything in current that is not in
+// the policy, except for system items.
+func queueRBACDeletes(batch Batch, current types.RBACPolicy, policyRoles, policyGroups, policyRoleBindings map[string]struct{}) {
+	// Remove everything that is not in the policy, rolebindings first.
+	for _, rb := range current.RoleBindings {
+		if _, ok := policyRoleBindings[rb.GetName()]; ok || IsSystemRoleBinding(rb.GetName()) {
+			continue
+		}
+		batch.Delete(RoleBindingsPrefix.ForString(rb.GetName()))
+	}
+	for _, group := range current.Groups {
+		if _, ok := policyGroups[group.GetName()]; ok || IsSystemGroup(group.GetName()) {
+			continue
+		}
+		batch.Delete(GroupsPrefix.ForString(group.GetName()))
+	}
+	for _, role := range current.Roles {
+		if _, ok := policyRoles[role.GetName()]; ok || IsSystemRole(role.GetName()) {
+			continue
+		}
+		batch.Delete(RolesPrefix.ForString(role.GetName()))
+	}
+}
+
+// PutRoleInBatch validates the given role and queues it for writing in the batch.
+func PutRoleInBatch(batch Batch, role types.Role) error {
+	if err := role.Validate(); err != nil {
+		return fmt.Errorf("validate role: %w", err)
+	}
+	data, err := role.MarshalProtoJSON()
+	if err != nil {
+		return fmt.Errorf("marshal role: %w", err)
+	}
+	batch.PutValue(RolesPrefix.ForString(role.GetName()), data, 0)
+	return nil
+}
+
+// PutRoleBindingInBatch validates the given rolebinding and queues it for writing
+// in the batch.
+func PutRoleBindingInBatch(batch Batch, rolebinding types.RoleBinding) error {
+	if err := rolebinding.Validate(); err != nil {
+		return fmt.Errorf("validate rolebinding: %w", err)
+	}
+	data, err := rolebinding.MarshalProtoJSON()
+	if err != nil {
+		return fmt.Errorf("marshal rolebinding: %w", err)
+	}
+	batch.PutValue(RoleBindingsPrefix.ForString(rolebinding.GetName()), data, 0)
+	return nil
+}
+
+// PutGroupInBatch validates the given group and queues it for writing in the batch.
+func PutGroupInBatch(batch Batch, group types.Group) error {
+	if err := group.Validate(); err != nil {
+		return fmt.Errorf("validate group: %w", err)
+	}
+	data, err := group.MarshalProtoJSON()
+	if err != nil {
+		return fmt.Errorf("marshal group: %w", err)
+	}
+	batch.PutValue(GroupsPrefix.ForString(group.GetName()), data, 0)
+	return nil
+}
+
+// validateRBACReferences checks that every rolebinding in the policy references
+// roles and groups that will exist once the policy is applied on top of current
+// with the given mode.
+func validateRBACReferences(current, policy types.RBACPolicy, mode RBACImportMode) error {
+	// Build the set of roles and groups that will exist after the import.
+	roles := make(map[string]struct{})
+	groups := make(map[string]struct{})
+	for _, role := range current.Roles {
+		if mode == RBACImportMerge || IsSystemRole(role.GetName()) {
+			roles[role.GetName()] = struct{}{}
+		}
+	}
+	for _, group := range current.Groups {
+		if mode == RBACImportMerge || IsSystemGroup(group.GetName()) {
+			groups[group.GetName()] = struct{}{}
+		}
+	}
+	for _, role := range policy.Roles {
+		roles[role.GetName()] = struct{}{}
+	}
+	for _, group := range policy.Groups {
+		groups[group.GetName()] = struct{}{}
+	}
+	for _, rb := range policy.RoleBindings {
+		if _, ok := roles[rb.GetRole()]; !ok {
+			return fmt.Errorf("%w: rolebinding %q references unknown role %q", errors.ErrInvalidRBACPolicy, rb.GetName(), rb.GetRole())
+		}
+		for _, subject := range rb.GetSubjects() {
+			if subject.GetType() != v1.SubjectType_SUBJECT_GROUP {
+				continue
+			}
+			if _, ok := groups[subject.GetName()]; !ok {
+				return fmt.Errorf("%w: rolebinding %q references unknown group %q", errors.ErrInvalidRBACPolicy, rb.GetName(), subject.GetName())
+			}
+		}
+	}
+	return nil
+}
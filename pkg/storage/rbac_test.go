@@ -0,0 +1,382 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func testRBACPolicy() types.RBACPolicy {
+	return types.RBACPolicy{
+		Roles: types.RolesList{
+			{Role: &v1.Role{
+				Name: "route-admin",
+				Rules: []*v1.Rule{{
+					Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_ALL},
+					Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+				}},
+			}},
+			{Role: &v1.Role{
+				Name: "acl-viewer",
+				Rules: []*v1.Rule{{
+					Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_GET},
+					Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_NETWORK_ACLS},
+				}},
+			}},
+		},
+		RoleBindings: []types.RoleBinding{
+			{RoleBinding: &v1.RoleBinding{
+				Name: "route-admins",
+				Role: "route-admin",
+				Subjects: []*v1.Subject{
+					{Name: "admins", Type: v1.SubjectType_SUBJECT_GROUP},
+					{Name: "node-a", Type: v1.SubjectType_SUBJECT_NODE},
+				},
+			}},
+		},
+		Groups: []types.Group{
+			{Group: &v1.Group{
+				Name:     "admins",
+				Subjects: []*v1.Subject{{Name: "alice", Type: v1.SubjectType_SUBJECT_USER}},
+			}},
+		},
+	}
+}
+
+func TestImportExportRBAC(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		t.Parallel()
+		src := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = src.Close() })
+		dst := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = dst.Close() })
+
+		want := testRBACPolicy()
+		if err := src.RBAC().ImportRBAC(ctx, want, storage.RBACImportReplace); err != nil {
+			t.Fatalf("import policy: %v", err)
+		}
+		exported, err := src.RBAC().ExportRBAC(ctx)
+		if err != nil {
+			t.Fatalf("export policy: %v", err)
+		}
+		// Round trip through a file format.
+		data, err := json.Marshal(exported)
+		if err != nil {
+			t.Fatalf("marshal policy: %v", err)
+		}
+		var decoded types.RBACPolicy
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("unmarshal policy: %v", err)
+		}
+		if err := dst.RBAC().ImportRBAC(ctx, decoded, storage.RBACImportReplace); err != nil {
+			t.Fatalf("import decoded policy: %v", err)
+		}
+		got, err := dst.RBAC().ExportRBAC(ctx)
+		if err != nil {
+			t.Fatalf("export policy: %v", err)
+		}
+		assertPolicyEqual(t, want, got)
+	})
+
+	t.Run("MergeKeepsUnrelated", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		unrelated := types.Role{Role: &v1.Role{
+			Name: "unrelated",
+			Rules: []*v1.Rule{{
+				Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_GET},
+				Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_EDGES},
+			}},
+		}}
+		if err := db.RBAC().PutRole(ctx, unrelated); err != nil {
+			t.Fatalf("put role: %v", err)
+		}
+		if err := db.RBAC().ImportRBAC(ctx, testRBACPolicy(), storage.RBACImportMerge); err != nil {
+			t.Fatalf("import policy: %v", err)
+		}
+		if _, err := db.RBAC().GetRole(ctx, "unrelated"); err != nil {
+			t.Fatalf("expected unrelated role to remain after merge: %v", err)
+		}
+		if _, err := db.RBAC().GetRole(ctx, "route-admin"); err != nil {
+			t.Fatalf("expected imported role to exist: %v", err)
+		}
+		// A replace should remove it.
+		if err := db.RBAC().ImportRBAC(ctx, testRBACPolicy(), storage.RBACImportReplace); err != nil {
+			t.Fatalf("import policy: %v", err)
+		}
+		if _, err := db.RBAC().GetRole(ctx, "unrelated"); !errors.IsRoleNotFound(err) {
+			t.Fatalf("expected unrelated role to be removed after replace, got %v", err)
+		}
+	})
+
+	t.Run("MergeAllowsExistingReferences", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		policy := testRBACPolicy()
+		if err := db.RBAC().PutRole(ctx, policy.Roles[0]); err != nil {
+			t.Fatalf("put role: %v", err)
+		}
+		if err := db.RBAC().PutGroup(ctx, policy.Groups[0]); err != nil {
+			t.Fatalf("put group: %v", err)
+		}
+		bindingsOnly := types.RBACPolicy{RoleBindings: policy.RoleBindings}
+		if err := db.RBAC().ImportRBAC(ctx, bindingsOnly, storage.RBACImportMerge); err != nil {
+			t.Fatalf("import policy: %v", err)
+		}
+		if err := db.RBAC().ImportRBAC(ctx, bindingsOnly, storage.RBACImportReplace); !errors.Is(err, errors.ErrInvalidRBACPolicy) {
+			t.Fatalf("expected replace with missing references to fail, got %v", err)
+		}
+	})
+
+	t.Run("InvalidReferences", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		tc := map[string]func(p *types.RBACPolicy){
+			"UnknownRole": func(p *types.RBACPolicy) {
+				p.RoleBindings[0].Role = "missing"
+			},
+			"UnknownGroup": func(p *types.RBACPolicy) {
+				p.Groups = nil
+			},
+			"UnknownVerb": func(p *types.RBACPolicy) {
+				p.Roles[0].Rules[0].Verbs = []v1.RuleVerb{42}
+			},
+			"DuplicateRole": func(p *types.RBACPolicy) {
+				p.Roles = append(p.Roles, p.Roles[0])
+			},
+		}
+		for name, mutate := range tc {
+			policy := testRBACPolicy()
+			mutate(&policy)
+			err := db.RBAC().ImportRBAC(ctx, policy, storage.RBACImportMerge)
+			if !errors.Is(err, errors.ErrInvalidRBACPolicy) {
+				t.Errorf("%s: expected ErrInvalidRBACPolicy, got %v", name, err)
+			}
+		}
+		// Nothing should have been written.
+		roles, err := db.RBAC().ListRoles(ctx)
+		if err != nil {
+			t.Fatalf("list roles: %v", err)
+		}
+		if len(roles) != 0 {
+			t.Errorf("expected no roles to be written, got %d", len(roles))
+		}
+	})
+
+	t.Run("FailedCommitWritesNothing", func(t *testing.T) {
+		t.Parallel()
+		st := badgerdb.NewTestStorage(false)
+		t.Cleanup(func() { _ = st.Close() })
+		db := meshdb.NewFromStorage(st)
+		want := testRBACPolicy()
+		if err := db.RBAC().ImportRBAC(ctx, want, storage.RBACImportReplace); err != nil {
+			t.Fatalf("import policy: %v", err)
+		}
+		replacement := testRBACPolicy()
+		replacement.Roles[1].Name = "acl-reader"
+		failing := meshdb.NewFromStorage(failingBatchStorage{st})
+		if err := failing.RBAC().ImportRBAC(ctx, replacement, storage.RBACImportReplace); err == nil {
+			t.Fatal("expected import to fail")
+		}
+		got, err := db.RBAC().ExportRBAC(ctx)
+		if err != nil {
+			t.Fatalf("export policy: %v", err)
+		}
+		assertPolicyEqual(t, want, got)
+	})
+}
+
+// failingBatchStorage is storage whose batches fail to commit.
+type failingBatchStorage struct {
+	storage.MeshStorage
+}
+
+func (f failingBatchStorage) Batch() storage.Batch {
+	return failingBatch{f.MeshStorage.Batch()}
+}
+
+type failingBatch struct {
+	storage.Batch
+}
+
+func (failingBatch) Commit(context.Context) error {
+	return fmt.Errorf("commit failed")
+}
+
+func assertPolicyEqual(t *testing.T, want, got types.RBACPolicy) {
+	t.Helper()
+	sort.Slice(want.Roles, func(i, j int) bool { return want.Roles[i].GetName() < want.Roles[j].GetName() })
+	sort.Slice(got.Roles, func(i, j int) bool { return got.Roles[i].GetName() < got.Roles[j].GetName() })
+	if len(want.Roles) != len(got.Roles) {
+		t.Fatalf("expected %d roles, got %d", len(want.Roles), len(got.Roles))
+	}
+	for i := range want.Roles {
+		if !proto.Equal(want.Roles[i].Role, got.Roles[i].Role) {
+			t.Errorf("expected role %v, got %v", want.Roles[i].Role, got.Roles[i].Role)
+		}
+	}
+	if len(want.RoleBindings) != len(got.RoleBindings) {
+		t.Fatalf("expected %d rolebindings, got %d", len(want.RoleBindings), len(got.RoleBindings))
+	}
+	for i := range want.RoleBindings {
+		if !proto.Equal(want.RoleBindings[i].RoleBinding, got.RoleBindings[i].RoleBinding) {
+			t.Errorf("expected rolebinding %v, got %v", want.RoleBindings[i].RoleBinding, got.RoleBindings[i].RoleBinding)
+		}
+	}
+	if len(want.Groups) != len(got.Groups) {
+		t.Fatalf("expected %d groups, got %d", len(want.Groups), len(got.Groups))
+	}
+	for i := range want.Groups {
+		if !proto.Equal(want.Groups[i].Group, got.Groups[i].Group) {
+			t.Errorf("expected group %v, got %v", want.Groups[i].Group, got.Groups[i].Group)
+		}
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	t.Cleanup(func() { _ = db.Close() })
+
+	policy := types.RBACPolicy{
+		Roles: types.RolesList{
+			{Role: &v1.Role{
+				Name: "route-writer",
+				Rules: []*v1.Rule{{
+					Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_PUT, v1.RuleVerb_VERB_DELETE},
+					Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+				}},
+			}},
+			{Role: &v1.Role{
+				Name: "admin",
+				Rules: []*v1.Rule{{
+					Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_ALL},
+					Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ALL},
+				}},
+			}},
+			{Role: &v1.Role{
+				Name: "zone-route-writer",
+				Rules: []*v1.Rule{{
+					Verbs:         []v1.RuleVerb{v1.RuleVerb_VERB_PUT},
+					Resources:     []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+					ResourceNames: []string{"us-east/*"},
+				}},
+			}},
+		},
+		RoleBindings: []types.RoleBinding{
+			{RoleBinding: &v1.RoleBinding{
+				Name:     "route-writers",
+				Role:     "route-writer",
+				Subjects: []*v1.Subject{{Name: "node-a", Type: v1.SubjectType_SUBJECT_NODE}},
+			}},
+			{RoleBinding: &v1.RoleBinding{
+				Name:     "admins",
+				Role:     "admin",
+				Subjects: []*v1.Subject{{Name: "alice", Type: v1.SubjectType_SUBJECT_USER}},
+			}},
+			{RoleBinding: &v1.RoleBinding{
+				Name:     "zone-route-writers",
+				Role:     "zone-route-writer",
+				Subjects: []*v1.Subject{{Name: "node-c", Type: v1.SubjectType_SUBJECT_NODE}},
+			}},
+		},
+	}
+	if err := db.RBAC().ImportRBAC(ctx, policy, storage.RBACImportReplace); err != nil {
+		t.Fatalf("import policy: %v", err)
+	}
+
+	routes := types.RBACResource{Type: v1.RuleResource_RESOURCE_ROUTES}
+	acls := types.RBACResource{Type: v1.RuleResource_RESOURCE_NETWORK_ACLS}
+	zoneRoute := types.RBACResource{Type: v1.RuleResource_RESOURCE_ROUTES, Name: "us-east/route-a"}
+	tc := []struct {
+		name     string
+		identity types.NodeID
+		verb     v1.RuleVerb
+		resource types.RBACResource
+		opts     types.EvalOptions
+		allowed  bool
+	}{
+		{name: "NodeAllowedVerb", identity: "node-a", verb: v1.RuleVerb_VERB_PUT, resource: routes, allowed: true},
+		{name: "NodeDeniedVerb", identity: "node-a", verb: v1.RuleVerb_VERB_GET, resource: routes, allowed: false},
+		{name: "NodeDeniedResource", identity: "node-a", verb: v1.RuleVerb_VERB_PUT, resource: acls, allowed: false},
+		{name: "NodeDeniedNamedResource", identity: "node-a", verb: v1.RuleVerb_VERB_PUT, resource: types.RBACResource{Type: v1.RuleResource_RESOURCE_ROUTES, Name: "route"}, allowed: false},
+		{name: "UserAllowedAll", identity: "alice", verb: v1.RuleVerb_VERB_DELETE, resource: acls, allowed: true},
+		{name: "UserAllowedNamedResource", identity: "alice", verb: v1.RuleVerb_VERB_PUT, resource: types.RBACResource{Type: v1.RuleResource_RESOURCE_ROUTES, Name: "route"}, allowed: true},
+		{name: "UnboundIdentity", identity: "node-b", verb: v1.RuleVerb_VERB_PUT, resource: routes, allowed: false},
+		{name: "EmptyIdentity", identity: "", verb: v1.RuleVerb_VERB_PUT, resource: routes, allowed: false},
+		{name: "PrefixDeniedByDefault", identity: "node-c", verb: v1.RuleVerb_VERB_PUT, resource: zoneRoute, allowed: false},
+		{name: "PrefixAllowedWithWildcards", identity: "node-c", verb: v1.RuleVerb_VERB_PUT, resource: zoneRoute, opts: types.EvalOptions{AllowWildcardResourceNames: true}, allowed: true},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := db.RBAC().Enforce(ctx, tt.identity, tt.verb, tt.resource, tt.opts)
+			if tt.allowed && err != nil {
+				t.Fatalf("expected allowed, got %v", err)
+			}
+			if !tt.allowed && !errors.IsPermissionDenied(err) {
+				t.Fatalf("expected permission denied, got %v", err)
+			}
+		})
+	}
+
+	t.Run("CachesRoles", func(t *testing.T) {
+		t.Parallel()
+		db := meshdb.NewTestDB()
+		t.Cleanup(func() { _ = db.Close() })
+		if err := db.RBAC().ImportRBAC(ctx, policy, storage.RBACImportReplace); err != nil {
+			t.Fatalf("import policy: %v", err)
+		}
+		ctx := storage.WithRoleCache(ctx)
+		if err := db.RBAC().Enforce(ctx, "node-a", v1.RuleVerb_VERB_PUT, routes, types.EvalOptions{}); err != nil {
+			t.Fatalf("expected allowed, got %v", err)
+		}
+		// Removing the binding should not affect checks within the same request.
+		if err := db.RBAC().DeleteRoleBinding(ctx, "route-writers"); err != nil {
+			t.Fatalf("delete rolebinding: %v", err)
+		}
+		if err := db.RBAC().Enforce(ctx, "node-a", v1.RuleVerb_VERB_DELETE, routes, types.EvalOptions{}); err != nil {
+			t.Fatalf("expected cached roles to allow, got %v", err)
+		}
+		// A new request should see the change.
+		err := db.RBAC().Enforce(context.Background(), "node-a", v1.RuleVerb_VERB_DELETE, routes, types.EvalOptions{})
+		if !errors.IsPermissionDenied(err) {
+			t.Fatalf("expected permission denied, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// RecentJoinsPrefix is where the last join of each node is recorded.
+var RecentJoinsPrefix = types.RegistryPrefix.ForString("recent-joins")
+
+// PutRecentJoinInBatch queues recording the given join in the batch. The
+// record expires after ttl.
+func PutRecentJoinInBatch(batch Batch, join types.RecentJoin, ttl time.Duration) error {
+	data, err := json.Marshal(join)
+	if err != nil {
+		return fmt.Errorf("marshal recent join: %w", err)
+	}
+	batch.PutValue(RecentJoinsPrefix.For(join.ID.Bytes()), data, ttl)
+	return nil
+}
+
+// GetRecentJoin returns the last recorded join of the given node. False is
+// returned if there is no record of a join.
+func GetRecentJoin(ctx context.Context, st MeshStorage, id types.NodeID) (types.RecentJoin, bool, error) {
+	data, err := st.GetValue(ctx, RecentJoinsPrefix.For(id.Bytes()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return types.RecentJoin{}, false, nil
+		}
+		return types.RecentJoin{}, false, fmt.Errorf("get recent join: %w", err)
+	}
+	var join types.RecentJoin
+	if err := json.Unmarshal(data, &join); err != nil {
+		return types.RecentJoin{}, false, fmt.Errorf("unmarshal recent join: %w", err)
+	}
+	return join, true, nil
+}
+
+// DeleteRecentJoin removes the record of the last join of the given node.
+func DeleteRecentJoin(ctx context.Context, st MeshStorage, id types.NodeID) error {
+	err := st.Delete(ctx, RecentJoinsPrefix.For(id.Bytes()))
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete recent join: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Registry is a typed helper for storing protobuf messages by name under
+// a prefix in MeshStorage. Messages are written with the codec of the
+// storage and can be read regardless of the codec they were written with.
+type Registry[T proto.Message] struct {
+	st     MeshStorage
+	prefix types.StoragePrefix
+}
+
+// NewRegistry returns a new Registry for messages of type T stored under the given prefix.
+func NewRegistry[T proto.Message](st MeshStorage, prefix types.StoragePrefix) *Registry[T] {
+	return &Registry[T]{st: st, prefix: prefix}
+}
+
+// Prefix returns the prefix the registry stores messages under.
+func (r *Registry[T]) Prefix() types.StoragePrefix {
+	return r.prefix
+}
+
+// Key returns the storage key for the message with the given name.
+func (r *Registry[T]) Key(name string) []byte {
+	return r.prefix.ForString(name)
+}
+
+// Put creates or updates the message with the given name.
+func (r *Registry[T]) Put(ctx context.Context, name string, msg T) error {
+	data, err := MarshalValue(r.st, msg)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", name, err)
+	}
+	err = r.st.PutValue(ctx, r.Key(name), data, 0)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", name, err)
+	}
+	return nil
+}
+
+// PutInBatch queues creating or updating the message with the given name
+// in the batch.
+func (r *Registry[T]) PutInBatch(batch Batch, name string, msg T) error {
+	data, err := MarshalValue(r.st, msg)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", name, err)
+	}
+	batch.PutValue(r.Key(name), data, 0)
+	return nil
+}
+
+// DeleteInBatch queues removing the message with the given name in the batch.
+func (r *Registry[T]) DeleteInBatch(batch Batch, name string) {
+	batch.Delete(r.Key(name))
+}
+
+// Get returns the message with the given name. If the message does not
+// exist, an error wrapping errors.ErrKeyNotFound is returned.
+func (r *Registry[T]) Get(ctx context.Context, name string) (T, error) {
+	var out T
+	data, err := r.st.GetValue(ctx, r.Key(name))
+	if err != nil {
+		return out, fmt.Errorf("get %s: %w", name, err)
+	}
+	out, err = r.unmarshal(data)
+	if err != nil {
+		return out, fmt.Errorf("unmarshal %s: %w", name, err)
+	}
+	return out, nil
+}
+
+// Delete removes the message with the given name. It is not an error
+// if the message does not exist.
+func (r *Registry[T]) Delete(ctx context.Context, name string) error {
+	err := r.st.Delete(ctx, r.Key(name))
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+	return nil
+}
+
+// List returns all messages in the registry.
+func (r *Registry[T]) List(ctx context.Context) ([]T, error) {
+	out := make([]T, 0)
+	err := r.Iter(ctx, func(_ string, msg T) error {
+		out = append(out, msg)
+		return nil
+	})
+	return out, err
+}
+
+// Iter calls fn with the name and value of every message in the registry.
+func (r *Registry[T]) Iter(ctx context.Context, fn func(name string, msg T) error) error {
+	return r.st.IterPrefix(ctx, r.prefix, func(key, value []byte) error {
+		if bytes.Equal(key, r.prefix) {
+			return nil
+		}
+		name := string(r.prefix.TrimFrom(key))
+		msg, err := r.unmarshal(value)
+		if err != nil {
+			return fmt.Errorf("unmarshal %s: %w", name, err)
+		}
+		return fn(name, msg)
+	})
+}
+
+// IterAt calls fn with the name and value of every message in the registry as
+// it existed at the given storage revision.
+func (r *Registry[T]) IterAt(ctx context.Context, revision uint64, fn func(name string, msg T) error) error {
+	return r.st.IterPrefixAt(ctx, r.prefix, revision, func(key, value []byte) error {
+		if bytes.Equal(key, r.prefix) {
+			return nil
+		}
+		name := string(r.prefix.TrimFrom(key))
+		msg, err := r.unmarshal(value)
+		if err != nil {
+			return fmt.Errorf("unmarshal %s: %w", name, err)
+		}
+		return fn(name, msg)
+	})
+}
+
+func (r *Registry[T]) unmarshal(data []byte) (T, error) {
+	var zero T
+	msg := zero.ProtoReflect().New().Interface().(T)
+	if err := UnmarshalValue(data, msg); err != nil {
+		return zero, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	prefix := types.RegistryPrefix.ForString("registry-test")
+
+	newRegistry := func(t *testing.T) (storage.MeshStorage, *storage.Registry[*v1.Route]) {
+		t.Helper()
+		st := badgerdb.NewTestStorage(false)
+		t.Cleanup(func() { _ = st.Close() })
+		return st, storage.NewRegistry[*v1.Route](st, prefix)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		t.Parallel()
+		_, reg := newRegistry(t)
+		routes := map[string]*v1.Route{
+			"route-a": {Name: "route-a", Node: "node-a", DestinationCIDRs: []string{"10.0.0.0/24"}},
+			"route-b": {Name: "route-b", Node: "node-b", DestinationCIDRs: []string{"10.0.1.0/24"}},
+		}
+		for name, rt := range routes {
+			if err := reg.Put(ctx, name, rt); err != nil {
+				t.Fatalf("put %s: %v", name, err)
+			}
+		}
+		for name, want := range routes {
+			got, err := reg.Get(ctx, name)
+			if err != nil {
+				t.Fatalf("get %s: %v", name, err)
+			}
+			if !proto.Equal(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		}
+		list, err := reg.List(ctx)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(list) != len(routes) {
+			t.Fatalf("expected %d routes, got %d", len(routes), len(list))
+		}
+		seen := map[string]bool{}
+		err = reg.Iter(ctx, func(name string, rt *v1.Route) error {
+			if name != rt.GetName() {
+				t.Errorf("expected name %q, got %q", rt.GetName(), name)
+			}
+			seen[name] = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("iter: %v", err)
+		}
+		if len(seen) != len(routes) {
+			t.Errorf("expected to iterate %d routes, got %d", len(routes), len(seen))
+		}
+		if err := reg.Delete(ctx, "route-a"); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if _, err := reg.Get(ctx, "route-a"); !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		_, reg := newRegistry(t)
+		_, err := reg.Get(ctx, "missing")
+		if !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+		// Deleting a missing key should not error.
+		if err := reg.Delete(ctx, "missing"); err != nil {
+			t.Errorf("expected no error deleting missing key, got %v", err)
+		}
+		list, err := reg.List(ctx)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(list) != 0 {
+			t.Errorf("expected empty list, got %d items", len(list))
+		}
+	})
+
+	t.Run("MarshalError", func(t *testing.T) {
+		t.Parallel()
+		st, reg := newRegistry(t)
+		// Invalid UTF-8 in a string field cannot be marshaled.
+		err := reg.Put(ctx, "invalid", &v1.Route{Name: "invalid", Node: "\xff"})
+		if err == nil {
+			t.Fatal("expected marshal error")
+		}
+		if _, err := st.GetValue(ctx, reg.Key("invalid")); !errors.IsKeyNotFound(err) {
+			t.Errorf("expected nothing to be written, got %v", err)
+		}
+	})
+
+	t.Run("UnmarshalError", func(t *testing.T) {
+		t.Parallel()
+		st, reg := newRegistry(t)
+		if err := st.PutValue(ctx, reg.Key("garbage"), []byte("not json"), 0); err != nil {
+			t.Fatalf("put raw value: %v", err)
+		}
+		if _, err := reg.Get(ctx, "garbage"); err == nil || errors.IsKeyNotFound(err) {
+			t.Errorf("expected unmarshal error, got %v", err)
+		}
+		if _, err := reg.List(ctx); err == nil {
+			t.Error("expected unmarshal error from list")
+		}
+	})
+}
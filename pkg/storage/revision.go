@@ -0,0 +1,123 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// NewRevisionStorage returns a read-only view of the storage as it was at the
+// given revision. Every read is served with IterPrefixAt, so a view stops
+// working with ErrInvalidRevision once the storage no longer retains the
+// revision. Closing the view does not close the underlying storage.
+func NewRevisionStorage(st MeshStorage, revision uint64) MeshStorage {
+	return &revisionStorage{st: st, revision: revision}
+}
+
+// AtCurrentRevision returns a read-only view of the storage at its current
+// revision, so that several reads observe the same state. Storage that does
+// not support revisions is returned unchanged.
+func AtCurrentRevision(ctx context.Context, st MeshStorage) (MeshStorage, error) {
+	rev, err := st.Revision(ctx)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotImplemented) {
+			return st, nil
+		}
+		return nil, fmt.Errorf("get current revision: %w", err)
+	}
+	return NewRevisionStorage(st, rev), nil
+}
+
+type revisionStorage struct {
+	st       MeshStorage
+	revision uint64
+}
+
+// Close is a no-op. The underlying storage is owned by the caller.
+func (r *revisionStorage) Close() error {
+	return nil
+}
+
+// ValueCodec returns the codec of the underlying storage.
+func (r *revisionStorage) ValueCodec() Codec {
+	return ValueCodecFor(r.st)
+}
+
+func (r *revisionStorage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	var value []byte
+	var found bool
+	err := r.st.IterPrefixAt(ctx, key, r.revision, func(k, v []byte) error {
+		// Keys are iterated in order, so the key itself comes first if it exists.
+		value, found = v, bytes.Equal(k, key)
+		return ErrStopIteration
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.NewKeyNotFoundError(key)
+	}
+	return value, nil
+}
+
+func (r *revisionStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	return errors.ErrReadOnly
+}
+
+func (r *revisionStorage) Delete(ctx context.Context, key []byte) error {
+	return errors.ErrReadOnly
+}
+
+func (r *revisionStorage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := r.st.IterPrefixAt(ctx, prefix, r.revision, func(key, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *revisionStorage) IterPrefix(ctx context.Context, prefix []byte, fn PrefixIterator) error {
+	return r.st.IterPrefixAt(ctx, prefix, r.revision, fn)
+}
+
+func (r *revisionStorage) Revision(ctx context.Context) (uint64, error) {
+	return r.revision, nil
+}
+
+func (r *revisionStorage) IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn PrefixIterator) error {
+	if revision != r.revision {
+		return fmt.Errorf("%w: view is at revision %d", errors.ErrInvalidRevision, r.revision)
+	}
+	return r.st.IterPrefixAt(ctx, prefix, revision, fn)
+}
+
+func (r *revisionStorage) Subscribe(ctx context.Context, prefix []byte, fn KVSubscribeFunc) (context.CancelFunc, error) {
+	return func() {}, errors.ErrNotImplemented
+}
+
+func (r *revisionStorage) Batch() Batch {
+	return NewSequentialBatch(r)
+}
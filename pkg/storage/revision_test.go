@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestRevisionStorage(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+
+	put := func(key, value string) {
+		t.Helper()
+		if err := st.PutValue(ctx, []byte(key), []byte(value), 0); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	put("/registry/view/a", "a")
+	put("/registry/view/ab", "ab")
+	view, err := storage.AtCurrentRevision(ctx, st)
+	if err != nil {
+		t.Fatalf("get current revision: %v", err)
+	}
+	put("/registry/view/a", "updated")
+	put("/registry/view/b", "b")
+
+	value, err := view.GetValue(ctx, []byte("/registry/view/a"))
+	if err != nil {
+		t.Fatalf("get value: %v", err)
+	}
+	if string(value) != "a" {
+		t.Errorf("expected the value at the revision, got %q", value)
+	}
+	if _, err := view.GetValue(ctx, []byte("/registry/view/b")); !errors.IsKeyNotFound(err) {
+		t.Errorf("expected a key written after the revision to be missing, got %v", err)
+	}
+	keys, err := view.ListKeys(ctx, []byte("/registry/view/"))
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("expected 2 keys at the revision, got %d", len(keys))
+	}
+	if err := view.PutValue(ctx, []byte("/registry/view/c"), []byte("c"), 0); !errors.Is(err, errors.ErrReadOnly) {
+		t.Errorf("expected writes to the view to fail, got %v", err)
+	}
+
+	// Once enough newer revisions are taken, the view's revision is no
+	// longer retained and reads fail instead of returning newer data.
+	for i := 0; i <= badgerdb.RevisionHistory; i++ {
+		put("/registry/view/b", fmt.Sprint(i))
+		if _, err := st.Revision(ctx); err != nil {
+			t.Fatalf("get revision: %v", err)
+		}
+	}
+	if _, err := view.GetValue(ctx, []byte("/registry/view/a")); !errors.Is(err, errors.ErrInvalidRevision) {
+		t.Errorf("expected an evicted revision to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// RouteNodesPrefix is where Routes are indexed by the node advertising them.
+// Keys are the node ID followed by the route name, and values are the number
+// of destination CIDRs the route advertises. This lets the routes advertised
+// by a node be counted without reading every route.
+var RouteNodesPrefix = types.RegistryPrefix.For([]byte("route-nodes"))
+
+func init() {
+	RegisterMigration(Migration{
+		Version: 1,
+		Name:    "index routes by node",
+		Migrate: migrateRouteNodesIndex,
+	})
+}
+
+// routeNodesKey returns the index key for the given node and route name.
+func routeNodesKey(nodeID string, name string) []byte {
+	return RouteNodesPrefix.For([]byte(nodeID)).For([]byte(name))
+}
+
+// routeNodesPrefixFor returns the index prefix for the routes of the given node.
+func routeNodesPrefixFor(nodeID string) []byte {
+	return append(RouteNodesPrefix.For([]byte(nodeID)), '/')
+}
+
+// IndexRouteInBatch adds the given route to the node index in the batch.
+func IndexRouteInBatch(batch Batch, route types.Route) {
+	batch.PutValue(routeNodesKey(route.GetNode(), route.GetName()), []byte(strconv.Itoa(len(route.GetDestinationCIDRs()))), 0)
+}
+
+// UnindexRouteInBatch removes the route with the given name from the index
+// of the given node in the batch.
+func UnindexRouteInBatch(batch Batch, nodeID string, name string) {
+	batch.Delete(routeNodesKey(nodeID, name))
+}
+
+// CountRoutesByNode returns the number of destination CIDRs advertised by each
+// of the routes of the given node, keyed by route name.
+func CountRoutesByNode(ctx context.Context, st MeshStorage, nodeID types.NodeID) (map[string]int, error) {
+	prefix := routeNodesPrefixFor(nodeID.String())
+	counts := make(map[string]int)
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		name := string(bytes.TrimPrefix(key, prefix))
+		count, err := strconv.Atoi(string(value))
+		if err != nil {
+			return fmt.Errorf("parse route count for %s: %w", name, err)
+		}
+		counts[name] = count
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("count routes for node %q: %w", nodeID, err)
+	}
+	return counts, nil
+}
+
+// CheckRouteLimit returns a RouteLimitError if putting the given route would
+// cause its node to advertise more than limit destination CIDRs across all of
+// its routes. The route replaces any existing route with the same name. A limit
+// of zero or less disables the check.
+func CheckRouteLimit(ctx context.Context, st MeshStorage, route types.Route, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	counts, err := CountRoutesByNode(ctx, st, types.NodeID(route.GetNode()))
+	if err != nil {
+		return err
+	}
+	total := len(route.GetDestinationCIDRs())
+	for name, count := range counts {
+		if name != route.GetName() {
+			total += count
+		}
+	}
+	if total > limit {
+		return &errors.RouteLimitError{NodeID: route.GetNode(), Limit: limit, Count: total}
+	}
+	return nil
+}
+
+// migrateRouteNodesIndex indexes all existing routes by their node.
+func migrateRouteNodesIndex(ctx context.Context, st MeshStorage) error {
+	routes := NewRegistry[*v1.Route](st, RoutesPrefix)
+	batch := st.Batch()
+	err := routes.Iter(ctx, func(_ string, route *v1.Route) error {
+		IndexRouteInBatch(batch, types.Route{Route: route})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("list routes: %w", err)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	return batch.Commit(ctx)
+}
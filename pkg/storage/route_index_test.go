@@ -0,0 +1,144 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestCheckRouteLimit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	nw := meshdb.NewFromStorage(st).Networking()
+
+	newRoute := func(name, node string, cidrs ...string) types.Route {
+		return types.Route{Route: &v1.Route{Name: name, Node: node, DestinationCIDRs: cidrs}}
+	}
+	put := func(route types.Route) {
+		t.Helper()
+		if err := nw.PutRoute(ctx, route); err != nil {
+			t.Fatalf("put route: %v", err)
+		}
+	}
+	expectCount := func(node string, want int) {
+		t.Helper()
+		counts, err := storage.CountRoutesByNode(ctx, st, types.NodeID(node))
+		if err != nil {
+			t.Fatalf("count routes: %v", err)
+		}
+		var got int
+		for _, count := range counts {
+			got += count
+		}
+		if got != want {
+			t.Fatalf("expected node %s to advertise %d routes, got %d", node, want, got)
+		}
+	}
+	const limit = 3
+
+	put(newRoute("route-a", "node-a", "10.0.0.0/24", "10.0.1.0/24"))
+	expectCount("node-a", 2)
+
+	// A route that brings the node up to the limit is allowed.
+	atLimit := newRoute("route-b", "node-a", "10.0.2.0/24")
+	if err := storage.CheckRouteLimit(ctx, st, atLimit, limit); err != nil {
+		t.Fatalf("expected route at the limit to be allowed, got %v", err)
+	}
+	put(atLimit)
+	expectCount("node-a", 3)
+
+	// One more route puts the node over the limit.
+	err := storage.CheckRouteLimit(ctx, st, newRoute("route-c", "node-a", "10.0.3.0/24"), limit)
+	if !errors.IsRouteLimitExceeded(err) {
+		t.Fatalf("expected route limit error, got %v", err)
+	}
+	var limitErr *errors.RouteLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected a RouteLimitError, got %T", err)
+	}
+	if limitErr.NodeID != "node-a" || limitErr.Limit != limit || limitErr.Count != 4 {
+		t.Fatalf("unexpected route limit error: %+v", limitErr)
+	}
+
+	// Replacing a route only counts the new destinations.
+	if err := storage.CheckRouteLimit(ctx, st, newRoute("route-a", "node-a", "10.0.0.0/24", "10.0.3.0/24"), limit); err != nil {
+		t.Fatalf("expected replacing a route to be allowed, got %v", err)
+	}
+	if err := storage.CheckRouteLimit(ctx, st, newRoute("route-a", "node-a", "10.0.0.0/24", "10.0.3.0/24", "10.0.4.0/24"), limit); !errors.IsRouteLimitExceeded(err) {
+		t.Fatalf("expected route limit error, got %v", err)
+	}
+
+	// Other nodes are counted separately.
+	if err := storage.CheckRouteLimit(ctx, st, newRoute("route-c", "node-b", "10.1.0.0/24", "10.1.1.0/24", "10.1.2.0/24"), limit); err != nil {
+		t.Fatalf("expected other node to be allowed, got %v", err)
+	}
+
+	// A zero limit disables the check.
+	if err := storage.CheckRouteLimit(ctx, st, newRoute("route-c", "node-a", "10.0.3.0/24"), 0); err != nil {
+		t.Fatalf("expected no limit, got %v", err)
+	}
+
+	// Moving and deleting routes keeps the index up to date.
+	put(newRoute("route-a", "node-b", "10.0.0.0/24", "10.0.1.0/24"))
+	expectCount("node-a", 1)
+	expectCount("node-b", 2)
+	if err := nw.DeleteRoute(ctx, "route-b"); err != nil {
+		t.Fatalf("delete route: %v", err)
+	}
+	expectCount("node-a", 0)
+}
+
+func TestRouteNodesMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+
+	// Write routes the way they were stored before they were indexed.
+	routes := storage.NewRegistry[*v1.Route](st, storage.RoutesPrefix)
+	for name, cidrs := range map[string][]string{
+		"route-a": {"10.0.0.0/24", "10.0.1.0/24"},
+		"route-b": {"10.0.2.0/24"},
+	} {
+		err := routes.Put(ctx, name, &v1.Route{Name: name, Node: "node-a", DestinationCIDRs: cidrs})
+		if err != nil {
+			t.Fatalf("put route: %v", err)
+		}
+	}
+	if _, err := storage.RegisteredMigrations().Run(ctx, st, state.New(st), storage.MigrateOptions{}); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	counts, err := storage.CountRoutesByNode(ctx, st, "node-a")
+	if err != nil {
+		t.Fatalf("count routes: %v", err)
+	}
+	if len(counts) != 2 || counts["route-a"] != 2 || counts["route-b"] != 1 {
+		t.Fatalf("unexpected route counts after migration: %v", counts)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// VerifyRouteSignature verifies that the route was signed by the identity key
+// of the given node and that the node is the one advertising the route. An empty
+// node is treated as unknown. All failures wrap ErrInvalidRouteSignature.
+func VerifyRouteSignature(route types.Route, node types.MeshNode) error {
+	if node.GetId() == "" {
+		return fmt.Errorf("%w: node %q not found", errors.ErrInvalidRouteSignature, route.GetNode())
+	}
+	if node.GetId() != route.GetNode() {
+		return fmt.Errorf("%w: route %q is advertised by %q not %q", errors.ErrInvalidRouteSignature, route.GetName(), route.GetNode(), node.GetId())
+	}
+	key, err := node.DecodePublicKey()
+	if err != nil {
+		return fmt.Errorf("%w: decode public key of node %q: %w", errors.ErrInvalidRouteSignature, node.GetId(), err)
+	}
+	if err := route.VerifySignature(key); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidRouteSignature, err)
+	}
+	return nil
+}
+
+// CheckRouteSigned returns an ErrInvalidRouteSignature error if signed routes
+// are required and the given route is unsigned.
+func CheckRouteSigned(route types.Route, require bool) error {
+	if require && !route.IsSigned() {
+		return fmt.Errorf("%w: route %q must be signed by node %q", errors.ErrInvalidRouteSignature, route.GetName(), route.GetNode())
+	}
+	return nil
+}
+
+// CheckRouteSignatureVersion returns an ErrInvalidRouteSignature error if the
+// signature of the given route is not newer than the last one stored for the
+// route name. Putting the currently stored signature again is allowed.
+// Unsigned routes are not checked.
+func CheckRouteSignatureVersion(ctx context.Context, st MeshStorage, route types.Route) error {
+	if !route.IsSigned() {
+		return nil
+	}
+	latest, err := NewRegistry[*wrapperspb.UInt64Value](st, RouteSignatureVersionsPrefix).Get(ctx, route.GetName())
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("get route signature version: %w", err)
+	}
+	if route.SignatureVersion() > latest.GetValue() {
+		return nil
+	}
+	stored, err := NewRegistry[*wrapperspb.BytesValue](st, RouteSignaturesPrefix).Get(ctx, route.GetName())
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("get route signature: %w", err)
+	}
+	if bytes.Equal(stored.GetValue(), route.Signature) {
+		return nil
+	}
+	return fmt.Errorf("%w: route %q signature version %d is not newer than %d", errors.ErrInvalidRouteSignature, route.GetName(), route.SignatureVersion(), latest.GetValue())
+}
+
+// PutRouteSignatureVersionInBatch records the signature version of the given
+// route in the batch. Unsigned routes are ignored.
+func PutRouteSignatureVersionInBatch(st MeshStorage, batch Batch, route types.Route) error {
+	if !route.IsSigned() {
+		return nil
+	}
+	return NewRegistry[*wrapperspb.UInt64Value](st, RouteSignatureVersionsPrefix).PutInBatch(batch, route.GetName(), wrapperspb.UInt64(route.SignatureVersion()))
+}
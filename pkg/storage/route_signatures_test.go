@@ -0,0 +1,172 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestPutSignedRoute(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+	db := meshdb.NewFromStorage(st)
+
+	keys := map[string]crypto.PrivateKey{}
+	for _, id := range []string{"node-a", "node-b"} {
+		keys[id] = crypto.MustGenerateKey()
+		encoded, err := keys[id].PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PublicKey: encoded}}); err != nil {
+			t.Fatalf("put node %s: %v", id, err)
+		}
+	}
+	newRoute := func(name, node string) types.Route {
+		return types.Route{Route: &v1.Route{Name: name, Node: node, DestinationCIDRs: []string{"10.0.0.0/24"}}}
+	}
+	sign := func(route types.Route, signer string) types.Route {
+		t.Helper()
+		if err := route.Sign(keys[signer]); err != nil {
+			t.Fatalf("sign route: %v", err)
+		}
+		return route
+	}
+
+	t.Run("ValidSignature", func(t *testing.T) {
+		route := sign(newRoute("signed", "node-a"), "node-a")
+		if err := db.Networking().PutRoute(ctx, route); err != nil {
+			t.Fatalf("expected signed route to be accepted, got %v", err)
+		}
+		got, err := db.Networking().GetRoute(ctx, "signed")
+		if err != nil {
+			t.Fatalf("get route: %v", err)
+		}
+		if !bytes.Equal(got.Signature, route.Signature) {
+			t.Fatalf("expected the signature to be stored with the route, got %x", got.Signature)
+		}
+		routes, err := db.Networking().ListRoutes(ctx)
+		if err != nil {
+			t.Fatalf("list routes: %v", err)
+		}
+		for _, rt := range routes {
+			if rt.GetName() == "signed" && !bytes.Equal(rt.Signature, route.Signature) {
+				t.Fatalf("expected the signature to be listed with the route, got %x", rt.Signature)
+			}
+		}
+		// An unsigned update that leaves the route unchanged keeps the signature.
+		if err := db.Networking().PutRoute(ctx, newRoute("signed", "node-a")); err != nil {
+			t.Fatalf("put route: %v", err)
+		}
+		got, err = db.Networking().GetRoute(ctx, "signed")
+		if err != nil {
+			t.Fatalf("get route: %v", err)
+		}
+		if !bytes.Equal(got.Signature, route.Signature) {
+			t.Fatalf("expected the signature to be kept, got %x", got.Signature)
+		}
+		// An unsigned update that changes the route is rejected.
+		changed := newRoute("signed", "node-a")
+		changed.DestinationCIDRs = []string{"0.0.0.0/0"}
+		if err := db.Networking().PutRoute(ctx, changed); !errors.IsInvalidRouteSignature(err) {
+			t.Fatalf("expected unsigned change to be rejected, got %v", err)
+		}
+		// A newly signed update replaces the signature.
+		changed = sign(changed, "node-a")
+		if err := db.Networking().PutRoute(ctx, changed); err != nil {
+			t.Fatalf("expected re-signed route to be accepted, got %v", err)
+		}
+		got, err = db.Networking().GetRoute(ctx, "signed")
+		if err != nil {
+			t.Fatalf("get route: %v", err)
+		}
+		if !bytes.Equal(got.Signature, changed.Signature) || !slices.Equal(got.GetDestinationCIDRs(), []string{"0.0.0.0/0"}) {
+			t.Fatalf("expected the re-signed route to be stored, got %v", got)
+		}
+	})
+
+	t.Run("ReplayedSignature", func(t *testing.T) {
+		first := sign(newRoute("replayed", "node-a"), "node-a")
+		if err := db.Networking().PutRoute(ctx, first); err != nil {
+			t.Fatalf("put route: %v", err)
+		}
+		// Putting the stored signature again is allowed.
+		if err := db.Networking().PutRoute(ctx, first); err != nil {
+			t.Fatalf("expected the stored route to be put again, got %v", err)
+		}
+		second := newRoute("replayed", "node-a")
+		second.DestinationCIDRs = []string{"10.1.0.0/24"}
+		second = sign(second, "node-a")
+		if err := db.Networking().PutRoute(ctx, second); err != nil {
+			t.Fatalf("put route: %v", err)
+		}
+		// The first signature is older than the stored one.
+		if err := db.Networking().PutRoute(ctx, first); !errors.IsInvalidRouteSignature(err) {
+			t.Fatalf("expected replayed route to be rejected, got %v", err)
+		}
+		// Nor can it be replayed once the route is deleted.
+		if err := db.Networking().DeleteRoute(ctx, "replayed"); err != nil {
+			t.Fatalf("delete route: %v", err)
+		}
+		if err := db.Networking().PutRoute(ctx, first); !errors.IsInvalidRouteSignature(err) {
+			t.Fatalf("expected replayed route to be rejected after delete, got %v", err)
+		}
+		if _, err := db.Networking().GetRoute(ctx, "replayed"); !errors.IsRouteNotFound(err) {
+			t.Fatalf("expected replayed route not to be stored, got %v", err)
+		}
+	})
+
+	t.Run("ForgedSignature", func(t *testing.T) {
+		// node-b signs a route claiming to be advertised by node-a.
+		forged := sign(newRoute("forged", "node-a"), "node-b")
+		err := db.Networking().PutRoute(ctx, forged)
+		if !errors.IsInvalidRouteSignature(err) {
+			t.Fatalf("expected forged route to be rejected, got %v", err)
+		}
+		// A route signed by its node and then altered is rejected.
+		tampered := sign(newRoute("tampered", "node-a"), "node-a")
+		tampered.DestinationCIDRs = []string{"0.0.0.0/0"}
+		err = db.Networking().PutRoute(ctx, tampered)
+		if !errors.IsInvalidRouteSignature(err) {
+			t.Fatalf("expected tampered route to be rejected, got %v", err)
+		}
+		// A signed route for a node that does not exist is rejected.
+		unknown := sign(newRoute("unknown", "node-c"), "node-a")
+		err = db.Networking().PutRoute(ctx, unknown)
+		if !errors.IsInvalidRouteSignature(err) {
+			t.Fatalf("expected route for an unknown node to be rejected, got %v", err)
+		}
+		for _, name := range []string{"forged", "tampered", "unknown"} {
+			if _, err := db.Networking().GetRoute(ctx, name); !errors.IsRouteNotFound(err) {
+				t.Fatalf("expected rejected route %s not to be stored, got %v", name, err)
+			}
+		}
+	})
+}
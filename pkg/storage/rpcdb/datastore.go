@@ -30,6 +30,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/state"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -148,6 +149,20 @@ func (p *KVStorage) IterPrefix(ctx context.Context, prefix []byte, fn storage.Pr
 	return nil
 }
 
+func (p *KVStorage) Revision(ctx context.Context) (uint64, error) {
+	return 0, errors.ErrNotImplemented
+}
+
+func (p *KVStorage) IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn storage.PrefixIterator) error {
+	return errors.ErrNotImplemented
+}
+
+// Batch returns a new batch. Operations are committed one at a time, so the
+// batch is not atomic.
+func (p *KVStorage) Batch() storage.Batch {
+	return storage.NewSequentialBatch(p)
+}
+
 func (p *KVStorage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) (context.CancelFunc, error) {
 	return func() {}, errors.ErrNotStorageNode
 }
@@ -333,6 +348,28 @@ func (g *GraphStore) Subscribe(ctx context.Context, fn storage.PeerSubscribeFunc
 	return func() {}, errors.ErrNotStorageNode
 }
 
+func (g *GraphStore) CreateNode(ctx context.Context, node types.MeshNode) error {
+	return errors.ErrNotStorageNode
+}
+
+func (g *GraphStore) PutHeartbeat(ctx context.Context, id types.NodeID, at time.Time) error {
+	return errors.ErrNotStorageNode
+}
+
+func (g *GraphStore) ListHeartbeats(ctx context.Context) (map[types.NodeID]time.Time, error) {
+	out := make(map[types.NodeID]time.Time)
+	kv := &KVStorage{g.Querier}
+	err := kv.IterPrefix(ctx, storage.HeartbeatsPrefix, func(key, value []byte) error {
+		id, at, err := storage.DecodeHeartbeat(key, value)
+		if err != nil {
+			return err
+		}
+		out[id] = at
+		return nil
+	})
+	return out, err
+}
+
 // RBACStore implements a mesh rbac store over a plugin query stream.
 type RBACStore struct {
 	*RPCDataStore
@@ -620,6 +657,18 @@ func (r *RBACStore) ListUserRoles(ctx context.Context, user types.NodeID) (types
 	return r.ListNodeRoles(ctx, user)
 }
 
+func (r *RBACStore) Enforce(ctx context.Context, identity types.NodeID, verb v1.RuleVerb, resource types.RBACResource, opts types.EvalOptions) error {
+	return storage.Enforce(ctx, r, identity, verb, resource, opts)
+}
+
+func (r *RBACStore) ExportRBAC(ctx context.Context) (types.RBACPolicy, error) {
+	return storage.ExportRBAC(ctx, r)
+}
+
+func (r *RBACStore) ImportRBAC(ctx context.Context, policy types.RBACPolicy, mode storage.RBACImportMode) error {
+	return storage.ImportRBAC(ctx, r, (&KVStorage{r.Querier}).Batch(), policy, mode)
+}
+
 // MeshStateStore implements a mesh state store over a plugin query stream.
 type MeshStateStore struct {
 	*RPCDataStore
@@ -629,6 +678,39 @@ func (st *MeshStateStore) SetMeshState(ctx context.Context, state types.NetworkS
 	return errors.ErrNotStorageNode
 }
 
+func (st *MeshStateStore) SetLeader(ctx context.Context, leader types.StoragePeer) error {
+	return errors.ErrNotStorageNode
+}
+
+func (st *MeshStateStore) GetLeader(ctx context.Context) (types.StoragePeer, error) {
+	kv := &KVStorage{st.Querier}
+	data, err := kv.GetValue(ctx, state.LeaderKey)
+	if err != nil {
+		return types.StoragePeer{}, err
+	}
+	var leader v1.StoragePeer
+	if err := storage.UnmarshalValue(data, &leader); err != nil {
+		return types.StoragePeer{}, err
+	}
+	return types.StoragePeer{StoragePeer: &leader}, nil
+}
+
+func (st *MeshStateStore) SetSchemaVersion(ctx context.Context, version uint64) error {
+	return errors.ErrNotStorageNode
+}
+
+func (st *MeshStateStore) GetSchemaVersion(ctx context.Context) (uint64, error) {
+	kv := &KVStorage{st.Querier}
+	data, err := kv.GetValue(ctx, state.SchemaVersionKey)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseUint(string(data), 10, 64)
+}
+
 func (st *MeshStateStore) GetMeshState(ctx context.Context) (types.NetworkState, error) {
 	var state types.NetworkState
 	req := &v1.QueryRequest{
@@ -854,3 +936,13 @@ func (nw *NetworkingStore) ListRoutes(ctx context.Context) (types.Routes, error)
 	}
 	return out, nil
 }
+
+func (nw *NetworkingStore) ResolveRoute(ctx context.Context, addr netip.Addr) (*v1.Route, string, error) {
+	return storage.ResolveRoute(ctx, nw, nw.RPCDataStore.RBAC(), storage.NewGraphLabelResolver(nw.RPCDataStore.GraphStore()), addr)
+}
+
+// ListOrphanedRoutes returns all routes whose node no longer exists in
+// the mesh.
+func (nw *NetworkingStore) ListOrphanedRoutes(ctx context.Context) (types.Routes, error) {
+	return storage.ListOrphanedRoutes(ctx, nw, nw.RPCDataStore.GraphStore())
+}
@@ -36,8 +36,21 @@ var (
 	ErrInvalidArgument = fmt.Errorf("invalid argument")
 )
 
+// Options are options for serving storage queries.
+type Options struct {
+	// RequireSignedRoutes rejects routes put through queries that are not
+	// signed by the node advertising them.
+	RequireSignedRoutes bool
+}
+
 // ServeQuery serves a storage query given a database and a query request.
 func ServeQuery(ctx context.Context, db storage.Provider, req *v1.QueryRequest) *v1.QueryResponse {
+	return ServeQueryWithOptions(ctx, db, req, Options{})
+}
+
+// ServeQueryWithOptions serves a storage query given a database, a query
+// request and the options to serve it with.
+func ServeQueryWithOptions(ctx context.Context, db storage.Provider, req *v1.QueryRequest, opts Options) *v1.QueryResponse {
 	query, err := types.ParseStorageQuery(req)
 	if err != nil {
 		return &v1.QueryResponse{
@@ -50,7 +63,7 @@ func ServeQuery(ctx context.Context, db storage.Provider, req *v1.QueryRequest)
 	case v1.QueryRequest_LIST:
 		return doListQuery(ctx, db, query)
 	case v1.QueryRequest_PUT:
-		return doPutQuery(ctx, db, query)
+		return doPutQuery(ctx, db, query, opts)
 	case v1.QueryRequest_DELETE:
 		return doDeleteQuery(ctx, db, query)
 	default:
@@ -514,7 +527,7 @@ func doListQuery(ctx context.Context, db storage.Provider, req types.StorageQuer
 	return
 }
 
-func doPutQuery(ctx context.Context, db storage.Provider, req types.StorageQuery) (res *v1.QueryResponse) {
+func doPutQuery(ctx context.Context, db storage.Provider, req types.StorageQuery, opts Options) (res *v1.QueryResponse) {
 	res = &v1.QueryResponse{}
 	switch req.GetType() {
 	case v1.QueryRequest_VALUE:
@@ -560,6 +573,11 @@ func doPutQuery(ctx context.Context, db storage.Provider, req types.StorageQuery
 			res.Error = err.Error()
 			return
 		}
+		err = storage.CheckRouteSigned(route, opts.RequireSignedRoutes)
+		if err != nil {
+			res.Error = err.Error()
+			return
+		}
 		err = db.MeshDB().Networking().PutRoute(ctx, route)
 		if err != nil {
 			res.Error = err.Error()
@@ -39,6 +39,12 @@ type QueryClient interface {
 
 // Serve serves database operations over a plugin query stream.
 func Serve(ctx context.Context, db storage.Provider, cli QueryClient) error {
+	return ServeWithOptions(ctx, db, cli, Options{})
+}
+
+// ServeWithOptions serves database operations over a plugin query stream
+// using the given options.
+func ServeWithOptions(ctx context.Context, db storage.Provider, cli QueryClient, opts Options) error {
 	log := context.LoggerFrom(ctx)
 	defer func() {
 		err := cli.CloseSend()
@@ -62,7 +68,7 @@ func Serve(ctx context.Context, db storage.Provider, cli QueryClient) error {
 			"type", query.GetType().String(),
 			"query", query.GetQuery(),
 		)
-		err = cli.Send(ServeQuery(ctx, db, query))
+		err = cli.Send(ServeQueryWithOptions(ctx, db, query, opts))
 		if err != nil {
 			log.Error("Error sending query response", "error", err)
 			return err
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// StatsPrefixes are the registry prefixes broken out in StorageStats.
+var StatsPrefixes = []types.StoragePrefix{
+	NodesPrefix,
+	EdgesPrefix,
+	HeartbeatsPrefix,
+	RoutesPrefix,
+	RouteMetricsPrefix,
+	RouteExclusionsPrefix,
+	RouteSignaturesPrefix,
+	RouteSignatureVersionsPrefix,
+	NetworkACLsPrefix,
+	RolesPrefix,
+	RoleBindingsPrefix,
+	GroupsPrefix,
+}
+
+// PrefixStats are usage statistics for a single storage prefix.
+type PrefixStats struct {
+	// Keys is the number of keys stored under the prefix.
+	Keys uint64 `json:"keys"`
+	// Bytes is the combined size of the keys and values stored under the prefix.
+	Bytes uint64 `json:"bytes"`
+}
+
+// StorageStats are usage statistics for the mesh database.
+type StorageStats struct {
+	// Prefixes are the statistics for each of the StatsPrefixes.
+	Prefixes map[string]PrefixStats `json:"prefixes"`
+	// Other are the statistics for registry keys not under any of the StatsPrefixes.
+	Other PrefixStats `json:"other"`
+	// Total are the statistics for the entire registry.
+	Total PrefixStats `json:"total"`
+}
+
+// GetStorageStats computes usage statistics for the mesh registry by iterating
+// over all keys in the given storage.
+func GetStorageStats(ctx context.Context, st MeshStorage) (*StorageStats, error) {
+	stats := &StorageStats{
+		Prefixes: make(map[string]PrefixStats, len(StatsPrefixes)),
+	}
+	for _, prefix := range StatsPrefixes {
+		stats.Prefixes[prefix.String()] = PrefixStats{}
+	}
+	err := st.IterPrefix(ctx, types.RegistryPrefix, func(key, value []byte) error {
+		size := uint64(len(key) + len(value))
+		stats.Total.Keys++
+		stats.Total.Bytes += size
+		for _, prefix := range StatsPrefixes {
+			name := prefix.String()
+			if strings.HasPrefix(string(key), name+"/") {
+				ps := stats.Prefixes[name]
+				ps.Keys++
+				ps.Bytes += size
+				stats.Prefixes[name] = ps
+				return nil
+			}
+		}
+		stats.Other.Keys++
+		stats.Other.Bytes += size
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iterate registry: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestStorageStats(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+
+	want := map[string]int{
+		storage.NodesPrefix.String():        4,
+		storage.EdgesPrefix.String():        3,
+		storage.RoutesPrefix.String():       2,
+		storage.NetworkACLsPrefix.String():  1,
+		storage.RolesPrefix.String():        2,
+		storage.RoleBindingsPrefix.String(): 3,
+	}
+	var wantBytes uint64
+	for prefix, count := range want {
+		for i := 0; i < count; i++ {
+			key := types.StoragePrefix(prefix).ForString(fmt.Sprintf("key-%d", i))
+			value := []byte("value")
+			if err := st.PutValue(ctx, key, value, 0); err != nil {
+				t.Fatalf("put %s: %v", key, err)
+			}
+			wantBytes += uint64(len(key) + len(value))
+		}
+	}
+	// Keys outside the known prefixes are counted separately.
+	other := types.RegistryPrefix.ForString("something-else")
+	if err := st.PutValue(ctx, other, []byte("value"), 0); err != nil {
+		t.Fatalf("put %s: %v", other, err)
+	}
+
+	stats, err := storage.GetStorageStats(ctx, st)
+	if err != nil {
+		t.Fatalf("get storage stats: %v", err)
+	}
+	var total int
+	for _, prefix := range storage.StatsPrefixes {
+		got := stats.Prefixes[prefix.String()]
+		if got.Keys != uint64(want[prefix.String()]) {
+			t.Errorf("expected %d keys under %s, got %d", want[prefix.String()], prefix, got.Keys)
+		}
+		if want[prefix.String()] > 0 && got.Bytes == 0 {
+			t.Errorf("expected bytes to be reported for %s", prefix)
+		}
+		total += want[prefix.String()]
+	}
+	if stats.Other.Keys != 1 {
+		t.Errorf("expected 1 other key, got %d", stats.Other.Keys)
+	}
+	if stats.Total.Keys != uint64(total+1) {
+		t.Errorf("expected %d total keys, got %d", total+1, stats.Total.Keys)
+	}
+	if stats.Total.Bytes != wantBytes+uint64(len(other)+len("value")) {
+		t.Errorf("expected %d total bytes, got %d", wantBytes+uint64(len(other)+len("value")), stats.Total.Bytes)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NewTenantStorage returns a MeshStorage that namespaces all registry keys to
+// the given tenant. Reads and writes through the returned storage only observe
+// data belonging to the tenant, and keys are presented to callers as if the
+// tenant's registry were the only one. Closing the returned storage does not
+// close the underlying storage.
+func NewTenantStorage(st MeshStorage, tenant string) (MeshStorage, error) {
+	if !types.IsValidID(tenant) {
+		return nil, fmt.Errorf("invalid tenant name: %q", tenant)
+	}
+	return &tenantStorage{st: st, tenant: tenant}, nil
+}
+
+type tenantStorage struct {
+	st     MeshStorage
+	tenant string
+}
+
+func (t *tenantStorage) key(key []byte) []byte {
+	return types.ForTenant(t.tenant, key)
+}
+
+func (t *tenantStorage) trim(key []byte) []byte {
+	return types.TrimTenant(t.tenant, key)
+}
+
+// Close is a no-op. The underlying storage is owned by the caller.
+func (t *tenantStorage) Close() error {
+	return nil
+}
+
+// ValueCodec returns the codec of the underlying storage.
+func (t *tenantStorage) ValueCodec() Codec {
+	return ValueCodecFor(t.st)
+}
+
+func (t *tenantStorage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	return t.st.GetValue(ctx, t.key(key))
+}
+
+func (t *tenantStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	return t.st.PutValue(ctx, t.key(key), value, ttl)
+}
+
+func (t *tenantStorage) Delete(ctx context.Context, key []byte) error {
+	return t.st.Delete(ctx, t.key(key))
+}
+
+func (t *tenantStorage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	keys, err := t.st.ListKeys(ctx, t.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = t.trim(key)
+	}
+	return keys, nil
+}
+
+func (t *tenantStorage) IterPrefix(ctx context.Context, prefix []byte, fn PrefixIterator) error {
+	return t.st.IterPrefix(ctx, t.key(prefix), func(key, value []byte) error {
+		return fn(t.trim(key), value)
+	})
+}
+
+func (t *tenantStorage) Revision(ctx context.Context) (uint64, error) {
+	return t.st.Revision(ctx)
+}
+
+func (t *tenantStorage) IterPrefixAt(ctx context.Context, prefix []byte, revision uint64, fn PrefixIterator) error {
+	return t.st.IterPrefixAt(ctx, t.key(prefix), revision, func(key, value []byte) error {
+		return fn(t.trim(key), value)
+	})
+}
+
+func (t *tenantStorage) Subscribe(ctx context.Context, prefix []byte, fn KVSubscribeFunc) (context.CancelFunc, error) {
+	return t.st.Subscribe(ctx, t.key(prefix), func(key, value []byte) {
+		fn(t.trim(key), value)
+	})
+}
+
+func (t *tenantStorage) Batch() Batch {
+	return &tenantBatch{Batch: t.st.Batch(), t: t}
+}
+
+type tenantBatch struct {
+	Batch
+	t *tenantStorage
+}
+
+func (b *tenantBatch) PutValue(key, value []byte, ttl time.Duration) {
+	b.Batch.PutValue(b.t.key(key), value, ttl)
+}
+
+func (b *tenantBatch) Delete(key []byte) {
+	b.Batch.Delete(b.t.key(key))
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	t.Cleanup(func() { _ = st.Close() })
+
+	newTenant := func(name string) storage.MeshDB {
+		t.Helper()
+		db, err := meshdb.NewForTenant(st, name)
+		if err != nil {
+			t.Fatalf("new tenant %s: %v", name, err)
+		}
+		return db
+	}
+	tenantA := newTenant("tenant-a")
+	tenantB := newTenant("tenant-b")
+	untenanted := meshdb.NewFromStorage(st)
+
+	err := tenantA.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-a"}})
+	if err != nil {
+		t.Fatalf("put node: %v", err)
+	}
+	err = tenantA.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "route-a",
+		Node:             "node-a",
+		DestinationCIDRs: []string{"10.0.0.0/24"},
+	}})
+	if err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	err = tenantA.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "acl-a",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	err = tenantA.RBAC().PutRole(ctx, types.Role{Role: &v1.Role{
+		Name: "role-a",
+		Rules: []*v1.Rule{{
+			Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ALL},
+			Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_ALL},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("put role: %v", err)
+	}
+
+	// Tenant A sees its own data.
+	if _, err := tenantA.Peers().Get(ctx, "node-a"); err != nil {
+		t.Errorf("expected tenant A to see its node, got %v", err)
+	}
+	if routes, err := tenantA.Networking().ListRoutes(ctx); err != nil || len(routes) != 1 {
+		t.Errorf("expected tenant A to list 1 route, got %d (%v)", len(routes), err)
+	}
+
+	// Nobody else does.
+	for name, db := range map[string]storage.MeshDB{"tenant-b": tenantB, "untenanted": untenanted} {
+		if _, err := db.Peers().Get(ctx, "node-a"); !errors.IsNodeNotFound(err) {
+			t.Errorf("%s: expected node to be invisible, got %v", name, err)
+		}
+		nodes, err := db.Peers().List(ctx)
+		if err != nil {
+			t.Fatalf("%s: list nodes: %v", name, err)
+		}
+		if len(nodes) != 0 {
+			t.Errorf("%s: expected no nodes, got %d", name, len(nodes))
+		}
+		routes, err := db.Networking().ListRoutes(ctx)
+		if err != nil {
+			t.Fatalf("%s: list routes: %v", name, err)
+		}
+		if len(routes) != 0 {
+			t.Errorf("%s: expected no routes, got %d", name, len(routes))
+		}
+		acls, err := db.Networking().ListNetworkACLs(ctx)
+		if err != nil {
+			t.Fatalf("%s: list network acls: %v", name, err)
+		}
+		if len(acls) != 0 {
+			t.Errorf("%s: expected no network acls, got %d", name, len(acls))
+		}
+		roles, err := db.RBAC().ListRoles(ctx)
+		if err != nil {
+			t.Fatalf("%s: list roles: %v", name, err)
+		}
+		if len(roles) != 0 {
+			t.Errorf("%s: expected no roles, got %d", name, len(roles))
+		}
+	}
+
+	// Tenant names must be valid IDs.
+	if _, err := meshdb.NewForTenant(st, "tenant/a"); err == nil {
+		t.Error("expected error for invalid tenant name")
+	}
+}
@@ -101,5 +101,36 @@ func TestMeshStateStorageConformance(t *testing.T, builder NewMeshStateFunc) {
 				t.Fatalf("expected network %s, got %s", expected, gotcidr)
 			}
 		})
+		t.Run("GetSetLeader", func(t *testing.T) {
+			// Set leaders should eventually be returned.
+			for _, id := range []string{"leader-1", "leader-2"} {
+				err := st.SetLeader(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{
+					Id:            id,
+					Address:       "127.0.0.1:9000",
+					ClusterStatus: v1.ClusterStatus_CLUSTER_LEADER,
+				}})
+				if err != nil {
+					t.Fatalf("set leader: %v", err)
+				}
+				var got string
+				ok := Eventually[string](func() string {
+					leader, err := st.GetLeader(ctx)
+					if err != nil {
+						t.Logf("failed to get leader: %v", err)
+						return ""
+					}
+					got = leader.GetId()
+					return got
+				}).ShouldEqual(time.Second*15, time.Second, id)
+				if !ok {
+					t.Fatalf("expected leader %q, got %q", id, got)
+				}
+			}
+			// Leaders without an ID should be rejected.
+			err := st.SetLeader(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{}})
+			if err == nil {
+				t.Fatal("expected error setting empty leader")
+			}
+		})
 	})
 }
@@ -18,6 +18,9 @@ package testutil
 
 import (
 	"context"
+	"net/netip"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -197,6 +200,140 @@ func TestPeerStorageConformance(t *testing.T, builder NewPeersFunc) {
 			}
 		})
 
+		t.Run("GetNodeByAddress", func(t *testing.T) {
+			ctx := context.Background()
+			p := builder(t)
+			nodes := []types.MeshNode{
+				{MeshNode: &v1.MeshNode{
+					Id:          "node-a",
+					PublicKey:   mustGeneratePublicKey(t),
+					PrivateIPv4: "172.16.0.1/32",
+					PrivateIPv6: "fd00:dead:beef:1::/112",
+				}},
+				{MeshNode: &v1.MeshNode{
+					Id:          "node-b",
+					PublicKey:   mustGeneratePublicKey(t),
+					PrivateIPv4: "172.16.0.2/32",
+					PrivateIPv6: "fd00:dead:beef:2::/112",
+				}},
+			}
+			for _, node := range nodes {
+				if err := p.Put(ctx, node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tc := map[string]string{
+				// Exact IPv4 match.
+				"172.16.0.2": "node-b",
+				// Within the node's IPv6 prefix.
+				"fd00:dead:beef:1::1": "node-a",
+				// Within the node's /64 but outside its prefix.
+				"fd00:dead:beef:2:1:2:3:4": "node-b",
+			}
+			for addr, want := range tc {
+				got, err := p.GetByAddress(ctx, netip.MustParseAddr(addr))
+				if err != nil {
+					t.Fatalf("get by address %s: %v", addr, err)
+				}
+				if got.GetId() != want {
+					t.Fatalf("address %s: expected node %q, got %q", addr, want, got.GetId())
+				}
+			}
+			for _, addr := range []string{"172.16.0.3", "fd00:dead:beef:3::1"} {
+				_, err := p.GetByAddress(ctx, netip.MustParseAddr(addr))
+				if !errors.IsNodeNotFound(err) {
+					t.Fatalf("address %s: expected node not found, got %v", addr, err)
+				}
+			}
+		})
+
+		t.Run("ListStaleNodes", func(t *testing.T) {
+			ctx := context.Background()
+			p := builder(t)
+			for _, id := range []string{"node-a", "node-b"} {
+				err := p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+					Id:        id,
+					PublicKey: mustGeneratePublicKey(t),
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// node-a is heartbeating, node-b stopped an hour ago.
+			if err := p.PutHeartbeat(ctx, "node-a", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.PutHeartbeat(ctx, "node-b", time.Now().Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			stale, err := p.ListStaleNodes(ctx, time.Now().Add(-time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stale) != 1 || stale[0].GetId() != "node-b" {
+				t.Fatalf("expected only node-b to be stale, got %v", stale)
+			}
+			// A fresh heartbeat should clear the node.
+			if err := p.PutHeartbeat(ctx, "node-b", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			stale, err = p.ListStaleNodes(ctx, time.Now().Add(-time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stale) != 0 {
+				t.Fatalf("expected no stale nodes, got %v", stale)
+			}
+			// Invalid node IDs should be rejected.
+			if err := p.PutHeartbeat(ctx, "invalid/node-id", time.Now()); err == nil {
+				t.Fatal("expected error for invalid node ID")
+			}
+		})
+
+		t.Run("CreateNode", func(t *testing.T) {
+			ctx := context.Background()
+			p := builder(t)
+			const workers = 8
+			keys := make([]string, workers)
+			for i := range keys {
+				keys[i] = mustGeneratePublicKey(t)
+			}
+			var created, exists atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(key string) {
+					defer wg.Done()
+					err := p.Create(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+						Id:        "created-node",
+						PublicKey: key,
+					}})
+					switch {
+					case err == nil:
+						created.Add(1)
+					case errors.IsNodeExists(err):
+						exists.Add(1)
+					default:
+						t.Errorf("unexpected error creating node: %v", err)
+					}
+				}(keys[i])
+			}
+			wg.Wait()
+			if created.Load() != 1 {
+				t.Fatalf("expected exactly one create to succeed, got %d", created.Load())
+			}
+			if exists.Load() != workers-1 {
+				t.Fatalf("expected %d creates to find the node existing, got %d", workers-1, exists.Load())
+			}
+			node, err := p.Get(ctx, "created-node")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(keys, node.GetPublicKey()) {
+				t.Fatal("stored node does not match any create")
+			}
+		})
+
 		t.Run("DeleteNode", func(t *testing.T) {
 			ctx := context.Background()
 			p := builder(t)
@@ -521,6 +658,79 @@ func TestPeerStorageConformance(t *testing.T, builder NewPeersFunc) {
 			}
 		})
 
+		t.Run("ListPeersByLabel", func(t *testing.T) {
+			ctx := context.Background()
+			p := builder(t)
+			nodes := []types.MeshNode{
+				{
+					MeshNode: &v1.MeshNode{
+						Id:              "gateway-us",
+						PublicKey:       mustGeneratePublicKey(t),
+						ZoneAwarenessID: "zone-a,region=us,role=gateway",
+					},
+				},
+				{
+					MeshNode: &v1.MeshNode{
+						Id:              "gateway-eu",
+						PublicKey:       mustGeneratePublicKey(t),
+						ZoneAwarenessID: "region=eu,role=gateway",
+					},
+				},
+				{
+					MeshNode: &v1.MeshNode{
+						Id:              "worker-us",
+						PublicKey:       mustGeneratePublicKey(t),
+						ZoneAwarenessID: "zone-a,region=us",
+					},
+				},
+			}
+			for _, node := range nodes {
+				err := p.Put(ctx, node)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			tc := map[string][]string{
+				"role=gateway":           {"gateway-eu", "gateway-us"},
+				"region=us":              {"gateway-us", "worker-us"},
+				"role=gateway,region=us": {"gateway-us"},
+				"!role":                  {"worker-us"},
+				"role=worker":            {},
+			}
+			for sel, want := range tc {
+				selector, err := types.ParseLabelSelector(sel)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := p.ListPeersByLabel(ctx, selector)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []string
+				for _, node := range got {
+					ids = append(ids, node.GetId())
+				}
+				slices.Sort(ids)
+				if !slices.Equal(ids, want) {
+					t.Fatalf("selector %q: expected %v, got %v", sel, want, ids)
+				}
+			}
+			// Labels should follow updates to the node.
+			updated := nodes[2].DeepCopy()
+			updated.ZoneAwarenessID = "zone-a,region=us,role=gateway"
+			if err := p.Put(ctx, updated); err != nil {
+				t.Fatal(err)
+			}
+			selector, _ := types.ParseLabelSelector("role=gateway,region=us")
+			got, err := p.ListPeersByLabel(ctx, selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("expected two nodes after relabeling, got %d", len(got))
+			}
+		})
+
 		t.Run("ListByFeature", func(t *testing.T) {
 			ctx := context.Background()
 			p := builder(t)
@@ -623,6 +623,176 @@ func TestMeshStorageConformance(ctx context.Context, t *testing.T, meshStorage s
 		}
 	})
 
+	t.Run("IterPrefixAt", func(t *testing.T) {
+		kv := map[string]string{
+			"IterPrefixAt/key1": "value1",
+			"IterPrefixAt/key2": "value2",
+		}
+		for key, value := range kv {
+			if err := meshStorage.PutValue(ctx, []byte(key), []byte(value), 0); err != nil {
+				t.Fatalf("failed to put key: %v", err)
+			}
+		}
+		rev, err := meshStorage.Revision(ctx)
+		if err != nil {
+			t.Fatalf("failed to get revision: %v", err)
+		}
+		// Write to the prefix while iterating and make sure none of the
+		// writes are observed.
+		seen := map[string]string{}
+		var wrote bool
+		err = meshStorage.IterPrefixAt(ctx, []byte("IterPrefixAt/"), rev, func(key, value []byte) error {
+			seen[string(key)] = string(value)
+			if !wrote {
+				wrote = true
+				if err := meshStorage.PutValue(ctx, []byte("IterPrefixAt/key3"), []byte("value3"), 0); err != nil {
+					return err
+				}
+				if err := meshStorage.PutValue(ctx, []byte("IterPrefixAt/key2"), []byte("updated"), 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to iterate prefix: %v", err)
+		}
+		if len(seen) != len(kv) {
+			t.Errorf("expected %d keys, got %d", len(kv), len(seen))
+		}
+		for key, value := range kv {
+			if seen[key] != value {
+				t.Errorf("expected %q, got %q", value, seen[key])
+			}
+		}
+		// A newer revision should observe the writes, and deletes made
+		// after the original revision should not affect it.
+		if err := meshStorage.Delete(ctx, []byte("IterPrefixAt/key1")); err != nil {
+			t.Fatalf("failed to delete key: %v", err)
+		}
+		newRev, err := meshStorage.Revision(ctx)
+		if err != nil {
+			t.Fatalf("failed to get revision: %v", err)
+		}
+		seen = map[string]string{}
+		err = meshStorage.IterPrefixAt(ctx, []byte("IterPrefixAt/"), newRev, func(key, value []byte) error {
+			seen[string(key)] = string(value)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to iterate prefix: %v", err)
+		}
+		expected := map[string]string{
+			"IterPrefixAt/key2": "updated",
+			"IterPrefixAt/key3": "value3",
+		}
+		if len(seen) != len(expected) {
+			t.Errorf("expected %d keys, got %d", len(expected), len(seen))
+		}
+		for key, value := range expected {
+			if seen[key] != value {
+				t.Errorf("expected %q, got %q", value, seen[key])
+			}
+		}
+		seen = map[string]string{}
+		err = meshStorage.IterPrefixAt(ctx, []byte("IterPrefixAt/"), rev, func(key, value []byte) error {
+			seen[string(key)] = string(value)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to iterate prefix: %v", err)
+		}
+		if seen["IterPrefixAt/key1"] != "value1" {
+			t.Errorf("expected deleted key to be visible at old revision, got %q", seen["IterPrefixAt/key1"])
+		}
+		// Clean up
+		for _, key := range []string{"IterPrefixAt/key2", "IterPrefixAt/key3"} {
+			if err := meshStorage.Delete(ctx, []byte(key)); err != nil {
+				t.Fatalf("failed to delete key: %v", err)
+			}
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		// Queue a few puts and a delete and make sure they are all applied.
+		if err := meshStorage.PutValue(ctx, []byte("Batch/delete"), []byte("value"), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		kv := map[string]string{
+			"Batch/key1": "value1",
+			"Batch/key2": "value2",
+		}
+		batch := meshStorage.Batch()
+		for key, value := range kv {
+			batch.PutValue([]byte(key), []byte(value), 0)
+		}
+		batch.Delete([]byte("Batch/delete"))
+		if batch.Len() != len(kv)+1 {
+			t.Fatalf("expected %d queued operations, got %d", len(kv)+1, batch.Len())
+		}
+		if err := batch.Commit(ctx); err != nil {
+			t.Fatalf("failed to commit batch: %v", err)
+		}
+		for key, value := range kv {
+			got, err := meshStorage.GetValue(ctx, []byte(key))
+			if err != nil {
+				t.Fatalf("failed to get key: %v", err)
+			}
+			if string(got) != value {
+				t.Errorf("expected %q, got %q", value, string(got))
+			}
+		}
+		_, err := meshStorage.GetValue(ctx, []byte("Batch/delete"))
+		if !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+		// Clean up
+		for key := range kv {
+			if err := meshStorage.Delete(ctx, []byte(key)); err != nil {
+				t.Fatalf("failed to delete key: %v", err)
+			}
+		}
+
+		// A batch containing an invalid operation should leave no writes applied.
+		batch = meshStorage.Batch()
+		batch.PutValue([]byte("Batch/key1"), []byte("value1"), 0)
+		batch.PutValue([]byte(""), []byte("value2"), 0)
+		if err := batch.Commit(ctx); err == nil {
+			t.Fatal("expected error committing batch with an empty key")
+		}
+		_, err = meshStorage.GetValue(ctx, []byte("Batch/key1"))
+		if !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound after failed batch, got %v", err)
+		}
+
+		// A key that must be absent is only written if it does not exist.
+		batch = meshStorage.Batch()
+		batch.PutValueIfAbsent([]byte("Batch/absent"), []byte("first"), 0)
+		if err := batch.Commit(ctx); err != nil {
+			t.Fatalf("failed to commit batch: %v", err)
+		}
+		batch = meshStorage.Batch()
+		batch.PutValue([]byte("Batch/key1"), []byte("value1"), 0)
+		batch.PutValueIfAbsent([]byte("Batch/absent"), []byte("second"), 0)
+		if err := batch.Commit(ctx); !errors.IsKeyExists(err) {
+			t.Fatalf("expected ErrKeyExists, got %v", err)
+		}
+		got, err := meshStorage.GetValue(ctx, []byte("Batch/absent"))
+		if err != nil {
+			t.Fatalf("failed to get key: %v", err)
+		}
+		if string(got) != "first" {
+			t.Errorf("expected the original value to be kept, got %q", string(got))
+		}
+		_, err = meshStorage.GetValue(ctx, []byte("Batch/key1"))
+		if !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound after failed condition, got %v", err)
+		}
+		if err := meshStorage.Delete(ctx, []byte("Batch/absent")); err != nil {
+			t.Fatalf("failed to delete key: %v", err)
+		}
+	})
+
 	t.Run("Subscribe", func(t *testing.T) {
 		SkipOnCI(t, "Skipping on CI due to flakiness")
 		var subscribeTimeout = 15 * time.Second
@@ -0,0 +1,67 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+// CordonLabel is the reserved label used to mark a node as unschedulable.
+// Routes through a cordoned node are only used when no other node offers
+// the same destination.
+const CordonLabel = "webmesh-cordon"
+
+// CordonState is the scheduling state of a node.
+type CordonState string
+
+const (
+	// CordonStateNone means the node is schedulable.
+	CordonStateNone CordonState = ""
+	// CordonStateCordoned means the node is not selected for new routes,
+	// but keeps serving the routes only it provides.
+	CordonStateCordoned CordonState = "cordoned"
+	// CordonStateDraining means the node is cordoned and being prepared to
+	// leave the mesh.
+	CordonStateDraining CordonState = "draining"
+)
+
+// IsValid returns true if the state is a known cordon state.
+func (c CordonState) IsValid() bool {
+	switch c {
+	case CordonStateNone, CordonStateCordoned, CordonStateDraining:
+		return true
+	}
+	return false
+}
+
+// CordonState returns the scheduling state of the node.
+func (n MeshNode) CordonState() CordonState {
+	return CordonState(n.Labels()[CordonLabel])
+}
+
+// Cordoned returns true if the node is cordoned or draining.
+func (n MeshNode) Cordoned() bool {
+	return n.CordonState() != CordonStateNone
+}
+
+// WithCordonState returns the zone awareness ID of the node with its cordon
+// state set to the given state. CordonStateNone removes the label.
+func (n MeshNode) WithCordonState(state CordonState) (string, error) {
+	labels := n.Labels()
+	if state == CordonStateNone {
+		delete(labels, CordonLabel)
+	} else {
+		labels[CordonLabel] = string(state)
+	}
+	return EncodeZoneAwarenessID(n.Zones(), labels)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// FeaturesHeader is the gRPC header used to return the features accepted
+	// for a joining node.
+	FeaturesHeader = "x-webmesh-features"
+	// UnsupportedFeaturesHeader is the gRPC header used to return the features
+	// requested by a joining node that the cluster does not support.
+	UnsupportedFeaturesHeader = "x-webmesh-unsupported-features"
+)
+
+// ParseFeatures parses the given feature names. Names are case-insensitive.
+func ParseFeatures(names []string) ([]v1.Feature, error) {
+	features := make([]v1.Feature, 0, len(names))
+	for _, name := range names {
+		val, ok := v1.Feature_value[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok || val == int32(v1.Feature_FEATURE_NONE) {
+			return nil, fmt.Errorf("unknown feature: %q", name)
+		}
+		features = append(features, v1.Feature(val))
+	}
+	return features, nil
+}
+
+// FeaturesFromHeader returns the accepted and unsupported features returned
+// in the header of a join response. Unknown feature names are ignored.
+func FeaturesFromHeader(md metadata.MD) (accepted, unsupported []v1.Feature) {
+	parse := func(key string) []v1.Feature {
+		var out []v1.Feature
+		for _, name := range md.Get(key) {
+			if val, ok := v1.Feature_value[name]; ok {
+				out = append(out, v1.Feature(val))
+			}
+		}
+		return out
+	}
+	return parse(FeaturesHeader), parse(UnsupportedFeaturesHeader)
+}
@@ -108,6 +108,29 @@ func (e Edge) ToMeshEdge(source, target NodeID) MeshEdge {
 	}
 }
 
+// EdgeAttributeOneWay is set on an edge when network ACLs allow traffic in
+// the direction of the edge but not in the reverse direction.
+const EdgeAttributeOneWay = "EDGE_ATTRIBUTE_ONE_WAY"
+
+// IsOneWay returns true if the edge only allows traffic from its source
+// to its target.
+func (e Edge) IsOneWay() bool {
+	_, ok := e.Properties.Attributes[EdgeAttributeOneWay]
+	return ok
+}
+
+// OneWay returns a copy of the edge marked as only allowing traffic from its
+// source to its target. The attributes of the original edge are not modified.
+func (e Edge) OneWay() Edge {
+	attrs := make(map[string]string, len(e.Properties.Attributes)+1)
+	for k, v := range e.Properties.Attributes {
+		attrs[k] = v
+	}
+	attrs[EdgeAttributeOneWay] = "true"
+	e.Properties.Attributes = attrs
+	return e
+}
+
 // DeepEqual returns true if the given Edge is equal to this Edge.
 func (e Edge) DeepEqual(other Edge) bool {
 	return e.Source == other.Source &&
@@ -183,17 +206,18 @@ func (e *MeshEdge) UnmarshalProtoJSON(data []byte) error {
 
 // PutInto puts the MeshEdge into the given graph.
 func (e MeshEdge) PutInto(ctx context.Context, g PeerGraph) error {
-	opts := []func(*graph.EdgeProperties){graph.EdgeWeight(int(e.Weight))}
-	if len(e.Attributes) > 0 {
-		for k, v := range e.Attributes {
-			opts = append(opts, graph.EdgeAttribute(k, v))
-		}
+	// Attributes are replaced as a whole so that removed attributes do not
+	// linger on existing edges.
+	attrs := make(map[string]string, len(e.Attributes))
+	for k, v := range e.Attributes {
+		attrs[k] = v
 	}
+	opts := []func(*graph.EdgeProperties){graph.EdgeWeight(int(e.Weight)), graph.EdgeAttributes(attrs)}
 	// Save the raft log some trouble by checking if the edge already exists.
 	graphEdge, err := g.Edge(e.SourceID(), e.TargetID())
 	if err == nil {
 		// Check if the weight or attributes changed
-		if !reflect.DeepEqual(graphEdge.Properties.Attributes, e.Attributes) {
+		if !reflect.DeepEqual(graphEdge.Properties.Attributes, attrs) {
 			return g.UpdateEdge(e.SourceID(), e.TargetID(), opts...)
 		}
 		if graphEdge.Properties.Weight != int(e.Weight) {
@@ -0,0 +1,206 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// LabelSeparator separates the key and value of a label. Labels are stored
+// alongside zones in a node's zone awareness ID as key=value entries, which
+// can never be mistaken for a zone.
+const LabelSeparator = "="
+
+// Labels are arbitrary key/value metadata attached to a node.
+type Labels map[string]string
+
+// ValidateLabels validates the keys and values of the given labels.
+// Keys must be valid IDs and values must be empty or valid IDs.
+func ValidateLabels(labels Labels) error {
+	for key, value := range labels {
+		if !IsValidID(key) || strings.Contains(key, LabelSeparator) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if value != "" && (!IsValidID(value) || strings.Contains(value, LabelSeparator)) {
+			return fmt.Errorf("invalid value %q for label %q", value, key)
+		}
+	}
+	return nil
+}
+
+// ParseLabels returns the labels stored in a zone awareness ID.
+func ParseLabels(zoneID string) Labels {
+	labels := Labels{}
+	for _, entry := range strings.Split(zoneID, ZoneSeparator) {
+		key, value, ok := strings.Cut(strings.TrimSpace(entry), LabelSeparator)
+		if ok && key != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// Entries returns the labels as sorted key=value strings.
+func (l Labels) Entries() []string {
+	out := make([]string, 0, len(l))
+	for key, value := range l {
+		out = append(out, key+LabelSeparator+value)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// String returns the labels as a comma-separated list of key=value pairs.
+func (l Labels) String() string {
+	return strings.Join(l.Entries(), ZoneSeparator)
+}
+
+// EncodeZoneAwarenessID validates the given zones and labels and encodes
+// them into a zone awareness ID. A node without labels is encoded exactly
+// as JoinZones would.
+func EncodeZoneAwarenessID(zones []string, labels Labels) (string, error) {
+	zoneID, err := JoinZones(zones)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateLabels(labels); err != nil {
+		return "", err
+	}
+	if len(labels) == 0 {
+		return zoneID, nil
+	}
+	if zoneID == "" {
+		return labels.String(), nil
+	}
+	return zoneID + ZoneSeparator + labels.String(), nil
+}
+
+// Labels returns the labels attached to the node.
+func (n MeshNode) Labels() Labels {
+	return ParseLabels(n.GetZoneAwarenessID())
+}
+
+// LabelOperator is an operator in a label selector requirement.
+type LabelOperator string
+
+const (
+	// LabelEquals matches nodes with the label set to the value.
+	LabelEquals LabelOperator = "="
+	// LabelNotEquals matches nodes without the label set to the value.
+	LabelNotEquals LabelOperator = "!="
+	// LabelExists matches nodes with the label set to any value.
+	LabelExists LabelOperator = "exists"
+	// LabelDoesNotExist matches nodes without the label.
+	LabelDoesNotExist LabelOperator = "!"
+)
+
+// LabelRequirement is a single requirement in a label selector.
+type LabelRequirement struct {
+	Key      string
+	Operator LabelOperator
+	Value    string
+}
+
+// Matches returns true if the labels satisfy the requirement.
+func (r LabelRequirement) Matches(labels Labels) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case LabelEquals:
+		return ok && value == r.Value
+	case LabelNotEquals:
+		return !ok || value != r.Value
+	case LabelExists:
+		return ok
+	case LabelDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// String returns the string form of the requirement.
+func (r LabelRequirement) String() string {
+	switch r.Operator {
+	case LabelExists:
+		return r.Key
+	case LabelDoesNotExist:
+		return "!" + r.Key
+	}
+	return r.Key + string(r.Operator) + r.Value
+}
+
+// LabelSelector selects nodes whose labels satisfy all of its requirements.
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses a comma-separated list of label requirements.
+// Each requirement is one of key=value, key==value, key!=value, key, or !key.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var out LabelSelector
+	for _, term := range strings.Split(selector, ZoneSeparator) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var req LabelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			req.Key, req.Value, _ = strings.Cut(term, "!=")
+			req.Operator = LabelNotEquals
+		case strings.Contains(term, "=="):
+			req.Key, req.Value, _ = strings.Cut(term, "==")
+			req.Operator = LabelEquals
+		case strings.Contains(term, LabelSeparator):
+			req.Key, req.Value, _ = strings.Cut(term, LabelSeparator)
+			req.Operator = LabelEquals
+		case strings.HasPrefix(term, "!"):
+			req.Key = strings.TrimPrefix(term, "!")
+			req.Operator = LabelDoesNotExist
+		default:
+			req.Key = term
+			req.Operator = LabelExists
+		}
+		req.Key, req.Value = strings.TrimSpace(req.Key), strings.TrimSpace(req.Value)
+		if err := ValidateLabels(Labels{req.Key: req.Value}); err != nil {
+			return nil, fmt.Errorf("invalid label selector %q: %w", term, err)
+		}
+		out = append(out, req)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("label selector must not be empty")
+	}
+	return out, nil
+}
+
+// Matches returns true if the labels satisfy every requirement.
+func (s LabelSelector) Matches(labels Labels) bool {
+	for _, req := range s {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the string form of the selector.
+func (s LabelSelector) String() string {
+	terms := make([]string, len(s))
+	for i, req := range s {
+		terms[i] = req.String()
+	}
+	return strings.Join(terms, ZoneSeparator)
+}
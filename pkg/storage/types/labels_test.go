@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+)
+
+func TestMeshNodeLabels(t *testing.T) {
+	t.Parallel()
+
+	zoneID, err := EncodeZoneAwarenessID([]string{"zone-a"}, Labels{"role": "gateway", "region": "us"})
+	if err != nil {
+		t.Fatalf("encode zone awareness id: %v", err)
+	}
+	if zoneID != "zone-a,region=us,role=gateway" {
+		t.Errorf("unexpected zone awareness id %q", zoneID)
+	}
+	node := MeshNode{MeshNode: &v1.MeshNode{ZoneAwarenessID: zoneID}}
+	labels := node.Labels()
+	if len(labels) != 2 || labels["role"] != "gateway" || labels["region"] != "us" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	// Labels must not be mistaken for zones.
+	if zones := node.Zones(); len(zones) != 1 || zones[0] != "zone-a" {
+		t.Errorf("expected only zone-a, got %v", zones)
+	}
+	for _, invalid := range []Labels{{"": "x"}, {"role": "a b"}, {"a=b": "c"}, {"role": "a,b"}} {
+		if _, err := EncodeZoneAwarenessID(nil, invalid); err == nil {
+			t.Errorf("expected error for labels %v", invalid)
+		}
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	t.Parallel()
+
+	labels := Labels{"role": "gateway", "region": "us"}
+	tc := []struct {
+		selector string
+		matches  bool
+		wantErr  bool
+	}{
+		{selector: "role=gateway", matches: true},
+		{selector: "role==gateway", matches: true},
+		{selector: "role=gateway,region=us", matches: true},
+		{selector: "role=gateway,region=eu", matches: false},
+		{selector: "role!=gateway", matches: false},
+		{selector: "region!=eu", matches: true},
+		{selector: "role", matches: true},
+		{selector: "zone", matches: false},
+		{selector: "!zone", matches: true},
+		{selector: "!role", matches: false},
+		{selector: "", wantErr: true},
+		{selector: "role=a b", wantErr: true},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.selector, func(t *testing.T) {
+			t.Parallel()
+			selector, err := ParseLabelSelector(tt.selector)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse selector: %v", err)
+			}
+			if got := selector.Matches(labels); got != tt.matches {
+				t.Errorf("expected %s to match %v: %v, got %v", selector, labels, tt.matches, got)
+			}
+		})
+	}
+}
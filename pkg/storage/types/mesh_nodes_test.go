@@ -447,3 +447,33 @@ func TestMeshNodeWrapper(t *testing.T) {
 		}
 	})
 }
+
+func TestMeshNodeZones(t *testing.T) {
+	t.Parallel()
+
+	zoneID, err := JoinZones([]string{"zone-b", "zone-a", "zone-b"})
+	if err != nil {
+		t.Fatalf("join zones: %v", err)
+	}
+	if zoneID != "zone-a,zone-b" {
+		t.Errorf("expected sorted and deduplicated zones, got %q", zoneID)
+	}
+	if _, err := JoinZones([]string{"zone a"}); err == nil {
+		t.Error("expected error for invalid zone")
+	}
+	node := MeshNode{MeshNode: &v1.MeshNode{ZoneAwarenessID: zoneID}}
+	if !node.InZone("zone-a") || !node.InZone("zone-b") {
+		t.Errorf("expected node to be in both zones, got %v", node.Zones())
+	}
+	if node.InZone("zone-c") {
+		t.Error("expected node not to be in zone-c")
+	}
+	// Single zone IDs are unchanged.
+	single := MeshNode{MeshNode: &v1.MeshNode{ZoneAwarenessID: "zone-a"}}
+	if zones := single.Zones(); len(zones) != 1 || zones[0] != "zone-a" {
+		t.Errorf("expected single zone, got %v", zones)
+	}
+	if !ZonesOverlap(node.Zones(), single.Zones()) {
+		t.Error("expected zones to overlap")
+	}
+}
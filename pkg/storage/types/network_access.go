@@ -25,6 +25,11 @@ import (
 // NetworkAction wraps a NetworkAction.
 type NetworkAction struct {
 	*v1.NetworkAction `json:",inline"`
+	// Protocol is the layer 4 protocol of the action. Actions without a
+	// protocol are evaluated for whether any traffic may flow.
+	Protocol Protocol `json:"protocol,omitempty"`
+	// Port is the destination port of the action.
+	Port uint16 `json:"port,omitempty"`
 }
 
 // Proto returns the protobuf representation of the action.
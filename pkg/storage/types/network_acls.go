@@ -33,10 +33,21 @@ import (
 const (
 	// GroupReference is the prefix of a node name that indicates it is a group reference.
 	GroupReference = "group:"
+	// LabelReference is the prefix of a node name that indicates it is a label
+	// selector, such as "label:role=gateway".
+	LabelReference = "label:"
 )
 
-// ValidateACL validates a NetworkACL.
+// MaxACLListLength is the maximum number of entries allowed in each of the
+// node and CIDR lists of a NetworkACL.
+const MaxACLListLength = 1024
+
+// ValidateACL validates a NetworkACL. An ACL that passes validation is safe
+// to use in graph filtering and action evaluation.
 func ValidateACL(acl NetworkACL) error {
+	if acl.NetworkACL == nil {
+		return errors.New("acl is required")
+	}
 	if acl.GetName() == "" {
 		return errors.New("acl name is required")
 	}
@@ -46,22 +57,70 @@ func ValidateACL(acl NetworkACL) error {
 	if _, ok := v1.ACLAction_name[int32(acl.GetAction())]; !ok {
 		return errors.New("invalid acl action")
 	}
-	for _, node := range append(acl.GetSourceNodes(), acl.GetDestinationNodes()...) {
+	if err := validateACLNodes("source", acl.GetSourceNodes()); err != nil {
+		return err
+	}
+	if err := validateACLNodes("destination", acl.GetDestinationNodes()); err != nil {
+		return err
+	}
+	if err := validateACLCIDRs("source", acl.GetSourceCIDRs()); err != nil {
+		return err
+	}
+	if len(acl.GetDestinationCIDRs()) > MaxACLListLength {
+		return fmt.Errorf("too many destination cidrs: %d > %d", len(acl.GetDestinationCIDRs()), MaxACLListLength)
+	}
+	if err := validateACLCIDRs("destination", destinationCIDRs(acl.GetDestinationCIDRs())); err != nil {
+		return err
+	}
+	for _, entry := range acl.GetDestinationCIDRs() {
+		if !strings.HasPrefix(entry, PortReference) {
+			continue
+		}
+		if _, err := ParsePortRule(strings.TrimPrefix(entry, PortReference)); err != nil {
+			return fmt.Errorf("invalid destination port rule %q: %w", entry, err)
+		}
+	}
+	return nil
+}
+
+func validateACLNodes(kind string, nodes []string) error {
+	if len(nodes) > MaxACLListLength {
+		return fmt.Errorf("too many %s nodes: %d > %d", kind, len(nodes), MaxACLListLength)
+	}
+	for _, node := range nodes {
 		if node == "*" {
 			continue
 		}
-		node = strings.TrimPrefix(node, GroupReference)
+		if strings.HasPrefix(node, GroupReference) {
+			if !IsValidID(strings.TrimPrefix(node, GroupReference)) {
+				return fmt.Errorf("invalid %s group reference: %q", kind, node)
+			}
+			continue
+		}
+		if strings.HasPrefix(node, LabelReference) {
+			if _, err := ParseLabelSelector(strings.TrimPrefix(node, LabelReference)); err != nil {
+				return fmt.Errorf("invalid %s label reference %q: %w", kind, node, err)
+			}
+			continue
+		}
 		if !IsValidID(node) {
-			return fmt.Errorf("invalid source node: %s", node)
+			return fmt.Errorf("invalid %s node: %q", kind, node)
 		}
 	}
-	for _, cidr := range append(acl.GetSourceCIDRs(), acl.GetDestinationCIDRs()...) {
+	return nil
+}
+
+func validateACLCIDRs(kind string, cidrs []string) error {
+	if len(cidrs) > MaxACLListLength {
+		return fmt.Errorf("too many %s cidrs: %d > %d", kind, len(cidrs), MaxACLListLength)
+	}
+	for _, cidr := range cidrs {
 		if cidr == "*" {
 			continue
 		}
 		_, err := netip.ParsePrefix(cidr)
 		if err != nil {
-			return fmt.Errorf("invalid source cidr: %s", cidr)
+			return fmt.Errorf("invalid %s cidr: %q", kind, cidr)
 		}
 	}
 	return nil
@@ -88,8 +147,13 @@ func (a NetworkACLs) Len() int { return len(a) }
 func (a NetworkACLs) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
 // Less returns whether the ACL at index i should be sorted before the ACL at index j.
+// ACLs of equal priority sort accept before deny, so that deny ACLs are evaluated
+// first when sorted in descending order.
 func (a NetworkACLs) Less(i, j int) bool {
-	return a[i].Priority < a[j].Priority
+	if a[i].Priority != a[j].Priority {
+		return a[i].Priority < a[j].Priority
+	}
+	return a[i].Action == v1.ACLAction_ACTION_ACCEPT && a[j].Action != v1.ACLAction_ACTION_ACCEPT
 }
 
 // Sort sorts the ACLs by priority.
@@ -226,9 +290,9 @@ func (a NetworkACL) SourcePrefixes() []netip.Prefix {
 }
 
 // DestinationPrefixes returns the destination prefixes for the ACL.
-// Invalid prefixes will be ignored.
+// Invalid prefixes and port rules will be ignored.
 func (a NetworkACL) DestinationPrefixes() []netip.Prefix {
-	return ToPrefixes(a.GetDestinationCIDRs())
+	return ToPrefixes(destinationCIDRs(a.GetDestinationCIDRs()))
 }
 
 // Matches checks if an action matches this ACL.
@@ -248,12 +312,12 @@ func (acl NetworkACL) Matches(ctx context.Context, action NetworkAction) bool {
 			return false
 		}
 	}
-	if action.DestinationPrefix().IsValid() && len(acl.GetDestinationCIDRs()) > 0 {
+	if action.DestinationPrefix().IsValid() && len(destinationCIDRs(acl.GetDestinationCIDRs())) > 0 {
 		if !containsAddress(acl.DestinationPrefixes(), action.DestinationPrefix().Addr()) {
 			return false
 		}
 	}
-	return true
+	return acl.matchesPorts(action, acl.PortRules())
 }
 
 func containsOrWildcardMatch(ss []string, s string) bool {
@@ -0,0 +1,348 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestValidateACL(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]string, MaxACLListLength+1)
+	for i := range tooMany {
+		tooMany[i] = "10.0.0.0/8"
+	}
+	tc := []struct {
+		name    string
+		acl     NetworkACL
+		wantErr bool
+	}{
+		{
+			name:    "nil acl",
+			acl:     NetworkACL{},
+			wantErr: true,
+		},
+		{
+			name:    "empty name",
+			acl:     NetworkACL{NetworkACL: &v1.NetworkACL{}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			acl:     NetworkACL{NetworkACL: &v1.NetworkACL{Name: "foo/bar"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid action",
+			acl:     NetworkACL{NetworkACL: &v1.NetworkACL{Name: "acl", Action: 42}},
+			wantErr: true,
+		},
+		{
+			name: "empty group reference",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:        "acl",
+				SourceNodes: []string{GroupReference},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid destination node",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				DestinationNodes: []string{"node a"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid destination cidr",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				DestinationCIDRs: []string{"10.0.0.0/33"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "too many cidrs",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:        "acl",
+				SourceCIDRs: tooMany,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid label reference",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:        "acl",
+				SourceNodes: []string{LabelReference + "role=a b"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid port protocol",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				DestinationCIDRs: []string{PortReference + "gre/443"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "inverted port range",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				DestinationCIDRs: []string{PortReference + "tcp/9000-8000"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "port out of range",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				DestinationCIDRs: []string{PortReference + "udp/70000"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "icmp with ports",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				DestinationCIDRs: []string{PortReference + "icmp/1"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "port rule in source cidrs",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:        "acl",
+				SourceCIDRs: []string{PortReference + "tcp/443"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "valid port rules",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				Action:           v1.ACLAction_ACTION_ACCEPT,
+				DestinationCIDRs: []string{"10.0.0.0/8", PortReference + "tcp/443", PortReference + "udp/5000-6000", PortReference + "icmp"},
+			}},
+			wantErr: false,
+		},
+		{
+			name: "valid acl",
+			acl: NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				Action:           v1.ACLAction_ACTION_ACCEPT,
+				SourceNodes:      []string{"*", "group:admins", "label:role=gateway,region!=eu", "node-a"},
+				DestinationNodes: []string{"node-b"},
+				SourceCIDRs:      []string{"*", "10.0.0.0/8"},
+				DestinationCIDRs: []string{"fd00::/8"},
+			}},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateACL(tt.acl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateACL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func FuzzValidateACL(f *testing.F) {
+	seeds := []string{
+		`{}`,
+		`{"name":"allow-all","action":"ACTION_ACCEPT","sourceNodes":["*"],"destinationNodes":["*"]}`,
+		`{"name":"deny","priority":-1,"action":"ACTION_DENY","sourceCIDRs":["*"],"destinationCIDRs":["0.0.0.0/0","::/0"]}`,
+		`{"name":"groups","action":1,"sourceNodes":["group:admins","group:"],"destinationNodes":["group:group:x"]}`,
+		`{"name":"bad-cidrs","sourceCIDRs":["10.0.0.0/33","fe80::1%eth0/64",""],"destinationCIDRs":["*/0"]}`,
+		`{"name":"","action":99,"sourceNodes":[""]}`,
+		`{"name":"labels","action":1,"sourceNodes":["label:role=gateway","label:"],"destinationNodes":["label:!zone,region!=eu"]}`,
+		`{"name":"ports","action":1,"destinationCIDRs":["10.0.0.0/8","port:tcp/443","port:udp/1-65535","port:icmp","port:tcp/0"]}`,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	ctx := context.Background()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg v1.NetworkACL
+		if err := protojson.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		acl := NetworkACL{NetworkACL: &msg}
+		if err := ValidateACL(acl); err != nil {
+			return
+		}
+		// Anything that passes validation must be safe to use downstream.
+		for _, cidr := range append(append([]string{}, acl.GetSourceCIDRs()...), acl.GetDestinationCIDRs()...) {
+			if cidr == "*" {
+				continue
+			}
+			if strings.HasPrefix(cidr, PortReference) {
+				if _, err := ParsePortRule(strings.TrimPrefix(cidr, PortReference)); err != nil {
+					t.Fatalf("validated acl contains invalid port rule %q", cidr)
+				}
+				continue
+			}
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				t.Fatalf("validated acl contains invalid cidr %q", cidr)
+			}
+		}
+		for _, node := range append(append([]string{}, acl.GetSourceNodes()...), acl.GetDestinationNodes()...) {
+			if strings.HasPrefix(node, LabelReference) {
+				if _, err := ParseLabelSelector(strings.TrimPrefix(node, LabelReference)); err != nil {
+					t.Fatalf("validated acl contains invalid label reference %q", node)
+				}
+				continue
+			}
+			if node != "*" && !IsValidID(strings.TrimPrefix(node, GroupReference)) {
+				t.Fatalf("validated acl contains invalid node %q", node)
+			}
+		}
+		_ = acl.SourcePrefixes()
+		_ = acl.DestinationPrefixes()
+		acls := NetworkACLs{acl}
+		acls.Sort(SortDescending)
+		for _, action := range []NetworkAction{
+			{NetworkAction: &v1.NetworkAction{}},
+			{NetworkAction: &v1.NetworkAction{SrcNode: "node-a", DstNode: "node-b", SrcCIDR: "10.0.0.1/32", DstCIDR: "10.0.0.2/32"}},
+			{NetworkAction: &v1.NetworkAction{SrcNode: "node-a", DstNode: "node-b", SrcCIDR: "fd00::1/128", DstCIDR: "fd00::2/128"}},
+			{NetworkAction: &v1.NetworkAction{SrcNode: "node-a", DstNode: "node-b"}, Protocol: ProtocolTCP, Port: 443},
+		} {
+			_ = acls.Accept(ctx, action)
+		}
+		_ = acls.AllowNodesToCommunicate(ctx,
+			MeshNode{MeshNode: &v1.MeshNode{Id: "node-a", PrivateIPv4: "10.0.0.1/32", PrivateIPv6: "fd00::1/128"}},
+			MeshNode{MeshNode: &v1.MeshNode{Id: "node-b", PrivateIPv4: "10.0.0.2/32", PrivateIPv6: "fd00::2/128"}},
+		)
+	})
+}
+
+func TestNetworkACLPortMatching(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	acls := NetworkACLs{
+		{NetworkACL: &v1.NetworkACL{
+			Name:             "allow-https",
+			Priority:         10,
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceCIDRs:      []string{"10.0.0.0/8"},
+			DestinationCIDRs: []string{"10.1.0.0/16", PortReference + "tcp/443"},
+		}},
+		{NetworkACL: &v1.NetworkACL{
+			Name:             "allow-dns-range",
+			Priority:         5,
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			DestinationCIDRs: []string{PortReference + "udp/5300-5400"},
+		}},
+		{NetworkACL: &v1.NetworkACL{
+			Name:             "deny-ssh",
+			Priority:         1,
+			Action:           v1.ACLAction_ACTION_DENY,
+			DestinationCIDRs: []string{PortReference + "tcp/22"},
+		}},
+	}
+	acls.Sort(SortDescending)
+	action := func(dst string, proto Protocol, port uint16) NetworkAction {
+		return NetworkAction{
+			NetworkAction: &v1.NetworkAction{SrcCIDR: "10.0.0.1/32", DstCIDR: dst},
+			Protocol:      proto,
+			Port:          port,
+		}
+	}
+	tc := []struct {
+		name   string
+		action NetworkAction
+		want   bool
+	}{
+		{"tcp 443 to allowed cidr", action("10.1.0.1/32", ProtocolTCP, 443), true},
+		{"tcp 80 to allowed cidr", action("10.1.0.1/32", ProtocolTCP, 80), false},
+		{"udp 443 to allowed cidr", action("10.1.0.1/32", ProtocolUDP, 443), false},
+		{"tcp 443 outside allowed cidr", action("10.2.0.1/32", ProtocolTCP, 443), false},
+		{"udp in allowed range", action("10.2.0.1/32", ProtocolUDP, 5353), true},
+		{"udp below allowed range", action("10.2.0.1/32", ProtocolUDP, 5299), false},
+		{"icmp", action("10.1.0.1/32", ProtocolICMP, 0), false},
+		{"tcp 22 denied", action("10.1.0.1/32", ProtocolTCP, 22), false},
+		// Actions without a protocol ask whether any traffic may flow.
+		{"any traffic to allowed cidr", action("10.1.0.1/32", "", 0), true},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := acls.Accept(ctx, tt.action); got != tt.want {
+				t.Errorf("Accept() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A deny that only covers some ports does not deny all traffic.
+	partialDeny := NetworkACLs{
+		{NetworkACL: &v1.NetworkACL{
+			Name:             "deny-ssh",
+			Priority:         10,
+			Action:           v1.ACLAction_ACTION_DENY,
+			DestinationCIDRs: []string{PortReference + "tcp/22"},
+		}},
+		{NetworkACL: &v1.NetworkACL{
+			Name:   "allow-all",
+			Action: v1.ACLAction_ACTION_ACCEPT,
+		}},
+	}
+	partialDeny.Sort(SortDescending)
+	if !partialDeny.Accept(ctx, action("10.1.0.1/32", "", 0)) {
+		t.Error("expected a partial deny not to deny all traffic")
+	}
+	if partialDeny.Accept(ctx, action("10.1.0.1/32", ProtocolTCP, 22)) {
+		t.Error("expected tcp 22 to be denied")
+	}
+}
+
+func TestParsePortRule(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		in   string
+		want PortRule
+	}{
+		{"tcp", PortRule{Protocol: ProtocolTCP}},
+		{"TCP/443", PortRule{Protocol: ProtocolTCP, Start: 443, End: 443}},
+		{"udp/5000-6000", PortRule{Protocol: ProtocolUDP, Start: 5000, End: 6000}},
+		{"icmp", PortRule{Protocol: ProtocolICMP}},
+	}
+	for _, tt := range tc {
+		got, err := ParsePortRule(tt.in)
+		if err != nil {
+			t.Fatalf("ParsePortRule(%q) error: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParsePortRule(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if reparsed, err := ParsePortRule(strings.TrimPrefix(got.String(), PortReference)); err != nil || reparsed != got {
+			t.Errorf("round trip of %q failed: %+v, %v", tt.in, reparsed, err)
+		}
+	}
+}
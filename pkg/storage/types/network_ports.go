@@ -0,0 +1,173 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+)
+
+// PortReference is the prefix of a destination CIDR entry in a NetworkACL that
+// restricts the ACL to a protocol and optional port range, such as "port:tcp/443"
+// or "port:udp/5000-6000". An ACL with port entries only matches actions for
+// one of the given protocols and ports.
+const PortReference = "port:"
+
+// Protocol is a layer 4 protocol matched by network ACLs.
+type Protocol string
+
+const (
+	// ProtocolTCP matches TCP traffic.
+	ProtocolTCP Protocol = "tcp"
+	// ProtocolUDP matches UDP traffic.
+	ProtocolUDP Protocol = "udp"
+	// ProtocolICMP matches ICMP traffic. ICMP has no ports.
+	ProtocolICMP Protocol = "icmp"
+)
+
+// IsValid returns true if the protocol is a known protocol.
+func (p Protocol) IsValid() bool {
+	switch p {
+	case ProtocolTCP, ProtocolUDP, ProtocolICMP:
+		return true
+	}
+	return false
+}
+
+// HasPorts returns true if the protocol uses ports.
+func (p Protocol) HasPorts() bool {
+	return p == ProtocolTCP || p == ProtocolUDP
+}
+
+// PortRule matches a protocol and an inclusive range of destination ports.
+// A zero range matches all ports.
+type PortRule struct {
+	Protocol Protocol
+	Start    uint16
+	End      uint16
+}
+
+// ParsePortRule parses a port rule of the form "<protocol>[/<port>[-<port>]]".
+func ParsePortRule(s string) (PortRule, error) {
+	proto, ports, hasPorts := strings.Cut(s, "/")
+	rule := PortRule{Protocol: Protocol(strings.ToLower(proto))}
+	if !rule.Protocol.IsValid() {
+		return rule, fmt.Errorf("invalid protocol %q", proto)
+	}
+	if !hasPorts {
+		return rule, nil
+	}
+	if !rule.Protocol.HasPorts() {
+		return rule, fmt.Errorf("protocol %q does not have ports", proto)
+	}
+	start, end, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		end = start
+	}
+	var err error
+	if rule.Start, err = parsePort(start); err != nil {
+		return rule, err
+	}
+	if rule.End, err = parsePort(end); err != nil {
+		return rule, err
+	}
+	if rule.Start > rule.End {
+		return rule, fmt.Errorf("invalid port range %q: start is greater than end", ports)
+	}
+	return rule, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(port), nil
+}
+
+// AllPorts returns true if the rule matches every port of its protocol.
+func (r PortRule) AllPorts() bool {
+	return r.Start == 0 && r.End == 0
+}
+
+// Matches returns true if the given protocol and destination port match the rule.
+func (r PortRule) Matches(proto Protocol, port uint16) bool {
+	if r.Protocol != proto {
+		return false
+	}
+	return r.AllPorts() || (port >= r.Start && port <= r.End)
+}
+
+// String returns the string representation of the rule as used in a NetworkACL.
+func (r PortRule) String() string {
+	switch {
+	case r.AllPorts():
+		return PortReference + string(r.Protocol)
+	case r.Start == r.End:
+		return fmt.Sprintf("%s%s/%d", PortReference, r.Protocol, r.Start)
+	default:
+		return fmt.Sprintf("%s%s/%d-%d", PortReference, r.Protocol, r.Start, r.End)
+	}
+}
+
+// PortRules returns the port rules for the ACL. Invalid rules will be ignored.
+func (a NetworkACL) PortRules() []PortRule {
+	var out []PortRule
+	for _, entry := range a.GetDestinationCIDRs() {
+		if !strings.HasPrefix(entry, PortReference) {
+			continue
+		}
+		rule, err := ParsePortRule(strings.TrimPrefix(entry, PortReference))
+		if err != nil {
+			continue
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+// matchesPorts checks the action against the port rules of the ACL. Actions
+// without a protocol describe whether any traffic may flow, so they match an
+// accepting ACL regardless of its ports, but never a denying ACL that only
+// covers some ports.
+func (acl NetworkACL) matchesPorts(action NetworkAction, rules []PortRule) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	if action.Protocol == "" {
+		return acl.GetAction() == v1.ACLAction_ACTION_ACCEPT
+	}
+	for _, rule := range rules {
+		if rule.Matches(action.Protocol, action.Port) {
+			return true
+		}
+	}
+	return false
+}
+
+func destinationCIDRs(cidrs []string) []string {
+	out := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.HasPrefix(cidr, PortReference) {
+			out = append(out, cidr)
+		}
+	}
+	return out
+}
@@ -26,6 +26,9 @@ import (
 // NetworkState wraps a NetworkState.
 type NetworkState struct {
 	*v1.NetworkState `json:",inline"`
+	// IPv4NodePrefixLength is the prefix length of the IPv4 subnet allocated
+	// to each node. Zero means the default of a /32 per node.
+	IPv4NodePrefixLength int `json:"ipv4NodePrefixLength,omitempty"`
 }
 
 // Proto returns the underlying protobuf.
@@ -35,7 +38,10 @@ func (n NetworkState) Proto() *v1.NetworkState {
 
 // DeepCopy returns a deep copy of the network state.
 func (n NetworkState) DeepCopy() NetworkState {
-	return NetworkState{NetworkState: n.NetworkState.DeepCopy()}
+	return NetworkState{
+		NetworkState:         n.NetworkState.DeepCopy(),
+		IPv4NodePrefixLength: n.IPv4NodePrefixLength,
+	}
 }
 
 // DeepCopyInto copies the node into the given network state.
@@ -68,6 +74,15 @@ func (n NetworkState) NetworkV4() netip.Prefix {
 	return prefix
 }
 
+// NodePrefixLengthV4 returns the prefix length of the IPv4 subnet
+// allocated to each node.
+func (n NetworkState) NodePrefixLengthV4() int {
+	if n.IPv4NodePrefixLength == 0 {
+		return 32
+	}
+	return n.IPv4NodePrefixLength
+}
+
 // NetworkV4 returns the IPv6 network as a netip.Prefix.
 func (n NetworkState) NetworkV6() netip.Prefix {
 	var prefix netip.Prefix
@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+// NodeRegistration is a node record created ahead of the node joining the
+// mesh. A node joining with a registered ID must match its registration.
+type NodeRegistration struct {
+	// ID is the expected ID of the node.
+	ID NodeID `json:"id"`
+	// PublicKey is the encoded public key the node must join with.
+	PublicKey string `json:"publicKey"`
+	// PrivateIPv4 is the IPv4 address assigned to the node when it requests
+	// one. When empty, an address is allocated as usual.
+	PrivateIPv4 string `json:"privateIPv4,omitempty"`
+	// Roles are the roles bound to the node when it joins.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// ValidateNodeRegistration validates a node registration.
+func ValidateNodeRegistration(reg NodeRegistration) error {
+	if reg.ID == "" {
+		return fmt.Errorf("node id must not be empty")
+	}
+	if !IsValidNodeID(reg.ID.String()) {
+		return fmt.Errorf("invalid node id %q", reg.ID)
+	}
+	if _, err := crypto.DecodePublicKey(reg.PublicKey); err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	if reg.PrivateIPv4 != "" {
+		prefix, err := netip.ParsePrefix(reg.PrivateIPv4)
+		if err != nil {
+			return fmt.Errorf("invalid private ipv4 %q: %w", reg.PrivateIPv4, err)
+		}
+		if !prefix.Addr().Is4() {
+			return fmt.Errorf("private ipv4 %q is not an ipv4 address", reg.PrivateIPv4)
+		}
+	}
+	for _, role := range reg.Roles {
+		if !IsValidID(role) {
+			return fmt.Errorf("invalid role %q", role)
+		}
+	}
+	return nil
+}
+
+// PrivateAddrV4 returns the registered IPv4 address of the node, if any.
+func (r NodeRegistration) PrivateAddrV4() netip.Prefix {
+	prefix, err := netip.ParsePrefix(r.PrivateIPv4)
+	if err != nil {
+		return netip.Prefix{}
+	}
+	return prefix
+}
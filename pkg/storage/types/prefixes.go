@@ -29,6 +29,9 @@ var (
 
 	// ConsensusPrefix is the prefix for all data stored related to consensus.
 	ConsensusPrefix StoragePrefix = []byte("/raft")
+
+	// TenantsPrefix is the prefix for registry data belonging to tenants.
+	TenantsPrefix StoragePrefix = []byte("/registry/tenants")
 )
 
 // String returns the string representation of the prefix.
@@ -71,3 +74,38 @@ func IsReservedPrefix(key []byte) bool {
 	}
 	return false
 }
+
+// TenantPrefix returns the prefix under which the registry of the given
+// tenant is stored.
+func TenantPrefix(tenant string) StoragePrefix {
+	return TenantsPrefix.ForString(tenant)
+}
+
+// ForTenant namespaces the given key to the registry of the given tenant.
+// Keys outside of the registry are returned unchanged.
+func ForTenant(tenant string, key []byte) []byte {
+	if !hasPathPrefix(key, RegistryPrefix) {
+		return key
+	}
+	rest := bytes.TrimPrefix(key, RegistryPrefix)
+	return append(append([]byte{}, TenantPrefix(tenant)...), rest...)
+}
+
+// TrimTenant reverses ForTenant, returning the key as it appears to the
+// given tenant.
+func TrimTenant(tenant string, key []byte) []byte {
+	prefix := TenantPrefix(tenant)
+	if !hasPathPrefix(key, prefix) {
+		return key
+	}
+	rest := bytes.TrimPrefix(key, prefix)
+	return append(append([]byte{}, RegistryPrefix...), rest...)
+}
+
+// hasPathPrefix returns true if the key is the prefix or a path below it.
+func hasPathPrefix(key, prefix []byte) bool {
+	if !bytes.HasPrefix(key, prefix) {
+		return false
+	}
+	return len(key) == len(prefix) || key[len(prefix)] == '/'
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+const (
+	// PresharedKeyEdgeAttribute is the edge attribute holding the WireGuard
+	// preshared key negotiated for the nodes on either end of the edge. The
+	// key is sealed to the WireGuard key of each end with SealPresharedKey,
+	// so that it can not be read by other nodes replicating the edge.
+	PresharedKeyEdgeAttribute = "EDGE_ATTRIBUTE_PRESHARED_KEY"
+	// PendingPresharedKeyEdgeAttribute is the edge attribute holding the
+	// preshared key that replaces the current one during a rotation. It is
+	// sealed the same way as the current key.
+	PendingPresharedKeyEdgeAttribute = "EDGE_ATTRIBUTE_PENDING_PRESHARED_KEY"
+	// PresharedKeyActivateAtEdgeAttribute is the edge attribute holding the
+	// time, in RFC 3339 format, at which both ends of the edge switch to the
+	// pending preshared key.
+	PresharedKeyActivateAtEdgeAttribute = "EDGE_ATTRIBUTE_PRESHARED_KEY_ACTIVATE_AT"
+	// PresharedKeysHeader is the gRPC header used to return the preshared
+	// keys negotiated for a joining node. Each value is of the form
+	// <peer-id>:<base64-key>.
+	PresharedKeysHeader = "x-webmesh-preshared-keys"
+)
+
+// SealPresharedKey seals the given preshared key to the WireGuard key of
+// each recipient. The result holds one anonymous box per recipient, keyed
+// by node ID, and can only be opened by the recipients.
+func SealPresharedKey(key wgtypes.Key, recipients map[NodeID]crypto.PublicKey) (string, error) {
+	sealed := make(map[NodeID]string, len(recipients))
+	for id, pub := range recipients {
+		wgkey := [32]byte(pub.WireGuardKey())
+		data, err := box.SealAnonymous(nil, key[:], &wgkey, rand.Reader)
+		if err != nil {
+			return "", fmt.Errorf("seal preshared key for %s: %w", id, err)
+		}
+		sealed[id] = base64.StdEncoding.EncodeToString(data)
+	}
+	out, err := json.Marshal(sealed)
+	if err != nil {
+		return "", fmt.Errorf("marshal sealed preshared key: %w", err)
+	}
+	return string(out), nil
+}
+
+// OpenPresharedKey opens the copy of a preshared key sealed with
+// SealPresharedKey for the given node. False is returned if there is no
+// valid key for the node.
+func OpenPresharedKey(sealed string, nodeID NodeID, priv crypto.PrivateKey) (wgtypes.Key, bool) {
+	if priv == nil {
+		return wgtypes.Key{}, false
+	}
+	var boxes map[NodeID]string
+	if err := json.Unmarshal([]byte(sealed), &boxes); err != nil {
+		return wgtypes.Key{}, false
+	}
+	data, err := base64.StdEncoding.DecodeString(boxes[nodeID])
+	if err != nil {
+		return wgtypes.Key{}, false
+	}
+	privkey := [32]byte(priv.WireGuardKey())
+	pubkey := [32]byte(priv.PublicKey().WireGuardKey())
+	key, ok := box.OpenAnonymous(nil, data, &pubkey, &privkey)
+	if !ok || len(key) != wgtypes.KeyLen {
+		return wgtypes.Key{}, false
+	}
+	return wgtypes.Key(key), true
+}
+
+// PresharedKeyFromEdgeAttrs returns the preshared key in the given edge
+// attributes as opened by the given node. False is returned if there is
+// no valid key for the node.
+func PresharedKeyFromEdgeAttrs(attrs map[string]string, nodeID NodeID, priv crypto.PrivateKey) (wgtypes.Key, bool) {
+	sealed, ok := attrs[PresharedKeyEdgeAttribute]
+	if !ok {
+		return wgtypes.Key{}, false
+	}
+	return OpenPresharedKey(sealed, nodeID, priv)
+}
+
+// EdgeAttrsWithPresharedKey returns a copy of the given edge attributes with
+// the preshared key sealed to the given recipients.
+func EdgeAttrsWithPresharedKey(attrs map[string]string, key wgtypes.Key, recipients map[NodeID]crypto.PublicKey) (map[string]string, error) {
+	sealed, err := SealPresharedKey(key, recipients)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(attrs)+1)
+	for k, v := range attrs {
+		out[k] = v
+	}
+	out[PresharedKeyEdgeAttribute] = sealed
+	return out, nil
+}
+
+// PresharedKeyRotationFromEdgeAttrs returns the time the pending preshared
+// key in the given edge attributes becomes active. False is returned if
+// there is no rotation in progress. The pending key itself is not opened.
+func PresharedKeyRotationFromEdgeAttrs(attrs map[string]string) (time.Time, bool) {
+	if _, ok := attrs[PendingPresharedKeyEdgeAttribute]; !ok {
+		return time.Time{}, false
+	}
+	activateAt, err := time.Parse(time.RFC3339Nano, attrs[PresharedKeyActivateAtEdgeAttribute])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return activateAt, true
+}
+
+// PendingPresharedKeyFromEdgeAttrs returns the pending preshared key in the
+// given edge attributes as opened by the given node, and the time it becomes
+// active. False is returned if there is no valid rotation in progress for
+// the node.
+func PendingPresharedKeyFromEdgeAttrs(attrs map[string]string, nodeID NodeID, priv crypto.PrivateKey) (wgtypes.Key, time.Time, bool) {
+	activateAt, ok := PresharedKeyRotationFromEdgeAttrs(attrs)
+	if !ok {
+		return wgtypes.Key{}, time.Time{}, false
+	}
+	key, ok := OpenPresharedKey(attrs[PendingPresharedKeyEdgeAttribute], nodeID, priv)
+	if !ok {
+		return wgtypes.Key{}, time.Time{}, false
+	}
+	return key, activateAt, true
+}
+
+// ActivePresharedKeyFromEdgeAttrs returns the preshared key that should be
+// in use at the given time as opened by the given node. This is the pending
+// key once it has become active, and the current key otherwise.
+func ActivePresharedKeyFromEdgeAttrs(attrs map[string]string, now time.Time, nodeID NodeID, priv crypto.PrivateKey) (wgtypes.Key, bool) {
+	if key, activateAt, ok := PendingPresharedKeyFromEdgeAttrs(attrs, nodeID, priv); ok && !now.Before(activateAt) {
+		return key, true
+	}
+	return PresharedKeyFromEdgeAttrs(attrs, nodeID, priv)
+}
+
+// EdgeAttrsWithPendingPresharedKey returns a copy of the given edge
+// attributes with a rotation at activateAt to the given key, sealed to the
+// given recipients.
+func EdgeAttrsWithPendingPresharedKey(attrs map[string]string, key wgtypes.Key, recipients map[NodeID]crypto.PublicKey, activateAt time.Time) (map[string]string, error) {
+	sealed, err := SealPresharedKey(key, recipients)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(attrs)+2)
+	for k, v := range attrs {
+		out[k] = v
+	}
+	out[PendingPresharedKeyEdgeAttribute] = sealed
+	out[PresharedKeyActivateAtEdgeAttribute] = activateAt.UTC().Format(time.RFC3339Nano)
+	return out, nil
+}
+
+// EdgeAttrsWithCompletedRotation returns a copy of the given edge attributes
+// with an active pending key promoted to the current key, retiring the old
+// one. The key is moved while still sealed. False is returned if there is
+// no rotation to complete at the given time.
+func EdgeAttrsWithCompletedRotation(attrs map[string]string, now time.Time) (map[string]string, bool) {
+	activateAt, ok := PresharedKeyRotationFromEdgeAttrs(attrs)
+	if !ok || now.Before(activateAt) {
+		return attrs, false
+	}
+	out := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+	out[PresharedKeyEdgeAttribute] = attrs[PendingPresharedKeyEdgeAttribute]
+	delete(out, PendingPresharedKeyEdgeAttribute)
+	delete(out, PresharedKeyActivateAtEdgeAttribute)
+	return out, true
+}
+
+// PresharedKeysToHeader returns the header used to send the given preshared
+// keys, keyed by peer ID, to a joining node.
+func PresharedKeysToHeader(keys map[NodeID]wgtypes.Key) metadata.MD {
+	md := metadata.MD{}
+	for id, key := range keys {
+		md.Append(PresharedKeysHeader, id.String()+":"+key.String())
+	}
+	return md
+}
+
+// PresharedKeysFromHeader returns the preshared keys, keyed by peer ID,
+// returned in the header of a join response. Malformed values are ignored.
+func PresharedKeysFromHeader(md metadata.MD) map[NodeID]wgtypes.Key {
+	keys := make(map[NodeID]wgtypes.Key)
+	for _, val := range md.Get(PresharedKeysHeader) {
+		id, encoded, ok := strings.Cut(val, ":")
+		if !ok || !IsValidNodeID(id) {
+			continue
+		}
+		key, err := wgtypes.ParseKey(encoded)
+		if err != nil {
+			continue
+		}
+		keys[NodeID(id)] = key
+	}
+	return keys
+}
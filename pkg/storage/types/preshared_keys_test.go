@@ -0,0 +1,127 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+func TestPresharedKeysHeader(t *testing.T) {
+	t.Parallel()
+
+	keys := make(map[NodeID]wgtypes.Key)
+	for _, id := range []NodeID{"node-a", "node=b"} {
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		keys[id] = key
+	}
+	md := PresharedKeysToHeader(keys)
+	// Malformed values should be ignored.
+	md.Append(PresharedKeysHeader, "node-c", "node-d:not-a-key", ":"+keys["node-a"].String())
+	got := PresharedKeysFromHeader(md)
+	if len(got) != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), len(got))
+	}
+	for id, key := range keys {
+		if got[id] != key {
+			t.Errorf("expected key %s for %s, got %s", key, id, got[id])
+		}
+	}
+
+}
+
+func TestSealedPresharedKeys(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	nodeKeys := map[NodeID]crypto.PrivateKey{
+		"node-a": crypto.MustGenerateKey(),
+		"node-b": crypto.MustGenerateKey(),
+	}
+	recipients := make(map[NodeID]crypto.PublicKey)
+	for id, priv := range nodeKeys {
+		recipients[id] = priv.PublicKey()
+	}
+	attrs, err := EdgeAttrsWithPresharedKey(map[string]string{"other": "attr"}, key, recipients)
+	if err != nil {
+		t.Fatalf("seal preshared key: %v", err)
+	}
+	if strings.Contains(attrs[PresharedKeyEdgeAttribute], key.String()) {
+		t.Error("expected the preshared key to not be stored in plaintext")
+	}
+	for id, priv := range nodeKeys {
+		if got, ok := PresharedKeyFromEdgeAttrs(attrs, id, priv); !ok || got != key {
+			t.Errorf("expected %s to open key %s from edge attributes, got %s", id, key, got)
+		}
+	}
+	if attrs["other"] != "attr" {
+		t.Error("expected other attributes to be kept")
+	}
+	if _, ok := PresharedKeyFromEdgeAttrs(attrs, "node-c", crypto.MustGenerateKey()); ok {
+		t.Error("expected nodes outside the edge to not open the key")
+	}
+	if _, ok := PresharedKeyFromEdgeAttrs(attrs, "node-a", nodeKeys["node-b"]); ok {
+		t.Error("expected the key to not open with the wrong private key")
+	}
+	if _, ok := PresharedKeyFromEdgeAttrs(map[string]string{PresharedKeyEdgeAttribute: "invalid"}, "node-a", nodeKeys["node-a"]); ok {
+		t.Error("expected invalid keys to be ignored")
+	}
+
+	// Pending keys are sealed the same way and moved as is on completion.
+	next, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	activateAt := time.Now().Add(time.Minute)
+	attrs, err = EdgeAttrsWithPendingPresharedKey(attrs, next, recipients, activateAt)
+	if err != nil {
+		t.Fatalf("seal pending preshared key: %v", err)
+	}
+	if strings.Contains(attrs[PendingPresharedKeyEdgeAttribute], next.String()) {
+		t.Error("expected the pending key to not be stored in plaintext")
+	}
+	if got, at, ok := PendingPresharedKeyFromEdgeAttrs(attrs, "node-b", nodeKeys["node-b"]); !ok || got != next || !at.Equal(activateAt) {
+		t.Errorf("expected pending key %s at %s, got %s at %s", next, activateAt, got, at)
+	}
+	if _, _, ok := PendingPresharedKeyFromEdgeAttrs(attrs, "node-c", crypto.MustGenerateKey()); ok {
+		t.Error("expected nodes outside the edge to not open the pending key")
+	}
+	if _, ok := EdgeAttrsWithCompletedRotation(attrs, time.Now()); ok {
+		t.Error("expected the rotation to not complete before it activates")
+	}
+	completed, ok := EdgeAttrsWithCompletedRotation(attrs, activateAt)
+	if !ok {
+		t.Fatal("expected the rotation to complete")
+	}
+	if got, ok := PresharedKeyFromEdgeAttrs(completed, "node-a", nodeKeys["node-a"]); !ok || got != next {
+		t.Errorf("expected the pending key to become the current key, got %s", got)
+	}
+	if _, ok := PresharedKeyRotationFromEdgeAttrs(completed); ok {
+		t.Error("expected the rotation to be removed")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// RBACPolicy is a complete set of RBAC roles, rolebindings, and groups.
+// It is used for importing and exporting RBAC configurations.
+type RBACPolicy struct {
+	// Roles are the roles in the policy.
+	Roles RolesList `json:"roles,omitempty"`
+	// RoleBindings are the rolebindings in the policy.
+	RoleBindings []RoleBinding `json:"roleBindings,omitempty"`
+	// Groups are the groups in the policy.
+	Groups []Group `json:"groups,omitempty"`
+}
+
+type rbacPolicyJSON struct {
+	Roles        []json.RawMessage `json:"roles,omitempty"`
+	RoleBindings []json.RawMessage `json:"roleBindings,omitempty"`
+	Groups       []json.RawMessage `json:"groups,omitempty"`
+}
+
+// MarshalJSON marshals the policy to JSON. Each item is encoded
+// as protobuf JSON.
+func (p RBACPolicy) MarshalJSON() ([]byte, error) {
+	var out rbacPolicyJSON
+	for _, role := range p.Roles {
+		data, err := protojson.Marshal(role.Role)
+		if err != nil {
+			return nil, fmt.Errorf("marshal role %q: %w", role.GetName(), err)
+		}
+		out.Roles = append(out.Roles, data)
+	}
+	for _, rb := range p.RoleBindings {
+		data, err := protojson.Marshal(rb.RoleBinding)
+		if err != nil {
+			return nil, fmt.Errorf("marshal rolebinding %q: %w", rb.GetName(), err)
+		}
+		out.RoleBindings = append(out.RoleBindings, data)
+	}
+	for _, group := range p.Groups {
+		data, err := protojson.Marshal(group.Group)
+		if err != nil {
+			return nil, fmt.Errorf("marshal group %q: %w", group.GetName(), err)
+		}
+		out.Groups = append(out.Groups, data)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON unmarshals the policy from JSON. Each item is decoded
+// as protobuf JSON.
+func (p *RBACPolicy) UnmarshalJSON(data []byte) error {
+	var in rbacPolicyJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	var out RBACPolicy
+	for i, data := range in.Roles {
+		var role v1.Role
+		if err := protojson.Unmarshal(data, &role); err != nil {
+			return fmt.Errorf("unmarshal role %d: %w", i, err)
+		}
+		out.Roles = append(out.Roles, Role{Role: &role})
+	}
+	for i, data := range in.RoleBindings {
+		var rb v1.RoleBinding
+		if err := protojson.Unmarshal(data, &rb); err != nil {
+			return fmt.Errorf("unmarshal rolebinding %d: %w", i, err)
+		}
+		out.RoleBindings = append(out.RoleBindings, RoleBinding{RoleBinding: &rb})
+	}
+	for i, data := range in.Groups {
+		var group v1.Group
+		if err := protojson.Unmarshal(data, &group); err != nil {
+			return fmt.Errorf("unmarshal group %d: %w", i, err)
+		}
+		out.Groups = append(out.Groups, Group{Group: &group})
+	}
+	*p = out
+	return nil
+}
+
+// Validate validates every item in the policy. It does not check that
+// rolebindings and group subjects reference roles and groups that exist.
+func (p RBACPolicy) Validate() error {
+	seen := make(map[string]struct{})
+	for _, role := range p.Roles {
+		if err := role.Validate(); err != nil {
+			return fmt.Errorf("role %q: %w", role.GetName(), err)
+		}
+		if _, ok := seen[role.GetName()]; ok {
+			return fmt.Errorf("duplicate role %q", role.GetName())
+		}
+		seen[role.GetName()] = struct{}{}
+		for _, rule := range role.GetRules() {
+			if err := ValidateRule(rule); err != nil {
+				return fmt.Errorf("role %q: %w", role.GetName(), err)
+			}
+		}
+	}
+	seen = make(map[string]struct{})
+	for _, rb := range p.RoleBindings {
+		if err := rb.Validate(); err != nil {
+			return fmt.Errorf("rolebinding %q: %w", rb.GetName(), err)
+		}
+		if _, ok := seen[rb.GetName()]; ok {
+			return fmt.Errorf("duplicate rolebinding %q", rb.GetName())
+		}
+		seen[rb.GetName()] = struct{}{}
+	}
+	seen = make(map[string]struct{})
+	for _, group := range p.Groups {
+		if err := group.Validate(); err != nil {
+			return fmt.Errorf("group %q: %w", group.GetName(), err)
+		}
+		if _, ok := seen[group.GetName()]; ok {
+			return fmt.Errorf("duplicate group %q", group.GetName())
+		}
+		seen[group.GetName()] = struct{}{}
+	}
+	return nil
+}
+
+// ValidateRule validates that a rule only references known verbs and resources.
+func ValidateRule(rule *v1.Rule) error {
+	if len(rule.GetVerbs()) == 0 {
+		return fmt.Errorf("rule verbs cannot be empty")
+	}
+	if len(rule.GetResources()) == 0 {
+		return fmt.Errorf("rule resources cannot be empty")
+	}
+	for _, verb := range rule.GetVerbs() {
+		if _, ok := v1.RuleVerb_name[int32(verb)]; !ok || verb == v1.RuleVerb_VERB_UNKNOWN {
+			return fmt.Errorf("unknown rule verb %d", verb)
+		}
+	}
+	for _, resource := range rule.GetResources() {
+		if _, ok := v1.RuleResource_name[int32(resource)]; !ok || resource == v1.RuleResource_RESOURCE_UNKNOWN {
+			return fmt.Errorf("unknown rule resource %d", resource)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import "time"
+
+// RecentJoin is the last successful join of a node. It is kept for a short
+// time to detect two machines configured with the same node ID.
+type RecentJoin struct {
+	// ID is the ID of the node that joined.
+	ID NodeID `json:"id"`
+	// PublicKey is the encoded public key the node joined with.
+	PublicKey string `json:"publicKey"`
+	// Source is where the join came from as seen by the server handling it.
+	Source string `json:"source,omitempty"`
+	// JoinedAt is the time of the join.
+	JoinedAt time.Time `json:"joinedAt"`
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// AppliedIndexHeader is the gRPC header used to report the index of the
+	// last log applied to the storage serving a read.
+	AppliedIndexHeader = "x-webmesh-applied-index"
+	// CommitIndexHeader is the gRPC header used to report the last commit
+	// index known to the storage serving a read.
+	CommitIndexHeader = "x-webmesh-commit-index"
+	// StalenessHeader is the gRPC header used to report how long it has been
+	// since the storage serving a read last heard from the leader.
+	StalenessHeader = "x-webmesh-staleness"
+	// MaxStalenessHeader is the gRPC header a client may set to bound the
+	// staleness of the storage serving its reads.
+	MaxStalenessHeader = "x-webmesh-max-staleness"
+)
+
+// ReplicaStatus describes how up to date the local copy of storage is.
+type ReplicaStatus struct {
+	// AppliedIndex is the index of the last log applied to storage.
+	AppliedIndex uint64 `json:"appliedIndex"`
+	// CommitIndex is the last commit index known to the replica.
+	CommitIndex uint64 `json:"commitIndex"`
+	// Staleness is the time since the replica last heard from the leader.
+	// It is zero on the leader.
+	Staleness time.Duration `json:"staleness"`
+}
+
+// Lag returns the number of committed logs that have not yet been applied.
+func (r ReplicaStatus) Lag() uint64 {
+	if r.CommitIndex <= r.AppliedIndex {
+		return 0
+	}
+	return r.CommitIndex - r.AppliedIndex
+}
+
+// Header returns the status encoded as gRPC metadata.
+func (r ReplicaStatus) Header() metadata.MD {
+	return metadata.Pairs(
+		AppliedIndexHeader, strconv.FormatUint(r.AppliedIndex, 10),
+		CommitIndexHeader, strconv.FormatUint(r.CommitIndex, 10),
+		StalenessHeader, r.Staleness.String(),
+	)
+}
+
+// ReplicaStatusFromHeader decodes a replica status from the given gRPC
+// metadata. It returns false if the metadata does not contain a status.
+func ReplicaStatusFromHeader(md metadata.MD) (ReplicaStatus, bool, error) {
+	var status ReplicaStatus
+	applied := md.Get(AppliedIndexHeader)
+	if len(applied) == 0 {
+		return status, false, nil
+	}
+	var err error
+	status.AppliedIndex, err = strconv.ParseUint(applied[0], 10, 64)
+	if err != nil {
+		return status, false, fmt.Errorf("parse applied index: %w", err)
+	}
+	if commit := md.Get(CommitIndexHeader); len(commit) > 0 {
+		status.CommitIndex, err = strconv.ParseUint(commit[0], 10, 64)
+		if err != nil {
+			return status, false, fmt.Errorf("parse commit index: %w", err)
+		}
+	}
+	if staleness := md.Get(StalenessHeader); len(staleness) > 0 {
+		status.Staleness, err = time.ParseDuration(staleness[0])
+		if err != nil {
+			return status, false, fmt.Errorf("parse staleness: %w", err)
+		}
+	}
+	return status, true, nil
+}
+
+// MaxStalenessFromHeader returns the staleness bound requested in the given
+// gRPC metadata. It returns false if no bound was requested.
+func MaxStalenessFromHeader(md metadata.MD) (time.Duration, bool, error) {
+	vals := md.Get(MaxStalenessHeader)
+	if len(vals) == 0 {
+		return 0, false, nil
+	}
+	bound, err := time.ParseDuration(vals[0])
+	if err != nil {
+		return 0, false, fmt.Errorf("parse max staleness: %w", err)
+	}
+	return bound, true, nil
+}
@@ -18,11 +18,51 @@ package types
 
 import (
 	"fmt"
+	"strings"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
+// ResourceNameWildcard is the wildcard character for resource names in rules.
+const ResourceNameWildcard = "*"
+
+// EvalOptions are options for evaluating actions against rules.
+type EvalOptions struct {
+	// AllowWildcardResourceNames is true if resource names in a rule ending in
+	// "*" match by prefix, and a bare "*" grants access to every resource name.
+	// It is disabled by default to guard against overly broad grants.
+	AllowWildcardResourceNames bool
+}
+
+// MatchResourceName reports whether the resource name pattern from a rule matches
+// the given resource name. When AllowWildcardResourceNames is true, a pattern ending
+// in "*" matches any name starting with the preceding prefix, so "us-east/*" matches
+// "us-east/route-a" and "us-east/zone-a/route-b", and a bare "*" matches every name.
+// Otherwise wildcard patterns match nothing. All other patterns must match exactly.
+func (o EvalOptions) MatchResourceName(pattern, name string) bool {
+	prefix, ok := strings.CutSuffix(pattern, ResourceNameWildcard)
+	if !ok {
+		return pattern == name
+	}
+	if !o.AllowWildcardResourceNames {
+		return false
+	}
+	return strings.HasPrefix(name, prefix)
+}
+
+// ValidateResourceName validates a resource name pattern from a rule. The wildcard
+// is only allowed as the final character.
+func ValidateResourceName(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("resource name cannot be empty")
+	}
+	if i := strings.Index(pattern, ResourceNameWildcard); i >= 0 && i != len(pattern)-1 {
+		return fmt.Errorf("resource name %q may only contain a wildcard at the end", pattern)
+	}
+	return nil
+}
+
 // RolesList is a list of roles. It contains methods for evaluating actions against
 // contained permissions.
 type RolesList []Role
@@ -73,34 +113,74 @@ func (n Role) Validate() error {
 	if len(n.GetRules()) == 0 {
 		return fmt.Errorf("role rules cannot be empty")
 	}
+	for _, rule := range n.GetRules() {
+		for _, name := range rule.GetResourceNames() {
+			if err := ValidateResourceName(name); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-// Eval evaluates an action against the roles in the list.
-func (l RolesList) Eval(action *v1.RBACAction) bool {
-	if l == nil {
-		return false
+// RBACResource identifies a resource being accessed for the purpose of
+// enforcing RBAC. An empty name refers to the resource type as a whole.
+type RBACResource struct {
+	// Type is the type of the resource.
+	Type v1.RuleResource
+	// Name is the name of the resource.
+	Name string
+}
+
+// Action returns the RBAC action for performing the given verb on the resource.
+func (r RBACResource) Action(verb v1.RuleVerb) *v1.RBACAction {
+	return &v1.RBACAction{
+		Verb:         verb,
+		Resource:     r.Type,
+		ResourceName: r.Name,
 	}
-	for _, role := range l {
-		if EvalRole(role, action) {
+}
+
+// Eval evaluates an action against the roles in the list with the default
+// evaluation options.
+func (l RolesList) Eval(action *v1.RBACAction) bool {
+	return EvalOptions{}.Eval(l, action)
+}
+
+// Eval evaluates an action against the roles in the list.
+func (o EvalOptions) Eval(roles RolesList, action *v1.RBACAction) bool {
+	for _, role := range roles {
+		if o.EvalRole(role, action) {
 			return true
 		}
 	}
 	return false
 }
 
-// EvalRole evaluates an action against a single role.
+// EvalRole evaluates an action against a single role with the default
+// evaluation options.
 func EvalRole(role Role, action *v1.RBACAction) bool {
+	return EvalOptions{}.EvalRole(role, action)
+}
+
+// EvalRole evaluates an action against a single role.
+func (o EvalOptions) EvalRole(role Role, action *v1.RBACAction) bool {
 	for _, p := range role.GetRules() {
-		if EvalRule(p, action) {
+		if o.EvalRule(p, action) {
 			return true
 		}
 	}
 	return false
 }
 
-// EvalRule evaluates an action against a single rule.
+// EvalRule evaluates an action against a single rule with the default
+// evaluation options.
 func EvalRule(rule *v1.Rule, action *v1.RBACAction) bool {
+	return EvalOptions{}.EvalRule(rule, action)
+}
+
+// EvalRule evaluates an action against a single rule.
+func (o EvalOptions) EvalRule(rule *v1.Rule, action *v1.RBACAction) bool {
 	var verbMatch bool
 	for _, verb := range rule.GetVerbs() {
 		if verb == action.GetVerb() || verb == v1.RuleVerb_VERB_ALL {
@@ -129,7 +209,7 @@ func EvalRule(rule *v1.Rule, action *v1.RBACAction) bool {
 		return true
 	}
 	for _, resourceName := range rule.GetResourceNames() {
-		if resourceName == action.GetResourceName() {
+		if o.MatchResourceName(resourceName, action.GetResourceName()) {
 			return true
 		}
 	}
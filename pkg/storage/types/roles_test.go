@@ -224,3 +224,66 @@ func TestRolesListEval(t *testing.T) {
 		})
 	}
 }
+
+func TestEvalRuleResourceNames(t *testing.T) {
+	rule := &v1.Rule{
+		Verbs:         []v1.RuleVerb{v1.RuleVerb_VERB_ALL},
+		Resources:     []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+		ResourceNames: []string{"us-east/*", "global-route"},
+	}
+	wildcardRule := &v1.Rule{
+		Verbs:         []v1.RuleVerb{v1.RuleVerb_VERB_ALL},
+		Resources:     []v1.RuleResource{v1.RuleResource_RESOURCE_ROUTES},
+		ResourceNames: []string{"*"},
+	}
+	tc := []struct {
+		name           string
+		rule           *v1.Rule
+		resourceName   string
+		allowWildcards bool
+		want           bool
+	}{
+		{name: "exact match", rule: rule, resourceName: "global-route", want: true},
+		{name: "exact mismatch", rule: rule, resourceName: "global-route-2", want: false},
+		{name: "prefix denied by default", rule: rule, resourceName: "us-east/route-a", want: false},
+		{name: "prefix match", rule: rule, resourceName: "us-east/route-a", allowWildcards: true, want: true},
+		{name: "nested prefix match", rule: rule, resourceName: "us-east/zone-a/route-a", allowWildcards: true, want: true},
+		{name: "prefix does not match parent", rule: rule, resourceName: "us-east", allowWildcards: true, want: false},
+		{name: "prefix does not match sibling", rule: rule, resourceName: "us-west/route-a", allowWildcards: true, want: false},
+		{name: "bare wildcard denied by default", rule: wildcardRule, resourceName: "us-east/route-a", want: false},
+		{name: "bare wildcard allowed when enabled", rule: wildcardRule, resourceName: "us-east/route-a", allowWildcards: true, want: true},
+		{name: "exact unaffected by wildcard setting", rule: rule, resourceName: "other", allowWildcards: true, want: false},
+		{name: "literal wildcard name denied by default", rule: wildcardRule, resourceName: "*", want: false},
+	}
+	for _, tt := range tc {
+		opts := EvalOptions{AllowWildcardResourceNames: tt.allowWildcards}
+		action := &v1.RBACAction{
+			Verb:         v1.RuleVerb_VERB_PUT,
+			Resource:     v1.RuleResource_RESOURCE_ROUTES,
+			ResourceName: tt.resourceName,
+		}
+		if got := opts.EvalRule(tt.rule, action); got != tt.want {
+			t.Errorf("%s: EvalRule(%q) = %v, want %v", tt.name, tt.resourceName, got, tt.want)
+		}
+	}
+}
+
+func TestValidateResourceName(t *testing.T) {
+	t.Parallel()
+
+	tc := map[string]bool{
+		"route":       true,
+		"us-east/*":   true,
+		"us-east*":    true,
+		"*":           true,
+		"":            false,
+		"us-*/routes": false,
+		"**":          false,
+	}
+	for name, valid := range tc {
+		err := ValidateResourceName(name)
+		if (err == nil) != valid {
+			t.Errorf("ValidateResourceName(%q) error = %v, want valid %v", name, err, valid)
+		}
+	}
+}
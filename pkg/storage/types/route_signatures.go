@@ -0,0 +1,127 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+// routeSignatureDomain is prepended to the payload of route signatures so
+// they cannot be mistaken for signatures over other data.
+const routeSignatureDomain = "webmesh-route-v2:"
+
+// routeSignatureVersionSize is the size of the version that prefixes the
+// ed25519 signature of a route.
+const routeSignatureVersionSize = 8
+
+// IsSigned reports if the route carries a signature.
+func (r Route) IsSigned() bool {
+	return len(r.Signature) > 0
+}
+
+// SignatureVersion returns the version the route was signed at, or zero if
+// the route is unsigned or its signature is malformed. Storage only accepts
+// a signed route whose version is newer than the last one it stored under
+// the same name, so an old signature cannot be replayed.
+func (r Route) SignatureVersion() uint64 {
+	if len(r.Signature) != routeSignatureVersionSize+ed25519.SignatureSize {
+		return 0
+	}
+	return binary.BigEndian.Uint64(r.Signature[:routeSignatureVersionSize])
+}
+
+// SignaturePayload returns the bytes covered by a signature of the route at
+// the given version. It includes every field that affects how the route is
+// consumed and excludes the signature itself.
+func (r Route) SignaturePayload(version uint64) ([]byte, error) {
+	payload := struct {
+		Version          uint64   `json:"version"`
+		Name             string   `json:"name"`
+		Node             string   `json:"node"`
+		NextHopNode      string   `json:"nextHopNode"`
+		DestinationCIDRs []string `json:"destinationCIDRs"`
+		Metric           uint32   `json:"metric"`
+		ExcludedCIDRs    []string `json:"excludedCIDRs"`
+	}{
+		Version:          version,
+		Name:             r.GetName(),
+		Node:             r.GetNode(),
+		NextHopNode:      r.GetNextHopNode(),
+		DestinationCIDRs: r.GetDestinationCIDRs(),
+		Metric:           r.Metric,
+		ExcludedCIDRs:    r.ExcludedCIDRs,
+	}
+	if len(payload.ExcludedCIDRs) == 0 {
+		// Empty and unset exclusions are stored the same way.
+		payload.ExcludedCIDRs = nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(routeSignatureDomain), data...), nil
+}
+
+// Sign signs the route with the given key, which should be the identity key
+// of the node advertising the route. Any previous signature is replaced. The
+// route is signed at the current time in nanoseconds, or just past the
+// version of the previous signature if the clock is behind it.
+func (r *Route) Sign(key crypto.PrivateKey) error {
+	version := uint64(time.Now().UnixNano())
+	if prev := r.SignatureVersion(); version <= prev {
+		version = prev + 1
+	}
+	return r.SignWithVersion(key, version)
+}
+
+// SignWithVersion signs the route with the given key at the given version.
+// The signature is the big-endian version followed by the ed25519 signature
+// over SignaturePayload.
+func (r *Route) SignWithVersion(key crypto.PrivateKey, version uint64) error {
+	payload, err := r.SignaturePayload(version)
+	if err != nil {
+		return err
+	}
+	sig := binary.BigEndian.AppendUint64(make([]byte, 0, routeSignatureVersionSize+ed25519.SignatureSize), version)
+	r.Signature = append(sig, ed25519.Sign(key.AsNative(), payload)...)
+	return nil
+}
+
+// VerifySignature verifies that the route was signed by the given key.
+// An error is returned if the route is unsigned.
+func (r Route) VerifySignature(key crypto.PublicKey) error {
+	if !r.IsSigned() {
+		return errors.New("route is not signed")
+	}
+	if len(r.Signature) != routeSignatureVersionSize+ed25519.SignatureSize {
+		return errors.New("route signature is malformed")
+	}
+	payload, err := r.SignaturePayload(r.SignatureVersion())
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key.AsNative(), payload, r.Signature[routeSignatureVersionSize:]) {
+		return errors.New("route signature does not match")
+	}
+	return nil
+}
@@ -17,10 +17,14 @@ limitations under the License.
 package types
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/netip"
+	"slices"
 	"sort"
+	"strings"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -47,6 +51,48 @@ func ToPrefixes(ss []string) []netip.Prefix {
 	return out
 }
 
+// AutoRouteSuffix is appended to a node ID to name the route holding the
+// routes the node advertises itself.
+const AutoRouteSuffix = "-auto"
+
+// AutoRouteName returns the name of the route holding the routes advertised
+// by the given node.
+func AutoRouteName(nodeID NodeID) string {
+	return nodeID.String() + AutoRouteSuffix
+}
+
+// ExitRouteSuffix is appended to a node ID to name the route it advertises
+// when acting as an exit node.
+const ExitRouteSuffix = "-exit"
+
+// ExitRouteName returns the name of the route advertised by the given node
+// when acting as an exit node.
+func ExitRouteName(nodeID NodeID) string {
+	return nodeID.String() + ExitRouteSuffix
+}
+
+// IsDefaultRoute reports if the prefix is an IPv4 or IPv6 default route.
+func IsDefaultRoute(prefix netip.Prefix) bool {
+	return prefix.Bits() == 0 && prefix.Addr().IsUnspecified()
+}
+
+// NewExitRoute returns the route advertised by the given node when acting as
+// an exit node. Default routes are only included for the enabled address families.
+func NewExitRoute(nodeID NodeID, ipv4, ipv6 bool) Route {
+	var cidrs []string
+	if ipv4 {
+		cidrs = append(cidrs, "0.0.0.0/0")
+	}
+	if ipv6 {
+		cidrs = append(cidrs, "::/0")
+	}
+	return Route{Route: &v1.Route{
+		Name:             ExitRouteName(nodeID),
+		Node:             nodeID.String(),
+		DestinationCIDRs: cidrs,
+	}}
+}
+
 // ValidateRoute validates a Route.
 func ValidateRoute(route Route) error {
 	if route.GetName() == "" {
@@ -74,6 +120,11 @@ func ValidateRoute(route Route) error {
 			return fmt.Errorf("parse prefix %q: %w", cidr, err)
 		}
 	}
+	for _, cidr := range route.ExcludedCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("parse excluded prefix %q: %w", cidr, err)
+		}
+	}
 	return nil
 }
 
@@ -111,11 +162,26 @@ func (a Routes) Proto() []*v1.Route {
 // Route wraps a Route.
 type Route struct {
 	*v1.Route `json:",inline"`
+	// Metric is the preference of the route when multiple nodes advertise
+	// overlapping destinations. Lower metrics are preferred.
+	Metric uint32 `json:"metric,omitempty"`
+	// ExcludedCIDRs are ranges within the destination CIDRs that must not
+	// be routed through the node.
+	ExcludedCIDRs []string `json:"excludedCIDRs,omitempty"`
+	// Signature is an optional signature over the route by the identity
+	// key of the node advertising it, prefixed by the version it was signed
+	// at. See Sign.
+	Signature []byte `json:"signature,omitempty"`
 }
 
 // DeepCopy returns a deep copy of the route.
 func (n Route) DeepCopy() Route {
-	return Route{Route: n.Route.DeepCopy()}
+	return Route{
+		Route:         n.Route.DeepCopy(),
+		Metric:        n.Metric,
+		ExcludedCIDRs: slices.Clone(n.ExcludedCIDRs),
+		Signature:     slices.Clone(n.Signature),
+	}
 }
 
 // DeepCopyInto copies the node into the given route.
@@ -128,19 +194,75 @@ func (r *Route) Proto() *v1.Route {
 	return r.Route
 }
 
-// MarshalProtoJSON marshals the route to protobuf json.
+// MarshalProtoJSON marshals the route to protobuf json. The excluded CIDRs
+// and signature are not part of the protobuf and are added as extra fields
+// when set. The metric is only kept in the route metrics registry.
 func (r Route) MarshalProtoJSON() ([]byte, error) {
-	return protojson.Marshal(r.Route)
+	data, err := protojson.Marshal(r.Route)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	if len(r.ExcludedCIDRs) > 0 {
+		excluded, err := json.Marshal(r.ExcludedCIDRs)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, `"excludedCIDRs":`+string(excluded))
+	}
+	if len(r.Signature) > 0 {
+		sig, err := json.Marshal(r.Signature)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, `"signature":`+string(sig))
+	}
+	if len(fields) == 0 {
+		return data, nil
+	}
+	field := strings.Join(fields, ",")
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("{}")) {
+		return []byte("{" + field + "}"), nil
+	}
+	return append([]byte("{"+field+","), data[1:]...), nil
 }
 
 // UnmarshalProtoJSON unmarshals the route from a protobuf.
 func (r *Route) UnmarshalProtoJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return fmt.Errorf("unmarshal route: %w", err)
+	}
+	var extra struct {
+		ExcludedCIDRs []string `json:"excludedCIDRs"`
+		Signature     []byte   `json:"signature"`
+	}
+	err = json.Unmarshal(data, &extra)
+	if err != nil {
+		return fmt.Errorf("unmarshal route: %w", err)
+	}
+	_, excluded := fields["excludedCIDRs"]
+	_, signed := fields["signature"]
+	if excluded || signed {
+		// Remove the extra fields so any other unknown field is still
+		// rejected by the protobuf decoder.
+		delete(fields, "excludedCIDRs")
+		delete(fields, "signature")
+		data, err = json.Marshal(fields)
+		if err != nil {
+			return fmt.Errorf("unmarshal route: %w", err)
+		}
+	}
 	var rt v1.Route
-	err := protojson.Unmarshal(data, &rt)
+	err = protojson.Unmarshal(data, &rt)
 	if err != nil {
 		return fmt.Errorf("unmarshal route: %w", err)
 	}
 	r.Route = &rt
+	r.ExcludedCIDRs = extra.ExcludedCIDRs
+	r.Signature = extra.Signature
 	return nil
 }
 
@@ -149,6 +271,13 @@ func (r Route) Validate() error {
 	return ValidateRoute(r)
 }
 
+// IsExitRoute reports if the route was advertised by its node acting as an
+// exit node. Exit routes are only used by nodes that explicitly select the
+// advertising node as their exit node.
+func (r Route) IsExitRoute() bool {
+	return r.GetNode() != "" && r.GetName() == ExitRouteName(NodeID(r.GetNode()))
+}
+
 // Equals returns whether the routes are equal.
 func (r *Route) Equals(other *Route) bool {
 	if r.GetName() != other.GetName() {
@@ -160,6 +289,15 @@ func (r *Route) Equals(other *Route) bool {
 	if r.GetNextHopNode() != other.GetNextHopNode() {
 		return false
 	}
+	if r.Metric != other.Metric {
+		return false
+	}
+	if !slices.Equal(r.ExcludedCIDRs, other.ExcludedCIDRs) {
+		return false
+	}
+	if !bytes.Equal(r.Signature, other.Signature) {
+		return false
+	}
 	if len(r.GetDestinationCIDRs()) != len(other.GetDestinationCIDRs()) {
 		return false
 	}
@@ -175,3 +313,8 @@ func (r *Route) Equals(other *Route) bool {
 func (r *Route) DestinationPrefixes() []netip.Prefix {
 	return ToPrefixes(r.GetDestinationCIDRs())
 }
+
+// ExcludedPrefixes returns the prefixes excluded from the destinations of the route.
+func (r *Route) ExcludedPrefixes() []netip.Prefix {
+	return ToPrefixes(r.ExcludedCIDRs)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+func TestRouteProtoJSON(t *testing.T) {
+	t.Parallel()
+
+	tc := []struct {
+		name  string
+		route Route
+	}{
+		{
+			name: "plain route",
+			route: Route{Route: &v1.Route{
+				Name:             "route",
+				Node:             "node-a",
+				DestinationCIDRs: []string{"10.0.0.0/8"},
+			}},
+		},
+		{
+			name:  "empty route",
+			route: Route{Route: &v1.Route{}},
+		},
+		{
+			name: "with exclusions",
+			route: Route{
+				Route: &v1.Route{
+					Name:             "route",
+					Node:             "node-a",
+					DestinationCIDRs: []string{"10.0.0.0/8"},
+				},
+				ExcludedCIDRs: []string{"10.5.0.0/16", "10.6.0.0/16"},
+			},
+		},
+		{
+			name: "with signature",
+			route: Route{
+				Route: &v1.Route{
+					Name:             "route",
+					Node:             "node-a",
+					DestinationCIDRs: []string{"10.0.0.0/8"},
+				},
+				Signature: []byte("signature"),
+			},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := tt.route.MarshalProtoJSON()
+			if err != nil {
+				t.Fatalf("MarshalProtoJSON() error = %v", err)
+			}
+			var got Route
+			if err := got.UnmarshalProtoJSON(data); err != nil {
+				t.Fatalf("UnmarshalProtoJSON(%s) error = %v", data, err)
+			}
+			if !got.Equals(&tt.route) {
+				t.Errorf("got route %v (metric %d), want %v (metric %d)", got.Route, got.Metric, tt.route.Route, tt.route.Metric)
+			}
+		})
+	}
+}
+
+func TestRouteProtoJSONOmitsMetric(t *testing.T) {
+	t.Parallel()
+	route := Route{
+		Route: &v1.Route{
+			Name:             "route",
+			Node:             "node-a",
+			DestinationCIDRs: []string{"10.0.0.0/8"},
+		},
+		Metric: 100,
+	}
+	data, err := route.MarshalProtoJSON()
+	if err != nil {
+		t.Fatalf("MarshalProtoJSON() error = %v", err)
+	}
+	var got Route
+	if err := got.UnmarshalProtoJSON(data); err != nil {
+		t.Fatalf("UnmarshalProtoJSON(%s) error = %v", data, err)
+	}
+	if got.Metric != 0 {
+		t.Errorf("expected the metric to be left to the metrics registry, got %d", got.Metric)
+	}
+	if err := got.UnmarshalProtoJSON([]byte(`{"name":"route","metric":100}`)); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+}
+
+func TestRouteSignature(t *testing.T) {
+	t.Parallel()
+	key := crypto.MustGenerateKey()
+	other := crypto.MustGenerateKey()
+	newRoute := func() Route {
+		return Route{
+			Route: &v1.Route{
+				Name:             "route",
+				Node:             "node-a",
+				DestinationCIDRs: []string{"10.0.0.0/8"},
+			},
+			Metric:        5,
+			ExcludedCIDRs: []string{"10.5.0.0/16"},
+		}
+	}
+
+	route := newRoute()
+	if err := route.VerifySignature(key.PublicKey()); err == nil {
+		t.Fatal("expected unsigned route to fail verification")
+	}
+	if err := route.Sign(key); err != nil {
+		t.Fatalf("sign route: %v", err)
+	}
+	if !route.IsSigned() {
+		t.Fatal("expected route to be signed")
+	}
+	if err := route.VerifySignature(key.PublicKey()); err != nil {
+		t.Fatalf("expected signed route to verify, got %v", err)
+	}
+	if err := route.VerifySignature(other.PublicKey()); err == nil {
+		t.Fatal("expected route to fail verification with another key")
+	}
+
+	// Signing again moves the version forward even if the clock is behind
+	// the previous signature.
+	resigned := route.DeepCopy()
+	future := uint64(time.Now().Add(time.Hour).UnixNano())
+	if err := resigned.SignWithVersion(key, future); err != nil {
+		t.Fatalf("sign route: %v", err)
+	}
+	if err := resigned.Sign(key); err != nil {
+		t.Fatalf("sign route: %v", err)
+	}
+	if got := resigned.SignatureVersion(); got != future+1 {
+		t.Fatalf("expected signature version %d, got %d", future+1, got)
+	}
+
+	// Changing any signed field invalidates the signature.
+	for name, tamper := range map[string]func(*Route){
+		"destinations": func(r *Route) { r.DestinationCIDRs = []string{"0.0.0.0/0"} },
+		"node":         func(r *Route) { r.Node = "node-b" },
+		"next hop":     func(r *Route) { r.NextHopNode = "node-b" },
+		"metric":       func(r *Route) { r.Metric = 0 },
+		"exclusions":   func(r *Route) { r.ExcludedCIDRs = nil },
+		"version":      func(r *Route) { r.Signature[7]++ },
+	} {
+		tampered := route.DeepCopy()
+		tamper(&tampered)
+		if err := tampered.VerifySignature(key.PublicKey()); err == nil {
+			t.Errorf("expected route with tampered %s to fail verification", name)
+		}
+	}
+}
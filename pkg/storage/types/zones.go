@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ZoneSeparator separates the zones stored in a node's zone awareness ID.
+// A node with a single zone is stored exactly as it always has been.
+const ZoneSeparator = ","
+
+// ParseZones splits a zone awareness ID into the zones it contains.
+// Labels stored in the ID are skipped.
+func ParseZones(zoneID string) []string {
+	if zoneID == "" {
+		return nil
+	}
+	var zones []string
+	for _, zone := range strings.Split(zoneID, ZoneSeparator) {
+		zone = strings.TrimSpace(zone)
+		if strings.Contains(zone, LabelSeparator) {
+			continue
+		}
+		if zone != "" && !slices.Contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// JoinZones validates the given zones and joins them into a zone awareness ID.
+// Duplicates are removed and the zones are sorted so that equal sets always
+// produce the same ID.
+func JoinZones(zones []string) (string, error) {
+	out := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		if !IsValidID(zone) {
+			return "", fmt.Errorf("invalid zone %q", zone)
+		}
+		if !slices.Contains(out, zone) {
+			out = append(out, zone)
+		}
+	}
+	slices.Sort(out)
+	return strings.Join(out, ZoneSeparator), nil
+}
+
+// ZonesOverlap returns true if the two sets of zones share at least one zone.
+func ZonesOverlap(a, b []string) bool {
+	for _, zone := range a {
+		if slices.Contains(b, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// Zones returns the zones the node is available in.
+func (n MeshNode) Zones() []string {
+	return ParseZones(n.GetZoneAwarenessID())
+}
+
+// InZone returns true if the node is available in the given zone.
+func (n MeshNode) InZone(zone string) bool {
+	return slices.Contains(n.Zones(), zone)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// ExemplarTraceIDLabel is the exemplar label holding the trace ID.
+const ExemplarTraceIDLabel = "trace_id"
+
+// ExemplarSpanIDLabel is the exemplar label holding the span ID.
+const ExemplarSpanIDLabel = "span_id"
+
+// Exemplar returns the exemplar labels linking an observation to the
+// sampled span in the given context, or nil if there is none.
+func Exemplar(ctx context.Context) prometheus.Labels {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{
+		ExemplarTraceIDLabel: sc.TraceID().String(),
+		ExemplarSpanIDLabel:  sc.SpanID().String(),
+	}
+}
+
+// Observe records the given value on the observer. An exemplar linking the
+// observation to the sampled span in the context is attached when there is
+// one and the observer supports exemplars.
+func Observe(ctx context.Context, obs prometheus.Observer, value float64) {
+	if exemplar := Exemplar(ctx); exemplar != nil {
+		if eo, ok := obs.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(value, exemplar)
+			return
+		}
+	}
+	obs.Observe(value)
+}
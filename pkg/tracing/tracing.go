@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing provides OpenTelemetry tracing for webmesh components.
+package tracing
+
+import (
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// TracerName is the name of the tracer used for all webmesh spans.
+const TracerName = "github.com/webmeshproj/webmesh"
+
+var tracerProvider atomic.Value
+
+func init() {
+	SetTracerProvider(trace.NewNoopTracerProvider())
+}
+
+// SetTracerProvider sets the tracer provider used for webmesh spans. The
+// default provider is a no-op.
+func SetTracerProvider(tp trace.TracerProvider) {
+	tracerProvider.Store(&tp)
+}
+
+// TracerProvider returns the tracer provider used for webmesh spans.
+func TracerProvider() trace.TracerProvider {
+	return *tracerProvider.Load().(*trace.TracerProvider)
+}
+
+// Start starts a new span with the given name as a child of any span in
+// the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return TracerProvider().Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the given error, if any, on the span and ends it. It is
+// intended to be deferred with a pointer to a named error return.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// Inject returns the W3C trace context for the span in the given context,
+// or an empty string if there is no span being recorded.
+func Inject(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Extract returns a context with the remote span described by the given
+// W3C trace context. The context is returned unchanged if the value is empty
+// or invalid.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}